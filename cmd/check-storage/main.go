@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/oneErrortime/afst/cmd/internal/storageconfig"
+	"github.com/oneErrortime/afst/internal/config"
+	"github.com/oneErrortime/afst/internal/repository"
+	"github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/oneErrortime/afst/internal/storage"
+)
+
+// check-storage перечитывает все учтённые файлы книг, пересчитывает SHA-256
+// и сообщает об отсутствующих и повреждённых файлах. Код выхода 1 — найдены
+// проблемы. С -fix-refs сначала пересчитывает счётчики ссылок по book_files.
+func main() {
+	fixRefs := flag.Bool("fix-refs", false, "пересчитать счётчики ссылок на файлы перед проверкой")
+	verbose := flag.Bool("v", false, "печатать каждый проверенный файл")
+	flag.Usage = func() {
+		fmt.Println("Использование: go run ./cmd/check-storage [-fix-refs] [-v]")
+		fmt.Println("Проверяется хранилище из STORAGE_BACKEND")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Ошибка загрузки конфигурации:", err)
+	}
+
+	db, err := repository.NewDatabase(&cfg.Database)
+	if err != nil {
+		log.Fatal("Ошибка подключения к базе данных:", err)
+	}
+	repos := gorm.NewExtendedRepository(db)
+
+	if *fixRefs {
+		fixed, err := repos.Blob.Reconcile()
+		if err != nil {
+			log.Fatal("Ошибка пересчёта ссылок:", err)
+		}
+		fmt.Printf("Исправлено счётчиков ссылок: %d\n", fixed)
+	}
+
+	expected, err := repos.Blob.ListHashes()
+	if err != nil {
+		log.Fatal("Ошибка чтения списка файлов:", err)
+	}
+
+	fileStorage, err := storage.New(storageconfig.Build(cfg.Storage))
+	if err != nil {
+		log.Fatal("Ошибка открытия хранилища:", err)
+	}
+
+	report := storage.Verify(fileStorage, expected, func(filePath string, err error) {
+		if *verbose && err == nil {
+			fmt.Printf("OK %s\n", filePath)
+		}
+	})
+
+	var missing, corrupt, unreadable []string
+	for p, err := range report.Failed {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			missing = append(missing, p)
+		case errors.Is(err, storage.ErrHashMismatch):
+			corrupt = append(corrupt, p)
+		default:
+			unreadable = append(unreadable, p)
+		}
+	}
+
+	fmt.Printf("Проверено: %d, отсутствует: %d, повреждено: %d, ошибок чтения: %d\n",
+		report.Checked, len(missing), len(corrupt), len(unreadable))
+	printPaths("Отсутствуют", missing, report.Failed)
+	printPaths("Повреждены", corrupt, report.Failed)
+	printPaths("Не читаются", unreadable, report.Failed)
+	if len(report.Failed) > 0 {
+		os.Exit(1)
+	}
+}
+
+func printPaths(title string, paths []string, failed map[string]error) {
+	if len(paths) == 0 {
+		return
+	}
+	sort.Strings(paths)
+	fmt.Println(title + ":")
+	for _, p := range paths {
+		fmt.Printf("  %s: %v\n", p, failed[p])
+	}
+}
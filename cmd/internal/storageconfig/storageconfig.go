@@ -0,0 +1,27 @@
+// Package storageconfig turns the storage settings read from the environment
+// into storage backend options for the commands that open the file storage
+package storageconfig
+
+import (
+	"github.com/oneErrortime/afst/internal/config"
+	"github.com/oneErrortime/afst/internal/storage"
+)
+
+// Build returns the options for storage.New and storage.Open
+func Build(cfg config.StorageConfig) storage.Config {
+	return storage.Config{
+		Backend:   cfg.Backend,
+		LocalPath: cfg.LocalPath,
+		BaseURL:   cfg.BaseURL,
+		S3: storage.S3Config{
+			Endpoint:      cfg.S3.Endpoint,
+			Region:        cfg.S3.Region,
+			Bucket:        cfg.S3.Bucket,
+			AccessKey:     cfg.S3.AccessKey,
+			SecretKey:     cfg.S3.SecretKey,
+			PathStyle:     cfg.S3.PathStyle,
+			PartSize:      cfg.S3.PartSize,
+			PresignExpiry: cfg.S3.PresignExpiry,
+		},
+	}
+}
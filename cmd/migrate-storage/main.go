@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/oneErrortime/afst/cmd/internal/storageconfig"
+	"github.com/oneErrortime/afst/internal/config"
+	"github.com/oneErrortime/afst/internal/repository"
+	"github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/oneErrortime/afst/internal/storage"
+)
+
+// migrate-storage копирует все файлы из одного хранилища в другое и сверяет
+// SHA-256 копий с исходником и с хешами файлов книг в базе. Повторный запуск
+// пропускает уже перенесённые файлы. После переноса переключите STORAGE_BACKEND.
+func main() {
+	from := flag.String("from", storage.BackendLocal, "исходное хранилище: local или s3")
+	to := flag.String("to", storage.BackendS3, "целевое хранилище: local или s3")
+	dryRun := flag.Bool("dry-run", false, "только проверить хеши исходных файлов, ничего не копировать")
+	flag.Usage = func() {
+		fmt.Println("Использование: go run ./cmd/migrate-storage -from local -to s3 [-dry-run]")
+		fmt.Println("Настройки обоих хранилищ берутся из FILE_STORAGE_PATH и S3_*")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *from == *to {
+		log.Fatal("Исходное и целевое хранилище совпадают")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Ошибка загрузки конфигурации:", err)
+	}
+
+	db, err := repository.NewDatabase(&cfg.Database)
+	if err != nil {
+		log.Fatal("Ошибка подключения к базе данных:", err)
+	}
+	repos := gorm.NewExtendedRepository(db)
+
+	expected, err := repos.BookFile.ListHashes()
+	if err != nil {
+		log.Fatal("Ошибка чтения хешей файлов:", err)
+	}
+
+	src, err := storage.Open(*from, storageconfig.Build(cfg.Storage))
+	if err != nil {
+		log.Fatal("Ошибка открытия исходного хранилища:", err)
+	}
+	dst, err := storage.Open(*to, storageconfig.Build(cfg.Storage))
+	if err != nil {
+		log.Fatal("Ошибка открытия целевого хранилища:", err)
+	}
+
+	report, err := storage.Migrate(src, dst, storage.MigrateOptions{
+		Expected: expected,
+		DryRun:   *dryRun,
+		Progress: func(filePath string, err error) {
+			if err != nil {
+				log.Printf("ОШИБКА %s: %v", filePath, err)
+			}
+		},
+	})
+	if err != nil {
+		log.Fatal("Ошибка обхода исходного хранилища:", err)
+	}
+
+	fmt.Printf("Скопировано: %d (%d байт), пропущено: %d, ошибок: %d\n",
+		report.Copied, report.Bytes, report.Skipped, len(report.Failed))
+	if len(report.Failed) > 0 {
+		paths := make([]string, 0, len(report.Failed))
+		for p := range report.Failed {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			fmt.Printf("  %s: %v\n", p, report.Failed[p])
+		}
+		os.Exit(1)
+	}
+}
@@ -12,12 +12,16 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/oneErrortime/afst/cmd/internal/storageconfig"
 	"github.com/oneErrortime/afst/internal/auth"
 	"github.com/oneErrortime/afst/internal/config"
 	"github.com/oneErrortime/afst/internal/events"
 	"github.com/oneErrortime/afst/internal/handlers"
+	"github.com/oneErrortime/afst/internal/models"
 	"github.com/oneErrortime/afst/internal/repository"
 	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/oneErrortime/afst/internal/scanner"
+	"github.com/oneErrortime/afst/internal/scheduler"
 	"github.com/oneErrortime/afst/internal/services"
 	"github.com/oneErrortime/afst/internal/storage"
 	"github.com/oneErrortime/afst/internal/worker"
@@ -50,15 +54,10 @@ func main() {
 
 	jwtService := auth.NewJWTService(cfg.JWT.Secret, cfg.JWT.ExpiresIn)
 
-	storagePath := os.Getenv("FILE_STORAGE_PATH")
-	if storagePath == "" {
-		storagePath = "./uploads"
-	}
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == "" {
-		baseURL = fmt.Sprintf("http://localhost:%s", cfg.Port)
+	fileStorage, err := storage.New(storageconfig.Build(cfg.Storage))
+	if err != nil {
+		log.Fatal("Ошибка инициализации файлового хранилища:", err)
 	}
-	fileStorage := storage.NewLocalStorage(storagePath, baseURL)
 
 	// ── Event bus ──────────────────────────────────────────────────────────────
 	bus := events.Default()
@@ -69,13 +68,99 @@ func main() {
 		log.Printf("Warning: NATS connection failed (%v) — continuing without NATS", err)
 	}
 
-	// ── Worker pool ────────────────────────────────────────────────────────────
-	// 4 concurrent workers, queue depth 256, up to 3 retries per job
-	pool := worker.NewPool("file-processor", 4, 256, 3)
+	// ── Workers ────────────────────────────────────────────────────────────────
+	// File processing goes through the persistent job queue: 4 workers,
+	// up to 5 attempts, jobs of a crashed instance are re-picked after the lease
+	fileQueue := worker.NewQueue("file-processor", repos.Job, worker.QueueConfig{
+		Workers:     4,
+		MaxAttempts: 5,
+		Lease:       time.Minute,
+	})
+	// Webhook deliveries: 2 workers, the first attempt plus up to 5 retries;
+	// retries wait in the jobs table, so they survive restarts
+	webhookQueue := worker.NewQueue("webhooks", repos.Job, worker.QueueConfig{
+		Workers:     2,
+		MaxAttempts: 6,
+		Lease:       time.Minute,
+	})
+	// Cover thumbnails: CPU-bound, 2 workers, one retry for storage hiccups
+	coverPool := worker.NewPool("covers", 2, 128, 1)
+
+	// Recurring maintenance; each tick runs on one instance only (DB leases)
+	sched := scheduler.New(repos.Scheduler)
 
 	// ── Services ───────────────────────────────────────────────────────────────
-	svc := services.NewExtendedServicesWithWorkers(repos, jwtService, fileStorage, bus, pool)
+	// Resumable (tus) uploads keep their chunks on local disk until complete
+	uploads := services.UploadOptions{
+		Partials: storage.NewPartialStore(cfg.Uploads.PartialPath),
+		Limits: services.UploadLimits{
+			MaxSize: map[models.UserRole]int64{
+				models.RoleLibrarian: cfg.Uploads.MaxSizeLibrarian,
+				models.RoleAdmin:     cfg.Uploads.MaxSizeAdmin,
+			},
+			Expiry: cfg.Uploads.Expiry,
+		},
+	}
+	// Signed file links for <a href> and external readers, download quotas per plan/group
+	downloads := services.DownloadOptions{
+		Signer:  auth.NewLinkSigner(cfg.Downloads.LinkSecret, cfg.Downloads.LinkTTL),
+		BaseURL: cfg.Storage.BaseURL,
+		Limits: services.DownloadLimits{
+			Period:     cfg.Downloads.Period,
+			GroupQuota: cfg.Downloads.GroupQuota,
+		},
+	}
+	watermarks := services.WatermarkOptions{CacheTTL: cfg.Downloads.WatermarkCacheTTL}
+	// Content checks before a book file is stored; ClamAV is optional (CLAMAV_ADDRESS)
+	checks := services.DefaultFileCheckOptions()
+	checks.Limits.MaxEntries = cfg.Uploads.EPUBMaxEntries
+	checks.Limits.MaxUnpacked = cfg.Uploads.EPUBMaxUnpacked
+	if cfg.Uploads.ClamAVAddress != "" {
+		clam := scanner.NewClamAV(cfg.Uploads.ClamAVAddress, cfg.Uploads.ClamAVTimeout)
+		if err := clam.Ping(); err != nil {
+			log.Printf("Warning: ClamAV is not answering (%v) — uploads will be rejected until it is", err)
+		}
+		checks.Scanner = clam
+	}
+	stats := services.ReadingStatsOptions{CacheTTL: cfg.StatsCacheTTL}
+	sessions := services.ReadingSessionOptions{IdleTimeout: cfg.SessionIdleTimeout}
+	// Offline licences are signed with a key derived from the link secret, so every instance agrees on it
+	offline := services.OfflineOptions{Signer: auth.NewLicenceSigner(cfg.Downloads.LinkSecret)}
+	svc := services.NewExtendedServicesWithWorkers(services.Deps{
+		Repos:        repos,
+		JWT:          jwtService,
+		Storage:      fileStorage,
+		Bus:          bus,
+		FileQueue:    fileQueue,
+		WebhookQueue: webhookQueue,
+		CoverPool:    coverPool,
+		Scheduler:    sched,
+		Uploads:      uploads,
+		Downloads:    downloads,
+		Watermarks:   watermarks,
+		Checks:       checks,
+		Stats:        stats,
+		Sessions:     sessions,
+		Offline:      offline,
+	})
 	svc.FeatureFlag.StartCacheUpdate(5 * time.Minute)
+	// Handlers are registered by the services above
+	fileQueue.Start()
+	webhookQueue.Start()
+
+	if err := services.RegisterMaintenanceTasks(sched, svc, repos); err != nil {
+		log.Fatal("Ошибка регистрации периодических задач:", err)
+	}
+	if err := sched.Start(); err != nil {
+		log.Fatal("Ошибка запуска планировщика:", err)
+	}
+
+	dispatchCtx, stopDispatch := context.WithCancel(context.Background())
+	svc.Webhook.Start(dispatchCtx, bus)
+
+	// Outbox relay starts after in-process subscribers so nothing it publishes is missed
+	relay := worker.NewOutboxRelay(repos.Outbox, bus, 500*time.Millisecond)
+	relay.Start(dispatchCtx)
 
 	v := validator.New()
 	handlersInstance := handlers.NewExtendedHandlers(svc, fileStorage, v, bus)
@@ -94,7 +179,7 @@ func main() {
 	// Start in background
 	go func() {
 		log.Printf("Library API v2.0 → http://localhost:%s", cfg.Port)
-		log.Printf("Storage: %s | NATS: %s", storagePath, cfg.NatsURL)
+		log.Printf("Storage: %s | NATS: %s", cfg.Storage.Backend, cfg.NatsURL)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatal("Server error:", err)
 		}
@@ -115,8 +200,12 @@ func main() {
 		log.Printf("HTTP shutdown error: %v", err)
 	}
 
-	// Drain worker pool
-	pool.Shutdown(20 * time.Second)
+	// Stop the outbox relay and webhook dispatch, then drain worker pools
+	stopDispatch()
+	sched.Shutdown(10 * time.Second)
+	fileQueue.Shutdown(20 * time.Second)
+	webhookQueue.Shutdown(10 * time.Second)
+	coverPool.Shutdown(10 * time.Second)
 
 	// Close NATS
 	if natsBridge != nil {
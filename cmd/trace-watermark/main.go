@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/oneErrortime/afst/internal/config"
+	"github.com/oneErrortime/afst/internal/repository"
+	"github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/oneErrortime/afst/internal/services"
+)
+
+// trace-watermark читает водяной знак из скачанного PDF или EPUB и находит
+// аккаунт, которому была выдана копия. Код выхода 1 — знак не найден,
+// 2 — знак есть, но выдачи с таким ID в базе нет (файл из другой
+// инсталляции или знак подделан).
+func main() {
+	flag.Usage = func() {
+		fmt.Println("Использование: go run ./cmd/trace-watermark <файл.pdf|файл.epub>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal("Ошибка открытия файла:", err)
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		log.Fatal("Ошибка чтения файла:", err)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Ошибка загрузки конфигурации:", err)
+	}
+	db, err := repository.NewDatabase(&cfg.Database)
+	if err != nil {
+		log.Fatal("Ошибка подключения к базе данных:", err)
+	}
+	// Хранилище для опознания не нужно
+	watermarks := services.NewWatermarkService(gorm.NewExtendedRepository(db), nil, services.WatermarkOptions{})
+
+	trace, err := watermarks.Trace(f, info.Size())
+	switch {
+	case errors.Is(err, services.ErrWatermarkUnsupported):
+		fmt.Println("Формат файла не поддерживается: ожидается PDF или EPUB")
+		os.Exit(1)
+	case errors.Is(err, services.ErrWatermarkNotFound):
+		fmt.Println("Водяной знак не найден")
+		os.Exit(1)
+	case err != nil:
+		log.Fatal("Ошибка чтения водяного знака:", err)
+	}
+
+	mark := trace.Mark
+	fmt.Printf("Копия:         %s\n", mark.ID)
+	fmt.Printf("Пользователь:  %s\n", mark.UserID)
+	fmt.Printf("Хеш e-mail:    %s\n", mark.EmailHash)
+	fmt.Printf("Выдана:        %s\n", mark.IssuedAt.Format(time.RFC3339))
+
+	if trace.User != nil {
+		match := "не совпадает (e-mail менялся после выдачи)"
+		if trace.EmailMatches {
+			match = "совпадает"
+		}
+		fmt.Printf("Аккаунт:       %s <%s>, хеш e-mail %s\n", trace.User.Name, trace.User.Email, match)
+	} else {
+		fmt.Println("Аккаунт:       не найден (удалён)")
+	}
+
+	if trace.Record == nil {
+		fmt.Println("Выдача с таким ID в базе не найдена")
+		os.Exit(2)
+	}
+	if trace.Record.UserID != mark.UserID || trace.Record.EmailHash != mark.EmailHash {
+		fmt.Println("Данные знака расходятся с записью о выдаче — знак мог быть изменён")
+		fmt.Printf("По записи:     пользователь %s, файл %s\n", trace.Record.UserID, trace.Record.FileID)
+		os.Exit(2)
+	}
+	fmt.Printf("Файл книги:    %s\n", trace.Record.FileID)
+}
@@ -3,7 +3,7 @@ import { useAuthStore } from '@/store/authStore';
 import { getBaseUrl } from '@/api/wrapper';
 
 type SSEEventType =
-  | 'connected' | 'ping'
+  | 'connected' | 'ping' | 'reset'
   | 'book.uploaded' | 'book.processed'
   | 'access.granted' | 'access.revoked'
   | 'subscription.new' | 'subscription.expired'
@@ -18,7 +18,7 @@ interface UseSSEOptions {
 }
 
 const ALL_EVENTS: SSEEventType[] = [
-  'connected', 'ping', 'book.uploaded', 'book.processed',
+  'connected', 'ping', 'reset', 'book.uploaded', 'book.processed',
   'access.granted', 'access.revoked', 'subscription.new',
   'subscription.expired', 'reading.progress',
 ];
@@ -28,6 +28,8 @@ export function useSSE({ handlers, enabled = true }: UseSSEOptions) {
   const esRef = useRef<EventSource | null>(null);
   const retryRef = useRef<ReturnType<typeof setTimeout> | undefined>(undefined);
   const delayRef = useRef(1000);
+  // last seen event id — the server replays what was missed on reconnect
+  const lastEventIdRef = useRef('');
   const handlersRef = useRef(handlers);
   const connectRef = useRef<() => void>(() => {});
 
@@ -39,7 +41,10 @@ export function useSSE({ handlers, enabled = true }: UseSSEOptions) {
     if (!token || !isAuthenticated) return;
 
     const baseUrl = getBaseUrl();
-    const url = `${baseUrl}/events/stream?token=${encodeURIComponent(token)}`;
+    let url = `${baseUrl}/events/stream?token=${encodeURIComponent(token)}`;
+    if (lastEventIdRef.current) {
+      url += `&last_event_id=${encodeURIComponent(lastEventIdRef.current)}`;
+    }
     const es = new EventSource(url);
     esRef.current = es;
 
@@ -55,6 +60,7 @@ export function useSSE({ handlers, enabled = true }: UseSSEOptions) {
 
     for (const type of ALL_EVENTS) {
       es.addEventListener(type, (e: MessageEvent) => {
+        if (e.lastEventId) lastEventIdRef.current = e.lastEventId;
         const h = handlersRef.current[type];
         if (!h) return;
         try { h(JSON.parse(e.data)); } catch { h(e.data); }
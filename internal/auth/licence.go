@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// LicenceSigner подписывает офлайн-лицензии Ed25519. В отличие от ссылок на
+// файлы, лицензию проверяет мобильное приложение без связи с сервером, поэтому
+// подпись асимметричная: приложению достаточно открытого ключа. Закрытый ключ
+// выводится из секрета, так что он одинаков на всех экземплярах сервера.
+type LicenceSigner struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+func NewLicenceSigner(secret string) *LicenceSigner {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("offline-licences"))
+	key := ed25519.NewKeyFromSeed(mac.Sum(nil))
+
+	sum := sha256.Sum256(key.Public().(ed25519.PublicKey))
+	return &LicenceSigner{key: key, keyID: hex.EncodeToString(sum[:8])}
+}
+
+// PublicKey — ключ, которым приложение проверяет лицензии
+func (s *LicenceSigner) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// KeyID — короткий отпечаток открытого ключа; по нему приложение понимает,
+// что ключ сменился и его нужно перезапросить
+func (s *LicenceSigner) KeyID() string {
+	return s.keyID
+}
+
+func (s *LicenceSigner) Sign(data []byte) []byte {
+	return ed25519.Sign(s.key, data)
+}
+
+func (s *LicenceSigner) Verify(data, sig []byte) bool {
+	return ed25519.Verify(s.PublicKey(), data, sig)
+}
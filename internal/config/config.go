@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -19,6 +21,22 @@ type Config struct {
 	// Настройки JWT
 	JWT JWTConfig
 
+	// Файловое хранилище: локальный диск или S3-совместимое
+	Storage StorageConfig
+
+	// Возобновляемые загрузки файлов (tus)
+	Uploads UploadConfig
+
+	// Подписанные ссылки на файлы и квоты скачиваний
+	Downloads DownloadConfig
+
+	// StatsCacheTTL — срок кэширования личной статистики чтения, 0 — без кэша
+	StatsCacheTTL time.Duration
+
+	// SessionIdleTimeout — сколько сессия чтения может быть без пульсов
+	// клиента, прежде чем читатель считается ушедшим
+	SessionIdleTimeout time.Duration
+
 	// NATS (опционально — если пусто, работаем без NATS)
 	NatsURL string
 
@@ -37,6 +55,54 @@ type JWTConfig struct {
 	ExpiresIn time.Duration
 }
 
+// StorageConfig содержит настройки файлового хранилища
+type StorageConfig struct {
+	// Backend — "local" (по умолчанию) или "s3"
+	Backend   string
+	LocalPath string
+	BaseURL   string
+	S3        S3Config
+}
+
+// S3Config содержит настройки S3-совместимого хранилища
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle — бакет в пути (MinIO), а не в имени хоста
+	PathStyle bool
+	// PartSize — размер части multipart-загрузки
+	PartSize int64
+	// PresignExpiry — срок действия подписанных ссылок, 0 — без них
+	PresignExpiry time.Duration
+}
+
+// UploadConfig содержит настройки возобновляемых загрузок
+type UploadConfig struct {
+	PartialPath      string
+	Expiry           time.Duration
+	MaxSizeLibrarian int64
+	MaxSizeAdmin     int64
+	// EPUBMaxEntries и EPUBMaxUnpacked ограничивают распаковку EPUB при проверке
+	EPUBMaxEntries  int
+	EPUBMaxUnpacked int64
+	// ClamAVAddress — адрес clamd (tcp://host:3310 или unix:///path), пусто — без антивируса
+	ClamAVAddress string
+	ClamAVTimeout time.Duration
+}
+
+// DownloadConfig содержит настройки ссылок на файлы и квот скачиваний
+type DownloadConfig struct {
+	LinkSecret string
+	LinkTTL    time.Duration
+	Period     time.Duration
+	GroupQuota int
+	// WatermarkCacheTTL — срок хранения помеченных копий, 0 — без кэша
+	WatermarkCacheTTL time.Duration
+}
+
 // Load загружает конфигурацию из переменных окружения
 func Load() (*Config, error) {
 	// Загружаем .env файл (игнорируем ошибку, если файла нет)
@@ -49,10 +115,54 @@ func Load() (*Config, error) {
 		jwtExpires = 24 * time.Hour
 	}
 
+	uploadExpiry, err := time.ParseDuration(getEnvOrDefault("UPLOAD_EXPIRY", "24h"))
+	if err != nil {
+		uploadExpiry = 24 * time.Hour
+	}
+
+	// Пустой срок — ссылки на прямое скачивание из S3 отключены
+	presignExpiry, err := time.ParseDuration(getEnvOrDefault("S3_PRESIGN_EXPIRY", "0s"))
+	if err != nil {
+		presignExpiry = 0
+	}
+
+	linkTTL, err := time.ParseDuration(getEnvOrDefault("DOWNLOAD_LINK_TTL", "10m"))
+	if err != nil {
+		linkTTL = 10 * time.Minute
+	}
+
+	downloadPeriod, err := time.ParseDuration(getEnvOrDefault("DOWNLOAD_QUOTA_PERIOD", "720h"))
+	if err != nil {
+		downloadPeriod = 30 * 24 * time.Hour
+	}
+
+	watermarkCacheTTL, err := time.ParseDuration(getEnvOrDefault("WATERMARK_CACHE_TTL", "24h"))
+	if err != nil {
+		watermarkCacheTTL = 24 * time.Hour
+	}
+
+	clamTimeout, err := time.ParseDuration(getEnvOrDefault("CLAMAV_TIMEOUT", "60s"))
+	if err != nil {
+		clamTimeout = time.Minute
+	}
+
+	statsCacheTTL, err := time.ParseDuration(getEnvOrDefault("STATS_CACHE_TTL", "10m"))
+	if err != nil {
+		statsCacheTTL = 10 * time.Minute
+	}
+
+	sessionIdleTimeout, err := time.ParseDuration(getEnvOrDefault("SESSION_IDLE_TIMEOUT", "5m"))
+	if err != nil || sessionIdleTimeout <= 0 {
+		sessionIdleTimeout = 5 * time.Minute
+	}
+
+	jwtSecret := getEnvOrDefault("JWT_SECRET", "your-super-secret-jwt-key")
+
+	port := getEnvOrDefault("PORT", "8080")
 	dbSQLitePath := getEnvOrDefault("DB_SQLITE_PATH", "library.db")
 
 	config := &Config{
-		Port:    getEnvOrDefault("PORT", "8080"),
+		Port:    port,
 		GinMode: getEnvOrDefault("GIN_MODE", "debug"),
 
 		Database: DatabaseConfig{
@@ -60,10 +170,51 @@ func Load() (*Config, error) {
 		},
 
 		JWT: JWTConfig{
-			Secret:    getEnvOrDefault("JWT_SECRET", "your-super-secret-jwt-key"),
+			Secret:    jwtSecret,
 			ExpiresIn: jwtExpires,
 		},
 
+		Storage: StorageConfig{
+			Backend:   getEnvOrDefault("STORAGE_BACKEND", "local"),
+			LocalPath: getEnvOrDefault("FILE_STORAGE_PATH", "./uploads"),
+			BaseURL:   getEnvOrDefault("BASE_URL", fmt.Sprintf("http://localhost:%s", port)),
+			S3: S3Config{
+				Endpoint:      os.Getenv("S3_ENDPOINT"),
+				Region:        getEnvOrDefault("S3_REGION", "us-east-1"),
+				Bucket:        os.Getenv("S3_BUCKET"),
+				AccessKey:     os.Getenv("S3_ACCESS_KEY"),
+				SecretKey:     os.Getenv("S3_SECRET_KEY"),
+				PathStyle:     os.Getenv("S3_PATH_STYLE") == "true",
+				PartSize:      getEnvInt64OrDefault("S3_PART_SIZE", 16<<20),
+				PresignExpiry: presignExpiry,
+			},
+		},
+
+		Uploads: UploadConfig{
+			PartialPath:      getEnvOrDefault("UPLOAD_PARTIAL_PATH", "./uploads/.partial"),
+			Expiry:           uploadExpiry,
+			MaxSizeLibrarian: getEnvInt64OrDefault("UPLOAD_MAX_SIZE_LIBRARIAN", 1<<30),
+			MaxSizeAdmin:     getEnvInt64OrDefault("UPLOAD_MAX_SIZE_ADMIN", 4<<30),
+
+			EPUBMaxEntries:  int(getEnvInt64OrDefault("UPLOAD_EPUB_MAX_ENTRIES", 10000)),
+			EPUBMaxUnpacked: getEnvInt64OrDefault("UPLOAD_EPUB_MAX_UNPACKED", 2<<30),
+			ClamAVAddress:   os.Getenv("CLAMAV_ADDRESS"), // empty = disabled
+			ClamAVTimeout:   clamTimeout,
+		},
+
+		Downloads: DownloadConfig{
+			// По умолчанию ключ ссылок выводится из секрета JWT
+			LinkSecret: getEnvOrDefault("DOWNLOAD_LINK_SECRET", jwtSecret),
+			LinkTTL:    linkTTL,
+			Period:     downloadPeriod,
+			GroupQuota: int(getEnvInt64OrDefault("DOWNLOAD_GROUP_QUOTA", 5)),
+
+			WatermarkCacheTTL: watermarkCacheTTL,
+		},
+
+		StatsCacheTTL:      statsCacheTTL,
+		SessionIdleTimeout: sessionIdleTimeout,
+
 		NatsURL:  os.Getenv("NATS_URL"), // empty = disabled
 		LogLevel: getEnvOrDefault("LOG_LEVEL", "debug"),
 	}
@@ -78,3 +229,11 @@ func getEnvOrDefault(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvInt64OrDefault возвращает числовое значение переменной окружения или значение по умолчанию
+func getEnvInt64OrDefault(key string, defaultValue int64) int64 {
+	if n, err := strconv.ParseInt(os.Getenv(key), 10, 64); err == nil {
+		return n
+	}
+	return defaultValue
+}
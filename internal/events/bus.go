@@ -3,9 +3,13 @@ package events
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"sync"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // EventType defines all system event types
@@ -21,14 +25,66 @@ const (
 	EventSubscriptionExpired EventType = "subscription.expired"
 	EventReadingProgress   EventType = "reading.progress"
 	EventReadingSessionEnd EventType = "reading.session.end"
+	EventGoalReached       EventType = "goal.reached"
+	EventStreakAtRisk      EventType = "streak.at_risk"
 )
 
-// Event is the envelope for all system events
+// AllEventTypes lists every event type the system publishes.
+// Consumers that want "everything" (webhooks, bridges) subscribe to this set.
+var AllEventTypes = []EventType{
+	EventBookUploaded,
+	EventBookProcessed,
+	EventBookDeleted,
+	EventAccessGranted,
+	EventAccessRevoked,
+	EventSubscriptionNew,
+	EventSubscriptionExpired,
+	EventReadingProgress,
+	EventReadingSessionEnd,
+	EventGoalReached,
+	EventStreakAtRisk,
+}
+
+// IsKnownEventType reports whether name is one of AllEventTypes
+func IsKnownEventType(name string) bool {
+	for _, t := range AllEventTypes {
+		if string(t) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessPayload is sent when a user gains or loses access to a book
+type AccessPayload struct {
+	AccessID string `json:"access_id"`
+	BookID   string `json:"book_id"`
+	UserID   string `json:"user_id"`
+	Type     string `json:"type,omitempty"`
+	EndDate  string `json:"end_date,omitempty"`
+}
+
+// SubscriptionPayload is sent on subscription lifecycle changes
+type SubscriptionPayload struct {
+	SubscriptionID string `json:"subscription_id"`
+	UserID         string `json:"user_id"`
+	Plan           string `json:"plan"`
+	Status         string `json:"status"`
+	EndDate        string `json:"end_date"`
+}
+
+// Event is the envelope for all system events.
+// ID is stable across redeliveries from the outbox, so consumers can
+// drop duplicates (see Deduplicator).
 type Event struct {
+	ID        string      `json:"id,omitempty"`
 	Type      EventType   `json:"type"`
 	Payload   interface{} `json:"payload"`
 	UserID    string      `json:"user_id,omitempty"`
 	Timestamp time.Time   `json:"timestamp"`
+	// Remote is set on events injected by the NATS bridge from another instance.
+	// Consumers with external side effects (webhooks) should leave those to the origin.
+	Remote bool `json:"-"`
 }
 
 // BookUploadedPayload is sent when a file is uploaded for a book
@@ -39,13 +95,26 @@ type BookUploadedPayload struct {
 	FileType string `json:"file_type"`
 }
 
-// BookProcessedPayload is sent after background processing completes
+// Stages reported in BookProcessedPayload
+const (
+	StageProcessed  = "processed"
+	StageConverting = "converting"
+	StageConverted  = "converted"
+)
+
+// BookProcessedPayload is sent after background processing completes and,
+// for MOBI files, while the EPUB conversion runs and when it finishes
 type BookProcessedPayload struct {
 	BookID    string `json:"book_id"`
 	FileID    string `json:"file_id"`
+	Stage     string `json:"stage"`
 	PageCount int    `json:"page_count"`
-	Success   bool   `json:"success"`
-	Error     string `json:"error,omitempty"`
+	// Progress is the conversion progress in percent
+	Progress int  `json:"progress,omitempty"`
+	Success  bool `json:"success"`
+	// DerivedFileID is the EPUB produced by a finished conversion
+	DerivedFileID string `json:"derived_file_id,omitempty"`
+	Error         string `json:"error,omitempty"`
 }
 
 // ProgressPayload carries reading progress updates
@@ -55,16 +124,64 @@ type ProgressPayload struct {
 	CurrentPage int     `json:"current_page"`
 	TotalPages  int     `json:"total_pages"`
 	Progress    float32 `json:"progress"`
+
+	// ConnectionID identifies the WebSocket connection that reported the progress,
+	// so it is not echoed back to the same device
+	ConnectionID string `json:"connection_id,omitempty"`
+	// DeviceID and Locator are set for positions synced per device; other
+	// devices use them to offer a jump to the latest position
+	DeviceID string      `json:"device_id,omitempty"`
+	Locator  interface{} `json:"locator,omitempty"`
+}
+
+// GoalPayload is sent once per period when a reading goal is reached, and
+// once per participant when a challenge target is reached
+type GoalPayload struct {
+	GoalID      string `json:"goal_id,omitempty"`
+	ChallengeID string `json:"challenge_id,omitempty"`
+	Metric      string `json:"metric"`
+	Period      string `json:"period,omitempty"`
+	Target      int    `json:"target"`
+	Value       int64  `json:"value"`
+	PeriodStart string `json:"period_start"`
+}
+
+// StreakPayload is sent in the evening when a daily goal met yesterday is
+// not met yet today, so the streak is about to break
+type StreakPayload struct {
+	GoalID string `json:"goal_id"`
+	Metric string `json:"metric"`
+	Target int    `json:"target"`
+	Value  int64  `json:"value"`
+	Streak int    `json:"streak"`
 }
 
 // Subscriber is a channel that receives events
 type Subscriber chan Event
 
+// subscription is one Subscribe call. Durable subscriptions make
+// PublishDurable wait for buffer space instead of dropping the event.
+type subscription struct {
+	ch      Subscriber
+	durable bool
+	// done is closed on unsubscribe so a blocked send gives up
+	done chan struct{}
+
+	// mu guards closed: senders hold it for reading while they send, so ch
+	// is never closed under them
+	mu     sync.RWMutex
+	closed bool
+}
+
+// ErrSubscriberGone is returned by PublishDurable when a durable subscriber
+// unsubscribed before it took the event
+var ErrSubscriberGone = errors.New("subscriber unsubscribed before taking the event")
+
 // Bus is the central event dispatcher. It uses in-process channels
 // and optionally bridges to NATS for distributed deployments.
 type Bus struct {
 	mu          sync.RWMutex
-	subscribers map[EventType][]Subscriber
+	subscribers map[EventType][]*subscription
 	bufferSize  int
 
 	// NATS bridge (optional — nil if NATS not configured)
@@ -74,6 +191,8 @@ type Bus struct {
 // NATSBridge abstracts the NATS connection so the bus doesn't import nats directly
 type NATSBridge interface {
 	Publish(subject string, data []byte) error
+	// PublishSync returns once the server has stored the message
+	PublishSync(ctx context.Context, subject string, data []byte) error
 	Subscribe(subject string, handler func([]byte)) error
 	Close()
 }
@@ -88,7 +207,7 @@ func Default() *Bus {
 // NewBus creates a new event bus with the given subscriber channel buffer size
 func NewBus(bufferSize int) *Bus {
 	return &Bus{
-		subscribers: make(map[EventType][]Subscriber),
+		subscribers: make(map[EventType][]*subscription),
 		bufferSize:  bufferSize,
 	}
 }
@@ -103,57 +222,164 @@ func (b *Bus) SetNATSBridge(bridge NATSBridge) {
 // Subscribe returns a channel that will receive all events of the given type.
 // Cancel ctx to unsubscribe and free resources.
 func (b *Bus) Subscribe(ctx context.Context, eventTypes ...EventType) Subscriber {
-	ch := make(Subscriber, b.bufferSize)
+	return b.subscribe(ctx, false, eventTypes)
+}
+
+// SubscribeDurable is Subscribe for consumers that must not miss events
+// relayed from the outbox (webhooks, SSE replay): PublishDurable waits for
+// room in their buffer rather than dropping the event.
+func (b *Bus) SubscribeDurable(ctx context.Context, eventTypes ...EventType) Subscriber {
+	return b.subscribe(ctx, true, eventTypes)
+}
+
+func (b *Bus) subscribe(ctx context.Context, durable bool, eventTypes []EventType) Subscriber {
+	sub := &subscription{
+		ch:      make(Subscriber, b.bufferSize),
+		durable: durable,
+		done:    make(chan struct{}),
+	}
 	b.mu.Lock()
 	for _, et := range eventTypes {
-		b.subscribers[et] = append(b.subscribers[et], ch)
+		b.subscribers[et] = append(b.subscribers[et], sub)
 	}
 	b.mu.Unlock()
 
 	go func() {
 		<-ctx.Done()
+		close(sub.done)
 		b.mu.Lock()
 		for _, et := range eventTypes {
 			subs := b.subscribers[et]
 			for i, s := range subs {
-				if s == ch {
+				if s == sub {
 					b.subscribers[et] = append(subs[:i], subs[i+1:]...)
 					break
 				}
 			}
 		}
 		b.mu.Unlock()
-		close(ch)
+
+		sub.mu.Lock()
+		sub.closed = true
+		close(sub.ch)
+		sub.mu.Unlock()
 	}()
-	return ch
+	return sub.ch
 }
 
 // Publish dispatches an event to all subscribers and (if configured) NATS
 func (b *Bus) Publish(event Event) {
-	event.Timestamp = time.Now()
+	event = stamp(event)
+	_ = b.deliver(nil, event)
+
+	// Bridge to NATS if available
+	if bridge := b.bridge(); bridge != nil {
+		if data, err := json.Marshal(event); err == nil {
+			if err := bridge.Publish(natsSubject(event), data); err != nil {
+				log.Printf("[events] NATS publish error for %s: %v", event.Type, err)
+			}
+		}
+	}
+}
+
+// PublishDurable is Publish for the outbox relay. It waits until every
+// durable subscriber has taken the event and, with a NATS bridge, until
+// JetStream has stored it. An error means the event may not have reached
+// everyone and has to be published again; the ID stays the same, so
+// consumers that already got it drop the repeat.
+func (b *Bus) PublishDurable(ctx context.Context, event Event) error {
+	event = stamp(event)
+	if err := b.deliver(ctx, event); err != nil {
+		return fmt.Errorf("deliver %s: %w", event.Type, err)
+	}
+
+	bridge := b.bridge()
+	if bridge == nil {
+		return nil
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", event.Type, err)
+	}
+	if err := bridge.PublishSync(ctx, natsSubject(event), data); err != nil {
+		return fmt.Errorf("NATS publish %s: %w", event.Type, err)
+	}
+	return nil
+}
+
+// stamp fills in the ID and time of a new event. Events relayed from the
+// outbox keep the ID and time they were recorded with.
+func stamp(event Event) Event {
+	if event.ID == "" {
+		event.ID = uuid.NewString()
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	return event
+}
+
+func natsSubject(event Event) string {
+	return "afst." + string(event.Type)
+}
 
+func (b *Bus) bridge() NATSBridge {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.natsBridge
+}
+
+// publishLocal delivers an event to in-process subscribers only.
+// The NATS bridge uses it to inject remote events without echoing them back.
+func (b *Bus) publishLocal(event Event) {
+	_ = b.deliver(nil, event)
+}
+
+// deliver sends event to in-process subscribers. Without ctx, or for
+// subscribers that are not durable, a full buffer drops the event; with ctx,
+// durable subscribers are waited for until ctx is done.
+func (b *Bus) deliver(ctx context.Context, event Event) error {
 	b.mu.RLock()
 	subs := b.subscribers[event.Type]
 	// make a copy to avoid holding the lock while sending
-	snapshot := make([]Subscriber, len(subs))
+	snapshot := make([]*subscription, len(subs))
 	copy(snapshot, subs)
 	b.mu.RUnlock()
 
-	for _, ch := range snapshot {
+	for _, sub := range snapshot {
+		if err := sub.send(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *subscription) send(ctx context.Context, event Event) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		if ctx != nil && s.durable {
+			return ErrSubscriberGone
+		}
+		return nil
+	}
+
+	if ctx == nil || !s.durable {
 		select {
-		case ch <- event:
+		case s.ch <- event:
 		default:
 			// Subscriber is slow — drop rather than block the publisher
 			log.Printf("[events] dropped event %s: subscriber buffer full", event.Type)
 		}
+		return nil
 	}
 
-	// Bridge to NATS if available
-	if b.natsBridge != nil {
-		if data, err := json.Marshal(event); err == nil {
-			if err := b.natsBridge.Publish("afst."+string(event.Type), data); err != nil {
-				log.Printf("[events] NATS publish error for %s: %v", event.Type, err)
-			}
-		}
+	select {
+	case s.ch <- event:
+		return nil
+	case <-s.done:
+		return ErrSubscriberGone
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
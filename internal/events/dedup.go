@@ -0,0 +1,45 @@
+package events
+
+import "sync"
+
+// Deduplicator remembers the IDs of the last N events and reports repeats.
+// The outbox relay delivers at least once, so a consumer with side effects
+// should check Seen before acting on an event.
+type Deduplicator struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+	ring []string
+	next int
+}
+
+// NewDeduplicator creates a deduplicator that remembers up to size IDs
+func NewDeduplicator(size int) *Deduplicator {
+	if size <= 0 {
+		size = 1024
+	}
+	return &Deduplicator{
+		seen: make(map[string]struct{}, size),
+		ring: make([]string, size),
+	}
+}
+
+// Seen records id and reports whether it was already recorded.
+// Events without an ID are never treated as duplicates.
+func (d *Deduplicator) Seen(id string) bool {
+	if id == "" {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.seen[id]; ok {
+		return true
+	}
+	if old := d.ring[d.next]; old != "" {
+		delete(d.seen, old)
+	}
+	d.ring[d.next] = id
+	d.next = (d.next + 1) % len(d.ring)
+	d.seen[id] = struct{}{}
+	return false
+}
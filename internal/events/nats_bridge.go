@@ -1,34 +1,213 @@
 package events
 
-import "log"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
 
-// NATSBridgeImpl is a no-op stub.
-// NATS support is intentionally excluded from this build to keep the
-// binary dependency-free. If distributed SSE fanout is needed in the
-// future, re-introduce github.com/nats-io/nats.go with `go get` and
-// run `go mod tidy` before uncommitting this file.
-type NATSBridgeImpl struct{}
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// NATSStreamName is the JetStream stream holding all afst.* subjects
+	NATSStreamName = "AFST_EVENTS"
+	natsSubjectAll = "afst.>"
+
+	// natsOriginHeader carries the publishing instance so it can skip its own messages
+	natsOriginHeader = "Afst-Origin"
+
+	natsStreamMaxAge = 24 * time.Hour
+	// natsDupWindow is how long JetStream remembers Nats-Msg-Id for deduplication
+	natsDupWindow  = 2 * time.Minute
+	natsOpTimeout  = 5 * time.Second
+	natsMaxPending = 1024
+)
+
+// NATSBridgeImpl fans bus events out to other instances through JetStream.
+// Every event is published to afst.<type> with Nats-Msg-Id set to the event ID,
+// so outbox redeliveries collapse inside the duplicate window. Each instance
+// reads the stream with an ordered consumer, which resumes from the last seen
+// sequence after a reconnect, and injects foreign events into its local bus.
+type NATSBridgeImpl struct {
+	nc     *nats.Conn
+	js     jetstream.JetStream
+	bus    *Bus
+	origin string
+
+	mu   sync.Mutex
+	subs []jetstream.ConsumeContext
+}
 
 // NewNATSBridge returns nil when NATS_URL is empty (single-instance mode).
-// It logs a warning when NATS_URL is set so operators know it is ignored.
+// Otherwise it connects (retrying in the background if NATS is not up yet),
+// ensures the stream exists, attaches itself to bus and starts injecting
+// events published by other instances.
 func NewNATSBridge(natsURL string, bus *Bus) (*NATSBridgeImpl, error) {
 	if natsURL == "" {
 		log.Println("[nats] NATS_URL not set — running in single-instance mode (no NATS)")
 		return nil, nil
 	}
-	log.Println("[nats] WARNING: NATS_URL is set but NATS support is not compiled in this build")
-	return nil, nil
+
+	nc, err := nats.Connect(natsURL,
+		nats.Name("afst"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(2*time.Second),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Printf("[nats] disconnected: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			log.Printf("[nats] reconnected to %s", c.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("nats connect: %w", err)
+	}
+
+	js, err := jetstream.New(nc,
+		jetstream.WithPublishAsyncMaxPending(natsMaxPending),
+		jetstream.WithPublishAsyncErrHandler(func(_ jetstream.JetStream, msg *nats.Msg, err error) {
+			log.Printf("[nats] publish to %s failed: %v", msg.Subject, err)
+		}),
+	)
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("jetstream: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), natsOpTimeout)
+	defer cancel()
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       NATSStreamName,
+		Subjects:   []string{natsSubjectAll},
+		Storage:    jetstream.FileStorage,
+		MaxAge:     natsStreamMaxAge,
+		Duplicates: natsDupWindow,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("create stream %s: %w", NATSStreamName, err)
+	}
+
+	b := &NATSBridgeImpl{
+		nc:     nc,
+		js:     js,
+		bus:    bus,
+		origin: uuid.NewString(),
+	}
+
+	if err := b.Subscribe(natsSubjectAll, b.inject); err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	bus.SetNATSBridge(b)
+	log.Printf("[nats] bridge connected to %s (stream %s, instance %s)", natsURL, NATSStreamName, b.origin)
+	return b, nil
+}
+
+// Publish sends data to JetStream without blocking the bus publisher.
+// Failures are reported by the async error handler.
+func (b *NATSBridgeImpl) Publish(subject string, data []byte) error {
+	msg, opts := b.message(subject, data)
+	_, err := b.js.PublishMsgAsync(msg, opts...)
+	return err
+}
+
+// PublishSync sends data to JetStream and waits for the stream to store it;
+// the outbox relay uses it so an event is not marked published before then
+func (b *NATSBridgeImpl) PublishSync(ctx context.Context, subject string, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, natsOpTimeout)
+	defer cancel()
+	msg, opts := b.message(subject, data)
+	_, err := b.js.PublishMsg(ctx, msg, opts...)
+	return err
+}
+
+// message builds a message tagged with this instance and, when data carries
+// an event ID, with Nats-Msg-Id for deduplication
+func (b *NATSBridgeImpl) message(subject string, data []byte) (*nats.Msg, []jetstream.PublishOpt) {
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	msg.Header.Set(natsOriginHeader, b.origin)
+
+	var opts []jetstream.PublishOpt
+	var meta struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(data, &meta) == nil && meta.ID != "" {
+		opts = append(opts, jetstream.WithMsgID(meta.ID))
+	}
+	return msg, opts
 }
 
-// Publish is a no-op in the stub.
-func (b *NATSBridgeImpl) Publish(_ string, _ []byte) error { return nil }
+// Subscribe delivers new messages on subject published by other instances.
+// Messages from this instance are filtered out to avoid echo loops.
+func (b *NATSBridgeImpl) Subscribe(subject string, handler func([]byte)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), natsOpTimeout)
+	defer cancel()
 
-// Subscribe is a no-op in the stub.
-func (b *NATSBridgeImpl) Subscribe(_ string, _ func([]byte)) error { return nil }
+	cons, err := b.js.OrderedConsumer(ctx, NATSStreamName, jetstream.OrderedConsumerConfig{
+		FilterSubjects: []string{subject},
+		DeliverPolicy:  jetstream.DeliverNewPolicy,
+	})
+	if err != nil {
+		return fmt.Errorf("nats consumer for %s: %w", subject, err)
+	}
 
-// Close is a no-op in the stub.
-func (b *NATSBridgeImpl) Close() {}
+	cc, err := cons.Consume(func(msg jetstream.Msg) {
+		if msg.Headers().Get(natsOriginHeader) == b.origin {
+			return
+		}
+		handler(msg.Data())
+	}, jetstream.ConsumeErrHandler(func(_ jetstream.ConsumeContext, err error) {
+		log.Printf("[nats] consume %s: %v", subject, err)
+	}))
+	if err != nil {
+		return fmt.Errorf("nats consume %s: %w", subject, err)
+	}
 
-// publishLocal would inject an event into the local bus without bridging back to NATS.
-// Reserved for future use when real NATS support is added.
-// nolint:unused
+	b.mu.Lock()
+	b.subs = append(b.subs, cc)
+	b.mu.Unlock()
+	return nil
+}
+
+// Close stops consumers, flushes pending publishes and closes the connection.
+func (b *NATSBridgeImpl) Close() {
+	b.mu.Lock()
+	for _, cc := range b.subs {
+		cc.Stop()
+	}
+	b.subs = nil
+	b.mu.Unlock()
+
+	select {
+	case <-b.js.PublishAsyncComplete():
+	case <-time.After(natsOpTimeout):
+		log.Printf("[nats] %d publishes still pending on close", b.js.PublishAsyncPending())
+	}
+	b.nc.Close()
+}
+
+func (b *NATSBridgeImpl) inject(data []byte) {
+	var wire struct {
+		Event
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &wire); err != nil {
+		log.Printf("[nats] dropping malformed event: %v", err)
+		return
+	}
+	event := wire.Event
+	event.Payload = wire.Payload
+	event.Remote = true
+	b.bus.publishLocal(event)
+}
@@ -0,0 +1,85 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runJetStreamServer(t *testing.T) *server.Server {
+	t.Helper()
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.NoError(t, err)
+	go srv.Start()
+	require.True(t, srv.ReadyForConnections(5*time.Second), "nats server did not start")
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+func TestNATSBridge_DisabledWithoutURL(t *testing.T) {
+	bridge, err := NewNATSBridge("", NewBus(1))
+	require.NoError(t, err)
+	assert.Nil(t, bridge)
+}
+
+func TestNATSBridge_FansOutBetweenInstances(t *testing.T) {
+	srv := runJetStreamServer(t)
+
+	busA, busB := NewBus(16), NewBus(16)
+	bridgeA, err := NewNATSBridge(srv.ClientURL(), busA)
+	require.NoError(t, err)
+	defer bridgeA.Close()
+	bridgeB, err := NewNATSBridge(srv.ClientURL(), busB)
+	require.NoError(t, err)
+	defer bridgeB.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	subA := busA.Subscribe(ctx, EventAccessGranted)
+	subB := busB.Subscribe(ctx, EventAccessGranted)
+
+	busA.Publish(Event{
+		ID:      "0b6f5f4e-8a1c-4c39-9d0b-0f1f6c1c2a10",
+		Type:    EventAccessGranted,
+		UserID:  "u1",
+		Payload: AccessPayload{AccessID: "a1"},
+	})
+
+	local := <-subA
+	assert.False(t, local.Remote)
+
+	select {
+	case remote := <-subB:
+		assert.True(t, remote.Remote)
+		assert.Equal(t, local.ID, remote.ID)
+		assert.Equal(t, "u1", remote.UserID)
+		var payload AccessPayload
+		require.NoError(t, json.Unmarshal(remote.Payload.(json.RawMessage), &payload))
+		assert.Equal(t, "a1", payload.AccessID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event did not reach the second instance")
+	}
+
+	// No echo back to the origin, and a republish with the same ID is deduplicated
+	busA.Publish(Event{ID: local.ID, Type: EventAccessGranted})
+	<-subA
+	select {
+	case e := <-subA:
+		t.Fatalf("unexpected echo on origin: %+v", e)
+	case e := <-subB:
+		t.Fatalf("duplicate reached second instance: %+v", e)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
@@ -0,0 +1,222 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StreamEvent is an event stamped with a stream sequence number.
+// Sequence numbers are strictly increasing within a process and start from
+// the process start time in microseconds, so they keep growing across restarts.
+type StreamEvent struct {
+	Seq uint64
+	Event
+}
+
+// replayRing is a bounded FIFO of stream events
+type replayRing struct {
+	items []StreamEvent
+	// evicted is the highest sequence number that no longer fits in the ring
+	evicted uint64
+}
+
+func (r *replayRing) push(e StreamEvent, limit int) {
+	r.items = append(r.items, e)
+	if over := len(r.items) - limit; over > 0 {
+		r.evicted = r.items[over-1].Seq
+		r.items = append(r.items[:0:0], r.items[over:]...)
+	}
+}
+
+// pruneBefore drops events older than cutoff
+func (r *replayRing) pruneBefore(cutoff time.Time) {
+	n := 0
+	for n < len(r.items) && r.items[n].Timestamp.Before(cutoff) {
+		n++
+	}
+	if n > 0 {
+		r.evicted = r.items[n-1].Seq
+		r.items = append(r.items[:0:0], r.items[n:]...)
+	}
+}
+
+func (r *replayRing) after(seq uint64) []StreamEvent {
+	for i, e := range r.items {
+		if e.Seq > seq {
+			return r.items[i:]
+		}
+	}
+	return nil
+}
+
+type replayListener struct {
+	userID string
+	ch     chan StreamEvent
+}
+
+// ReplayBuffer keeps the recent history of bus events so SSE clients can
+// resume after a reconnect. Events addressed to a user are kept per user;
+// events without a user (broadcasts) are kept in a shared ring.
+type ReplayBuffer struct {
+	mu        sync.Mutex
+	seq       uint64
+	start     uint64
+	perUser   int
+	retention time.Duration
+
+	global replayRing
+	users  map[string]*replayRing
+	// forgotten is the highest evicted sequence of user rings dropped by sweep;
+	// it stands in for the history of users the buffer no longer tracks
+	forgotten uint64
+
+	listeners map[*replayListener]struct{}
+}
+
+// NewReplayBuffer keeps up to perUser events per user (and per broadcast ring)
+// for at most retention
+func NewReplayBuffer(perUser int, retention time.Duration) *ReplayBuffer {
+	start := uint64(time.Now().UnixMicro())
+	return &ReplayBuffer{
+		seq:       start,
+		start:     start,
+		perUser:   perUser,
+		retention: retention,
+		users:     make(map[string]*replayRing),
+		listeners: make(map[*replayListener]struct{}),
+	}
+}
+
+// Run feeds the buffer from bus until ctx is cancelled. Events the outbox
+// relay publishes again after a failed attempt are stored only once.
+func (r *ReplayBuffer) Run(ctx context.Context, bus *Bus) {
+	sub := bus.SubscribeDurable(ctx, AllEventTypes...)
+	dedup := NewDeduplicator(4096)
+	go func() {
+		sweep := time.NewTicker(time.Minute)
+		defer sweep.Stop()
+		for {
+			select {
+			case event, ok := <-sub:
+				if !ok {
+					return
+				}
+				if dedup.Seen(event.ID) {
+					continue
+				}
+				r.Add(event)
+			case <-sweep.C:
+				r.sweep()
+			}
+		}
+	}()
+}
+
+// Add stamps event with the next sequence number, stores it and fans it out
+func (r *ReplayBuffer) Add(event Event) StreamEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seq++
+	se := StreamEvent{Seq: r.seq, Event: event}
+
+	if event.UserID == "" {
+		r.global.push(se, r.perUser)
+	} else {
+		ring, ok := r.users[event.UserID]
+		if !ok {
+			ring = &replayRing{evicted: r.forgotten}
+			r.users[event.UserID] = ring
+		}
+		ring.push(se, r.perUser)
+	}
+
+	for l := range r.listeners {
+		if event.UserID != "" && event.UserID != l.userID {
+			continue
+		}
+		select {
+		case l.ch <- se:
+		default:
+			// Slow client: close the stream, it reconnects with Last-Event-ID
+			// and catches up from the buffer
+			delete(r.listeners, l)
+			close(l.ch)
+		}
+	}
+	return se
+}
+
+// Attach returns the events for userID after lastSeq and a channel with
+// everything that follows, with no gap between the two. complete is false
+// when some events after lastSeq were already evicted and the client should
+// reload its state. lastSeq == 0 means a fresh connection with no backlog.
+// The channel is closed when ctx is done or the client falls too far behind.
+func (r *ReplayBuffer) Attach(ctx context.Context, userID string, lastSeq uint64) (backlog []StreamEvent, live <-chan StreamEvent, complete bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	complete = true
+	if lastSeq > 0 {
+		backlog = mergeBySeq(r.global.after(lastSeq), r.userRing(userID).after(lastSeq))
+		// An ID from before this process started means events may have been
+		// published while no buffer existed
+		if lastSeq < r.start || lastSeq < r.global.evicted || lastSeq < r.userRing(userID).evicted {
+			complete = false
+		}
+	}
+
+	l := &replayListener{userID: userID, ch: make(chan StreamEvent, 64)}
+	r.listeners[l] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		r.mu.Lock()
+		if _, ok := r.listeners[l]; ok {
+			delete(r.listeners, l)
+			close(l.ch)
+		}
+		r.mu.Unlock()
+	}()
+
+	return backlog, l.ch, complete
+}
+
+func (r *ReplayBuffer) userRing(userID string) *replayRing {
+	if ring, ok := r.users[userID]; ok {
+		return ring
+	}
+	return &replayRing{evicted: r.forgotten}
+}
+
+// sweep drops expired events and forgets users with an empty history
+func (r *ReplayBuffer) sweep() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := time.Now().Add(-r.retention)
+	r.global.pruneBefore(cutoff)
+	for id, ring := range r.users {
+		ring.pruneBefore(cutoff)
+		if len(ring.items) == 0 {
+			if ring.evicted > r.forgotten {
+				r.forgotten = ring.evicted
+			}
+			delete(r.users, id)
+		}
+	}
+}
+
+func mergeBySeq(a, b []StreamEvent) []StreamEvent {
+	out := make([]StreamEvent, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if a[0].Seq < b[0].Seq {
+			out, a = append(out, a[0]), a[1:]
+		} else {
+			out, b = append(out, b[0]), b[1:]
+		}
+	}
+	out = append(out, a...)
+	return append(out, b...)
+}
@@ -0,0 +1,88 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seqs(list []StreamEvent) []uint64 {
+	out := make([]uint64, len(list))
+	for i, e := range list {
+		out[i] = e.Seq
+	}
+	return out
+}
+
+func TestReplayBuffer_ResumesWithUserScope(t *testing.T) {
+	r := NewReplayBuffer(10, time.Hour)
+	first := r.Add(Event{Type: EventAccessGranted, UserID: "alice", Timestamp: time.Now()})
+	bob := r.Add(Event{Type: EventAccessGranted, UserID: "bob", Timestamp: time.Now()})
+	broadcast := r.Add(Event{Type: EventBookProcessed, Timestamp: time.Now()})
+	second := r.Add(Event{Type: EventAccessRevoked, UserID: "alice", Timestamp: time.Now()})
+
+	assert.Less(t, first.Seq, bob.Seq)
+	assert.Less(t, bob.Seq, broadcast.Seq)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backlog, live, complete := r.Attach(ctx, "alice", first.Seq)
+	assert.True(t, complete)
+	assert.Equal(t, []uint64{broadcast.Seq, second.Seq}, seqs(backlog))
+
+	r.Add(Event{Type: EventAccessGranted, UserID: "bob", Timestamp: time.Now()})
+	next := r.Add(Event{Type: EventAccessGranted, UserID: "alice", Timestamp: time.Now()})
+	got := <-live
+	assert.Equal(t, next.Seq, got.Seq)
+
+	cancel()
+	require.Eventually(t, func() bool {
+		_, open := <-live
+		return !open
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestReplayBuffer_ReportsGap(t *testing.T) {
+	r := NewReplayBuffer(2, time.Hour)
+	first := r.Add(Event{Type: EventAccessGranted, UserID: "alice", Timestamp: time.Now()})
+	for i := 0; i < 3; i++ {
+		r.Add(Event{Type: EventAccessGranted, UserID: "alice", Timestamp: time.Now()})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backlog, _, complete := r.Attach(ctx, "alice", first.Seq)
+	assert.False(t, complete)
+	assert.Len(t, backlog, 2)
+
+	// ID from a previous process
+	_, _, complete = r.Attach(ctx, "alice", 1)
+	assert.False(t, complete)
+
+	// Fresh connection: no backlog, nothing lost
+	backlog, _, complete = r.Attach(ctx, "alice", 0)
+	assert.True(t, complete)
+	assert.Empty(t, backlog)
+}
+
+func TestReplayBuffer_SlowListenerIsClosed(t *testing.T) {
+	r := NewReplayBuffer(1000, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, live, _ := r.Attach(ctx, "alice", 0)
+
+	for i := 0; i < 100; i++ {
+		r.Add(Event{Type: EventReadingProgress, UserID: "alice", Timestamp: time.Now()})
+	}
+
+	n := 0
+	for range live {
+		n++
+	}
+	assert.Less(t, n, 100)
+}
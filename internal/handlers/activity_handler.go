@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/middleware"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/services"
+)
+
+// ActivityHandler — лента активности и её настройки видимости.
+type ActivityHandler struct {
+	svc       services.ActivityService
+	validator *validator.Validate
+}
+
+func NewActivityHandler(svc services.ActivityService, validator *validator.Validate) *ActivityHandler {
+	return &ActivityHandler{svc: svc, validator: validator}
+}
+
+// pageParams читает limit/offset (по умолчанию 20, не больше 100)
+func pageParams(c *gin.Context) (int, int) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 {
+		limit = 20
+	}
+	limit = min(limit, 100)
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+func pageResponse(data interface{}, limit, offset int, total int64) models.ListResponseDTO {
+	lastPage := int(total) / limit
+	if int(total)%limit != 0 {
+		lastPage++
+	}
+	return models.ListResponseDTO{
+		Data: data,
+		Pagination: &models.PaginationDTO{
+			Page:     offset/limit + 1,
+			Limit:    limit,
+			Total:    total,
+			LastPage: lastPage,
+		},
+	}
+}
+
+// GetFeed godoc
+// @Summary      Лента подписок
+// @Description  События тех, на кого подписан пользователь, новые первыми: дочитанные книги, рецензии,
+// @Description  открытые коллекции и челленджи. Виды событий, скрытые автором, в ленту не попадают.
+// @Tags         Social
+// @Produce      json
+// @Security     BearerAuth
+// @Param        limit   query  int  false  "Событий на странице"  minimum(1)  maximum(100)
+// @Param        offset  query  int  false  "Смещение"             minimum(0)
+// @Success      200  {object}  models.ListResponseDTO{Data=[]models.ActivityDTO}
+// @Failure      401  {object}  models.ErrorResponseDTO
+// @Router       /me/feed [get]
+func (h *ActivityHandler) GetFeed(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	limit, offset := pageParams(c)
+	items, total, err := h.svc.Feed(userID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка получения ленты", Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, pageResponse(items, limit, offset, total))
+}
+
+// GetUserActivity godoc
+// @Summary      События пользователя
+// @Description  Свои события видны все, чужие — только тех видов, которые автор не скрыл.
+// @Tags         Social
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id      path   string  true   "ID пользователя"
+// @Param        limit   query  int     false  "Событий на странице"  minimum(1)  maximum(100)
+// @Param        offset  query  int     false  "Смещение"             minimum(0)
+// @Success      200  {object}  models.ListResponseDTO{Data=[]models.ActivityDTO}
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Router       /users/{id}/activity [get]
+func (h *ActivityHandler) GetUserActivity(c *gin.Context) {
+	viewerID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат ID пользователя"})
+		return
+	}
+	limit, offset := pageParams(c)
+	items, total, err := h.svc.UserActivity(viewerID, userID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка получения событий", Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, pageResponse(items, limit, offset, total))
+}
+
+// GetPrivacy godoc
+// @Summary      Видимость событий для подписчиков
+// @Tags         Social
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  models.ActivityPrivacyDTO
+// @Router       /me/activity-privacy [get]
+func (h *ActivityHandler) GetPrivacy(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	privacy, err := h.svc.GetPrivacy(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка получения настроек", Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, privacy)
+}
+
+// UpdatePrivacy godoc
+// @Summary      Изменить видимость событий
+// @Description  Передаются только меняющиеся виды: {"shared": {"book_finished": false}}. Настройка действует
+// @Description  и на прошлые события: скрытые пропадают из лент подписчиков, снова открытые возвращаются.
+// @Tags         Social
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body  body  models.ActivityPrivacyDTO  true  "Видимость по видам событий"
+// @Success      200  {object}  models.ActivityPrivacyDTO
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Router       /me/activity-privacy [put]
+func (h *ActivityHandler) UpdatePrivacy(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	var dto models.ActivityPrivacyDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат данных", Message: err.Error()})
+		return
+	}
+	if err := h.validator.Struct(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Ошибка валидации", Message: err.Error()})
+		return
+	}
+	privacy, err := h.svc.UpdatePrivacy(userID, &dto)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка сохранения настроек", Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, privacy)
+}
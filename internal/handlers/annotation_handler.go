@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/middleware"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/services"
+)
+
+// AnnotationHandler — выделения и заметки в книгах.
+type AnnotationHandler struct {
+	svc           services.AnnotationService
+	accessService services.BookAccessService
+	validator     *validator.Validate
+}
+
+func NewAnnotationHandler(svc services.AnnotationService, accessService services.BookAccessService, validator *validator.Validate) *AnnotationHandler {
+	return &AnnotationHandler{svc: svc, accessService: accessService, validator: validator}
+}
+
+// exportContentTypes — тип содержимого и расширение файла выгрузки
+var exportContentTypes = map[models.AnnotationExportFormat][2]string{
+	models.ExportMarkdown:  {"text/markdown; charset=utf-8", "md"},
+	models.ExportJSON:      {"application/json; charset=utf-8", "json"},
+	models.ExportClippings: {"text/plain; charset=utf-8", "txt"},
+}
+
+// filter собирает фильтр из параметров book_id, type, color, tag, limit и offset
+func (h *AnnotationHandler) filter(c *gin.Context) (models.AnnotationFilter, bool) {
+	filter := models.AnnotationFilter{
+		Type:  models.AnnotationType(c.Query("type")),
+		Color: models.AnnotationColor(c.Query("color")),
+		Tag:   c.Query("tag"),
+	}
+	filter.Limit, filter.Offset = pageParams(c)
+	if raw := c.Query("book_id"); raw != "" {
+		bookID, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат ID книги"})
+			return filter, false
+		}
+		filter.BookID = &bookID
+	}
+	return filter, true
+}
+
+// canRead — есть ли у пользователя доступ к книге; администраторы и
+// библиотекари видят все книги, как в BookFileHandler.authorizeFile
+func (h *AnnotationHandler) canRead(c *gin.Context, userID, bookID uuid.UUID) bool {
+	if isStaff(c) {
+		return true
+	}
+	hasAccess, _ := h.accessService.CheckAccess(userID, bookID)
+	return hasAccess
+}
+
+func isStaff(c *gin.Context) bool {
+	roleVal, _ := c.Get("user_role")
+	role, _ := roleVal.(models.UserRole)
+	return role == models.RoleAdmin || role == models.RoleLibrarian
+}
+
+// list отдаёт страницу аннотаций по фильтру
+func (h *AnnotationHandler) list(c *gin.Context, viewerID uuid.UUID, filter models.AnnotationFilter) {
+	annotations, total, err := h.svc.List(viewerID, filter)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, pageResponse(annotations, filter.Limit, filter.Offset, total))
+}
+
+func (h *AnnotationHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrAnnotationNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Аннотация не найдена"})
+	case errors.Is(err, services.ErrAnnotationForbidden):
+		c.JSON(http.StatusForbidden, models.ErrorResponseDTO{Error: "Аннотация принадлежит другому пользователю"})
+	case errors.Is(err, services.ErrAnnotationEmpty), errors.Is(err, models.ErrInvalidLocator):
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверная аннотация", Message: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка обработки аннотации", Message: err.Error()})
+	}
+}
+
+// CreateAnnotation godoc
+// @Summary      Создать выделение или заметку
+// @Description  Место задаётся локатором или строкой location, как у закладок. Видимость: private (по умолчанию),
+// @Description  followers — подписчикам автора, public — всем.
+// @Tags         Annotations
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body  body  models.CreateAnnotationDTO  true  "Аннотация"
+// @Success      201  {object}  models.Annotation
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Failure      403  {object}  models.ErrorResponseDTO
+// @Router       /annotations [post]
+func (h *AnnotationHandler) CreateAnnotation(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	var dto models.CreateAnnotationDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат данных", Message: err.Error()})
+		return
+	}
+	if err := h.validator.Struct(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Ошибка валидации", Message: err.Error()})
+		return
+	}
+	if !h.canRead(c, userID, dto.BookID) {
+		c.JSON(http.StatusForbidden, models.ErrorResponseDTO{Error: "Нет доступа к этой книге"})
+		return
+	}
+
+	annotation, err := h.svc.Create(userID, &dto)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, annotation)
+}
+
+// ListMyAnnotations godoc
+// @Summary      Мои аннотации по всей библиотеке
+// @Tags         Annotations
+// @Produce      json
+// @Security     BearerAuth
+// @Param        book_id  query  string  false  "ID книги"
+// @Param        type     query  string  false  "highlight или note"
+// @Param        color    query  string  false  "Цвет"
+// @Param        tag      query  string  false  "Тег"
+// @Param        limit    query  int     false  "Аннотаций на странице"  minimum(1)  maximum(100)
+// @Param        offset   query  int     false  "Смещение"              minimum(0)
+// @Success      200  {object}  models.ListResponseDTO
+// @Router       /annotations [get]
+func (h *AnnotationHandler) ListMyAnnotations(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	filter, ok := h.filter(c)
+	if !ok {
+		return
+	}
+	filter.UserID = &userID
+	h.list(c, userID, filter)
+}
+
+// ListBookAnnotations godoc
+// @Summary      Аннотации к книге
+// @Description  Свои аннотации, публичные и «для подписчиков» тех, на кого подписан пользователь.
+// @Description  Нужен доступ к книге.
+// @Tags         Annotations
+// @Produce      json
+// @Security     BearerAuth
+// @Param        book_id  path   string  true   "ID книги"
+// @Param        type     query  string  false  "highlight или note"
+// @Param        color    query  string  false  "Цвет"
+// @Param        tag      query  string  false  "Тег"
+// @Param        limit    query  int     false  "Аннотаций на странице"  minimum(1)  maximum(100)
+// @Param        offset   query  int     false  "Смещение"              minimum(0)
+// @Success      200  {object}  models.ListResponseDTO
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Failure      403  {object}  models.ErrorResponseDTO
+// @Router       /annotations/book/{book_id} [get]
+func (h *AnnotationHandler) ListBookAnnotations(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	bookID, err := uuid.Parse(c.Param("book_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат ID книги"})
+		return
+	}
+	filter, ok := h.filter(c)
+	if !ok {
+		return
+	}
+	if !h.canRead(c, userID, bookID) {
+		c.JSON(http.StatusForbidden, models.ErrorResponseDTO{Error: "Нет доступа к этой книге"})
+		return
+	}
+	filter.BookID = &bookID
+	h.list(c, userID, filter)
+}
+
+// ListUserAnnotations godoc
+// @Summary      Аннотации пользователя, видимые текущему
+// @Description  Чужие аннотации — только к книгам, к которым у текущего пользователя есть доступ.
+// @Tags         Annotations
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id       path   string  true   "ID пользователя"
+// @Param        book_id  query  string  false  "ID книги"
+// @Param        limit    query  int     false  "Аннотаций на странице"  minimum(1)  maximum(100)
+// @Param        offset   query  int     false  "Смещение"              minimum(0)
+// @Success      200  {object}  models.ListResponseDTO
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Router       /users/{id}/annotations [get]
+func (h *AnnotationHandler) ListUserAnnotations(c *gin.Context) {
+	viewerID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	ownerID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат ID пользователя"})
+		return
+	}
+	filter, ok := h.filter(c)
+	if !ok {
+		return
+	}
+	filter.UserID = &ownerID
+	// Чужие аннотации цитируют текст книги — только по книгам, доступным зрителю
+	if ownerID != viewerID && !isStaff(c) {
+		filter.ReadableBy = &viewerID
+	}
+	h.list(c, viewerID, filter)
+}
+
+// GetAnnotation godoc
+// @Summary      Получить аннотацию
+// @Tags         Annotations
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id  path  string  true  "ID аннотации"
+// @Success      200  {object}  models.Annotation
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /annotations/{id} [get]
+func (h *AnnotationHandler) GetAnnotation(c *gin.Context) {
+	userID, id, ok := h.target(c)
+	if !ok {
+		return
+	}
+	annotation, err := h.svc.Get(userID, id)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	if annotation.UserID != userID && !h.canRead(c, userID, annotation.BookID) {
+		h.writeError(c, services.ErrAnnotationNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, annotation)
+}
+
+// UpdateAnnotation godoc
+// @Summary      Изменить аннотацию
+// @Description  Меняются текст, заметка, цвет, теги и видимость; место в книге не меняется.
+// @Tags         Annotations
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id    path  string                      true  "ID аннотации"
+// @Param        body  body  models.UpdateAnnotationDTO  true  "Изменения"
+// @Success      200  {object}  models.Annotation
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Failure      403  {object}  models.ErrorResponseDTO
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /annotations/{id} [put]
+func (h *AnnotationHandler) UpdateAnnotation(c *gin.Context) {
+	userID, id, ok := h.target(c)
+	if !ok {
+		return
+	}
+	var dto models.UpdateAnnotationDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат данных", Message: err.Error()})
+		return
+	}
+	if err := h.validator.Struct(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Ошибка валидации", Message: err.Error()})
+		return
+	}
+	annotation, err := h.svc.Update(userID, id, &dto)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, annotation)
+}
+
+// DeleteAnnotation godoc
+// @Summary      Удалить аннотацию
+// @Tags         Annotations
+// @Security     BearerAuth
+// @Param        id  path  string  true  "ID аннотации"
+// @Success      204
+// @Failure      403  {object}  models.ErrorResponseDTO
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /annotations/{id} [delete]
+func (h *AnnotationHandler) DeleteAnnotation(c *gin.Context) {
+	userID, id, ok := h.target(c)
+	if !ok {
+		return
+	}
+	if err := h.svc.Delete(userID, id); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ExportAnnotations godoc
+// @Summary      Выгрузить мои аннотации
+// @Description  format: markdown, json или clippings (формат «My Clippings.txt» Kindle).
+// @Tags         Annotations
+// @Produce      plain
+// @Security     BearerAuth
+// @Param        format   query  string  true   "markdown, json или clippings"
+// @Param        book_id  query  string  false  "ID книги"
+// @Param        tag      query  string  false  "Тег"
+// @Success      200  {file}    file
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Router       /annotations/export [get]
+func (h *AnnotationHandler) ExportAnnotations(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	format := models.AnnotationExportFormat(c.DefaultQuery("format", string(models.ExportMarkdown)))
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неизвестный формат выгрузки", Message: "format: markdown, json или clippings"})
+		return
+	}
+	filter, ok := h.filter(c)
+	if !ok {
+		return
+	}
+	data, err := h.svc.Export(userID, filter, format)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	fileName := "annotations." + contentType[1]
+	if format == models.ExportClippings {
+		fileName = "My Clippings.txt"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	c.Data(http.StatusOK, contentType[0], data)
+}
+
+// target возвращает пользователя и ID аннотации из пути
+func (h *AnnotationHandler) target(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат ID аннотации"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userID, id, true
+}
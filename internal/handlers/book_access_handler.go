@@ -145,11 +145,21 @@ func (h *BookAccessHandler) UpdateProgress(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат ID"})
 		return
 	}
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
 	var dto models.UpdateReadingProgressDTO
 	if err := c.ShouldBindJSON(&dto); err != nil {
 		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат данных", Message: err.Error()})
 		return
 	}
+	// Чужая выдача неотличима от несуществующей
+	if access, err := h.accessService.GetByID(id); err != nil || access.UserID != userID {
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Доступ не найден"})
+		return
+	}
 	if err := h.accessService.UpdateProgress(id, dto.CurrentPage, 0); err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка обновления прогресса", Message: err.Error()})
 		return
@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"errors"
+	"io"
+	"log"
+	"mime"
 	"net/http"
-	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
@@ -14,23 +19,29 @@ import (
 )
 
 type BookFileHandler struct {
-	fileService   services.BookFileService
-	accessService services.BookAccessService
-	fileStorage   storage.FileStorage
-	validator     *validator.Validate
+	fileService      services.BookFileService
+	accessService    services.BookAccessService
+	downloadService  services.DownloadService
+	watermarkService services.WatermarkService
+	fileStorage      storage.FileStorage
+	validator        *validator.Validate
 }
 
 func NewBookFileHandler(
 	fileService services.BookFileService,
 	accessService services.BookAccessService,
+	downloadService services.DownloadService,
+	watermarkService services.WatermarkService,
 	fileStorage storage.FileStorage,
 	validator *validator.Validate,
 ) *BookFileHandler {
 	return &BookFileHandler{
-		fileService:   fileService,
-		accessService: accessService,
-		fileStorage:   fileStorage,
-		validator:     validator,
+		fileService:      fileService,
+		accessService:    accessService,
+		downloadService:  downloadService,
+		watermarkService: watermarkService,
+		fileStorage:      fileStorage,
+		validator:        validator,
 	}
 }
 
@@ -50,13 +61,31 @@ func (h *BookFileHandler) Upload(c *gin.Context) {
 
 	bookFile, err := h.fileService.Upload(bookID, file, header)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Ошибка загрузки файла", Message: err.Error()})
+		if !writeFileCheckError(c, err) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Ошибка загрузки файла", Message: err.Error()})
+		}
 		return
 	}
 
 	c.JSON(http.StatusCreated, bookFile)
 }
 
+// writeFileCheckError отвечает на отказ проверки содержимого файла;
+// false — ошибка другого рода
+func writeFileCheckError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrFileContentInvalid):
+		c.JSON(http.StatusUnprocessableEntity, models.ErrorResponseDTO{Error: "Содержимое файла не соответствует формату", Message: err.Error()})
+	case errors.Is(err, services.ErrFileInfected):
+		c.JSON(http.StatusUnprocessableEntity, models.ErrorResponseDTO{Error: "Файл не прошёл антивирусную проверку", Message: err.Error()})
+	case errors.Is(err, services.ErrFileScanFailed):
+		c.JSON(http.StatusServiceUnavailable, models.ErrorResponseDTO{Error: "Антивирусная проверка недоступна", Message: "повторите загрузку позже"})
+	default:
+		return false
+	}
+	return true
+}
+
 func (h *BookFileHandler) GetByBookID(c *gin.Context) {
 	bookID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
@@ -74,6 +103,28 @@ func (h *BookFileHandler) GetByBookID(c *gin.Context) {
 }
 
 func (h *BookFileHandler) ServeFile(c *gin.Context) {
+	bookFile, ok := h.authorizeFile(c)
+	if !ok {
+		return
+	}
+	h.streamFile(c, bookFile, models.DownloadModeView, nil)
+}
+
+// CreateLink godoc
+// @Summary Подписанная ссылка на файл
+// @Description Короткоживущая ссылка, которая открывается без заголовка Authorization (обычный <a href>, внешняя читалка). Режим view отдаёт файл inline, download — вложением и расходует квоту скачиваний тарифа или группы
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID файла"
+// @Param body body models.CreateDownloadLinkDTO false "Режим ссылки"
+// @Success 201 {object} models.DownloadLinkDTO
+// @Failure 403 {object} models.ErrorResponseDTO
+// @Failure 404 {object} models.ErrorResponseDTO
+// @Failure 429 {object} models.ErrorResponseDTO
+// @Router /files/{id}/link [post]
+func (h *BookFileHandler) CreateLink(c *gin.Context) {
 	fileID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат ID файла"})
@@ -85,23 +136,125 @@ func (h *BookFileHandler) ServeFile(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
 		return
 	}
+	roleVal, _ := c.Get("user_role")
+	role, _ := roleVal.(models.UserRole)
 
-	bookFile, err := h.fileService.GetByID(fileID)
+	var dto models.CreateDownloadLinkDTO
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&dto); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат данных", Message: err.Error()})
+			return
+		}
+		if err := h.validator.Struct(&dto); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Ошибка валидации", Message: err.Error()})
+			return
+		}
+	}
+	if dto.Mode == "" {
+		dto.Mode = models.DownloadModeView
+	}
+
+	link, err := h.downloadService.IssueLink(userID, role, fileID, dto.Mode)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Файл не найден"})
+		writeDownloadError(c, err)
 		return
 	}
 
-	// Admins and librarians always have access
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, link)
+}
+
+// ViewSigned godoc
+// @Summary Просмотр файла по подписанной ссылке
+// @Description Отдает файл inline; параметры uid, lid, exp, sig выдает POST /files/{id}/link
+// @Tags files
+// @Produce octet-stream
+// @Param id path string true "ID файла"
+// @Success 200 {file} file
+// @Failure 403 {object} models.ErrorResponseDTO
+// @Router /files/{id}/view [get]
+func (h *BookFileHandler) ViewSigned(c *gin.Context) {
+	h.serveSigned(c, models.DownloadModeView)
+}
+
+// DownloadSigned godoc
+// @Summary Скачивание файла по подписанной ссылке
+// @Description Отдает файл вложением и учитывает скачивание в квоте (один раз на ссылку, докачка не расходует квоту). PDF и EPUB помечаются водяным знаком пользователя
+// @Tags files
+// @Produce octet-stream
+// @Param id path string true "ID файла"
+// @Success 200 {file} file
+// @Failure 403 {object} models.ErrorResponseDTO
+// @Failure 429 {object} models.ErrorResponseDTO
+// @Router /files/{id}/download [get]
+func (h *BookFileHandler) DownloadSigned(c *gin.Context) {
+	h.serveSigned(c, models.DownloadModeDownload)
+}
+
+// GetDownloadQuota godoc
+// @Summary Квота скачиваний
+// @Description Лимит и остаток скачиваний текущего пользователя за период; limit -1 — без ограничений, 0 — скачивание недоступно
+// @Tags files
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.DownloadQuotaDTO
+// @Router /downloads/quota [get]
+func (h *BookFileHandler) GetDownloadQuota(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
 	roleVal, _ := c.Get("user_role")
 	role, _ := roleVal.(models.UserRole)
-	if role != models.RoleAdmin && role != models.RoleLibrarian {
-		hasAccess, _ := h.accessService.CheckAccess(userID, bookFile.BookID)
-		if !hasAccess {
-			c.JSON(http.StatusForbidden, models.ErrorResponseDTO{Error: "Нет доступа к этой книге"})
-			return
-		}
+
+	quota, err := h.downloadService.GetQuota(userID, role)
+	if err != nil {
+		writeDownloadError(c, err)
+		return
 	}
+	c.JSON(http.StatusOK, quota)
+}
+
+func (h *BookFileHandler) serveSigned(c *gin.Context, mode models.DownloadMode) {
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат ID файла"})
+		return
+	}
+
+	bookFile, user, err := h.downloadService.Resolve(fileID, mode, c.Request.URL.Query())
+	if err != nil {
+		writeDownloadError(c, err)
+		return
+	}
+	h.streamFile(c, bookFile, mode, user)
+}
+
+func writeDownloadError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrDownloadFileNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Файл не найден"})
+	case errors.Is(err, services.ErrDownloadLinkInvalid):
+		c.JSON(http.StatusForbidden, models.ErrorResponseDTO{Error: "Ссылка недействительна или истекла"})
+	case errors.Is(err, services.ErrDownloadNoAccess):
+		c.JSON(http.StatusForbidden, models.ErrorResponseDTO{Error: "Нет доступа к этой книге"})
+	case errors.Is(err, services.ErrDownloadNotAllowed):
+		c.JSON(http.StatusForbidden, models.ErrorResponseDTO{Error: "Скачивание не входит в тариф или группу"})
+	case errors.Is(err, services.ErrDownloadLimitReached):
+		c.JSON(http.StatusTooManyRequests, models.ErrorResponseDTO{Error: "Исчерпан лимит скачиваний за период"})
+	case errors.Is(err, services.ErrDownloadBadMode):
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неизвестный режим ссылки", Message: "допустимо: view, download"})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка сервера", Message: err.Error()})
+	}
+}
+
+// streamFile отдает файл inline (view) или вложением (download): редиректом на
+// прямую ссылку хранилища, если оно это умеет, иначе через сервер. При
+// скачивании отдаётся копия с водяным знаком пользователя user.
+func (h *BookFileHandler) streamFile(c *gin.Context, bookFile *models.BookFile, mode models.DownloadMode, user *models.User) {
+	fileID := bookFile.ID
 
 	filePath, mimeType, err := h.fileService.ServeFile(fileID)
 	if err != nil {
@@ -109,15 +262,55 @@ func (h *BookFileHandler) ServeFile(c *gin.Context) {
 		return
 	}
 
+	// Путь в хранилище — хеш содержимого без расширения, имя берём из записи
+	disposition := mime.FormatMediaType("inline", map[string]string{"filename": bookFile.FileName})
+	if mode == models.DownloadModeDownload {
+		disposition = mime.FormatMediaType("attachment", map[string]string{"filename": bookFile.OriginalName})
+	}
+
+	if mode == models.DownloadModeDownload && user != nil {
+		marked, err := h.watermarkService.Prepare(bookFile, user)
+		switch {
+		case errors.Is(err, services.ErrWatermarkUnsupported):
+			// MOBI и зашифрованные PDF пометить нельзя — отдаём как есть
+			log.Printf("[book_file] %s is served without a watermark: %v", fileID, err)
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка подготовки файла", Message: err.Error()})
+			return
+		case marked.Temp != nil:
+			defer func() { _ = marked.Temp.Close() }()
+			h.serveObject(c, marked.Temp, mimeType, disposition)
+			return
+		default:
+			filePath = marked.Path
+		}
+	}
+
+	// Хранилище с прямыми ссылками (S3) отдаёт файл само, минуя сервер
+	if presigner, ok := h.fileStorage.(storage.Presigner); ok {
+		url, err := presigner.PresignGet(filePath, storage.PresignOptions{ContentType: mimeType, ContentDisposition: disposition})
+		if err == nil {
+			c.Header("Cache-Control", "no-store")
+			c.Redirect(http.StatusFound, url)
+			return
+		}
+		if !errors.Is(err, storage.ErrPresignDisabled) {
+			log.Printf("[book_file] presign failed for %s, streaming instead: %v", fileID, err)
+		}
+	}
+
 	file, err := h.fileStorage.Get(filePath)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка чтения файла", Message: err.Error()})
 		return
 	}
 	defer func() { _ = file.Close() }()
+	h.serveObject(c, file, mimeType, disposition)
+}
 
+func (h *BookFileHandler) serveObject(c *gin.Context, file storage.Object, mimeType, disposition string) {
 	c.Header("Content-Type", mimeType)
-	c.Header("Content-Disposition", "inline; filename="+filepath.Base(filePath))
+	c.Header("Content-Disposition", disposition)
 
 	fileInfo, err := file.Stat()
 	if err != nil {
@@ -128,6 +321,154 @@ func (h *BookFileHandler) ServeFile(c *gin.Context) {
 	http.ServeContent(c.Writer, c.Request, fileInfo.Name(), fileInfo.ModTime(), file)
 }
 
+// authorizeFile загружает файл из :id и проверяет доступ пользователя к книге.
+// При отказе ответ уже записан.
+func (h *BookFileHandler) authorizeFile(c *gin.Context) (*models.BookFile, bool) {
+	fileID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат ID файла"})
+		return nil, false
+	}
+
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return nil, false
+	}
+
+	bookFile, err := h.fileService.GetByID(fileID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Файл не найден"})
+		return nil, false
+	}
+
+	// Admins and librarians always have access
+	roleVal, _ := c.Get("user_role")
+	role, _ := roleVal.(models.UserRole)
+	if role != models.RoleAdmin && role != models.RoleLibrarian {
+		hasAccess, _ := h.accessService.CheckAccess(userID, bookFile.BookID)
+		if !hasAccess {
+			c.JSON(http.StatusForbidden, models.ErrorResponseDTO{Error: "Нет доступа к этой книге"})
+			return nil, false
+		}
+	}
+
+	return bookFile, true
+}
+
+// GetMetadata godoc
+// @Summary Метаданные файла
+// @Description Разобранные метаданные файла: оглавление, spine и ресурсы EPUB, outline PDF
+// @Tags files
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "ID файла"
+// @Success 200 {object} object
+// @Failure 403 {object} models.ErrorResponseDTO
+// @Failure 404 {object} models.ErrorResponseDTO
+// @Router /files/{id}/metadata [get]
+func (h *BookFileHandler) GetMetadata(c *gin.Context) {
+	bookFile, ok := h.authorizeFile(c)
+	if !ok {
+		return
+	}
+
+	if bookFile.Metadata == nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Файл ещё не обработан"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, no-cache")
+	c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(*bookFile.Metadata))
+}
+
+// ServeEPUBResource godoc
+// @Summary Ресурс из архива EPUB
+// @Description Отдает отдельный файл из архива EPUB (главу, стиль, картинку, шрифт) по пути внутри архива
+// @Description HTML, XHTML и SVG отдаются с Content-Security-Policy: sandbox — скрипты глав не выполняются
+// @Tags files
+// @Produce octet-stream
+// @Security BearerAuth
+// @Param id path string true "ID файла"
+// @Param path path string true "Путь внутри архива, например OEBPS/chapter1.xhtml"
+// @Success 200 {file} file
+// @Success 304
+// @Failure 403 {object} models.ErrorResponseDTO
+// @Failure 404 {object} models.ErrorResponseDTO
+// @Router /files/{id}/epub/{path} [get]
+func (h *BookFileHandler) ServeEPUBResource(c *gin.Context) {
+	bookFile, ok := h.authorizeFile(c)
+	if !ok {
+		return
+	}
+
+	res, err := h.fileService.OpenEPUBResource(bookFile.ID, c.Param("path"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrNotEPUB):
+			c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Файл не является EPUB"})
+		case errors.Is(err, services.ErrEPUBResourceNotFound):
+			c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Ресурс не найден"})
+		default:
+			c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка чтения файла", Message: err.Error()})
+		}
+		return
+	}
+	defer func() { _ = res.Close() }()
+
+	// Содержимое архива не меняется, пока не изменился хеш файла
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Header("ETag", res.ETag)
+	c.Header("Last-Modified", res.ModTime.UTC().Format(http.TimeFormat))
+	c.Header("X-Content-Type-Options", "nosniff")
+	if isMarkupType(res.ContentType) {
+		// Главы загружены пользователями и отдаются с нашего origin: песочница
+		// без скриптов и с тем же запретом на внешние ресурсы, иначе это stored XSS
+		c.Header("Content-Security-Policy", epubMarkupCSP)
+	}
+
+	if match := c.GetHeader("If-None-Match"); match != "" && etagMatches(match, res.ETag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Header("Content-Length", strconv.FormatInt(res.Size, 10))
+	c.Header("Content-Type", res.ContentType)
+	c.Status(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+	_, _ = io.Copy(c.Writer, res)
+}
+
+// epubMarkupCSP — политика для HTML/XHTML/SVG из EPUB: картинки и стили
+// книги разрешены, скрипты, формы и сетевые запросы — нет
+const epubMarkupCSP = "sandbox; default-src 'none'; img-src 'self' data:; style-src 'self' 'unsafe-inline'"
+
+// isMarkupType — типы, которые браузер отрисует как документ и в которых
+// может выполниться скрипт
+func isMarkupType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	switch mediaType {
+	case "text/html", "application/xhtml+xml", "image/svg+xml", "application/xml", "text/xml":
+		return true
+	}
+	return false
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *BookFileHandler) Delete(c *gin.Context) {
 	id, err := uuid.Parse(c.Param("id"))
 	if err != nil {
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -52,8 +53,15 @@ func (h *BookmarkHandler) CreateBookmark(c *gin.Context) {
 		Location: dto.Location,
 		Label:    dto.Label,
 	}
+	if dto.Locator != nil {
+		bookmark.Locator = *dto.Locator
+	}
 
 	if err := h.service.CreateBookmark(bookmark); err != nil {
+		if errors.Is(err, models.ErrInvalidLocator) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: err.Error()})
 		return
 	}
@@ -50,6 +50,7 @@ func (h *CollectionHandler) CreateCollection(c *gin.Context) {
 		UserID:      userID,
 		Name:        dto.Name,
 		Description: dto.Description,
+		IsPublic:    dto.IsPublic,
 	}
 
 	if err := h.service.CreateCollection(collection); err != nil {
@@ -88,7 +89,7 @@ func (h *CollectionHandler) GetCollections(c *gin.Context) {
 
 // GetCollectionByID godoc
 // @Summary		Get a collection by ID
-// @Description	Retrieves a single collection by its ID.
+// @Description	Retrieves a single collection by its ID. Private collections are only visible to their owner.
 // @Tags			Collections
 // @Produce		json
 // @Security		BearerAuth
@@ -111,12 +112,19 @@ func (h *CollectionHandler) GetCollectionByID(c *gin.Context) {
 		return
 	}
 
+	// Private collections are visible to their owner only
+	userID, _ := middleware.GetUserFromContext(c)
+	if !collection.IsPublic && collection.UserID != userID {
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Collection not found"})
+		return
+	}
+
 	c.JSON(http.StatusOK, collection)
 }
 
 // UpdateCollection godoc
 // @Summary		Update a collection
-// @Description	Updates a collection's name and/or description.
+// @Description	Updates a collection's name, description and/or visibility. Public collections appear on the profile and in followers' feeds.
 // @Tags			Collections
 // @Accept			json
 // @Produce		json
@@ -162,6 +170,9 @@ func (h *CollectionHandler) UpdateCollection(c *gin.Context) {
 	if dto.Description != nil {
 		collection.Description = *dto.Description
 	}
+	if dto.IsPublic != nil {
+		collection.IsPublic = *dto.IsPublic
+	}
 
 	if err := h.service.UpdateCollection(collection); err != nil {
 		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: err.Error()})
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/services"
+)
+
+// CoverHandler — обложки книг: публичная отдача и управление (библиотекарь/админ).
+type CoverHandler struct {
+	svc services.CoverService
+}
+
+func NewCoverHandler(svc services.CoverService) *CoverHandler {
+	return &CoverHandler{svc: svc}
+}
+
+func writeCoverError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrCoverNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Обложка не найдена"})
+	case errors.Is(err, services.ErrCoverBookNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Книга не найдена"})
+	case errors.Is(err, services.ErrCoverBadSize):
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неизвестный размер обложки", Message: "допустимо: small, medium, large, original"})
+	case errors.Is(err, services.ErrCoverInvalid):
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Обложка должна быть изображением JPEG, PNG или WebP", Message: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка сервера", Message: err.Error()})
+	}
+}
+
+func parseCoverBookID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат ID книги"})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// GetCover godoc
+// @Summary      Обложка книги
+// @Description  Публичная отдача обложки с ETag. Пока миниатюры не построены, вместо них отдаётся оригинал.
+// @Tags         Covers
+// @Produce      image/jpeg,image/png,image/webp
+// @Param        id    path   string  true   "ID книги"
+// @Param        size  query  string  false  "Размер"  Enums(small, medium, large, original)
+// @Success      200  {file}    file
+// @Success      304
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /books/{id}/cover [get]
+func (h *CoverHandler) GetCover(c *gin.Context) {
+	bookID, ok := parseCoverBookID(c)
+	if !ok {
+		return
+	}
+
+	img, err := h.svc.Open(bookID, c.Query("size"))
+	if err != nil {
+		writeCoverError(c, err)
+		return
+	}
+	defer func() { _ = img.Close() }()
+
+	if img.Provisional {
+		c.Header("Cache-Control", "public, max-age=60")
+	} else {
+		c.Header("Cache-Control", "public, max-age=86400")
+	}
+	c.Header("ETag", img.ETag)
+	c.Header("Last-Modified", img.ModTime.UTC().Format(http.TimeFormat))
+	c.Header("X-Content-Type-Options", "nosniff")
+
+	if match := c.GetHeader("If-None-Match"); match != "" && etagMatches(match, img.ETag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Header("Content-Length", strconv.FormatInt(img.Size, 10))
+	c.Header("Content-Type", img.ContentType)
+	c.Status(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+	_, _ = io.Copy(c.Writer, img)
+}
+
+// UploadCover godoc
+// @Summary      Загрузить обложку
+// @Description  Заменяет обложку книги. Загруженная вручную обложка не перезаписывается извлечённой из файла книги.
+// @Tags         Covers
+// @Accept       multipart/form-data
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id    path      string  true  "ID книги"
+// @Param        file  formData  file    true  "JPEG, PNG или WebP до 10 МБ"
+// @Success      200  {object}  models.BookCover
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /books/{id}/cover [put]
+func (h *CoverHandler) UploadCover(c *gin.Context) {
+	bookID, ok := parseCoverBookID(c)
+	if !ok {
+		return
+	}
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Файл не найден", Message: err.Error()})
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	cover, err := h.svc.Upload(bookID, file, header)
+	if err != nil {
+		writeCoverError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cover)
+}
+
+// DeleteCover godoc
+// @Summary      Удалить обложку
+// @Tags         Covers
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id  path  string  true  "ID книги"
+// @Success      200  {object}  models.SuccessResponseDTO
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /books/{id}/cover [delete]
+func (h *CoverHandler) DeleteCover(c *gin.Context) {
+	bookID, ok := parseCoverBookID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.svc.Delete(bookID); err != nil {
+		writeCoverError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponseDTO{Message: "Обложка удалена"})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -281,7 +282,14 @@ func (h *Handlers) ExtCreateBookmark(c *gin.Context) {
 		Location: dto.Location,
 		Label:    dto.Label,
 	}
+	if dto.Locator != nil {
+		bookmark.Locator = *dto.Locator
+	}
 	if err := h.Services.Bookmark.CreateBookmark(bookmark); err != nil {
+		if errors.Is(err, models.ErrInvalidLocator) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверное место закладки", Message: err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка создания закладки", Message: err.Error()})
 		return
 	}
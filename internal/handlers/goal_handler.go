@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/middleware"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/services"
+)
+
+// GoalHandler — цели чтения и челленджи.
+type GoalHandler struct {
+	svc       services.GoalService
+	validator *validator.Validate
+}
+
+func NewGoalHandler(svc services.GoalService, validator *validator.Validate) *GoalHandler {
+	return &GoalHandler{svc: svc, validator: validator}
+}
+
+func (h *GoalHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrGoalNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Цель не найдена"})
+	case errors.Is(err, services.ErrChallengeNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Челлендж не найден"})
+	case errors.Is(err, services.ErrChallengeEnded):
+		c.JSON(http.StatusConflict, models.ErrorResponseDTO{Error: "Челлендж уже закончился"})
+	case errors.Is(err, services.ErrChallengeJoined):
+		c.JSON(http.StatusConflict, models.ErrorResponseDTO{Error: "Вы уже участвуете в челлендже"})
+	case errors.Is(err, services.ErrChallengeNotJoined):
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Вы не участвуете в челлендже"})
+	case errors.Is(err, services.ErrChallengeInvalidDate):
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Дата окончания должна быть позже даты начала"})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка обработки запроса", Message: err.Error()})
+	}
+}
+
+// bind разбирает и проверяет тело запроса
+func (h *GoalHandler) bind(c *gin.Context, dto interface{}) bool {
+	if err := c.ShouldBindJSON(dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат данных", Message: err.Error()})
+		return false
+	}
+	if err := h.validator.Struct(dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Ошибка валидации", Message: err.Error()})
+		return false
+	}
+	return true
+}
+
+func (h *GoalHandler) parseID(c *gin.Context, message string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: message})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// CreateGoal godoc
+// @Summary      Создать цель чтения
+// @Description  Метрика: books — дочитанные книги, minutes — минуты чтения, pages — прочитанные страницы.
+// @Description  Период: day, week (с понедельника) или year; дни считаются в часовом поясе timezone.
+// @Tags         Goals
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body  body  models.CreateGoalDTO  true  "Цель"
+// @Success      201  {object}  models.ReadingGoal
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Router       /me/goals [post]
+func (h *GoalHandler) CreateGoal(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	var dto models.CreateGoalDTO
+	if !h.bind(c, &dto) {
+		return
+	}
+	goal, err := h.svc.CreateGoal(userID, &dto)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, goal)
+}
+
+// ListGoals godoc
+// @Summary      Мои цели и прогресс
+// @Description  Прогресс в текущем периоде и серия периодов подряд, в которых цель выполнена.
+// @Tags         Goals
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  models.ListResponseDTO
+// @Router       /me/goals [get]
+func (h *GoalHandler) ListGoals(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	goals, err := h.svc.ListGoals(userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, models.ListResponseDTO{Data: goals})
+}
+
+// UpdateGoal godoc
+// @Summary      Изменить цель
+// @Tags         Goals
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id    path  string               true  "ID цели"
+// @Param        body  body  models.UpdateGoalDTO  true  "Изменения"
+// @Success      200  {object}  models.ReadingGoal
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /me/goals/{id} [put]
+func (h *GoalHandler) UpdateGoal(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	id, ok := h.parseID(c, "Неверный формат ID цели")
+	if !ok {
+		return
+	}
+	var dto models.UpdateGoalDTO
+	if !h.bind(c, &dto) {
+		return
+	}
+	goal, err := h.svc.UpdateGoal(userID, id, &dto)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, goal)
+}
+
+// DeleteGoal godoc
+// @Summary      Удалить цель
+// @Tags         Goals
+// @Security     BearerAuth
+// @Param        id  path  string  true  "ID цели"
+// @Success      204
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /me/goals/{id} [delete]
+func (h *GoalHandler) DeleteGoal(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	id, ok := h.parseID(c, "Неверный формат ID цели")
+	if !ok {
+		return
+	}
+	if err := h.svc.DeleteGoal(userID, id); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListChallenges godoc
+// @Summary      Челленджи
+// @Tags         Challenges
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  models.ListResponseDTO
+// @Router       /challenges [get]
+func (h *GoalHandler) ListChallenges(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	challenges, err := h.svc.ListChallenges(userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, models.ListResponseDTO{Data: challenges})
+}
+
+// GetChallenge godoc
+// @Summary      Челлендж
+// @Tags         Challenges
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id  path  string  true  "ID челленджа"
+// @Success      200  {object}  models.ChallengeDTO
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /challenges/{id} [get]
+func (h *GoalHandler) GetChallenge(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	id, ok := h.parseID(c, "Неверный формат ID челленджа")
+	if !ok {
+		return
+	}
+	challenge, err := h.svc.GetChallenge(userID, id)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, challenge)
+}
+
+// GetLeaderboard godoc
+// @Summary      Таблица лидеров челленджа
+// @Description  Участники по убыванию результата за время челленджа; при равном результате место общее.
+// @Tags         Challenges
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id  path  string  true  "ID челленджа"
+// @Success      200  {object}  models.ListResponseDTO
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /challenges/{id}/leaderboard [get]
+func (h *GoalHandler) GetLeaderboard(c *gin.Context) {
+	id, ok := h.parseID(c, "Неверный формат ID челленджа")
+	if !ok {
+		return
+	}
+	entries, err := h.svc.Leaderboard(id)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, models.ListResponseDTO{Data: entries})
+}
+
+// JoinChallenge godoc
+// @Summary      Участвовать в челлендже
+// @Tags         Challenges
+// @Security     BearerAuth
+// @Param        id  path  string  true  "ID челленджа"
+// @Success      204
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Failure      409  {object}  models.ErrorResponseDTO
+// @Router       /challenges/{id}/join [post]
+func (h *GoalHandler) JoinChallenge(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	id, ok := h.parseID(c, "Неверный формат ID челленджа")
+	if !ok {
+		return
+	}
+	if err := h.svc.Join(userID, id); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// LeaveChallenge godoc
+// @Summary      Выйти из челленджа
+// @Tags         Challenges
+// @Security     BearerAuth
+// @Param        id  path  string  true  "ID челленджа"
+// @Success      204
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /challenges/{id}/join [delete]
+func (h *GoalHandler) LeaveChallenge(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	id, ok := h.parseID(c, "Неверный формат ID челленджа")
+	if !ok {
+		return
+	}
+	if err := h.svc.Leave(userID, id); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// CreateChallenge godoc
+// @Summary      Создать челлендж (библиотекарь)
+// @Tags         Challenges
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body  body  models.CreateChallengeDTO  true  "Челлендж"
+// @Success      201  {object}  models.ReadingChallenge
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Router       /challenges [post]
+func (h *GoalHandler) CreateChallenge(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	var dto models.CreateChallengeDTO
+	if !h.bind(c, &dto) {
+		return
+	}
+	challenge, err := h.svc.CreateChallenge(userID, &dto)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, challenge)
+}
+
+// UpdateChallenge godoc
+// @Summary      Изменить челлендж (библиотекарь)
+// @Tags         Challenges
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id    path  string                    true  "ID челленджа"
+// @Param        body  body  models.UpdateChallengeDTO  true  "Изменения"
+// @Success      200  {object}  models.ReadingChallenge
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /challenges/{id} [put]
+func (h *GoalHandler) UpdateChallenge(c *gin.Context) {
+	id, ok := h.parseID(c, "Неверный формат ID челленджа")
+	if !ok {
+		return
+	}
+	var dto models.UpdateChallengeDTO
+	if !h.bind(c, &dto) {
+		return
+	}
+	challenge, err := h.svc.UpdateChallenge(id, &dto)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, challenge)
+}
+
+// DeleteChallenge godoc
+// @Summary      Удалить челлендж (библиотекарь)
+// @Tags         Challenges
+// @Security     BearerAuth
+// @Param        id  path  string  true  "ID челленджа"
+// @Success      204
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /challenges/{id} [delete]
+func (h *GoalHandler) DeleteChallenge(c *gin.Context) {
+	id, ok := h.parseID(c, "Неверный формат ID челленджа")
+	if !ok {
+		return
+	}
+	if err := h.svc.DeleteChallenge(id); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
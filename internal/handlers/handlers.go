@@ -17,6 +17,7 @@ type Handlers struct {
 	Subscription   *SubscriptionHandler
 	BookAccess     *BookAccessHandler
 	BookFile       *BookFileHandler
+	Upload         *UploadHandler
 	ReadingSession *ReadingSessionHandler
 	Setup          *SetupHandler
 	Collection     *CollectionHandler
@@ -24,7 +25,19 @@ type Handlers struct {
 	Bookmark       *BookmarkHandler
 	Social         *SocialHandler
 	SSE            *SSEHandler
+	WS             *WSHandler
 	APIKey         *APIKeyHandler
+	Webhook        *WebhookHandler
+	Job            *JobHandler
+	Scheduler      *SchedulerHandler
+	Cover          *CoverHandler
+	Search         *SearchHandler
+	Position       *ReadingPositionHandler
+	Annotation     *AnnotationHandler
+	Stats          *ReadingStatsHandler
+	Goal           *GoalHandler
+	Offline        *OfflineHandler
+	Activity       *ActivityHandler
 	Services       *services.Services
 }
 
@@ -40,6 +53,8 @@ func NewHandlers(services *services.Services, validator *validator.Validate) *Ha
 }
 
 func NewExtendedHandlers(services *services.Services, fileStorage storage.FileStorage, validator *validator.Validate, bus *events.Bus) *Handlers {
+	sse := NewSSEHandler(bus)
+
 	return &Handlers{
 		Auth:           NewAuthHandler(services.Auth, validator),
 		Book:           NewBookHandler(services.Book, validator),
@@ -49,15 +64,28 @@ func NewExtendedHandlers(services *services.Services, fileStorage storage.FileSt
 		Category:       NewCategoryHandler(services.Category, validator),
 		Subscription:   NewSubscriptionHandler(services.Subscription, validator),
 		BookAccess:     NewBookAccessHandler(services.BookAccess, validator),
-		BookFile:       NewBookFileHandler(services.BookFile, services.BookAccess, fileStorage, validator),
+		BookFile:       NewBookFileHandler(services.BookFile, services.BookAccess, services.Download, services.Watermark, fileStorage, validator),
+		Upload:         NewUploadHandler(services.Upload),
 		ReadingSession: NewReadingSessionHandler(services.ReadingSession, services.BookAccess, validator),
 		Setup:          NewSetupHandler(services.Auth, validator),
 		Collection:     NewCollectionHandler(services.Collection),
 		Review:         NewReviewHandler(services.Review),
 		Bookmark:       NewBookmarkHandler(services.Bookmark),
 		Social:         NewSocialHandler(services.Social),
-		SSE:            NewSSEHandler(bus),
+		SSE:            sse,
+		WS:             NewWSHandler(sse.replay, bus, services.BookAccess, services.ReadingSession),
 		APIKey:         NewAPIKeyHandler(services.APIKey, validator),
+		Webhook:        NewWebhookHandler(services.Webhook, validator),
+		Job:            NewJobHandler(services.Job),
+		Scheduler:      NewSchedulerHandler(services.Scheduler),
+		Cover:          NewCoverHandler(services.Cover),
+		Search:         NewSearchHandler(services.Search, services.BookAccess),
+		Position:       NewReadingPositionHandler(services.Position, services.BookAccess, validator),
+		Annotation:     NewAnnotationHandler(services.Annotation, services.BookAccess, validator),
+		Stats:          NewReadingStatsHandler(services.Stats),
+		Goal:           NewGoalHandler(services.Goal, validator),
+		Offline:        NewOfflineHandler(services.Offline, validator),
+		Activity:       NewActivityHandler(services.Activity, validator),
 		Services:       services,
 	}
 }
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/services"
+)
+
+// JobHandler — администрирование очереди фоновых задач (только admin).
+type JobHandler struct {
+	svc services.JobService
+}
+
+func NewJobHandler(svc services.JobService) *JobHandler {
+	return &JobHandler{svc: svc}
+}
+
+func writeJobError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrJobNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Задача не найдена"})
+	case errors.Is(err, services.ErrJobState):
+		c.JSON(http.StatusConflict, models.ErrorResponseDTO{Error: "Операция недоступна в текущем статусе задачи", Message: err.Error()})
+	case errors.Is(err, services.ErrJobBadStatus):
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неизвестный статус задачи", Message: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка сервера", Message: err.Error()})
+	}
+}
+
+func parseJobID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный ID задачи"})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// ListJobs godoc
+// @Summary      Список фоновых задач
+// @Description  Задачи персистентной очереди, новые сверху. Фильтры по очереди, типу и статусу.
+// @Tags         Jobs
+// @Produce      json
+// @Security     BearerAuth
+// @Param        queue   query     string  false  "Очередь"
+// @Param        kind    query     string  false  "Тип задачи"
+// @Param        status  query     string  false  "Статус"  Enums(pending, running, succeeded, failed, dead, cancelled)
+// @Param        limit   query     int     false  "Лимит"   minimum(1)  maximum(100)
+// @Param        offset  query     int     false  "Смещение"  minimum(0)
+// @Success      200  {object}  models.ListResponseDTO{Data=[]models.Job}
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Failure      403  {object}  models.ErrorResponseDTO
+// @Router       /admin/jobs [get]
+func (h *JobHandler) ListJobs(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	jobs, total, err := h.svc.List(models.JobFilter{
+		Queue:  c.Query("queue"),
+		Kind:   c.Query("kind"),
+		Status: models.JobStatus(c.Query("status")),
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		writeJobError(c, err)
+		return
+	}
+
+	lastPage := int(total) / limit
+	if int(total)%limit != 0 {
+		lastPage++
+	}
+
+	c.JSON(http.StatusOK, models.ListResponseDTO{
+		Data: jobs,
+		Pagination: &models.PaginationDTO{
+			Page:     offset/limit + 1,
+			Limit:    limit,
+			Total:    total,
+			LastPage: lastPage,
+		},
+	})
+}
+
+// GetJobStats godoc
+// @Summary      Статистика очередей
+// @Description  Глубина, число задач по статусам, завершённые за последний час и возраст самой старой ожидающей задачи.
+// @Tags         Jobs
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   models.JobQueueStatsDTO
+// @Failure      403  {object}  models.ErrorResponseDTO
+// @Router       /admin/jobs/stats [get]
+func (h *JobHandler) GetJobStats(c *gin.Context) {
+	stats, err := h.svc.Stats()
+	if err != nil {
+		writeJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// GetJob godoc
+// @Summary      Получить задачу
+// @Tags         Jobs
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string  true  "ID задачи"
+// @Success      200  {object}  models.Job
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /admin/jobs/{id} [get]
+func (h *JobHandler) GetJob(c *gin.Context) {
+	id, ok := parseJobID(c)
+	if !ok {
+		return
+	}
+
+	job, err := h.svc.Get(id)
+	if err != nil {
+		writeJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// RetryJob godoc
+// @Summary      Перезапустить задачу
+// @Description  Возвращает failed, dead или cancelled задачу в очередь с полным набором попыток.
+// @Tags         Jobs
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string  true  "ID задачи"
+// @Success      200  {object}  models.Job
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Failure      409  {object}  models.ErrorResponseDTO
+// @Router       /admin/jobs/{id}/retry [post]
+func (h *JobHandler) RetryJob(c *gin.Context) {
+	id, ok := parseJobID(c)
+	if !ok {
+		return
+	}
+
+	job, err := h.svc.Retry(id)
+	if err != nil {
+		writeJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelJob godoc
+// @Summary      Отменить задачу
+// @Description  Отменяет ожидающую задачу; выполняющаяся задача прерывается при следующем продлении аренды.
+// @Tags         Jobs
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string  true  "ID задачи"
+// @Success      200  {object}  models.Job
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Failure      409  {object}  models.ErrorResponseDTO
+// @Router       /admin/jobs/{id}/cancel [post]
+func (h *JobHandler) CancelJob(c *gin.Context) {
+	id, ok := parseJobID(c)
+	if !ok {
+		return
+	}
+
+	job, err := h.svc.Cancel(id)
+	if err != nil {
+		writeJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/middleware"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/services"
+)
+
+// OfflineHandler — офлайн-пакеты книг и синхронизация мобильных приложений.
+type OfflineHandler struct {
+	svc       services.OfflineService
+	validator *validator.Validate
+}
+
+func NewOfflineHandler(svc services.OfflineService, validator *validator.Validate) *OfflineHandler {
+	return &OfflineHandler{svc: svc, validator: validator}
+}
+
+// DownloadBundle godoc
+// @Summary      Офлайн-пакет книги
+// @Description  ZIP для чтения без сети: файл книги (book.<формат>, с водяным знаком, если формат позволяет),
+// @Description  manifest.json (оглавление, закладки, аннотации, позиции и токен синхронизации),
+// @Description  licence.json и licence.sig — подпись Ed25519 лицензии в base64. Лицензия действует до конца
+// @Description  выдачи и содержит SHA-256 файла книги и манифеста. Ключ проверки — GET /offline/key.
+// @Description  Пакет считается скачиванием: нужно право скачивания по тарифу или группе, расходуется квота.
+// @Tags         Offline
+// @Produce      application/zip
+// @Security     BearerAuth
+// @Param        id       path   string  true   "ID книги"
+// @Param        file_id  query  string  false  "ID файла книги; по умолчанию EPUB, затем PDF, затем MOBI"
+// @Success      200  {file}    binary
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Failure      403  {object}  models.ErrorResponseDTO
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /books/{id}/offline [get]
+func (h *OfflineHandler) DownloadBundle(c *gin.Context) {
+	bookID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат ID книги"})
+		return
+	}
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	var fileID *uuid.UUID
+	if raw := c.Query("file_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат ID файла"})
+			return
+		}
+		fileID = &id
+	}
+
+	bundle, err := h.svc.Bundle(userID, bookID, fileID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrOfflineNoAccess), errors.Is(err, services.ErrDownloadNoAccess):
+			c.JSON(http.StatusForbidden, models.ErrorResponseDTO{Error: "Нет активного доступа к этой книге"})
+		case errors.Is(err, services.ErrDownloadNotAllowed):
+			c.JSON(http.StatusForbidden, models.ErrorResponseDTO{Error: "Скачивание не входит в тариф или группу"})
+		case errors.Is(err, services.ErrDownloadLimitReached):
+			c.JSON(http.StatusForbidden, models.ErrorResponseDTO{Error: "Исчерпан лимит скачиваний за период"})
+		case errors.Is(err, services.ErrOfflineNoFile):
+			c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Файл книги не найден"})
+		default:
+			c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка подготовки пакета", Message: err.Error()})
+		}
+		return
+	}
+	defer func() { _ = bundle.Close() }()
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": bundle.FileName()}))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+	// Заголовки уже отправлены: ошибку посреди архива можно только записать в лог,
+	// приложение увидит обрыв и повторит загрузку
+	if err := bundle.Write(c.Writer); err != nil {
+		log.Printf("[offline] bundle for book %s failed: %v", bookID, err)
+	}
+}
+
+// GetKey godoc
+// @Summary      Ключ проверки офлайн-лицензий
+// @Description  Открытый ключ Ed25519. Приложение сохраняет его и проверяет licence.sig без связи с сервером;
+// @Description  если key_id лицензии не совпадает с сохранённым, ключ нужно запросить заново.
+// @Tags         Offline
+// @Produce      json
+// @Success      200  {object}  models.OfflineKeyDTO
+// @Router       /offline/key [get]
+func (h *OfflineHandler) GetKey(c *gin.Context) {
+	c.JSON(http.StatusOK, h.svc.Key())
+}
+
+// Sync godoc
+// @Summary      Синхронизация офлайн-изменений
+// @Description  Приложение отправляет закладки, аннотации, сессии чтения, позиции и удаления, сделанные офлайн,
+// @Description  и получает изменения на сервере после token. ID записей создаёт приложение: повтор запроса
+// @Description  возвращает duplicate. Аннотация меняется, только если правка на устройстве новее (иначе stale).
+// @Description  Пустой или устаревший token — полная выгрузка (full_sync=true).
+// @Tags         Offline
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body  body  models.SyncRequestDTO  true  "Изменения устройства"
+// @Success      200  {object}  models.SyncResponseDTO
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Router       /sync [post]
+func (h *OfflineHandler) Sync(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	var dto models.SyncRequestDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат данных", Message: err.Error()})
+		return
+	}
+	if err := h.validator.Struct(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Ошибка валидации", Message: err.Error()})
+		return
+	}
+
+	userAgent := c.Request.UserAgent()
+	client := models.SessionClient{
+		UserAgent:  userAgent,
+		IPAddress:  c.ClientIP(),
+		DeviceType: models.DetectDeviceType(userAgent),
+	}
+	resp, err := h.svc.Sync(userID, client, &dto)
+	if err != nil {
+		if errors.Is(err, services.ErrSyncTokenInvalid) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный токен синхронизации"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка синхронизации", Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/middleware"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/services"
+)
+
+// ReadingPositionHandler — синхронизация позиции чтения между устройствами.
+type ReadingPositionHandler struct {
+	svc           services.ReadingPositionService
+	accessService services.BookAccessService
+	validator     *validator.Validate
+}
+
+func NewReadingPositionHandler(svc services.ReadingPositionService, accessService services.BookAccessService, validator *validator.Validate) *ReadingPositionHandler {
+	return &ReadingPositionHandler{svc: svc, accessService: accessService, validator: validator}
+}
+
+// reader возвращает пользователя и книгу запроса, если у него есть доступ к книге
+func (h *ReadingPositionHandler) reader(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	bookID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат ID книги"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	if hasAccess, _ := h.accessService.CheckAccess(userID, bookID); !hasAccess {
+		c.JSON(http.StatusForbidden, models.ErrorResponseDTO{Error: "Нет доступа к этой книге"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userID, bookID, true
+}
+
+// SyncPosition godoc
+// @Summary      Сохранить позицию чтения устройства
+// @Description  Позиция задаётся локатором: страница (page), EPUB CFI (cfi) или процент (percent).
+// @Description  Побеждает позиция, изменённая последней (updated_at устройства); запоздавший запрос не затирает
+// @Description  более новую позицию (applied=false). Прогресс пересчитывается от числа страниц файла.
+// @Description  Если на другом устройстве позиция новее, она возвращается в jump_to.
+// @Tags         Reading
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id    path  string                  true  "ID книги"
+// @Param        body  body  models.SyncPositionDTO  true  "Позиция"
+// @Success      200  {object}  models.PositionSyncDTO
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Failure      403  {object}  models.ErrorResponseDTO
+// @Router       /books/{id}/position [put]
+func (h *ReadingPositionHandler) SyncPosition(c *gin.Context) {
+	userID, bookID, ok := h.reader(c)
+	if !ok {
+		return
+	}
+	var dto models.SyncPositionDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат данных", Message: err.Error()})
+		return
+	}
+	if err := h.validator.Struct(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Ошибка валидации", Message: err.Error()})
+		return
+	}
+
+	state, err := h.svc.Sync(userID, bookID, &dto)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidLocator) || errors.Is(err, services.ErrPositionDeviceRequired) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверная позиция", Message: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка сохранения позиции", Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, state)
+}
+
+// GetPosition godoc
+// @Summary      Позиция чтения для устройства
+// @Description  Вызывается при открытии книги: position — позиция этого устройства, latest — самая новая,
+// @Description  jump_to — позиция с другого устройства, к которой стоит предложить перейти.
+// @Tags         Reading
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id         path   string  true  "ID книги"
+// @Param        device_id  query  string  true  "ID устройства"
+// @Success      200  {object}  models.PositionSyncDTO
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Failure      403  {object}  models.ErrorResponseDTO
+// @Router       /books/{id}/position [get]
+func (h *ReadingPositionHandler) GetPosition(c *gin.Context) {
+	userID, bookID, ok := h.reader(c)
+	if !ok {
+		return
+	}
+	deviceID := c.Query("device_id")
+	if deviceID == "" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Не указано устройство", Message: "параметр device_id обязателен"})
+		return
+	}
+	state, err := h.svc.Get(userID, bookID, deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка получения позиции", Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, state)
+}
+
+// ListPositions godoc
+// @Summary      Позиции чтения на всех устройствах
+// @Tags         Reading
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id  path  string  true  "ID книги"
+// @Success      200  {object}  models.ListResponseDTO
+// @Failure      403  {object}  models.ErrorResponseDTO
+// @Router       /books/{id}/positions [get]
+func (h *ReadingPositionHandler) ListPositions(c *gin.Context) {
+	userID, bookID, ok := h.reader(c)
+	if !ok {
+		return
+	}
+	positions, err := h.svc.List(userID, bookID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка получения позиций", Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, models.ListResponseDTO{Data: positions})
+}
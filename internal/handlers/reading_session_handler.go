@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"errors"
+	"io"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -39,14 +41,31 @@ func (h *ReadingSessionHandler) StartSession(c *gin.Context) {
 	var req struct {
 		BookID   uuid.UUID `json:"book_id" validate:"required"`
 		AccessID uuid.UUID `json:"access_id" validate:"required"`
+		// DeviceType — тип устройства, если клиент знает его точнее User-Agent
+		DeviceType string `json:"device_type" validate:"omitempty,oneof=desktop mobile tablet ereader"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат данных", Message: err.Error()})
 		return
 	}
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Ошибка валидации", Message: err.Error()})
+		return
+	}
 
-	deviceInfo := c.GetHeader("User-Agent")
-	session, err := h.sessionService.StartSession(userID, req.BookID, req.AccessID, deviceInfo)
+	client := models.SessionClient{
+		UserAgent:  c.Request.UserAgent(),
+		IPAddress:  c.ClientIP(),
+		DeviceType: req.DeviceType,
+	}
+	if client.DeviceType == "" {
+		client.DeviceType = models.DetectDeviceType(client.UserAgent)
+	}
+	session, err := h.sessionService.StartSession(userID, req.BookID, req.AccessID, client)
+	if errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Доступ не найден"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Ошибка начала сессии", Message: err.Error()})
 		return
@@ -55,7 +74,62 @@ func (h *ReadingSessionHandler) StartSession(c *gin.Context) {
 	c.JSON(http.StatusCreated, session)
 }
 
+// Heartbeat godoc
+// @Summary      Пульс сессии чтения
+// @Description  Клиент шлёт пульс раз в 30–60 секунд, пока книга открыта. Время между пульсами засчитывается,
+// @Description  если оно не больше таймаута простоя и idle=false. Если сессия уже закрыта по простою,
+// @Description  возвращается 409 — нужно начать новую сессию.
+// @Tags         Reading
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id    path  string                      true  "ID сессии"
+// @Param        body  body  models.SessionHeartbeatDTO  true  "Пульс"
+// @Success      200  {object}  models.ReadingSession
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Failure      409  {object}  models.ErrorResponseDTO
+// @Router       /reading-sessions/{id}/heartbeat [post]
+func (h *ReadingSessionHandler) Heartbeat(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат ID сессии"})
+		return
+	}
+
+	var dto models.SessionHeartbeatDTO
+	if err := c.ShouldBindJSON(&dto); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат данных", Message: err.Error()})
+		return
+	}
+	if err := h.validator.Struct(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Ошибка валидации", Message: err.Error()})
+		return
+	}
+
+	session, err := h.sessionService.Heartbeat(userID, sessionID, &dto)
+	switch {
+	case errors.Is(err, services.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Сессия не найдена"})
+	case errors.Is(err, services.ErrSessionEnded):
+		c.JSON(http.StatusConflict, models.ErrorResponseDTO{Error: "Сессия завершена, начните новую"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка обновления сессии", Message: err.Error()})
+	default:
+		c.JSON(http.StatusOK, session)
+	}
+}
+
 func (h *ReadingSessionHandler) EndSession(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
 	sessionID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат ID сессии"})
@@ -70,7 +144,11 @@ func (h *ReadingSessionHandler) EndSession(c *gin.Context) {
 		return
 	}
 
-	if err := h.sessionService.EndSession(sessionID, req.EndPage); err != nil {
+	if err := h.sessionService.EndSession(userID, sessionID, req.EndPage); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Сессия не найдена"})
+			return
+		}
 		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Ошибка завершения сессии", Message: err.Error()})
 		return
 	}
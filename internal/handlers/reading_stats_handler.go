@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oneErrortime/afst/internal/middleware"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/services"
+)
+
+// ReadingStatsHandler — личная статистика чтения.
+type ReadingStatsHandler struct {
+	svc services.ReadingStatsService
+}
+
+func NewReadingStatsHandler(svc services.ReadingStatsService) *ReadingStatsHandler {
+	return &ReadingStatsHandler{svc: svc}
+}
+
+// GetMyStats godoc
+// @Summary      Моя статистика чтения
+// @Description  Время чтения по дням (30 дней) и неделям (12 недель), скорость в страницах в час, дочитанные книги,
+// @Description  серии дней чтения, любимые категории и прогноз дочитывания текущих книг.
+// @Description  Дни считаются в часовом поясе tz (IANA, например Europe/Moscow), по умолчанию UTC.
+// @Tags         Reading
+// @Produce      json
+// @Security     BearerAuth
+// @Param        tz  query  string  false  "Часовой пояс"
+// @Success      200  {object}  models.UserReadingStatsDTO
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Router       /me/stats [get]
+func (h *ReadingStatsHandler) GetMyStats(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	loc := time.UTC
+	if tz := c.Query("tz"); tz != "" {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неизвестный часовой пояс", Message: err.Error()})
+			return
+		}
+	}
+
+	stats, err := h.svc.GetUserStats(userID, loc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка получения статистики", Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
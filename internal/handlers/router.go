@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/oneErrortime/afst/internal/auth"
 	"github.com/oneErrortime/afst/internal/middleware"
@@ -12,14 +13,27 @@ import (
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
+const (
+	tusExtensions      = "creation,creation-with-upload,termination,expiration"
+	tusRequestHeaders  = "Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata, Upload-Defer-Length, Upload-Concat"
+	tusResponseHeaders = "Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Metadata, Upload-Expires"
+)
+
 func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, accept, origin, Cache-Control, X-Requested-With, "+tusRequestHeaders)
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, HEAD, PUT, DELETE, PATCH")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Location, "+tusResponseHeaders)
 
 		if c.Request.Method == "OPTIONS" {
+			// Запрос возможностей tus приходит без авторизации, отвечаем здесь же
+			if strings.HasPrefix(c.Request.URL.Path, "/api/v1/uploads") {
+				c.Header("Tus-Resumable", tusVersion)
+				c.Header("Tus-Version", tusVersion)
+				c.Header("Tus-Extension", tusExtensions)
+			}
 			c.AbortWithStatus(204)
 			return
 		}
@@ -59,6 +73,8 @@ func SetupRoutes(handlers *Handlers, jwtService *auth.JWTService) *gin.Engine {
 		books.GET("", handlers.Book.GetAllBooks)
 		books.GET("/:id", handlers.Book.GetBook)
 		books.GET("/:id/recommendations", handlers.Book.GetRecommendations)
+		books.GET("/:id/cover", handlers.Cover.GetCover)
+		books.HEAD("/:id/cover", handlers.Cover.GetCover)
 	}
 
 	categories := api.Group("/categories")
@@ -103,6 +119,10 @@ func SetupRoutes(handlers *Handlers, jwtService *auth.JWTService) *gin.Engine {
 		authProtectedSocial := socialUsers.Use(authMiddleware)
 		authProtectedSocial.POST("/:id/follow", handlers.Social.FollowUser)
 		authProtectedSocial.DELETE("/:id/follow", handlers.Social.UnfollowUser)
+		authProtectedSocial.GET("/:id/annotations", handlers.Annotation.ListUserAnnotations)
+		authProtectedSocial.GET("/:id/activity", handlers.Activity.GetUserActivity)
+		authProtectedSocial.GET("/:id/followers", handlers.Social.GetFollowers)
+		authProtectedSocial.GET("/:id/following", handlers.Social.GetFollowing)
 	}
 
 	protectedBooks := api.Group("/books").Use(authMiddleware, requireLibrarian)
@@ -112,9 +132,20 @@ func SetupRoutes(handlers *Handlers, jwtService *auth.JWTService) *gin.Engine {
 		protectedBooks.DELETE("/:id", handlers.Book.DeleteBook)
 		protectedBooks.POST("/:id/files", handlers.BookFile.Upload)
 		protectedBooks.GET("/:id/files", handlers.BookFile.GetByBookID)
+		protectedBooks.PUT("/:id/cover", handlers.Cover.UploadCover)
+		protectedBooks.DELETE("/:id/cover", handlers.Cover.DeleteCover)
 		protectedBooks.GET("/:id/stats", handlers.ReadingSession.GetBookStats)
 	}
 
+	readerBooks := api.Group("/books").Use(authMiddleware)
+	{
+		readerBooks.GET("/:id/search", handlers.Search.SearchInBook)
+		readerBooks.GET("/:id/position", handlers.Position.GetPosition)
+		readerBooks.PUT("/:id/position", handlers.Position.SyncPosition)
+		readerBooks.GET("/:id/positions", handlers.Position.ListPositions)
+		readerBooks.GET("/:id/offline", handlers.Offline.DownloadBundle)
+	}
+
 	readers := api.Group("/readers").Use(authMiddleware, requireLibrarian)
 	{
 		readers.POST("", handlers.Reader.CreateReader)
@@ -176,9 +207,30 @@ func SetupRoutes(handlers *Handlers, jwtService *auth.JWTService) *gin.Engine {
 		adminAccess.POST("/:id/revoke", handlers.BookAccess.RevokeAccess)
 	}
 
+	uploads := api.Group("/uploads").Use(authMiddleware, requireLibrarian, TusMiddleware())
+	{
+		uploads.POST("", handlers.Upload.Create)
+		uploads.HEAD("/:id", handlers.Upload.Head)
+		uploads.PATCH("/:id", handlers.Upload.Patch)
+		uploads.DELETE("/:id", handlers.Upload.Terminate)
+		uploads.GET("/:id", handlers.Upload.GetStatus)
+	}
+
+	// Подписанные ссылки открываются без JWT: подпись удостоверяет пользователя
+	signedFiles := api.Group("/files")
+	{
+		signedFiles.GET("/:id/view", handlers.BookFile.ViewSigned)
+		signedFiles.HEAD("/:id/view", handlers.BookFile.ViewSigned)
+		signedFiles.GET("/:id/download", handlers.BookFile.DownloadSigned)
+		signedFiles.HEAD("/:id/download", handlers.BookFile.DownloadSigned)
+	}
+
 	files := api.Group("/files").Use(authMiddleware)
 	{
 		files.GET("/:id", handlers.BookFile.ServeFile)
+		files.POST("/:id/link", handlers.BookFile.CreateLink)
+		files.GET("/:id/metadata", handlers.BookFile.GetMetadata)
+		files.GET("/:id/epub/*path", handlers.BookFile.ServeEPUBResource)
 	}
 
 	adminFiles := api.Group("/files").Use(authMiddleware, requireLibrarian)
@@ -186,13 +238,47 @@ func SetupRoutes(handlers *Handlers, jwtService *auth.JWTService) *gin.Engine {
 		adminFiles.DELETE("/:id", handlers.BookFile.Delete)
 	}
 
+	api.GET("/downloads/quota", authMiddleware, handlers.BookFile.GetDownloadQuota)
+
 	sessions := api.Group("/reading-sessions").Use(authMiddleware)
 	{
 		sessions.POST("", handlers.ReadingSession.StartSession)
+		sessions.POST("/:id/heartbeat", handlers.ReadingSession.Heartbeat)
 		sessions.POST("/:id/end", handlers.ReadingSession.EndSession)
 		sessions.GET("/my", handlers.ReadingSession.GetMySessions)
 	}
 
+	api.GET("/offline/key", handlers.Offline.GetKey)
+	api.POST("/sync", authMiddleware, handlers.Offline.Sync)
+
+	me := api.Group("/me").Use(authMiddleware)
+	{
+		me.GET("/stats", handlers.Stats.GetMyStats)
+		me.GET("/goals", handlers.Goal.ListGoals)
+		me.POST("/goals", handlers.Goal.CreateGoal)
+		me.PUT("/goals/:id", handlers.Goal.UpdateGoal)
+		me.DELETE("/goals/:id", handlers.Goal.DeleteGoal)
+		me.GET("/feed", handlers.Activity.GetFeed)
+		me.GET("/activity-privacy", handlers.Activity.GetPrivacy)
+		me.PUT("/activity-privacy", handlers.Activity.UpdatePrivacy)
+	}
+
+	challenges := api.Group("/challenges").Use(authMiddleware)
+	{
+		challenges.GET("", handlers.Goal.ListChallenges)
+		challenges.GET("/:id", handlers.Goal.GetChallenge)
+		challenges.GET("/:id/leaderboard", handlers.Goal.GetLeaderboard)
+		challenges.POST("/:id/join", handlers.Goal.JoinChallenge)
+		challenges.DELETE("/:id/join", handlers.Goal.LeaveChallenge)
+	}
+
+	manageChallenges := api.Group("/challenges").Use(authMiddleware, requireLibrarian)
+	{
+		manageChallenges.POST("", handlers.Goal.CreateChallenge)
+		manageChallenges.PUT("/:id", handlers.Goal.UpdateChallenge)
+		manageChallenges.DELETE("/:id", handlers.Goal.DeleteChallenge)
+	}
+
 	collections := api.Group("/collections").Use(authMiddleware)
 	{
 		collections.POST("", handlers.Collection.CreateCollection)
@@ -222,7 +308,19 @@ func SetupRoutes(handlers *Handlers, jwtService *auth.JWTService) *gin.Engine {
 		bookmarks.DELETE("/:id", handlers.Bookmark.DeleteBookmark)
 	}
 
+	annotations := api.Group("/annotations").Use(authMiddleware)
+	{
+		annotations.POST("", handlers.Annotation.CreateAnnotation)
+		annotations.GET("", handlers.Annotation.ListMyAnnotations)
+		annotations.GET("/export", handlers.Annotation.ExportAnnotations)
+		annotations.GET("/book/:book_id", handlers.Annotation.ListBookAnnotations)
+		annotations.GET("/:id", handlers.Annotation.GetAnnotation)
+		annotations.PUT("/:id", handlers.Annotation.UpdateAnnotation)
+		annotations.DELETE("/:id", handlers.Annotation.DeleteAnnotation)
+	}
+
 	api.GET("/stats/dashboard", authMiddleware, requireLibrarian, handlers.GetDashboardStats)
+	api.GET("/search/content", authMiddleware, requireLibrarian, handlers.Search.SearchLibrary)
 
 	// ── Управление API-ключами (требует JWT пользователя) ─────────────────────
 	apiKeys := api.Group("/api-keys").Use(authMiddleware)
@@ -239,6 +337,38 @@ func SetupRoutes(handlers *Handlers, jwtService *auth.JWTService) *gin.Engine {
 		apiKeysAdmin.POST("/:id/topup", handlers.APIKey.TopUpTokens)
 	}
 
+	// ── Вебхуки (feature flag enable_webhooks) ─────────────────────────────────
+	requireWebhooks := middleware.FeatureFlagMiddleware(handlers.Services.FeatureFlag, "enable_webhooks")
+	webhooks := api.Group("/webhooks").Use(authMiddleware, requireWebhooks)
+	{
+		webhooks.POST("", handlers.Webhook.CreateWebhook)
+		webhooks.GET("", handlers.Webhook.ListWebhooks)
+		webhooks.GET("/:id", handlers.Webhook.GetWebhook)
+		webhooks.PUT("/:id", handlers.Webhook.UpdateWebhook)
+		webhooks.DELETE("/:id", handlers.Webhook.DeleteWebhook)
+		webhooks.POST("/:id/rotate-secret", handlers.Webhook.RotateSecret)
+		webhooks.GET("/:id/deliveries", handlers.Webhook.ListDeliveries)
+		webhooks.POST("/:id/deliveries/:delivery_id/redeliver", handlers.Webhook.Redeliver)
+	}
+
+	// ── Фоновые и периодические задачи — только admin ─────────────────────────
+	adminJobs := api.Group("/admin/jobs").Use(authMiddleware, requireAdmin)
+	{
+		adminJobs.GET("", handlers.Job.ListJobs)
+		adminJobs.GET("/stats", handlers.Job.GetJobStats)
+		adminJobs.GET("/:id", handlers.Job.GetJob)
+		adminJobs.POST("/:id/retry", handlers.Job.RetryJob)
+		adminJobs.POST("/:id/cancel", handlers.Job.CancelJob)
+	}
+
+	adminScheduler := api.Group("/admin/scheduler").Use(authMiddleware, requireAdmin)
+	{
+		adminScheduler.GET("/tasks", handlers.Scheduler.ListScheduledTasks)
+		adminScheduler.GET("/tasks/:name", handlers.Scheduler.GetScheduledTask)
+		adminScheduler.GET("/tasks/:name/runs", handlers.Scheduler.ListScheduledTaskRuns)
+		adminScheduler.POST("/tasks/:name/run", handlers.Scheduler.RunScheduledTask)
+	}
+
 	// ── Внешнее API /ext/v1 — аутентификация по API-ключу ──────────────────────
 	apiKeyMiddleware := middleware.APIKeyMiddleware(handlers.Services.APIKey)
 	ext := router.Group("/ext/v1").Use(apiKeyMiddleware)
@@ -265,6 +395,18 @@ func SetupRoutes(handlers *Handlers, jwtService *auth.JWTService) *gin.Engine {
 		ext.POST("/collections", handlers.ExtCreateCollection)
 	}
 
+	// Webhooks, привязанные к API-ключу
+	extWebhooks := router.Group("/ext/v1/webhooks").Use(apiKeyMiddleware, requireWebhooks)
+	{
+		extWebhooks.POST("", handlers.Webhook.CreateWebhook)
+		extWebhooks.GET("", handlers.Webhook.ListWebhooks)
+		extWebhooks.GET("/:id", handlers.Webhook.GetWebhook)
+		extWebhooks.PUT("/:id", handlers.Webhook.UpdateWebhook)
+		extWebhooks.DELETE("/:id", handlers.Webhook.DeleteWebhook)
+		extWebhooks.GET("/:id/deliveries", handlers.Webhook.ListDeliveries)
+		extWebhooks.POST("/:id/deliveries/:delivery_id/redeliver", handlers.Webhook.Redeliver)
+	}
+
 	api.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"status":  "OK",
@@ -277,6 +419,7 @@ func SetupRoutes(handlers *Handlers, jwtService *auth.JWTService) *gin.Engine {
 	eventStream := api.Group("/events").Use(authMiddleware)
 	{
 		eventStream.GET("/stream", handlers.SSE.Stream)
+		eventStream.GET("/ws", handlers.WS.Connect)
 	}
 
 	return router
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/services"
+)
+
+// SchedulerHandler — периодические задачи: состояние, история запусков, ручной запуск (только admin).
+type SchedulerHandler struct {
+	svc services.SchedulerService
+}
+
+func NewSchedulerHandler(svc services.SchedulerService) *SchedulerHandler {
+	return &SchedulerHandler{svc: svc}
+}
+
+func writeSchedulerError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrScheduledTaskNotFound) {
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Задача планировщика не найдена"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка сервера", Message: err.Error()})
+}
+
+// ListScheduledTasks godoc
+// @Summary      Список периодических задач
+// @Description  Расписание, следующий запуск, результат и ошибка последнего запуска.
+// @Tags         Scheduler
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   models.ScheduledTaskDTO
+// @Failure      403  {object}  models.ErrorResponseDTO
+// @Router       /admin/scheduler/tasks [get]
+func (h *SchedulerHandler) ListScheduledTasks(c *gin.Context) {
+	tasks, err := h.svc.List()
+	if err != nil {
+		writeSchedulerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tasks)
+}
+
+// GetScheduledTask godoc
+// @Summary      Получить периодическую задачу
+// @Tags         Scheduler
+// @Produce      json
+// @Security     BearerAuth
+// @Param        name  path      string  true  "Имя задачи"
+// @Success      200   {object}  models.ScheduledTaskDTO
+// @Failure      404   {object}  models.ErrorResponseDTO
+// @Router       /admin/scheduler/tasks/{name} [get]
+func (h *SchedulerHandler) GetScheduledTask(c *gin.Context) {
+	task, err := h.svc.Get(c.Param("name"))
+	if err != nil {
+		writeSchedulerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, task)
+}
+
+// ListScheduledTaskRuns godoc
+// @Summary      История запусков задачи
+// @Tags         Scheduler
+// @Produce      json
+// @Security     BearerAuth
+// @Param        name   path      string  true   "Имя задачи"
+// @Param        limit  query     int     false  "Лимит"  minimum(1)  maximum(200)
+// @Success      200    {array}   models.ScheduledTaskRun
+// @Failure      404    {object}  models.ErrorResponseDTO
+// @Router       /admin/scheduler/tasks/{name}/runs [get]
+func (h *SchedulerHandler) ListScheduledTaskRuns(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	runs, err := h.svc.Runs(c.Param("name"), limit)
+	if err != nil {
+		writeSchedulerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
+
+// RunScheduledTask godoc
+// @Summary      Запустить задачу сейчас
+// @Description  Внеплановый запуск; выполняется одним из инстансов в течение нескольких секунд.
+// @Tags         Scheduler
+// @Produce      json
+// @Security     BearerAuth
+// @Param        name  path      string  true  "Имя задачи"
+// @Success      202   {object}  models.SuccessResponseDTO
+// @Failure      404   {object}  models.ErrorResponseDTO
+// @Router       /admin/scheduler/tasks/{name}/run [post]
+func (h *SchedulerHandler) RunScheduledTask(c *gin.Context) {
+	if err := h.svc.RunNow(c.Param("name")); err != nil {
+		writeSchedulerError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, models.SuccessResponseDTO{Message: "Запуск запрошен"})
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/middleware"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/services"
+)
+
+// SearchHandler — полнотекстовый поиск по содержимому книг.
+type SearchHandler struct {
+	svc           services.SearchService
+	accessService services.BookAccessService
+}
+
+func NewSearchHandler(svc services.SearchService, accessService services.BookAccessService) *SearchHandler {
+	return &SearchHandler{svc: svc, accessService: accessService}
+}
+
+func writeSearchError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrSearchQueryEmpty):
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Пустой поисковый запрос", Message: "параметр q должен содержать хотя бы одно слово"})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка поиска", Message: err.Error()})
+	}
+}
+
+// SearchInBook godoc
+// @Summary      Поиск по тексту книги
+// @Description  Ищет слова запроса в тексте книги и возвращает сниппеты с номером страницы (PDF) или CFI главы (EPUB). Запрос в кавычках ищется как фраза.
+// @Tags         Search
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id      path   string  true   "ID книги"
+// @Param        q       query  string  true   "Поисковый запрос"
+// @Param        limit   query  int     false  "Лимит"     minimum(1)  maximum(100)
+// @Param        offset  query  int     false  "Смещение"  minimum(0)
+// @Success      200  {object}  models.BookSearchResultDTO
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Failure      403  {object}  models.ErrorResponseDTO
+// @Router       /books/{id}/search [get]
+func (h *SearchHandler) SearchInBook(c *gin.Context) {
+	bookID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат ID книги"})
+		return
+	}
+
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+
+	// Admins and librarians always have access
+	role, _ := middleware.GetUserRoleFromContext(c)
+	if role != models.RoleAdmin && role != models.RoleLibrarian {
+		hasAccess, _ := h.accessService.CheckAccess(userID, bookID)
+		if !hasAccess {
+			c.JSON(http.StatusForbidden, models.ErrorResponseDTO{Error: "Нет доступа к этой книге"})
+			return
+		}
+	}
+
+	limit, offset := searchPaging(c)
+	result, err := h.svc.SearchBook(bookID, c.Query("q"), limit, offset)
+	if err != nil {
+		writeSearchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// SearchLibrary godoc
+// @Summary      Поиск по содержимому всей библиотеки
+// @Description  Книги, в тексте которых встречается запрос, по убыванию плотности совпадений (доли страниц или глав с совпадением).
+// @Tags         Search
+// @Produce      json
+// @Security     BearerAuth
+// @Param        q      query  string  true   "Поисковый запрос"
+// @Param        limit  query  int     false  "Лимит"  minimum(1)  maximum(100)
+// @Success      200  {object}  models.ListResponseDTO{Data=[]models.LibrarySearchHitDTO}
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Failure      403  {object}  models.ErrorResponseDTO
+// @Router       /search/content [get]
+func (h *SearchHandler) SearchLibrary(c *gin.Context) {
+	limit, _ := searchPaging(c)
+	hits, err := h.svc.SearchLibrary(c.Query("q"), limit)
+	if err != nil {
+		writeSearchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ListResponseDTO{Data: hits})
+}
+
+func searchPaging(c *gin.Context) (limit, offset int) {
+	limit, _ = strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
@@ -114,3 +114,63 @@ func (h *SocialHandler) UnfollowUser(c *gin.Context) {
 
 	c.JSON(http.StatusOK, models.SuccessResponseDTO{Message: "Successfully unfollowed user"})
 }
+
+// GetFollowers godoc
+// @Summary		List a user's followers
+// @Description	Returns the users following the given user, most recent first. Only public fields are exposed.
+// @Tags			Social
+// @Produce		json
+// @Security		BearerAuth
+// @Param			id		path		string	true	"User ID"
+// @Param			limit	query		int		false	"Users per page"	minimum(1)	maximum(100)
+// @Param			offset	query		int		false	"Offset for pagination"	minimum(0)
+// @Success		200	{object}	models.ListResponseDTO{Data=[]models.UserSummaryDTO}
+// @Failure		400	{object}	models.ErrorResponseDTO
+// @Failure		500	{object}	models.ErrorResponseDTO
+// @Router			/users/{id}/followers [get]
+func (h *SocialHandler) GetFollowers(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Invalid user ID"})
+		return
+	}
+
+	limit, offset := pageParams(c)
+	users, total, err := h.service.GetFollowers(userID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Failed to get followers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, pageResponse(users, limit, offset, total))
+}
+
+// GetFollowing godoc
+// @Summary		List users a user follows
+// @Description	Returns the users the given user follows, most recent first. Only public fields are exposed.
+// @Tags			Social
+// @Produce		json
+// @Security		BearerAuth
+// @Param			id		path		string	true	"User ID"
+// @Param			limit	query		int		false	"Users per page"	minimum(1)	maximum(100)
+// @Param			offset	query		int		false	"Offset for pagination"	minimum(0)
+// @Success		200	{object}	models.ListResponseDTO{Data=[]models.UserSummaryDTO}
+// @Failure		400	{object}	models.ErrorResponseDTO
+// @Failure		500	{object}	models.ErrorResponseDTO
+// @Router			/users/{id}/following [get]
+func (h *SocialHandler) GetFollowing(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Invalid user ID"})
+		return
+	}
+
+	limit, offset := pageParams(c)
+	users, total, err := h.service.GetFollowing(userID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Failed to get followed users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, pageResponse(users, limit, offset, total))
+}
@@ -5,34 +5,68 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/oneErrortime/afst/internal/events"
+	"github.com/oneErrortime/afst/internal/middleware"
+	"github.com/oneErrortime/afst/internal/models"
 )
 
 // SSEHandler streams server-sent events to connected frontend clients.
-// Each user gets their own subscription filtered by user_id.
+// Each user gets their own subscription filtered by user_id. Every event
+// carries an SSE id, and a reconnecting client (Last-Event-ID) first gets
+// what it missed from the replay buffer.
 type SSEHandler struct {
-	bus *events.Bus
+	replay *events.ReplayBuffer
 }
 
+const (
+	sseReplayPerUser   = 256
+	sseReplayRetention = 15 * time.Minute
+)
+
 func NewSSEHandler(bus *events.Bus) *SSEHandler {
-	return &SSEHandler{bus: bus}
+	replay := events.NewReplayBuffer(sseReplayPerUser, sseReplayRetention)
+	replay.Run(context.Background(), bus)
+	return &SSEHandler{replay: replay}
 }
 
 // Stream handles GET /api/v1/events/stream
 // The client gets SSE for events relevant to their user_id.
 //
-//	@Summary	Subscribe to real-time events
-//	@Tags		events
-//	@Produce	text/event-stream
-//	@Security	BearerAuth
-//	@Success	200
-//	@Router		/events/stream [get]
+//	@Summary		Subscribe to real-time events
+//	@Description	Resumable stream: pass Last-Event-ID (or last_event_id) to replay missed events. A "reset" event means some were lost and state should be reloaded.
+//	@Tags			events
+//	@Produce		text/event-stream
+//	@Security		BearerAuth
+//	@Param			types			query	string	false	"Comma-separated event types, e.g. access.granted,book.processed"
+//	@Param			last_event_id	query	string	false	"Resume after this event ID (same as the Last-Event-ID header)"
+//	@Success		200
+//	@Failure		400	{object}	models.ErrorResponseDTO
+//	@Router			/events/stream [get]
 func (h *SSEHandler) Stream(c *gin.Context) {
-	userIDVal, _ := c.Get("user_id")
-	userID, _ := userIDVal.(string)
+	uid, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	userID := uid.String()
+
+	filter, err := parseSSETypes(c.Query("types"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неизвестный тип события", Message: err.Error()})
+		return
+	}
+
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+	lastSeq, _ := strconv.ParseUint(lastID, 10, 64)
 
 	// Set SSE headers
 	c.Header("Content-Type", "text/event-stream")
@@ -43,22 +77,22 @@ func (h *SSEHandler) Stream(c *gin.Context) {
 	ctx, cancel := context.WithCancel(c.Request.Context())
 	defer cancel()
 
-	// Subscribe to all interesting event types
-	sub := h.bus.Subscribe(ctx,
-		events.EventBookUploaded,
-		events.EventBookProcessed,
-		events.EventAccessGranted,
-		events.EventAccessRevoked,
-		events.EventSubscriptionNew,
-		events.EventSubscriptionExpired,
-		events.EventReadingProgress,
-	)
+	backlog, live, complete := h.replay.Attach(ctx, userID, lastSeq)
 
 	// Send initial "connected" event
 	writeSSE(c, "connected", map[string]string{
 		"status":  "connected",
 		"user_id": userID,
 	})
+	if !complete {
+		writeSSE(c, "reset", map[string]string{"reason": "events were lost while disconnected"})
+	}
+	for _, event := range backlog {
+		if filter.allows(event.Type) {
+			writeSSEEvent(c.Writer, event)
+		}
+	}
+	c.Writer.Flush()
 
 	// Heartbeat ticker — keeps the connection alive through proxies
 	ticker := time.NewTicker(25 * time.Second)
@@ -73,22 +107,51 @@ func (h *SSEHandler) Stream(c *gin.Context) {
 			writeSSETo(w, "ping", map[string]string{"t": time.Now().Format(time.RFC3339)})
 			return true
 
-		case event, ok := <-sub:
+		case event, ok := <-live:
 			if !ok {
 				return false
 			}
-
-			// Filter: only send to the relevant user
-			if event.UserID != "" && event.UserID != userID {
-				return true // skip but stay connected
+			if filter.allows(event.Type) {
+				writeSSEEvent(w, event)
 			}
-
-			writeSSETo(w, string(event.Type), event.Payload)
 			return true
 		}
 	})
 }
 
+// sseTypeFilter is the set of event types a client asked for; nil means all
+type sseTypeFilter map[events.EventType]bool
+
+func parseSSETypes(raw string) (sseTypeFilter, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	filter := make(sseTypeFilter)
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !events.IsKnownEventType(name) {
+			return nil, fmt.Errorf("unknown event type %q", name)
+		}
+		filter[events.EventType(name)] = true
+	}
+	return filter, nil
+}
+
+func (f sseTypeFilter) allows(t events.EventType) bool {
+	return f == nil || f[t]
+}
+
+func writeSSEEvent(w io.Writer, event events.StreamEvent) {
+	data, err := json.Marshal(event.Payload)
+	if err != nil {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data)
+}
+
 func writeSSETo(w io.Writer, eventType string, payload interface{}) {
 	data, err := json.Marshal(payload)
 	if err != nil {
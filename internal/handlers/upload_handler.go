@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/middleware"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/services"
+)
+
+const (
+	tusVersion = "1.0.0"
+	// tusChunkType — единственный тип тела PATCH по протоколу tus
+	tusChunkType = "application/offset+octet-stream"
+	// uploadChunkTimeout — сколько ждём один фрагмент; таймауты сервера рассчитаны на обычные запросы
+	uploadChunkTimeout = 15 * time.Minute
+)
+
+// UploadHandler — возобновляемая загрузка файлов книг по протоколу tus 1.0.0.
+// Клиент создаёт загрузку (POST), досылает фрагменты (PATCH), узнаёт
+// смещение после обрыва (HEAD) и может отменить загрузку (DELETE).
+type UploadHandler struct {
+	svc services.UploadService
+}
+
+func NewUploadHandler(svc services.UploadService) *UploadHandler {
+	return &UploadHandler{svc: svc}
+}
+
+// TusMiddleware проставляет Tus-Resumable и отклоняет чужие версии протокола.
+// GET статуса — обычный JSON и заголовка Tus-Resumable не требует.
+func TusMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", tusVersion)
+		if c.Request.Method != http.MethodGet && c.GetHeader("Tus-Resumable") != tusVersion {
+			c.Header("Tus-Version", tusVersion)
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, models.ErrorResponseDTO{
+				Error:   "Неподдерживаемая версия протокола tus",
+				Message: "ожидается Tus-Resumable: " + tusVersion,
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+func writeUploadError(c *gin.Context, err error) {
+	// Отказ проверки содержимого приходит из финализации, но важнее её
+	if writeFileCheckError(c, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Загрузка не найдена"})
+	case errors.Is(err, services.ErrUploadBookNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Книга не найдена"})
+	case errors.Is(err, services.ErrUploadForbidden):
+		c.JSON(http.StatusForbidden, models.ErrorResponseDTO{Error: "Недостаточно прав для загрузки файлов"})
+	case errors.Is(err, services.ErrUploadTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, models.ErrorResponseDTO{Error: "Превышен допустимый размер загрузки", Message: err.Error()})
+	case errors.Is(err, services.ErrUploadBadFileType):
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неподдерживаемый тип файла", Message: "допустимо: pdf, epub, mobi, azw3, fb2, djvu"})
+	case errors.Is(err, services.ErrUploadOffsetMismatch):
+		c.JSON(http.StatusConflict, models.ErrorResponseDTO{Error: "Смещение не совпадает с загруженным", Message: "запросите текущее смещение через HEAD"})
+	case errors.Is(err, services.ErrUploadNotActive):
+		c.JSON(http.StatusConflict, models.ErrorResponseDTO{Error: "Загрузка уже завершена"})
+	case errors.Is(err, services.ErrUploadLocked):
+		c.JSON(http.StatusLocked, models.ErrorResponseDTO{Error: "Загрузка занята другим запросом"})
+	case errors.Is(err, services.ErrUploadExpired):
+		c.JSON(http.StatusGone, models.ErrorResponseDTO{Error: "Срок загрузки истёк"})
+	case errors.Is(err, services.ErrUploadFinalize):
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Не удалось сохранить файл книги", Message: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка сервера", Message: err.Error()})
+	}
+}
+
+// parseUploadMetadata разбирает Upload-Metadata: пары "ключ base64", через запятую
+func parseUploadMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return meta, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, errors.New("metadata value of " + key + " is not base64")
+		}
+		meta[key] = string(decoded)
+	}
+	return meta, nil
+}
+
+func parseUploadID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Загрузка не найдена"})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func uploadUser(c *gin.Context) (uuid.UUID, bool) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+func setUploadHeaders(c *gin.Context, upload *models.Upload) {
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.Status == models.UploadStatusActive {
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// extendUploadDeadline снимает общие таймауты сервера на время приёма фрагмента
+func extendUploadDeadline(c *gin.Context) {
+	rc := http.NewResponseController(c.Writer)
+	deadline := time.Now().Add(uploadChunkTimeout)
+	_ = rc.SetReadDeadline(deadline)
+	_ = rc.SetWriteDeadline(deadline)
+}
+
+// Create godoc
+// @Summary      Начать загрузку
+// @Description  Создаёт возобновляемую загрузку (tus creation). Upload-Metadata должен содержать filename и book_id.
+// @Description  Если тело передано с Content-Type application/offset+octet-stream, оно сразу записывается как первый фрагмент.
+// @Tags         Uploads
+// @Security     BearerAuth
+// @Param        Tus-Resumable    header  string  true   "Версия протокола"  default(1.0.0)
+// @Param        Upload-Length    header  int     true   "Полный размер файла в байтах"
+// @Param        Upload-Metadata  header  string  true   "filename <base64>,book_id <base64>"
+// @Success      201
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Failure      412  {object}  models.ErrorResponseDTO
+// @Failure      413  {object}  models.ErrorResponseDTO
+// @Router       /uploads [post]
+func (h *UploadHandler) Create(c *gin.Context) {
+	userID, ok := uploadUser(c)
+	if !ok {
+		return
+	}
+	role, err := middleware.GetUserRoleFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	c.Header("Tus-Max-Size", strconv.FormatInt(h.svc.MaxSize(role), 10))
+
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Размер загрузки должен быть известен заранее", Message: "Upload-Defer-Length не поддерживается"})
+		return
+	}
+	size, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || size <= 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный заголовок Upload-Length"})
+		return
+	}
+
+	rawMeta := c.GetHeader("Upload-Metadata")
+	meta, err := parseUploadMetadata(rawMeta)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный заголовок Upload-Metadata", Message: err.Error()})
+		return
+	}
+	if meta["filename"] == "" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "В Upload-Metadata не указан filename"})
+		return
+	}
+	bookID, err := uuid.Parse(meta["book_id"])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "В Upload-Metadata не указан или неверен book_id"})
+		return
+	}
+
+	upload, err := h.svc.Create(userID, role, services.CreateUploadRequest{
+		BookID:   bookID,
+		FileName: meta["filename"],
+		Size:     size,
+		Metadata: rawMeta,
+	})
+	if err != nil {
+		writeUploadError(c, err)
+		return
+	}
+	c.Header("Location", "/api/v1/uploads/"+upload.ID.String())
+
+	// creation-with-upload: первый фрагмент в теле запроса создания
+	if c.ContentType() == tusChunkType && c.Request.ContentLength != 0 {
+		extendUploadDeadline(c)
+		appended, err := h.svc.Append(userID, upload.ID, 0, c.Request.ContentLength, c.Request.Body)
+		if errors.Is(err, services.ErrUploadTooLarge) || errors.Is(err, services.ErrUploadFinalize) {
+			writeUploadError(c, err)
+			return
+		}
+		// Прочие ошибки не мешают: загрузка создана, клиент продолжит с сохранённого смещения
+		if appended != nil {
+			upload = appended
+		}
+	}
+
+	setUploadHeaders(c, upload)
+	c.Status(http.StatusCreated)
+}
+
+// Head godoc
+// @Summary      Смещение загрузки
+// @Description  Возвращает, сколько байт уже принято, чтобы продолжить загрузку после обрыва.
+// @Tags         Uploads
+// @Security     BearerAuth
+// @Param        id             path    string  true  "ID загрузки"
+// @Param        Tus-Resumable  header  string  true  "Версия протокола"  default(1.0.0)
+// @Success      200
+// @Failure      404
+// @Failure      410
+// @Router       /uploads/{id} [head]
+func (h *UploadHandler) Head(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	userID, ok := uploadUser(c)
+	if !ok {
+		return
+	}
+	id, ok := parseUploadID(c)
+	if !ok {
+		return
+	}
+
+	upload, err := h.svc.Get(userID, id)
+	if err != nil {
+		if errors.Is(err, services.ErrUploadNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if upload.Status == models.UploadStatusFailed {
+		c.Status(http.StatusGone)
+		return
+	}
+
+	setUploadHeaders(c, upload)
+	c.Header("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	if upload.Metadata != "" {
+		c.Header("Upload-Metadata", upload.Metadata)
+	}
+	c.Status(http.StatusOK)
+}
+
+// Patch godoc
+// @Summary      Дослать фрагмент
+// @Description  Дописывает фрагмент с позиции Upload-Offset. После последнего фрагмента файл проходит
+// @Description  проверку содержимого, антивирусную проверку и проверку дубликатов и ставится в очередь обработки,
+// @Description  как при обычной загрузке.
+// @Tags         Uploads
+// @Accept       application/offset+octet-stream
+// @Security     BearerAuth
+// @Param        id             path    string  true  "ID загрузки"
+// @Param        Tus-Resumable  header  string  true  "Версия протокола"  default(1.0.0)
+// @Param        Upload-Offset  header  int     true  "Смещение фрагмента"
+// @Success      204
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Failure      409  {object}  models.ErrorResponseDTO
+// @Failure      410  {object}  models.ErrorResponseDTO
+// @Failure      413  {object}  models.ErrorResponseDTO
+// @Failure      415  {object}  models.ErrorResponseDTO
+// @Failure      422  {object}  models.ErrorResponseDTO
+// @Failure      423  {object}  models.ErrorResponseDTO
+// @Failure      503  {object}  models.ErrorResponseDTO
+// @Router       /uploads/{id} [patch]
+func (h *UploadHandler) Patch(c *gin.Context) {
+	userID, ok := uploadUser(c)
+	if !ok {
+		return
+	}
+	id, ok := parseUploadID(c)
+	if !ok {
+		return
+	}
+	if c.ContentType() != tusChunkType {
+		c.JSON(http.StatusUnsupportedMediaType, models.ErrorResponseDTO{Error: "Ожидается Content-Type " + tusChunkType})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный заголовок Upload-Offset"})
+		return
+	}
+
+	extendUploadDeadline(c)
+	upload, err := h.svc.Append(userID, id, offset, c.Request.ContentLength, c.Request.Body)
+	if err != nil {
+		writeUploadError(c, err)
+		return
+	}
+	setUploadHeaders(c, upload)
+	c.Status(http.StatusNoContent)
+}
+
+// Terminate godoc
+// @Summary      Отменить загрузку
+// @Description  Удаляет незавершённую загрузку и принятые фрагменты (tus termination).
+// @Tags         Uploads
+// @Security     BearerAuth
+// @Param        id             path    string  true  "ID загрузки"
+// @Param        Tus-Resumable  header  string  true  "Версия протокола"  default(1.0.0)
+// @Success      204
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Failure      423  {object}  models.ErrorResponseDTO
+// @Router       /uploads/{id} [delete]
+func (h *UploadHandler) Terminate(c *gin.Context) {
+	userID, ok := uploadUser(c)
+	if !ok {
+		return
+	}
+	id, ok := parseUploadID(c)
+	if !ok {
+		return
+	}
+	if err := h.svc.Terminate(userID, id); err != nil {
+		writeUploadError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GetStatus godoc
+// @Summary      Статус загрузки
+// @Description  Состояние загрузки в JSON; у завершённой — ID созданного файла книги.
+// @Tags         Uploads
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id  path  string  true  "ID загрузки"
+// @Success      200  {object}  models.Upload
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /uploads/{id} [get]
+func (h *UploadHandler) GetStatus(c *gin.Context) {
+	userID, ok := uploadUser(c)
+	if !ok {
+		return
+	}
+	id, ok := parseUploadID(c)
+	if !ok {
+		return
+	}
+	upload, err := h.svc.Get(userID, id)
+	if err != nil {
+		writeUploadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, upload)
+}
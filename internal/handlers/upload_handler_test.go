@@ -0,0 +1,160 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/handlers"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockUploadService is a mock for the UploadService
+type MockUploadService struct {
+	mock.Mock
+}
+
+func (m *MockUploadService) MaxSize(role models.UserRole) int64 {
+	return int64(m.Called(role).Int(0))
+}
+
+func (m *MockUploadService) Create(userID uuid.UUID, role models.UserRole, req services.CreateUploadRequest) (*models.Upload, error) {
+	args := m.Called(userID, role, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Upload), args.Error(1)
+}
+
+func (m *MockUploadService) Get(userID, id uuid.UUID) (*models.Upload, error) {
+	args := m.Called(userID, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Upload), args.Error(1)
+}
+
+func (m *MockUploadService) Append(userID, id uuid.UUID, offset, length int64, r io.Reader) (*models.Upload, error) {
+	args := m.Called(userID, id, offset, length)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Upload), args.Error(1)
+}
+
+func (m *MockUploadService) Terminate(userID, id uuid.UUID) error {
+	return m.Called(userID, id).Error(0)
+}
+
+func (m *MockUploadService) CleanupExpired() (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+
+func setupUploadRouter(userID uuid.UUID) (*gin.Engine, *MockUploadService) {
+	gin.SetMode(gin.TestMode)
+	mockService := new(MockUploadService)
+	handler := handlers.NewUploadHandler(mockService)
+	router := gin.New()
+	uploads := router.Group("/api/v1/uploads", func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Set("user_role", models.RoleLibrarian)
+	}, handlers.TusMiddleware())
+	uploads.POST("", handler.Create)
+	uploads.HEAD("/:id", handler.Head)
+	uploads.PATCH("/:id", handler.Patch)
+	return router, mockService
+}
+
+func TestUploadHandler_RequiresTusVersion(t *testing.T) {
+	router, _ := setupUploadRouter(uuid.New())
+
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/uploads", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, "1.0.0", w.Header().Get("Tus-Version"))
+}
+
+func TestUploadHandler_Create(t *testing.T) {
+	userID, bookID := uuid.New(), uuid.New()
+	router, mockService := setupUploadRouter(userID)
+	upload := &models.Upload{ID: uuid.New(), Status: models.UploadStatusActive, Size: 1000, ExpiresAt: time.Now().Add(time.Hour)}
+
+	mockService.On("MaxSize", models.RoleLibrarian).Return(1 << 20)
+	mockService.On("Create", userID, models.RoleLibrarian, services.CreateUploadRequest{
+		BookID:   bookID,
+		FileName: "book.epub",
+		Size:     1000,
+		Metadata: "filename " + base64.StdEncoding.EncodeToString([]byte("book.epub")) + ",book_id " + base64.StdEncoding.EncodeToString([]byte(bookID.String())),
+	}).Return(upload, nil)
+
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/uploads", nil)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Length", "1000")
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("book.epub"))+",book_id "+base64.StdEncoding.EncodeToString([]byte(bookID.String())))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "/api/v1/uploads/"+upload.ID.String(), w.Header().Get("Location"))
+	assert.Equal(t, "0", w.Header().Get("Upload-Offset"))
+	assert.NotEmpty(t, w.Header().Get("Upload-Expires"))
+	mockService.AssertExpectations(t)
+}
+
+func TestUploadHandler_Patch(t *testing.T) {
+	userID, id := uuid.New(), uuid.New()
+	router, mockService := setupUploadRouter(userID)
+
+	patch := func(contentType, offset string, body []byte) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPatch, "/api/v1/uploads/"+id.String(), bytes.NewReader(body))
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Upload-Offset", offset)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := patch("application/octet-stream", "0", []byte("abc"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	mockService.On("Append", userID, id, int64(5), int64(3)).Return(nil, services.ErrUploadOffsetMismatch).Once()
+	w = patch("application/offset+octet-stream", "5", []byte("abc"))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	mockService.On("Append", userID, id, int64(0), int64(3)).Return(&models.Upload{ID: id, Offset: 3, Status: models.UploadStatusActive}, nil).Once()
+	w = patch("application/offset+octet-stream", "0", []byte("abc"))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "3", w.Header().Get("Upload-Offset"))
+	assert.Equal(t, "1.0.0", w.Header().Get("Tus-Resumable"))
+	mockService.AssertExpectations(t)
+}
+
+func TestUploadHandler_Head(t *testing.T) {
+	userID, id := uuid.New(), uuid.New()
+	router, mockService := setupUploadRouter(userID)
+	mockService.On("Get", userID, id).Return(&models.Upload{ID: id, Offset: 42, Size: 100, Metadata: "filename Ym9vaw==", Status: models.UploadStatusActive}, nil)
+
+	req, _ := http.NewRequest(http.MethodHead, "/api/v1/uploads/"+id.String(), nil)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "42", w.Header().Get("Upload-Offset"))
+	assert.Equal(t, "100", w.Header().Get("Upload-Length"))
+	assert.Equal(t, "filename Ym9vaw==", w.Header().Get("Upload-Metadata"))
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+}
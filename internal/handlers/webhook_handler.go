@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/middleware"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/services"
+)
+
+// WebhookHandler — управление исходящими вебхуками.
+// Используется и в /api/v1 (JWT), и в /ext/v1 (API-ключ): во втором случае
+// вебхуки привязываются к ключу, которым выполнен запрос.
+type WebhookHandler struct {
+	svc       services.WebhookService
+	validator *validator.Validate
+}
+
+func NewWebhookHandler(svc services.WebhookService, v *validator.Validate) *WebhookHandler {
+	return &WebhookHandler{svc: svc, validator: v}
+}
+
+func webhookOwnerFromContext(c *gin.Context) (services.WebhookOwner, error) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		return services.WebhookOwner{}, err
+	}
+	owner := services.WebhookOwner{UserID: userID}
+	if val, ok := c.Get(middleware.APIKeyIDContextKey); ok {
+		if keyID, ok := val.(uuid.UUID); ok {
+			owner.APIKeyID = &keyID
+		}
+	}
+	return owner, nil
+}
+
+func writeWebhookError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrWebhookNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponseDTO{Error: "Вебхук не найден"})
+	case errors.Is(err, services.ErrWebhookForbidden):
+		c.JSON(http.StatusForbidden, models.ErrorResponseDTO{Error: "Доступ запрещён"})
+	case errors.Is(err, services.ErrWebhookBadEvent), errors.Is(err, services.ErrWebhookBadURL),
+		errors.Is(err, services.ErrWebhookPrivateURL):
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Ошибка валидации", Message: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponseDTO{Error: "Ошибка сервера", Message: err.Error()})
+	}
+}
+
+// CreateWebhook godoc
+// @Summary      Создать вебхук
+// @Description  Подписывает URL на события библиотеки. Доставки подписываются HMAC-SHA256 (X-AFST-Signature), секрет возвращается один раз.
+// @Description  Приходят события владельца и общие события; all_users=true (только для администраторов) — события всех пользователей.
+// @Tags         Webhooks
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body body models.CreateWebhookDTO true "Параметры вебхука"
+// @Success      201  {object}  models.WebhookSecretDTO
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Failure      401  {object}  models.ErrorResponseDTO
+// @Failure      403  {object}  models.ErrorResponseDTO
+// @Router       /webhooks [post]
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	owner, err := webhookOwnerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+
+	var dto models.CreateWebhookDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат данных", Message: err.Error()})
+		return
+	}
+	if err := h.validator.Struct(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Ошибка валидации", Message: err.Error()})
+		return
+	}
+
+	hook, err := h.svc.Create(owner, &dto)
+	if err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, hook)
+}
+
+// ListWebhooks godoc
+// @Summary      Список моих вебхуков
+// @Tags         Webhooks
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   models.Webhook
+// @Failure      401  {object}  models.ErrorResponseDTO
+// @Router       /webhooks [get]
+func (h *WebhookHandler) ListWebhooks(c *gin.Context) {
+	owner, err := webhookOwnerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+
+	hooks, err := h.svc.List(owner)
+	if err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hooks)
+}
+
+// GetWebhook godoc
+// @Summary      Получить вебхук
+// @Tags         Webhooks
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string  true  "ID вебхука"
+// @Success      200  {object}  models.Webhook
+// @Failure      403  {object}  models.ErrorResponseDTO
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /webhooks/{id} [get]
+func (h *WebhookHandler) GetWebhook(c *gin.Context) {
+	owner, err := webhookOwnerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный ID вебхука"})
+		return
+	}
+
+	hook, err := h.svc.Get(owner, id)
+	if err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hook)
+}
+
+// UpdateWebhook godoc
+// @Summary      Обновить вебхук
+// @Description  Меняет URL, фильтр событий, описание или all_users. is_active=true включает вебхук, отключённый после серии ошибок.
+// @Tags         Webhooks
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string                   true  "ID вебхука"
+// @Param        body body      models.UpdateWebhookDTO  true  "Изменения"
+// @Success      200  {object}  models.Webhook
+// @Failure      400  {object}  models.ErrorResponseDTO
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /webhooks/{id} [put]
+func (h *WebhookHandler) UpdateWebhook(c *gin.Context) {
+	owner, err := webhookOwnerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный ID вебхука"})
+		return
+	}
+
+	var dto models.UpdateWebhookDTO
+	if err := c.ShouldBindJSON(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный формат данных", Message: err.Error()})
+		return
+	}
+	if err := h.validator.Struct(&dto); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Ошибка валидации", Message: err.Error()})
+		return
+	}
+
+	hook, err := h.svc.Update(owner, id, &dto)
+	if err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hook)
+}
+
+// DeleteWebhook godoc
+// @Summary      Удалить вебхук
+// @Tags         Webhooks
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string  true  "ID вебхука"
+// @Success      200  {object}  models.SuccessResponseDTO
+// @Failure      403  {object}  models.ErrorResponseDTO
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /webhooks/{id} [delete]
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	owner, err := webhookOwnerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный ID вебхука"})
+		return
+	}
+
+	if err := h.svc.Delete(owner, id); err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponseDTO{Message: "Вебхук удалён"})
+}
+
+// RotateSecret godoc
+// @Summary      Перевыпустить секрет вебхука
+// @Tags         Webhooks
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string  true  "ID вебхука"
+// @Success      200  {object}  models.WebhookSecretDTO
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /webhooks/{id}/rotate-secret [post]
+func (h *WebhookHandler) RotateSecret(c *gin.Context) {
+	owner, err := webhookOwnerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный ID вебхука"})
+		return
+	}
+
+	hook, err := h.svc.RotateSecret(owner, id)
+	if err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, hook)
+}
+
+// ListDeliveries godoc
+// @Summary      Журнал доставок вебхука
+// @Tags         Webhooks
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id     path      string  true   "ID вебхука"
+// @Param        limit  query     int     false  "Количество записей (по умолчанию 50, максимум 200)"
+// @Success      200  {array}   models.WebhookDelivery
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /webhooks/{id}/deliveries [get]
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	owner, err := webhookOwnerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный ID вебхука"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	deliveries, err := h.svc.ListDeliveries(owner, id, limit)
+	if err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// Redeliver godoc
+// @Summary      Повторить доставку
+// @Description  Ставит в очередь новую доставку с тем же event_id и телом.
+// @Tags         Webhooks
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id           path      string  true  "ID вебхука"
+// @Param        delivery_id  path      string  true  "ID доставки"
+// @Success      202  {object}  models.WebhookDelivery
+// @Failure      404  {object}  models.ErrorResponseDTO
+// @Router       /webhooks/{id}/deliveries/{delivery_id}/redeliver [post]
+func (h *WebhookHandler) Redeliver(c *gin.Context) {
+	owner, err := webhookOwnerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный ID вебхука"})
+		return
+	}
+	deliveryID, err := uuid.Parse(c.Param("delivery_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponseDTO{Error: "Неверный ID доставки"})
+		return
+	}
+
+	delivery, err := h.svc.Redeliver(owner, id, deliveryID)
+	if err != nil {
+		writeWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
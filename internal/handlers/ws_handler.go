@@ -0,0 +1,506 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/oneErrortime/afst/internal/events"
+	"github.com/oneErrortime/afst/internal/middleware"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/services"
+)
+
+const (
+	wsMaxConnsPerUser = 5
+	wsSendBuffer      = 64
+	wsMaxMessageSize  = 4096
+	wsWriteWait       = 10 * time.Second
+	wsPongWait        = 60 * time.Second
+	wsPingPeriod      = 50 * time.Second
+	// wsProgressFlush — как часто накопленный прогресс пишется в БД
+	wsProgressFlush = 2 * time.Second
+	// wsMaxReadGap — больший промежуток между перелистываниями не засчитывается как чтение
+	wsMaxReadGap = 2 * time.Minute
+)
+
+// Сообщения клиента
+const (
+	wsMsgProgress  = "progress"
+	wsMsgHeartbeat = "heartbeat"
+	wsMsgPresence  = "presence"
+)
+
+// Состояния presence
+const (
+	wsPresenceReading = "reading"
+	wsPresenceIdle    = "idle"
+	wsPresenceClosed  = "closed"
+)
+
+// wsInbound — сообщение от клиента
+type wsInbound struct {
+	Type        string    `json:"type"`
+	AccessID    uuid.UUID `json:"access_id,omitempty"`
+	CurrentPage int       `json:"current_page,omitempty"`
+	TotalPages  int       `json:"total_pages,omitempty"`
+	State       string    `json:"state,omitempty"`
+}
+
+// wsOutbound — сообщение клиенту: событие шины, pong или ошибка
+type wsOutbound struct {
+	Type  string           `json:"type"`
+	ID    string           `json:"id,omitempty"`
+	Event events.EventType `json:"event,omitempty"`
+	Data  interface{}      `json:"data,omitempty"`
+	Error string           `json:"error,omitempty"`
+}
+
+// WSHandler — двунаправленный канал реального времени.
+// Вниз идут те же события, что и в SSE (с теми же ID для возобновления),
+// вверх — прогресс чтения, heartbeat и presence, которые ведут сессии чтения.
+type WSHandler struct {
+	replay         *events.ReplayBuffer
+	bus            *events.Bus
+	accessService  services.BookAccessService
+	sessionService services.ReadingSessionService
+	upgrader       websocket.Upgrader
+
+	mu    sync.Mutex
+	conns map[uuid.UUID]int
+}
+
+func NewWSHandler(
+	replay *events.ReplayBuffer,
+	bus *events.Bus,
+	accessService services.BookAccessService,
+	sessionService services.ReadingSessionService,
+) *WSHandler {
+	return &WSHandler{
+		replay:         replay,
+		bus:            bus,
+		accessService:  accessService,
+		sessionService: sessionService,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			// Авторизация по токену, а не по cookie — как и CORS, пускаем любой Origin
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		conns: make(map[uuid.UUID]int),
+	}
+}
+
+func (h *WSHandler) acquire(userID uuid.UUID) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conns[userID] >= wsMaxConnsPerUser {
+		return false
+	}
+	h.conns[userID]++
+	return true
+}
+
+func (h *WSHandler) release(userID uuid.UUID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conns[userID] <= 1 {
+		delete(h.conns, userID)
+		return
+	}
+	h.conns[userID]--
+}
+
+// Connect godoc
+// @Summary      WebSocket-канал реального времени
+// @Description  Вниз: {"type":"event","id","event","data"}. Вверх: {"type":"progress","access_id","current_page","total_pages"}, {"type":"heartbeat"}, {"type":"presence","access_id","state":"reading|idle|closed"}.
+// @Tags         events
+// @Security     BearerAuth
+// @Param        last_event_id  query  string  false  "Продолжить после события с этим ID"
+// @Success      101
+// @Failure      401  {object}  models.ErrorResponseDTO
+// @Failure      429  {object}  models.ErrorResponseDTO
+// @Router       /events/ws [get]
+func (h *WSHandler) Connect(c *gin.Context) {
+	userID, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponseDTO{Error: "Не авторизован"})
+		return
+	}
+	if !h.acquire(userID) {
+		c.JSON(http.StatusTooManyRequests, models.ErrorResponseDTO{
+			Error:   "Слишком много подключений",
+			Message: "Превышен лимит одновременных подключений: " + strconv.Itoa(wsMaxConnsPerUser),
+		})
+		return
+	}
+	defer h.release(userID)
+
+	ws, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade уже ответил клиенту
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lastSeq, _ := strconv.ParseUint(c.Query("last_event_id"), 10, 64)
+	backlog, live, complete := h.replay.Attach(ctx, userID.String(), lastSeq)
+
+	conn := &wsConn{
+		h:      h,
+		ws:     ws,
+		id:     uuid.NewString(),
+		userID: userID,
+		// Бэклог целиком помещается в очередь до старта writeLoop
+		send:     make(chan wsOutbound, wsSendBuffer+len(backlog)+2),
+		ctx:      ctx,
+		cancel:   cancel,
+		accesses: make(map[uuid.UUID]*models.BookAccess),
+		progress: make(map[uuid.UUID]*wsProgress),
+		sessions: make(map[uuid.UUID]uuid.UUID),
+		client: models.SessionClient{
+			UserAgent:  c.Request.UserAgent(),
+			IPAddress:  c.ClientIP(),
+			DeviceType: models.DetectDeviceType(c.Request.UserAgent()),
+		},
+	}
+
+	conn.enqueue(wsOutbound{Type: "connected", Data: map[string]string{"user_id": userID.String()}})
+	if !complete {
+		conn.enqueue(wsOutbound{Type: "reset"})
+	}
+	for _, event := range backlog {
+		conn.enqueueEvent(event)
+	}
+
+	go conn.writeLoop()
+	go conn.pump(live)
+	go conn.flushLoop()
+	conn.readLoop()
+}
+
+// wsProgress — последний непереданный в БД прогресс по одной выдаче
+type wsProgress struct {
+	page     int
+	total    int
+	readTime time.Duration
+	lastAt   time.Time
+	dirty    bool
+}
+
+type wsConn struct {
+	h      *WSHandler
+	ws     *websocket.Conn
+	id     string
+	userID uuid.UUID
+	send   chan wsOutbound
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	accesses map[uuid.UUID]*models.BookAccess
+	progress map[uuid.UUID]*wsProgress
+	sessions map[uuid.UUID]uuid.UUID // access_id → id сессии чтения
+	client   models.SessionClient
+}
+
+// enqueue не блокирует: если клиент не успевает читать, соединение закрывается,
+// клиент переподключится с last_event_id и догонит пропущенное из буфера
+func (c *wsConn) enqueue(msg wsOutbound) {
+	select {
+	case <-c.ctx.Done():
+	case c.send <- msg:
+	default:
+		log.Printf("[ws] closing slow connection of user %s", c.userID)
+		c.cancel()
+	}
+}
+
+func (c *wsConn) enqueueEvent(event events.StreamEvent) {
+	// Свой же прогресс обратно не отправляем
+	if p, ok := event.Payload.(events.ProgressPayload); ok && p.ConnectionID == c.id {
+		return
+	}
+	c.enqueue(wsOutbound{
+		Type:  "event",
+		ID:    strconv.FormatUint(event.Seq, 10),
+		Event: event.Type,
+		Data:  event.Payload,
+	})
+}
+
+func (c *wsConn) pump(live <-chan events.StreamEvent) {
+	for event := range live {
+		c.enqueueEvent(event)
+	}
+	// Буфер закрыл подписку (отстали или отключились)
+	c.cancel()
+}
+
+func (c *wsConn) writeLoop() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		_ = c.ws.Close()
+	}()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			_ = c.ws.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseTryAgainLater, ""),
+				time.Now().Add(time.Second))
+			return
+		case msg := <-c.send:
+			_ = c.ws.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.ws.WriteJSON(msg); err != nil {
+				c.cancel()
+				return
+			}
+		case <-ticker.C:
+			_ = c.ws.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.cancel()
+				return
+			}
+		}
+	}
+}
+
+func (c *wsConn) flushLoop() {
+	ticker := time.NewTicker(wsProgressFlush)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.flushProgress()
+		}
+	}
+}
+
+func (c *wsConn) readLoop() {
+	defer func() {
+		c.cancel()
+		c.flushProgress()
+		c.endSessions()
+	}()
+
+	c.ws.SetReadLimit(wsMaxMessageSize)
+	_ = c.ws.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = c.ws.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		var msg wsInbound
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.enqueue(wsOutbound{Type: "error", Error: "Неверный формат сообщения"})
+			continue
+		}
+
+		switch msg.Type {
+		case wsMsgHeartbeat:
+			c.enqueue(wsOutbound{Type: "pong"})
+		case wsMsgProgress:
+			c.handleProgress(msg)
+		case wsMsgPresence:
+			c.handlePresence(msg)
+		default:
+			c.enqueue(wsOutbound{Type: "error", Error: "Неизвестный тип сообщения"})
+		}
+	}
+}
+
+// access возвращает выдачу, если она принадлежит пользователю соединения и действует
+func (c *wsConn) access(id uuid.UUID) *models.BookAccess {
+	c.mu.Lock()
+	access, ok := c.accesses[id]
+	c.mu.Unlock()
+	if ok {
+		return access
+	}
+
+	access, err := c.h.accessService.GetByID(id)
+	if err != nil || access.UserID != c.userID || !access.IsValid() {
+		c.enqueue(wsOutbound{Type: "error", Error: "Доступ к книге не найден"})
+		return nil
+	}
+
+	c.mu.Lock()
+	c.accesses[id] = access
+	c.mu.Unlock()
+	return access
+}
+
+func (c *wsConn) handleProgress(msg wsInbound) {
+	if msg.CurrentPage < 0 || msg.TotalPages < 0 {
+		c.enqueue(wsOutbound{Type: "error", Error: "Неверный номер страницы"})
+		return
+	}
+	if c.access(msg.AccessID) == nil {
+		return
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	p, ok := c.progress[msg.AccessID]
+	if !ok {
+		p = &wsProgress{}
+		c.progress[msg.AccessID] = p
+	}
+	if !p.lastAt.IsZero() {
+		if gap := now.Sub(p.lastAt); gap < wsMaxReadGap {
+			p.readTime += gap
+		}
+	}
+	p.page, p.total, p.lastAt, p.dirty = msg.CurrentPage, msg.TotalPages, now, true
+	c.mu.Unlock()
+}
+
+func (c *wsConn) handlePresence(msg wsInbound) {
+	access := c.access(msg.AccessID)
+	if access == nil {
+		return
+	}
+
+	switch msg.State {
+	case wsPresenceReading:
+		c.mu.Lock()
+		_, active := c.sessions[access.ID]
+		c.mu.Unlock()
+		if active {
+			return
+		}
+		session, err := c.h.sessionService.StartSession(c.userID, access.BookID, access.ID, c.client)
+		if err != nil {
+			c.enqueue(wsOutbound{Type: "error", Error: "Не удалось начать сессию чтения"})
+			return
+		}
+		c.mu.Lock()
+		c.sessions[access.ID] = session.ID
+		c.mu.Unlock()
+
+	case wsPresenceIdle, wsPresenceClosed:
+		c.flushProgress()
+		c.endSession(access.ID)
+
+	default:
+		c.enqueue(wsOutbound{Type: "error", Error: "Неизвестное состояние presence"})
+	}
+}
+
+// flushProgress пишет накопленный прогресс в БД и рассылает его другим устройствам
+func (c *wsConn) flushProgress() {
+	type update struct {
+		accessID uuid.UUID
+		bookID   uuid.UUID
+		wsProgress
+	}
+
+	c.mu.Lock()
+	var updates []update
+	for id, p := range c.progress {
+		if !p.dirty {
+			continue
+		}
+		updates = append(updates, update{accessID: id, bookID: c.accesses[id].BookID, wsProgress: *p})
+		p.dirty = false
+		p.readTime = 0
+	}
+	c.mu.Unlock()
+
+	for _, u := range updates {
+		if err := c.h.accessService.UpdateProgress(u.accessID, u.page, u.readTime); err != nil {
+			log.Printf("[ws] failed to save progress for access %s: %v", u.accessID, err)
+			continue
+		}
+		c.heartbeat(u.accessID, u.page)
+
+		var percent float32
+		if u.total > 0 {
+			percent = float32(u.page) / float32(u.total)
+		}
+		c.h.bus.Publish(events.Event{
+			Type:   events.EventReadingProgress,
+			UserID: c.userID.String(),
+			Payload: events.ProgressPayload{
+				BookID:       u.bookID.String(),
+				AccessID:     u.accessID.String(),
+				CurrentPage:  u.page,
+				TotalPages:   u.total,
+				Progress:     percent,
+				ConnectionID: c.id,
+			},
+		})
+	}
+}
+
+// heartbeat засчитывает перелистывание как активность в сессии чтения.
+// Сессию, закрытую по простою, забываем — следующий presence начнёт новую.
+func (c *wsConn) heartbeat(accessID uuid.UUID, page int) {
+	c.mu.Lock()
+	sessionID, ok := c.sessions[accessID]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	_, err := c.h.sessionService.Heartbeat(c.userID, sessionID, &models.SessionHeartbeatDTO{Page: &page})
+	if errors.Is(err, services.ErrSessionEnded) {
+		c.mu.Lock()
+		if c.sessions[accessID] == sessionID {
+			delete(c.sessions, accessID)
+		}
+		c.mu.Unlock()
+	} else if err != nil {
+		log.Printf("[ws] failed to record heartbeat for session %s: %v", sessionID, err)
+	}
+}
+
+func (c *wsConn) endSession(accessID uuid.UUID) {
+	c.mu.Lock()
+	sessionID, ok := c.sessions[accessID]
+	delete(c.sessions, accessID)
+	page := 0
+	if p, has := c.progress[accessID]; has {
+		page = p.page
+	} else if access, has := c.accesses[accessID]; has {
+		page = access.CurrentPage
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	if err := c.h.sessionService.EndSession(c.userID, sessionID, page); err != nil {
+		log.Printf("[ws] failed to end session %s: %v", sessionID, err)
+	}
+}
+
+func (c *wsConn) endSessions() {
+	c.mu.Lock()
+	ids := make([]uuid.UUID, 0, len(c.sessions))
+	for accessID := range c.sessions {
+		ids = append(ids, accessID)
+	}
+	c.mu.Unlock()
+
+	for _, id := range ids {
+		c.endSession(id)
+	}
+}
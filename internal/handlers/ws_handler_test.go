@@ -0,0 +1,226 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/oneErrortime/afst/internal/events"
+	"github.com/oneErrortime/afst/internal/handlers"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockBookAccessService is a mock for the BookAccessService
+type MockBookAccessService struct {
+	mock.Mock
+}
+
+func (m *MockBookAccessService) GrantAccess(dto *models.GrantAccessDTO) (*models.BookAccess, error) {
+	args := m.Called(dto)
+	return args.Get(0).(*models.BookAccess), args.Error(1)
+}
+
+func (m *MockBookAccessService) GetByID(id uuid.UUID) (*models.BookAccess, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.BookAccess), args.Error(1)
+}
+
+func (m *MockBookAccessService) GetByUserID(userID uuid.UUID) ([]models.BookAccess, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]models.BookAccess), args.Error(1)
+}
+
+func (m *MockBookAccessService) GetActiveByUserAndBook(userID, bookID uuid.UUID) (*models.BookAccess, error) {
+	args := m.Called(userID, bookID)
+	return args.Get(0).(*models.BookAccess), args.Error(1)
+}
+
+func (m *MockBookAccessService) CheckAccess(userID, bookID uuid.UUID) (bool, error) {
+	args := m.Called(userID, bookID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockBookAccessService) RevokeAccess(id uuid.UUID) error {
+	return m.Called(id).Error(0)
+}
+
+func (m *MockBookAccessService) UpdateProgress(id uuid.UUID, currentPage int, readTime time.Duration) error {
+	return m.Called(id, currentPage, readTime).Error(0)
+}
+
+func (m *MockBookAccessService) GetUserLibrary(userID uuid.UUID) ([]models.BookAccessWithBook, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]models.BookAccessWithBook), args.Error(1)
+}
+
+func (m *MockBookAccessService) ExpireOverdue() (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// MockReadingSessionService is a mock for the ReadingSessionService
+type MockReadingSessionService struct {
+	mock.Mock
+}
+
+func (m *MockReadingSessionService) StartSession(userID, bookID, accessID uuid.UUID, client models.SessionClient) (*models.ReadingSession, error) {
+	args := m.Called(userID, bookID, accessID, client)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.ReadingSession), args.Error(1)
+}
+
+func (m *MockReadingSessionService) Heartbeat(userID, sessionID uuid.UUID, dto *models.SessionHeartbeatDTO) (*models.ReadingSession, error) {
+	args := m.Called(userID, sessionID, dto)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.ReadingSession), args.Error(1)
+}
+
+func (m *MockReadingSessionService) EndSession(userID, sessionID uuid.UUID, endPage int) error {
+	return m.Called(userID, sessionID, endPage).Error(0)
+}
+
+func (m *MockReadingSessionService) CloseIdle() (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockReadingSessionService) GetUserSessions(userID uuid.UUID, limit int) ([]models.ReadingSession, error) {
+	args := m.Called(userID, limit)
+	return args.Get(0).([]models.ReadingSession), args.Error(1)
+}
+
+func (m *MockReadingSessionService) GetBookStats(bookID uuid.UUID) (*models.BookReadingStats, error) {
+	args := m.Called(bookID)
+	return args.Get(0).(*models.BookReadingStats), args.Error(1)
+}
+
+type wsMessage struct {
+	Type  string                 `json:"type"`
+	ID    string                 `json:"id"`
+	Event string                 `json:"event"`
+	Data  map[string]interface{} `json:"data"`
+	Error string                 `json:"error"`
+}
+
+func setupWSTestServer(t *testing.T, userID uuid.UUID) (*httptest.Server, *MockBookAccessService, *MockReadingSessionService) {
+	gin.SetMode(gin.TestMode)
+	accessService := new(MockBookAccessService)
+	sessionService := new(MockReadingSessionService)
+
+	bus := events.NewBus(64)
+	replay := events.NewReplayBuffer(64, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	replay.Run(ctx, bus)
+
+	h := handlers.NewWSHandler(replay, bus, accessService, sessionService)
+	router := gin.New()
+	router.GET("/ws", func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Next()
+	}, h.Connect)
+
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv, accessService, sessionService
+}
+
+func dialWS(t *testing.T, srv *httptest.Server) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	var hello wsMessage
+	require.NoError(t, conn.ReadJSON(&hello))
+	require.Equal(t, "connected", hello.Type)
+	return conn
+}
+
+func TestWSHandler_ProgressSyncsOtherDevices(t *testing.T) {
+	userID := uuid.New()
+	srv, accessService, sessionService := setupWSTestServer(t, userID)
+
+	access := &models.BookAccess{
+		ID:      uuid.New(),
+		UserID:  userID,
+		BookID:  uuid.New(),
+		Status:  models.AccessStatusActive,
+		EndDate: time.Now().Add(time.Hour),
+	}
+	sessionID := uuid.New()
+	accessService.On("GetByID", access.ID).Return(access, nil)
+	accessService.On("UpdateProgress", access.ID, 42, mock.Anything).Return(nil)
+	fromRequest := mock.MatchedBy(func(c models.SessionClient) bool { return c.UserAgent != "" && c.IPAddress != "" })
+	onPage42 := mock.MatchedBy(func(dto *models.SessionHeartbeatDTO) bool { return dto.Page != nil && *dto.Page == 42 })
+	sessionService.On("StartSession", userID, access.BookID, access.ID, fromRequest).Return(&models.ReadingSession{ID: sessionID}, nil)
+	sessionService.On("Heartbeat", userID, sessionID, onPage42).Return(&models.ReadingSession{ID: sessionID}, nil)
+	sessionService.On("EndSession", userID, sessionID, 42).Return(nil)
+
+	reader := dialWS(t, srv)
+	other := dialWS(t, srv)
+
+	require.NoError(t, reader.WriteJSON(map[string]interface{}{"type": "presence", "access_id": access.ID, "state": "reading"}))
+	require.NoError(t, reader.WriteJSON(map[string]interface{}{"type": "progress", "access_id": access.ID, "current_page": 42, "total_pages": 100}))
+	require.NoError(t, reader.WriteJSON(map[string]interface{}{"type": "presence", "access_id": access.ID, "state": "idle"}))
+	require.NoError(t, reader.WriteJSON(map[string]interface{}{"type": "heartbeat"}))
+
+	_ = other.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg wsMessage
+	require.NoError(t, other.ReadJSON(&msg))
+	assert.Equal(t, "event", msg.Type)
+	assert.Equal(t, string(events.EventReadingProgress), msg.Event)
+	assert.NotEmpty(t, msg.ID)
+	assert.Equal(t, float64(42), msg.Data["current_page"])
+
+	// The reporting device gets only its pong, not its own progress back
+	_ = reader.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.NoError(t, reader.ReadJSON(&msg))
+	assert.Equal(t, "pong", msg.Type)
+
+	accessService.AssertExpectations(t)
+	sessionService.AssertExpectations(t)
+}
+
+func TestWSHandler_RejectsForeignAccess(t *testing.T) {
+	srv, accessService, _ := setupWSTestServer(t, uuid.New())
+
+	foreign := &models.BookAccess{ID: uuid.New(), UserID: uuid.New(), Status: models.AccessStatusActive, EndDate: time.Now().Add(time.Hour)}
+	accessService.On("GetByID", foreign.ID).Return(foreign, nil)
+
+	conn := dialWS(t, srv)
+	require.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "progress", "access_id": foreign.ID, "current_page": 1, "total_pages": 2}))
+
+	var msg wsMessage
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "error", msg.Type)
+	accessService.AssertNotCalled(t, "UpdateProgress", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestWSHandler_CapsConnectionsPerUser(t *testing.T) {
+	srv, _, _ := setupWSTestServer(t, uuid.New())
+
+	for i := 0; i < 5; i++ {
+		dialWS(t, srv)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	require.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
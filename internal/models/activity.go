@@ -0,0 +1,111 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ActivityType — вид события в ленте активности
+type ActivityType string
+
+const (
+	// ActivityBookFinished — пользователь дочитал книгу (субъект — выдача)
+	ActivityBookFinished ActivityType = "book_finished"
+	// ActivityReviewWritten — написал рецензию
+	ActivityReviewWritten ActivityType = "review_written"
+	// ActivityCollectionPublished — открыл коллекцию для всех
+	ActivityCollectionPublished ActivityType = "collection_published"
+	// ActivityChallengeJoined — вступил в челлендж
+	ActivityChallengeJoined ActivityType = "challenge_joined"
+)
+
+// ActivityTypes — все виды событий, в порядке показа в настройках
+var ActivityTypes = []ActivityType{
+	ActivityBookFinished,
+	ActivityReviewWritten,
+	ActivityCollectionPublished,
+	ActivityChallengeJoined,
+}
+
+// Activity — событие в ленте пользователя. Записывается всегда, а видимость
+// для подписчиков решается при чтении по ActivityPreference: пользователь,
+// снова открывший вид событий, открывает и прошлые события.
+//
+// Одно событие на субъект: повторная запись того же субъекта обновляет
+// только Title (например, коллекцию переименовали).
+type Activity struct {
+	ID        uuid.UUID    `json:"id" gorm:"type:text;primary_key"`
+	UserID    uuid.UUID    `json:"user_id" gorm:"type:text;not null;index:idx_activities_user_created;uniqueIndex:idx_activities_subject"`
+	Type      ActivityType `json:"type" gorm:"type:text;not null;uniqueIndex:idx_activities_subject"`
+	SubjectID uuid.UUID    `json:"subject_id" gorm:"type:text;not null;uniqueIndex:idx_activities_subject"`
+	BookID    *uuid.UUID   `json:"book_id,omitempty" gorm:"type:text;index"`
+	// Title — название коллекции или челленджа на момент события
+	Title     string    `json:"title,omitempty"`
+	CreatedAt time.Time `json:"created_at" gorm:"index:idx_activities_user_created"`
+
+	User *User `json:"-" gorm:"foreignKey:UserID"`
+	Book *Book `json:"-" gorm:"foreignKey:BookID"`
+}
+
+func (Activity) TableName() string {
+	return "activities"
+}
+
+func (a *Activity) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+// ActivityPreference — показывать ли подписчикам события этого вида. Строка
+// есть только для видов, которые пользователь настраивал; по умолчанию все
+// события видны.
+type ActivityPreference struct {
+	UserID    uuid.UUID    `json:"-" gorm:"type:text;primary_key"`
+	Type      ActivityType `json:"type" gorm:"type:text;primary_key"`
+	Shared    bool         `json:"shared" gorm:"not null"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+func (ActivityPreference) TableName() string {
+	return "activity_preferences"
+}
+
+// UserSummaryDTO — публичные данные пользователя в списках и ленте
+type UserSummaryDTO struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	AvatarURL *string   `json:"avatar_url,omitempty"`
+}
+
+// NewUserSummaryDTO — только публичные поля, без email и роли
+func NewUserSummaryDTO(u *User) UserSummaryDTO {
+	return UserSummaryDTO{ID: u.ID, Name: u.Name, AvatarURL: u.AvatarURL}
+}
+
+// ActivityBookDTO — книга, о которой событие
+type ActivityBookDTO struct {
+	ID     uuid.UUID `json:"id"`
+	Title  string    `json:"title"`
+	Author string    `json:"author"`
+}
+
+// ActivityDTO — событие в ленте
+type ActivityDTO struct {
+	ID        uuid.UUID        `json:"id"`
+	Type      ActivityType     `json:"type"`
+	Actor     UserSummaryDTO   `json:"actor"`
+	SubjectID uuid.UUID        `json:"subject_id"`
+	Book      *ActivityBookDTO `json:"book,omitempty"`
+	Title     string           `json:"title,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// ActivityPrivacyDTO — какие виды событий видят подписчики. В ответе есть
+// все виды; в запросе достаточно тех, что меняются.
+type ActivityPrivacyDTO struct {
+	Shared map[ActivityType]bool `json:"shared" validate:"required,dive,keys,oneof=book_finished review_written collection_published challenge_joined,endkeys"`
+}
@@ -0,0 +1,82 @@
+package models
+
+import "github.com/google/uuid"
+
+// AnnotationType — выделение текста или заметка
+type AnnotationType string
+
+const (
+	AnnotationHighlight AnnotationType = "highlight"
+	AnnotationNote      AnnotationType = "note"
+)
+
+// AnnotationColor — цвет выделения
+type AnnotationColor string
+
+const (
+	ColorYellow AnnotationColor = "yellow"
+	ColorGreen  AnnotationColor = "green"
+	ColorBlue   AnnotationColor = "blue"
+	ColorPink   AnnotationColor = "pink"
+	ColorPurple AnnotationColor = "purple"
+)
+
+// AnnotationVisibility — кто кроме автора видит аннотацию
+type AnnotationVisibility string
+
+const (
+	// VisibilityPrivate — только автор
+	VisibilityPrivate AnnotationVisibility = "private"
+	// VisibilityFollowers — автор и его подписчики
+	VisibilityFollowers AnnotationVisibility = "followers"
+	// VisibilityPublic — все пользователи
+	VisibilityPublic AnnotationVisibility = "public"
+)
+
+// AnnotationExportFormat — формат выгрузки аннотаций
+type AnnotationExportFormat string
+
+const (
+	ExportMarkdown AnnotationExportFormat = "markdown"
+	ExportJSON     AnnotationExportFormat = "json"
+	// ExportClippings — формат файла «My Clippings.txt» читалок Kindle
+	ExportClippings AnnotationExportFormat = "clippings"
+)
+
+// CreateAnnotationDTO — место задаётся локатором или строкой location, как у закладок.
+// У выделения обязателен highlighted_text, у заметки — note.
+type CreateAnnotationDTO struct {
+	BookID          uuid.UUID            `json:"book_id" validate:"required"`
+	Type            AnnotationType       `json:"type" validate:"required,oneof=highlight note"`
+	Location        string               `json:"location" validate:"required_without=Locator"`
+	Locator         *Locator             `json:"locator,omitempty"`
+	HighlightedText string               `json:"highlighted_text" validate:"max=10000"`
+	Note            string               `json:"note" validate:"max=10000"`
+	Color           AnnotationColor      `json:"color" validate:"omitempty,oneof=yellow green blue pink purple"`
+	Tags            []string             `json:"tags" validate:"max=20,dive,required,max=50"`
+	Visibility      AnnotationVisibility `json:"visibility" validate:"omitempty,oneof=private followers public"`
+}
+
+// UpdateAnnotationDTO — место и книга аннотации не меняются
+type UpdateAnnotationDTO struct {
+	HighlightedText *string               `json:"highlighted_text" validate:"omitempty,max=10000"`
+	Note            *string               `json:"note" validate:"omitempty,max=10000"`
+	Color           *AnnotationColor      `json:"color" validate:"omitempty,oneof=yellow green blue pink purple"`
+	Tags            []string              `json:"tags" validate:"omitempty,max=20,dive,required,max=50"`
+	Visibility      *AnnotationVisibility `json:"visibility" validate:"omitempty,oneof=private followers public"`
+}
+
+// AnnotationFilter — отбор аннотаций; пустые поля не ограничивают выборку
+type AnnotationFilter struct {
+	UserID *uuid.UUID
+	BookID *uuid.UUID
+	// ReadableBy — только книги, к которым у этого пользователя есть
+	// действующий доступ
+	ReadableBy *uuid.UUID
+	Type       AnnotationType
+	Color      AnnotationColor
+	Tag        string
+	// Limit 0 — без ограничения (выгрузка, офлайн-пакет)
+	Limit  int
+	Offset int
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// BlobCollecting — значение RefCount у файла, который удаляет сборщик мусора.
+// Новые ссылки на такой файл не принимаются, пока запись не исчезнет.
+const BlobCollecting = -1
+
+// Blob — файл книги в хранилище. Файлы адресуются SHA-256 содержимого, и
+// одну копию могут использовать несколько записей BookFile; RefCount
+// считает их. Файл без ссылок удаляется сборщиком мусора после паузы.
+type Blob struct {
+	FilePath  string    `json:"file_path" gorm:"primaryKey"`
+	Hash      string    `json:"hash" gorm:"not null;index"`
+	Size      int64     `json:"size" gorm:"not null"`
+	RefCount  int       `json:"ref_count" gorm:"not null;default:0;index"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (Blob) TableName() string {
+	return "blobs"
+}
@@ -37,6 +37,7 @@ type BookAccess struct {
 	ReadProgress   float32      `json:"read_progress" gorm:"default:0"`
 	CurrentPage    int          `json:"current_page" gorm:"default:0"`
 	TotalReadTime  int          `json:"total_read_time" gorm:"default:0"`
+	FinishedAt     *time.Time   `json:"finished_at,omitempty" gorm:"index"` // когда книга впервые дочитана до конца
 	GrantedBy      *uuid.UUID   `json:"granted_by,omitempty" gorm:"type:text"`
 	Notes          *string      `json:"notes,omitempty"`
 	CreatedAt      time.Time    `json:"created_at"`
@@ -93,8 +94,17 @@ func (a *BookAccess) Return() {
 func (a *BookAccess) UpdateProgress(page int, totalPages int) {
 	a.CurrentPage = page
 	if totalPages > 0 {
-		a.ReadProgress = float32(page) / float32(totalPages) * 100
+		a.SetProgress(float32(min(page, totalPages)) / float32(totalPages) * 100)
 	}
 	now := time.Now()
 	a.LastAccessedAt = &now
 }
+
+// SetProgress записывает прогресс в процентах и отмечает, когда книга дочитана впервые
+func (a *BookAccess) SetProgress(progress float32) {
+	a.ReadProgress = progress
+	if progress >= 100 && a.FinishedAt == nil {
+		now := time.Now()
+		a.FinishedAt = &now
+	}
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CoverSource string
+
+const (
+	CoverSourceEPUB   CoverSource = "epub"
+	CoverSourcePDF    CoverSource = "pdf"
+	CoverSourceMOBI   CoverSource = "mobi"
+	CoverSourceUpload CoverSource = "upload"
+)
+
+// CoverSizeOriginal — исходное изображение без масштабирования
+const CoverSizeOriginal = "original"
+
+// BookCover — обложка книги: оригинал и набор JPEG-миниатюр в файловом хранилище.
+// Пути файлов выводятся из хеша, поэтому миниатюры прежней обложки никогда
+// не отдаются под видом новой.
+type BookCover struct {
+	BookID          uuid.UUID   `json:"book_id" gorm:"type:text;primary_key"`
+	Source          CoverSource `json:"source" gorm:"type:text;not null"`
+	ContentType     string      `json:"content_type" gorm:"not null"`
+	Hash            string      `json:"-" gorm:"not null"`
+	Width           int         `json:"width"`
+	Height          int         `json:"height"`
+	FileSize        int64       `json:"file_size"`
+	FilePath        string      `json:"-" gorm:"not null"`
+	ThumbnailsReady bool        `json:"thumbnails_ready" gorm:"not null;default:false"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+}
+
+func (BookCover) TableName() string {
+	return "book_covers"
+}
+
+// ThumbnailPath — путь миниатюры размера size в хранилище
+func (c *BookCover) ThumbnailPath(size string) string {
+	return c.storagePath(size, ".jpg")
+}
+
+// OriginalPath — путь оригинала; ext зависит от формата изображения
+func (c *BookCover) OriginalPath(ext string) string {
+	return c.storagePath(CoverSizeOriginal, ext)
+}
+
+func (c *BookCover) storagePath(size, ext string) string {
+	return fmt.Sprintf("covers/%s/%s_%s%s", c.BookID, c.Hash[:16], size, ext)
+}
+
+// ETag меняется вместе с содержимым обложки
+func (c *BookCover) ETag(size string) string {
+	return fmt.Sprintf(`"%s-%s"`, c.Hash[:16], size)
+}
+
+// CoverURL — публичный адрес обложки; версия в запросе сбрасывает кэши при замене
+func (c *BookCover) CoverURL() string {
+	return fmt.Sprintf("/api/v1/books/%s/cover?v=%s", c.BookID, c.Hash[:8])
+}
@@ -20,7 +20,7 @@ type BookFile struct {
 	BookID       uuid.UUID  `json:"book_id" gorm:"type:text;not null;index"`
 	FileName     string     `json:"file_name" gorm:"not null"`
 	OriginalName string     `json:"original_name" gorm:"not null"`
-	FilePath     string     `json:"-" gorm:"not null"`
+	FilePath     string     `json:"-" gorm:"not null;index"`
 	FileType     FileType   `json:"file_type" gorm:"type:text;not null"`
 	FileSize     int64      `json:"file_size" gorm:"not null"`
 	MimeType     string     `json:"mime_type" gorm:"not null"`
@@ -28,6 +28,7 @@ type BookFile struct {
 	IsProcessed  bool       `json:"is_processed" gorm:"default:false"`
 	PageCount    *int       `json:"page_count,omitempty"`
 	Metadata     *string    `json:"metadata,omitempty" gorm:"type:text"`
+	SourceFileID *uuid.UUID `json:"source_file_id,omitempty" gorm:"type:text;index"`
 	CreatedAt    time.Time  `json:"created_at"`
 	UpdatedAt    time.Time  `json:"updated_at"`
 	DeletedAt    *time.Time `json:"-" gorm:"index"`
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BookTextIndex — состояние полнотекстового индекса по одному файлу книги
+type BookTextIndex struct {
+	FileID     uuid.UUID `json:"file_id" gorm:"type:text;primary_key"`
+	BookID     uuid.UUID `json:"book_id" gorm:"type:text;not null;index"`
+	Format     FileType  `json:"format" gorm:"type:text;not null"`
+	ChunkCount int       `json:"chunk_count" gorm:"not null;default:0"`
+	CharCount  int64     `json:"char_count" gorm:"not null;default:0"`
+	IndexedAt  time.Time `json:"indexed_at"`
+}
+
+func (BookTextIndex) TableName() string {
+	return "book_text_indexes"
+}
+
+// BookTextChunk — единица индексации: страница PDF или документ из spine EPUB
+type BookTextChunk struct {
+	Ordinal int
+	Page    *int
+	Href    string
+	CFI     string
+	Text    string
+}
+
+// BookSearchHitDTO — совпадение внутри книги. Snippet — экранированный HTML,
+// совпадения выделены <mark>.
+type BookSearchHitDTO struct {
+	FileID  uuid.UUID `json:"file_id"`
+	Format  FileType  `json:"format"`
+	Page    *int      `json:"page,omitempty"`
+	Href    string    `json:"href,omitempty"`
+	CFI     string    `json:"cfi,omitempty"`
+	Snippet string    `json:"snippet"`
+	Score   float64   `json:"score"`
+}
+
+// BookSearchResultDTO — результат поиска по тексту книги
+type BookSearchResultDTO struct {
+	Query   string             `json:"query"`
+	Indexed bool               `json:"indexed"`
+	Total   int64              `json:"total"`
+	Hits    []BookSearchHitDTO `json:"hits"`
+}
+
+// LibrarySearchHitDTO — книга в поиске по всей библиотеке.
+// Density — доля фрагментов книги, в которых есть совпадение.
+type LibrarySearchHitDTO struct {
+	BookID  uuid.UUID `json:"book_id"`
+	Title   string    `json:"title"`
+	Author  string    `json:"author"`
+	Hits    int       `json:"hits"`
+	Chunks  int       `json:"chunks"`
+	Density float64   `json:"density"`
+}
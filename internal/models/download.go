@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DownloadMode — как отдаётся файл по подписанной ссылке.
+type DownloadMode string
+
+const (
+	// DownloadModeView — просмотр в браузере или читалке (inline), квоту не расходует.
+	DownloadModeView DownloadMode = "view"
+	// DownloadModeDownload — скачивание файла (attachment), учитывается в квоте.
+	DownloadModeDownload DownloadMode = "download"
+)
+
+// BookDownload — учтённое скачивание файла книги. Запись одна на ссылку:
+// повторные и докачивающие (Range) запросы по той же ссылке квоту не расходуют.
+type BookDownload struct {
+	ID        uuid.UUID `json:"id" gorm:"type:text;primary_key"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:text;not null;index:idx_book_downloads_user_created"`
+	BookID    uuid.UUID `json:"book_id" gorm:"type:text;not null;index"`
+	FileID    uuid.UUID `json:"file_id" gorm:"type:text;not null"`
+	LinkID    uuid.UUID `json:"link_id" gorm:"type:text;not null;uniqueIndex"`
+	CreatedAt time.Time `json:"created_at" gorm:"index:idx_book_downloads_user_created"`
+}
+
+func (BookDownload) TableName() string {
+	return "book_downloads"
+}
+
+func (d *BookDownload) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+// CreateDownloadLinkDTO — запрос ссылки на файл; по умолчанию для просмотра.
+type CreateDownloadLinkDTO struct {
+	Mode DownloadMode `json:"mode" validate:"omitempty,oneof=view download"`
+}
+
+// DownloadLinkDTO — выданная подписанная ссылка на файл.
+type DownloadLinkDTO struct {
+	URL       string       `json:"url"`
+	Mode      DownloadMode `json:"mode"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+// DownloadQuotaDTO — остаток скачиваний пользователя за текущий период.
+// Limit < 0 — без ограничений.
+type DownloadQuotaDTO struct {
+	Limit       int       `json:"limit"`
+	Used        int64     `json:"used"`
+	Remaining   int64     `json:"remaining"`
+	PeriodStart time.Time `json:"period_start"`
+}
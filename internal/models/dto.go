@@ -215,11 +215,13 @@ type DashboardStatsDTO struct {
 type CreateCollectionDTO struct {
 	Name        string `json:"name" validate:"required"`
 	Description string `json:"description"`
+	IsPublic    bool   `json:"is_public"`
 }
 
 type UpdateCollectionDTO struct {
 	Name        *string `json:"name"`
 	Description *string `json:"description"`
+	IsPublic    *bool   `json:"is_public"`
 }
 
 type AddBookToCollectionDTO struct {
@@ -239,8 +241,11 @@ type UpdateReviewDTO struct {
 	Body   *string `json:"body"`
 }
 
+// CreateBookmarkDTO — закладка задаётся локатором или строкой location
+// ("12", "epubcfi(...)", "42.5%"); строка разбирается в локатор
 type CreateBookmarkDTO struct {
 	BookID   uuid.UUID `json:"book_id" validate:"required"`
-	Location string    `json:"location" validate:"required"`
+	Location string    `json:"location" validate:"required_without=Locator"`
+	Locator  *Locator  `json:"locator,omitempty"`
 	Label    string    `json:"label"`
 }
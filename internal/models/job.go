@@ -0,0 +1,83 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type JobStatus string
+
+const (
+	JobStatusPending   JobStatus = "pending"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusSucceeded JobStatus = "succeeded"
+	// JobStatusFailed — попытка не удалась, задача ждёт повтора в RunAt
+	JobStatusFailed JobStatus = "failed"
+	// JobStatusDead — попытки исчерпаны, нужна ручная проверка (retry из админки)
+	JobStatusDead      JobStatus = "dead"
+	JobStatusCancelled JobStatus = "cancelled"
+)
+
+// Job — фоновая задача в персистентной очереди.
+// Воркер берёт задачу в аренду (LeaseOwner/LeaseUntil); если процесс упал,
+// после истечения аренды задачу подхватит другой воркер.
+type Job struct {
+	ID          uuid.UUID  `json:"id"           gorm:"type:text;primary_key"`
+	Queue       string     `json:"queue"        gorm:"not null;index:idx_jobs_claim,priority:1"`
+	Kind        string     `json:"kind"         gorm:"not null"`
+	Payload     string     `json:"payload"      gorm:"type:text;not null"`
+	Status      JobStatus  `json:"status"       gorm:"type:text;not null;default:'pending';index:idx_jobs_claim,priority:2"`
+	Attempts    int        `json:"attempts"     gorm:"not null;default:0"`
+	MaxAttempts int        `json:"max_attempts" gorm:"not null;default:5"`
+	RunAt       time.Time  `json:"run_at"       gorm:"not null;index:idx_jobs_claim,priority:3"`
+	LeaseOwner  *string    `json:"lease_owner,omitempty"`
+	LeaseUntil  *time.Time `json:"lease_until,omitempty"`
+	LastError   *string    `json:"last_error,omitempty" gorm:"type:text"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty" gorm:"index"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+func (Job) TableName() string { return "jobs" }
+
+func (j *Job) BeforeCreate(tx *gorm.DB) error {
+	if j.ID == uuid.Nil {
+		j.ID = uuid.New()
+	}
+	if j.RunAt.IsZero() {
+		j.RunAt = time.Now()
+	}
+	return nil
+}
+
+// IsLastAttempt — после неудачи этой попытки задача уйдёт в dead
+func (j *Job) IsLastAttempt() bool {
+	return j.Attempts >= j.MaxAttempts
+}
+
+// JobFilter — фильтр списка задач для админки
+type JobFilter struct {
+	Queue  string
+	Kind   string
+	Status JobStatus
+	Limit  int
+	Offset int
+}
+
+// JobQueueStatsDTO — глубина и пропускная способность очереди
+type JobQueueStatsDTO struct {
+	Queue     string              `json:"queue"`
+	ByStatus  map[JobStatus]int64 `json:"by_status"`
+	Depth     int64               `json:"depth"`
+	Running   int64               `json:"running"`
+	Succeeded int64               `json:"succeeded_last_hour"`
+	Failed    int64               `json:"failed_last_hour"`
+	// OldestPendingSec — сколько ждёт самая старая готовая к запуску задача
+	OldestPendingSec int64 `json:"oldest_pending_sec"`
+	// Processed/FailedTotal — счётчики воркеров этого процесса с момента запуска
+	Processed   int64 `json:"processed"`
+	FailedTotal int64 `json:"failed_total"`
+}
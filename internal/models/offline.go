@@ -0,0 +1,216 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OfflineBundleVersion — версия формата офлайн-пакета
+const OfflineBundleVersion = 1
+
+// Файлы внутри офлайн-пакета (ZIP)
+const (
+	// OfflineManifestName — manifest.json: книга, оглавление и данные пользователя
+	OfflineManifestName = "manifest.json"
+	// OfflineLicenceName — licence.json: условия офлайн-чтения
+	OfflineLicenceName = "licence.json"
+	// OfflineSignatureName — licence.sig: подпись Ed25519 байтов licence.json в base64
+	OfflineSignatureName = "licence.sig"
+	// OfflineContentPrefix — файл книги лежит в пакете как book.<расширение>
+	OfflineContentPrefix = "book."
+)
+
+// OfflineLicence — разрешение читать книгу из пакета без связи с сервером до
+// ExpiresAt (конца выдачи). Хеши связывают лицензию с содержимым пакета:
+// подменить файл книги или manifest.json, не сломав подпись, нельзя.
+type OfflineLicence struct {
+	ID             uuid.UUID `json:"id"`
+	Version        int       `json:"version"`
+	KeyID          string    `json:"key_id"`
+	UserID         uuid.UUID `json:"user_id"`
+	BookID         uuid.UUID `json:"book_id"`
+	FileID         uuid.UUID `json:"file_id"`
+	AccessID       uuid.UUID `json:"access_id"`
+	IssuedAt       time.Time `json:"issued_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	Content        string    `json:"content"`
+	ContentSHA256  string    `json:"content_sha256"`
+	ManifestSHA256 string    `json:"manifest_sha256"`
+}
+
+// OfflineManifest — всё, что нужно приложению для чтения книги офлайн
+type OfflineManifest struct {
+	Version int            `json:"version"`
+	Book    OfflineBookDTO `json:"book"`
+	File    OfflineFileDTO `json:"file"`
+	// TOC — оглавление EPUB или outline PDF из метаданных файла; пусто, если
+	// файл ещё не обработан
+	TOC         json.RawMessage   `json:"toc,omitempty"`
+	Bookmarks   []Bookmark        `json:"bookmarks"`
+	Annotations []Annotation      `json:"annotations"`
+	Positions   []ReadingPosition `json:"positions"`
+	// SyncToken — с него приложение начинает синхронизацию после чтения офлайн
+	SyncToken   string    `json:"sync_token"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+type OfflineBookDTO struct {
+	ID     uuid.UUID `json:"id"`
+	Title  string    `json:"title"`
+	Author string    `json:"author"`
+}
+
+type OfflineFileDTO struct {
+	ID       uuid.UUID `json:"id"`
+	Name     string    `json:"name"`
+	Type     FileType  `json:"type"`
+	MimeType string    `json:"mime_type"`
+	// Watermarked — в пакете копия, помеченная данными пользователя
+	Watermarked bool `json:"watermarked"`
+}
+
+// OfflineKeyDTO — открытый ключ для проверки офлайн-лицензий
+type OfflineKeyDTO struct {
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+	// PublicKey — 32 байта ключа Ed25519 в base64
+	PublicKey string `json:"public_key"`
+}
+
+// SyncEntity — тип синхронизируемой записи
+type SyncEntity string
+
+const (
+	SyncBookmark   SyncEntity = "bookmark"
+	SyncAnnotation SyncEntity = "annotation"
+	SyncSession    SyncEntity = "session"
+	SyncPosition   SyncEntity = "position"
+)
+
+// SyncStatus — что сервер сделал с присланной записью
+type SyncStatus string
+
+const (
+	// SyncApplied — запись создана или изменена
+	SyncApplied SyncStatus = "applied"
+	// SyncDuplicate — запись уже была применена раньше (повтор запроса)
+	SyncDuplicate SyncStatus = "duplicate"
+	// SyncStale — на сервере более новая версия, присланная отброшена
+	SyncStale SyncStatus = "stale"
+	// SyncRejected — запись неверна или чужая; причина в Error
+	SyncRejected SyncStatus = "rejected"
+)
+
+// SyncTombstone — след удалённой записи, чтобы другие устройства узнали об
+// удалении при синхронизации
+type SyncTombstone struct {
+	ID         uuid.UUID  `json:"-" gorm:"type:text;primary_key"`
+	UserID     uuid.UUID  `json:"-" gorm:"type:text;not null;index:idx_sync_tombstones_user_deleted"`
+	EntityType SyncEntity `json:"type" gorm:"type:text;not null"`
+	EntityID   uuid.UUID  `json:"id" gorm:"type:text;not null"`
+	DeletedAt  time.Time  `json:"deleted_at" gorm:"not null;index:idx_sync_tombstones_user_deleted"`
+}
+
+func (SyncTombstone) TableName() string {
+	return "sync_tombstones"
+}
+
+func (t *SyncTombstone) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	if t.DeletedAt.IsZero() {
+		t.DeletedAt = time.Now()
+	}
+	return nil
+}
+
+// SyncRequestDTO — изменения, сделанные на устройстве офлайн. ID записей
+// создаёт приложение, поэтому повтор того же запроса ничего не дублирует.
+type SyncRequestDTO struct {
+	// Token — токен из прошлой синхронизации или офлайн-пакета; пусто — полная выгрузка
+	Token       string              `json:"token"`
+	DeviceID    string              `json:"device_id" validate:"required,max=128"`
+	DeviceName  string              `json:"device_name" validate:"max=128"`
+	Bookmarks   []SyncBookmarkDTO   `json:"bookmarks" validate:"max=1000,dive"`
+	Annotations []SyncAnnotationDTO `json:"annotations" validate:"max=1000,dive"`
+	Sessions    []SyncSessionDTO    `json:"sessions" validate:"max=1000,dive"`
+	Positions   []SyncPositionItem  `json:"positions" validate:"max=1000,dive"`
+	Deleted     []SyncDeletionDTO   `json:"deleted" validate:"max=1000,dive"`
+}
+
+type SyncBookmarkDTO struct {
+	ID        uuid.UUID `json:"id" validate:"required"`
+	BookID    uuid.UUID `json:"book_id" validate:"required"`
+	Location  string    `json:"location" validate:"required_without=Locator,max=1024"`
+	Locator   *Locator  `json:"locator,omitempty"`
+	Label     string    `json:"label" validate:"max=200"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type SyncAnnotationDTO struct {
+	ID              uuid.UUID            `json:"id" validate:"required"`
+	BookID          uuid.UUID            `json:"book_id" validate:"required"`
+	Type            AnnotationType       `json:"type" validate:"required,oneof=highlight note"`
+	Location        string               `json:"location" validate:"required_without=Locator,max=1024"`
+	Locator         *Locator             `json:"locator,omitempty"`
+	HighlightedText string               `json:"highlighted_text" validate:"max=10000"`
+	Note            string               `json:"note" validate:"max=10000"`
+	Color           AnnotationColor      `json:"color" validate:"omitempty,oneof=yellow green blue pink purple"`
+	Tags            []string             `json:"tags" validate:"max=20,dive,required,max=50"`
+	Visibility      AnnotationVisibility `json:"visibility" validate:"omitempty,oneof=private followers public"`
+	CreatedAt       time.Time            `json:"created_at"`
+	// UpdatedAt — время изменения на устройстве; уже существующая аннотация
+	// меняется, только если эта правка новее серверной
+	UpdatedAt time.Time `json:"updated_at" validate:"required"`
+}
+
+// SyncSessionDTO — завершённая офлайн-сессия чтения; Duration — активное время в секундах
+type SyncSessionDTO struct {
+	ID        uuid.UUID `json:"id" validate:"required"`
+	BookID    uuid.UUID `json:"book_id" validate:"required"`
+	StartedAt time.Time `json:"started_at" validate:"required"`
+	EndedAt   time.Time `json:"ended_at" validate:"required"`
+	Duration  int       `json:"duration" validate:"min=0"`
+	StartPage int       `json:"start_page" validate:"min=0"`
+	EndPage   int       `json:"end_page" validate:"min=0"`
+}
+
+// SyncPositionItem — позиция в книге на этом устройстве
+type SyncPositionItem struct {
+	BookID    uuid.UUID `json:"book_id" validate:"required"`
+	Locator   Locator   `json:"locator"`
+	UpdatedAt time.Time `json:"updated_at" validate:"required"`
+}
+
+// SyncDeletionDTO — удалённая на устройстве закладка или аннотация
+type SyncDeletionDTO struct {
+	Type SyncEntity `json:"type" validate:"required,oneof=bookmark annotation"`
+	ID   uuid.UUID  `json:"id" validate:"required"`
+}
+
+// SyncResultDTO — итог применения одной присланной записи
+type SyncResultDTO struct {
+	Type   SyncEntity `json:"type"`
+	ID     uuid.UUID  `json:"id"`
+	Status SyncStatus `json:"status"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// SyncResponseDTO — итог применения и изменения на сервере с прошлой
+// синхронизации. Записи применяются по ID: одна и та же запись может прийти
+// повторно (в том числе только что присланная), это не ошибка.
+type SyncResponseDTO struct {
+	Token string `json:"token"`
+	// FullSync — токен пустой или слишком старый: в ответе все записи
+	// пользователя, и локальные копии, которых здесь нет, нужно удалить
+	FullSync    bool              `json:"full_sync"`
+	Results     []SyncResultDTO   `json:"results"`
+	Bookmarks   []Bookmark        `json:"bookmarks"`
+	Annotations []Annotation      `json:"annotations"`
+	Positions   []ReadingPosition `json:"positions"`
+	Deleted     []SyncTombstone   `json:"deleted"`
+}
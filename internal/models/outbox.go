@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OutboxEvent — событие шины, записанное в той же транзакции, что и изменение данных.
+// Релей читает неопубликованные записи, публикует их в шину и проставляет PublishedAt.
+// ID события сохраняется при повторной публикации, по нему потребители убирают дубли.
+type OutboxEvent struct {
+	ID          uuid.UUID  `json:"id"           gorm:"type:text;primary_key"`
+	Type        string     `json:"type"         gorm:"not null"`
+	UserID      string     `json:"user_id"`
+	Payload     string     `json:"payload"      gorm:"type:text;not null"`
+	CreatedAt   time.Time  `json:"created_at"   gorm:"index"`
+	PublishedAt *time.Time `json:"published_at" gorm:"index"`
+	// ClaimedBy/ClaimedUntil — релей, который публикует событие; пока аренда
+	// не истекла, другие инстансы его не берут
+	ClaimedBy    *string    `json:"claimed_by,omitempty"`
+	ClaimedUntil *time.Time `json:"claimed_until,omitempty"`
+}
+
+func (OutboxEvent) TableName() string { return "event_outbox" }
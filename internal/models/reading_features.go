@@ -13,6 +13,8 @@ type Collection struct {
 	UserID      uuid.UUID  `json:"user_id" gorm:"type:text;not null;index"`
 	Name        string     `json:"name" gorm:"not null"`
 	Description string     `json:"description"`
+	// IsPublic — коллекция видна в профиле и попадает в ленту подписчиков
+	IsPublic    bool       `json:"is_public" gorm:"not null;default:false"`
 	CreatedAt   time.Time  `json:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at"`
 	Books       []*Book    `json:"books,omitempty" gorm:"many2many:collection_books;"`
@@ -64,9 +66,11 @@ type Bookmark struct {
 	ID        uuid.UUID `json:"id" gorm:"type:text;primary_key"`
 	UserID    uuid.UUID `json:"user_id" gorm:"type:text;not null;index"`
 	BookID    uuid.UUID `json:"book_id" gorm:"type:text;not null;index"`
-	Location  string    `json:"location" gorm:"not null"` // Может быть номером страницы, CFI для EPUB, и т.д.
+	Location  string    `json:"location" gorm:"not null"` // Текстовая форма Locator; у старых закладок — произвольная строка
+	Locator   Locator   `json:"locator" gorm:"embedded;embeddedPrefix:locator_"`
 	Label     string    `json:"label"`
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 	User      *User     `json:"-" gorm:"foreignKey:UserID"`
 	Book      *Book     `json:"book,omitempty" gorm:"foreignKey:BookID"`
 }
@@ -84,18 +88,23 @@ func (b *Bookmark) BeforeCreate(tx *gorm.DB) (err error) {
 	return
 }
 
-// Annotation представляет собой заметку или выделение текста в книге.
+// Annotation представляет собой выделение текста или заметку в книге.
 type Annotation struct {
-	ID          uuid.UUID `json:"id" gorm:"type:text;primary_key"`
-	UserID      uuid.UUID `json:"user_id" gorm:"type:text;not null;index"`
-	BookID      uuid.UUID `json:"book_id" gorm:"type:text;not null;index"`
-	Location    string    `json:"location" gorm:"not null"`    // Местоположение в книге
-	HighlightedText string `json:"highlighted_text"`          // Выделенный текст
-	Note        string    `json:"note"`                        // Текст заметки
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	User        *User     `json:"-" gorm:"foreignKey:UserID"`
-	Book        *Book     `json:"-" gorm:"foreignKey:BookID"`
+	ID              uuid.UUID            `json:"id" gorm:"type:text;primary_key"`
+	UserID          uuid.UUID            `json:"user_id" gorm:"type:text;not null;index"`
+	BookID          uuid.UUID            `json:"book_id" gorm:"type:text;not null;index"`
+	Type            AnnotationType       `json:"type" gorm:"type:text;not null;default:'highlight'"`
+	Location        string               `json:"location" gorm:"not null"` // Текстовая форма Locator
+	Locator         Locator              `json:"locator" gorm:"embedded;embeddedPrefix:locator_"`
+	HighlightedText string               `json:"highlighted_text"` // Выделенный текст
+	Note            string               `json:"note"`             // Текст заметки
+	Color           AnnotationColor      `json:"color,omitempty" gorm:"type:text"`
+	Tags            []string             `json:"tags" gorm:"serializer:json;type:text"`
+	Visibility      AnnotationVisibility `json:"visibility" gorm:"type:text;not null;default:'private';index"`
+	CreatedAt       time.Time            `json:"created_at"`
+	UpdatedAt       time.Time            `json:"updated_at"`
+	User            *User                `json:"-" gorm:"foreignKey:UserID"`
+	Book            *Book                `json:"book,omitempty" gorm:"foreignKey:BookID"`
 }
 
 // TableName возвращает имя таблицы для модели Annotation.
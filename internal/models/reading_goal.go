@@ -0,0 +1,194 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GoalMetric — что считается в цели: дочитанные книги, минуты или страницы чтения
+type GoalMetric string
+
+const (
+	GoalMetricBooks   GoalMetric = "books"
+	GoalMetricMinutes GoalMetric = "minutes"
+	GoalMetricPages   GoalMetric = "pages"
+)
+
+// GoalPeriod — период, за который набирается цель
+type GoalPeriod string
+
+const (
+	GoalPeriodDay  GoalPeriod = "day"
+	GoalPeriodWeek GoalPeriod = "week"
+	GoalPeriodYear GoalPeriod = "year"
+)
+
+// ReadingGoal — личная цель чтения, например «24 книги в год» или
+// «30 минут в день». Прогресс считается по сессиям чтения и дочитанным книгам.
+type ReadingGoal struct {
+	ID     uuid.UUID  `json:"id" gorm:"type:text;primary_key"`
+	UserID uuid.UUID  `json:"user_id" gorm:"type:text;not null;index"`
+	Metric GoalMetric `json:"metric" gorm:"type:text;not null"`
+	Period GoalPeriod `json:"period" gorm:"type:text;not null"`
+	Target int        `json:"target" gorm:"not null"`
+	// Timezone — часовой пояс IANA, в котором считаются дни и недели цели
+	Timezone string `json:"timezone" gorm:"type:text;not null;default:'UTC'"`
+	IsActive bool   `json:"is_active" gorm:"default:true;index"`
+	// ReachedPeriod и WarnedPeriod — начало периода (2006-01-02), за который
+	// уже отправлены события goal.reached и streak.at_risk
+	ReachedPeriod string    `json:"-" gorm:"type:text"`
+	WarnedPeriod  string    `json:"-" gorm:"type:text"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func (ReadingGoal) TableName() string {
+	return "reading_goals"
+}
+
+func (g *ReadingGoal) BeforeCreate(tx *gorm.DB) error {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	return nil
+}
+
+// PeriodBounds возвращает период цели, в который попадает t: день, неделя
+// с понедельника или календарный год
+func (g *ReadingGoal) PeriodBounds(t time.Time) (time.Time, time.Time) {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch g.Period {
+	case GoalPeriodWeek:
+		start := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7)
+	case GoalPeriodYear:
+		start := time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(1, 0, 0)
+	default:
+		return day, day.AddDate(0, 0, 1)
+	}
+}
+
+// ReadingChallenge — публичный челлендж клуба: участники соревнуются, кто
+// наберёт больше по метрике за время челленджа
+type ReadingChallenge struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:text;primary_key"`
+	Title       string     `json:"title" gorm:"not null"`
+	Description string     `json:"description"`
+	Metric      GoalMetric `json:"metric" gorm:"type:text;not null"`
+	Target      int        `json:"target" gorm:"not null"`
+	StartDate   time.Time  `json:"start_date" gorm:"not null;index"`
+	EndDate     time.Time  `json:"end_date" gorm:"not null;index"`
+	CreatedBy   uuid.UUID  `json:"created_by" gorm:"type:text;not null"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+func (ReadingChallenge) TableName() string {
+	return "reading_challenges"
+}
+
+func (c *ReadingChallenge) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsRunning — челлендж уже начался и ещё не закончился
+func (c *ReadingChallenge) IsRunning(t time.Time) bool {
+	return !t.Before(c.StartDate) && t.Before(c.EndDate)
+}
+
+// ChallengeParticipant — участие пользователя в челлендже
+type ChallengeParticipant struct {
+	ID          uuid.UUID `json:"id" gorm:"type:text;primary_key"`
+	ChallengeID uuid.UUID `json:"challenge_id" gorm:"type:text;not null;uniqueIndex:idx_challenge_participant"`
+	UserID      uuid.UUID `json:"user_id" gorm:"type:text;not null;uniqueIndex:idx_challenge_participant;index"`
+	// CompletedAt — когда участник впервые набрал цель челленджа
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	JoinedAt    time.Time  `json:"joined_at"`
+	User        *User      `json:"-" gorm:"foreignKey:UserID"`
+}
+
+func (ChallengeParticipant) TableName() string {
+	return "challenge_participants"
+}
+
+func (p *ChallengeParticipant) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	if p.JoinedAt.IsZero() {
+		p.JoinedAt = time.Now()
+	}
+	return nil
+}
+
+// ReadingTotals — время и страницы чтения за период
+type ReadingTotals struct {
+	Seconds int64 `json:"seconds"`
+	Pages   int64 `json:"pages"`
+}
+
+type CreateGoalDTO struct {
+	Metric GoalMetric `json:"metric" validate:"required,oneof=books minutes pages"`
+	Period GoalPeriod `json:"period" validate:"required,oneof=day week year"`
+	Target int        `json:"target" validate:"required,min=1,max=100000"`
+	// Timezone — часовой пояс IANA (Europe/Moscow), по умолчанию UTC
+	Timezone string `json:"timezone" validate:"omitempty,timezone"`
+}
+
+type UpdateGoalDTO struct {
+	Target   *int    `json:"target" validate:"omitempty,min=1,max=100000"`
+	Timezone *string `json:"timezone" validate:"omitempty,timezone"`
+	IsActive *bool   `json:"is_active"`
+}
+
+// GoalProgressDTO — цель и её прогресс в текущем периоде
+type GoalProgressDTO struct {
+	Goal        ReadingGoal `json:"goal"`
+	PeriodStart time.Time   `json:"period_start"`
+	PeriodEnd   time.Time   `json:"period_end"`
+	Value       int64       `json:"value"`
+	Percent     float64     `json:"percent"`
+	Reached     bool        `json:"reached"`
+	// Streak — сколько периодов подряд цель выполнена (включая текущий, если
+	// он уже выполнен)
+	Streak int `json:"streak"`
+}
+
+type CreateChallengeDTO struct {
+	Title       string     `json:"title" validate:"required,max=200"`
+	Description string     `json:"description" validate:"max=5000"`
+	Metric      GoalMetric `json:"metric" validate:"required,oneof=books minutes pages"`
+	Target      int        `json:"target" validate:"required,min=1,max=1000000"`
+	StartDate   time.Time  `json:"start_date" validate:"required"`
+	EndDate     time.Time  `json:"end_date" validate:"required,gtfield=StartDate"`
+}
+
+type UpdateChallengeDTO struct {
+	Title       *string    `json:"title" validate:"omitempty,max=200"`
+	Description *string    `json:"description" validate:"omitempty,max=5000"`
+	Target      *int       `json:"target" validate:"omitempty,min=1,max=1000000"`
+	EndDate     *time.Time `json:"end_date"`
+}
+
+// ChallengeDTO — челлендж с числом участников и участием текущего пользователя
+type ChallengeDTO struct {
+	ReadingChallenge
+	Participants int64 `json:"participants"`
+	Joined       bool  `json:"joined"`
+}
+
+// LeaderboardEntryDTO — строка таблицы лидеров челленджа
+type LeaderboardEntryDTO struct {
+	Rank        int        `json:"rank"`
+	UserID      uuid.UUID  `json:"user_id"`
+	Name        string     `json:"name"`
+	Value       int64      `json:"value"`
+	Percent     float64    `json:"percent"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// LocatorType — способ указать позицию в книге
+type LocatorType string
+
+const (
+	// LocatorPage — номер страницы (PDF, файлы с постоянной вёрсткой)
+	LocatorPage LocatorType = "page"
+	// LocatorCFI — EPUB CFI, точная позиция в перетекаемом тексте
+	LocatorCFI LocatorType = "cfi"
+	// LocatorPercent — доля прочитанного в процентах, когда точнее указать нельзя
+	LocatorPercent LocatorType = "percent"
+)
+
+// maxCFILength — ограничение длины CFI; настоящие CFI короче сотни символов
+const maxCFILength = 1024
+
+var (
+	ErrInvalidLocator = errors.New("invalid locator")
+
+	cfiPattern = regexp.MustCompile(`^epubcfi\(/\d+[^()]*(\([^()]*\)[^()]*)*\)$`)
+)
+
+// Locator — позиция в книге. Для CFI можно передать и Percent: сервер не
+// разбирает вёрстку EPUB, и прогресс по CFI берётся у клиента.
+type Locator struct {
+	Type    LocatorType `json:"type" gorm:"type:text"`
+	FileID  *uuid.UUID  `json:"file_id,omitempty" gorm:"type:text"`
+	Page    *int        `json:"page,omitempty"`
+	CFI     *string     `json:"cfi,omitempty" gorm:"type:text"`
+	Percent *float64    `json:"percent,omitempty"`
+}
+
+// Validate проверяет, что заполнено поле, соответствующее типу
+func (l Locator) Validate() error {
+	if l.Percent != nil && (*l.Percent < 0 || *l.Percent > 100) {
+		return fmt.Errorf("%w: percent must be between 0 and 100", ErrInvalidLocator)
+	}
+	switch l.Type {
+	case LocatorPage:
+		if l.Page == nil || *l.Page < 0 {
+			return fmt.Errorf("%w: page locator needs a non-negative page", ErrInvalidLocator)
+		}
+	case LocatorCFI:
+		if l.CFI == nil || len(*l.CFI) > maxCFILength || !cfiPattern.MatchString(*l.CFI) {
+			return fmt.Errorf("%w: cfi must look like epubcfi(/6/4!/4/2:0)", ErrInvalidLocator)
+		}
+	case LocatorPercent:
+		if l.Percent == nil {
+			return fmt.Errorf("%w: percent locator needs a percent", ErrInvalidLocator)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidLocator, l.Type)
+	}
+	return nil
+}
+
+// String — текстовая форма для Bookmark.Location: "12", "epubcfi(...)" или "42.5%"
+func (l Locator) String() string {
+	switch l.Type {
+	case LocatorPage:
+		if l.Page != nil {
+			return strconv.Itoa(*l.Page)
+		}
+	case LocatorCFI:
+		if l.CFI != nil {
+			return *l.CFI
+		}
+	case LocatorPercent:
+		if l.Percent != nil {
+			return strconv.FormatFloat(*l.Percent, 'f', -1, 64) + "%"
+		}
+	}
+	return ""
+}
+
+// ParseLocator разбирает текстовую форму из String. Строки другого вида
+// (старые закладки) дают ErrInvalidLocator.
+func ParseLocator(s string) (Locator, error) {
+	s = strings.TrimSpace(s)
+	var l Locator
+	switch {
+	case strings.HasPrefix(s, "epubcfi("):
+		l = Locator{Type: LocatorCFI, CFI: &s}
+	case strings.HasSuffix(s, "%"):
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			return Locator{}, fmt.Errorf("%w: %q", ErrInvalidLocator, s)
+		}
+		l = Locator{Type: LocatorPercent, Percent: &p}
+	default:
+		page, err := strconv.Atoi(s)
+		if err != nil {
+			return Locator{}, fmt.Errorf("%w: %q", ErrInvalidLocator, s)
+		}
+		l = Locator{Type: LocatorPage, Page: &page}
+	}
+	return l, l.Validate()
+}
+
+// ResolveLocation приводит место в книге к паре «локатор + текстовая форма»:
+// заданный локатор проверяется и даёт location, а location вида "12",
+// "epubcfi(...)" или "42%" разбирается в локатор. Произвольная строка
+// остаётся как есть, без локатора.
+func ResolveLocation(loc *Locator, location string) (Locator, string, error) {
+	if loc != nil && loc.Type != "" {
+		if err := loc.Validate(); err != nil {
+			return Locator{}, "", err
+		}
+		return *loc, loc.String(), nil
+	}
+	if strings.TrimSpace(location) == "" {
+		return Locator{}, "", fmt.Errorf("%w: location is empty", ErrInvalidLocator)
+	}
+	parsed, err := ParseLocator(location)
+	if err != nil {
+		return Locator{}, location, nil
+	}
+	return parsed, location, nil
+}
+
+// ReadingPosition — последняя позиция чтения книги на одном устройстве
+// пользователя. Между устройствами побеждает позиция с более поздним
+// ClientUpdatedAt (last-writer-wins по времени изменения на устройстве).
+type ReadingPosition struct {
+	ID         uuid.UUID `json:"id" gorm:"type:text;primary_key"`
+	UserID     uuid.UUID `json:"user_id" gorm:"type:text;not null;uniqueIndex:idx_reading_positions_device"`
+	BookID     uuid.UUID `json:"book_id" gorm:"type:text;not null;uniqueIndex:idx_reading_positions_device"`
+	DeviceID   string    `json:"device_id" gorm:"type:text;not null;uniqueIndex:idx_reading_positions_device"`
+	DeviceName string    `json:"device_name,omitempty" gorm:"type:text"`
+	Locator    Locator   `json:"locator" gorm:"embedded;embeddedPrefix:locator_"`
+	// Progress — прочитанная доля в процентах, пересчитанная сервером
+	Progress float32 `json:"progress" gorm:"default:0"`
+	// ClientUpdatedAt — когда позиция изменилась на устройстве (не позже времени сервера)
+	ClientUpdatedAt time.Time `json:"client_updated_at" gorm:"not null"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+func (ReadingPosition) TableName() string {
+	return "reading_positions"
+}
+
+func (p *ReadingPosition) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
+// NewerThan — порядок last-writer-wins; при равном времени решает ID
+// устройства, чтобы все узлы выбирали одну и ту же позицию
+func (p *ReadingPosition) NewerThan(other *ReadingPosition) bool {
+	if !p.ClientUpdatedAt.Equal(other.ClientUpdatedAt) {
+		return p.ClientUpdatedAt.After(other.ClientUpdatedAt)
+	}
+	return p.DeviceID > other.DeviceID
+}
+
+// SyncPositionDTO — позиция, присланная устройством
+type SyncPositionDTO struct {
+	DeviceID   string  `json:"device_id" validate:"required,max=128"`
+	DeviceName string  `json:"device_name" validate:"max=128"`
+	Locator    Locator `json:"locator"`
+	// UpdatedAt — время изменения позиции на устройстве; пусто — время сервера
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// PositionSyncDTO — состояние синхронизации для одного устройства
+type PositionSyncDTO struct {
+	// Position — сохранённая позиция этого устройства
+	Position *ReadingPosition `json:"position,omitempty"`
+	// Applied — false, если присланная позиция старее уже сохранённой
+	// (запоздавший запрос) и была отброшена; в ответе на чтение всегда false
+	Applied bool `json:"applied"`
+	// Latest — самая новая позиция среди всех устройств
+	Latest *ReadingPosition `json:"latest,omitempty"`
+	// JumpTo — позиция с другого устройства новее этой: клиенту стоит
+	// предложить «продолжить с места на другом устройстве»
+	JumpTo *ReadingPosition `json:"jump_to,omitempty"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -8,20 +9,27 @@ import (
 )
 
 type ReadingSession struct {
-	ID          uuid.UUID  `json:"id" gorm:"type:text;primary_key"`
-	UserID      uuid.UUID  `json:"user_id" gorm:"type:text;not null;index"`
-	BookID      uuid.UUID  `json:"book_id" gorm:"type:text;not null;index"`
-	AccessID    uuid.UUID  `json:"access_id" gorm:"type:text;not null;index"`
-	StartedAt   time.Time  `json:"started_at" gorm:"not null"`
-	EndedAt     *time.Time `json:"ended_at,omitempty"`
-	StartPage   int        `json:"start_page" gorm:"default:0"`
-	EndPage     int        `json:"end_page" gorm:"default:0"`
-	Duration    int        `json:"duration" gorm:"default:0"`
-	UserAgent   *string    `json:"user_agent,omitempty"`
-	IPAddress   *string    `json:"-"`
-	DeviceType  *string    `json:"device_type,omitempty"`
-	CreatedAt   time.Time  `json:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at"`
+	ID        uuid.UUID  `json:"id" gorm:"type:text;primary_key"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:text;not null;index"`
+	BookID    uuid.UUID  `json:"book_id" gorm:"type:text;not null;index"`
+	AccessID  uuid.UUID  `json:"access_id" gorm:"type:text;not null;index"`
+	StartedAt time.Time  `json:"started_at" gorm:"not null"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	StartPage int        `json:"start_page" gorm:"default:0"`
+	EndPage   int        `json:"end_page" gorm:"default:0"`
+	// Duration — активное время чтения в секундах: сумма промежутков между
+	// пульсами клиента, не превышающих таймаут простоя
+	Duration int `json:"duration" gorm:"default:0"`
+	// LastActiveAt — последний пульс, в котором клиент сообщил о чтении
+	LastActiveAt *time.Time `json:"last_active_at,omitempty" gorm:"index"`
+	// IdleSince — пульс без активности, закрывший активный промежуток;
+	// следующий промежуток отсчитывается от него
+	IdleSince  *time.Time `json:"-"`
+	UserAgent  *string    `json:"user_agent,omitempty"`
+	IPAddress  *string    `json:"-"`
+	DeviceType *string    `json:"device_type,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
 
 	User   *User       `json:"user,omitempty" gorm:"foreignKey:UserID"`
 	Book   *Book       `json:"book,omitempty" gorm:"foreignKey:BookID"`
@@ -39,11 +47,55 @@ func (s *ReadingSession) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
-func (s *ReadingSession) End(endPage int) {
-	now := time.Now()
-	s.EndedAt = &now
+// ActiveSince — время последней активности: последний пульс или начало сессии
+func (s *ReadingSession) ActiveSince() time.Time {
+	if s.LastActiveAt != nil {
+		return *s.LastActiveAt
+	}
+	return s.StartedAt
+}
+
+// intervalStart — начало текущего промежутка: последний пульс с чтением или
+// более поздний пульс без активности
+func (s *ReadingSession) intervalStart() time.Time {
+	start := s.ActiveSince()
+	if s.IdleSince != nil && s.IdleSince.After(start) {
+		return *s.IdleSince
+	}
+	return start
+}
+
+// Touch засчитывает промежуток от начала текущего интервала до at, если
+// клиент читал (active) и промежуток не длиннее idleTimeout. Более длинный
+// промежуток — простой: он не засчитывается, а отсчёт начинается заново.
+// Пульс без активности закрывает промежуток, ничего не засчитывая: следующий
+// отсчитывается от него. Сессию такой пульс не продлевает.
+func (s *ReadingSession) Touch(at time.Time, active bool, idleTimeout time.Duration) {
+	if !active {
+		if at.After(s.intervalStart()) {
+			s.IdleSince = &at
+		}
+		return
+	}
+	if gap := at.Sub(s.intervalStart()); gap > 0 && gap <= idleTimeout {
+		s.Duration += int(gap.Seconds())
+	}
+	if at.After(s.ActiveSince()) {
+		s.LastActiveAt = &at
+		s.IdleSince = nil
+	}
+}
+
+// End завершает сессию в момент at по тем же правилам, что и Touch. Если
+// клиент молчал дольше idleTimeout, сессия считается закончившейся при
+// последней активности.
+func (s *ReadingSession) End(at time.Time, endPage int, idleTimeout time.Duration) {
+	if at.Sub(s.ActiveSince()) > idleTimeout {
+		at = s.ActiveSince()
+	}
+	s.Touch(at, true, idleTimeout)
+	s.EndedAt = &at
 	s.EndPage = endPage
-	s.Duration = int(now.Sub(s.StartedAt).Seconds())
 }
 
 func (s *ReadingSession) IsActive() bool {
@@ -56,3 +108,49 @@ func (s *ReadingSession) PagesRead() int {
 	}
 	return 0
 }
+
+// Типы устройств, с которых читают
+const (
+	DeviceTypeDesktop = "desktop"
+	DeviceTypeMobile  = "mobile"
+	DeviceTypeTablet  = "tablet"
+	DeviceTypeEReader = "ereader"
+)
+
+// SessionClient — откуда начата сессия чтения
+type SessionClient struct {
+	UserAgent  string
+	IPAddress  string
+	DeviceType string
+}
+
+// DetectDeviceType определяет тип устройства по User-Agent; пустая строка —
+// определить не удалось
+func DetectDeviceType(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case ua == "":
+		return ""
+	case strings.Contains(ua, "kindle") || strings.Contains(ua, "kobo") || strings.Contains(ua, "pocketbook"):
+		return DeviceTypeEReader
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") ||
+		(strings.Contains(ua, "android") && !strings.Contains(ua, "mobile")):
+		return DeviceTypeTablet
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || strings.Contains(ua, "android"):
+		return DeviceTypeMobile
+	case strings.Contains(ua, "windows") || strings.Contains(ua, "macintosh") || strings.Contains(ua, "linux") ||
+		strings.Contains(ua, "cros"):
+		return DeviceTypeDesktop
+	default:
+		return ""
+	}
+}
+
+// SessionHeartbeatDTO — пульс клиента во время чтения
+type SessionHeartbeatDTO struct {
+	// Page — текущая страница; становится конечной страницей сессии
+	Page *int `json:"page" validate:"omitempty,min=0"`
+	// Idle — клиент не видит активности читателя (вкладка скрыта, нет ввода);
+	// такой пульс время чтения не засчитывает
+	Idle bool `json:"idle"`
+}
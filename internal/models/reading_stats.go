@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserReadingStatsDTO — личная статистика чтения пользователя. Дни и недели
+// считаются в часовом поясе запроса, неделя начинается с понедельника.
+type UserReadingStatsDTO struct {
+	Timezone      string `json:"timezone"`
+	TotalReadTime int64  `json:"total_read_time_seconds"`
+	TotalSessions int    `json:"total_sessions"`
+	PagesRead     int    `json:"pages_read"`
+	// PagesPerHour — средняя скорость по сессиям, в которых менялась страница
+	PagesPerHour    float64 `json:"pages_per_hour"`
+	BooksFinished   int     `json:"books_finished"`
+	BooksInProgress int     `json:"books_in_progress"`
+	// CurrentStreak — дни чтения подряд по сегодня; серия не прерывается,
+	// пока не закончился день без чтения
+	CurrentStreak int `json:"current_streak_days"`
+	LongestStreak int `json:"longest_streak_days"`
+	// AvgDailyReadTime — среднее время чтения в день за последние 30 дней
+	AvgDailyReadTime   int64              `json:"avg_daily_read_time_seconds"`
+	Daily              []ReadingPeriodDTO `json:"daily"`
+	Weekly             []ReadingPeriodDTO `json:"weekly"`
+	FavoriteCategories []CategoryStatDTO  `json:"favorite_categories"`
+	Books              []BookEstimateDTO  `json:"books"`
+	GeneratedAt        time.Time          `json:"generated_at"`
+}
+
+// ReadingPeriodDTO — чтение за день или неделю (Start — первый день периода)
+type ReadingPeriodDTO struct {
+	Start    string `json:"start"`
+	ReadTime int64  `json:"read_time_seconds"`
+	Pages    int    `json:"pages"`
+	Sessions int    `json:"sessions"`
+}
+
+// CategoryStatDTO — время чтения книг категории
+type CategoryStatDTO struct {
+	CategoryID uuid.UUID `json:"category_id"`
+	Name       string    `json:"name"`
+	ReadTime   int64     `json:"read_time_seconds"`
+	Books      int       `json:"books"`
+}
+
+// BookEstimateDTO — прогноз дочитывания книги из текущих выдач
+type BookEstimateDTO struct {
+	BookID         uuid.UUID `json:"book_id"`
+	Title          string    `json:"title"`
+	Progress       float32   `json:"progress"`
+	CurrentPage    int       `json:"current_page"`
+	PageCount      *int      `json:"page_count,omitempty"`
+	ReadTime       int64     `json:"read_time_seconds"`
+	PagesPerHour   float64   `json:"pages_per_hour,omitempty"`
+	RemainingPages *int      `json:"remaining_pages,omitempty"`
+	// RemainingTime — сколько ещё читать при текущей скорости
+	RemainingTime *int64 `json:"remaining_time_seconds,omitempty"`
+	// EstimatedFinishAt — когда книга будет дочитана при нынешнем темпе
+	// (среднее время чтения в день); пусто — темп ещё неизвестен
+	EstimatedFinishAt *time.Time `json:"estimated_finish_at,omitempty"`
+}
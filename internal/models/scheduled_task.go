@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ScheduledRunStatus string
+
+const (
+	ScheduledRunRunning   ScheduledRunStatus = "running"
+	ScheduledRunSucceeded ScheduledRunStatus = "succeeded"
+	ScheduledRunFailed    ScheduledRunStatus = "failed"
+)
+
+// ScheduledTask — состояние периодической задачи планировщика.
+// Строка общая для всех инстансов: задачу запускает тот, кто первым взял аренду
+// после наступления NextRunAt.
+type ScheduledTask struct {
+	Name      string    `json:"name"      gorm:"type:text;primary_key"`
+	Schedule  string    `json:"schedule"  gorm:"not null"`
+	NextRunAt time.Time `json:"next_run_at" gorm:"not null;index"`
+	// RunRequested — запуск вне расписания по запросу администратора
+	RunRequested bool       `json:"run_requested" gorm:"not null;default:false"`
+	LeaseOwner   *string    `json:"lease_owner,omitempty"`
+	LeaseUntil   *time.Time `json:"lease_until,omitempty"`
+
+	LastRunAt      *time.Time         `json:"last_run_at,omitempty"`
+	LastStatus     ScheduledRunStatus `json:"last_status,omitempty" gorm:"type:text"`
+	LastError      *string            `json:"last_error,omitempty" gorm:"type:text"`
+	LastDurationMs int64              `json:"last_duration_ms"`
+	RunCount       int64              `json:"run_count" gorm:"not null;default:0"`
+	FailCount      int64              `json:"fail_count" gorm:"not null;default:0"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (ScheduledTask) TableName() string { return "scheduled_tasks" }
+
+// ScheduledTaskRun — запись истории запусков
+type ScheduledTaskRun struct {
+	ID         uuid.UUID          `json:"id" gorm:"type:text;primary_key"`
+	TaskName   string             `json:"task_name" gorm:"not null;index:idx_scheduled_runs_task,priority:1"`
+	Owner      string             `json:"owner" gorm:"not null"`
+	Manual     bool               `json:"manual" gorm:"not null;default:false"`
+	Status     ScheduledRunStatus `json:"status" gorm:"type:text;not null"`
+	Error      *string            `json:"error,omitempty" gorm:"type:text"`
+	StartedAt  time.Time          `json:"started_at" gorm:"not null;index:idx_scheduled_runs_task,priority:2"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty"`
+	DurationMs int64              `json:"duration_ms"`
+}
+
+func (ScheduledTaskRun) TableName() string { return "scheduled_task_runs" }
+
+func (r *ScheduledTaskRun) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// ScheduledTaskDTO — задача планировщика для админки
+type ScheduledTaskDTO struct {
+	ScheduledTask
+	// Registered — задача зарегистрирована в этом процессе (неизвестные задачи
+	// остаются в таблице после удаления из кода)
+	Registered bool `json:"registered"`
+	Running    bool `json:"running"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type UploadStatus string
+
+const (
+	UploadStatusActive    UploadStatus = "active"
+	UploadStatusCompleted UploadStatus = "completed"
+	UploadStatusFailed    UploadStatus = "failed"
+)
+
+// Upload — возобновляемая загрузка файла книги по протоколу tus.
+// Принятые байты лежат во временном хранилище до последнего фрагмента,
+// после чего файл проходит обычный путь загрузки и становится BookFile.
+type Upload struct {
+	ID         uuid.UUID    `json:"id" gorm:"type:text;primary_key"`
+	UserID     uuid.UUID    `json:"user_id" gorm:"type:text;not null;index"`
+	BookID     uuid.UUID    `json:"book_id" gorm:"type:text;not null"`
+	FileName   string       `json:"file_name" gorm:"not null"`
+	Size       int64        `json:"size" gorm:"not null"`
+	Offset     int64        `json:"offset" gorm:"column:upload_offset;not null;default:0"`
+	Metadata   string       `json:"-" gorm:"type:text"`
+	Status     UploadStatus `json:"status" gorm:"type:text;not null;default:'active';index"`
+	BookFileID *uuid.UUID   `json:"book_file_id,omitempty" gorm:"type:text"`
+	Error      *string      `json:"error,omitempty" gorm:"type:text"`
+	ExpiresAt  time.Time    `json:"expires_at" gorm:"not null;index"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+}
+
+func (Upload) TableName() string {
+	return "uploads"
+}
+
+func (u *Upload) BeforeCreate(tx *gorm.DB) error {
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
+	return nil
+}
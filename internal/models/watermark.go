@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// FileWatermark — выданная пользователю помеченная копия файла. Запись
+// хранится бессрочно: по ID из утёкшего файла находится аккаунт. Сама копия
+// кэшируется в хранилище до CacheExpiresAt, затем удаляется.
+type FileWatermark struct {
+	ID             uuid.UUID  `json:"id" gorm:"type:text;primary_key"`
+	UserID         uuid.UUID  `json:"user_id" gorm:"type:text;not null;index:idx_file_watermarks_user_file"`
+	FileID         uuid.UUID  `json:"file_id" gorm:"type:text;not null;index:idx_file_watermarks_user_file"`
+	EmailHash      string     `json:"email_hash" gorm:"type:text;not null"`
+	CachePath      *string    `json:"-" gorm:"type:text"`
+	CacheExpiresAt *time.Time `json:"-" gorm:"index"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+func (FileWatermark) TableName() string {
+	return "file_watermarks"
+}
+
+func (w *FileWatermark) BeforeCreate(tx *gorm.DB) error {
+	if w.ID == uuid.Nil {
+		w.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// WebhookEventWildcard — подписка на все типы событий.
+const WebhookEventWildcard = "*"
+
+// Webhook — подписка внешней системы (LMS, Slack-бот и т.п.) на события библиотеки.
+// Принадлежит пользователю; если создана через внешнее API — ещё и конкретному API-ключу.
+type Webhook struct {
+	ID          uuid.UUID  `json:"id"          gorm:"type:text;primary_key"`
+	UserID      uuid.UUID  `json:"user_id"     gorm:"type:text;not null;index"`
+	APIKeyID    *uuid.UUID `json:"api_key_id,omitempty" gorm:"type:text;index"`
+	URL         string     `json:"url"         gorm:"not null"`
+	Events      []string   `json:"events"      gorm:"serializer:json;type:text;not null"`
+	Description string     `json:"description"`
+	// AllUsers — доставлять события всех пользователей, а не только владельца.
+	// Включить может только администратор.
+	AllUsers bool `json:"all_users" gorm:"not null;default:false"`
+	// Secret — ключ HMAC-SHA256 для подписи доставок, показывается только при создании/ротации
+	Secret              string     `json:"-"                    gorm:"not null"`
+	IsActive            bool       `json:"is_active"            gorm:"not null;default:true"`
+	ConsecutiveFailures int        `json:"consecutive_failures" gorm:"not null;default:0"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty"`
+	LastDeliveryAt      *time.Time `json:"last_delivery_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+
+	User   *User   `json:"-" gorm:"foreignKey:UserID"`
+	APIKey *APIKey `json:"-" gorm:"foreignKey:APIKeyID"`
+}
+
+func (Webhook) TableName() string { return "webhooks" }
+
+func (w *Webhook) BeforeCreate(tx *gorm.DB) error {
+	if w.ID == uuid.Nil {
+		w.ID = uuid.New()
+	}
+	return nil
+}
+
+// Matches проверяет, подписан ли вебхук на событие данного типа.
+func (w *Webhook) Matches(eventType string) bool {
+	for _, e := range w.Events {
+		if e == WebhookEventWildcard || e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	DeliveryStatusPending   WebhookDeliveryStatus = "pending"
+	DeliveryStatusSucceeded WebhookDeliveryStatus = "succeeded"
+	DeliveryStatusFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery — журнал одной доставки события (со всеми повторными попытками).
+type WebhookDelivery struct {
+	ID             uuid.UUID             `json:"id"         gorm:"type:text;primary_key"`
+	WebhookID      uuid.UUID             `json:"webhook_id" gorm:"type:text;not null;index"`
+	EventID        uuid.UUID             `json:"event_id"   gorm:"type:text;not null;index"`
+	EventType      string                `json:"event_type" gorm:"not null"`
+	Payload        string                `json:"payload"    gorm:"type:text;not null"`
+	Status         WebhookDeliveryStatus `json:"status"     gorm:"type:text;not null;default:'pending'"`
+	Attempts       int                   `json:"attempts"   gorm:"not null;default:0"`
+	ResponseStatus *int                  `json:"response_status,omitempty"`
+	ResponseBody   *string               `json:"response_body,omitempty" gorm:"type:text"`
+	Error          *string               `json:"error,omitempty"`
+	DurationMs     int64                 `json:"duration_ms"`
+	// RedeliveryOf — исходная доставка, если это ручной повтор
+	RedeliveryOf *uuid.UUID `json:"redelivery_of,omitempty" gorm:"type:text"`
+	CreatedAt    time.Time  `json:"created_at"`
+	DeliveredAt  *time.Time `json:"delivered_at,omitempty"`
+
+	Webhook *Webhook `json:"-" gorm:"foreignKey:WebhookID"`
+}
+
+func (WebhookDelivery) TableName() string { return "webhook_deliveries" }
+
+func (d *WebhookDelivery) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+// CreateWebhookDTO — входной DTO для создания вебхука.
+type CreateWebhookDTO struct {
+	URL         string   `json:"url"         validate:"required,url,max=2048"`
+	Events      []string `json:"events"      validate:"required,min=1,dive,required"`
+	Description string   `json:"description" validate:"max=255"`
+	AllUsers    bool     `json:"all_users"`
+}
+
+// UpdateWebhookDTO — частичное обновление вебхука.
+// is_active=true повторно включает автоматически отключённый вебхук.
+type UpdateWebhookDTO struct {
+	URL         *string  `json:"url,omitempty"         validate:"omitempty,url,max=2048"`
+	Events      []string `json:"events,omitempty"      validate:"omitempty,min=1,dive,required"`
+	Description *string  `json:"description,omitempty" validate:"omitempty,max=255"`
+	IsActive    *bool    `json:"is_active,omitempty"`
+	AllUsers    *bool    `json:"all_users,omitempty"`
+}
+
+// WebhookSecretDTO — вебхук вместе с секретом (показывается один раз).
+type WebhookSecretDTO struct {
+	Webhook
+	Secret string `json:"secret"`
+}
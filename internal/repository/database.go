@@ -2,6 +2,7 @@ package repository
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/oneErrortime/afst/internal/config"
 	"github.com/oneErrortime/afst/internal/models"
@@ -48,7 +49,7 @@ func NewDatabase(cfg *config.DatabaseConfig) (*gorm.DB, error) {
 
 // Migrate выполняет автоматическую миграцию моделей
 func Migrate(db *gorm.DB) error {
-	return db.AutoMigrate(
+	err := db.AutoMigrate(
 		&models.UserGroup{},
 		&models.User{},
 		&models.Category{},
@@ -64,9 +65,74 @@ func Migrate(db *gorm.DB) error {
 		&models.Collection{},
 		&models.Review{},
 		&models.Bookmark{},
+		&models.Annotation{},
+		&models.ReadingGoal{},
+		&models.ReadingChallenge{},
+		&models.ChallengeParticipant{},
+		&models.SyncTombstone{},
+		&models.Activity{},
+		&models.ActivityPreference{},
 		&models.APIKey{},
 		&models.APIUsageLog{},
+		&models.Webhook{},
+		&models.WebhookDelivery{},
+		&models.OutboxEvent{},
+		&models.Job{},
+		&models.ScheduledTask{},
+		&models.ScheduledTaskRun{},
+		&models.BookCover{},
+		&models.BookTextIndex{},
+		&models.Upload{},
+		&models.Blob{},
+		&models.BookDownload{},
+		&models.FileWatermark{},
+		&models.ReadingPosition{},
 	)
+	if err != nil {
+		return err
+	}
+	if _, err := ReconcileBlobs(db); err != nil {
+		return fmt.Errorf("не удалось пересчитать ссылки на файлы: %w", err)
+	}
+	return MigrateFullText(db)
+}
+
+// ReconcileBlobs заводит записи blobs для файлов книг, у которых их нет
+// (загруженных до учёта ссылок), и пересчитывает RefCount по book_files.
+// Файлы, которые прямо сейчас удаляет сборщик мусора, не трогает.
+// Возвращает число исправленных записей.
+func ReconcileBlobs(db *gorm.DB) (int64, error) {
+	var fixed int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Exec(`INSERT INTO blobs (file_path, hash, size, ref_count, created_at, updated_at)
+			SELECT file_path, MIN(hash), MAX(file_size), 0, ?, ? FROM book_files
+			WHERE file_path NOT IN (SELECT file_path FROM blobs)
+			GROUP BY file_path`, now, now).Error; err != nil {
+			return err
+		}
+		const refs = `(SELECT COUNT(*) FROM book_files WHERE book_files.file_path = blobs.file_path)`
+		res := tx.Exec(`UPDATE blobs SET ref_count = `+refs+`, updated_at = ?
+			WHERE ref_count <> ? AND ref_count <> `+refs, now, models.BlobCollecting)
+		fixed = res.RowsAffected
+		return res.Error
+	})
+	return fixed, err
+}
+
+// MigrateFullText создает виртуальную таблицу FTS5 для поиска по тексту книг.
+// AutoMigrate не умеет виртуальные таблицы, поэтому она создается отдельно.
+func MigrateFullText(db *gorm.DB) error {
+	return db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS book_text_fts USING fts5(
+		content,
+		book_id UNINDEXED,
+		file_id UNINDEXED,
+		ordinal UNINDEXED,
+		page UNINDEXED,
+		href UNINDEXED,
+		cfi UNINDEXED,
+		tokenize = 'unicode61 remove_diacritics 2'
+	)`).Error
 }
 
 func strPtr(s string) *string {
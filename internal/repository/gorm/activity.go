@@ -0,0 +1,80 @@
+package gorm
+
+import (
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type activityRepository struct {
+	db *gorm.DB
+}
+
+func NewActivityRepository(db *gorm.DB) *activityRepository {
+	return &activityRepository{db: db}
+}
+
+func (r *activityRepository) Save(activity *models.Activity) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "type"}, {Name: "subject_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"title"}),
+	}).Create(activity).Error
+}
+
+func (r *activityRepository) Delete(userID uuid.UUID, activityType models.ActivityType, subjectID uuid.UUID) error {
+	return r.db.Where("user_id = ? AND type = ? AND subject_id = ?", userID, activityType, subjectID).
+		Delete(&models.Activity{}).Error
+}
+
+// shared отбрасывает события видов, которые автор скрыл от подписчиков
+func (r *activityRepository) shared(q *gorm.DB) *gorm.DB {
+	hidden := r.db.Model(&models.ActivityPreference{}).Select("1").
+		Where("activity_preferences.user_id = activities.user_id AND activity_preferences.type = activities.type AND activity_preferences.shared = ?", false)
+	return q.Where("NOT EXISTS (?)", hidden)
+}
+
+func (r *activityRepository) page(q *gorm.DB, limit, offset int) ([]models.Activity, int64, error) {
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var activities []models.Activity
+	err := q.Preload("User").Preload("Book").
+		Order("created_at DESC, id DESC").Limit(limit).Offset(offset).
+		Find(&activities).Error
+	return activities, total, err
+}
+
+// ListFeed читает ленту в момент запроса (fan-out on read): подписка или
+// отписка сразу меняет ленту, и хранить копии событий для каждого
+// подписчика не нужно
+func (r *activityRepository) ListFeed(userID uuid.UUID, limit, offset int) ([]models.Activity, int64, error) {
+	following := r.db.Model(&models.Follow{}).Select("followed_user_id").Where("user_id = ?", userID)
+	q := r.db.Model(&models.Activity{}).Where("user_id IN (?)", following)
+	return r.page(r.shared(q), limit, offset)
+}
+
+func (r *activityRepository) ListByUser(userID uuid.UUID, sharedOnly bool, limit, offset int) ([]models.Activity, int64, error) {
+	q := r.db.Model(&models.Activity{}).Where("user_id = ?", userID)
+	if sharedOnly {
+		q = r.shared(q)
+	}
+	return r.page(q, limit, offset)
+}
+
+func (r *activityRepository) GetPreferences(userID uuid.UUID) ([]models.ActivityPreference, error) {
+	var prefs []models.ActivityPreference
+	err := r.db.Where("user_id = ?", userID).Find(&prefs).Error
+	return prefs, err
+}
+
+func (r *activityRepository) SavePreferences(prefs []models.ActivityPreference) error {
+	if len(prefs) == 0 {
+		return nil
+	}
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "type"}},
+		DoUpdates: clause.AssignmentColumns([]string{"shared", "updated_at"}),
+	}).Create(&prefs).Error
+}
@@ -0,0 +1,88 @@
+package gorm
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"gorm.io/gorm"
+)
+
+type annotationRepository struct {
+	db *gorm.DB
+}
+
+func NewAnnotationRepository(db *gorm.DB) *annotationRepository {
+	return &annotationRepository{db: db}
+}
+
+func (r *annotationRepository) Create(annotation *models.Annotation) error {
+	return r.db.Create(annotation).Error
+}
+
+func (r *annotationRepository) GetByID(id uuid.UUID) (*models.Annotation, error) {
+	var annotation models.Annotation
+	err := r.db.Preload("Book").First(&annotation, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &annotation, nil
+}
+
+func (r *annotationRepository) Update(annotation *models.Annotation) error {
+	return r.db.Omit("Book", "User").Save(annotation).Error
+}
+
+func (r *annotationRepository) Delete(id uuid.UUID) error {
+	return deleteWithTombstone(r.db, &models.Annotation{}, models.SyncAnnotation, id)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (r *annotationRepository) ListVisible(viewerID uuid.UUID, filter models.AnnotationFilter) ([]models.Annotation, int64, error) {
+	following := r.db.Model(&models.Follow{}).Select("followed_user_id").Where("user_id = ?", viewerID)
+	q := r.db.Model(&models.Annotation{}).Where(
+		r.db.Where("user_id = ?", viewerID).
+			Or("visibility = ?", models.VisibilityPublic).
+			Or("visibility = ? AND user_id IN (?)", models.VisibilityFollowers, following),
+	)
+	if filter.UserID != nil {
+		q = q.Where("user_id = ?", *filter.UserID)
+	}
+	if filter.BookID != nil {
+		q = q.Where("book_id = ?", *filter.BookID)
+	}
+	if filter.ReadableBy != nil {
+		now := time.Now()
+		readable := r.db.Model(&models.BookAccess{}).Select("book_id").
+			Where("user_id = ? AND status = ? AND start_date < ? AND end_date > ?", *filter.ReadableBy, models.AccessStatusActive, now, now)
+		q = q.Where("book_id IN (?)", readable)
+	}
+	if filter.Type != "" {
+		q = q.Where("type = ?", filter.Type)
+	}
+	if filter.Color != "" {
+		q = q.Where("color = ?", filter.Color)
+	}
+	if filter.Tag != "" {
+		// Теги хранятся JSON-массивом: ищем элемент в той же JSON-кодировке
+		encoded, err := json.Marshal(filter.Tag)
+		if err != nil {
+			return nil, 0, err
+		}
+		q = q.Where(`tags LIKE ? ESCAPE '\'`, "%"+likeEscaper.Replace(string(encoded))+"%")
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	q = q.Preload("Book").Order("created_at")
+	if filter.Limit > 0 {
+		q = q.Limit(filter.Limit).Offset(filter.Offset)
+	}
+	var annotations []models.Annotation
+	err := q.Find(&annotations).Error
+	return annotations, total, err
+}
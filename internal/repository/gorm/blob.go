@@ -0,0 +1,106 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type blobRepository struct {
+	db *gorm.DB
+}
+
+func NewBlobRepository(db *gorm.DB) *blobRepository {
+	return &blobRepository{db: db}
+}
+
+func (r *blobRepository) GetByPath(filePath string) (*models.Blob, error) {
+	var blob models.Blob
+	if err := r.db.First(&blob, "file_path = ?", filePath).Error; err != nil {
+		return nil, err
+	}
+	return &blob, nil
+}
+
+func (r *blobRepository) ListUnreferenced(before time.Time, limit int) ([]models.Blob, error) {
+	var blobs []models.Blob
+	err := r.db.Where("ref_count <= 0 AND updated_at < ?", before).
+		Order("updated_at").Limit(limit).Find(&blobs).Error
+	return blobs, err
+}
+
+// Claim also takes over files left claimed by an interrupted run
+func (r *blobRepository) Claim(filePath string, before time.Time) (bool, error) {
+	res := r.db.Model(&models.Blob{}).
+		Where("file_path = ? AND ref_count <= 0 AND updated_at < ?", filePath, before).
+		Updates(map[string]interface{}{"ref_count": models.BlobCollecting, "updated_at": time.Now()})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *blobRepository) Unclaim(filePath string) error {
+	return r.db.Model(&models.Blob{}).
+		Where("file_path = ? AND ref_count = ?", filePath, models.BlobCollecting).
+		Updates(map[string]interface{}{"ref_count": 0, "updated_at": time.Now()}).Error
+}
+
+func (r *blobRepository) Remove(filePath string) error {
+	return r.db.Where("file_path = ? AND ref_count = ?", filePath, models.BlobCollecting).
+		Delete(&models.Blob{}).Error
+}
+
+func (r *blobRepository) ListHashes() (map[string]string, error) {
+	var blobs []models.Blob
+	if err := r.db.Select("file_path, hash").Find(&blobs).Error; err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string, len(blobs))
+	for _, b := range blobs {
+		hashes[b.FilePath] = b.Hash
+	}
+	return hashes, nil
+}
+
+func (r *blobRepository) Reconcile() (int64, error) {
+	return repository.ReconcileBlobs(r.db)
+}
+
+// acquireBlob adds a reference to the file behind a new BookFile, creating
+// its row on first use. A file being collected is not revived: the upsert
+// matches no row and ErrBlobCollecting is returned.
+func acquireBlob(tx *gorm.DB, file *models.BookFile) error {
+	now := time.Now()
+	res := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "file_path"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"ref_count":  gorm.Expr("blobs.ref_count + 1"),
+			"updated_at": now,
+		}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			gorm.Expr("blobs.ref_count <> ?", models.BlobCollecting),
+		}},
+	}).Create(&models.Blob{
+		FilePath:  file.FilePath,
+		Hash:      file.Hash,
+		Size:      file.FileSize,
+		RefCount:  1,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return repository.ErrBlobCollecting
+	}
+	return nil
+}
+
+// releaseBlob drops a reference; the file stays until garbage collection
+func releaseBlob(tx *gorm.DB, filePath string) error {
+	return tx.Model(&models.Blob{}).
+		Where("file_path = ? AND ref_count > 0", filePath).
+		Updates(map[string]interface{}{"ref_count": gorm.Expr("ref_count - 1"), "updated_at": time.Now()}).Error
+}
@@ -63,3 +63,38 @@ func (r *bookAccessRepository) CountActiveByUser(userID uuid.UUID) (int64, error
 	err := r.db.Model(&models.BookAccess{}).Where("user_id = ? AND status = ? AND end_date > ?", userID, models.AccessStatusActive, time.Now()).Count(&count).Error
 	return count, err
 }
+
+func (r *bookAccessRepository) ExpireEndedBefore(before time.Time) (int64, error) {
+	res := r.db.Model(&models.BookAccess{}).
+		Where("status = ? AND end_date < ?", models.AccessStatusActive, before).
+		Updates(map[string]interface{}{"status": models.AccessStatusExpired, "updated_at": time.Now()})
+	return res.RowsAffected, res.Error
+}
+
+func (r *bookAccessRepository) LastUpdatedByUser(userID uuid.UUID) (time.Time, error) {
+	var accesses []models.BookAccess
+	err := r.db.Select("updated_at").Where("user_id = ?", userID).Order("updated_at DESC").Limit(1).Find(&accesses).Error
+	if err != nil || len(accesses) == 0 {
+		return time.Time{}, err
+	}
+	return accesses[0].UpdatedAt, nil
+}
+
+func (r *bookAccessRepository) CountFinishedByUsers(userIDs []uuid.UUID, from, to time.Time) (map[uuid.UUID]int64, error) {
+	counts := make(map[uuid.UUID]int64, len(userIDs))
+	if len(userIDs) == 0 {
+		return counts, nil
+	}
+	var rows []struct {
+		UserID uuid.UUID
+		Books  int64
+	}
+	err := r.db.Model(&models.BookAccess{}).
+		Select("user_id, COUNT(DISTINCT book_id) AS books").
+		Where("user_id IN ? AND finished_at >= ? AND finished_at < ?", userIDs, from, to).
+		Group("user_id").Scan(&rows).Error
+	for _, row := range rows {
+		counts[row.UserID] = row.Books
+	}
+	return counts, err
+}
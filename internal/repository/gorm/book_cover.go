@@ -0,0 +1,47 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type bookCoverRepository struct {
+	db *gorm.DB
+}
+
+func NewBookCoverRepository(db *gorm.DB) *bookCoverRepository {
+	return &bookCoverRepository{db: db}
+}
+
+func (r *bookCoverRepository) Get(bookID uuid.UUID) (*models.BookCover, error) {
+	var cover models.BookCover
+	if err := r.db.Where("book_id = ?", bookID).First(&cover).Error; err != nil {
+		return nil, err
+	}
+	return &cover, nil
+}
+
+func (r *bookCoverRepository) Save(cover *models.BookCover) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "book_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"source", "content_type", "hash", "width", "height",
+			"file_size", "file_path", "thumbnails_ready", "updated_at",
+		}),
+	}).Create(cover).Error
+}
+
+func (r *bookCoverRepository) MarkThumbnailsReady(bookID uuid.UUID, hash string) (bool, error) {
+	res := r.db.Model(&models.BookCover{}).
+		Where("book_id = ? AND hash = ?", bookID, hash).
+		Updates(map[string]interface{}{"thumbnails_ready": true, "updated_at": time.Now()})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *bookCoverRepository) Delete(bookID uuid.UUID) error {
+	return r.db.Where("book_id = ?", bookID).Delete(&models.BookCover{}).Error
+}
@@ -1,6 +1,8 @@
 package gorm
 
 import (
+	"errors"
+
 	"github.com/google/uuid"
 	"github.com/oneErrortime/afst/internal/models"
 	"gorm.io/gorm"
@@ -14,8 +16,14 @@ func NewBookFileRepository(db *gorm.DB) *bookFileRepository {
 	return &bookFileRepository{db: db}
 }
 
+// Create stores the record together with a reference to its file
 func (r *bookFileRepository) Create(file *models.BookFile) error {
-	return r.db.Create(file).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := acquireBlob(tx, file); err != nil {
+			return err
+		}
+		return tx.Create(file).Error
+	})
 }
 
 func (r *bookFileRepository) GetByID(id uuid.UUID) (*models.BookFile, error) {
@@ -36,10 +44,53 @@ func (r *bookFileRepository) GetByHash(hash string) (*models.BookFile, error) {
 	return &file, err
 }
 
+func (r *bookFileRepository) GetByBookAndHash(bookID uuid.UUID, hash string) (*models.BookFile, error) {
+	var file models.BookFile
+	if err := r.db.Where("book_id = ? AND hash = ?", bookID, hash).First(&file).Error; err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (r *bookFileRepository) GetDerived(sourceID uuid.UUID) ([]models.BookFile, error) {
+	var files []models.BookFile
+	err := r.db.Where("source_file_id = ?", sourceID).Order("created_at").Find(&files).Error
+	return files, err
+}
+
+func (r *bookFileRepository) ListHashes() (map[string]string, error) {
+	var rows []struct {
+		FilePath string
+		Hash     string
+	}
+	if err := r.db.Model(&models.BookFile{}).Select("file_path, hash").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string, len(rows))
+	for _, row := range rows {
+		hashes[row.FilePath] = row.Hash
+	}
+	return hashes, nil
+}
+
 func (r *bookFileRepository) Update(file *models.BookFile) error {
 	return r.db.Save(file).Error
 }
 
+// Delete removes the record and releases its file
 func (r *bookFileRepository) Delete(id uuid.UUID) error {
-	return r.db.Delete(&models.BookFile{}, "id = ?", id).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var file models.BookFile
+		err := tx.Select("id", "file_path").First(&file, "id = ?", id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.BookFile{}, "id = ?", id).Error; err != nil {
+			return err
+		}
+		return releaseBlob(tx, file.FilePath)
+	})
 }
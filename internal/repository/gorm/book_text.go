@@ -0,0 +1,103 @@
+package gorm
+
+import (
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// snippetTokens — сколько слов вокруг совпадения попадает в сниппет
+const snippetTokens = 16
+
+type bookTextRepository struct {
+	db *gorm.DB
+}
+
+func NewBookTextRepository(db *gorm.DB) *bookTextRepository {
+	return &bookTextRepository{db: db}
+}
+
+func (r *bookTextRepository) ReplaceFile(index *models.BookTextIndex, chunks []models.BookTextChunk) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM book_text_fts WHERE file_id = ?", index.FileID.String()).Error; err != nil {
+			return err
+		}
+		for _, c := range chunks {
+			err := tx.Exec(
+				"INSERT INTO book_text_fts (content, book_id, file_id, ordinal, page, href, cfi) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				c.Text, index.BookID.String(), index.FileID.String(), c.Ordinal, c.Page, c.Href, c.CFI,
+			).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(index).Error
+	})
+}
+
+func (r *bookTextRepository) DeleteFile(fileID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM book_text_fts WHERE file_id = ?", fileID.String()).Error; err != nil {
+			return err
+		}
+		return tx.Where("file_id = ?", fileID).Delete(&models.BookTextIndex{}).Error
+	})
+}
+
+func (r *bookTextRepository) GetIndexes(bookID uuid.UUID) ([]models.BookTextIndex, error) {
+	var indexes []models.BookTextIndex
+	err := r.db.Where("book_id = ?", bookID).Order("indexed_at").Find(&indexes).Error
+	return indexes, err
+}
+
+func (r *bookTextRepository) Search(bookID uuid.UUID, match string, limit, offset int) ([]models.BookSearchHitDTO, int64, error) {
+	var total int64
+	err := r.db.Raw(
+		"SELECT COUNT(*) FROM book_text_fts WHERE book_text_fts MATCH ? AND book_id = ?",
+		match, bookID.String(),
+	).Scan(&total).Error
+	if err != nil || total == 0 {
+		return []models.BookSearchHitDTO{}, total, err
+	}
+
+	var hits []models.BookSearchHitDTO
+	err = r.db.Raw(`
+		SELECT book_text_fts.file_id AS file_id, i.format AS format, page, href, cfi,
+			snippet(book_text_fts, 0, ?, ?, '…', ?) AS snippet,
+			-bm25(book_text_fts) AS score
+		FROM book_text_fts
+		JOIN book_text_indexes i ON i.file_id = book_text_fts.file_id
+		WHERE book_text_fts MATCH ? AND book_text_fts.book_id = ?
+		ORDER BY bm25(book_text_fts), ordinal
+		LIMIT ? OFFSET ?`,
+		repository.SnippetMatchStart, repository.SnippetMatchEnd, snippetTokens, match, bookID.String(), limit, offset,
+	).Scan(&hits).Error
+	return hits, total, err
+}
+
+func (r *bookTextRepository) SearchLibrary(match string, limit int) ([]models.LibrarySearchHitDTO, error) {
+	var hits []models.LibrarySearchHitDTO
+	err := r.db.Raw(`
+		SELECT t.book_id AS book_id, b.title AS title, b.author AS author,
+			t.hits AS hits, s.chunks AS chunks,
+			CAST(t.hits AS REAL) / s.chunks AS density
+		FROM (
+			SELECT book_id, COUNT(*) AS hits FROM book_text_fts
+			WHERE book_text_fts MATCH ? GROUP BY book_id
+		) t
+		JOIN (
+			SELECT book_id, SUM(chunk_count) AS chunks FROM book_text_indexes GROUP BY book_id
+		) s ON s.book_id = t.book_id
+		JOIN books b ON b.id = t.book_id AND b.deleted_at IS NULL
+		WHERE s.chunks > 0
+		ORDER BY density DESC, hits DESC
+		LIMIT ?`,
+		match, limit,
+	).Scan(&hits).Error
+	if hits == nil {
+		hits = []models.LibrarySearchHitDTO{}
+	}
+	return hits, err
+}
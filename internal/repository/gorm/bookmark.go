@@ -37,5 +37,5 @@ func (r *bookmarkRepository) GetByID(id uuid.UUID) (*models.Bookmark, error) {
 }
 
 func (r *bookmarkRepository) Delete(id uuid.UUID) error {
-	return r.db.Delete(&models.Bookmark{}, "id = ?", id).Error
+	return deleteWithTombstone(r.db, &models.Bookmark{}, models.SyncBookmark, id)
 }
@@ -61,3 +61,26 @@ func (r *categoryRepository) Count() (int64, error) {
 	err := r.db.Model(&models.Category{}).Where("is_active = ?", true).Count(&count).Error
 	return count, err
 }
+
+func (r *categoryRepository) GetByBookIDs(bookIDs []uuid.UUID) (map[uuid.UUID][]models.Category, error) {
+	result := make(map[uuid.UUID][]models.Category)
+	if len(bookIDs) == 0 {
+		return result, nil
+	}
+	var rows []struct {
+		BookID uuid.UUID
+		models.Category
+	}
+	err := r.db.Table("categories").
+		Select("book_categories.book_id AS book_id, categories.*").
+		Joins("JOIN book_categories ON book_categories.category_id = categories.id").
+		Where("book_categories.book_id IN ? AND categories.deleted_at IS NULL", bookIDs).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.BookID] = append(result[row.BookID], row.Category)
+	}
+	return result, nil
+}
@@ -0,0 +1,63 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type downloadRepository struct {
+	db *gorm.DB
+}
+
+func NewDownloadRepository(db *gorm.DB) *downloadRepository {
+	return &downloadRepository{db: db}
+}
+
+// Record counts and inserts in one transaction so concurrent downloads
+// cannot both pass the last free slot of the quota.
+func (r *downloadRepository) Record(download *models.BookDownload, since time.Time, limit int) (bool, error) {
+	counted := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var seen int64
+		if err := tx.Model(&models.BookDownload{}).Where("link_id = ?", download.LinkID).Count(&seen).Error; err != nil {
+			return err
+		}
+		if seen > 0 {
+			return nil
+		}
+
+		if limit >= 0 {
+			var used int64
+			if err := tx.Model(&models.BookDownload{}).
+				Where("user_id = ? AND created_at >= ?", download.UserID, since).
+				Count(&used).Error; err != nil {
+				return err
+			}
+			if used >= int64(limit) {
+				return repository.ErrDownloadLimitReached
+			}
+		}
+
+		res := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "link_id"}}, DoNothing: true}).Create(download)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		counted = true
+		return tx.Model(&models.Book{}).Where("id = ?", download.BookID).
+			UpdateColumn("download_count", gorm.Expr("download_count + 1")).Error
+	})
+	return counted, err
+}
+
+func (r *downloadRepository) CountByUserSince(userID uuid.UUID, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.BookDownload{}).
+		Where("user_id = ? AND created_at >= ?", userID, since).
+		Count(&count).Error
+	return count, err
+}
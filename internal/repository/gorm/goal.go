@@ -0,0 +1,162 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"gorm.io/gorm"
+)
+
+type goalRepository struct {
+	db *gorm.DB
+}
+
+func NewGoalRepository(db *gorm.DB) *goalRepository {
+	return &goalRepository{db: db}
+}
+
+func (r *goalRepository) Create(goal *models.ReadingGoal) error {
+	return r.db.Create(goal).Error
+}
+
+func (r *goalRepository) GetByID(id uuid.UUID) (*models.ReadingGoal, error) {
+	var goal models.ReadingGoal
+	err := r.db.First(&goal, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &goal, nil
+}
+
+func (r *goalRepository) GetByUserID(userID uuid.UUID) ([]models.ReadingGoal, error) {
+	var goals []models.ReadingGoal
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&goals).Error
+	return goals, err
+}
+
+func (r *goalRepository) ListActive() ([]models.ReadingGoal, error) {
+	var goals []models.ReadingGoal
+	err := r.db.Where("is_active = ?", true).Order("user_id, created_at").Find(&goals).Error
+	return goals, err
+}
+
+func (r *goalRepository) Update(goal *models.ReadingGoal) error {
+	return r.db.Save(goal).Error
+}
+
+func (r *goalRepository) Delete(id uuid.UUID) error {
+	return r.db.Delete(&models.ReadingGoal{}, "id = ?", id).Error
+}
+
+// MarkReached и MarkWarned — условные обновления: при одновременной проверке
+// из нескольких экземпляров событие за период отправит только один
+func (r *goalRepository) MarkReached(id uuid.UUID, period string) (bool, error) {
+	return r.mark(id, "reached_period", period)
+}
+
+func (r *goalRepository) MarkWarned(id uuid.UUID, period string) (bool, error) {
+	return r.mark(id, "warned_period", period)
+}
+
+func (r *goalRepository) mark(id uuid.UUID, column, period string) (bool, error) {
+	res := r.db.Model(&models.ReadingGoal{}).
+		Where("id = ? AND ("+column+" IS NULL OR "+column+" <> ?)", id, period).
+		Update(column, period)
+	return res.RowsAffected > 0, res.Error
+}
+
+type challengeRepository struct {
+	db *gorm.DB
+}
+
+func NewChallengeRepository(db *gorm.DB) *challengeRepository {
+	return &challengeRepository{db: db}
+}
+
+func (r *challengeRepository) Create(challenge *models.ReadingChallenge) error {
+	return r.db.Create(challenge).Error
+}
+
+func (r *challengeRepository) GetByID(id uuid.UUID) (*models.ReadingChallenge, error) {
+	var challenge models.ReadingChallenge
+	err := r.db.First(&challenge, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+func (r *challengeRepository) List() ([]models.ReadingChallenge, error) {
+	var challenges []models.ReadingChallenge
+	err := r.db.Order("start_date DESC").Find(&challenges).Error
+	return challenges, err
+}
+
+func (r *challengeRepository) ListRunning(now time.Time) ([]models.ReadingChallenge, error) {
+	var challenges []models.ReadingChallenge
+	err := r.db.Where("start_date <= ? AND end_date > ?", now, now).Find(&challenges).Error
+	return challenges, err
+}
+
+func (r *challengeRepository) Update(challenge *models.ReadingChallenge) error {
+	return r.db.Save(challenge).Error
+}
+
+func (r *challengeRepository) Delete(id uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.ChallengeParticipant{}, "challenge_id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.ReadingChallenge{}, "id = ?", id).Error
+	})
+}
+
+func (r *challengeRepository) AddParticipant(participant *models.ChallengeParticipant) error {
+	return r.db.Create(participant).Error
+}
+
+func (r *challengeRepository) RemoveParticipant(challengeID, userID uuid.UUID) error {
+	return r.db.Delete(&models.ChallengeParticipant{}, "challenge_id = ? AND user_id = ?", challengeID, userID).Error
+}
+
+func (r *challengeRepository) GetParticipant(challengeID, userID uuid.UUID) (*models.ChallengeParticipant, error) {
+	var participant models.ChallengeParticipant
+	err := r.db.Where("challenge_id = ? AND user_id = ?", challengeID, userID).First(&participant).Error
+	if err != nil {
+		return nil, err
+	}
+	return &participant, nil
+}
+
+func (r *challengeRepository) ListParticipants(challengeID uuid.UUID) ([]models.ChallengeParticipant, error) {
+	var participants []models.ChallengeParticipant
+	err := r.db.Preload("User").Where("challenge_id = ?", challengeID).Order("joined_at").Find(&participants).Error
+	return participants, err
+}
+
+func (r *challengeRepository) CountParticipants(challengeIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	counts := make(map[uuid.UUID]int64, len(challengeIDs))
+	if len(challengeIDs) == 0 {
+		return counts, nil
+	}
+	var rows []struct {
+		ChallengeID uuid.UUID
+		Total       int64
+	}
+	err := r.db.Model(&models.ChallengeParticipant{}).
+		Select("challenge_id, COUNT(*) AS total").
+		Where("challenge_id IN ?", challengeIDs).
+		Group("challenge_id").Scan(&rows).Error
+	for _, row := range rows {
+		counts[row.ChallengeID] = row.Total
+	}
+	return counts, err
+}
+
+func (r *challengeRepository) MarkCompleted(participantID uuid.UUID, at time.Time) (bool, error) {
+	res := r.db.Model(&models.ChallengeParticipant{}).
+		Where("id = ? AND completed_at IS NULL", participantID).
+		Update("completed_at", at)
+	return res.RowsAffected > 0, res.Error
+}
@@ -0,0 +1,305 @@
+package gorm
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// claimAttempts — сколько раз Claim пробует взять задачу, если её перехватил другой воркер
+const claimAttempts = 3
+
+type jobRepository struct {
+	db *gorm.DB
+}
+
+func NewJobRepository(db *gorm.DB) *jobRepository {
+	return &jobRepository{db: db}
+}
+
+func (r *jobRepository) Enqueue(job *models.Job) error {
+	return r.db.Create(job).Error
+}
+
+func (r *jobRepository) EnqueueOnce(job *models.Job) (bool, error) {
+	res := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(job)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *jobRepository) GetByID(id uuid.UUID) (*models.Job, error) {
+	var job models.Job
+	if err := r.db.First(&job, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *jobRepository) List(filter models.JobFilter) ([]models.Job, int64, error) {
+	query := r.db.Model(&models.Job{})
+	if filter.Queue != "" {
+		query = query.Where("queue = ?", filter.Queue)
+	}
+	if filter.Kind != "" {
+		query = query.Where("kind = ?", filter.Kind)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var jobs []models.Job
+	err := query.Order("created_at DESC").
+		Limit(filter.Limit).Offset(filter.Offset).
+		Find(&jobs).Error
+	return jobs, total, err
+}
+
+// Claim выбирает кандидата и забирает его условным UPDATE: если между SELECT и UPDATE
+// задачу взял другой воркер, RowsAffected будет 0 и мы пробуем следующего.
+func (r *jobRepository) Claim(queue, owner string, lease time.Duration) (*models.Job, error) {
+	for i := 0; i < claimAttempts; i++ {
+		now := time.Now()
+
+		var candidate models.Job
+		err := r.db.Where("queue = ?", queue).
+			Where(r.claimable(now)).
+			Order("run_at ASC").
+			First(&candidate).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		until := now.Add(lease)
+		res := r.db.Model(&models.Job{}).
+			Where("id = ?", candidate.ID).
+			Where(r.claimable(now)).
+			Updates(map[string]interface{}{
+				"status":      models.JobStatusRunning,
+				"attempts":    gorm.Expr("attempts + 1"),
+				"lease_owner": owner,
+				"lease_until": until,
+				"started_at":  now,
+				"updated_at":  now,
+			})
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 1 {
+			return r.GetByID(candidate.ID)
+		}
+	}
+	return nil, nil
+}
+
+// claimable — готовые к запуску задачи и задачи упавших воркеров с неисчерпанными попытками
+func (r *jobRepository) claimable(now time.Time) *gorm.DB {
+	return r.db.Where("status IN ? AND run_at <= ?",
+		[]models.JobStatus{models.JobStatusPending, models.JobStatusFailed}, now).
+		Or("status = ? AND lease_until < ? AND attempts < max_attempts",
+			models.JobStatusRunning, now)
+}
+
+func (r *jobRepository) ExtendLease(id uuid.UUID, owner string, until time.Time) (bool, error) {
+	res := r.db.Model(&models.Job{}).
+		Where("id = ? AND status = ? AND lease_owner = ?", id, models.JobStatusRunning, owner).
+		Updates(map[string]interface{}{"lease_until": until, "updated_at": time.Now()})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *jobRepository) Complete(id uuid.UUID, owner string) (bool, error) {
+	now := time.Now()
+	res := r.db.Model(&models.Job{}).
+		Where("id = ? AND status = ? AND lease_owner = ?", id, models.JobStatusRunning, owner).
+		Updates(map[string]interface{}{
+			"status":      models.JobStatusSucceeded,
+			"lease_owner": nil,
+			"lease_until": nil,
+			"last_error":  nil,
+			"finished_at": now,
+			"updated_at":  now,
+		})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *jobRepository) Fail(id uuid.UUID, owner, errMsg string, nextRunAt *time.Time) (bool, error) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"lease_owner": nil,
+		"lease_until": nil,
+		"last_error":  errMsg,
+		"updated_at":  now,
+	}
+	if nextRunAt != nil {
+		updates["status"] = models.JobStatusFailed
+		updates["run_at"] = *nextRunAt
+	} else {
+		updates["status"] = models.JobStatusDead
+		updates["finished_at"] = now
+	}
+
+	res := r.db.Model(&models.Job{}).
+		Where("id = ? AND status = ? AND lease_owner = ?", id, models.JobStatusRunning, owner).
+		Updates(updates)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *jobRepository) ReapExpired(queue string) (int64, error) {
+	now := time.Now()
+	res := r.db.Model(&models.Job{}).
+		Where("queue = ? AND status = ? AND lease_until < ? AND attempts >= max_attempts",
+			queue, models.JobStatusRunning, now).
+		Updates(map[string]interface{}{
+			"status":      models.JobStatusDead,
+			"lease_owner": nil,
+			"lease_until": nil,
+			"last_error":  "lease expired on last attempt",
+			"finished_at": now,
+			"updated_at":  now,
+		})
+	return res.RowsAffected, res.Error
+}
+
+// Retry возвращает задачу в очередь с полным набором попыток
+func (r *jobRepository) Retry(id uuid.UUID) error {
+	now := time.Now()
+	res := r.db.Model(&models.Job{}).
+		Where("id = ? AND status IN ?", id, []models.JobStatus{
+			models.JobStatusFailed, models.JobStatusDead, models.JobStatusCancelled,
+		}).
+		Updates(map[string]interface{}{
+			"status":      models.JobStatusPending,
+			"attempts":    0,
+			"run_at":      now,
+			"finished_at": nil,
+			"updated_at":  now,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Cancel снимает задачу, которая ещё не завершилась. Работающий воркер
+// узнает об отмене при следующем продлении аренды.
+func (r *jobRepository) Cancel(id uuid.UUID) error {
+	now := time.Now()
+	res := r.db.Model(&models.Job{}).
+		Where("id = ? AND status IN ?", id, []models.JobStatus{
+			models.JobStatusPending, models.JobStatusFailed, models.JobStatusRunning,
+		}).
+		Updates(map[string]interface{}{
+			"status":      models.JobStatusCancelled,
+			"lease_owner": nil,
+			"lease_until": nil,
+			"finished_at": now,
+			"updated_at":  now,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *jobRepository) Stats(since time.Time) ([]models.JobQueueStatsDTO, error) {
+	var counts []struct {
+		Queue  string
+		Status models.JobStatus
+		Count  int64
+	}
+	if err := r.db.Model(&models.Job{}).
+		Select("queue, status, COUNT(*) AS count").
+		Group("queue, status").
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+
+	var finished []struct {
+		Queue  string
+		Status models.JobStatus
+		Count  int64
+	}
+	if err := r.db.Model(&models.Job{}).
+		Select("queue, status, COUNT(*) AS count").
+		Where("finished_at >= ? AND status IN ?", since,
+			[]models.JobStatus{models.JobStatusSucceeded, models.JobStatusDead}).
+		Group("queue, status").
+		Scan(&finished).Error; err != nil {
+		return nil, err
+	}
+
+	byQueue := make(map[string]*models.JobQueueStatsDTO)
+	var order []string
+	get := func(queue string) *models.JobQueueStatsDTO {
+		s, ok := byQueue[queue]
+		if !ok {
+			s = &models.JobQueueStatsDTO{Queue: queue, ByStatus: make(map[models.JobStatus]int64)}
+			byQueue[queue] = s
+			order = append(order, queue)
+		}
+		return s
+	}
+
+	for _, c := range counts {
+		s := get(c.Queue)
+		s.ByStatus[c.Status] = c.Count
+		switch c.Status {
+		case models.JobStatusPending, models.JobStatusFailed:
+			s.Depth += c.Count
+		case models.JobStatusRunning:
+			s.Running = c.Count
+		}
+	}
+	for _, f := range finished {
+		s := get(f.Queue)
+		if f.Status == models.JobStatusSucceeded {
+			s.Succeeded = f.Count
+		} else {
+			s.Failed = f.Count
+		}
+	}
+
+	now := time.Now()
+	stats := make([]models.JobQueueStatsDTO, 0, len(order))
+	for _, queue := range order {
+		s := byQueue[queue]
+		if s.Depth > 0 {
+			var oldest models.Job
+			err := r.db.Select("run_at").
+				Where("queue = ? AND status IN ? AND run_at <= ?", queue,
+					[]models.JobStatus{models.JobStatusPending, models.JobStatusFailed}, now).
+				Order("run_at ASC").
+				First(&oldest).Error
+			if err == nil {
+				s.OldestPendingSec = int64(now.Sub(oldest.RunAt).Seconds())
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, err
+			}
+		}
+		stats = append(stats, *s)
+	}
+	return stats, nil
+}
+
+func (r *jobRepository) DeleteFinishedBefore(before time.Time) (int64, error) {
+	res := r.db.Where("status IN ? AND finished_at < ?",
+		[]models.JobStatus{models.JobStatusSucceeded, models.JobStatusCancelled}, before).
+		Delete(&models.Job{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,102 @@
+package gorm
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/events"
+	"github.com/oneErrortime/afst/internal/models"
+	"gorm.io/gorm"
+)
+
+type outboxRepository struct {
+	db *gorm.DB
+}
+
+func NewOutboxRepository(db *gorm.DB) *outboxRepository {
+	return &outboxRepository{db: db}
+}
+
+func (r *outboxRepository) Enqueue(event events.Event) error {
+	payload, err := json.Marshal(event.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s payload: %w", event.Type, err)
+	}
+
+	id := uuid.New()
+	if event.ID != "" {
+		if parsed, err := uuid.Parse(event.ID); err == nil {
+			id = parsed
+		}
+	}
+
+	return r.db.Create(&models.OutboxEvent{
+		ID:      id,
+		Type:    string(event.Type),
+		UserID:  event.UserID,
+		Payload: string(payload),
+	}).Error
+}
+
+func (r *outboxRepository) GetPending(limit int) ([]models.OutboxEvent, error) {
+	var pending []models.OutboxEvent
+	err := r.db.Where("published_at IS NULL").
+		Order("created_at ASC, id ASC").
+		Limit(limit).Find(&pending).Error
+	return pending, err
+}
+
+// Claim выбирает кандидатов и забирает их условным UPDATE, как jobRepository.Claim:
+// события, которые между SELECT и UPDATE взял другой релей, не обновятся,
+// и возвращаются только строки, доставшиеся owner.
+func (r *outboxRepository) Claim(owner string, limit int, lease time.Duration) ([]models.OutboxEvent, error) {
+	now := time.Now()
+	var ids []uuid.UUID
+	err := r.db.Model(&models.OutboxEvent{}).
+		Where(r.claimable(now)).
+		Order("created_at ASC, id ASC").
+		Limit(limit).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	res := r.db.Model(&models.OutboxEvent{}).
+		Where("id IN ?", ids).
+		Where(r.claimable(now)).
+		Updates(map[string]interface{}{
+			"claimed_by":    owner,
+			"claimed_until": now.Add(lease),
+		})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return nil, res.Error
+	}
+
+	var claimed []models.OutboxEvent
+	err = r.db.Where("id IN ? AND claimed_by = ? AND published_at IS NULL", ids, owner).
+		Order("created_at ASC, id ASC").
+		Find(&claimed).Error
+	return claimed, err
+}
+
+// claimable — неопубликованные события без действующей аренды
+func (r *outboxRepository) claimable(now time.Time) *gorm.DB {
+	return r.db.Where("published_at IS NULL").
+		Where("claimed_until IS NULL OR claimed_until < ?", now)
+}
+
+func (r *outboxRepository) MarkPublished(ids []uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.Model(&models.OutboxEvent{}).
+		Where("id IN ?", ids).
+		Update("published_at", time.Now()).Error
+}
+
+func (r *outboxRepository) DeletePublishedBefore(before time.Time) (int64, error) {
+	res := r.db.Where("published_at IS NOT NULL AND published_at < ?", before).
+		Delete(&models.OutboxEvent{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,53 @@
+package gorm
+
+import (
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type readingPositionRepository struct {
+	db *gorm.DB
+}
+
+func NewReadingPositionRepository(db *gorm.DB) *readingPositionRepository {
+	return &readingPositionRepository{db: db}
+}
+
+// Save — условный upsert: строка устройства обновляется, только если новая
+// позиция изменена позже сохранённой. Запоздавший запрос с устройства не
+// затирает более новую позицию, даже если запросы пришли одновременно.
+func (r *readingPositionRepository) Save(pos *models.ReadingPosition) (bool, error) {
+	res := r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "book_id"}, {Name: "device_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"device_name", "locator_type", "locator_file_id", "locator_page", "locator_cfi",
+			"locator_percent", "progress", "client_updated_at", "updated_at",
+		}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			gorm.Expr("reading_positions.client_updated_at < excluded.client_updated_at"),
+		}},
+	}).Create(pos)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+func (r *readingPositionRepository) GetByDevice(userID, bookID uuid.UUID, deviceID string) (*models.ReadingPosition, error) {
+	var pos models.ReadingPosition
+	err := r.db.Where("user_id = ? AND book_id = ? AND device_id = ?", userID, bookID, deviceID).First(&pos).Error
+	if err != nil {
+		return nil, err
+	}
+	return &pos, nil
+}
+
+func (r *readingPositionRepository) ListByBook(userID, bookID uuid.UUID) ([]models.ReadingPosition, error) {
+	var positions []models.ReadingPosition
+	err := r.db.Where("user_id = ? AND book_id = ?", userID, bookID).
+		Order("client_updated_at DESC, device_id DESC").
+		Find(&positions).Error
+	return positions, err
+}
@@ -1,6 +1,8 @@
 package gorm
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/oneErrortime/afst/internal/models"
 	"gorm.io/gorm"
@@ -64,3 +66,50 @@ func (r *readingSessionRepository) GetBookStats(bookID uuid.UUID) (totalReaders,
 	totalReadTime = result.Total
 	return
 }
+
+func (r *readingSessionRepository) GetEndedByUser(userID uuid.UUID) ([]models.ReadingSession, error) {
+	var sessions []models.ReadingSession
+	err := r.db.Where("user_id = ? AND ended_at IS NOT NULL", userID).Order("started_at").Find(&sessions).Error
+	return sessions, err
+}
+
+func (r *readingSessionRepository) LastUpdatedByUser(userID uuid.UUID) (time.Time, error) {
+	var sessions []models.ReadingSession
+	err := r.db.Select("updated_at").Where("user_id = ?", userID).Order("updated_at DESC").Limit(1).Find(&sessions).Error
+	if err != nil || len(sessions) == 0 {
+		return time.Time{}, err
+	}
+	return sessions[0].UpdatedAt, nil
+}
+
+func (r *readingSessionRepository) TotalsByUsers(userIDs []uuid.UUID, from, to time.Time) (map[uuid.UUID]models.ReadingTotals, error) {
+	totals := make(map[uuid.UUID]models.ReadingTotals, len(userIDs))
+	if len(userIDs) == 0 {
+		return totals, nil
+	}
+	var rows []struct {
+		UserID  uuid.UUID
+		Seconds int64
+		Pages   int64
+	}
+	err := r.db.Model(&models.ReadingSession{}).
+		Select("user_id, COALESCE(SUM(duration), 0) AS seconds, "+
+			"COALESCE(SUM(CASE WHEN end_page > start_page THEN end_page - start_page ELSE 0 END), 0) AS pages").
+		Where("user_id IN ? AND ended_at IS NOT NULL AND started_at >= ? AND started_at < ?", userIDs, from, to).
+		Group("user_id").Scan(&rows).Error
+	for _, row := range rows {
+		totals[row.UserID] = models.ReadingTotals{Seconds: row.Seconds, Pages: row.Pages}
+	}
+	return totals, err
+}
+
+func (r *readingSessionRepository) CloseIdle(before time.Time) (int64, error) {
+	lastActive := "COALESCE(last_active_at, started_at)"
+	res := r.db.Model(&models.ReadingSession{}).
+		Where("ended_at IS NULL AND "+lastActive+" < ?", before).
+		Updates(map[string]interface{}{
+			"ended_at":   gorm.Expr(lastActive),
+			"updated_at": time.Now(),
+		})
+	return res.RowsAffected, res.Error
+}
@@ -35,6 +35,22 @@ func NewExtendedRepository(db *gorm.DB) *repository.ExtendedRepository {
 		Collection:     NewCollectionRepository(db),
 		Review:         NewReviewRepository(db),
 		Bookmark:       NewBookmarkRepository(db),
+		Webhook:        NewWebhookRepository(db),
+		Outbox:         NewOutboxRepository(db),
+		Job:            NewJobRepository(db),
+		Scheduler:      NewSchedulerRepository(db),
+		BookCover:      NewBookCoverRepository(db),
+		BookText:       NewBookTextRepository(db),
+		Upload:         NewUploadRepository(db),
+		Blob:           NewBlobRepository(db),
+		Download:       NewDownloadRepository(db),
+		Watermark:      NewWatermarkRepository(db),
+		Position:       NewReadingPositionRepository(db),
+		Annotation:     NewAnnotationRepository(db),
+		Goal:           NewGoalRepository(db),
+		Challenge:      NewChallengeRepository(db),
+		Sync:           NewSyncRepository(db),
+		Activity:       NewActivityRepository(db),
 		DB:             db,
 	}
 }
@@ -60,6 +76,21 @@ func WithTransaction(repo *repository.ExtendedRepository, fn repository.Transact
 			BookFile:       NewBookFileRepository(tx),
 			ReadingSession: NewReadingSessionRepository(tx),
 			Social:         NewSocialRepository(tx),
+			Outbox:         NewOutboxRepository(tx),
+			Job:            NewJobRepository(tx),
+			Scheduler:      NewSchedulerRepository(tx),
+			BookCover:      NewBookCoverRepository(tx),
+			BookText:       NewBookTextRepository(tx),
+			Upload:         NewUploadRepository(tx),
+			Blob:           NewBlobRepository(tx),
+			Download:       NewDownloadRepository(tx),
+			Watermark:      NewWatermarkRepository(tx),
+			Position:       NewReadingPositionRepository(tx),
+			Annotation:     NewAnnotationRepository(tx),
+			Goal:           NewGoalRepository(tx),
+			Challenge:      NewChallengeRepository(tx),
+			Sync:           NewSyncRepository(tx),
+			Activity:       NewActivityRepository(tx),
 			DB:             tx,
 		}
 		return fn(txRepo)
@@ -0,0 +1,134 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/oneErrortime/afst/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type schedulerRepository struct {
+	db *gorm.DB
+}
+
+func NewSchedulerRepository(db *gorm.DB) *schedulerRepository {
+	return &schedulerRepository{db: db}
+}
+
+func (r *schedulerRepository) Register(name, schedule string, nextRunAt time.Time) error {
+	task := models.ScheduledTask{Name: name, Schedule: schedule, NextRunAt: nextRunAt}
+	if err := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&task).Error; err != nil {
+		return err
+	}
+	// Расписание поменялось в коде — пересчитываем следующий запуск
+	return r.db.Model(&models.ScheduledTask{}).
+		Where("name = ? AND schedule <> ?", name, schedule).
+		Updates(map[string]interface{}{
+			"schedule":    schedule,
+			"next_run_at": nextRunAt,
+			"updated_at":  time.Now(),
+		}).Error
+}
+
+func (r *schedulerRepository) Acquire(name, owner string, lease time.Duration) (*models.ScheduledTask, error) {
+	now := time.Now()
+	res := r.db.Model(&models.ScheduledTask{}).
+		Where("name = ?", name).
+		Where(r.db.Where("next_run_at <= ?", now).Or("run_requested = ?", true)).
+		Where(r.db.Where("lease_until IS NULL").Or("lease_until < ?", now)).
+		Updates(map[string]interface{}{
+			"lease_owner": owner,
+			"lease_until": now.Add(lease),
+			"updated_at":  now,
+		})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+	return r.Get(name)
+}
+
+func (r *schedulerRepository) ExtendLease(name, owner string, until time.Time) (bool, error) {
+	res := r.db.Model(&models.ScheduledTask{}).
+		Where("name = ? AND lease_owner = ?", name, owner).
+		Updates(map[string]interface{}{"lease_until": until, "updated_at": time.Now()})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *schedulerRepository) StartRun(run *models.ScheduledTaskRun) error {
+	return r.db.Create(run).Error
+}
+
+func (r *schedulerRepository) Finish(name, owner string, run *models.ScheduledTaskRun, nextRunAt time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(run).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{
+			"next_run_at":      nextRunAt,
+			"lease_owner":      nil,
+			"lease_until":      nil,
+			"last_run_at":      run.StartedAt,
+			"last_status":      run.Status,
+			"last_error":       run.Error,
+			"last_duration_ms": run.DurationMs,
+			"run_count":        gorm.Expr("run_count + 1"),
+			"updated_at":       time.Now(),
+		}
+		// Запрос, пришедший во время планового запуска, не теряем
+		if run.Manual {
+			updates["run_requested"] = false
+		}
+		if run.Status == models.ScheduledRunFailed {
+			updates["fail_count"] = gorm.Expr("fail_count + 1")
+		}
+		return tx.Model(&models.ScheduledTask{}).
+			Where("name = ? AND lease_owner = ?", name, owner).
+			Updates(updates).Error
+	})
+}
+
+func (r *schedulerRepository) RequestRun(name string) error {
+	res := r.db.Model(&models.ScheduledTask{}).
+		Where("name = ?", name).
+		Updates(map[string]interface{}{"run_requested": true, "updated_at": time.Now()})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *schedulerRepository) List() ([]models.ScheduledTask, error) {
+	var tasks []models.ScheduledTask
+	err := r.db.Order("name ASC").Find(&tasks).Error
+	return tasks, err
+}
+
+func (r *schedulerRepository) Get(name string) (*models.ScheduledTask, error) {
+	var task models.ScheduledTask
+	if err := r.db.First(&task, "name = ?", name).Error; err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (r *schedulerRepository) ListRuns(name string, limit int) ([]models.ScheduledTaskRun, error) {
+	var runs []models.ScheduledTaskRun
+	err := r.db.Where("task_name = ?", name).
+		Order("started_at DESC").
+		Limit(limit).Find(&runs).Error
+	return runs, err
+}
+
+func (r *schedulerRepository) DeleteRunsBefore(before time.Time) (int64, error) {
+	res := r.db.Where("started_at < ? AND status <> ?", before, models.ScheduledRunRunning).
+		Delete(&models.ScheduledTaskRun{})
+	return res.RowsAffected, res.Error
+}
@@ -28,26 +28,34 @@ func (r *socialRepository) Unfollow(userID, targetUserID uuid.UUID) error {
 	return r.db.Where("user_id = ? AND followed_user_id = ?", userID, targetUserID).Delete(&models.Follow{}).Error
 }
 
-// GetFollowers возвращает список ID пользователей, которые подписаны на targetUserID.
-func (r *socialRepository) GetFollowers(targetUserID uuid.UUID) ([]models.User, error) {
-	var users []models.User
-	err := r.db.Table("users").
-		Select("users.*").
+// GetFollowers возвращает страницу пользователей, которые подписаны на targetUserID, и их общее число.
+func (r *socialRepository) GetFollowers(targetUserID uuid.UUID, limit, offset int) ([]models.User, int64, error) {
+	q := r.db.Table("users").
 		Joins("JOIN follows ON follows.user_id = users.id").
-		Where("follows.followed_user_id = ?", targetUserID).
-		Find(&users).Error
-	return users, err
+		Where("follows.followed_user_id = ? AND users.deleted_at IS NULL", targetUserID)
+	return r.followPage(q, limit, offset)
 }
 
-// GetFollowing возвращает список ID пользователей, на которых подписан userID.
-func (r *socialRepository) GetFollowing(userID uuid.UUID) ([]models.User, error) {
-	var users []models.User
-	err := r.db.Table("users").
-		Select("users.*").
+// GetFollowing возвращает страницу пользователей, на которых подписан userID, и их общее число.
+func (r *socialRepository) GetFollowing(userID uuid.UUID, limit, offset int) ([]models.User, int64, error) {
+	q := r.db.Table("users").
 		Joins("JOIN follows ON follows.followed_user_id = users.id").
-		Where("follows.user_id = ?", userID).
+		Where("follows.user_id = ? AND users.deleted_at IS NULL", userID)
+	return r.followPage(q, limit, offset)
+}
+
+// followPage считает и читает страницу списка подписок, новые первыми
+func (r *socialRepository) followPage(q *gorm.DB, limit, offset int) ([]models.User, int64, error) {
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var users []models.User
+	err := q.Select("users.*").
+		Order("follows.created_at DESC").
+		Limit(limit).Offset(offset).
 		Find(&users).Error
-	return users, err
+	return users, total, err
 }
 
 // IsFollowing проверяет, подписан ли userID на targetUserID.
@@ -71,7 +79,7 @@ func (r *socialRepository) GetUserPublicProfile(userID uuid.UUID) (*models.UserP
 	r.db.Model(&models.Follow{}).Where("user_id = ?", userID).Count(&followingCount)
 
 	var collections []models.Collection
-	r.db.Where("user_id = ?", userID).Find(&collections)
+	r.db.Where("user_id = ? AND is_public = ?", userID, true).Find(&collections)
 
 	var reviews []models.Review
 	r.db.Where("user_id = ?", userID).Preload("Book").Find(&reviews)
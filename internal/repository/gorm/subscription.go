@@ -51,3 +51,10 @@ func (r *subscriptionRepository) Update(subscription *models.Subscription) error
 func (r *subscriptionRepository) Delete(id uuid.UUID) error {
 	return r.db.Delete(&models.Subscription{}, "id = ?", id).Error
 }
+
+func (r *subscriptionRepository) GetExpiredActive(before time.Time, limit int) ([]models.Subscription, error) {
+	var subscriptions []models.Subscription
+	err := r.db.Where("status = ? AND end_date < ? AND auto_renew = ?", models.SubStatusActive, before, false).
+		Order("end_date ASC").Limit(limit).Find(&subscriptions).Error
+	return subscriptions, err
+}
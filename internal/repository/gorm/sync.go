@@ -0,0 +1,77 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"gorm.io/gorm"
+)
+
+type syncRepository struct {
+	db *gorm.DB
+}
+
+func NewSyncRepository(db *gorm.DB) *syncRepository {
+	return &syncRepository{db: db}
+}
+
+func (r *syncRepository) BookmarksSince(userID uuid.UUID, since time.Time) ([]models.Bookmark, error) {
+	var bookmarks []models.Bookmark
+	err := r.since(userID, since).Order("updated_at, id").Find(&bookmarks).Error
+	return bookmarks, err
+}
+
+func (r *syncRepository) AnnotationsSince(userID uuid.UUID, since time.Time) ([]models.Annotation, error) {
+	var annotations []models.Annotation
+	err := r.since(userID, since).Order("updated_at, id").Find(&annotations).Error
+	return annotations, err
+}
+
+func (r *syncRepository) PositionsSince(userID uuid.UUID, since time.Time) ([]models.ReadingPosition, error) {
+	var positions []models.ReadingPosition
+	err := r.since(userID, since).Order("updated_at, id").Find(&positions).Error
+	return positions, err
+}
+
+func (r *syncRepository) TombstonesSince(userID uuid.UUID, since time.Time) ([]models.SyncTombstone, error) {
+	var tombstones []models.SyncTombstone
+	q := r.db.Where("user_id = ?", userID)
+	if !since.IsZero() {
+		q = q.Where("deleted_at > ?", since)
+	}
+	err := q.Order("deleted_at").Find(&tombstones).Error
+	return tombstones, err
+}
+
+func (r *syncRepository) DeleteTombstonesBefore(before time.Time) (int64, error) {
+	res := r.db.Where("deleted_at < ?", before).Delete(&models.SyncTombstone{})
+	return res.RowsAffected, res.Error
+}
+
+func (r *syncRepository) since(userID uuid.UUID, since time.Time) *gorm.DB {
+	q := r.db.Where("user_id = ?", userID)
+	if !since.IsZero() {
+		q = q.Where("updated_at > ?", since)
+	}
+	return q
+}
+
+// deleteWithTombstone удаляет запись пользователя и оставляет след для
+// синхронизации в одной транзакции. Несуществующая запись — не ошибка.
+func deleteWithTombstone(db *gorm.DB, model interface{}, entity models.SyncEntity, id uuid.UUID) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var owner struct{ UserID uuid.UUID }
+		res := tx.Model(model).Select("user_id").Where("id = ?", id).Limit(1).Scan(&owner)
+		if res.Error != nil {
+			return res.Error
+		}
+		if err := tx.Delete(model, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		return tx.Create(&models.SyncTombstone{UserID: owner.UserID, EntityType: entity, EntityID: id}).Error
+	})
+}
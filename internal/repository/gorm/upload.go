@@ -0,0 +1,51 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"gorm.io/gorm"
+)
+
+type uploadRepository struct {
+	db *gorm.DB
+}
+
+func NewUploadRepository(db *gorm.DB) *uploadRepository {
+	return &uploadRepository{db: db}
+}
+
+func (r *uploadRepository) Create(upload *models.Upload) error {
+	return r.db.Create(upload).Error
+}
+
+func (r *uploadRepository) GetByID(id uuid.UUID) (*models.Upload, error) {
+	var upload models.Upload
+	if err := r.db.First(&upload, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (r *uploadRepository) AdvanceOffset(id uuid.UUID, from, to int64, expiresAt time.Time) (bool, error) {
+	res := r.db.Model(&models.Upload{}).
+		Where("id = ? AND status = ? AND upload_offset = ?", id, models.UploadStatusActive, from).
+		Updates(map[string]interface{}{"upload_offset": to, "expires_at": expiresAt, "updated_at": time.Now()})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *uploadRepository) Update(upload *models.Upload) error {
+	return r.db.Save(upload).Error
+}
+
+func (r *uploadRepository) Delete(id uuid.UUID) error {
+	return r.db.Delete(&models.Upload{}, "id = ?", id).Error
+}
+
+func (r *uploadRepository) ListExpired(before time.Time, limit int) ([]models.Upload, error) {
+	var uploads []models.Upload
+	err := r.db.Where("status = ? AND expires_at < ?", models.UploadStatusActive, before).
+		Order("expires_at").Limit(limit).Find(&uploads).Error
+	return uploads, err
+}
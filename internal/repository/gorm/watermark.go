@@ -0,0 +1,59 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"gorm.io/gorm"
+)
+
+type watermarkRepository struct {
+	db *gorm.DB
+}
+
+func NewWatermarkRepository(db *gorm.DB) *watermarkRepository {
+	return &watermarkRepository{db: db}
+}
+
+func (r *watermarkRepository) Create(mark *models.FileWatermark) error {
+	return r.db.Create(mark).Error
+}
+
+func (r *watermarkRepository) GetByID(id uuid.UUID) (*models.FileWatermark, error) {
+	var mark models.FileWatermark
+	if err := r.db.First(&mark, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &mark, nil
+}
+
+func (r *watermarkRepository) FindCached(userID, fileID uuid.UUID, now time.Time) (*models.FileWatermark, error) {
+	var mark models.FileWatermark
+	err := r.db.Where("user_id = ? AND file_id = ? AND cache_path IS NOT NULL AND cache_expires_at > ?", userID, fileID, now).
+		Order("created_at DESC").
+		First(&mark).Error
+	if err != nil {
+		return nil, err
+	}
+	return &mark, nil
+}
+
+func (r *watermarkRepository) SetCache(id uuid.UUID, cachePath string, expiresAt time.Time) error {
+	return r.db.Model(&models.FileWatermark{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"cache_path": cachePath, "cache_expires_at": expiresAt}).Error
+}
+
+func (r *watermarkRepository) ListExpiredCache(now time.Time, limit int) ([]models.FileWatermark, error) {
+	var marks []models.FileWatermark
+	err := r.db.Where("cache_path IS NOT NULL AND cache_expires_at <= ?", now).
+		Order("cache_expires_at").
+		Limit(limit).
+		Find(&marks).Error
+	return marks, err
+}
+
+func (r *watermarkRepository) ClearCache(id uuid.UUID) error {
+	return r.db.Model(&models.FileWatermark{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"cache_path": nil, "cache_expires_at": nil}).Error
+}
@@ -0,0 +1,119 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"gorm.io/gorm"
+)
+
+type webhookRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepository(db *gorm.DB) *webhookRepository {
+	return &webhookRepository{db: db}
+}
+
+func (r *webhookRepository) Create(hook *models.Webhook) error {
+	return r.db.Create(hook).Error
+}
+
+func (r *webhookRepository) GetByID(id uuid.UUID) (*models.Webhook, error) {
+	var hook models.Webhook
+	err := r.db.First(&hook, "id = ?", id).Error
+	return &hook, err
+}
+
+func (r *webhookRepository) GetByUserID(userID uuid.UUID) ([]models.Webhook, error) {
+	var hooks []models.Webhook
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&hooks).Error
+	return hooks, err
+}
+
+func (r *webhookRepository) GetByAPIKeyID(apiKeyID uuid.UUID) ([]models.Webhook, error) {
+	var hooks []models.Webhook
+	err := r.db.Where("api_key_id = ?", apiKeyID).Order("created_at DESC").Find(&hooks).Error
+	return hooks, err
+}
+
+func (r *webhookRepository) GetActive() ([]models.Webhook, error) {
+	var hooks []models.Webhook
+	err := r.db.Where("is_active = ?", true).Find(&hooks).Error
+	return hooks, err
+}
+
+func (r *webhookRepository) Update(hook *models.Webhook) error {
+	return r.db.Save(hook).Error
+}
+
+// Delete удаляет вебхук вместе с журналом доставок.
+func (r *webhookRepository) Delete(id uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", id).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Webhook{}, "id = ?", id).Error
+	})
+}
+
+func (r *webhookRepository) RecordSuccess(id uuid.UUID) error {
+	return r.db.Model(&models.Webhook{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"consecutive_failures": 0,
+			"last_delivery_at":     time.Now(),
+		}).Error
+}
+
+func (r *webhookRepository) RecordFailure(id uuid.UUID, disableAfter int) (bool, error) {
+	now := time.Now()
+	err := r.db.Model(&models.Webhook{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"consecutive_failures": gorm.Expr("consecutive_failures + 1"),
+			"last_delivery_at":     now,
+		}).Error
+	if err != nil {
+		return false, err
+	}
+
+	// Отключаем одним условным UPDATE, чтобы параллельные воркеры не гонялись
+	res := r.db.Model(&models.Webhook{}).
+		Where("id = ? AND is_active = ? AND consecutive_failures >= ?", id, true, disableAfter).
+		Updates(map[string]interface{}{
+			"is_active":   false,
+			"disabled_at": now,
+		})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *webhookRepository) CreateDelivery(delivery *models.WebhookDelivery) error {
+	return r.db.Create(delivery).Error
+}
+
+func (r *webhookRepository) GetDeliveryByID(id uuid.UUID) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := r.db.First(&delivery, "id = ?", id).Error
+	return &delivery, err
+}
+
+func (r *webhookRepository) GetDeliveries(webhookID uuid.UUID, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := r.db.Where("webhook_id = ?", webhookID).
+		Order("created_at DESC").
+		Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (r *webhookRepository) GetPendingDeliveries() ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := r.db.Where("status = ?", models.DeliveryStatusPending).
+		Order("created_at").Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (r *webhookRepository) UpdateDelivery(delivery *models.WebhookDelivery) error {
+	return r.db.Save(delivery).Error
+}
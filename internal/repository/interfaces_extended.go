@@ -1,7 +1,11 @@
 package repository
 
 import (
+	"errors"
+	"time"
+
 	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/events"
 	"github.com/oneErrortime/afst/internal/models"
 )
 
@@ -26,6 +30,8 @@ type CategoryRepository interface {
 	Update(category *models.Category) error
 	Delete(id uuid.UUID) error
 	Count() (int64, error)
+	// GetByBookIDs возвращает категории каждой из книг
+	GetByBookIDs(bookIDs []uuid.UUID) (map[uuid.UUID][]models.Category, error)
 }
 
 type SubscriptionRepository interface {
@@ -36,6 +42,8 @@ type SubscriptionRepository interface {
 	GetAll(limit, offset int) ([]models.Subscription, error)
 	Update(subscription *models.Subscription) error
 	Delete(id uuid.UUID) error
+	// GetExpiredActive — активные подписки без автопродления, срок которых истёк до before
+	GetExpiredActive(before time.Time, limit int) ([]models.Subscription, error)
 }
 
 type BookAccessRepository interface {
@@ -48,6 +56,12 @@ type BookAccessRepository interface {
 	Update(access *models.BookAccess) error
 	Delete(id uuid.UUID) error
 	CountActiveByUser(userID uuid.UUID) (int64, error)
+	// ExpireEndedBefore переводит в expired активные доступы, закончившиеся до before
+	ExpireEndedBefore(before time.Time) (int64, error)
+	// LastUpdatedByUser — время последнего изменения выдач пользователя, нулевое — выдач нет
+	LastUpdatedByUser(userID uuid.UUID) (time.Time, error)
+	// CountFinishedByUsers — сколько разных книг каждый пользователь дочитал в [from, to)
+	CountFinishedByUsers(userIDs []uuid.UUID, from, to time.Time) (map[uuid.UUID]int64, error)
 }
 
 type BookFileRepository interface {
@@ -55,10 +69,20 @@ type BookFileRepository interface {
 	GetByID(id uuid.UUID) (*models.BookFile, error)
 	GetByBookID(bookID uuid.UUID) ([]models.BookFile, error)
 	GetByHash(hash string) (*models.BookFile, error)
+	// GetByBookAndHash находит файл книги с тем же содержимым
+	GetByBookAndHash(bookID uuid.UUID, hash string) (*models.BookFile, error)
+	// GetDerived возвращает файлы, сконвертированные из sourceID
+	GetDerived(sourceID uuid.UUID) ([]models.BookFile, error)
+	// ListHashes возвращает SHA-256 всех файлов по пути в хранилище — для сверки при переносе
+	ListHashes() (map[string]string, error)
 	Update(file *models.BookFile) error
 	Delete(id uuid.UUID) error
 }
 
+// ErrBlobCollecting — файл, на который ссылается новая запись BookFile,
+// прямо сейчас удаляет сборщик мусора; запись можно создать чуть позже.
+var ErrBlobCollecting = errors.New("файл удаляется сборщиком мусора")
+
 type ReadingSessionRepository interface {
 	Create(session *models.ReadingSession) error
 	GetByID(id uuid.UUID) (*models.ReadingSession, error)
@@ -67,13 +91,22 @@ type ReadingSessionRepository interface {
 	GetActiveByUserAndBook(userID, bookID uuid.UUID) (*models.ReadingSession, error)
 	Update(session *models.ReadingSession) error
 	GetBookStats(bookID uuid.UUID) (totalReaders, totalSessions, totalReadTime int64, err error)
+	// GetEndedByUser возвращает завершённые сессии пользователя, старые первыми
+	GetEndedByUser(userID uuid.UUID) ([]models.ReadingSession, error)
+	// LastUpdatedByUser — время последнего изменения сессий пользователя, нулевое — сессий нет
+	LastUpdatedByUser(userID uuid.UUID) (time.Time, error)
+	// CloseIdle завершает активные сессии без активности с before; сессия
+	// заканчивается в момент последней активности
+	CloseIdle(before time.Time) (int64, error)
+	// TotalsByUsers — время и страницы завершённых сессий, начатых в [from, to)
+	TotalsByUsers(userIDs []uuid.UUID, from, to time.Time) (map[uuid.UUID]models.ReadingTotals, error)
 }
 
 type SocialRepository interface {
 	Follow(userID, targetUserID uuid.UUID) error
 	Unfollow(userID, targetUserID uuid.UUID) error
-	GetFollowers(targetUserID uuid.UUID) ([]models.User, error)
-	GetFollowing(userID uuid.UUID) ([]models.User, error)
+	GetFollowers(targetUserID uuid.UUID, limit, offset int) ([]models.User, int64, error)
+	GetFollowing(userID uuid.UUID, limit, offset int) ([]models.User, int64, error)
 	IsFollowing(userID, targetUserID uuid.UUID) (bool, error)
 	GetUserPublicProfile(userID uuid.UUID) (*models.UserPublicProfileDTO, error)
 }
@@ -91,6 +124,22 @@ type ExtendedRepository struct {
 	Review         ReviewRepository
 	Bookmark       BookmarkRepository
 	APIKey         APIKeyRepository
+	Webhook        WebhookRepository
+	Outbox         OutboxRepository
+	Job            JobRepository
+	Scheduler      SchedulerRepository
+	BookCover      BookCoverRepository
+	BookText       BookTextRepository
+	Upload         UploadRepository
+	Blob           BlobRepository
+	Download       DownloadRepository
+	Watermark      WatermarkRepository
+	Position       ReadingPositionRepository
+	Annotation     AnnotationRepository
+	Goal           GoalRepository
+	Challenge      ChallengeRepository
+	Sync           SyncRepository
+	Activity       ActivityRepository
 	DB             interface{}
 }
 
@@ -111,3 +160,256 @@ type APIKeyRepository interface {
 	GetUsageLogs(apiKeyID uuid.UUID, limit int) ([]models.APIUsageLog, error)
 	GetUsageStats(apiKeyID uuid.UUID) (*models.APIUsageStatsDTO, error)
 }
+
+// WebhookRepository — подписки на вебхуки и журнал доставок.
+type WebhookRepository interface {
+	Create(hook *models.Webhook) error
+	GetByID(id uuid.UUID) (*models.Webhook, error)
+	GetByUserID(userID uuid.UUID) ([]models.Webhook, error)
+	GetByAPIKeyID(apiKeyID uuid.UUID) ([]models.Webhook, error)
+	GetActive() ([]models.Webhook, error)
+	Update(hook *models.Webhook) error
+	Delete(id uuid.UUID) error
+
+	// RecordSuccess сбрасывает счётчик ошибок подряд.
+	RecordSuccess(id uuid.UUID) error
+	// RecordFailure увеличивает счётчик ошибок и отключает вебхук при достижении порога.
+	// Возвращает true, если вебхук был отключён этим вызовом.
+	RecordFailure(id uuid.UUID, disableAfter int) (bool, error)
+
+	CreateDelivery(delivery *models.WebhookDelivery) error
+	GetDeliveryByID(id uuid.UUID) (*models.WebhookDelivery, error)
+	GetDeliveries(webhookID uuid.UUID, limit int) ([]models.WebhookDelivery, error)
+	// GetPendingDeliveries возвращает незавершённые доставки, старые первыми.
+	GetPendingDeliveries() ([]models.WebhookDelivery, error)
+	UpdateDelivery(delivery *models.WebhookDelivery) error
+}
+
+// OutboxRepository — транзакционный outbox событий шины.
+type OutboxRepository interface {
+	// Enqueue сохраняет событие; вызывается внутри транзакции вместе с изменением данных.
+	Enqueue(event events.Event) error
+	// GetPending возвращает неопубликованные события в порядке записи.
+	GetPending(limit int) ([]models.OutboxEvent, error)
+	// Claim берёт в аренду до limit неопубликованных событий, не занятых другим
+	// релеем, и возвращает их в порядке записи.
+	Claim(owner string, limit int, lease time.Duration) ([]models.OutboxEvent, error)
+	MarkPublished(ids []uuid.UUID) error
+	// DeletePublishedBefore удаляет опубликованные события старше before.
+	DeletePublishedBefore(before time.Time) (int64, error)
+}
+
+// JobRepository — персистентная очередь фоновых задач с арендой.
+type JobRepository interface {
+	Enqueue(job *models.Job) error
+	// EnqueueOnce сохраняет задачу, если задачи с таким ID ещё нет; false — уже есть.
+	EnqueueOnce(job *models.Job) (bool, error)
+	GetByID(id uuid.UUID) (*models.Job, error)
+	List(filter models.JobFilter) ([]models.Job, int64, error)
+
+	// Claim берёт в аренду одну готовую задачу очереди (pending/failed с наступившим RunAt
+	// или running с истёкшей арендой). Возвращает nil, если брать нечего.
+	Claim(queue, owner string, lease time.Duration) (*models.Job, error)
+	// ExtendLease продлевает аренду; false — аренда потеряна (задачу отменили или перехватили).
+	ExtendLease(id uuid.UUID, owner string, until time.Time) (bool, error)
+	Complete(id uuid.UUID, owner string) (bool, error)
+	// Fail фиксирует неудачную попытку: nextRunAt == nil переводит задачу в dead.
+	Fail(id uuid.UUID, owner, errMsg string, nextRunAt *time.Time) (bool, error)
+	// ReapExpired переводит в dead задачи с истёкшей арендой и исчерпанными попытками.
+	ReapExpired(queue string) (int64, error)
+
+	Retry(id uuid.UUID) error
+	Cancel(id uuid.UUID) error
+	Stats(since time.Time) ([]models.JobQueueStatsDTO, error)
+	// DeleteFinishedBefore удаляет succeeded и cancelled задачи; dead остаются для разбора.
+	DeleteFinishedBefore(before time.Time) (int64, error)
+}
+
+// SchedulerRepository — состояние и история периодических задач планировщика.
+type SchedulerRepository interface {
+	// Register создаёт задачу или обновляет расписание, если оно изменилось.
+	Register(name, schedule string, nextRunAt time.Time) error
+	// Acquire берёт аренду задачи, если подошло время запуска или запрошен ручной запуск.
+	// Возвращает nil, если задачу запускать не нужно или её уже выполняет другой инстанс.
+	Acquire(name, owner string, lease time.Duration) (*models.ScheduledTask, error)
+	ExtendLease(name, owner string, until time.Time) (bool, error)
+	StartRun(run *models.ScheduledTaskRun) error
+	// Finish сохраняет результат запуска, снимает аренду и назначает следующий запуск.
+	Finish(name, owner string, run *models.ScheduledTaskRun, nextRunAt time.Time) error
+	RequestRun(name string) error
+
+	List() ([]models.ScheduledTask, error)
+	Get(name string) (*models.ScheduledTask, error)
+	ListRuns(name string, limit int) ([]models.ScheduledTaskRun, error)
+	DeleteRunsBefore(before time.Time) (int64, error)
+}
+
+// BookCoverRepository — обложки книг.
+type BookCoverRepository interface {
+	Get(bookID uuid.UUID) (*models.BookCover, error)
+	// Save создаёт или заменяет обложку книги.
+	Save(cover *models.BookCover) error
+	// MarkThumbnailsReady отмечает готовность миниатюр, только если обложку
+	// за это время не заменили (hash совпадает).
+	MarkThumbnailsReady(bookID uuid.UUID, hash string) (bool, error)
+	Delete(bookID uuid.UUID) error
+}
+
+// Границы совпадения в сниппетах поиска. Управляющие символы не встречаются
+// в извлечённом тексте и не являются разметкой: HTML из них делает сервис
+// поиска после экранирования текста.
+const (
+	SnippetMatchStart = "\x02"
+	SnippetMatchEnd   = "\x03"
+)
+
+// BookTextRepository — полнотекстовый индекс содержимого книг (SQLite FTS5).
+// match — готовое выражение FTS5, его собирает сервис поиска.
+type BookTextRepository interface {
+	// ReplaceFile заменяет все фрагменты файла одной транзакцией.
+	ReplaceFile(index *models.BookTextIndex, chunks []models.BookTextChunk) error
+	DeleteFile(fileID uuid.UUID) error
+	GetIndexes(bookID uuid.UUID) ([]models.BookTextIndex, error)
+	// Search возвращает сниппеты сырым текстом книги: совпадения обрамлены
+	// SnippetMatchStart и SnippetMatchEnd, а не разметкой
+	Search(bookID uuid.UUID, match string, limit, offset int) ([]models.BookSearchHitDTO, int64, error)
+	// SearchLibrary ранжирует книги по плотности совпадений.
+	SearchLibrary(match string, limit int) ([]models.LibrarySearchHitDTO, error)
+}
+
+// UploadRepository — возобновляемые загрузки файлов.
+type UploadRepository interface {
+	Create(upload *models.Upload) error
+	GetByID(id uuid.UUID) (*models.Upload, error)
+	// AdvanceOffset переносит смещение from → to и продлевает срок жизни,
+	// только если загрузка активна и смещение не изменилось.
+	AdvanceOffset(id uuid.UUID, from, to int64, expiresAt time.Time) (bool, error)
+	Update(upload *models.Upload) error
+	Delete(id uuid.UUID) error
+	// ListExpired возвращает незавершённые загрузки с истёкшим сроком.
+	ListExpired(before time.Time, limit int) ([]models.Upload, error)
+}
+
+// BlobRepository — учёт файлов книг в хранилище. Ссылки добавляет и снимает
+// BookFileRepository при создании и удалении записей, здесь — сборка мусора.
+type BlobRepository interface {
+	GetByPath(filePath string) (*models.Blob, error)
+	// ListUnreferenced возвращает файлы без ссылок, не менявшиеся с before.
+	ListUnreferenced(before time.Time, limit int) ([]models.Blob, error)
+	// Claim помечает файл без ссылок на удаление. false — на файл успели
+	// сослаться, удалять нельзя.
+	Claim(filePath string, before time.Time) (bool, error)
+	// Unclaim снимает пометку, если удалить файл не удалось.
+	Unclaim(filePath string) error
+	// Remove удаляет запись помеченного файла.
+	Remove(filePath string) error
+	// ListHashes возвращает SHA-256 всех учтённых файлов по пути.
+	ListHashes() (map[string]string, error)
+	// Reconcile пересчитывает ссылки по book_files, возвращает число исправлений.
+	Reconcile() (int64, error)
+}
+
+// DownloadRepository — учёт скачиваний файлов книг для квот.
+type DownloadRepository interface {
+	// Record учитывает скачивание по ссылке и увеличивает счётчик книги, если
+	// у пользователя с since меньше limit скачиваний (limit < 0 — без ограничений).
+	// false — скачивание по этой ссылке уже учтено.
+	Record(download *models.BookDownload, since time.Time, limit int) (bool, error)
+	CountByUserSince(userID uuid.UUID, since time.Time) (int64, error)
+}
+
+// ErrDownloadLimitReached — квота скачиваний за период исчерпана.
+var ErrDownloadLimitReached = errors.New("превышен лимит скачиваний")
+
+// WatermarkRepository — выданные помеченные копии файлов и их кэш.
+type WatermarkRepository interface {
+	Create(mark *models.FileWatermark) error
+	GetByID(id uuid.UUID) (*models.FileWatermark, error)
+	// FindCached возвращает последнюю копию пользователя, кэш которой ещё действует
+	FindCached(userID, fileID uuid.UUID, now time.Time) (*models.FileWatermark, error)
+	SetCache(id uuid.UUID, cachePath string, expiresAt time.Time) error
+	ListExpiredCache(now time.Time, limit int) ([]models.FileWatermark, error)
+	ClearCache(id uuid.UUID) error
+}
+
+// ReadingPositionRepository — позиции чтения по устройствам.
+type ReadingPositionRepository interface {
+	// Save записывает позицию устройства, если она новее сохранённой
+	// (по ClientUpdatedAt); false — позиция устарела и не записана
+	Save(pos *models.ReadingPosition) (bool, error)
+	GetByDevice(userID, bookID uuid.UUID, deviceID string) (*models.ReadingPosition, error)
+	// ListByBook возвращает позиции всех устройств, новые первыми
+	ListByBook(userID, bookID uuid.UUID) ([]models.ReadingPosition, error)
+}
+
+// AnnotationRepository — выделения и заметки пользователей.
+type AnnotationRepository interface {
+	Create(annotation *models.Annotation) error
+	GetByID(id uuid.UUID) (*models.Annotation, error)
+	Update(annotation *models.Annotation) error
+	Delete(id uuid.UUID) error
+	// ListVisible возвращает аннотации по фильтру, которые видит viewerID:
+	// свои, публичные и «для подписчиков» тех, на кого он подписан, и их
+	// общее число без учёта Limit/Offset
+	ListVisible(viewerID uuid.UUID, filter models.AnnotationFilter) ([]models.Annotation, int64, error)
+}
+
+// GoalRepository — личные цели чтения.
+type GoalRepository interface {
+	Create(goal *models.ReadingGoal) error
+	GetByID(id uuid.UUID) (*models.ReadingGoal, error)
+	GetByUserID(userID uuid.UUID) ([]models.ReadingGoal, error)
+	ListActive() ([]models.ReadingGoal, error)
+	Update(goal *models.ReadingGoal) error
+	Delete(id uuid.UUID) error
+	// MarkReached и MarkWarned запоминают период, за который отправлено
+	// событие; false — событие за этот период уже отправлено
+	MarkReached(id uuid.UUID, period string) (bool, error)
+	MarkWarned(id uuid.UUID, period string) (bool, error)
+}
+
+// ChallengeRepository — челленджи и их участники.
+type ChallengeRepository interface {
+	Create(challenge *models.ReadingChallenge) error
+	GetByID(id uuid.UUID) (*models.ReadingChallenge, error)
+	List() ([]models.ReadingChallenge, error)
+	// ListRunning возвращает челленджи, идущие в момент now
+	ListRunning(now time.Time) ([]models.ReadingChallenge, error)
+	Update(challenge *models.ReadingChallenge) error
+	Delete(id uuid.UUID) error
+
+	AddParticipant(participant *models.ChallengeParticipant) error
+	RemoveParticipant(challengeID, userID uuid.UUID) error
+	GetParticipant(challengeID, userID uuid.UUID) (*models.ChallengeParticipant, error)
+	// ListParticipants возвращает участников вместе с пользователями
+	ListParticipants(challengeID uuid.UUID) ([]models.ChallengeParticipant, error)
+	CountParticipants(challengeIDs []uuid.UUID) (map[uuid.UUID]int64, error)
+	// MarkCompleted отмечает, что участник набрал цель; false — уже отмечено
+	MarkCompleted(participantID uuid.UUID, at time.Time) (bool, error)
+}
+
+// SyncRepository — выборки для синхронизации офлайн-устройств. Нулевое since
+// означает «все записи пользователя».
+type SyncRepository interface {
+	BookmarksSince(userID uuid.UUID, since time.Time) ([]models.Bookmark, error)
+	AnnotationsSince(userID uuid.UUID, since time.Time) ([]models.Annotation, error)
+	PositionsSince(userID uuid.UUID, since time.Time) ([]models.ReadingPosition, error)
+	// TombstonesSince — удалённые закладки и аннотации; следы пишут Delete
+	// репозиториев закладок и аннотаций
+	TombstonesSince(userID uuid.UUID, since time.Time) ([]models.SyncTombstone, error)
+	DeleteTombstonesBefore(before time.Time) (int64, error)
+}
+
+// ActivityRepository — лента активности. Выборки для подписчиков пропускают
+// виды событий, скрытые автором в ActivityPreference.
+type ActivityRepository interface {
+	// Save записывает событие; повтор того же субъекта обновляет только Title
+	Save(activity *models.Activity) error
+	Delete(userID uuid.UUID, activityType models.ActivityType, subjectID uuid.UUID) error
+	// ListFeed — события тех, на кого подписан userID, новые первыми
+	ListFeed(userID uuid.UUID, limit, offset int) ([]models.Activity, int64, error)
+	// ListByUser — события userID; sharedOnly — только видимые подписчикам
+	ListByUser(userID uuid.UUID, sharedOnly bool, limit, offset int) ([]models.Activity, int64, error)
+	GetPreferences(userID uuid.UUID) ([]models.ActivityPreference, error)
+	SavePreferences(prefs []models.ActivityPreference) error
+}
@@ -0,0 +1,127 @@
+package scanner
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamChunkSize is the size of one INSTREAM chunk; clamd's StreamMaxLength
+// applies to the total, not to chunks
+const clamChunkSize = 64 << 10
+
+// ClamAV talks to clamd over its socket protocol: null-terminated z-commands
+// and INSTREAM with length-prefixed chunks, so the daemon does not need
+// access to the file system of the server.
+type ClamAV struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamAV creates a client for clamd at addr: "tcp://host:port",
+// "unix:///path/to/clamd.ctl" or a bare "host:port". timeout bounds a whole
+// command including the scan itself.
+func NewClamAV(addr string, timeout time.Duration) *ClamAV {
+	network, address := "tcp", addr
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		network, address = "unix", strings.TrimPrefix(addr, "unix://")
+	case strings.HasPrefix(addr, "tcp://"):
+		address = strings.TrimPrefix(addr, "tcp://")
+	}
+	return &ClamAV{network: network, address: address, timeout: timeout}
+}
+
+// Ping checks that clamd answers
+func (c *ClamAV) Ping() error {
+	reply, err := c.command("zPING\x00", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("%w: unexpected reply %q", ErrUnavailable, reply)
+	}
+	return nil
+}
+
+// Scan streams r to clamd. A stream over the daemon's size limit is an
+// error, not a clean result.
+func (c *ClamAV) Scan(r io.Reader) (*Result, error) {
+	reply, err := c.command("zINSTREAM\x00", r)
+	if err != nil {
+		return nil, err
+	}
+	return parseClamReply(reply)
+}
+
+func (c *ClamAV) command(cmd string, body io.Reader) (string, error) {
+	conn, err := net.DialTimeout(c.network, c.address, c.timeout)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer func() { _ = conn.Close() }()
+	if c.timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+
+	writeErr := writeClamCommand(conn, cmd, body)
+	// clamd answers and closes the connection when the stream is too long,
+	// so the reply explains a failed write better than the write error
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		if writeErr != nil {
+			err = writeErr
+		}
+		return "", fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return strings.TrimSpace(strings.TrimRight(reply, "\x00")), nil
+}
+
+func writeClamCommand(w io.Writer, cmd string, body io.Reader) error {
+	if _, err := io.WriteString(w, cmd); err != nil {
+		return err
+	}
+	if body == nil {
+		return nil
+	}
+	buf := make([]byte, 4+clamChunkSize)
+	for {
+		n, err := io.ReadFull(body, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, werr := w.Write(buf[:4+n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// parseClamReply reads "stream: OK", "stream: <name> FOUND" or "<msg> ERROR"
+func parseClamReply(reply string) (*Result, error) {
+	msg := reply
+	if i := strings.Index(reply, ": "); i >= 0 {
+		msg = reply[i+2:]
+	}
+	switch {
+	case msg == "OK":
+		return &Result{}, nil
+	case strings.HasSuffix(msg, " FOUND"):
+		return &Result{Infected: true, Signature: strings.TrimSuffix(msg, " FOUND")}, nil
+	case strings.HasSuffix(msg, " ERROR"):
+		return nil, fmt.Errorf("%w: %s", ErrUnavailable, strings.TrimSuffix(msg, " ERROR"))
+	}
+	return nil, fmt.Errorf("%w: unexpected reply %q", ErrUnavailable, reply)
+}
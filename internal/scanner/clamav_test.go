@@ -0,0 +1,118 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd answers PING and INSTREAM like clamd; streams longer than
+// maxStream get the size-limit error
+func fakeClamd(t *testing.T, maxStream int) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveClamd(conn, maxStream)
+		}
+	}()
+	return "tcp://" + ln.Addr().String()
+}
+
+func serveClamd(conn net.Conn, maxStream int) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	cmd, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	switch cmd {
+	case "zPING\x00":
+		_, _ = conn.Write([]byte("PONG\x00"))
+	case "zINSTREAM\x00":
+		var data bytes.Buffer
+		for {
+			var size [4]byte
+			if _, err := io.ReadFull(r, size[:]); err != nil {
+				return
+			}
+			n := binary.BigEndian.Uint32(size[:])
+			if n == 0 {
+				break
+			}
+			if data.Len()+int(n) > maxStream {
+				_, _ = conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+				return
+			}
+			if _, err := io.CopyN(&data, r, int64(n)); err != nil {
+				return
+			}
+		}
+		if bytes.Contains(data.Bytes(), []byte(eicar)) {
+			_, _ = conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+			return
+		}
+		_, _ = conn.Write([]byte("stream: OK\x00"))
+	default:
+		_, _ = conn.Write([]byte("UNKNOWN COMMAND\x00"))
+	}
+}
+
+func TestClamAV_Scan(t *testing.T) {
+	clam := NewClamAV(fakeClamd(t, 1<<20), 5*time.Second)
+	require.NoError(t, clam.Ping())
+
+	res, err := clam.Scan(strings.NewReader("%PDF-1.4 clean book"))
+	require.NoError(t, err)
+	assert.False(t, res.Infected)
+
+	// Сигнатура на границе фрагментов тоже находится
+	infected := strings.Repeat("a", clamChunkSize-10) + eicar
+	res, err = clam.Scan(strings.NewReader(infected))
+	require.NoError(t, err)
+	assert.True(t, res.Infected)
+	assert.Equal(t, "Eicar-Test-Signature", res.Signature)
+}
+
+func TestClamAV_Errors(t *testing.T) {
+	clam := NewClamAV(fakeClamd(t, 1000), 5*time.Second)
+	_, err := clam.Scan(bytes.NewReader(make([]byte, 3*clamChunkSize)))
+	assert.ErrorIs(t, err, ErrUnavailable, "a stream over the limit is not clean")
+	assert.Contains(t, err.Error(), "size limit exceeded")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+	_, err = NewClamAV(addr, time.Second).Scan(strings.NewReader("x"))
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
+
+func TestParseClamReply(t *testing.T) {
+	res, err := parseClamReply("stream: OK")
+	require.NoError(t, err)
+	assert.False(t, res.Infected)
+
+	res, err = parseClamReply("stream: Win.Test.EICAR_HDB-1 FOUND")
+	require.NoError(t, err)
+	assert.Equal(t, "Win.Test.EICAR_HDB-1", res.Signature)
+
+	_, err = parseClamReply("garbage")
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
@@ -0,0 +1,22 @@
+package scanner
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrUnavailable means the scan could not be run; callers should treat the
+// file as unchecked rather than clean
+var ErrUnavailable = errors.New("malware scanner unavailable")
+
+// Result is the verdict on one stream
+type Result struct {
+	Infected bool
+	// Signature names what was found; empty for clean files
+	Signature string
+}
+
+// Scanner checks uploaded content for malware before it is stored
+type Scanner interface {
+	Scan(r io.Reader) (*Result, error)
+}
@@ -0,0 +1,340 @@
+// Package scheduler runs named recurring tasks on cron schedules.
+//
+// Task state lives in the scheduled_tasks table shared by all instances:
+// whoever first takes the lease after next_run_at executes the task, so each
+// tick runs exactly once across the cluster. The lease is renewed while the
+// task runs; if the instance dies, another one picks the task up once the
+// lease expires.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	defaultTick    = 5 * time.Second
+	defaultLease   = time.Minute
+	defaultTimeout = 30 * time.Minute
+
+	// runRetention is how long run history is kept
+	runRetention = 30 * 24 * time.Hour
+)
+
+// ErrUnknownTask is returned for tasks not registered in this process
+var ErrUnknownTask = errors.New("unknown scheduled task")
+
+// specParser accepts standard 5-field cron expressions and descriptors
+// such as @hourly, @daily or @every 10m
+var specParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Task is a named recurring job
+type Task struct {
+	Name string
+	// Spec is a cron expression ("*/5 * * * *") or descriptor ("@daily", "@every 1h")
+	Spec string
+	// Jitter adds a random delay up to this value to every scheduled run,
+	// spreading load when many tasks share the same schedule
+	Jitter time.Duration
+	// Timeout bounds a single run (default 30m)
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+type task struct {
+	Task
+	schedule cron.Schedule
+}
+
+// Scheduler executes registered tasks on their schedules
+type Scheduler struct {
+	repo  repository.SchedulerRepository
+	owner string
+	tick  time.Duration
+	lease time.Duration
+
+	mu      sync.Mutex
+	tasks   map[string]*task
+	running map[string]bool
+	started bool
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a scheduler; register tasks before calling Start
+func New(repo repository.SchedulerRepository) *Scheduler {
+	host, _ := os.Hostname()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		repo:    repo,
+		owner:   fmt.Sprintf("%s/%s", host, uuid.NewString()),
+		tick:    defaultTick,
+		lease:   defaultLease,
+		tasks:   make(map[string]*task),
+		running: make(map[string]bool),
+		wake:    make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Register adds a task. It fails on an invalid spec or a duplicate name.
+func (s *Scheduler) Register(t Task) error {
+	schedule, err := specParser.Parse(t.Spec)
+	if err != nil {
+		return fmt.Errorf("task %s: invalid schedule %q: %w", t.Name, t.Spec, err)
+	}
+	if t.Timeout <= 0 {
+		t.Timeout = defaultTimeout
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tasks[t.Name]; ok {
+		return fmt.Errorf("task %s already registered", t.Name)
+	}
+	s.tasks[t.Name] = &task{Task: t, schedule: schedule}
+	return nil
+}
+
+// Start stores the registered tasks and begins the scheduling loop
+func (s *Scheduler) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return nil
+	}
+
+	for _, t := range s.tasks {
+		if err := s.repo.Register(t.Name, t.Spec, s.next(t, time.Now())); err != nil {
+			return fmt.Errorf("register task %s: %w", t.Name, err)
+		}
+	}
+	s.started = true
+
+	s.wg.Add(1)
+	go s.loop()
+	log.Printf("[scheduler] started with %d tasks", len(s.tasks))
+	return nil
+}
+
+// RunNow requests an immediate out-of-schedule run. The run happens on
+// whichever instance acquires the task first.
+func (s *Scheduler) RunNow(name string) error {
+	if !s.Registered(name) {
+		return ErrUnknownTask
+	}
+	if err := s.repo.RequestRun(name); err != nil {
+		return err
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Registered reports whether name is a task known to this process
+func (s *Scheduler) Registered(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.tasks[name]
+	return ok
+}
+
+// Running reports whether name is currently executing in this process
+func (s *Scheduler) Running(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running[name]
+}
+
+// Names returns registered task names in sorted order
+func (s *Scheduler) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.tasks))
+	for name := range s.tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Shutdown stops scheduling and waits for running tasks to finish
+func (s *Scheduler) Shutdown(timeout time.Duration) {
+	log.Println("[scheduler] shutting down...")
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("[scheduler] clean shutdown")
+	case <-time.After(timeout):
+		log.Printf("[scheduler] shutdown timed out after %v", timeout)
+	}
+}
+
+func (s *Scheduler) loop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+	lastCleanup := time.Now()
+
+	for {
+		s.dispatch()
+
+		if time.Since(lastCleanup) >= time.Hour {
+			lastCleanup = time.Now()
+			if _, err := s.repo.DeleteRunsBefore(time.Now().Add(-runRetention)); err != nil {
+				log.Printf("[scheduler] history cleanup error: %v", err)
+			}
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// dispatch starts every due task that this instance manages to acquire
+func (s *Scheduler) dispatch() {
+	s.mu.Lock()
+	var idle []*task
+	for name, t := range s.tasks {
+		if !s.running[name] {
+			idle = append(idle, t)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, t := range idle {
+		state, err := s.repo.Acquire(t.Name, s.owner, s.lease)
+		if err != nil {
+			log.Printf("[scheduler] acquire %s: %v", t.Name, err)
+			continue
+		}
+		if state == nil {
+			continue
+		}
+
+		s.mu.Lock()
+		s.running[t.Name] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func(t *task, manual bool) {
+			defer s.wg.Done()
+			s.execute(t, manual)
+			s.mu.Lock()
+			delete(s.running, t.Name)
+			s.mu.Unlock()
+		}(t, state.RunRequested)
+	}
+}
+
+func (s *Scheduler) execute(t *task, manual bool) {
+	run := &models.ScheduledTaskRun{
+		TaskName:  t.Name,
+		Owner:     s.owner,
+		Manual:    manual,
+		Status:    models.ScheduledRunRunning,
+		StartedAt: time.Now(),
+	}
+	if err := s.repo.StartRun(run); err != nil {
+		log.Printf("[scheduler] %s: failed to record run: %v", t.Name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, t.Timeout)
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		s.heartbeat(ctx, cancel, t.Name)
+	}()
+
+	err := safeRun(ctx, t.Run)
+	cancel()
+	<-heartbeatDone
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.DurationMs = finished.Sub(run.StartedAt).Milliseconds()
+	run.Status = models.ScheduledRunSucceeded
+	if err != nil {
+		msg := err.Error()
+		run.Status = models.ScheduledRunFailed
+		run.Error = &msg
+		log.Printf("[scheduler] %s failed after %dms: %v", t.Name, run.DurationMs, err)
+	}
+
+	if err := s.repo.Finish(t.Name, s.owner, run, s.next(t, finished)); err != nil {
+		log.Printf("[scheduler] %s: failed to record result: %v", t.Name, err)
+	}
+}
+
+// heartbeat renews the task lease; losing it cancels the run
+func (s *Scheduler) heartbeat(ctx context.Context, cancel context.CancelFunc, name string) {
+	ticker := time.NewTicker(s.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ok, err := s.repo.ExtendLease(name, s.owner, time.Now().Add(s.lease))
+		if err != nil {
+			log.Printf("[scheduler] %s: lease renewal failed: %v", name, err)
+			continue
+		}
+		if !ok {
+			log.Printf("[scheduler] %s: lease lost, stopping", name)
+			cancel()
+			return
+		}
+	}
+}
+
+func (s *Scheduler) next(t *task, from time.Time) time.Time {
+	next := t.schedule.Next(from)
+	if t.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(t.Jitter))))
+	}
+	return next
+}
+
+// safeRun turns a panic in a task into an error so one bad task
+// cannot take the scheduler down
+func safeRun(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[scheduler] panic: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
@@ -0,0 +1,185 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestRepo(t *testing.T) (*gormdb.DB, repository.SchedulerRepository) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	// every connection to :memory: is a separate database
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	require.NoError(t, db.AutoMigrate(&models.ScheduledTask{}, &models.ScheduledTaskRun{}))
+	return db, gormrepo.NewSchedulerRepository(db)
+}
+
+func makeDue(t *testing.T, db *gormdb.DB, name string) {
+	t.Helper()
+	require.NoError(t, db.Model(&models.ScheduledTask{}).Where("name = ?", name).
+		Update("next_run_at", time.Now().Add(-time.Second)).Error)
+}
+
+func waitForRuns(t *testing.T, repo repository.SchedulerRepository, name string, n int64) *models.ScheduledTask {
+	t.Helper()
+	var task *models.ScheduledTask
+	require.Eventually(t, func() bool {
+		var err error
+		task, err = repo.Get(name)
+		return err == nil && task.RunCount >= n && task.LeaseOwner == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	return task
+}
+
+func TestRegister_RejectsBadSpec(t *testing.T) {
+	_, repo := newTestRepo(t)
+	s := New(repo)
+	noop := func(ctx context.Context) error { return nil }
+
+	assert.Error(t, s.Register(Task{Name: "bad", Spec: "every minute", Run: noop}))
+	require.NoError(t, s.Register(Task{Name: "ok", Spec: "@every 1h", Run: noop}))
+	assert.Error(t, s.Register(Task{Name: "ok", Spec: "@daily", Run: noop}), "duplicate name")
+}
+
+func TestScheduler_SingleRunAcrossInstances(t *testing.T) {
+	db, repo := newTestRepo(t)
+
+	var runs atomic.Int32
+	release := make(chan struct{})
+	task := Task{Name: "sweep", Spec: "@hourly", Run: func(ctx context.Context) error {
+		runs.Add(1)
+		<-release
+		return nil
+	}}
+
+	instances := []*Scheduler{New(repo), New(repo), New(repo)}
+	for _, s := range instances {
+		require.NoError(t, s.Register(task))
+		require.NoError(t, s.repo.Register(task.Name, task.Spec, time.Now().Add(time.Hour)))
+	}
+	makeDue(t, db, "sweep")
+
+	var wg sync.WaitGroup
+	for _, s := range instances {
+		wg.Add(1)
+		go func(s *Scheduler) {
+			defer wg.Done()
+			s.dispatch()
+		}(s)
+	}
+	wg.Wait()
+	close(release)
+
+	state := waitForRuns(t, repo, "sweep", 1)
+	assert.Equal(t, int32(1), runs.Load())
+	assert.Equal(t, models.ScheduledRunSucceeded, state.LastStatus)
+	assert.True(t, state.NextRunAt.After(time.Now()), "next run is rescheduled")
+
+	// Not due any more
+	for _, s := range instances {
+		s.dispatch()
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), runs.Load())
+
+	for _, s := range instances {
+		s.wg.Wait()
+	}
+}
+
+func TestScheduler_RunNowRecordsHistoryAndErrors(t *testing.T) {
+	_, repo := newTestRepo(t)
+	s := New(repo)
+	s.tick = 20 * time.Millisecond
+
+	var calls atomic.Int32
+	require.NoError(t, s.Register(Task{Name: "flaky", Spec: "@daily", Run: func(ctx context.Context) error {
+		if calls.Add(1) == 1 {
+			return errors.New("db unavailable")
+		}
+		panic("boom")
+	}}))
+	require.NoError(t, s.Start())
+	defer s.Shutdown(time.Second)
+
+	assert.ErrorIs(t, s.RunNow("missing"), ErrUnknownTask)
+
+	require.NoError(t, s.RunNow("flaky"))
+	state := waitForRuns(t, repo, "flaky", 1)
+	assert.Equal(t, models.ScheduledRunFailed, state.LastStatus)
+	require.NotNil(t, state.LastError)
+	assert.Equal(t, "db unavailable", *state.LastError)
+	assert.False(t, state.RunRequested)
+
+	require.NoError(t, s.RunNow("flaky"))
+	state = waitForRuns(t, repo, "flaky", 2)
+	assert.Equal(t, "panic: boom", *state.LastError)
+	assert.Equal(t, int64(2), state.FailCount)
+
+	runs, err := repo.ListRuns("flaky", 10)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.True(t, runs[0].Manual)
+	assert.Equal(t, models.ScheduledRunFailed, runs[0].Status)
+	assert.NotNil(t, runs[0].FinishedAt)
+}
+
+func TestScheduler_ExpiredLeaseIsTakenOver(t *testing.T) {
+	db, repo := newTestRepo(t)
+	require.NoError(t, repo.Register("sweep", "@hourly", time.Now().Add(-time.Second)))
+
+	// An instance took the task and died
+	state, err := repo.Acquire("sweep", "crashed", 10*time.Millisecond)
+	require.NoError(t, err)
+	require.NotNil(t, state)
+
+	again, err := repo.Acquire("sweep", "other", time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, again, "lease is held")
+
+	time.Sleep(20 * time.Millisecond)
+	makeDue(t, db, "sweep")
+
+	var ran atomic.Bool
+	s := New(repo)
+	require.NoError(t, s.Register(Task{Name: "sweep", Spec: "@hourly", Run: func(ctx context.Context) error {
+		ran.Store(true)
+		return nil
+	}}))
+	s.dispatch()
+	s.wg.Wait()
+
+	assert.True(t, ran.Load())
+	state = waitForRuns(t, repo, "sweep", 1)
+	assert.Equal(t, models.ScheduledRunSucceeded, state.LastStatus)
+}
+
+func TestScheduler_JitterDelaysNextRun(t *testing.T) {
+	_, repo := newTestRepo(t)
+	s := New(repo)
+	require.NoError(t, s.Register(Task{Name: "j", Spec: "0 3 * * *", Jitter: time.Hour, Run: func(ctx context.Context) error { return nil }}))
+
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		next := s.next(s.tasks["j"], from)
+		assert.False(t, next.Before(time.Date(2026, 1, 1, 3, 0, 0, 0, time.UTC)))
+		assert.True(t, next.Before(time.Date(2026, 1, 1, 4, 0, 0, 0, time.UTC)))
+	}
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+)
+
+// ActivityService — лента активности: дочитанные книги, рецензии, открытые
+// коллекции и челленджи. События записывают сервисы, где они происходят
+// (recordActivity); здесь — чтение ленты и настройки видимости.
+type ActivityService interface {
+	// Feed — события тех, на кого подписан userID
+	Feed(userID uuid.UUID, limit, offset int) ([]models.ActivityDTO, int64, error)
+	// UserActivity — события userID глазами viewerID: свои видны все,
+	// чужие — только открытые автором
+	UserActivity(viewerID, userID uuid.UUID, limit, offset int) ([]models.ActivityDTO, int64, error)
+	GetPrivacy(userID uuid.UUID) (*models.ActivityPrivacyDTO, error)
+	UpdatePrivacy(userID uuid.UUID, dto *models.ActivityPrivacyDTO) (*models.ActivityPrivacyDTO, error)
+}
+
+type activityService struct {
+	repo repository.ActivityRepository
+	now  func() time.Time
+}
+
+func NewActivityService(repo repository.ActivityRepository) ActivityService {
+	return &activityService{repo: repo, now: time.Now}
+}
+
+func (s *activityService) Feed(userID uuid.UUID, limit, offset int) ([]models.ActivityDTO, int64, error) {
+	activities, total, err := s.repo.ListFeed(userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return activityDTOs(activities), total, nil
+}
+
+func (s *activityService) UserActivity(viewerID, userID uuid.UUID, limit, offset int) ([]models.ActivityDTO, int64, error) {
+	activities, total, err := s.repo.ListByUser(userID, viewerID != userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return activityDTOs(activities), total, nil
+}
+
+func (s *activityService) GetPrivacy(userID uuid.UUID) (*models.ActivityPrivacyDTO, error) {
+	prefs, err := s.repo.GetPreferences(userID)
+	if err != nil {
+		return nil, err
+	}
+	dto := &models.ActivityPrivacyDTO{Shared: make(map[models.ActivityType]bool, len(models.ActivityTypes))}
+	for _, t := range models.ActivityTypes {
+		dto.Shared[t] = true
+	}
+	for _, p := range prefs {
+		dto.Shared[p.Type] = p.Shared
+	}
+	return dto, nil
+}
+
+func (s *activityService) UpdatePrivacy(userID uuid.UUID, dto *models.ActivityPrivacyDTO) (*models.ActivityPrivacyDTO, error) {
+	now := s.now()
+	prefs := make([]models.ActivityPreference, 0, len(dto.Shared))
+	for t, shared := range dto.Shared {
+		prefs = append(prefs, models.ActivityPreference{UserID: userID, Type: t, Shared: shared, UpdatedAt: now})
+	}
+	if err := s.repo.SavePreferences(prefs); err != nil {
+		return nil, err
+	}
+	return s.GetPrivacy(userID)
+}
+
+func activityDTOs(activities []models.Activity) []models.ActivityDTO {
+	result := make([]models.ActivityDTO, len(activities))
+	for i, a := range activities {
+		dto := models.ActivityDTO{
+			ID:        a.ID,
+			Type:      a.Type,
+			Actor:     models.UserSummaryDTO{ID: a.UserID},
+			SubjectID: a.SubjectID,
+			Title:     a.Title,
+			CreatedAt: a.CreatedAt,
+		}
+		if a.User != nil {
+			dto.Actor = models.NewUserSummaryDTO(a.User)
+		}
+		if a.Book != nil {
+			dto.Book = &models.ActivityBookDTO{ID: a.Book.ID, Title: a.Book.Title, Author: a.Book.Author}
+		}
+		result[i] = dto
+	}
+	return result
+}
+
+// recordActivity добавляет событие в ленту. Лента вторична: ошибка только
+// пишется в лог и не отменяет действие, которое событие описывает.
+// repo может быть nil — тогда события не записываются.
+func recordActivity(repo repository.ActivityRepository, activity *models.Activity) {
+	if repo == nil {
+		return
+	}
+	if err := repo.Save(activity); err != nil {
+		log.Printf("[activity] failed to record %s for user %s: %v", activity.Type, activity.UserID, err)
+	}
+}
+
+// recordBookFinished — книга дочитана впервые в этой выдаче; повторная
+// выдача той же книги даёт новое событие
+func recordBookFinished(repo repository.ActivityRepository, access *models.BookAccess) {
+	recordActivity(repo, &models.Activity{
+		UserID:    access.UserID,
+		Type:      models.ActivityBookFinished,
+		SubjectID: access.ID,
+		BookID:    &access.BookID,
+		CreatedAt: *access.FinishedAt,
+	})
+}
+
+// forgetActivity убирает событие об удалённом или скрытом субъекте
+func forgetActivity(repo repository.ActivityRepository, userID uuid.UUID, activityType models.ActivityType, subjectID uuid.UUID) {
+	if repo == nil {
+		return
+	}
+	if err := repo.Delete(userID, activityType, subjectID); err != nil {
+		log.Printf("[activity] failed to remove %s %s: %v", activityType, subjectID, err)
+	}
+}
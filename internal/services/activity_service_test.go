@@ -0,0 +1,145 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/oneErrortime/afst/internal/models"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestActivityService_Feed(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Book{}, &models.BookAccess{}, &models.Follow{},
+		&models.Review{}, &models.Collection{}, &models.ReadingChallenge{}, &models.ChallengeParticipant{},
+		&models.Activity{}, &models.ActivityPreference{}))
+	repos := gormrepo.NewExtendedRepository(db)
+	svc := NewActivityService(repos.Activity)
+	social := NewSocialService(repos.Social)
+	reviews := NewReviewService(repos.Review, repos.Activity)
+	collections := NewCollectionService(repos.Collection, repos.Activity)
+	goals := NewGoalService(repos, nil)
+	access := NewBookAccessServiceWithOutbox(repos)
+
+	newUser := func(name string) *models.User {
+		u := &models.User{Email: name + "@example.com", Name: name, Role: models.RoleReader, IsActive: true}
+		require.NoError(t, db.Create(u).Error)
+		return u
+	}
+	reader, author, stranger := newUser("Reader"), newUser("Author"), newUser("Stranger")
+	require.NoError(t, social.FollowUser(reader.ID, author.ID))
+
+	pages := 100
+	book := &models.Book{Title: "Солярис", Author: "Лем", PageCount: &pages}
+	require.NoError(t, db.Create(book).Error)
+	loan := &models.BookAccess{UserID: author.ID, BookID: book.ID, Type: models.AccessTypeLoan, Status: models.AccessStatusActive,
+		StartDate: time.Now().Add(-time.Hour), EndDate: time.Now().Add(24 * time.Hour)}
+	require.NoError(t, db.Create(loan).Error)
+
+	require.NoError(t, access.UpdateProgress(loan.ID, 50, 0))
+	require.NoError(t, access.UpdateProgress(loan.ID, 100, 0))
+	require.NoError(t, access.UpdateProgress(loan.ID, 100, 0))
+	review := &models.Review{UserID: author.ID, BookID: book.ID, Rating: 5, Title: "Океан"}
+	require.NoError(t, reviews.CreateReview(review))
+	private := &models.Collection{UserID: author.ID, Name: "Черновики"}
+	require.NoError(t, collections.CreateCollection(private))
+	shelf := &models.Collection{UserID: author.ID, Name: "Фантастика", IsPublic: true}
+	require.NoError(t, collections.CreateCollection(shelf))
+	challenge := &models.ReadingChallenge{Title: "Неделя чтения", Metric: models.GoalMetricBooks, Target: 2,
+		StartDate: time.Now().Add(-time.Hour), EndDate: time.Now().Add(7 * 24 * time.Hour), CreatedBy: author.ID}
+	require.NoError(t, db.Create(challenge).Error)
+	require.NoError(t, goals.Join(author.ID, challenge.ID))
+	require.NoError(t, db.Create(&models.Review{UserID: stranger.ID, BookID: book.ID, Rating: 1}).Error)
+
+	types := func(items []models.ActivityDTO) []models.ActivityType {
+		var got []models.ActivityType
+		for _, a := range items {
+			got = append(got, a.Type)
+		}
+		return got
+	}
+
+	feed, total, err := svc.Feed(reader.ID, 20, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), total, "finishing twice and private collections add nothing")
+	assert.ElementsMatch(t, []models.ActivityType{models.ActivityBookFinished, models.ActivityReviewWritten,
+		models.ActivityCollectionPublished, models.ActivityChallengeJoined}, types(feed))
+	for _, a := range feed {
+		assert.Equal(t, "Author", a.Actor.Name)
+		switch a.Type {
+		case models.ActivityBookFinished:
+			require.NotNil(t, a.Book)
+			assert.Equal(t, "Солярис", a.Book.Title)
+			assert.Equal(t, loan.ID, a.SubjectID)
+		case models.ActivityCollectionPublished:
+			assert.Equal(t, "Фантастика", a.Title)
+		}
+	}
+
+	page, total, err := svc.Feed(reader.ID, 3, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), total)
+	assert.Len(t, page, 1)
+
+	// Автор скрывает дочитанные книги: и новые, и прошлые события
+	privacy, err := svc.UpdatePrivacy(author.ID, &models.ActivityPrivacyDTO{
+		Shared: map[models.ActivityType]bool{models.ActivityBookFinished: false},
+	})
+	require.NoError(t, err)
+	assert.False(t, privacy.Shared[models.ActivityBookFinished])
+	assert.True(t, privacy.Shared[models.ActivityReviewWritten])
+	feed, _, err = svc.Feed(reader.ID, 20, 0)
+	require.NoError(t, err)
+	assert.NotContains(t, types(feed), models.ActivityBookFinished)
+	own, total, err := svc.UserActivity(author.ID, author.ID, 20, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), total, "the author sees hidden activity")
+	assert.Contains(t, types(own), models.ActivityBookFinished)
+	_, total, err = svc.UserActivity(stranger.ID, author.ID, 20, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+
+	// Закрытая или удалённая коллекция и удалённая рецензия уходят из ленты
+	shelf.IsPublic = false
+	require.NoError(t, collections.UpdateCollection(shelf))
+	require.NoError(t, reviews.DeleteReview(review.ID))
+	require.NoError(t, goals.Leave(author.ID, challenge.ID))
+	feed, total, err = svc.Feed(reader.ID, 20, 0)
+	require.NoError(t, err)
+	assert.Zero(t, total)
+	assert.Empty(t, feed)
+
+	require.NoError(t, social.UnfollowUser(reader.ID, author.ID))
+	_, err = svc.UpdatePrivacy(author.ID, &models.ActivityPrivacyDTO{
+		Shared: map[models.ActivityType]bool{models.ActivityBookFinished: true},
+	})
+	require.NoError(t, err)
+	_, total, err = svc.Feed(reader.ID, 20, 0)
+	require.NoError(t, err)
+	assert.Zero(t, total, "unfollowing empties the feed at once")
+
+	followers, total, err := social.GetFollowers(author.ID, 20, 0)
+	require.NoError(t, err)
+	assert.Empty(t, followers)
+	assert.Zero(t, total)
+	require.NoError(t, social.FollowUser(stranger.ID, author.ID))
+	require.NoError(t, social.FollowUser(reader.ID, author.ID))
+	followers, total, err = social.GetFollowers(author.ID, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	require.Len(t, followers, 1)
+	assert.Equal(t, "Stranger", followers[0].Name, "newest followers first")
+	following, total, err := social.GetFollowing(stranger.ID, 20, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, following, 1)
+	assert.Equal(t, author.ID, following[0].ID)
+}
@@ -0,0 +1,317 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+)
+
+var (
+	ErrAnnotationNotFound  = errors.New("annotation not found")
+	ErrAnnotationForbidden = errors.New("annotation belongs to another user")
+	ErrAnnotationEmpty     = errors.New("highlight needs highlighted_text and note needs note")
+	ErrUnknownExportFormat = errors.New("unknown export format")
+)
+
+// AnnotationService — выделения и заметки. Аннотацию видит автор, а в
+// зависимости от видимости — его подписчики или все пользователи; менять и
+// удалять её может только автор.
+type AnnotationService interface {
+	Create(userID uuid.UUID, dto *models.CreateAnnotationDTO) (*models.Annotation, error)
+	// Get возвращает аннотацию, если viewerID её видит, иначе ErrAnnotationNotFound
+	Get(viewerID, id uuid.UUID) (*models.Annotation, error)
+	Update(userID, id uuid.UUID, dto *models.UpdateAnnotationDTO) (*models.Annotation, error)
+	Delete(userID, id uuid.UUID) error
+	// List возвращает видимые viewerID аннотации по фильтру и их общее число
+	List(viewerID uuid.UUID, filter models.AnnotationFilter) ([]models.Annotation, int64, error)
+	// Export выгружает собственные аннотации пользователя в заданном формате
+	Export(userID uuid.UUID, filter models.AnnotationFilter, format models.AnnotationExportFormat) ([]byte, error)
+}
+
+type annotationService struct {
+	repo   repository.AnnotationRepository
+	social repository.SocialRepository
+}
+
+func NewAnnotationService(repo repository.AnnotationRepository, social repository.SocialRepository) AnnotationService {
+	return &annotationService{repo: repo, social: social}
+}
+
+func (s *annotationService) Create(userID uuid.UUID, dto *models.CreateAnnotationDTO) (*models.Annotation, error) {
+	loc, location, err := models.ResolveLocation(dto.Locator, dto.Location)
+	if err != nil {
+		return nil, err
+	}
+	annotation := &models.Annotation{
+		UserID:          userID,
+		BookID:          dto.BookID,
+		Type:            dto.Type,
+		Location:        location,
+		Locator:         loc,
+		HighlightedText: strings.TrimSpace(dto.HighlightedText),
+		Note:            strings.TrimSpace(dto.Note),
+		Color:           dto.Color,
+		Tags:            normalizeTags(dto.Tags),
+		Visibility:      dto.Visibility,
+	}
+	if annotation.Color == "" && annotation.Type == models.AnnotationHighlight {
+		annotation.Color = models.ColorYellow
+	}
+	if annotation.Visibility == "" {
+		annotation.Visibility = models.VisibilityPrivate
+	}
+	if err := checkAnnotationText(annotation); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(annotation); err != nil {
+		return nil, err
+	}
+	return annotation, nil
+}
+
+func (s *annotationService) Get(viewerID, id uuid.UUID) (*models.Annotation, error) {
+	annotation, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, ErrAnnotationNotFound
+	}
+	if !s.visible(viewerID, annotation) {
+		return nil, ErrAnnotationNotFound
+	}
+	return annotation, nil
+}
+
+func (s *annotationService) Update(userID, id uuid.UUID, dto *models.UpdateAnnotationDTO) (*models.Annotation, error) {
+	annotation, err := s.owned(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if dto.HighlightedText != nil {
+		annotation.HighlightedText = strings.TrimSpace(*dto.HighlightedText)
+	}
+	if dto.Note != nil {
+		annotation.Note = strings.TrimSpace(*dto.Note)
+	}
+	if dto.Color != nil {
+		annotation.Color = *dto.Color
+	}
+	if dto.Tags != nil {
+		annotation.Tags = normalizeTags(dto.Tags)
+	}
+	if dto.Visibility != nil {
+		annotation.Visibility = *dto.Visibility
+	}
+	if err := checkAnnotationText(annotation); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Update(annotation); err != nil {
+		return nil, err
+	}
+	return annotation, nil
+}
+
+func (s *annotationService) Delete(userID, id uuid.UUID) error {
+	if _, err := s.owned(userID, id); err != nil {
+		return err
+	}
+	return s.repo.Delete(id)
+}
+
+func (s *annotationService) List(viewerID uuid.UUID, filter models.AnnotationFilter) ([]models.Annotation, int64, error) {
+	filter.Tag = normalizeTag(filter.Tag)
+	return s.repo.ListVisible(viewerID, filter)
+}
+
+func (s *annotationService) Export(userID uuid.UUID, filter models.AnnotationFilter, format models.AnnotationExportFormat) ([]byte, error) {
+	filter.UserID = &userID
+	filter.Limit, filter.Offset = 0, 0
+	annotations, _, err := s.List(userID, filter)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case models.ExportMarkdown:
+		return exportMarkdown(annotations), nil
+	case models.ExportJSON:
+		return exportJSON(annotations)
+	case models.ExportClippings:
+		return exportClippings(annotations), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownExportFormat, format)
+	}
+}
+
+func (s *annotationService) owned(userID, id uuid.UUID) (*models.Annotation, error) {
+	annotation, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, ErrAnnotationNotFound
+	}
+	if annotation.UserID != userID {
+		if s.visible(userID, annotation) {
+			return nil, ErrAnnotationForbidden
+		}
+		return nil, ErrAnnotationNotFound
+	}
+	return annotation, nil
+}
+
+func (s *annotationService) visible(viewerID uuid.UUID, annotation *models.Annotation) bool {
+	switch {
+	case annotation.UserID == viewerID, annotation.Visibility == models.VisibilityPublic:
+		return true
+	case annotation.Visibility == models.VisibilityFollowers:
+		following, err := s.social.IsFollowing(viewerID, annotation.UserID)
+		return err == nil && following
+	}
+	return false
+}
+
+func checkAnnotationText(a *models.Annotation) error {
+	if (a.Type == models.AnnotationHighlight && a.HighlightedText == "") ||
+		(a.Type == models.AnnotationNote && a.Note == "") {
+		return ErrAnnotationEmpty
+	}
+	return nil
+}
+
+// normalizeTags приводит теги к нижнему регистру и убирает пустые и повторы
+func normalizeTags(tags []string) []string {
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	return out
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(tag), "#")))
+}
+
+// annotatedBook — аннотации одной книги в выгрузке
+type annotatedBook struct {
+	BookID      uuid.UUID           `json:"book_id"`
+	Title       string              `json:"title"`
+	Author      string              `json:"author"`
+	Annotations []models.Annotation `json:"annotations"`
+}
+
+// groupByBook группирует аннотации по книгам в порядке первой аннотации
+func groupByBook(annotations []models.Annotation) []*annotatedBook {
+	var books []*annotatedBook
+	index := make(map[uuid.UUID]*annotatedBook)
+	for _, a := range annotations {
+		book, ok := index[a.BookID]
+		if !ok {
+			book = &annotatedBook{BookID: a.BookID, Title: "Без названия"}
+			if a.Book != nil {
+				book.Title, book.Author = a.Book.Title, a.Book.Author
+			}
+			index[a.BookID] = book
+			books = append(books, book)
+		}
+		a.Book = nil
+		book.Annotations = append(book.Annotations, a)
+	}
+	return books
+}
+
+func exportJSON(annotations []models.Annotation) ([]byte, error) {
+	books := groupByBook(annotations)
+	if books == nil {
+		books = []*annotatedBook{}
+	}
+	return json.MarshalIndent(books, "", "  ")
+}
+
+// annotationPlace — место аннотации для людей: "стр. 12", "42.5%" или CFI
+func annotationPlace(a *models.Annotation) string {
+	if a.Locator.Type == models.LocatorPage && a.Locator.Page != nil {
+		return fmt.Sprintf("стр. %d", *a.Locator.Page)
+	}
+	return a.Location
+}
+
+func exportMarkdown(annotations []models.Annotation) []byte {
+	var buf bytes.Buffer
+	for i, book := range groupByBook(annotations) {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		buf.WriteString("# " + book.Title)
+		if book.Author != "" {
+			buf.WriteString(" — " + book.Author)
+		}
+		buf.WriteString("\n")
+		for _, a := range book.Annotations {
+			buf.WriteString("\n")
+			if a.HighlightedText != "" {
+				for _, line := range strings.Split(a.HighlightedText, "\n") {
+					buf.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+				}
+				buf.WriteString("\n")
+			}
+			if a.Note != "" {
+				buf.WriteString(a.Note + "\n\n")
+			}
+			meta := []string{annotationPlace(&a)}
+			if a.Color != "" {
+				meta = append(meta, string(a.Color))
+			}
+			for _, tag := range a.Tags {
+				meta = append(meta, "#"+tag)
+			}
+			meta = append(meta, a.CreatedAt.Format("2006-01-02"))
+			buf.WriteString("*" + strings.Join(meta, " · ") + "*\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+// Kindle пишет «My Clippings.txt» в UTF-8 с BOM и переводами строк CRLF;
+// выделение с заметкой — это две записи: сначала выделение, затем заметка
+const (
+	clippingsSeparator  = "=========="
+	clippingsTimeLayout = "Monday, January 2, 2006 3:04:05 PM"
+)
+
+func exportClippings(annotations []models.Annotation) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\ufeff")
+	entry := func(title, kind string, a *models.Annotation, text string) {
+		buf.WriteString(title + "\r\n")
+		buf.WriteString("- Your " + kind)
+		if a.Locator.Type == models.LocatorPage && a.Locator.Page != nil {
+			fmt.Fprintf(&buf, " on page %d", *a.Locator.Page)
+		}
+		buf.WriteString(" | Added on " + a.CreatedAt.Format(clippingsTimeLayout) + "\r\n\r\n")
+		buf.WriteString(strings.ReplaceAll(text, "\n", "\r\n") + "\r\n")
+		buf.WriteString(clippingsSeparator + "\r\n")
+	}
+	for _, book := range groupByBook(annotations) {
+		title := book.Title
+		if book.Author != "" {
+			title += " (" + book.Author + ")"
+		}
+		for i := range book.Annotations {
+			a := &book.Annotations[i]
+			if a.HighlightedText != "" {
+				entry(title, "Highlight", a, a.HighlightedText)
+			}
+			if a.Note != "" {
+				entry(title, "Note", a, a.Note)
+			}
+		}
+	}
+	return buf.Bytes()
+}
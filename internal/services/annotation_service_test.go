@@ -0,0 +1,144 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestAnnotationService(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Book{}, &models.Follow{}, &models.BookAccess{}, &models.Annotation{}))
+	repos := gormrepo.NewExtendedRepository(db)
+	svc := NewAnnotationService(repos.Annotation, repos.Social)
+
+	var author, follower, stranger models.User
+	for _, u := range []*models.User{&author, &follower, &stranger} {
+		u.Email = uuid.NewString() + "@example.com"
+		u.Name = "Reader"
+		u.Role = models.RoleReader
+		require.NoError(t, db.Create(u).Error)
+	}
+	require.NoError(t, repos.Social.Follow(follower.ID, author.ID))
+	book := &models.Book{Title: "Евгений Онегин", Author: "Пушкин"}
+	require.NoError(t, db.Create(book).Error)
+
+	create := func(dto models.CreateAnnotationDTO) *models.Annotation {
+		dto.BookID = book.ID
+		a, err := svc.Create(author.ID, &dto)
+		require.NoError(t, err)
+		return a
+	}
+	private := create(models.CreateAnnotationDTO{Type: models.AnnotationHighlight, Location: "12",
+		HighlightedText: "Мой дядя самых честных правил", Note: "начало", Tags: []string{"#Intro", "intro", " "}})
+	assert.Equal(t, models.LocatorPage, private.Locator.Type)
+	assert.Equal(t, models.ColorYellow, private.Color)
+	assert.Equal(t, models.VisibilityPrivate, private.Visibility)
+	assert.Equal(t, []string{"intro"}, private.Tags)
+
+	cfi := "epubcfi(/6/4!/4/2:0)"
+	followers := create(models.CreateAnnotationDTO{Type: models.AnnotationNote, Locator: &models.Locator{Type: models.LocatorCFI, CFI: &cfi},
+		Note: "Для подписчиков", Visibility: models.VisibilityFollowers, Tags: []string{"100%_sure"}})
+	public := create(models.CreateAnnotationDTO{Type: models.AnnotationHighlight, Location: "глава 2",
+		HighlightedText: "Когда не в шутку занемог", Color: models.ColorBlue, Visibility: models.VisibilityPublic})
+
+	_, err = svc.Create(author.ID, &models.CreateAnnotationDTO{BookID: book.ID, Type: models.AnnotationHighlight, Location: "3"})
+	assert.ErrorIs(t, err, ErrAnnotationEmpty)
+	_, err = svc.Create(author.ID, &models.CreateAnnotationDTO{BookID: book.ID, Type: models.AnnotationNote, Note: "x"})
+	assert.ErrorIs(t, err, models.ErrInvalidLocator)
+
+	visible := func(viewer uuid.UUID) []uuid.UUID {
+		list, _, err := svc.List(viewer, models.AnnotationFilter{BookID: &book.ID})
+		require.NoError(t, err)
+		ids := make([]uuid.UUID, 0, len(list))
+		for _, a := range list {
+			ids = append(ids, a.ID)
+		}
+		return ids
+	}
+	assert.ElementsMatch(t, []uuid.UUID{private.ID, followers.ID, public.ID}, visible(author.ID))
+	assert.ElementsMatch(t, []uuid.UUID{followers.ID, public.ID}, visible(follower.ID))
+	assert.ElementsMatch(t, []uuid.UUID{public.ID}, visible(stranger.ID))
+
+	_, err = svc.Get(stranger.ID, private.ID)
+	assert.ErrorIs(t, err, ErrAnnotationNotFound, "a private annotation is not revealed")
+	_, err = svc.Get(follower.ID, followers.ID)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, svc.Delete(stranger.ID, public.ID), ErrAnnotationForbidden)
+
+	byTag, _, err := svc.List(author.ID, models.AnnotationFilter{Tag: "#INTRO"})
+	require.NoError(t, err)
+	require.Len(t, byTag, 1)
+	assert.Equal(t, private.ID, byTag[0].ID)
+	byTag, _, err = svc.List(author.ID, models.AnnotationFilter{Tag: "100%_sure"})
+	require.NoError(t, err)
+	assert.Len(t, byTag, 1, "LIKE wildcards in tags are matched literally")
+
+	page, total, err := svc.List(author.ID, models.AnnotationFilter{UserID: &author.ID, Limit: 2, Offset: 2})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	require.Len(t, page, 1)
+	assert.Equal(t, public.ID, page[0].ID)
+
+	// Без действующей выдачи чужие аннотации к книге не видны, даже публичные
+	readable := models.AnnotationFilter{UserID: &author.ID, ReadableBy: &stranger.ID}
+	_, total, err = svc.List(stranger.ID, readable)
+	require.NoError(t, err)
+	assert.Zero(t, total)
+	require.NoError(t, db.Create(&models.BookAccess{UserID: stranger.ID, BookID: book.ID, Type: models.AccessTypeLoan,
+		Status: models.AccessStatusActive, StartDate: time.Now().Add(-time.Hour), EndDate: time.Now().Add(time.Hour)}).Error)
+	_, total, err = svc.List(stranger.ID, readable)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+
+	empty := ""
+	_, err = svc.Update(author.ID, public.ID, &models.UpdateAnnotationDTO{HighlightedText: &empty})
+	assert.ErrorIs(t, err, ErrAnnotationEmpty)
+	pink := models.ColorPink
+	updated, err := svc.Update(author.ID, public.ID, &models.UpdateAnnotationDTO{Color: &pink, Tags: []string{"Travel"}})
+	require.NoError(t, err)
+	assert.Equal(t, models.ColorPink, updated.Color)
+	assert.Equal(t, []string{"travel"}, updated.Tags)
+
+	t.Run("export", func(t *testing.T) {
+		md, err := svc.Export(author.ID, models.AnnotationFilter{}, models.ExportMarkdown)
+		require.NoError(t, err)
+		assert.Contains(t, string(md), "# Евгений Онегин — Пушкин\n")
+		assert.Contains(t, string(md), "> Мой дядя самых честных правил\n\nначало\n\n*стр. 12 · yellow · #intro · ")
+
+		raw, err := svc.Export(author.ID, models.AnnotationFilter{}, models.ExportJSON)
+		require.NoError(t, err)
+		var books []annotatedBook
+		require.NoError(t, json.Unmarshal(raw, &books))
+		require.Len(t, books, 1)
+		assert.Equal(t, "Пушкин", books[0].Author)
+		assert.Len(t, books[0].Annotations, 3)
+
+		clippings, err := svc.Export(author.ID, models.AnnotationFilter{}, models.ExportClippings)
+		require.NoError(t, err)
+		text := string(clippings)
+		assert.True(t, strings.HasPrefix(text, "\ufeffЕвгений Онегин (Пушкин)\r\n- Your Highlight on page 12 | Added on "))
+		assert.Equal(t, 4, strings.Count(text, "==========\r\n"), "a highlight with a note is two clippings")
+		assert.Contains(t, text, "- Your Note on page 12 | Added on ")
+
+		other, err := svc.Export(follower.ID, models.AnnotationFilter{}, models.ExportJSON)
+		require.NoError(t, err)
+		assert.JSONEq(t, "[]", string(other), "only one's own annotations are exported")
+
+		_, err = svc.Export(author.ID, models.AnnotationFilter{}, "pdf")
+		assert.ErrorIs(t, err, ErrUnknownExportFormat)
+	})
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"time"
+
+	"github.com/oneErrortime/afst/internal/repository"
+	"github.com/oneErrortime/afst/internal/storage"
+)
+
+// blobGCBatch — сколько файлов без ссылок разбирается за один запрос
+const blobGCBatch = 200
+
+// BlobGCReport — итог сборки мусора в хранилище файлов книг
+type BlobGCReport struct {
+	// Removed — удалено учтённых файлов, на которые не осталось ссылок
+	Removed int
+	// Orphans — удалено файлов без записи в blobs (загрузки, оборванные до
+	// создания BookFile)
+	Orphans int
+	Bytes   int64
+}
+
+// BlobService — сборка мусора в хранилище файлов книг. Файлы хранятся по
+// SHA-256 содержимого и разделяются между записями BookFile; удалять их
+// можно только когда ссылок не осталось.
+type BlobService interface {
+	// CollectGarbage удаляет файлы, на которые никто не ссылается дольше grace.
+	// Пауза защищает загрузки, которые уже записали файл, но ещё не создали
+	// BookFile.
+	CollectGarbage(grace time.Duration) (*BlobGCReport, error)
+}
+
+type blobService struct {
+	repo        repository.BlobRepository
+	fileStorage storage.FileStorage
+}
+
+func NewBlobService(repo repository.BlobRepository, fileStorage storage.FileStorage) BlobService {
+	return &blobService{repo: repo, fileStorage: fileStorage}
+}
+
+func (s *blobService) CollectGarbage(grace time.Duration) (*BlobGCReport, error) {
+	before := time.Now().Add(-grace)
+	report := &BlobGCReport{}
+	if err := s.collectUnreferenced(before, report); err != nil {
+		return report, err
+	}
+	err := s.collectOrphans(before, report)
+	return report, err
+}
+
+// collectUnreferenced удаляет файлы с нулём ссылок. Файл сначала помечается
+// (Claim): после этого новые BookFile на него не ссылаются, пока запись не
+// удалена, а загрузка того же содержимого запишет файл заново.
+func (s *blobService) collectUnreferenced(before time.Time, report *BlobGCReport) error {
+	for {
+		blobs, err := s.repo.ListUnreferenced(before, blobGCBatch)
+		if err != nil {
+			return err
+		}
+		progress := false
+		for _, b := range blobs {
+			claimed, err := s.repo.Claim(b.FilePath, before)
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				continue
+			}
+			if err := s.fileStorage.Delete(b.FilePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("[blobs] failed to delete %s: %v", b.FilePath, err)
+				if err := s.repo.Unclaim(b.FilePath); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := s.repo.Remove(b.FilePath); err != nil {
+				return err
+			}
+			report.Removed++
+			report.Bytes += b.Size
+			progress = true
+		}
+		if len(blobs) < blobGCBatch || !progress {
+			return nil
+		}
+	}
+}
+
+// collectOrphans удаляет файлы в области blobs/, о которых база не знает:
+// загрузка записала файл, но запись BookFile так и не появилась. Свежие
+// файлы не трогаются — их загрузка может быть ещё в пути.
+func (s *blobService) collectOrphans(before time.Time, report *BlobGCReport) error {
+	walker, ok := s.fileStorage.(storage.Walker)
+	if !ok {
+		return nil
+	}
+	known, err := s.repo.ListHashes()
+	if err != nil {
+		return err
+	}
+
+	return walker.Walk(func(filePath string) error {
+		if !storage.IsBlobPath(filePath) {
+			return nil
+		}
+		if _, ok := known[filePath]; ok {
+			return nil
+		}
+		// Запись могла появиться после чтения списка
+		if _, err := s.repo.GetByPath(filePath); err == nil {
+			return nil
+		}
+		obj, err := s.fileStorage.Get(filePath)
+		if err != nil {
+			return nil
+		}
+		info, err := obj.Stat()
+		_ = obj.Close()
+		if err != nil || !info.ModTime().Before(before) {
+			return nil
+		}
+		if err := s.fileStorage.Delete(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete orphan %s: %w", filePath, err)
+		}
+		report.Orphans++
+		report.Bytes += info.Size()
+		return nil
+	})
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"mime/multipart"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/oneErrortime/afst/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// memoryFile — multipart.File поверх строки
+type memoryFile struct{ *strings.Reader }
+
+func (memoryFile) Close() error { return nil }
+
+// testPDFTrailer — конец PDF, без которого файл не проходит проверку структуры
+const testPDFTrailer = "\nstartxref\n0\n%%EOF\n"
+
+func TestBlobReferenceCountingAndGC(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Book{}, &models.BookFile{}, &models.Blob{}))
+	repos := gormrepo.NewExtendedRepository(db)
+
+	fileStorage := storage.NewLocalStorage(t.TempDir(), "")
+	bookFiles := NewBookFileService(repos.BookFile, repos.Book, nil, fileStorage, DefaultFileCheckOptions())
+	blobs := NewBlobService(repos.Blob, fileStorage)
+
+	first := &models.Book{Title: "Война и мир", Author: "Толстой"}
+	second := &models.Book{Title: "Война и мир (копия)", Author: "Толстой"}
+	require.NoError(t, db.Create(first).Error)
+	require.NoError(t, db.Create(second).Error)
+
+	upload := func(book *models.Book, name, content string) (*models.BookFile, error) {
+		return bookFiles.Upload(book.ID, memoryFile{strings.NewReader(content)}, &multipart.FileHeader{Filename: name})
+	}
+	refCount := func(filePath string) int {
+		blob, err := repos.Blob.GetByPath(filePath)
+		require.NoError(t, err)
+		return blob.RefCount
+	}
+	const content = "%PDF-1.4 одна и та же книга" + testPDFTrailer
+
+	a, err := upload(first, "a.pdf", content)
+	require.NoError(t, err)
+	b, err := upload(second, "b.pdf", content)
+	require.NoError(t, err)
+	assert.Equal(t, a.FilePath, b.FilePath, "identical files share one blob")
+	assert.Equal(t, 2, refCount(a.FilePath))
+
+	_, err = upload(first, "again.pdf", content)
+	assert.Error(t, err, "a duplicate within one book is rejected")
+	assert.Equal(t, 2, refCount(a.FilePath))
+	assert.True(t, fileStorage.Exists(a.FilePath), "rejecting a duplicate keeps the shared blob")
+
+	require.NoError(t, bookFiles.Delete(a.ID))
+	assert.Equal(t, 1, refCount(a.FilePath))
+	report, err := blobs.CollectGarbage(0)
+	require.NoError(t, err)
+	assert.Zero(t, report.Removed)
+	assert.True(t, fileStorage.Exists(b.FilePath), "the other book still uses the blob")
+
+	require.NoError(t, bookFiles.Delete(b.ID))
+	assert.Equal(t, 0, refCount(b.FilePath))
+	report, err = blobs.CollectGarbage(time.Hour)
+	require.NoError(t, err)
+	assert.Zero(t, report.Removed, "unreferenced blobs wait out the grace period")
+
+	report, err = blobs.CollectGarbage(0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Removed)
+	assert.Equal(t, int64(len(content)), report.Bytes)
+	assert.False(t, fileStorage.Exists(b.FilePath))
+	_, err = repos.Blob.GetByPath(b.FilePath)
+	assert.Error(t, err)
+
+	t.Run("a blob being collected takes no new references", func(t *testing.T) {
+		f, err := upload(first, "c.pdf", "%PDF-1.4 другая книга"+testPDFTrailer)
+		require.NoError(t, err)
+		require.NoError(t, repos.BookFile.Delete(f.ID))
+		claimed, err := repos.Blob.Claim(f.FilePath, time.Now().Add(time.Second))
+		require.NoError(t, err)
+		require.True(t, claimed)
+
+		err = repos.BookFile.Create(&models.BookFile{BookID: second.ID, FileName: "d.pdf", OriginalName: "d.pdf",
+			FilePath: f.FilePath, FileType: models.FileTypePDF, FileSize: f.FileSize, MimeType: f.MimeType, Hash: f.Hash})
+		assert.ErrorIs(t, err, repository.ErrBlobCollecting)
+
+		// Сборщик закончил: файл и запись удалены, повторная загрузка записывает файл заново
+		require.NoError(t, fileStorage.Delete(f.FilePath))
+		require.NoError(t, repos.Blob.Remove(f.FilePath))
+		again, err := upload(second, "d.pdf", "%PDF-1.4 другая книга"+testPDFTrailer)
+		require.NoError(t, err)
+		assert.True(t, fileStorage.Exists(again.FilePath))
+		assert.Equal(t, 1, refCount(again.FilePath))
+	})
+
+	t.Run("orphaned blobs are swept after the grace period", func(t *testing.T) {
+		orphan, err := fileStorage.Upload(strings.NewReader("%PDF-1.4 брошенная загрузка"), "orphan.pdf")
+		require.NoError(t, err)
+		_, err = fileStorage.Put("covers/x/cover_original.png", strings.NewReader("png"))
+		require.NoError(t, err)
+
+		report, err := blobs.CollectGarbage(time.Hour)
+		require.NoError(t, err)
+		assert.Zero(t, report.Orphans)
+
+		old := time.Now().Add(-2 * time.Hour)
+		require.NoError(t, os.Chtimes(fileStorage.LocalPath(orphan.FilePath), old, old))
+		report, err = blobs.CollectGarbage(time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Orphans)
+		assert.False(t, fileStorage.Exists(orphan.FilePath))
+		assert.True(t, fileStorage.Exists("covers/x/cover_original.png"), "only the blob area is swept")
+	})
+
+	t.Run("reconcile restores counts of files uploaded before reference counting", func(t *testing.T) {
+		legacy := &models.BookFile{BookID: first.ID, FileName: "old.pdf", OriginalName: "old.pdf",
+			FilePath: "ab/legacy_abcdef12.pdf", FileType: models.FileTypePDF, FileSize: 10, MimeType: "application/pdf", Hash: "abcdef"}
+		require.NoError(t, db.Create(legacy).Error)
+
+		fixed, err := repos.Blob.Reconcile()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), fixed)
+		assert.Equal(t, 1, refCount(legacy.FilePath))
+	})
+}
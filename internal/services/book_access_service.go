@@ -5,8 +5,10 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/events"
 	"github.com/oneErrortime/afst/internal/models"
 	"github.com/oneErrortime/afst/internal/repository"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
 )
 
 type bookAccessService struct {
@@ -15,6 +17,8 @@ type bookAccessService struct {
 	userRepo         repository.UserRepository
 	subscriptionRepo repository.SubscriptionRepository
 	groupRepo        repository.UserGroupRepository
+	activityRepo     repository.ActivityRepository
+	extendedRepo     *repository.ExtendedRepository
 }
 
 func NewBookAccessService(
@@ -33,6 +37,20 @@ func NewBookAccessService(
 	}
 }
 
+// NewBookAccessServiceWithOutbox записывает access.granted / access.revoked
+// в outbox в одной транзакции с изменением доступа.
+func NewBookAccessServiceWithOutbox(extendedRepo *repository.ExtendedRepository) BookAccessService {
+	return &bookAccessService{
+		accessRepo:       extendedRepo.BookAccess,
+		bookRepo:         extendedRepo.Book,
+		userRepo:         extendedRepo.User,
+		subscriptionRepo: extendedRepo.Subscription,
+		groupRepo:        extendedRepo.UserGroup,
+		activityRepo:     extendedRepo.Activity,
+		extendedRepo:     extendedRepo,
+	}
+}
+
 func (s *bookAccessService) GrantAccess(dto *models.GrantAccessDTO) (*models.BookAccess, error) {
 	user, err := s.userRepo.GetByID(dto.UserID)
 	if err != nil {
@@ -83,10 +101,12 @@ func (s *bookAccessService) GrantAccess(dto *models.GrantAccessDTO) (*models.Boo
 		EndDate:   now.AddDate(0, 0, loanDays),
 	}
 
-	if err := s.accessRepo.Create(access); err != nil {
+	err = s.persist(events.EventAccessGranted, access, func(repo repository.BookAccessRepository) error {
+		return repo.Create(access)
+	})
+	if err != nil {
 		return nil, err
 	}
-
 	return access, nil
 }
 
@@ -123,7 +143,9 @@ func (s *bookAccessService) RevokeAccess(id uuid.UUID) error {
 	}
 
 	access.Status = models.AccessStatusRevoked
-	return s.accessRepo.Update(access)
+	return s.persist(events.EventAccessRevoked, access, func(repo repository.BookAccessRepository) error {
+		return repo.Update(access)
+	})
 }
 
 func (s *bookAccessService) UpdateProgress(id uuid.UUID, currentPage int, readTime time.Duration) error {
@@ -132,12 +154,22 @@ func (s *bookAccessService) UpdateProgress(id uuid.UUID, currentPage int, readTi
 		return err
 	}
 
-	access.CurrentPage = currentPage
+	// Прогресс считается от числа страниц книги (его записывает обработка файла)
+	totalPages := 0
+	if book, err := s.bookRepo.GetByID(access.BookID); err == nil && book.PageCount != nil {
+		totalPages = *book.PageCount
+	}
+	finished := access.FinishedAt != nil
+	access.UpdateProgress(currentPage, totalPages)
 	access.TotalReadTime += int(readTime.Seconds())
-	now := time.Now()
-	access.LastAccessedAt = &now
 
-	return s.accessRepo.Update(access)
+	if err := s.accessRepo.Update(access); err != nil {
+		return err
+	}
+	if !finished && access.FinishedAt != nil {
+		recordBookFinished(s.activityRepo, access)
+	}
+	return nil
 }
 
 func (s *bookAccessService) GetUserLibrary(userID uuid.UUID) ([]models.BookAccessWithBook, error) {
@@ -159,3 +191,30 @@ func (s *bookAccessService) GetUserLibrary(userID uuid.UUID) ([]models.BookAcces
 
 	return result, nil
 }
+
+func (s *bookAccessService) ExpireOverdue() (int64, error) {
+	return s.accessRepo.ExpireEndedBefore(time.Now())
+}
+
+// persist выполняет write и, если подключён outbox, в той же транзакции записывает событие.
+func (s *bookAccessService) persist(eventType events.EventType, access *models.BookAccess, write func(repository.BookAccessRepository) error) error {
+	if s.extendedRepo == nil || s.extendedRepo.Outbox == nil {
+		return write(s.accessRepo)
+	}
+	return gormrepo.WithTransaction(s.extendedRepo, func(txRepo *repository.ExtendedRepository) error {
+		if err := write(txRepo.BookAccess); err != nil {
+			return err
+		}
+		return txRepo.Outbox.Enqueue(events.Event{
+			Type:   eventType,
+			UserID: access.UserID.String(),
+			Payload: events.AccessPayload{
+				AccessID: access.ID.String(),
+				BookID:   access.BookID.String(),
+				UserID:   access.UserID.String(),
+				Type:     string(access.Type),
+				EndDate:  access.EndDate.Format(time.RFC3339),
+			},
+		})
+	})
+}
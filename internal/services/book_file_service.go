@@ -3,53 +3,90 @@ package services
 import (
 	"archive/zip"
 	"errors"
+	"fmt"
+	"io"
+	"log"
 	"mime/multipart"
-	"os"
+	"time"
 
 	"github.com/google/uuid"
 	epubparser "github.com/mathieu-keller/epub-parser"
 	"github.com/oneErrortime/afst/internal/events"
 	"github.com/oneErrortime/afst/internal/models"
 	"github.com/oneErrortime/afst/internal/repository"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/oneErrortime/afst/internal/scanner"
 	"github.com/oneErrortime/afst/internal/storage"
 	"github.com/oneErrortime/afst/internal/worker"
 )
 
+var (
+	ErrFileContentInvalid = errors.New("file content does not match its type")
+	ErrFileInfected       = errors.New("file failed the malware scan")
+	ErrFileScanFailed     = errors.New("malware scan could not be completed")
+)
+
+// FileCheckOptions — проверки файла до записи в хранилище
+type FileCheckOptions struct {
+	// Limits — ограничения распаковки EPUB (защита от zip-бомб)
+	Limits storage.ValidationLimits
+	// Scanner — антивирус; nil — файлы не сканируются
+	Scanner scanner.Scanner
+}
+
+// DefaultFileCheckOptions — проверка структуры без антивируса
+func DefaultFileCheckOptions() FileCheckOptions {
+	return FileCheckOptions{Limits: storage.DefaultValidationLimits()}
+}
+
 type bookFileService struct {
-	fileRepo  repository.BookFileRepository
-	bookRepo  repository.BookRepository
+	fileRepo    repository.BookFileRepository
+	bookRepo    repository.BookRepository
+	textRepo    repository.BookTextRepository
 	fileStorage storage.FileStorage
-	processor *worker.FileProcessor
-	bus       *events.Bus
+	processor   *worker.FileProcessor
+	bus         *events.Bus
+	checks      FileCheckOptions
+	// extendedRepo — для записи book.uploaded через outbox
+	extendedRepo *repository.ExtendedRepository
 }
 
 func NewBookFileService(
 	fileRepo repository.BookFileRepository,
 	bookRepo repository.BookRepository,
+	textRepo repository.BookTextRepository,
 	fileStorage storage.FileStorage,
+	checks FileCheckOptions,
 ) BookFileService {
 	return &bookFileService{
 		fileRepo:    fileRepo,
 		bookRepo:    bookRepo,
+		textRepo:    textRepo,
 		fileStorage: fileStorage,
+		checks:      checks,
 		// processor and bus start as nil; wire via NewBookFileServiceWithWorker
 	}
 }
 
-// NewBookFileServiceWithWorker wires in the async processor and event bus
+// NewBookFileServiceWithWorker wires in the async processor and event bus.
+// book.uploaded is written to the outbox in the same transaction as the file
+// record when extendedRepo has one.
 func NewBookFileServiceWithWorker(
-	fileRepo repository.BookFileRepository,
-	bookRepo repository.BookRepository,
+	extendedRepo *repository.ExtendedRepository,
 	fileStorage storage.FileStorage,
 	processor *worker.FileProcessor,
 	bus *events.Bus,
+	checks FileCheckOptions,
 ) BookFileService {
 	return &bookFileService{
-		fileRepo:    fileRepo,
-		bookRepo:    bookRepo,
-		fileStorage: fileStorage,
-		processor:   processor,
-		bus:         bus,
+		fileRepo:     extendedRepo.BookFile,
+		bookRepo:     extendedRepo.Book,
+		textRepo:     extendedRepo.BookText,
+		fileStorage:  fileStorage,
+		processor:    processor,
+		bus:          bus,
+		checks:       checks,
+		extendedRepo: extendedRepo,
 	}
 }
 
@@ -59,16 +96,23 @@ func (s *bookFileService) Upload(bookID uuid.UUID, file multipart.File, header *
 		return nil, errors.New("книга не найдена")
 	}
 
-	result, err := s.fileStorage.Upload(file, header)
+	// Пока файл не проверен, он остаётся только во временном файле запроса
+	// (или загрузки tus): в хранилище и в выдачу попадает лишь прошедший проверку
+	if err := s.check(file, header.Filename); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	result, err := s.fileStorage.Upload(file, header.Filename)
 	if err != nil {
 		return nil, err
 	}
 
-	existing, _ := s.fileRepo.GetByHash(result.Hash)
-	if existing != nil && existing.BookID == bookID {
-		if deleteErr := s.fileStorage.Delete(result.FilePath); deleteErr != nil {
-			_ = deleteErr // файл может отсутствовать, игнорируем
-		}
+	// Файл лежит по хешу содержимого и может принадлежать другим книгам,
+	// поэтому здесь он не удаляется; копию без ссылок уберёт сборщик мусора
+	if _, err := s.fileRepo.GetByBookAndHash(bookID, result.Hash); err == nil {
 		return nil, errors.New("файл с таким содержимым уже загружен для этой книги")
 	}
 
@@ -78,11 +122,10 @@ func (s *bookFileService) Upload(bookID uuid.UUID, file multipart.File, header *
 		fileType = models.FileTypeEPUB
 
 		// Попробуем извлечь метаданные из EPUB
-		f, err := os.Open(result.FilePath)
+		f, err := s.fileStorage.Get(result.FilePath)
 		if err == nil {
 			defer func() { _ = f.Close() }()
-			finfo, _ := f.Stat()
-			zipReader, err := zip.NewReader(f, finfo.Size())
+			zipReader, err := zip.NewReader(f, result.FileSize)
 			if err == nil {
 				epubBook, err := epubparser.OpenBook(zipReader)
 				if err == nil {
@@ -120,29 +163,33 @@ func (s *bookFileService) Upload(bookID uuid.UUID, file multipart.File, header *
 		IsProcessed:  false, // will be updated by the worker
 	}
 
-	if err := s.fileRepo.Create(bookFile); err != nil {
-		if delErr := s.fileStorage.Delete(result.FilePath); delErr != nil {
-			_ = delErr
-		}
+	if err := s.createFile(bookFile); err != nil {
 		return nil, err
 	}
 
-	// Publish upload event
-	if s.bus != nil {
-		s.bus.Publish(events.Event{
-			Type: events.EventBookUploaded,
-			Payload: events.BookUploadedPayload{
-				BookID:   bookID.String(),
-				FileID:   bookFile.ID.String(),
-				FilePath: result.FilePath,
-				FileType: string(fileType),
-			},
-		})
+	// Сборщик мусора мог удалить файл без ссылок между записью в хранилище и
+	// созданием ссылки — тогда записываем его заново
+	if !s.fileStorage.Exists(result.FilePath) {
+		_, err := file.Seek(0, io.SeekStart)
+		if err == nil {
+			_, err = s.fileStorage.Upload(file, header.Filename)
+		}
+		if err != nil {
+			_ = s.fileRepo.Delete(bookFile.ID)
+			return nil, err
+		}
+	}
+
+	// Без outbox событие о загрузке уходит в шину после записи
+	if s.bus != nil && !s.hasOutbox() {
+		s.bus.Publish(uploadedEvent(bookFile))
 	}
 
 	// Queue background processing (page counting, metadata)
 	if s.processor != nil {
-		s.processor.Enqueue(bookFile.ID, result.FilePath, string(fileType), bookID)
+		if err := s.processor.Enqueue(bookFile.ID, result.FilePath, string(fileType), bookID); err != nil {
+			log.Printf("[book_file] failed to queue processing for %s: %v", bookFile.ID, err)
+		}
 	} else {
 		// Synchronous fallback if no worker pool is wired
 		bookFile.IsProcessed = true
@@ -152,6 +199,84 @@ func (s *bookFileService) Upload(bookID uuid.UUID, file multipart.File, header *
 	return bookFile, nil
 }
 
+// check сверяет содержимое с расширением, проверяет структуру формата и
+// сканирует файл антивирусом. Недоступный антивирус — отказ: непроверенный
+// файл не принимается.
+func (s *bookFileService) check(file multipart.File, name string) error {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if err := storage.ValidateBook(file, size, name, s.checks.Limits); err != nil {
+		if errors.Is(err, storage.ErrContentMismatch) || errors.Is(err, storage.ErrMalformedBook) ||
+			errors.Is(err, storage.ErrArchiveLimits) {
+			return fmt.Errorf("%w: %v", ErrFileContentInvalid, err)
+		}
+		return err
+	}
+
+	if s.checks.Scanner == nil {
+		return nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	result, err := s.checks.Scanner.Scan(file)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrFileScanFailed, err)
+	}
+	if result.Infected {
+		log.Printf("[book_file] rejected %q: %s", name, result.Signature)
+		return fmt.Errorf("%w: %s", ErrFileInfected, result.Signature)
+	}
+	return nil
+}
+
+// blobRetries — сколько раз создание записи ждёт сборщик мусора
+const blobRetries = 5
+
+// createFile создаёт запись BookFile. Если сборщик мусора как раз удаляет
+// файл с тем же содержимым, запись подождёт, пока он закончит.
+func (s *bookFileService) createFile(file *models.BookFile) error {
+	for attempt := 1; ; attempt++ {
+		err := s.insertFile(file)
+		if !errors.Is(err, repository.ErrBlobCollecting) || attempt == blobRetries {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * 50 * time.Millisecond)
+	}
+}
+
+// insertFile записывает BookFile; при подключённом outbox book.uploaded
+// пишется в той же транзакции и не теряется после коммита
+func (s *bookFileService) insertFile(file *models.BookFile) error {
+	if !s.hasOutbox() {
+		return s.fileRepo.Create(file)
+	}
+	return gormrepo.WithTransaction(s.extendedRepo, func(txRepo *repository.ExtendedRepository) error {
+		if err := txRepo.BookFile.Create(file); err != nil {
+			return err
+		}
+		return txRepo.Outbox.Enqueue(uploadedEvent(file))
+	})
+}
+
+func (s *bookFileService) hasOutbox() bool {
+	return s.extendedRepo != nil && s.extendedRepo.Outbox != nil
+}
+
+func uploadedEvent(file *models.BookFile) events.Event {
+	return events.Event{
+		Type: events.EventBookUploaded,
+		Payload: events.BookUploadedPayload{
+			BookID:   file.BookID.String(),
+			FileID:   file.ID.String(),
+			FilePath: file.FilePath,
+			FileType: string(file.FileType),
+		},
+	}
+}
+
 func (s *bookFileService) GetByID(id uuid.UUID) (*models.BookFile, error) {
 	return s.fileRepo.GetByID(id)
 }
@@ -161,14 +286,27 @@ func (s *bookFileService) GetByBookID(bookID uuid.UUID) ([]models.BookFile, erro
 }
 
 func (s *bookFileService) Delete(id uuid.UUID) error {
-	file, err := s.fileRepo.GetByID(id)
-	if err != nil {
+	if _, err := s.fileRepo.GetByID(id); err != nil {
 		return err
 	}
 
-	// Не возвращаем ошибку удаления файла, т.к. файл может быть уже удален
-	_ = s.fileStorage.Delete(file.FilePath)
+	// Сконвертированные копии (EPUB из MOBI) без исходника не нужны
+	if derived, err := s.fileRepo.GetDerived(id); err == nil {
+		for _, d := range derived {
+			if err := s.Delete(d.ID); err != nil {
+				log.Printf("[book_file] failed to delete converted file %s: %v", d.ID, err)
+			}
+		}
+	}
+
+	if s.textRepo != nil {
+		if err := s.textRepo.DeleteFile(id); err != nil {
+			log.Printf("[book_file] failed to drop text index of %s: %v", id, err)
+		}
+	}
 
+	// Сам файл может использоваться другими записями: удаление записи снимает
+	// ссылку, а файл без ссылок уберёт сборщик мусора
 	return s.fileRepo.Delete(id)
 }
 
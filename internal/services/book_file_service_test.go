@@ -0,0 +1,120 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/oneErrortime/afst/internal/events"
+	"github.com/oneErrortime/afst/internal/models"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/oneErrortime/afst/internal/scanner"
+	"github.com/oneErrortime/afst/internal/storage"
+	"github.com/oneErrortime/afst/internal/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// stubScanner находит «вирус» по подстроке или отвечает ошибкой
+type stubScanner struct {
+	err     error
+	scanned int
+}
+
+func (s *stubScanner) Scan(r io.Reader) (*scanner.Result, error) {
+	s.scanned++
+	if s.err != nil {
+		return nil, s.err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(string(data), "EICAR") {
+		return &scanner.Result{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+	}
+	return &scanner.Result{}, nil
+}
+
+func TestBookFileService_UploadChecks(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Book{}, &models.BookFile{}, &models.Blob{}))
+	repos := gormrepo.NewExtendedRepository(db)
+
+	fileStorage := storage.NewMemoryStorage()
+	scan := &stubScanner{}
+	checks := DefaultFileCheckOptions()
+	checks.Scanner = scan
+	bookFiles := NewBookFileService(repos.BookFile, repos.Book, nil, fileStorage, checks)
+
+	book := &models.Book{Title: "Обломов", Author: "Гончаров"}
+	require.NoError(t, db.Create(book).Error)
+	upload := func(name, content string) (*models.BookFile, error) {
+		return bookFiles.Upload(book.ID, memoryFile{strings.NewReader(content)}, &multipart.FileHeader{Filename: name})
+	}
+	stored := func() int {
+		n := 0
+		require.NoError(t, fileStorage.Walk(func(string) error { n++; return nil }))
+		return n
+	}
+
+	_, err = upload("setup.pdf", "MZ\x90\x00 исполняемый файл")
+	assert.ErrorIs(t, err, ErrFileContentInvalid)
+	_, err = upload("book.pdf", "%PDF-1.4 без трейлера")
+	assert.ErrorIs(t, err, ErrFileContentInvalid)
+	assert.Equal(t, 0, scan.scanned, "malformed files are not sent to the scanner")
+
+	_, err = upload("book.pdf", "%PDF-1.4 EICAR"+testPDFTrailer)
+	assert.ErrorIs(t, err, ErrFileInfected)
+	scan.err = scanner.ErrUnavailable
+	_, err = upload("book.pdf", "%PDF-1.4 чистая книга"+testPDFTrailer)
+	assert.ErrorIs(t, err, ErrFileScanFailed, "an unavailable scanner rejects the upload")
+	assert.Equal(t, 0, stored(), "rejected files never reach storage")
+
+	scan.err = nil
+	file, err := upload("book.pdf", "%PDF-1.4 чистая книга"+testPDFTrailer)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("%PDF-1.4 чистая книга"+testPDFTrailer)), file.FileSize, "the scan does not eat the stream")
+	assert.Equal(t, 1, stored())
+}
+
+func TestBookFileService_UploadWritesEventToOutbox(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Book{}, &models.BookFile{}, &models.Blob{}, &models.Job{}, &models.OutboxEvent{}))
+	repos := gormrepo.NewExtendedRepository(db)
+
+	bus := events.NewBus(16)
+	sub := bus.Subscribe(t.Context(), events.EventBookUploaded)
+	fileStorage := storage.NewMemoryStorage()
+	processor := worker.NewFileProcessor(worker.NewQueue("files", repos.Job, worker.QueueConfig{}),
+		repos.BookFile, repos.Book, nil, fileStorage, bus)
+	bookFiles := NewBookFileServiceWithWorker(repos, fileStorage, processor, bus, DefaultFileCheckOptions())
+
+	book := &models.Book{Title: "Обломов", Author: "Гончаров"}
+	require.NoError(t, db.Create(book).Error)
+	file, err := bookFiles.Upload(book.ID, memoryFile{strings.NewReader("%PDF-1.4 книга" + testPDFTrailer)},
+		&multipart.FileHeader{Filename: "book.pdf"})
+	require.NoError(t, err)
+
+	pending, err := repos.Outbox.GetPending(10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, string(events.EventBookUploaded), pending[0].Type)
+	var payload events.BookUploadedPayload
+	require.NoError(t, json.Unmarshal([]byte(pending[0].Payload), &payload))
+	assert.Equal(t, file.ID.String(), payload.FileID)
+	assert.Equal(t, book.ID.String(), payload.BookID)
+	assert.Equal(t, string(models.FileTypePDF), payload.FileType)
+	assert.Empty(t, sub, "the event reaches the bus only through the outbox relay")
+}
@@ -14,7 +14,13 @@ func NewBookmarkService(repo repository.BookmarkRepository) BookmarkService {
 	return &bookmarkService{repo: repo}
 }
 
+// CreateBookmark приводит место закладки к локатору (см. models.ResolveLocation)
 func (s *bookmarkService) CreateBookmark(bookmark *models.Bookmark) error {
+	loc, location, err := models.ResolveLocation(&bookmark.Locator, bookmark.Location)
+	if err != nil {
+		return err
+	}
+	bookmark.Locator, bookmark.Location = loc, location
 	return s.repo.Create(bookmark)
 }
 
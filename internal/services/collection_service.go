@@ -7,15 +7,35 @@ import (
 )
 
 type collectionService struct {
-	repo repository.CollectionRepository
+	repo         repository.CollectionRepository
+	activityRepo repository.ActivityRepository
 }
 
-func NewCollectionService(repo repository.CollectionRepository) CollectionService {
-	return &collectionService{repo: repo}
+func NewCollectionService(repo repository.CollectionRepository, activityRepo repository.ActivityRepository) CollectionService {
+	return &collectionService{repo: repo, activityRepo: activityRepo}
 }
 
 func (s *collectionService) CreateCollection(collection *models.Collection) error {
-	return s.repo.Create(collection)
+	if err := s.repo.Create(collection); err != nil {
+		return err
+	}
+	s.syncActivity(collection)
+	return nil
+}
+
+// syncActivity — в ленте только открытые коллекции: закрытая коллекция
+// пропадает из ленты, а снова открытая возвращается
+func (s *collectionService) syncActivity(collection *models.Collection) {
+	if !collection.IsPublic {
+		forgetActivity(s.activityRepo, collection.UserID, models.ActivityCollectionPublished, collection.ID)
+		return
+	}
+	recordActivity(s.activityRepo, &models.Activity{
+		UserID:    collection.UserID,
+		Type:      models.ActivityCollectionPublished,
+		SubjectID: collection.ID,
+		Title:     collection.Name,
+	})
 }
 
 func (s *collectionService) GetCollectionsByUserID(userID uuid.UUID) ([]models.Collection, error) {
@@ -27,11 +47,23 @@ func (s *collectionService) GetCollectionByID(id uuid.UUID) (*models.Collection,
 }
 
 func (s *collectionService) UpdateCollection(collection *models.Collection) error {
-	return s.repo.Update(collection)
+	if err := s.repo.Update(collection); err != nil {
+		return err
+	}
+	s.syncActivity(collection)
+	return nil
 }
 
 func (s *collectionService) DeleteCollection(id uuid.UUID) error {
-	return s.repo.Delete(id)
+	collection, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Delete(id); err != nil {
+		return err
+	}
+	forgetActivity(s.activityRepo, collection.UserID, models.ActivityCollectionPublished, id)
+	return nil
 }
 
 func (s *collectionService) AddBookToCollection(collectionID, bookID uuid.UUID) error {
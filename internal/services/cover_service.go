@@ -0,0 +1,302 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+	"github.com/oneErrortime/afst/internal/storage"
+	"github.com/oneErrortime/afst/internal/worker"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCoverNotFound     = errors.New("cover not found")
+	ErrCoverBookNotFound = errors.New("book not found")
+	ErrCoverBadSize      = errors.New("unknown cover size")
+	ErrCoverInvalid      = errors.New("cover must be a JPEG, PNG or WebP image")
+)
+
+// coverExtensions — расширения оригиналов в хранилище по типу содержимого
+var coverExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// CoverImage — открытый файл обложки для отдачи клиенту
+type CoverImage struct {
+	io.ReadCloser
+	Size        int64
+	ContentType string
+	ETag        string
+	ModTime     time.Time
+	// Provisional — вместо миниатюры отдан оригинал, кэшировать надолго нельзя
+	Provisional bool
+}
+
+// CoverService — обложки книг: ручная загрузка, извлечение из файлов книги и миниатюры.
+type CoverService interface {
+	// Upload сохраняет обложку, загруженную библиотекарем; она имеет приоритет над извлечённой.
+	Upload(bookID uuid.UUID, file multipart.File, header *multipart.FileHeader) (*models.BookCover, error)
+	// SetExtractedCover сохраняет обложку из EPUB/PDF/MOBI, если вручную загруженной нет.
+	SetExtractedCover(bookID uuid.UUID, data []byte, source models.CoverSource) error
+	Get(bookID uuid.UUID) (*models.BookCover, error)
+	// Open открывает оригинал или миниатюру; пока миниатюры не готовы, отдаётся оригинал.
+	Open(bookID uuid.UUID, size string) (*CoverImage, error)
+	Delete(bookID uuid.UUID) error
+}
+
+type coverService struct {
+	repo        repository.BookCoverRepository
+	bookRepo    repository.BookRepository
+	fileStorage storage.FileStorage
+	pool        *worker.Pool
+}
+
+// NewCoverService создаёт сервис обложек. Если pool == nil,
+// миниатюры строятся синхронно при сохранении обложки.
+func NewCoverService(
+	repo repository.BookCoverRepository,
+	bookRepo repository.BookRepository,
+	fileStorage storage.FileStorage,
+	pool *worker.Pool,
+) CoverService {
+	return &coverService{
+		repo:        repo,
+		bookRepo:    bookRepo,
+		fileStorage: fileStorage,
+		pool:        pool,
+	}
+}
+
+func (s *coverService) Upload(bookID uuid.UUID, file multipart.File, header *multipart.FileHeader) (*models.BookCover, error) {
+	if header.Size > worker.MaxCoverBytes {
+		return nil, ErrCoverInvalid
+	}
+	data, err := io.ReadAll(io.LimitReader(file, worker.MaxCoverBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	return s.save(bookID, data, models.CoverSourceUpload)
+}
+
+func (s *coverService) SetExtractedCover(bookID uuid.UUID, data []byte, source models.CoverSource) error {
+	existing, err := s.repo.Get(bookID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	// Обложку, выбранную библиотекарем, не трогаем
+	if existing != nil && existing.Source == models.CoverSourceUpload {
+		return nil
+	}
+	_, err = s.save(bookID, data, source)
+	return err
+}
+
+func (s *coverService) save(bookID uuid.UUID, data []byte, source models.CoverSource) (*models.BookCover, error) {
+	info, err := worker.InspectCover(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCoverInvalid, err)
+	}
+
+	book, err := s.bookRepo.GetByID(bookID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCoverBookNotFound
+		}
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	previous, err := s.repo.Get(bookID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if previous != nil && previous.Hash == hash {
+		// То же изображение: обновляем только источник
+		if previous.Source != source {
+			previous.Source = source
+			if err := s.repo.Save(previous); err != nil {
+				return nil, err
+			}
+		}
+		return previous, nil
+	}
+
+	cover := &models.BookCover{
+		BookID:      bookID,
+		Source:      source,
+		ContentType: info.ContentType,
+		Hash:        hash,
+		Width:       info.Width,
+		Height:      info.Height,
+		FileSize:    int64(len(data)),
+	}
+	cover.FilePath = cover.OriginalPath(coverExtensions[info.ContentType])
+
+	if _, err := s.fileStorage.Put(cover.FilePath, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Save(cover); err != nil {
+		_ = s.fileStorage.Delete(cover.FilePath)
+		return nil, err
+	}
+
+	coverURL := cover.CoverURL()
+	book.CoverURL = &coverURL
+	if err := s.bookRepo.Update(book); err != nil {
+		log.Printf("[cover] failed to set cover url for book %s: %v", bookID, err)
+	}
+
+	if previous != nil {
+		s.removeFiles(previous)
+	}
+	s.generateThumbnails(cover, data)
+	return cover, nil
+}
+
+// generateThumbnails строит миниатюры в пуле воркеров
+func (s *coverService) generateThumbnails(cover *models.BookCover, data []byte) {
+	job := worker.Job{
+		ID: "cover:" + cover.BookID.String(),
+		Execute: func(ctx context.Context) error {
+			thumbs, err := worker.GenerateThumbnails(data)
+			if err != nil {
+				return worker.Permanent(err)
+			}
+			for _, size := range worker.CoverSizes {
+				if _, err := s.fileStorage.Put(cover.ThumbnailPath(size.Name), bytes.NewReader(thumbs[size.Name])); err != nil {
+					return err
+				}
+			}
+			ok, err := s.repo.MarkThumbnailsReady(cover.BookID, cover.Hash)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				// Обложку заменили или удалили, пока строились миниатюры
+				s.removeThumbnails(cover)
+			}
+			return nil
+		},
+		OnDone: func(err error) {
+			if err != nil {
+				log.Printf("[cover] thumbnails for book %s failed: %v", cover.BookID, err)
+			}
+		},
+	}
+
+	if s.pool == nil {
+		job.OnDone(job.Execute(context.Background()))
+		return
+	}
+	if !s.pool.Submit(job) {
+		log.Printf("[cover] worker pool is shutting down, thumbnails for book %s skipped", cover.BookID)
+	}
+}
+
+func (s *coverService) Get(bookID uuid.UUID) (*models.BookCover, error) {
+	cover, err := s.repo.Get(bookID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCoverNotFound
+		}
+		return nil, err
+	}
+	return cover, nil
+}
+
+func (s *coverService) Open(bookID uuid.UUID, size string) (*CoverImage, error) {
+	if size == "" {
+		size = models.CoverSizeOriginal
+	}
+	if size != models.CoverSizeOriginal && !isCoverSize(size) {
+		return nil, ErrCoverBadSize
+	}
+
+	cover, err := s.Get(bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	path, contentType, etagSize := cover.FilePath, cover.ContentType, models.CoverSizeOriginal
+	provisional := false
+	if size != models.CoverSizeOriginal {
+		if cover.ThumbnailsReady {
+			path, contentType, etagSize = cover.ThumbnailPath(size), "image/jpeg", size
+		} else {
+			provisional = true
+		}
+	}
+
+	f, err := s.fileStorage.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return &CoverImage{
+		ReadCloser:  f,
+		Size:        info.Size(),
+		ContentType: contentType,
+		ETag:        cover.ETag(etagSize),
+		ModTime:     cover.UpdatedAt,
+		Provisional: provisional,
+	}, nil
+}
+
+func (s *coverService) Delete(bookID uuid.UUID) error {
+	cover, err := s.Get(bookID)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Delete(bookID); err != nil {
+		return err
+	}
+	s.removeFiles(cover)
+
+	if book, err := s.bookRepo.GetByID(bookID); err == nil && book.CoverURL != nil {
+		book.CoverURL = nil
+		if err := s.bookRepo.Update(book); err != nil {
+			log.Printf("[cover] failed to clear cover url for book %s: %v", bookID, err)
+		}
+	}
+	return nil
+}
+
+func (s *coverService) removeFiles(cover *models.BookCover) {
+	_ = s.fileStorage.Delete(cover.FilePath)
+	s.removeThumbnails(cover)
+}
+
+func (s *coverService) removeThumbnails(cover *models.BookCover) {
+	for _, size := range worker.CoverSizes {
+		_ = s.fileStorage.Delete(cover.ThumbnailPath(size.Name))
+	}
+}
+
+func isCoverSize(name string) bool {
+	for _, size := range worker.CoverSizes {
+		if size.Name == name {
+			return true
+		}
+	}
+	return false
+}
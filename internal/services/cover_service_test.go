@@ -0,0 +1,107 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/oneErrortime/afst/internal/models"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/oneErrortime/afst/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func testCoverImage(w, h int, encode func(io.Writer, image.Image) error) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+	img.Set(0, 0, color.Black)
+	var buf bytes.Buffer
+	_ = encode(&buf, img)
+	return buf.Bytes()
+}
+
+func TestCoverService(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Book{}, &models.BookCover{}))
+	repos := gormrepo.NewExtendedRepository(db)
+	fileStorage := storage.NewLocalStorage(t.TempDir(), "")
+	svc := NewCoverService(repos.BookCover, repos.Book, fileStorage, nil)
+
+	book := &models.Book{Title: "Обломов", Author: "Гончаров"}
+	require.NoError(t, db.Create(book).Error)
+
+	_, err = svc.Open(book.ID, "")
+	assert.ErrorIs(t, err, ErrCoverNotFound)
+
+	jpegCover := testCoverImage(400, 600, func(w io.Writer, img image.Image) error { return jpeg.Encode(w, img, nil) })
+	require.NoError(t, svc.SetExtractedCover(book.ID, jpegCover, models.CoverSourceEPUB))
+
+	cover, err := svc.Get(book.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.CoverSourceEPUB, cover.Source)
+	assert.True(t, cover.ThumbnailsReady, "without a pool thumbnails are built synchronously")
+
+	got, err := repos.Book.GetByID(book.ID)
+	require.NoError(t, err)
+	require.NotNil(t, got.CoverURL)
+	assert.Equal(t, cover.CoverURL(), *got.CoverURL)
+
+	small, err := svc.Open(book.ID, "small")
+	require.NoError(t, err)
+	data, _ := io.ReadAll(small)
+	_ = small.Close()
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, 160, cfg.Width)
+	assert.Equal(t, "image/jpeg", small.ContentType)
+	assert.NotEqual(t, cover.ETag(models.CoverSizeOriginal), small.ETag)
+
+	_, err = svc.Open(book.ID, "huge")
+	assert.ErrorIs(t, err, ErrCoverBadSize)
+
+	// A manual upload replaces the extracted cover and its files
+	pngCover := testCoverImage(300, 300, png.Encode)
+	uploaded, err := svc.Upload(book.ID, nopMultipartFile{bytes.NewReader(pngCover)}, &multipart.FileHeader{Filename: "cover.png", Size: int64(len(pngCover))})
+	require.NoError(t, err)
+	assert.Equal(t, models.CoverSourceUpload, uploaded.Source)
+	assert.Equal(t, "image/png", uploaded.ContentType)
+	assert.False(t, fileStorage.Exists(cover.FilePath))
+	assert.False(t, fileStorage.Exists(cover.ThumbnailPath("small")))
+
+	// ...and is not overwritten by the next extraction
+	require.NoError(t, svc.SetExtractedCover(book.ID, jpegCover, models.CoverSourcePDF))
+	current, err := svc.Get(book.ID)
+	require.NoError(t, err)
+	assert.Equal(t, uploaded.Hash, current.Hash)
+
+	_, err = svc.Upload(book.ID, nopMultipartFile{bytes.NewReader([]byte("GIF89a"))}, &multipart.FileHeader{Filename: "cover.gif", Size: 6})
+	assert.ErrorIs(t, err, ErrCoverInvalid)
+
+	require.NoError(t, svc.Delete(book.ID))
+	assert.False(t, fileStorage.Exists(uploaded.FilePath))
+	got, err = repos.Book.GetByID(book.ID)
+	require.NoError(t, err)
+	assert.Nil(t, got.CoverURL)
+}
+
+// nopMultipartFile adapts a bytes.Reader to multipart.File
+type nopMultipartFile struct {
+	*bytes.Reader
+}
+
+func (nopMultipartFile) Close() error { return nil }
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/auth"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+)
+
+var (
+	ErrDownloadFileNotFound = errors.New("file not found")
+	ErrDownloadNoAccess     = errors.New("no access to this book")
+	ErrDownloadNotAllowed   = errors.New("downloads are not included in the plan or group")
+	ErrDownloadLimitReached = errors.New("download limit reached for the period")
+	ErrDownloadBadMode      = errors.New("unknown link mode")
+	ErrDownloadLinkInvalid  = errors.New("invalid or expired link")
+)
+
+// DownloadLimits — квоты скачиваний
+type DownloadLimits struct {
+	// Period — скользящее окно, за которое считаются скачивания
+	Period time.Duration
+	// GroupQuota — скачиваний за период для групп с CanDownload, < 0 — без ограничений.
+	// Если по подписке положено больше, действует лимит подписки.
+	GroupQuota int
+}
+
+// DefaultDownloadLimits — 30 дней, 5 скачиваний для групп с правом скачивания
+func DefaultDownloadLimits() DownloadLimits {
+	return DownloadLimits{Period: 30 * 24 * time.Hour, GroupQuota: 5}
+}
+
+// DownloadOptions — подпись ссылок и квоты скачиваний
+type DownloadOptions struct {
+	Signer *auth.LinkSigner
+	// BaseURL — адрес сервера для абсолютных ссылок; пусто — ссылки относительные
+	BaseURL string
+	Limits  DownloadLimits
+}
+
+// DownloadService выдаёт подписанные ссылки на файлы книг и ведёт квоты.
+// Просмотр (view) отдаётся inline и не учитывается; скачивание (download)
+// отдаётся как attachment и расходует квоту тарифа или группы — один раз на ссылку.
+type DownloadService interface {
+	// IssueLink проверяет доступ к книге и, для скачивания, остаток квоты
+	IssueLink(userID uuid.UUID, role models.UserRole, fileID uuid.UUID, mode models.DownloadMode) (*models.DownloadLinkDTO, error)
+	// Resolve проверяет подписанную ссылку и учитывает скачивание.
+	// Возвращает файл и пользователя, на которого выдана ссылка.
+	Resolve(fileID uuid.UUID, mode models.DownloadMode, query url.Values) (*models.BookFile, *models.User, error)
+	GetQuota(userID uuid.UUID, role models.UserRole) (*models.DownloadQuotaDTO, error)
+	// RecordDownload проверяет доступ, право на скачивание и квоту и учитывает
+	// скачивание файла, отданного без ссылки (офлайн-пакет). Каждый вызов —
+	// отдельное скачивание.
+	RecordDownload(user *models.User, file *models.BookFile) error
+}
+
+type downloadService struct {
+	repo             repository.DownloadRepository
+	fileRepo         repository.BookFileRepository
+	userRepo         repository.UserRepository
+	subscriptionRepo repository.SubscriptionRepository
+	groupRepo        repository.UserGroupRepository
+	access           BookAccessService
+	signer           *auth.LinkSigner
+	baseURL          string
+	limits           DownloadLimits
+}
+
+func NewDownloadService(repos *repository.ExtendedRepository, access BookAccessService, opts DownloadOptions) DownloadService {
+	return &downloadService{
+		repo:             repos.Download,
+		fileRepo:         repos.BookFile,
+		userRepo:         repos.User,
+		subscriptionRepo: repos.Subscription,
+		groupRepo:        repos.UserGroup,
+		access:           access,
+		signer:           opts.Signer,
+		baseURL:          strings.TrimRight(opts.BaseURL, "/"),
+		limits:           opts.Limits,
+	}
+}
+
+func (s *downloadService) IssueLink(userID uuid.UUID, role models.UserRole, fileID uuid.UUID, mode models.DownloadMode) (*models.DownloadLinkDTO, error) {
+	if mode != models.DownloadModeView && mode != models.DownloadModeDownload {
+		return nil, ErrDownloadBadMode
+	}
+	file, err := s.fileRepo.GetByID(fileID)
+	if err != nil {
+		return nil, ErrDownloadFileNotFound
+	}
+	if err := s.checkAccess(userID, role, file.BookID); err != nil {
+		return nil, err
+	}
+
+	// Квота проверяется заранее, чтобы не выдавать заведомо бесполезную ссылку;
+	// расходуется она при переходе по ссылке
+	if mode == models.DownloadModeDownload {
+		quota, err := s.GetQuota(userID, role)
+		if err != nil {
+			return nil, err
+		}
+		if quota.Limit == 0 {
+			return nil, ErrDownloadNotAllowed
+		}
+		if quota.Limit > 0 && quota.Remaining <= 0 {
+			return nil, ErrDownloadLimitReached
+		}
+	}
+
+	claims := &auth.LinkClaims{FileID: file.ID, UserID: userID, Mode: string(mode)}
+	query := s.signer.Sign(claims)
+
+	path := file.GetPublicURL()
+	if mode == models.DownloadModeDownload {
+		path = file.GetDownloadURL()
+	}
+	return &models.DownloadLinkDTO{
+		URL:       s.baseURL + path + "?" + query.Encode(),
+		Mode:      mode,
+		ExpiresAt: claims.ExpiresAt,
+	}, nil
+}
+
+func (s *downloadService) Resolve(fileID uuid.UUID, mode models.DownloadMode, query url.Values) (*models.BookFile, *models.User, error) {
+	claims, err := s.signer.Verify(fileID, string(mode), query, time.Now())
+	if err != nil {
+		return nil, nil, ErrDownloadLinkInvalid
+	}
+	file, err := s.fileRepo.GetByID(fileID)
+	if err != nil {
+		return nil, nil, ErrDownloadFileNotFound
+	}
+
+	// Роль берётся из базы: за время жизни ссылки доступ могли отозвать
+	user, err := s.userRepo.GetByID(claims.UserID)
+	if err != nil || !user.IsActive {
+		return nil, nil, ErrDownloadLinkInvalid
+	}
+	if err := s.checkAccess(user.ID, user.Role, file.BookID); err != nil {
+		return nil, nil, err
+	}
+	if mode == models.DownloadModeView {
+		return file, user, nil
+	}
+
+	if err := s.record(user, file, claims.LinkID); err != nil {
+		return nil, nil, err
+	}
+	return file, user, nil
+}
+
+func (s *downloadService) RecordDownload(user *models.User, file *models.BookFile) error {
+	if err := s.checkAccess(user.ID, user.Role, file.BookID); err != nil {
+		return err
+	}
+	return s.record(user, file, uuid.New())
+}
+
+// record учитывает скачивание по linkID в пределах квоты; повтор того же
+// linkID не учитывается
+func (s *downloadService) record(user *models.User, file *models.BookFile, linkID uuid.UUID) error {
+	limit, err := s.limitFor(user.ID, user.Role)
+	if err != nil {
+		return err
+	}
+	if limit == 0 {
+		return ErrDownloadNotAllowed
+	}
+	_, err = s.repo.Record(&models.BookDownload{
+		UserID: user.ID,
+		BookID: file.BookID,
+		FileID: file.ID,
+		LinkID: linkID,
+	}, time.Now().Add(-s.limits.Period), limit)
+	if errors.Is(err, repository.ErrDownloadLimitReached) {
+		return ErrDownloadLimitReached
+	}
+	return err
+}
+
+func (s *downloadService) GetQuota(userID uuid.UUID, role models.UserRole) (*models.DownloadQuotaDTO, error) {
+	limit, err := s.limitFor(userID, role)
+	if err != nil {
+		return nil, err
+	}
+	since := time.Now().Add(-s.limits.Period)
+	used, err := s.repo.CountByUserSince(userID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	quota := &models.DownloadQuotaDTO{Limit: limit, Used: used, Remaining: -1, PeriodStart: since}
+	if limit >= 0 {
+		quota.Remaining = max(int64(limit)-used, 0)
+	}
+	return quota, nil
+}
+
+func (s *downloadService) checkAccess(userID uuid.UUID, role models.UserRole, bookID uuid.UUID) error {
+	if role == models.RoleAdmin || role == models.RoleLibrarian {
+		return nil
+	}
+	ok, err := s.access.CheckAccess(userID, bookID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrDownloadNoAccess
+	}
+	return nil
+}
+
+// limitFor — скачиваний за период: -1 без ограничений, 0 — скачивание запрещено.
+// Действует больший из лимитов активной подписки и группы.
+func (s *downloadService) limitFor(userID uuid.UUID, role models.UserRole) (int, error) {
+	if role == models.RoleAdmin || role == models.RoleLibrarian {
+		return -1, nil
+	}
+
+	limit := 0
+	if sub, err := s.subscriptionRepo.GetActiveByUserID(userID); err == nil && sub.IsValid() {
+		limit = sub.MaxDownloads
+	}
+
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return 0, err
+	}
+	if user.GroupID != nil {
+		group, err := s.groupRepo.GetByID(*user.GroupID)
+		if err == nil && group.IsActive && group.CanDownload {
+			if s.limits.GroupQuota < 0 {
+				return -1, nil
+			}
+			limit = max(limit, s.limits.GroupQuota)
+		}
+	}
+	return limit, nil
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/auth"
+	"github.com/oneErrortime/afst/internal/models"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestDownloadService_LinksAndQuota(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.UserGroup{}, &models.User{}, &models.Book{}, &models.BookFile{},
+		&models.Subscription{}, &models.BookAccess{}, &models.BookDownload{}))
+	repos := gormrepo.NewExtendedRepository(db)
+	access := NewBookAccessService(repos.BookAccess, repos.Book, repos.User, repos.Subscription, repos.UserGroup)
+	svc := NewDownloadService(repos, access, DownloadOptions{
+		Signer:  auth.NewLinkSigner("secret", time.Minute),
+		BaseURL: "https://library.example/",
+		Limits:  DownloadLimits{Period: 24 * time.Hour, GroupQuota: 2},
+	})
+
+	students := &models.UserGroup{Name: "Студенты", Type: models.GroupTypeStudent, CanDownload: true, IsActive: true}
+	require.NoError(t, db.Create(students).Error)
+	reader := &models.User{Email: "reader@example.com", Name: "Reader", Role: models.RoleReader, IsActive: true, GroupID: &students.ID}
+	stranger := &models.User{Email: "stranger@example.com", Name: "Stranger", Role: models.RoleReader, IsActive: true}
+	require.NoError(t, db.Create(reader).Error)
+	require.NoError(t, db.Create(stranger).Error)
+
+	book := &models.Book{Title: "Мастер и Маргарита", Author: "Булгаков"}
+	require.NoError(t, db.Create(book).Error)
+	file := &models.BookFile{BookID: book.ID, FileName: "book.pdf", OriginalName: "Мастер.pdf", FilePath: "blobs/ab/ab",
+		FileType: models.FileTypePDF, FileSize: 10, MimeType: "application/pdf", Hash: "ab"}
+	require.NoError(t, db.Create(file).Error)
+	now := time.Now()
+	for _, u := range []*models.User{reader, stranger} {
+		require.NoError(t, db.Create(&models.BookAccess{UserID: u.ID, BookID: book.ID, Type: models.AccessTypeLoan,
+			Status: models.AccessStatusActive, StartDate: now, EndDate: now.Add(24 * time.Hour)}).Error)
+	}
+
+	resolve := func(link *models.DownloadLinkDTO) (*models.BookFile, error) {
+		f, _, err := svc.Resolve(file.ID, link.Mode, mustQuery(t, link.URL))
+		return f, err
+	}
+
+	view, err := svc.IssueLink(reader.ID, reader.Role, file.ID, models.DownloadModeView)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(view.URL, "https://library.example/api/v1/files/"+file.ID.String()+"/view?"))
+	_, err = resolve(view)
+	require.NoError(t, err)
+
+	_, _, err = svc.Resolve(file.ID, models.DownloadModeDownload, mustQuery(t, view.URL))
+	assert.ErrorIs(t, err, ErrDownloadLinkInvalid, "a view link does not grant a download")
+
+	first, err := svc.IssueLink(reader.ID, reader.Role, file.ID, models.DownloadModeDownload)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = resolve(first)
+		require.NoError(t, err, "resuming the same link is not counted again")
+	}
+	second, err := svc.IssueLink(reader.ID, reader.Role, file.ID, models.DownloadModeDownload)
+	require.NoError(t, err)
+	_, err = resolve(second)
+	require.NoError(t, err)
+
+	quota, err := svc.GetQuota(reader.ID, reader.Role)
+	require.NoError(t, err)
+	assert.Equal(t, 2, quota.Limit)
+	assert.EqualValues(t, 2, quota.Used)
+	assert.Zero(t, quota.Remaining)
+
+	_, err = svc.IssueLink(reader.ID, reader.Role, file.ID, models.DownloadModeDownload)
+	assert.ErrorIs(t, err, ErrDownloadLimitReached)
+
+	var stored models.Book
+	require.NoError(t, db.First(&stored, "id = ?", book.ID).Error)
+	assert.Equal(t, 2, stored.DownloadCount)
+
+	// Подписка расширяет квоту группы
+	require.NoError(t, db.Create(&models.Subscription{UserID: reader.ID, Plan: models.PlanPremium, Status: models.SubStatusActive,
+		StartDate: now, EndDate: now.AddDate(0, 1, 0), MaxDownloads: 3}).Error)
+	third, err := svc.IssueLink(reader.ID, reader.Role, file.ID, models.DownloadModeDownload)
+	require.NoError(t, err)
+	_, err = resolve(third)
+	require.NoError(t, err)
+
+	_, err = svc.IssueLink(stranger.ID, stranger.Role, file.ID, models.DownloadModeDownload)
+	assert.ErrorIs(t, err, ErrDownloadNotAllowed)
+	_, err = svc.IssueLink(stranger.ID, stranger.Role, file.ID, models.DownloadModeView)
+	assert.NoError(t, err, "viewing needs only access to the book")
+
+	_, err = svc.IssueLink(uuid.New(), models.RoleReader, file.ID, models.DownloadModeView)
+	assert.ErrorIs(t, err, ErrDownloadNoAccess)
+}
+
+func TestLinkSigner_RejectsTamperedAndExpired(t *testing.T) {
+	signer := auth.NewLinkSigner("secret", time.Minute)
+	fileID := uuid.New()
+	claims := &auth.LinkClaims{FileID: fileID, UserID: uuid.New(), Mode: "download"}
+	q := signer.Sign(claims)
+
+	got, err := signer.Verify(fileID, "download", q, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, claims.UserID, got.UserID)
+	assert.Equal(t, claims.LinkID, got.LinkID)
+
+	_, err = signer.Verify(uuid.New(), "download", q, time.Now())
+	assert.ErrorIs(t, err, auth.ErrLinkInvalid)
+
+	forged := url.Values{}
+	for k, v := range q {
+		forged[k] = v
+	}
+	forged.Set("uid", uuid.New().String())
+	_, err = signer.Verify(fileID, "download", forged, time.Now())
+	assert.ErrorIs(t, err, auth.ErrLinkInvalid)
+
+	_, err = auth.NewLinkSigner("other", time.Minute).Verify(fileID, "download", q, time.Now())
+	assert.ErrorIs(t, err, auth.ErrLinkInvalid)
+
+	_, err = signer.Verify(fileID, "download", q, time.Now().Add(2*time.Minute))
+	assert.ErrorIs(t, err, auth.ErrLinkExpired)
+}
+
+func mustQuery(t *testing.T, raw string) url.Values {
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	return u.Query()
+}
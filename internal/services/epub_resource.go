@@ -0,0 +1,157 @@
+package services
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+)
+
+var (
+	ErrNotEPUB              = errors.New("file is not an epub")
+	ErrEPUBResourceNotFound = errors.New("epub resource not found")
+)
+
+// epubContentTypes — типы, которых нет (или они другие) в системной таблице mime
+var epubContentTypes = map[string]string{
+	".xhtml": "application/xhtml+xml",
+	".html":  "application/xhtml+xml",
+	".htm":   "application/xhtml+xml",
+	".css":   "text/css; charset=utf-8",
+	".ncx":   "application/x-dtbncx+xml",
+	".opf":   "application/oebps-package+xml",
+	".svg":   "image/svg+xml",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".png":   "image/png",
+	".gif":   "image/gif",
+	".webp":  "image/webp",
+	".otf":   "font/otf",
+	".ttf":   "font/ttf",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".smil":  "application/smil+xml",
+	".mp3":   "audio/mpeg",
+	".js":    "application/javascript",
+}
+
+// EPUBResource — открытый файл из архива EPUB. Close закрывает и архив.
+type EPUBResource struct {
+	io.ReadCloser
+	Name        string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+	// ETag не меняется, пока не изменился файл книги
+	ETag string
+}
+
+func (s *bookFileService) OpenEPUBResource(id uuid.UUID, resourcePath string) (*EPUBResource, error) {
+	file, err := s.fileRepo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if file.FileType != models.FileTypeEPUB {
+		return nil, ErrNotEPUB
+	}
+
+	name, ok := cleanEPUBPath(resourcePath)
+	if !ok {
+		return nil, ErrEPUBResourceNotFound
+	}
+
+	f, err := s.fileStorage.Get(file.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("invalid epub archive: %w", err)
+	}
+
+	for _, entry := range zr.File {
+		if entry.Name != name || entry.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+
+		modTime := entry.Modified
+		if modTime.IsZero() {
+			modTime = file.UpdatedAt
+		}
+		return &EPUBResource{
+			ReadCloser:  &epubEntryReader{ReadCloser: rc, archive: f},
+			Name:        name,
+			Size:        int64(entry.UncompressedSize64),
+			ModTime:     modTime,
+			ContentType: epubContentType(name),
+			ETag:        epubResourceETag(file.Hash, name, entry.CRC32),
+		}, nil
+	}
+
+	_ = f.Close()
+	return nil, ErrEPUBResourceNotFound
+}
+
+// cleanEPUBPath нормализует путь из URL в имя записи архива и отбрасывает выход за корень
+func cleanEPUBPath(p string) (string, bool) {
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		return "", false
+	}
+	cleaned := path.Clean(p)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", false
+	}
+	return cleaned, true
+}
+
+func epubContentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ct, ok := epubContentTypes[ext]; ok {
+		return ct
+	}
+	if ct := mime.TypeByExtension(ext); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+func epubResourceETag(fileHash, name string, crc uint32) string {
+	h := crc32.NewIEEE()
+	_, _ = h.Write([]byte(fileHash))
+	_, _ = h.Write([]byte(name))
+	return fmt.Sprintf(`"%08x-%08x"`, h.Sum32(), crc)
+}
+
+// epubEntryReader закрывает запись и файл архива вместе
+type epubEntryReader struct {
+	io.ReadCloser
+	archive io.Closer
+}
+
+func (r *epubEntryReader) Close() error {
+	err := r.ReadCloser.Close()
+	if cerr := r.archive.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
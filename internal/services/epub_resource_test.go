@@ -0,0 +1,32 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanEPUBPath(t *testing.T) {
+	cases := map[string]string{
+		"/OEBPS/text/ch1.xhtml":   "OEBPS/text/ch1.xhtml",
+		"OEBPS//images/./a.png":   "OEBPS/images/a.png",
+		"OEBPS/text/../style.css": "OEBPS/style.css",
+	}
+	for in, want := range cases {
+		got, ok := cleanEPUBPath(in)
+		assert.True(t, ok, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{"", "/", "..", "../etc/passwd", "/OEBPS/../../secret"} {
+		_, ok := cleanEPUBPath(in)
+		assert.False(t, ok, in)
+	}
+}
+
+func TestEPUBContentType(t *testing.T) {
+	assert.Equal(t, "application/xhtml+xml", epubContentType("OEBPS/ch1.XHTML"))
+	assert.Equal(t, "font/woff2", epubContentType("fonts/a.woff2"))
+	assert.Equal(t, "application/x-dtbncx+xml", epubContentType("toc.ncx"))
+	assert.Equal(t, "application/octet-stream", epubContentType("META-INF/unknown.zzz"))
+}
@@ -0,0 +1,595 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/events"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+)
+
+var (
+	ErrGoalNotFound         = errors.New("goal not found")
+	ErrChallengeNotFound    = errors.New("challenge not found")
+	ErrChallengeEnded       = errors.New("challenge has ended")
+	ErrChallengeJoined      = errors.New("already joined the challenge")
+	ErrChallengeNotJoined   = errors.New("not a participant of the challenge")
+	ErrChallengeInvalidDate = errors.New("end_date must be after start_date")
+)
+
+const (
+	// goalStreakLimit — сколько периодов назад просматривается серия
+	goalStreakLimit = 366
+	// streakWarnHour — с какого часа (по времени цели) предупреждать, что
+	// дневная серия вот-вот прервётся
+	streakWarnHour = 20
+)
+
+// GoalService — личные цели чтения и челленджи клуба. Прогресс считается по
+// завершённым сессиям чтения (минуты и страницы) и дочитанным книгам.
+// О достижении цели и о риске прервать дневную серию сообщает шина событий:
+// goal.reached — один раз за период, streak.at_risk — один раз за день.
+type GoalService interface {
+	CreateGoal(userID uuid.UUID, dto *models.CreateGoalDTO) (*models.ReadingGoal, error)
+	// ListGoals возвращает цели пользователя с прогрессом в текущем периоде
+	ListGoals(userID uuid.UUID) ([]models.GoalProgressDTO, error)
+	UpdateGoal(userID, id uuid.UUID, dto *models.UpdateGoalDTO) (*models.ReadingGoal, error)
+	DeleteGoal(userID, id uuid.UUID) error
+
+	CreateChallenge(userID uuid.UUID, dto *models.CreateChallengeDTO) (*models.ReadingChallenge, error)
+	// ListChallenges возвращает челленджи с числом участников; Joined — участвует ли userID
+	ListChallenges(userID uuid.UUID) ([]models.ChallengeDTO, error)
+	GetChallenge(userID, id uuid.UUID) (*models.ChallengeDTO, error)
+	UpdateChallenge(id uuid.UUID, dto *models.UpdateChallengeDTO) (*models.ReadingChallenge, error)
+	DeleteChallenge(id uuid.UUID) error
+	// Join записывает пользователя в челлендж, пока тот не закончился
+	Join(userID, id uuid.UUID) error
+	Leave(userID, id uuid.UUID) error
+	// Leaderboard — участники по убыванию результата; при равенстве у них
+	// одно место, выше тот, кто раньше набрал цель
+	Leaderboard(id uuid.UUID) ([]models.LeaderboardEntryDTO, error)
+
+	// CheckGoals проверяет активные цели и идущие челленджи и публикует
+	// события; вызывается планировщиком
+	CheckGoals() error
+}
+
+type goalService struct {
+	goalRepo      repository.GoalRepository
+	challengeRepo repository.ChallengeRepository
+	sessionRepo   repository.ReadingSessionRepository
+	accessRepo    repository.BookAccessRepository
+	activityRepo  repository.ActivityRepository
+	bus           *events.Bus
+	now           func() time.Time
+}
+
+// NewGoalService создаёт сервис; bus может быть nil — тогда прогресс
+// считается, но события не публикуются
+func NewGoalService(repos *repository.ExtendedRepository, bus *events.Bus) GoalService {
+	return &goalService{
+		goalRepo:      repos.Goal,
+		challengeRepo: repos.Challenge,
+		sessionRepo:   repos.ReadingSession,
+		accessRepo:    repos.BookAccess,
+		activityRepo:  repos.Activity,
+		bus:           bus,
+		now:           time.Now,
+	}
+}
+
+func (s *goalService) CreateGoal(userID uuid.UUID, dto *models.CreateGoalDTO) (*models.ReadingGoal, error) {
+	goal := &models.ReadingGoal{
+		UserID:   userID,
+		Metric:   dto.Metric,
+		Period:   dto.Period,
+		Target:   dto.Target,
+		Timezone: dto.Timezone,
+		IsActive: true,
+	}
+	if goal.Timezone == "" {
+		goal.Timezone = "UTC"
+	}
+	if err := s.goalRepo.Create(goal); err != nil {
+		return nil, err
+	}
+	return goal, nil
+}
+
+func (s *goalService) ListGoals(userID uuid.UUID) ([]models.GoalProgressDTO, error) {
+	goals, err := s.goalRepo.GetByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(goals) == 0 {
+		return []models.GoalProgressDTO{}, nil
+	}
+	activity, err := s.loadActivity(userID)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	result := make([]models.GoalProgressDTO, 0, len(goals))
+	for i := range goals {
+		progress := activity.progress(&goals[i], now)
+		if goals[i].IsActive && progress.Reached {
+			s.notifyReached(&goals[i], progress)
+		}
+		result = append(result, progress)
+	}
+	return result, nil
+}
+
+func (s *goalService) UpdateGoal(userID, id uuid.UUID, dto *models.UpdateGoalDTO) (*models.ReadingGoal, error) {
+	goal, err := s.ownedGoal(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if dto.Target != nil {
+		goal.Target = *dto.Target
+	}
+	if dto.Timezone != nil && *dto.Timezone != "" {
+		goal.Timezone = *dto.Timezone
+	}
+	if dto.IsActive != nil {
+		goal.IsActive = *dto.IsActive
+	}
+	if err := s.goalRepo.Update(goal); err != nil {
+		return nil, err
+	}
+	return goal, nil
+}
+
+func (s *goalService) DeleteGoal(userID, id uuid.UUID) error {
+	if _, err := s.ownedGoal(userID, id); err != nil {
+		return err
+	}
+	return s.goalRepo.Delete(id)
+}
+
+// ownedGoal — чужая цель выглядит как несуществующая
+func (s *goalService) ownedGoal(userID, id uuid.UUID) (*models.ReadingGoal, error) {
+	goal, err := s.goalRepo.GetByID(id)
+	if err != nil || goal.UserID != userID {
+		return nil, ErrGoalNotFound
+	}
+	return goal, nil
+}
+
+func (s *goalService) CreateChallenge(userID uuid.UUID, dto *models.CreateChallengeDTO) (*models.ReadingChallenge, error) {
+	if !dto.EndDate.After(dto.StartDate) {
+		return nil, ErrChallengeInvalidDate
+	}
+	challenge := &models.ReadingChallenge{
+		Title:       dto.Title,
+		Description: dto.Description,
+		Metric:      dto.Metric,
+		Target:      dto.Target,
+		StartDate:   dto.StartDate,
+		EndDate:     dto.EndDate,
+		CreatedBy:   userID,
+	}
+	if err := s.challengeRepo.Create(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+func (s *goalService) ListChallenges(userID uuid.UUID) ([]models.ChallengeDTO, error) {
+	challenges, err := s.challengeRepo.List()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uuid.UUID, len(challenges))
+	for i, c := range challenges {
+		ids[i] = c.ID
+	}
+	counts, err := s.challengeRepo.CountParticipants(ids)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]models.ChallengeDTO, 0, len(challenges))
+	for _, c := range challenges {
+		_, err := s.challengeRepo.GetParticipant(c.ID, userID)
+		result = append(result, models.ChallengeDTO{ReadingChallenge: c, Participants: counts[c.ID], Joined: err == nil})
+	}
+	return result, nil
+}
+
+func (s *goalService) GetChallenge(userID, id uuid.UUID) (*models.ChallengeDTO, error) {
+	challenge, err := s.challengeRepo.GetByID(id)
+	if err != nil {
+		return nil, ErrChallengeNotFound
+	}
+	counts, err := s.challengeRepo.CountParticipants([]uuid.UUID{id})
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.challengeRepo.GetParticipant(id, userID)
+	return &models.ChallengeDTO{ReadingChallenge: *challenge, Participants: counts[id], Joined: err == nil}, nil
+}
+
+func (s *goalService) UpdateChallenge(id uuid.UUID, dto *models.UpdateChallengeDTO) (*models.ReadingChallenge, error) {
+	challenge, err := s.challengeRepo.GetByID(id)
+	if err != nil {
+		return nil, ErrChallengeNotFound
+	}
+	if dto.Title != nil {
+		challenge.Title = *dto.Title
+	}
+	if dto.Description != nil {
+		challenge.Description = *dto.Description
+	}
+	if dto.Target != nil {
+		challenge.Target = *dto.Target
+	}
+	if dto.EndDate != nil {
+		challenge.EndDate = *dto.EndDate
+	}
+	if !challenge.EndDate.After(challenge.StartDate) {
+		return nil, ErrChallengeInvalidDate
+	}
+	if err := s.challengeRepo.Update(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+func (s *goalService) DeleteChallenge(id uuid.UUID) error {
+	if _, err := s.challengeRepo.GetByID(id); err != nil {
+		return ErrChallengeNotFound
+	}
+	return s.challengeRepo.Delete(id)
+}
+
+func (s *goalService) Join(userID, id uuid.UUID) error {
+	challenge, err := s.challengeRepo.GetByID(id)
+	if err != nil {
+		return ErrChallengeNotFound
+	}
+	if !s.now().Before(challenge.EndDate) {
+		return ErrChallengeEnded
+	}
+	if _, err := s.challengeRepo.GetParticipant(id, userID); err == nil {
+		return ErrChallengeJoined
+	}
+	if err := s.challengeRepo.AddParticipant(&models.ChallengeParticipant{ChallengeID: id, UserID: userID}); err != nil {
+		return err
+	}
+	recordActivity(s.activityRepo, &models.Activity{
+		UserID:    userID,
+		Type:      models.ActivityChallengeJoined,
+		SubjectID: id,
+		Title:     challenge.Title,
+	})
+	return nil
+}
+
+func (s *goalService) Leave(userID, id uuid.UUID) error {
+	if _, err := s.challengeRepo.GetByID(id); err != nil {
+		return ErrChallengeNotFound
+	}
+	if _, err := s.challengeRepo.GetParticipant(id, userID); err != nil {
+		return ErrChallengeNotJoined
+	}
+	if err := s.challengeRepo.RemoveParticipant(id, userID); err != nil {
+		return err
+	}
+	forgetActivity(s.activityRepo, userID, models.ActivityChallengeJoined, id)
+	return nil
+}
+
+func (s *goalService) Leaderboard(id uuid.UUID) ([]models.LeaderboardEntryDTO, error) {
+	challenge, err := s.challengeRepo.GetByID(id)
+	if err != nil {
+		return nil, ErrChallengeNotFound
+	}
+	participants, values, err := s.challengeStandings(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]models.LeaderboardEntryDTO, 0, len(participants))
+	for _, p := range participants {
+		entry := models.LeaderboardEntryDTO{
+			UserID:      p.UserID,
+			Value:       values[p.UserID],
+			Percent:     goalPercent(values[p.UserID], challenge.Target),
+			CompletedAt: p.CompletedAt,
+		}
+		if p.User != nil {
+			entry.Name = p.User.Name
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Value != b.Value {
+			return a.Value > b.Value
+		}
+		if (a.CompletedAt == nil) != (b.CompletedAt == nil) {
+			return a.CompletedAt != nil
+		}
+		if a.CompletedAt != nil && !a.CompletedAt.Equal(*b.CompletedAt) {
+			return a.CompletedAt.Before(*b.CompletedAt)
+		}
+		return a.Name < b.Name
+	})
+	for i := range entries {
+		if i > 0 && entries[i].Value == entries[i-1].Value {
+			entries[i].Rank = entries[i-1].Rank
+		} else {
+			entries[i].Rank = i + 1
+		}
+	}
+	return entries, nil
+}
+
+// challengeStandings считает результаты участников за время челленджа и
+// отмечает тех, кто впервые набрал цель, пока челлендж идёт
+func (s *goalService) challengeStandings(challenge *models.ReadingChallenge) ([]models.ChallengeParticipant, map[uuid.UUID]int64, error) {
+	participants, err := s.challengeRepo.ListParticipants(challenge.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	userIDs := make([]uuid.UUID, len(participants))
+	for i, p := range participants {
+		userIDs[i] = p.UserID
+	}
+
+	values := make(map[uuid.UUID]int64, len(participants))
+	if challenge.Metric == models.GoalMetricBooks {
+		values, err = s.accessRepo.CountFinishedByUsers(userIDs, challenge.StartDate, challenge.EndDate)
+	} else {
+		var totals map[uuid.UUID]models.ReadingTotals
+		totals, err = s.sessionRepo.TotalsByUsers(userIDs, challenge.StartDate, challenge.EndDate)
+		for userID, t := range totals {
+			values[userID] = metricValue(challenge.Metric, t.Seconds, t.Pages, 0)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := s.now()
+	if !challenge.IsRunning(now) {
+		return participants, values, nil
+	}
+	for i := range participants {
+		p := &participants[i]
+		if p.CompletedAt != nil || values[p.UserID] < int64(challenge.Target) {
+			continue
+		}
+		marked, err := s.challengeRepo.MarkCompleted(p.ID, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !marked {
+			continue
+		}
+		completedAt := now
+		p.CompletedAt = &completedAt
+		s.publish(events.EventGoalReached, p.UserID, events.GoalPayload{
+			ChallengeID: challenge.ID.String(),
+			Metric:      string(challenge.Metric),
+			Target:      challenge.Target,
+			Value:       values[p.UserID],
+			PeriodStart: challenge.StartDate.Format("2006-01-02"),
+		})
+	}
+	return participants, values, nil
+}
+
+func (s *goalService) CheckGoals() error {
+	goals, err := s.goalRepo.ListActive()
+	if err != nil {
+		return err
+	}
+	now := s.now()
+	byUser := make(map[uuid.UUID][]*models.ReadingGoal)
+	for i := range goals {
+		byUser[goals[i].UserID] = append(byUser[goals[i].UserID], &goals[i])
+	}
+	for userID, userGoals := range byUser {
+		activity, err := s.loadActivity(userID)
+		if err != nil {
+			log.Printf("[goals] failed to load activity of user %s: %v", userID, err)
+			continue
+		}
+		for _, goal := range userGoals {
+			progress := activity.progress(goal, now)
+			if progress.Reached {
+				s.notifyReached(goal, progress)
+				continue
+			}
+			s.warnStreak(goal, progress, now)
+		}
+	}
+
+	challenges, err := s.challengeRepo.ListRunning(now)
+	if err != nil {
+		return err
+	}
+	for i := range challenges {
+		if _, _, err := s.challengeStandings(&challenges[i]); err != nil {
+			log.Printf("[goals] failed to check challenge %s: %v", challenges[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// notifyReached публикует goal.reached, если за текущий период ещё не публиковали
+func (s *goalService) notifyReached(goal *models.ReadingGoal, progress models.GoalProgressDTO) {
+	period := progress.PeriodStart.Format("2006-01-02")
+	if goal.ReachedPeriod == period {
+		return
+	}
+	marked, err := s.goalRepo.MarkReached(goal.ID, period)
+	if err != nil {
+		log.Printf("[goals] failed to mark goal %s reached: %v", goal.ID, err)
+		return
+	}
+	goal.ReachedPeriod = period
+	if !marked {
+		return
+	}
+	s.publish(events.EventGoalReached, goal.UserID, events.GoalPayload{
+		GoalID:      goal.ID.String(),
+		Metric:      string(goal.Metric),
+		Period:      string(goal.Period),
+		Target:      goal.Target,
+		Value:       progress.Value,
+		PeriodStart: period,
+	})
+}
+
+// warnStreak публикует streak.at_risk для дневной цели, выполненной вчера,
+// но ещё не выполненной сегодня, если день цели подходит к концу
+func (s *goalService) warnStreak(goal *models.ReadingGoal, progress models.GoalProgressDTO, now time.Time) {
+	if goal.Period != models.GoalPeriodDay || progress.Streak == 0 {
+		return
+	}
+	if now.In(progress.PeriodStart.Location()).Hour() < streakWarnHour {
+		return
+	}
+	period := progress.PeriodStart.Format("2006-01-02")
+	if goal.WarnedPeriod == period {
+		return
+	}
+	marked, err := s.goalRepo.MarkWarned(goal.ID, period)
+	if err != nil {
+		log.Printf("[goals] failed to mark goal %s warned: %v", goal.ID, err)
+		return
+	}
+	goal.WarnedPeriod = period
+	if !marked {
+		return
+	}
+	s.publish(events.EventStreakAtRisk, goal.UserID, events.StreakPayload{
+		GoalID: goal.ID.String(),
+		Metric: string(goal.Metric),
+		Target: goal.Target,
+		Value:  progress.Value,
+		Streak: progress.Streak,
+	})
+}
+
+func (s *goalService) publish(eventType events.EventType, userID uuid.UUID, payload interface{}) {
+	if s.bus == nil {
+		return
+	}
+	s.bus.Publish(events.Event{Type: eventType, UserID: userID.String(), Payload: payload})
+}
+
+// readingActivity — завершённые сессии и дочитанные книги пользователя,
+// по которым считается прогресс всех его целей
+type readingActivity struct {
+	sessions []models.ReadingSession
+	finished []models.BookAccess
+}
+
+func (s *goalService) loadActivity(userID uuid.UUID) (*readingActivity, error) {
+	sessions, err := s.sessionRepo.GetEndedByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	accesses, err := s.accessRepo.GetByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	activity := &readingActivity{sessions: sessions}
+	for _, a := range accesses {
+		if a.FinishedAt != nil {
+			activity.finished = append(activity.finished, a)
+		}
+	}
+	return activity, nil
+}
+
+// progress считает значение цели по периодам в её часовом поясе: текущий
+// период и серию выполненных периодов подряд
+func (a *readingActivity) progress(goal *models.ReadingGoal, now time.Time) models.GoalProgressDTO {
+	loc, err := time.LoadLocation(goal.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	periodKey := func(t time.Time) string {
+		start, _ := goal.PeriodBounds(t.In(loc))
+		return start.Format("2006-01-02")
+	}
+
+	seconds := map[string]int64{}
+	pages := map[string]int64{}
+	for _, session := range a.sessions {
+		key := periodKey(session.StartedAt)
+		seconds[key] += int64(session.Duration)
+		if session.EndPage > session.StartPage {
+			pages[key] += int64(session.EndPage - session.StartPage)
+		}
+	}
+	books := map[string]int64{}
+	seen := map[string]map[uuid.UUID]bool{}
+	for _, access := range a.finished {
+		key := periodKey(*access.FinishedAt)
+		if seen[key] == nil {
+			seen[key] = map[uuid.UUID]bool{}
+		}
+		if !seen[key][access.BookID] {
+			seen[key][access.BookID] = true
+			books[key]++
+		}
+	}
+	value := func(key string) int64 {
+		return metricValue(goal.Metric, seconds[key], pages[key], books[key])
+	}
+
+	start, end := goal.PeriodBounds(now.In(loc))
+	current := value(start.Format("2006-01-02"))
+	progress := models.GoalProgressDTO{
+		Goal:        *goal,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Value:       current,
+		Percent:     goalPercent(current, goal.Target),
+		Reached:     current >= int64(goal.Target),
+	}
+	// Невыполненный текущий период серию не прерывает, пока он не закончился
+	if progress.Reached {
+		progress.Streak = 1
+	}
+	prev := start
+	for i := 0; i < goalStreakLimit; i++ {
+		prev, _ = goal.PeriodBounds(prev.AddDate(0, 0, -1))
+		if value(prev.Format("2006-01-02")) < int64(goal.Target) {
+			break
+		}
+		progress.Streak++
+	}
+	return progress
+}
+
+func metricValue(metric models.GoalMetric, seconds, pages, books int64) int64 {
+	switch metric {
+	case models.GoalMetricMinutes:
+		return seconds / 60
+	case models.GoalMetricPages:
+		return pages
+	default:
+		return books
+	}
+}
+
+func goalPercent(value int64, target int) float64 {
+	if target <= 0 {
+		return 0
+	}
+	percent := float64(value) * 100 / float64(target)
+	if percent > 100 {
+		percent = 100
+	}
+	return round1(percent)
+}
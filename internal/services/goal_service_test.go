@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/oneErrortime/afst/internal/events"
+	"github.com/oneErrortime/afst/internal/models"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestGoalService(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Book{}, &models.BookAccess{}, &models.ReadingSession{},
+		&models.ReadingGoal{}, &models.ReadingChallenge{}, &models.ChallengeParticipant{}))
+	repos := gormrepo.NewExtendedRepository(db)
+	bus := events.NewBus(16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub := bus.Subscribe(ctx, events.EventGoalReached, events.EventStreakAtRisk)
+	svc := NewGoalService(repos, bus)
+
+	// Вечер, чтобы дневная серия была «под угрозой»
+	y, m, d := time.Now().UTC().Date()
+	now := time.Date(y, m, d, 21, 0, 0, 0, time.UTC)
+	svc.(*goalService).now = func() time.Time { return now }
+
+	drain := func() []events.Event {
+		var got []events.Event
+		for {
+			select {
+			case e := <-sub:
+				got = append(got, e)
+			default:
+				return got
+			}
+		}
+	}
+
+	newUser := func(name string) *models.User {
+		u := &models.User{Email: name + "@example.com", Name: name, Role: models.RoleReader, IsActive: true}
+		require.NoError(t, db.Create(u).Error)
+		return u
+	}
+	reader, rival, idle := newUser("Reader"), newUser("Rival"), newUser("Idle")
+	book := &models.Book{Title: "Солярис", Author: "Лем"}
+	require.NoError(t, db.Create(book).Error)
+
+	session := func(u *models.User, daysAgo, minutes, pages int) {
+		start := now.AddDate(0, 0, -daysAgo).Add(-2 * time.Hour)
+		end := start.Add(time.Duration(minutes) * time.Minute)
+		require.NoError(t, db.Create(&models.ReadingSession{UserID: u.ID, BookID: book.ID, AccessID: book.ID,
+			StartedAt: start, EndedAt: &end, Duration: minutes * 60, StartPage: 0, EndPage: pages}).Error)
+	}
+	session(reader, 2, 30, 10)
+	session(reader, 1, 40, 20)
+	session(reader, 0, 10, 5)
+	session(rival, 0, 80, 0)
+
+	daily, err := svc.CreateGoal(reader.ID, &models.CreateGoalDTO{Metric: models.GoalMetricMinutes, Period: models.GoalPeriodDay, Target: 30})
+	require.NoError(t, err)
+	assert.Equal(t, "UTC", daily.Timezone)
+	_, err = svc.CreateGoal(reader.ID, &models.CreateGoalDTO{Metric: models.GoalMetricBooks, Period: models.GoalPeriodYear, Target: 3})
+	require.NoError(t, err)
+
+	finished := now.Add(-time.Hour)
+	for _, title := range []string{"Нос", "Шинель"} {
+		b := &models.Book{Title: title, Author: "Гоголь"}
+		require.NoError(t, db.Create(b).Error)
+		require.NoError(t, db.Create(&models.BookAccess{UserID: reader.ID, BookID: b.ID, Type: models.AccessTypeLoan,
+			Status: models.AccessStatusActive, StartDate: now.AddDate(0, 0, -1), EndDate: now.AddDate(0, 0, 14),
+			ReadProgress: 100, FinishedAt: &finished}).Error)
+	}
+
+	progress, err := svc.ListGoals(reader.ID)
+	require.NoError(t, err)
+	require.Len(t, progress, 2)
+	assert.Equal(t, int64(10), progress[0].Value)
+	assert.False(t, progress[0].Reached)
+	assert.Equal(t, 2, progress[0].Streak, "an unfinished day does not break the streak")
+	assert.Equal(t, int64(2), progress[1].Value)
+	assert.Equal(t, 66.7, progress[1].Percent)
+
+	require.NoError(t, svc.CheckGoals())
+	require.NoError(t, svc.CheckGoals())
+	got := drain()
+	require.Len(t, got, 1, "streak.at_risk is published once a day")
+	assert.Equal(t, events.EventStreakAtRisk, got[0].Type)
+	assert.Equal(t, reader.ID.String(), got[0].UserID)
+	assert.Equal(t, 2, got[0].Payload.(events.StreakPayload).Streak)
+
+	session(reader, 0, 25, 0)
+	progress, err = svc.ListGoals(reader.ID)
+	require.NoError(t, err)
+	assert.True(t, progress[0].Reached)
+	assert.Equal(t, 3, progress[0].Streak)
+	require.NoError(t, svc.CheckGoals())
+	got = drain()
+	require.Len(t, got, 1, "goal.reached is published once a period")
+	assert.Equal(t, daily.ID.String(), got[0].Payload.(events.GoalPayload).GoalID)
+
+	_, err = svc.UpdateGoal(rival.ID, daily.ID, &models.UpdateGoalDTO{})
+	assert.ErrorIs(t, err, ErrGoalNotFound)
+
+	challenge, err := svc.CreateChallenge(reader.ID, &models.CreateChallengeDTO{Title: "Неделя чтения", Metric: models.GoalMetricMinutes,
+		Target: 60, StartDate: now.AddDate(0, 0, -1).Add(-12 * time.Hour), EndDate: now.AddDate(0, 0, 7)})
+	require.NoError(t, err)
+	for _, u := range []*models.User{idle, rival, reader} {
+		require.NoError(t, svc.Join(u.ID, challenge.ID))
+	}
+	assert.ErrorIs(t, svc.Join(reader.ID, challenge.ID), ErrChallengeJoined)
+
+	board, err := svc.Leaderboard(challenge.ID)
+	require.NoError(t, err)
+	require.Len(t, board, 3)
+	assert.Equal(t, []string{"Rival", "Reader", "Idle"}, []string{board[0].Name, board[1].Name, board[2].Name})
+	assert.Equal(t, int64(80), board[0].Value)
+	assert.Equal(t, int64(75), board[1].Value, "sessions before the challenge are not counted")
+	assert.Equal(t, 3, board[2].Rank)
+	assert.NotNil(t, board[1].CompletedAt)
+	assert.Nil(t, board[2].CompletedAt)
+	assert.Len(t, drain(), 2, "each participant reaching the target is announced")
+
+	session(idle, 0, 75, 0)
+	now = now.Add(time.Hour)
+	board, err = svc.Leaderboard(challenge.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 2}, []int{board[0].Rank, board[1].Rank, board[2].Rank}, "equal values share a place")
+	assert.Equal(t, "Reader", board[1].Name, "the earlier finisher goes first")
+
+	list, err := svc.ListChallenges(reader.ID)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, int64(3), list[0].Participants)
+	assert.True(t, list[0].Joined)
+
+	ended, err := svc.CreateChallenge(reader.ID, &models.CreateChallengeDTO{Title: "Прошлый месяц", Metric: models.GoalMetricBooks,
+		Target: 1, StartDate: now.AddDate(0, -2, 0), EndDate: now.AddDate(0, -1, 0)})
+	require.NoError(t, err)
+	assert.ErrorIs(t, svc.Join(reader.ID, ended.ID), ErrChallengeEnded)
+}
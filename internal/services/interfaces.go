@@ -77,6 +77,8 @@ type SubscriptionService interface {
 	Cancel(id uuid.UUID) error
 	Renew(id uuid.UUID) error
 	GetPlans() []models.SubscriptionPlanConfig
+	// ExpireOverdue переводит в expired истёкшие подписки без автопродления
+	ExpireOverdue() (int, error)
 }
 
 type BookAccessService interface {
@@ -88,6 +90,8 @@ type BookAccessService interface {
 	RevokeAccess(id uuid.UUID) error
 	UpdateProgress(id uuid.UUID, currentPage int, readTime time.Duration) error
 	GetUserLibrary(userID uuid.UUID) ([]models.BookAccessWithBook, error)
+	// ExpireOverdue переводит в expired активные доступы с истёкшим сроком
+	ExpireOverdue() (int64, error)
 }
 
 type BookFileService interface {
@@ -96,11 +100,21 @@ type BookFileService interface {
 	GetByBookID(bookID uuid.UUID) ([]models.BookFile, error)
 	Delete(id uuid.UUID) error
 	ServeFile(id uuid.UUID) (string, string, error)
+	// OpenEPUBResource открывает отдельный файл из архива EPUB (главу, стиль, картинку).
+	OpenEPUBResource(id uuid.UUID, resourcePath string) (*EPUBResource, error)
 }
 
+// ReadingSessionService — сессии чтения. Время сессии — только активное:
+// клиент шлёт пульсы, а промежутки дольше таймаута простоя не засчитываются.
+// Сессии без пульсов дольше таймаута закрывает CloseIdle.
 type ReadingSessionService interface {
-	StartSession(userID, bookID, accessID uuid.UUID, deviceInfo string) (*models.ReadingSession, error)
-	EndSession(sessionID uuid.UUID, endPage int) error
+	StartSession(userID, bookID, accessID uuid.UUID, client models.SessionClient) (*models.ReadingSession, error)
+	// Heartbeat засчитывает активность; для завершённой или простаивающей
+	// сессии возвращает ErrSessionEnded — клиенту нужно начать новую
+	Heartbeat(userID, sessionID uuid.UUID, dto *models.SessionHeartbeatDTO) (*models.ReadingSession, error)
+	EndSession(userID, sessionID uuid.UUID, endPage int) error
+	// CloseIdle завершает сессии без активности дольше таймаута простоя
+	CloseIdle() (int64, error)
 	GetUserSessions(userID uuid.UUID, limit int) ([]models.ReadingSession, error)
 	GetBookStats(bookID uuid.UUID) (*models.BookReadingStats, error)
 }
@@ -135,6 +149,10 @@ type SocialService interface {
 	FollowUser(userID, targetUserID uuid.UUID) error
 	UnfollowUser(userID, targetUserID uuid.UUID) error
 	GetUserProfile(userID uuid.UUID) (*models.UserPublicProfileDTO, error)
+	// GetFollowers — страница тех, кто подписан на userID, и их общее число
+	GetFollowers(userID uuid.UUID, limit, offset int) ([]models.UserSummaryDTO, int64, error)
+	// GetFollowing — страница тех, на кого подписан userID, и их общее число
+	GetFollowing(userID uuid.UUID, limit, offset int) ([]models.UserSummaryDTO, int64, error)
 }
 
 type Services struct {
@@ -147,6 +165,10 @@ type Services struct {
 	Subscription   SubscriptionService
 	BookAccess     BookAccessService
 	BookFile       BookFileService
+	Download       DownloadService
+	Watermark      WatermarkService
+	Upload         UploadService
+	Blob           BlobService
 	ReadingSession ReadingSessionService
 	FeatureFlag    FeatureFlagService
 	Collection     CollectionService
@@ -154,6 +176,17 @@ type Services struct {
 	Bookmark       BookmarkService
 	Social         SocialService
 	APIKey         APIKeyService
+	Webhook        WebhookService
+	Job            JobService
+	Scheduler      SchedulerService
+	Cover          CoverService
+	Search         SearchService
+	Position       ReadingPositionService
+	Annotation     AnnotationService
+	Stats          ReadingStatsService
+	Goal           GoalService
+	Offline        OfflineService
+	Activity       ActivityService
 }
 
 // APIKeyService — интерфейс управления ключами внешнего API.
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+	"github.com/oneErrortime/afst/internal/worker"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobState — операция недопустима в текущем статусе задачи
+	ErrJobState     = errors.New("operation not allowed in current job status")
+	ErrJobBadStatus = errors.New("unknown job status")
+)
+
+// jobThroughputWindow — за какой период считаем завершённые задачи в статистике
+const jobThroughputWindow = time.Hour
+
+// JobService — администрирование персистентной очереди фоновых задач.
+type JobService interface {
+	List(filter models.JobFilter) ([]models.Job, int64, error)
+	Get(id uuid.UUID) (*models.Job, error)
+	// Retry возвращает failed/dead/cancelled задачу в очередь с обнулённым счётчиком попыток.
+	Retry(id uuid.UUID) (*models.Job, error)
+	// Cancel отменяет ожидающую или выполняющуюся задачу.
+	Cancel(id uuid.UUID) (*models.Job, error)
+	Stats() ([]models.JobQueueStatsDTO, error)
+}
+
+type jobService struct {
+	repo   repository.JobRepository
+	queues []*worker.Queue
+}
+
+// NewJobService — queues нужны только для счётчиков воркеров этого процесса в Stats
+func NewJobService(repo repository.JobRepository, queues ...*worker.Queue) JobService {
+	return &jobService{repo: repo, queues: queues}
+}
+
+func (s *jobService) List(filter models.JobFilter) ([]models.Job, int64, error) {
+	switch filter.Status {
+	case "", models.JobStatusPending, models.JobStatusRunning, models.JobStatusSucceeded,
+		models.JobStatusFailed, models.JobStatusDead, models.JobStatusCancelled:
+	default:
+		return nil, 0, ErrJobBadStatus
+	}
+	return s.repo.List(filter)
+}
+
+func (s *jobService) Get(id uuid.UUID) (*models.Job, error) {
+	job, err := s.repo.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+func (s *jobService) Retry(id uuid.UUID) (*models.Job, error) {
+	return s.transition(id, s.repo.Retry)
+}
+
+func (s *jobService) Cancel(id uuid.UUID) (*models.Job, error) {
+	return s.transition(id, s.repo.Cancel)
+}
+
+// transition отличает «задачи нет» от «задача в неподходящем статусе»:
+// репозиторий в обоих случаях отвечает ErrRecordNotFound
+func (s *jobService) transition(id uuid.UUID, apply func(uuid.UUID) error) (*models.Job, error) {
+	if _, err := s.Get(id); err != nil {
+		return nil, err
+	}
+	if err := apply(id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrJobState
+		}
+		return nil, err
+	}
+	return s.Get(id)
+}
+
+func (s *jobService) Stats() ([]models.JobQueueStatsDTO, error) {
+	stats, err := s.repo.Stats(time.Now().Add(-jobThroughputWindow))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, q := range s.queues {
+		processed, failed := q.Stats()
+		found := false
+		for i := range stats {
+			if stats[i].Queue == q.Name() {
+				stats[i].Processed, stats[i].FailedTotal = processed, failed
+				found = true
+			}
+		}
+		if !found {
+			stats = append(stats, models.JobQueueStatsDTO{
+				Queue:       q.Name(),
+				ByStatus:    map[models.JobStatus]int64{},
+				Processed:   processed,
+				FailedTotal: failed,
+			})
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/oneErrortime/afst/internal/repository"
+	"github.com/oneErrortime/afst/internal/scheduler"
+)
+
+const (
+	// jobRetention — сколько храним завершённые фоновые задачи
+	jobRetention = 7 * 24 * time.Hour
+	// blobGracePeriod — сколько файл без ссылок ждёт удаления
+	blobGracePeriod = 24 * time.Hour
+)
+
+// RegisterMaintenanceTasks регистрирует периодические задачи обслуживания.
+// Вызывается при старте до sched.Start.
+func RegisterMaintenanceTasks(sched *scheduler.Scheduler, svc *Services, repos *repository.ExtendedRepository) error {
+	tasks := []scheduler.Task{
+		{
+			Name:   "subscriptions.expire",
+			Spec:   "*/5 * * * *",
+			Jitter: 30 * time.Second,
+			Run: func(ctx context.Context) error {
+				n, err := svc.Subscription.ExpireOverdue()
+				if n > 0 {
+					log.Printf("[maintenance] expired %d subscriptions", n)
+				}
+				return err
+			},
+		},
+		{
+			Name:   "book_access.expire",
+			Spec:   "*/5 * * * *",
+			Jitter: 30 * time.Second,
+			Run: func(ctx context.Context) error {
+				n, err := svc.BookAccess.ExpireOverdue()
+				if n > 0 {
+					log.Printf("[maintenance] expired %d book accesses", n)
+				}
+				return err
+			},
+		},
+		{
+			Name:   "jobs.cleanup",
+			Spec:   "@daily",
+			Jitter: 10 * time.Minute,
+			Run: func(ctx context.Context) error {
+				n, err := repos.Job.DeleteFinishedBefore(time.Now().Add(-jobRetention))
+				if n > 0 {
+					log.Printf("[maintenance] removed %d finished jobs", n)
+				}
+				return err
+			},
+		},
+		{
+			Name:   "uploads.cleanup",
+			Spec:   "@hourly",
+			Jitter: 5 * time.Minute,
+			Run: func(ctx context.Context) error {
+				n, err := svc.Upload.CleanupExpired()
+				if n > 0 {
+					log.Printf("[maintenance] removed %d abandoned uploads", n)
+				}
+				return err
+			},
+		},
+		{
+			Name:   "blobs.gc",
+			Spec:   "@daily",
+			Jitter: 30 * time.Minute,
+			Run: func(ctx context.Context) error {
+				report, err := svc.Blob.CollectGarbage(blobGracePeriod)
+				if report != nil && report.Removed+report.Orphans > 0 {
+					log.Printf("[maintenance] removed %d unreferenced and %d orphaned files (%d bytes)",
+						report.Removed, report.Orphans, report.Bytes)
+				}
+				return err
+			},
+		},
+		{
+			Name:   "watermarks.cleanup",
+			Spec:   "@hourly",
+			Jitter: 5 * time.Minute,
+			Run: func(ctx context.Context) error {
+				n, err := svc.Watermark.CleanupCache()
+				if n > 0 {
+					log.Printf("[maintenance] removed %d cached watermarked copies", n)
+				}
+				return err
+			},
+		},
+		{
+			Name:   "reading_sessions.close_idle",
+			Spec:   "*/5 * * * *",
+			Jitter: 30 * time.Second,
+			Run: func(ctx context.Context) error {
+				n, err := svc.ReadingSession.CloseIdle()
+				if n > 0 {
+					log.Printf("[maintenance] closed %d idle reading sessions", n)
+				}
+				return err
+			},
+		},
+		{
+			Name:   "goals.check",
+			Spec:   "*/15 * * * *",
+			Jitter: time.Minute,
+			Run: func(ctx context.Context) error {
+				return svc.Goal.CheckGoals()
+			},
+		},
+		{
+			Name:   "sync.tombstones_cleanup",
+			Spec:   "@daily",
+			Jitter: 10 * time.Minute,
+			Run: func(ctx context.Context) error {
+				n, err := svc.Offline.CleanupTombstones()
+				if n > 0 {
+					log.Printf("[maintenance] removed %d sync tombstones", n)
+				}
+				return err
+			},
+		},
+	}
+
+	for _, t := range tasks {
+		if err := sched.Register(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
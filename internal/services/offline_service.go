@@ -0,0 +1,611 @@
+package services
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/auth"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+	"github.com/oneErrortime/afst/internal/storage"
+)
+
+const (
+	// syncTokenPrefix — версия формата токена синхронизации
+	syncTokenPrefix = "v1."
+	// syncOverlap — на сколько раньше токена начинается выборка изменений:
+	// запись, сохранённая в транзакции, начатой до выдачи токена, не потеряется.
+	// Такие записи могут прийти повторно, клиент применяет их по ID.
+	syncOverlap = 5 * time.Second
+	// SyncTombstoneRetention — сколько хранятся следы удалений; с более
+	// старым токеном клиент получает полную выгрузку
+	SyncTombstoneRetention = 90 * 24 * time.Hour
+)
+
+var (
+	ErrOfflineNoAccess    = errors.New("no active access to this book")
+	ErrOfflineNoFile      = errors.New("book has no file to read offline")
+	ErrSyncTokenInvalid   = errors.New("invalid sync token")
+	errSyncNoAccess       = errors.New("no access to this book")
+	errSyncForeign        = errors.New("record belongs to another user")
+	errSyncBadSessionTime = errors.New("ended_at must be after started_at and not in the future")
+)
+
+// offlineFilePreference — какой файл книги класть в пакет, если их несколько:
+// перетекаемый EPUB удобнее читать на телефоне
+var offlineFilePreference = map[models.FileType]int{
+	models.FileTypeEPUB: 0,
+	models.FileTypePDF:  1,
+	models.FileTypeMOBI: 2,
+}
+
+// OfflineOptions — подпись офлайн-лицензий
+type OfflineOptions struct {
+	Signer *auth.LicenceSigner
+}
+
+// OfflineService собирает пакеты для чтения без сети и синхронизирует
+// изменения, сделанные на устройстве офлайн.
+//
+// Пакет — ZIP с файлом книги (помеченным водяным знаком, если формат это
+// позволяет), manifest.json (оглавление, закладки, аннотации, позиции) и
+// лицензией licence.json, подписанной Ed25519 (licence.sig). Лицензия
+// действует до конца выдачи книги.
+//
+// Синхронизация идемпотентна: ID записей создаёт приложение, и повтор того
+// же запроса отвечает duplicate вместо создания копий.
+type OfflineService interface {
+	// Bundle готовит пакет; fileID — конкретный файл книги, nil — лучший из
+	// имеющихся. Пакет нужно закрыть после записи.
+	Bundle(userID, bookID uuid.UUID, fileID *uuid.UUID) (*OfflineBundle, error)
+	// Key — открытый ключ для проверки лицензий в приложении
+	Key() models.OfflineKeyDTO
+	// Sync применяет изменения устройства и возвращает изменения на сервере
+	// с прошлой синхронизации
+	Sync(userID uuid.UUID, client models.SessionClient, dto *models.SyncRequestDTO) (*models.SyncResponseDTO, error)
+	// CleanupTombstones удаляет следы удалений старше SyncTombstoneRetention
+	CleanupTombstones() (int64, error)
+}
+
+type offlineService struct {
+	repos       *repository.ExtendedRepository
+	fileStorage storage.FileStorage
+	downloads   DownloadService
+	watermarks  WatermarkService
+	positions   ReadingPositionService
+	signer      *auth.LicenceSigner
+	now         func() time.Time
+}
+
+func NewOfflineService(repos *repository.ExtendedRepository, fileStorage storage.FileStorage, downloads DownloadService,
+	watermarks WatermarkService, positions ReadingPositionService, opts OfflineOptions) OfflineService {
+	return &offlineService{
+		repos:       repos,
+		fileStorage: fileStorage,
+		downloads:   downloads,
+		watermarks:  watermarks,
+		positions:   positions,
+		signer:      opts.Signer,
+		now:         time.Now,
+	}
+}
+
+// OfflineBundle — подготовленный пакет: данные собраны, файл открыт, а
+// хеши и подпись считаются при записи
+type OfflineBundle struct {
+	Manifest models.OfflineManifest
+	Licence  models.OfflineLicence
+
+	content storage.Object
+	signer  *auth.LicenceSigner
+}
+
+// FileName — имя пакета для Content-Disposition
+func (b *OfflineBundle) FileName() string {
+	return "book-" + b.Licence.BookID.String() + ".zip"
+}
+
+// Write пишет пакет в w. Файл книги сжат уже сам по себе и кладётся без сжатия.
+func (b *OfflineBundle) Write(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	entry, err := zw.CreateHeader(&zip.FileHeader{Name: b.Licence.Content, Method: zip.Store, Modified: b.Licence.IssuedAt})
+	if err != nil {
+		return err
+	}
+	if _, err := b.content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(entry, hash), b.content); err != nil {
+		return fmt.Errorf("copy book file: %w", err)
+	}
+	b.Licence.ContentSHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	manifest, err := json.MarshalIndent(b.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(manifest)
+	b.Licence.ManifestSHA256 = hex.EncodeToString(sum[:])
+
+	licence, err := json.MarshalIndent(b.Licence, "", "  ")
+	if err != nil {
+		return err
+	}
+	signature := base64.StdEncoding.EncodeToString(b.signer.Sign(licence))
+
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{models.OfflineManifestName, manifest},
+		{models.OfflineLicenceName, licence},
+		{models.OfflineSignatureName, []byte(signature)},
+	} {
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: b.Licence.IssuedAt})
+		if err != nil {
+			return err
+		}
+		if _, err := entry.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (b *OfflineBundle) Close() error {
+	return b.content.Close()
+}
+
+func (s *offlineService) Bundle(userID, bookID uuid.UUID, fileID *uuid.UUID) (*OfflineBundle, error) {
+	access, err := s.repos.BookAccess.GetActiveByUserAndBook(userID, bookID)
+	if err != nil || !access.IsValid() {
+		return nil, ErrOfflineNoAccess
+	}
+	book, err := s.repos.Book.GetByID(bookID)
+	if err != nil {
+		return nil, ErrOfflineNoAccess
+	}
+	file, err := s.pickFile(bookID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.repos.User.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	// Пакет уносит файл целиком, поэтому это скачивание: те же права и квота
+	if err := s.downloads.RecordDownload(user, file); err != nil {
+		return nil, err
+	}
+
+	bookmarks, err := s.repos.Bookmark.GetByBookID(userID, bookID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range bookmarks {
+		bookmarks[i].Book = nil
+	}
+	annotations, _, err := s.repos.Annotation.ListVisible(userID, models.AnnotationFilter{UserID: &userID, BookID: &bookID})
+	if err != nil {
+		return nil, err
+	}
+	for i := range annotations {
+		annotations[i].Book = nil
+	}
+	positions, err := s.repos.Position.ListByBook(userID, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	content, watermarked, err := s.openContent(file, user)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	return &OfflineBundle{
+		Manifest: models.OfflineManifest{
+			Version: models.OfflineBundleVersion,
+			Book:    models.OfflineBookDTO{ID: book.ID, Title: book.Title, Author: book.Author},
+			File: models.OfflineFileDTO{
+				ID:          file.ID,
+				Name:        file.OriginalName,
+				Type:        file.FileType,
+				MimeType:    file.MimeType,
+				Watermarked: watermarked,
+			},
+			TOC:         fileTOC(file),
+			Bookmarks:   bookmarks,
+			Annotations: annotations,
+			Positions:   positions,
+			SyncToken:   encodeSyncToken(now),
+			GeneratedAt: now,
+		},
+		Licence: models.OfflineLicence{
+			ID:        uuid.New(),
+			Version:   models.OfflineBundleVersion,
+			KeyID:     s.signer.KeyID(),
+			UserID:    userID,
+			BookID:    bookID,
+			FileID:    file.ID,
+			AccessID:  access.ID,
+			IssuedAt:  now,
+			ExpiresAt: access.EndDate,
+			Content:   models.OfflineContentPrefix + string(file.FileType),
+		},
+		content: content,
+		signer:  s.signer,
+	}, nil
+}
+
+func (s *offlineService) pickFile(bookID uuid.UUID, fileID *uuid.UUID) (*models.BookFile, error) {
+	files, err := s.repos.BookFile.GetByBookID(bookID)
+	if err != nil {
+		return nil, err
+	}
+	var best *models.BookFile
+	for i := range files {
+		f := &files[i]
+		if f.DeletedAt != nil {
+			continue
+		}
+		if fileID != nil {
+			if f.ID == *fileID {
+				return f, nil
+			}
+			continue
+		}
+		if best == nil || offlineFilePreference[f.FileType] < offlineFilePreference[best.FileType] {
+			best = f
+		}
+	}
+	if best == nil {
+		return nil, ErrOfflineNoFile
+	}
+	return best, nil
+}
+
+// openContent открывает копию с водяным знаком; форматы, которые пометить
+// нельзя, уходят как есть — так же, как при скачивании
+func (s *offlineService) openContent(file *models.BookFile, user *models.User) (storage.Object, bool, error) {
+	marked, err := s.watermarks.Prepare(file, user)
+	switch {
+	case errors.Is(err, ErrWatermarkUnsupported):
+		obj, err := s.fileStorage.Get(file.FilePath)
+		return obj, false, err
+	case err != nil:
+		return nil, false, err
+	case marked.Temp != nil:
+		return marked.Temp, true, nil
+	default:
+		obj, err := s.fileStorage.Get(marked.Path)
+		return obj, true, err
+	}
+}
+
+// fileTOC достаёт оглавление (EPUB) или outline (PDF) из метаданных файла
+func fileTOC(file *models.BookFile) json.RawMessage {
+	if file.Metadata == nil {
+		return nil
+	}
+	var meta map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(*file.Metadata), &meta); err != nil {
+		return nil
+	}
+	if toc, ok := meta["toc"]; ok {
+		return toc
+	}
+	return meta["outline"]
+}
+
+func (s *offlineService) Key() models.OfflineKeyDTO {
+	return models.OfflineKeyDTO{
+		KeyID:     s.signer.KeyID(),
+		Algorithm: "Ed25519",
+		PublicKey: base64.StdEncoding.EncodeToString(s.signer.PublicKey()),
+	}
+}
+
+func (s *offlineService) Sync(userID uuid.UUID, client models.SessionClient, dto *models.SyncRequestDTO) (*models.SyncResponseDTO, error) {
+	since, err := decodeSyncToken(dto.Token)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	fullSync := since.IsZero() || now.Sub(since) > SyncTombstoneRetention
+	if fullSync {
+		since = time.Time{}
+	} else {
+		since = since.Add(-syncOverlap)
+	}
+
+	// Офлайн-записи принимаются по книгам, которые у пользователя были
+	// выданы: выдача могла закончиться, пока устройство было без сети
+	accesses, err := s.repos.BookAccess.GetByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[uuid.UUID]*models.BookAccess, len(accesses))
+	for i := range accesses {
+		if _, ok := latest[accesses[i].BookID]; !ok {
+			latest[accesses[i].BookID] = &accesses[i]
+		}
+	}
+
+	resp := &models.SyncResponseDTO{FullSync: fullSync, Results: []models.SyncResultDTO{}}
+	record := func(entity models.SyncEntity, id uuid.UUID, status models.SyncStatus, err error) {
+		result := models.SyncResultDTO{Type: entity, ID: id, Status: status}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	for i := range dto.Bookmarks {
+		b := &dto.Bookmarks[i]
+		status, err := s.pushBookmark(userID, latest[b.BookID], b)
+		record(models.SyncBookmark, b.ID, status, err)
+	}
+	for i := range dto.Annotations {
+		a := &dto.Annotations[i]
+		status, err := s.pushAnnotation(userID, latest[a.BookID], a)
+		record(models.SyncAnnotation, a.ID, status, err)
+	}
+	for i := range dto.Sessions {
+		sess := &dto.Sessions[i]
+		status, err := s.pushSession(userID, latest[sess.BookID], client, sess, now)
+		record(models.SyncSession, sess.ID, status, err)
+	}
+	for i := range dto.Positions {
+		p := &dto.Positions[i]
+		status, err := s.pushPosition(userID, latest[p.BookID], dto, p)
+		record(models.SyncPosition, p.BookID, status, err)
+	}
+	for _, d := range dto.Deleted {
+		status, err := s.pushDeletion(userID, d)
+		record(d.Type, d.ID, status, err)
+	}
+
+	if resp.Bookmarks, err = s.repos.Sync.BookmarksSince(userID, since); err != nil {
+		return nil, err
+	}
+	if resp.Annotations, err = s.repos.Sync.AnnotationsSince(userID, since); err != nil {
+		return nil, err
+	}
+	if resp.Positions, err = s.repos.Sync.PositionsSince(userID, since); err != nil {
+		return nil, err
+	}
+	if fullSync {
+		resp.Deleted = []models.SyncTombstone{}
+	} else if resp.Deleted, err = s.repos.Sync.TombstonesSince(userID, since); err != nil {
+		return nil, err
+	}
+	resp.Token = encodeSyncToken(now)
+	return resp, nil
+}
+
+func (s *offlineService) pushBookmark(userID uuid.UUID, access *models.BookAccess, dto *models.SyncBookmarkDTO) (models.SyncStatus, error) {
+	if existing, err := s.repos.Bookmark.GetByID(dto.ID); err == nil {
+		if existing.UserID != userID {
+			return models.SyncRejected, errSyncForeign
+		}
+		return models.SyncDuplicate, nil
+	}
+	if access == nil {
+		return models.SyncRejected, errSyncNoAccess
+	}
+	loc, location, err := models.ResolveLocation(dto.Locator, dto.Location)
+	if err != nil {
+		return models.SyncRejected, err
+	}
+	bookmark := &models.Bookmark{
+		ID:        dto.ID,
+		UserID:    userID,
+		BookID:    dto.BookID,
+		Location:  location,
+		Locator:   loc,
+		Label:     dto.Label,
+		CreatedAt: dto.CreatedAt,
+	}
+	if err := s.repos.Bookmark.Create(bookmark); err != nil {
+		return models.SyncRejected, err
+	}
+	return models.SyncApplied, nil
+}
+
+func (s *offlineService) pushAnnotation(userID uuid.UUID, access *models.BookAccess, dto *models.SyncAnnotationDTO) (models.SyncStatus, error) {
+	existing, err := s.repos.Annotation.GetByID(dto.ID)
+	if err == nil {
+		if existing.UserID != userID {
+			return models.SyncRejected, errSyncForeign
+		}
+		if !dto.UpdatedAt.After(existing.UpdatedAt) {
+			return models.SyncStale, nil
+		}
+		existing.HighlightedText = strings.TrimSpace(dto.HighlightedText)
+		existing.Note = strings.TrimSpace(dto.Note)
+		existing.Tags = normalizeTags(dto.Tags)
+		if dto.Color != "" {
+			existing.Color = dto.Color
+		}
+		if dto.Visibility != "" {
+			existing.Visibility = dto.Visibility
+		}
+		if err := checkAnnotationText(existing); err != nil {
+			return models.SyncRejected, err
+		}
+		if err := s.repos.Annotation.Update(existing); err != nil {
+			return models.SyncRejected, err
+		}
+		return models.SyncApplied, nil
+	}
+
+	if access == nil {
+		return models.SyncRejected, errSyncNoAccess
+	}
+	loc, location, err := models.ResolveLocation(dto.Locator, dto.Location)
+	if err != nil {
+		return models.SyncRejected, err
+	}
+	annotation := &models.Annotation{
+		ID:              dto.ID,
+		UserID:          userID,
+		BookID:          dto.BookID,
+		Type:            dto.Type,
+		Location:        location,
+		Locator:         loc,
+		HighlightedText: strings.TrimSpace(dto.HighlightedText),
+		Note:            strings.TrimSpace(dto.Note),
+		Color:           dto.Color,
+		Tags:            normalizeTags(dto.Tags),
+		Visibility:      dto.Visibility,
+		CreatedAt:       dto.CreatedAt,
+	}
+	if annotation.Color == "" && annotation.Type == models.AnnotationHighlight {
+		annotation.Color = models.ColorYellow
+	}
+	if annotation.Visibility == "" {
+		annotation.Visibility = models.VisibilityPrivate
+	}
+	if err := checkAnnotationText(annotation); err != nil {
+		return models.SyncRejected, err
+	}
+	if err := s.repos.Annotation.Create(annotation); err != nil {
+		return models.SyncRejected, err
+	}
+	return models.SyncApplied, nil
+}
+
+// pushSession записывает завершённую офлайн-сессию. Активное время не может
+// быть больше длительности сессии.
+func (s *offlineService) pushSession(userID uuid.UUID, access *models.BookAccess, client models.SessionClient,
+	dto *models.SyncSessionDTO, now time.Time) (models.SyncStatus, error) {
+	if existing, err := s.repos.ReadingSession.GetByID(dto.ID); err == nil {
+		if existing.UserID != userID {
+			return models.SyncRejected, errSyncForeign
+		}
+		return models.SyncDuplicate, nil
+	}
+	if access == nil {
+		return models.SyncRejected, errSyncNoAccess
+	}
+	if !dto.EndedAt.After(dto.StartedAt) || dto.EndedAt.After(now) {
+		return models.SyncRejected, errSyncBadSessionTime
+	}
+
+	endedAt := dto.EndedAt
+	duration := min(dto.Duration, int(endedAt.Sub(dto.StartedAt).Seconds()))
+	session := &models.ReadingSession{
+		ID:           dto.ID,
+		UserID:       userID,
+		BookID:       dto.BookID,
+		AccessID:     access.ID,
+		StartedAt:    dto.StartedAt,
+		EndedAt:      &endedAt,
+		LastActiveAt: &endedAt,
+		StartPage:    dto.StartPage,
+		EndPage:      dto.EndPage,
+		Duration:     duration,
+		UserAgent:    optionalString(client.UserAgent),
+		IPAddress:    optionalString(client.IPAddress),
+		DeviceType:   optionalString(client.DeviceType),
+	}
+	if err := s.repos.ReadingSession.Create(session); err != nil {
+		return models.SyncRejected, err
+	}
+	return models.SyncApplied, nil
+}
+
+// pushPosition передаёт позицию в ReadingPositionService: там уже есть
+// last-writer-wins по времени на устройстве, так что повтор безопасен
+func (s *offlineService) pushPosition(userID uuid.UUID, access *models.BookAccess, req *models.SyncRequestDTO,
+	dto *models.SyncPositionItem) (models.SyncStatus, error) {
+	if access == nil {
+		return models.SyncRejected, errSyncNoAccess
+	}
+	updatedAt := dto.UpdatedAt
+	result, err := s.positions.Sync(userID, dto.BookID, &models.SyncPositionDTO{
+		DeviceID:   req.DeviceID,
+		DeviceName: req.DeviceName,
+		Locator:    dto.Locator,
+		UpdatedAt:  &updatedAt,
+	})
+	if err != nil {
+		return models.SyncRejected, err
+	}
+	if !result.Applied {
+		return models.SyncStale, nil
+	}
+	return models.SyncApplied, nil
+}
+
+func (s *offlineService) pushDeletion(userID uuid.UUID, dto models.SyncDeletionDTO) (models.SyncStatus, error) {
+	var owner uuid.UUID
+	switch dto.Type {
+	case models.SyncBookmark:
+		bookmark, err := s.repos.Bookmark.GetByID(dto.ID)
+		if err != nil {
+			return models.SyncDuplicate, nil
+		}
+		owner = bookmark.UserID
+	case models.SyncAnnotation:
+		annotation, err := s.repos.Annotation.GetByID(dto.ID)
+		if err != nil {
+			return models.SyncDuplicate, nil
+		}
+		owner = annotation.UserID
+	default:
+		return models.SyncRejected, fmt.Errorf("cannot delete %q", dto.Type)
+	}
+	if owner != userID {
+		return models.SyncRejected, errSyncForeign
+	}
+
+	var err error
+	if dto.Type == models.SyncBookmark {
+		err = s.repos.Bookmark.Delete(dto.ID)
+	} else {
+		err = s.repos.Annotation.Delete(dto.ID)
+	}
+	if err != nil {
+		return models.SyncRejected, err
+	}
+	return models.SyncApplied, nil
+}
+
+func (s *offlineService) CleanupTombstones() (int64, error) {
+	return s.repos.Sync.DeleteTombstonesBefore(s.now().Add(-SyncTombstoneRetention))
+}
+
+// encodeSyncToken — токен синхронизации: время сервера, с которого клиент
+// ещё не видел изменений. Токен непрозрачен для клиента.
+func encodeSyncToken(t time.Time) string {
+	return syncTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(t.UnixNano(), 10)))
+}
+
+func decodeSyncToken(token string) (time.Time, error) {
+	if token == "" {
+		return time.Time{}, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, syncTokenPrefix))
+	if err != nil || !strings.HasPrefix(token, syncTokenPrefix) {
+		return time.Time{}, ErrSyncTokenInvalid
+	}
+	nanos, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || nanos <= 0 {
+		return time.Time{}, ErrSyncTokenInvalid
+	}
+	return time.Unix(0, nanos), nil
+}
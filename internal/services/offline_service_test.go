@@ -0,0 +1,205 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/auth"
+	"github.com/oneErrortime/afst/internal/models"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/oneErrortime/afst/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestOfflineService(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Book{}, &models.BookFile{}, &models.BookAccess{},
+		&models.Bookmark{}, &models.Annotation{}, &models.Follow{}, &models.ReadingPosition{}, &models.ReadingSession{},
+		&models.FileWatermark{}, &models.SyncTombstone{}, &models.UserGroup{}, &models.Subscription{}, &models.BookDownload{}))
+	repos := gormrepo.NewExtendedRepository(db)
+	files := storage.NewMemoryStorage()
+	signer := auth.NewLicenceSigner("test-secret")
+	bookAccess := NewBookAccessService(repos.BookAccess, repos.Book, repos.User, repos.Subscription, repos.UserGroup)
+	downloads := NewDownloadService(repos, bookAccess, DownloadOptions{
+		Signer: auth.NewLinkSigner("secret", time.Minute),
+		Limits: DownloadLimits{Period: 24 * time.Hour, GroupQuota: 1},
+	})
+	svc := NewOfflineService(repos, files, downloads, NewWatermarkService(repos, files, DefaultWatermarkOptions()),
+		NewReadingPositionService(repos, nil), OfflineOptions{Signer: signer})
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	svc.(*offlineService).now = func() time.Time { return now }
+
+	reader := &models.User{Email: "reader@example.com", Name: "Reader", Role: models.RoleReader, IsActive: true}
+	other := &models.User{Email: "other@example.com", Name: "Other", Role: models.RoleReader, IsActive: true}
+	require.NoError(t, db.Create(reader).Error)
+	require.NoError(t, db.Create(other).Error)
+	book := &models.Book{Title: "Солярис", Author: "Лем"}
+	require.NoError(t, db.Create(book).Error)
+
+	content := []byte("BOOKMOBI offline content")
+	_, err = files.Put("blobs/solaris", bytes.NewReader(content))
+	require.NoError(t, err)
+	meta := `{"toc":[{"title":"Глава 1","href":"ch1.html"}]}`
+	file := &models.BookFile{BookID: book.ID, FileName: "solaris.mobi", OriginalName: "Солярис.mobi", FilePath: "blobs/solaris",
+		FileType: models.FileTypeMOBI, FileSize: int64(len(content)), MimeType: "application/x-mobipocket-ebook", Hash: "x", Metadata: &meta}
+	require.NoError(t, db.Create(file).Error)
+
+	_, err = svc.Bundle(reader.ID, book.ID, nil)
+	assert.ErrorIs(t, err, ErrOfflineNoAccess)
+
+	access := &models.BookAccess{UserID: reader.ID, BookID: book.ID, Type: models.AccessTypeLoan, Status: models.AccessStatusActive,
+		StartDate: now.Add(-time.Hour), EndDate: now.Add(14 * 24 * time.Hour)}
+	require.NoError(t, db.Create(access).Error)
+	mark := &models.Bookmark{UserID: reader.ID, BookID: book.ID, Location: "page:5", Label: "Океан"}
+	require.NoError(t, repos.Bookmark.Create(mark))
+
+	_, err = svc.Bundle(reader.ID, book.ID, nil)
+	assert.ErrorIs(t, err, ErrDownloadNotAllowed, "a bundle is a download")
+
+	students := &models.UserGroup{Name: "Студенты", Type: models.GroupTypeStudent, CanDownload: true, IsActive: true}
+	require.NoError(t, db.Create(students).Error)
+	require.NoError(t, db.Model(reader).Update("group_id", students.ID).Error)
+
+	bundle, err := svc.Bundle(reader.ID, book.ID, nil)
+	require.NoError(t, err)
+	var stored models.Book
+	require.NoError(t, db.First(&stored, "id = ?", book.ID).Error)
+	assert.Equal(t, 1, stored.DownloadCount)
+	_, err = svc.Bundle(reader.ID, book.ID, nil)
+	assert.ErrorIs(t, err, ErrDownloadLimitReached)
+	var buf bytes.Buffer
+	require.NoError(t, bundle.Write(&buf))
+	require.NoError(t, bundle.Close())
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	entries := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		entries[f.Name] = data
+	}
+	assert.Equal(t, content, entries["book.mobi"], "MOBI cannot be watermarked and goes as is")
+
+	var licence models.OfflineLicence
+	require.NoError(t, json.Unmarshal(entries[models.OfflineLicenceName], &licence))
+	sig, err := base64.StdEncoding.DecodeString(string(entries[models.OfflineSignatureName]))
+	require.NoError(t, err)
+	assert.True(t, signer.Verify(entries[models.OfflineLicenceName], sig))
+	assert.Equal(t, svc.Key().KeyID, licence.KeyID)
+	assert.True(t, licence.ExpiresAt.Equal(access.EndDate), "the licence ends with the loan")
+	sum := sha256.Sum256(content)
+	assert.Equal(t, hex.EncodeToString(sum[:]), licence.ContentSHA256)
+	sum = sha256.Sum256(entries[models.OfflineManifestName])
+	assert.Equal(t, hex.EncodeToString(sum[:]), licence.ManifestSHA256)
+
+	var manifest models.OfflineManifest
+	require.NoError(t, json.Unmarshal(entries[models.OfflineManifestName], &manifest))
+	assert.False(t, manifest.File.Watermarked)
+	assert.JSONEq(t, `[{"title":"Глава 1","href":"ch1.html"}]`, string(manifest.TOC))
+	require.Len(t, manifest.Bookmarks, 1)
+	assert.Equal(t, mark.ID, manifest.Bookmarks[0].ID)
+
+	// Чтение офлайн: закладка, аннотация, сессия и позиция, удалённая закладка
+	started := now.Add(-90 * time.Minute)
+	page := 41
+	push := &models.SyncRequestDTO{
+		Token:    manifest.SyncToken,
+		DeviceID: "phone",
+		Bookmarks: []models.SyncBookmarkDTO{
+			{ID: uuid.New(), BookID: book.ID, Location: "page:40", Label: "Контакт"},
+		},
+		Annotations: []models.SyncAnnotationDTO{
+			{ID: uuid.New(), BookID: book.ID, Type: models.AnnotationNote, Location: "page:41", Note: "Гости", UpdatedAt: now.Add(-time.Hour)},
+		},
+		Sessions: []models.SyncSessionDTO{
+			{ID: uuid.New(), BookID: book.ID, StartedAt: started, EndedAt: now.Add(-time.Hour), Duration: 7200, StartPage: 5, EndPage: 41},
+		},
+		Positions: []models.SyncPositionItem{
+			{BookID: book.ID, Locator: models.Locator{Type: models.LocatorPage, Page: &page}, UpdatedAt: now.Add(-time.Hour)},
+		},
+		Deleted: []models.SyncDeletionDTO{{Type: models.SyncBookmark, ID: mark.ID}},
+	}
+	resp, err := svc.Sync(reader.ID, models.SessionClient{DeviceType: models.DeviceTypeMobile}, push)
+	require.NoError(t, err)
+	assert.False(t, resp.FullSync)
+	require.Len(t, resp.Results, 5)
+	for _, r := range resp.Results {
+		assert.Equal(t, models.SyncApplied, r.Status, "%s %s: %s", r.Type, r.ID, r.Error)
+	}
+	require.Len(t, resp.Bookmarks, 1)
+	assert.Equal(t, push.Bookmarks[0].ID, resp.Bookmarks[0].ID)
+	require.Len(t, resp.Deleted, 1)
+	assert.Equal(t, mark.ID, resp.Deleted[0].EntityID)
+
+	session, err := repos.ReadingSession.GetByID(push.Sessions[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1800, session.Duration, "active time cannot exceed the session")
+	assert.Equal(t, access.ID, session.AccessID)
+
+	// Повтор того же запроса (ответ потерялся) ничего не дублирует
+	resp, err = svc.Sync(reader.ID, models.SessionClient{}, push)
+	require.NoError(t, err)
+	statuses := map[models.SyncEntity]models.SyncStatus{}
+	for _, r := range resp.Results {
+		statuses[r.Type] = r.Status
+	}
+	assert.Equal(t, models.SyncDuplicate, statuses[models.SyncBookmark])
+	assert.Equal(t, models.SyncStale, statuses[models.SyncAnnotation])
+	assert.Equal(t, models.SyncDuplicate, statuses[models.SyncSession])
+	var count int64
+	require.NoError(t, db.Model(&models.Bookmark{}).Where("user_id = ?", reader.ID).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	// Правка аннотации на другом устройстве: побеждает более поздняя
+	edit := push.Annotations[0]
+	edit.Note = "Хари"
+	edit.UpdatedAt = now.Add(time.Minute)
+	resp, err = svc.Sync(reader.ID, models.SessionClient{}, &models.SyncRequestDTO{Token: resp.Token, DeviceID: "tablet",
+		Annotations: []models.SyncAnnotationDTO{edit}})
+	require.NoError(t, err)
+	assert.Equal(t, models.SyncApplied, resp.Results[0].Status)
+	require.Len(t, resp.Annotations, 1)
+	assert.Equal(t, "Хари", resp.Annotations[0].Note)
+
+	// Чужие записи и книги без выдачи не принимаются
+	foreign := &models.Book{Title: "Эдем", Author: "Лем"}
+	require.NoError(t, db.Create(foreign).Error)
+	resp, err = svc.Sync(other.ID, models.SessionClient{}, &models.SyncRequestDTO{DeviceID: "phone",
+		Bookmarks: []models.SyncBookmarkDTO{push.Bookmarks[0], {ID: uuid.New(), BookID: foreign.ID, Location: "page:1"}},
+		Deleted:   []models.SyncDeletionDTO{{Type: models.SyncAnnotation, ID: edit.ID}}})
+	require.NoError(t, err)
+	assert.True(t, resp.FullSync)
+	for _, r := range resp.Results {
+		assert.Equal(t, models.SyncRejected, r.Status)
+	}
+	assert.Empty(t, resp.Annotations)
+
+	_, err = svc.Sync(reader.ID, models.SessionClient{}, &models.SyncRequestDTO{Token: "v1.garbage!", DeviceID: "phone"})
+	assert.ErrorIs(t, err, ErrSyncTokenInvalid)
+	assert.True(t, strings.HasPrefix(resp.Token, syncTokenPrefix))
+
+	now = now.Add(SyncTombstoneRetention + time.Hour)
+	n, err := svc.CleanupTombstones()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/events"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+)
+
+var ErrPositionDeviceRequired = errors.New("device id is required")
+
+// ReadingPositionService синхронизирует позицию чтения между устройствами
+// пользователя. У каждого устройства своя позиция; самой актуальной
+// считается изменённая последней (last-writer-wins по времени изменения на
+// устройстве, будущее время обрезается до времени сервера). Запоздавший
+// запрос с устройства не затирает его более новую позицию.
+type ReadingPositionService interface {
+	// Sync сохраняет позицию устройства, пересчитывает прогресс выдачи и
+	// сообщает, есть ли на другом устройстве позиция новее
+	Sync(userID, bookID uuid.UUID, dto *models.SyncPositionDTO) (*models.PositionSyncDTO, error)
+	// Get — состояние синхронизации при открытии книги на устройстве
+	Get(userID, bookID uuid.UUID, deviceID string) (*models.PositionSyncDTO, error)
+	// List возвращает позиции всех устройств, новые первыми
+	List(userID, bookID uuid.UUID) ([]models.ReadingPosition, error)
+}
+
+type readingPositionService struct {
+	repo         repository.ReadingPositionRepository
+	accessRepo   repository.BookAccessRepository
+	bookRepo     repository.BookRepository
+	fileRepo     repository.BookFileRepository
+	activityRepo repository.ActivityRepository
+	bus          *events.Bus
+	now          func() time.Time
+}
+
+// NewReadingPositionService создаёт сервис; bus может быть nil — тогда
+// другие устройства узнают о новой позиции только при следующем запросе
+func NewReadingPositionService(repos *repository.ExtendedRepository, bus *events.Bus) ReadingPositionService {
+	return &readingPositionService{
+		repo:         repos.Position,
+		accessRepo:   repos.BookAccess,
+		bookRepo:     repos.Book,
+		fileRepo:     repos.BookFile,
+		activityRepo: repos.Activity,
+		bus:          bus,
+		now:          time.Now,
+	}
+}
+
+func (s *readingPositionService) Sync(userID, bookID uuid.UUID, dto *models.SyncPositionDTO) (*models.PositionSyncDTO, error) {
+	deviceID := strings.TrimSpace(dto.DeviceID)
+	if deviceID == "" {
+		return nil, ErrPositionDeviceRequired
+	}
+	if err := dto.Locator.Validate(); err != nil {
+		return nil, err
+	}
+
+	prev, _ := s.repo.GetByDevice(userID, bookID, deviceID)
+	progress, err := s.progress(bookID, dto.Locator, prev)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	changedAt := now
+	if dto.UpdatedAt != nil && dto.UpdatedAt.Before(now) {
+		changedAt = *dto.UpdatedAt
+	}
+	pos := &models.ReadingPosition{
+		UserID:          userID,
+		BookID:          bookID,
+		DeviceID:        deviceID,
+		DeviceName:      dto.DeviceName,
+		Locator:         dto.Locator,
+		Progress:        progress,
+		ClientUpdatedAt: changedAt.UTC(),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	applied, err := s.repo.Save(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := s.Get(userID, bookID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	state.Applied = applied
+	if applied && state.Latest != nil && state.Latest.DeviceID == deviceID {
+		s.apply(state.Latest)
+	}
+	return state, nil
+}
+
+func (s *readingPositionService) Get(userID, bookID uuid.UUID, deviceID string) (*models.PositionSyncDTO, error) {
+	positions, err := s.repo.ListByBook(userID, bookID)
+	if err != nil {
+		return nil, err
+	}
+	state := &models.PositionSyncDTO{}
+	for i := range positions {
+		p := &positions[i]
+		if state.Latest == nil || p.NewerThan(state.Latest) {
+			state.Latest = p
+		}
+		if p.DeviceID == deviceID {
+			state.Position = p
+		}
+	}
+	if state.Latest != nil && state.Latest.DeviceID != deviceID &&
+		(state.Position == nil || state.Latest.Locator.String() != state.Position.Locator.String()) {
+		state.JumpTo = state.Latest
+	}
+	return state, nil
+}
+
+func (s *readingPositionService) List(userID, bookID uuid.UUID) ([]models.ReadingPosition, error) {
+	return s.repo.ListByBook(userID, bookID)
+}
+
+// progress считает прочитанную долю: для страницы — от числа страниц файла
+// (или книги), для CFI и процента — по проценту от клиента. Если прогресс
+// посчитать не из чего, остаётся прежний прогресс устройства.
+func (s *readingPositionService) progress(bookID uuid.UUID, loc models.Locator, prev *models.ReadingPosition) (float32, error) {
+	if loc.Type == models.LocatorPage {
+		total, err := s.pageCount(bookID, loc.FileID)
+		if err != nil {
+			return 0, err
+		}
+		if total > 0 {
+			if *loc.Page > total {
+				return 0, fmt.Errorf("%w: page %d is past the last page %d", models.ErrInvalidLocator, *loc.Page, total)
+			}
+			return float32(*loc.Page) / float32(total) * 100, nil
+		}
+	}
+	if loc.Percent != nil {
+		return float32(*loc.Percent), nil
+	}
+	if prev != nil {
+		return prev.Progress, nil
+	}
+	return 0, nil
+}
+
+// pageCount — число страниц файла из локатора, иначе книги; 0 — неизвестно
+func (s *readingPositionService) pageCount(bookID uuid.UUID, fileID *uuid.UUID) (int, error) {
+	if fileID != nil {
+		file, err := s.fileRepo.GetByID(*fileID)
+		if err != nil || file.BookID != bookID {
+			return 0, fmt.Errorf("%w: file %s is not a file of this book", models.ErrInvalidLocator, fileID)
+		}
+		if file.PageCount != nil {
+			return *file.PageCount, nil
+		}
+	}
+	book, err := s.bookRepo.GetByID(bookID)
+	if err != nil || book.PageCount == nil {
+		return 0, nil
+	}
+	return *book.PageCount, nil
+}
+
+// apply переносит самую новую позицию в выдачу (прогресс в библиотеке) и
+// рассылает её другим устройствам пользователя
+func (s *readingPositionService) apply(pos *models.ReadingPosition) {
+	payload := events.ProgressPayload{
+		BookID:   pos.BookID.String(),
+		Progress: pos.Progress / 100,
+		DeviceID: pos.DeviceID,
+		Locator:  pos.Locator,
+	}
+	// Сотрудники читают без выдачи — тогда переносить прогресс некуда
+	if access, err := s.accessRepo.GetActiveByUserAndBook(pos.UserID, pos.BookID); err == nil {
+		if pos.Locator.Type == models.LocatorPage {
+			access.CurrentPage = *pos.Locator.Page
+		}
+		finished := access.FinishedAt != nil
+		access.SetProgress(pos.Progress)
+		now := s.now()
+		access.LastAccessedAt = &now
+		if err := s.accessRepo.Update(access); err != nil {
+			log.Printf("[position] failed to update access %s: %v", access.ID, err)
+		} else if !finished && access.FinishedAt != nil {
+			recordBookFinished(s.activityRepo, access)
+		}
+		payload.AccessID = access.ID.String()
+		payload.CurrentPage = access.CurrentPage
+	}
+
+	if s.bus == nil {
+		return
+	}
+	s.bus.Publish(events.Event{
+		Type:    events.EventReadingProgress,
+		UserID:  pos.UserID.String(),
+		Payload: payload,
+	})
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/oneErrortime/afst/internal/models"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestReadingPositionService_Sync(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Book{}, &models.BookFile{},
+		&models.BookAccess{}, &models.ReadingPosition{}))
+	repos := gormrepo.NewExtendedRepository(db)
+	svc := NewReadingPositionService(repos, nil).(*readingPositionService)
+	now := time.Now().UTC().Truncate(time.Second)
+	svc.now = func() time.Time { return now }
+
+	reader := &models.User{Email: "reader@example.com", Name: "Reader", Role: models.RoleReader, IsActive: true}
+	require.NoError(t, db.Create(reader).Error)
+	bookPages := 400
+	book := &models.Book{Title: "Война и мир", Author: "Толстой", PageCount: &bookPages}
+	require.NoError(t, db.Create(book).Error)
+	filePages := 200
+	file := &models.BookFile{BookID: book.ID, FileName: "book.pdf", OriginalName: "Война.pdf", FilePath: "blobs/ab/ab",
+		FileType: models.FileTypePDF, FileSize: 10, MimeType: "application/pdf", Hash: "ab", PageCount: &filePages}
+	require.NoError(t, db.Create(file).Error)
+	access := &models.BookAccess{UserID: reader.ID, BookID: book.ID, Type: models.AccessTypeLoan,
+		Status: models.AccessStatusActive, StartDate: now, EndDate: now.Add(24 * time.Hour)}
+	require.NoError(t, db.Create(access).Error)
+
+	page := func(n int) models.Locator {
+		return models.Locator{Type: models.LocatorPage, FileID: &file.ID, Page: &n}
+	}
+	sync := func(device string, loc models.Locator, at time.Time) *models.PositionSyncDTO {
+		state, err := svc.Sync(reader.ID, book.ID, &models.SyncPositionDTO{DeviceID: device, Locator: loc, UpdatedAt: &at})
+		require.NoError(t, err)
+		return state
+	}
+
+	phone := sync("phone", page(50), now.Add(-time.Hour))
+	assert.True(t, phone.Applied)
+	assert.Nil(t, phone.JumpTo)
+	assert.InDelta(t, 25, phone.Position.Progress, 0.01, "progress comes from the file's page count")
+
+	stored, err := repos.BookAccess.GetByID(access.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 50, stored.CurrentPage)
+	assert.InDelta(t, 25, stored.ReadProgress, 0.01)
+
+	cfi := "epubcfi(/6/4!/4/2:0)"
+	percent := 40.0
+	tablet := sync("tablet", models.Locator{Type: models.LocatorCFI, CFI: &cfi, Percent: &percent}, now.Add(-30*time.Minute))
+	assert.True(t, tablet.Applied)
+	assert.Nil(t, tablet.JumpTo, "the tablet holds the latest position")
+	assert.InDelta(t, 40, tablet.Position.Progress, 0.01)
+
+	opened, err := svc.Get(reader.ID, book.ID, "phone")
+	require.NoError(t, err)
+	require.NotNil(t, opened.JumpTo)
+	assert.Equal(t, "tablet", opened.JumpTo.DeviceID)
+	assert.Equal(t, 50, *opened.Position.Locator.Page)
+
+	// Запоздавший запрос телефона не затирает его более новую позицию
+	sync("phone", page(80), now.Add(-10*time.Minute))
+	late := sync("phone", page(60), now.Add(-20*time.Minute))
+	assert.False(t, late.Applied)
+	assert.Equal(t, 80, *late.Position.Locator.Page)
+	assert.Nil(t, late.JumpTo)
+
+	// Время из будущего обрезается до времени сервера
+	future := sync("laptop", page(10), now.Add(time.Hour))
+	assert.True(t, future.Position.ClientUpdatedAt.Equal(now))
+	// При равном времени все узлы выбирают одну позицию — по ID устройства
+	tie := sync("phone", page(90), now)
+	assert.True(t, tie.Applied)
+	assert.Equal(t, "phone", tie.Latest.DeviceID)
+
+	_, err = svc.Sync(reader.ID, book.ID, &models.SyncPositionDTO{DeviceID: "phone", Locator: page(201)})
+	assert.ErrorIs(t, err, models.ErrInvalidLocator, "page past the end of the file")
+	_, err = svc.Sync(reader.ID, book.ID, &models.SyncPositionDTO{DeviceID: " ", Locator: page(1)})
+	assert.ErrorIs(t, err, ErrPositionDeviceRequired)
+	bad := "chapter 3"
+	_, err = svc.Sync(reader.ID, book.ID, &models.SyncPositionDTO{DeviceID: "phone",
+		Locator: models.Locator{Type: models.LocatorCFI, CFI: &bad}})
+	assert.ErrorIs(t, err, models.ErrInvalidLocator)
+
+	positions, err := svc.List(reader.ID, book.ID)
+	require.NoError(t, err)
+	assert.Len(t, positions, 3)
+}
+
+func TestParseLocator(t *testing.T) {
+	loc, err := models.ParseLocator("12")
+	require.NoError(t, err)
+	assert.Equal(t, models.LocatorPage, loc.Type)
+	assert.Equal(t, "12", loc.String())
+
+	loc, err = models.ParseLocator("42.5%")
+	require.NoError(t, err)
+	assert.Equal(t, 42.5, *loc.Percent)
+	assert.Equal(t, "42.5%", loc.String())
+
+	loc, err = models.ParseLocator("epubcfi(/6/4!/4/2[chap01]:10)")
+	require.NoError(t, err)
+	assert.Equal(t, models.LocatorCFI, loc.Type)
+
+	for _, s := range []string{"глава 3", "150%", "epubcfi(garbage"} {
+		_, err = models.ParseLocator(s)
+		assert.ErrorIs(t, err, models.ErrInvalidLocator, s)
+	}
+}
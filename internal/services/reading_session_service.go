@@ -9,48 +9,76 @@ import (
 	"github.com/oneErrortime/afst/internal/repository"
 )
 
+var (
+	ErrSessionNotFound = errors.New("reading session not found")
+	ErrSessionEnded    = errors.New("reading session has ended")
+)
+
+// ReadingSessionOptions — настройки сессий чтения
+type ReadingSessionOptions struct {
+	// IdleTimeout — промежуток без пульсов, после которого читатель считается
+	// ушедшим: время не засчитывается, а сессия закрывается
+	IdleTimeout time.Duration
+}
+
+func DefaultReadingSessionOptions() ReadingSessionOptions {
+	return ReadingSessionOptions{IdleTimeout: 5 * time.Minute}
+}
+
 type readingSessionService struct {
 	sessionRepo repository.ReadingSessionRepository
 	accessRepo  repository.BookAccessRepository
+	opts        ReadingSessionOptions
+	now         func() time.Time
 }
 
 func NewReadingSessionService(
 	sessionRepo repository.ReadingSessionRepository,
 	accessRepo repository.BookAccessRepository,
+	opts ReadingSessionOptions,
 ) ReadingSessionService {
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultReadingSessionOptions().IdleTimeout
+	}
 	return &readingSessionService{
 		sessionRepo: sessionRepo,
 		accessRepo:  accessRepo,
+		opts:        opts,
+		now:         time.Now,
 	}
 }
 
-func (s *readingSessionService) StartSession(userID, bookID, accessID uuid.UUID, deviceInfo string) (*models.ReadingSession, error) {
+func (s *readingSessionService) StartSession(userID, bookID, accessID uuid.UUID, client models.SessionClient) (*models.ReadingSession, error) {
 	access, err := s.accessRepo.GetByID(accessID)
-	if err != nil {
-		return nil, errors.New("доступ не найден")
+	// Чужая выдача или выдача другой книги неотличима от несуществующей
+	if err != nil || access.UserID != userID || access.BookID != bookID {
+		return nil, ErrSessionNotFound
 	}
 
 	if !access.IsValid() {
 		return nil, errors.New("доступ к книге истек")
 	}
 
+	now := s.now()
 	existing, _ := s.sessionRepo.GetActiveByUserAndBook(userID, bookID)
 	if existing != nil {
-		now := time.Now()
-		existing.EndedAt = &now
-		existing.Duration = int(now.Sub(existing.StartedAt).Seconds())
+		existing.End(now, existing.EndPage, s.opts.IdleTimeout)
 		if updateErr := s.sessionRepo.Update(existing); updateErr != nil {
 			_ = updateErr // не критично, продолжаем создание новой сессии
 		}
 	}
 
 	session := &models.ReadingSession{
-		UserID:     userID,
-		BookID:     bookID,
-		AccessID:   accessID,
-		StartedAt:  time.Now(),
-		StartPage:  access.CurrentPage,
-		DeviceType: &deviceInfo,
+		UserID:       userID,
+		BookID:       bookID,
+		AccessID:     accessID,
+		StartedAt:    now,
+		LastActiveAt: &now,
+		StartPage:    access.CurrentPage,
+		EndPage:      access.CurrentPage,
+		UserAgent:    optionalString(client.UserAgent),
+		IPAddress:    optionalString(client.IPAddress),
+		DeviceType:   optionalString(client.DeviceType),
 	}
 
 	if err := s.sessionRepo.Create(session); err != nil {
@@ -60,20 +88,69 @@ func (s *readingSessionService) StartSession(userID, bookID, accessID uuid.UUID,
 	return session, nil
 }
 
-func (s *readingSessionService) EndSession(sessionID uuid.UUID, endPage int) error {
-	session, err := s.sessionRepo.GetByID(sessionID)
+func (s *readingSessionService) Heartbeat(userID, sessionID uuid.UUID, dto *models.SessionHeartbeatDTO) (*models.ReadingSession, error) {
+	session, err := s.owned(userID, sessionID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if !session.IsActive() {
+		return nil, ErrSessionEnded
+	}
+
+	now := s.now()
+	if dto.Page != nil {
+		session.EndPage = *dto.Page
+	}
+	// Сессию ещё не закрыл планировщик, но читатель уже ушёл
+	if now.Sub(session.ActiveSince()) > s.opts.IdleTimeout {
+		session.End(now, session.EndPage, s.opts.IdleTimeout)
+		if err := s.sessionRepo.Update(session); err != nil {
+			return nil, err
+		}
+		return nil, ErrSessionEnded
+	}
+	session.Touch(now, !dto.Idle, s.opts.IdleTimeout)
+	if err := s.sessionRepo.Update(session); err != nil {
+		return nil, err
 	}
+	return session, nil
+}
 
-	now := time.Now()
-	session.EndedAt = &now
-	session.EndPage = endPage
-	session.Duration = int(now.Sub(session.StartedAt).Seconds())
+func (s *readingSessionService) EndSession(userID, sessionID uuid.UUID, endPage int) error {
+	session, err := s.owned(userID, sessionID)
+	if err != nil {
+		return err
+	}
 
+	// Сессию уже закрыл таймаут простоя — запоминаем только страницу
+	if !session.IsActive() {
+		session.EndPage = endPage
+		return s.sessionRepo.Update(session)
+	}
+	session.End(s.now(), endPage, s.opts.IdleTimeout)
 	return s.sessionRepo.Update(session)
 }
 
+func (s *readingSessionService) CloseIdle() (int64, error) {
+	return s.sessionRepo.CloseIdle(s.now().Add(-s.opts.IdleTimeout))
+}
+
+// owned — чужая сессия выглядит как несуществующая
+func (s *readingSessionService) owned(userID, sessionID uuid.UUID) (*models.ReadingSession, error) {
+	session, err := s.sessionRepo.GetByID(sessionID)
+	if err != nil || session.UserID != userID {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func optionalString(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return &v
+}
+
 func (s *readingSessionService) GetUserSessions(userID uuid.UUID, limit int) ([]models.ReadingSession, error) {
 	if limit <= 0 {
 		limit = 20
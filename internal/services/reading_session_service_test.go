@@ -0,0 +1,122 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestReadingSessionService_ActiveTime(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Book{}, &models.BookAccess{}, &models.ReadingSession{}))
+	repos := gormrepo.NewExtendedRepository(db)
+	svc := NewReadingSessionService(repos.ReadingSession, repos.BookAccess, ReadingSessionOptions{IdleTimeout: 5 * time.Minute})
+
+	now := time.Now().UTC().Truncate(time.Second)
+	svc.(*readingSessionService).now = func() time.Time { return now }
+
+	reader := &models.User{Email: "reader@example.com", Name: "Reader", Role: models.RoleReader, IsActive: true}
+	other := &models.User{Email: "other@example.com", Name: "Other", Role: models.RoleReader, IsActive: true}
+	require.NoError(t, db.Create(reader).Error)
+	require.NoError(t, db.Create(other).Error)
+	book := &models.Book{Title: "Солярис", Author: "Лем"}
+	require.NoError(t, db.Create(book).Error)
+	access := &models.BookAccess{UserID: reader.ID, BookID: book.ID, Type: models.AccessTypeLoan, Status: models.AccessStatusActive,
+		StartDate: now.Add(-time.Hour), EndDate: now.Add(24 * time.Hour), CurrentPage: 10}
+	require.NoError(t, db.Create(access).Error)
+
+	_, err = svc.StartSession(other.ID, book.ID, access.ID, models.SessionClient{})
+	assert.ErrorIs(t, err, ErrSessionNotFound, "someone else's access")
+	_, err = svc.StartSession(reader.ID, uuid.New(), access.ID, models.SessionClient{})
+	assert.ErrorIs(t, err, ErrSessionNotFound, "access to another book")
+
+	ua := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148"
+	session, err := svc.StartSession(reader.ID, book.ID, access.ID, models.SessionClient{
+		UserAgent: ua, IPAddress: "203.0.113.7", DeviceType: models.DetectDeviceType(ua),
+	})
+	require.NoError(t, err)
+	require.NotNil(t, session.DeviceType)
+	assert.Equal(t, models.DeviceTypeMobile, *session.DeviceType)
+	assert.Equal(t, "203.0.113.7", *session.IPAddress)
+
+	beat := func(page int, idle bool) (*models.ReadingSession, error) {
+		return svc.Heartbeat(reader.ID, session.ID, &models.SessionHeartbeatDTO{Page: &page, Idle: idle})
+	}
+	now = now.Add(time.Minute)
+	s, err := beat(12, false)
+	require.NoError(t, err)
+	assert.Equal(t, 60, s.Duration)
+
+	now = now.Add(time.Minute)
+	s, err = beat(12, true)
+	require.NoError(t, err)
+	assert.Equal(t, 60, s.Duration, "an idle heartbeat does not count")
+
+	now = now.Add(time.Minute)
+	s, err = beat(15, false)
+	require.NoError(t, err)
+	assert.Equal(t, 120, s.Duration, "time since the idle heartbeat counts, the idle interval does not")
+
+	_, err = svc.Heartbeat(other.ID, session.ID, &models.SessionHeartbeatDTO{})
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	// Вкладку оставили открытой на ночь
+	now = now.Add(8 * time.Hour)
+	_, err = beat(15, false)
+	assert.ErrorIs(t, err, ErrSessionEnded)
+	stored, err := repos.ReadingSession.GetByID(session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 120, stored.Duration)
+	require.NotNil(t, stored.EndedAt)
+	assert.True(t, stored.EndedAt.Equal(now.Add(-8*time.Hour)), "the session ends at the last activity")
+	assert.Equal(t, 5, stored.PagesRead())
+
+	require.NoError(t, svc.EndSession(reader.ID, session.ID, 20))
+	stored, err = repos.ReadingSession.GetByID(session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 20, stored.EndPage, "a late end only records the page")
+	assert.Equal(t, 120, stored.Duration)
+
+	// Сессию, которую никто не завершил, закрывает планировщик
+	stale, err := svc.StartSession(reader.ID, book.ID, access.ID, models.SessionClient{})
+	require.NoError(t, err)
+	assert.Nil(t, stale.UserAgent)
+	n, err := svc.CloseIdle()
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	now = now.Add(6 * time.Minute)
+	n, err = svc.CloseIdle()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	stored, err = repos.ReadingSession.GetByID(stale.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.EndedAt)
+	assert.True(t, stored.EndedAt.Equal(stale.StartedAt))
+	assert.Zero(t, stored.Duration)
+}
+
+func TestDetectDeviceType(t *testing.T) {
+	cases := map[string]string{
+		"": "",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0":            models.DeviceTypeDesktop,
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari/537.36":     models.DeviceTypeMobile,
+		"Mozilla/5.0 (Linux; Android 13; SM-X700) Safari/537.36":            models.DeviceTypeTablet,
+		"Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X)":                     models.DeviceTypeTablet,
+		"Mozilla/5.0 (X11; U; Linux armv7l like Android; en-us) Kindle/3.0": models.DeviceTypeEReader,
+		"curl/8.4.0": "",
+	}
+	for ua, want := range cases {
+		assert.Equal(t, want, models.DetectDeviceType(ua), ua)
+	}
+}
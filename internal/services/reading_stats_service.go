@@ -0,0 +1,376 @@
+package services
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+)
+
+const (
+	statsDays               = 30
+	statsWeeks              = 12
+	statsFavoriteCategories = 5
+	// statsBookSpeedMinTime — сколько нужно почитать книгу, чтобы прогноз
+	// считался по её собственной скорости, а не по средней
+	statsBookSpeedMinTime = 10 * 60
+)
+
+// ReadingStatsOptions — настройки личной статистики
+type ReadingStatsOptions struct {
+	// CacheTTL — сколько хранится посчитанная статистика; кэш сбрасывается
+	// раньше, если у пользователя изменились сессии или выдачи. 0 — без кэша
+	CacheTTL time.Duration
+}
+
+func DefaultReadingStatsOptions() ReadingStatsOptions {
+	return ReadingStatsOptions{CacheTTL: 10 * time.Minute}
+}
+
+// ReadingStatsService — личная статистика чтения по сессиям и выдачам
+type ReadingStatsService interface {
+	// GetUserStats возвращает статистику пользователя; дни считаются в loc.
+	// Результат может быть общим для нескольких запросов — не изменяйте его
+	GetUserStats(userID uuid.UUID, loc *time.Location) (*models.UserReadingStatsDTO, error)
+}
+
+type statsCacheEntry struct {
+	stats   *models.UserReadingStatsDTO
+	stamp   time.Time
+	day     string
+	expires time.Time
+}
+
+type readingStatsService struct {
+	sessionRepo  repository.ReadingSessionRepository
+	accessRepo   repository.BookAccessRepository
+	categoryRepo repository.CategoryRepository
+	opts         ReadingStatsOptions
+	now          func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]statsCacheEntry
+}
+
+func NewReadingStatsService(repos *repository.ExtendedRepository, opts ReadingStatsOptions) ReadingStatsService {
+	return &readingStatsService{
+		sessionRepo:  repos.ReadingSession,
+		accessRepo:   repos.BookAccess,
+		categoryRepo: repos.Category,
+		opts:         opts,
+		now:          time.Now,
+		cache:        make(map[string]statsCacheEntry),
+	}
+}
+
+func (s *readingStatsService) GetUserStats(userID uuid.UUID, loc *time.Location) (*models.UserReadingStatsDTO, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	now := s.now().In(loc)
+	day := now.Format("2006-01-02")
+	key := userID.String() + "|" + loc.String()
+
+	// Отпечаток данных: статистика меняется только вместе с сессиями и выдачами
+	// (и со сменой дня — из-за серий и разбивки по дням)
+	stamp, err := s.stamp(userID)
+	if err != nil {
+		return nil, err
+	}
+	if s.opts.CacheTTL > 0 {
+		s.mu.Lock()
+		entry, ok := s.cache[key]
+		s.mu.Unlock()
+		if ok && entry.day == day && entry.stamp.Equal(stamp) && now.Before(entry.expires) {
+			return entry.stats, nil
+		}
+	}
+
+	stats, err := s.compute(userID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.opts.CacheTTL > 0 {
+		s.mu.Lock()
+		for k, e := range s.cache {
+			if !now.Before(e.expires) {
+				delete(s.cache, k)
+			}
+		}
+		s.cache[key] = statsCacheEntry{stats: stats, stamp: stamp, day: day, expires: now.Add(s.opts.CacheTTL)}
+		s.mu.Unlock()
+	}
+	return stats, nil
+}
+
+func (s *readingStatsService) stamp(userID uuid.UUID) (time.Time, error) {
+	sessions, err := s.sessionRepo.LastUpdatedByUser(userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	accesses, err := s.accessRepo.LastUpdatedByUser(userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if accesses.After(sessions) {
+		return accesses, nil
+	}
+	return sessions, nil
+}
+
+// bookReading — чтение одной книги по сессиям
+type bookReading struct {
+	readTime int64
+	// speedTime и speedPages — только сессии, в которых менялась страница
+	speedTime  int64
+	speedPages int
+}
+
+func (b bookReading) pagesPerHour() float64 {
+	if b.speedTime <= 0 {
+		return 0
+	}
+	return float64(b.speedPages) / (float64(b.speedTime) / 3600)
+}
+
+func (s *readingStatsService) compute(userID uuid.UUID, now time.Time) (*models.UserReadingStatsDTO, error) {
+	sessions, err := s.sessionRepo.GetEndedByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	accesses, err := s.accessRepo.GetByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := now.Location()
+	stats := &models.UserReadingStatsDTO{Timezone: loc.String(), GeneratedAt: now}
+
+	days := make(map[string]*models.ReadingPeriodDTO)
+	books := make(map[uuid.UUID]*bookReading)
+	var total bookReading
+	for _, session := range sessions {
+		if session.Duration <= 0 {
+			continue
+		}
+		pages := session.PagesRead()
+		seconds := int64(session.Duration)
+
+		dayKey := session.StartedAt.In(loc).Format("2006-01-02")
+		d, ok := days[dayKey]
+		if !ok {
+			d = &models.ReadingPeriodDTO{Start: dayKey}
+			days[dayKey] = d
+		}
+		d.ReadTime += seconds
+		d.Pages += pages
+		d.Sessions++
+
+		book, ok := books[session.BookID]
+		if !ok {
+			book = &bookReading{}
+			books[session.BookID] = book
+		}
+		for _, b := range []*bookReading{book, &total} {
+			b.readTime += seconds
+			if pages > 0 {
+				b.speedTime += seconds
+				b.speedPages += pages
+			}
+		}
+		stats.TotalSessions++
+		stats.PagesRead += pages
+	}
+	stats.TotalReadTime = total.readTime
+	stats.PagesPerHour = round1(total.pagesPerHour())
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	stats.Daily, stats.AvgDailyReadTime = dailyPeriods(days, today)
+	stats.Weekly = weeklyPeriods(days, today)
+	stats.CurrentStreak, stats.LongestStreak = streaks(days, today)
+
+	// Дочитанной книга остаётся, даже если при новой выдаче прогресс сброшен
+	finished := make(map[uuid.UUID]bool)
+	for _, access := range accesses {
+		if access.FinishedAt != nil {
+			finished[access.BookID] = true
+		}
+	}
+	stats.BooksFinished = len(finished)
+
+	stats.Books = []models.BookEstimateDTO{}
+	for _, access := range accesses {
+		if !access.IsValid() || finished[access.BookID] {
+			continue
+		}
+		stats.BooksInProgress++
+		stats.Books = append(stats.Books, estimate(access, books[access.BookID], total, stats.AvgDailyReadTime, now))
+	}
+
+	stats.FavoriteCategories, err = s.favoriteCategories(books)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// estimate прогнозирует дочитывание: по оставшимся страницам и скорости
+// чтения книги (или средней, если книгу читали мало), а без числа страниц —
+// по времени, ушедшему на уже прочитанную долю
+func estimate(access models.BookAccess, book *bookReading, total bookReading, dailyTime int64, now time.Time) models.BookEstimateDTO {
+	if book == nil {
+		book = &bookReading{}
+	}
+	est := models.BookEstimateDTO{
+		BookID:      access.BookID,
+		Progress:    access.ReadProgress,
+		CurrentPage: access.CurrentPage,
+		ReadTime:    book.readTime,
+	}
+	if access.Book != nil {
+		est.Title = access.Book.Title
+		est.PageCount = access.Book.PageCount
+	}
+
+	speed := total.pagesPerHour()
+	if book.speedTime >= statsBookSpeedMinTime {
+		speed = book.pagesPerHour()
+	}
+	var remaining int64 = -1
+	if est.PageCount != nil && *est.PageCount > 0 {
+		left := *est.PageCount - access.CurrentPage
+		if left < 0 {
+			left = 0
+		}
+		est.RemainingPages = &left
+		if speed > 0 {
+			est.PagesPerHour = round1(speed)
+			remaining = int64(float64(left) / speed * 3600)
+		}
+	} else if access.ReadProgress > 0 && book.readTime > 0 {
+		remaining = int64(float64(book.readTime) * float64(100-access.ReadProgress) / float64(access.ReadProgress))
+	}
+	if remaining < 0 {
+		return est
+	}
+	est.RemainingTime = &remaining
+	if dailyTime > 0 {
+		finish := now.Add(time.Duration(float64(remaining) / float64(dailyTime) * float64(24*time.Hour)))
+		est.EstimatedFinishAt = &finish
+	}
+	return est
+}
+
+// dailyPeriods — последние statsDays дней по сегодня, старые первыми,
+// и среднее время чтения в день за них
+func dailyPeriods(days map[string]*models.ReadingPeriodDTO, today time.Time) ([]models.ReadingPeriodDTO, int64) {
+	periods := make([]models.ReadingPeriodDTO, 0, statsDays)
+	var sum int64
+	for i := statsDays - 1; i >= 0; i-- {
+		key := today.AddDate(0, 0, -i).Format("2006-01-02")
+		p := models.ReadingPeriodDTO{Start: key}
+		if d, ok := days[key]; ok {
+			p = *d
+		}
+		sum += p.ReadTime
+		periods = append(periods, p)
+	}
+	return periods, sum / statsDays
+}
+
+// weeklyPeriods — последние statsWeeks недель с понедельника, старые первыми
+func weeklyPeriods(days map[string]*models.ReadingPeriodDTO, today time.Time) []models.ReadingPeriodDTO {
+	monday := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	periods := make([]models.ReadingPeriodDTO, 0, statsWeeks)
+	for w := statsWeeks - 1; w >= 0; w-- {
+		start := monday.AddDate(0, 0, -7*w)
+		p := models.ReadingPeriodDTO{Start: start.Format("2006-01-02")}
+		for i := 0; i < 7; i++ {
+			if d, ok := days[start.AddDate(0, 0, i).Format("2006-01-02")]; ok {
+				p.ReadTime += d.ReadTime
+				p.Pages += d.Pages
+				p.Sessions += d.Sessions
+			}
+		}
+		periods = append(periods, p)
+	}
+	return periods
+}
+
+// streaks — текущая и самая длинная серия дней с чтением. Текущая серия
+// заканчивается сегодня или, если сегодня ещё не читали, вчера.
+func streaks(days map[string]*models.ReadingPeriodDTO, today time.Time) (current, longest int) {
+	keys := make([]string, 0, len(days))
+	for k := range days {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	run := 0
+	var prev time.Time
+	for _, k := range keys {
+		day, err := time.ParseInLocation("2006-01-02", k, today.Location())
+		if err != nil {
+			continue
+		}
+		if run > 0 && prev.AddDate(0, 0, 1).Equal(day) {
+			run++
+		} else {
+			run = 1
+		}
+		prev = day
+		if run > longest {
+			longest = run
+		}
+	}
+	if run > 0 && (prev.Equal(today) || prev.AddDate(0, 0, 1).Equal(today)) {
+		current = run
+	}
+	return current, longest
+}
+
+func (s *readingStatsService) favoriteCategories(books map[uuid.UUID]*bookReading) ([]models.CategoryStatDTO, error) {
+	ids := make([]uuid.UUID, 0, len(books))
+	for id := range books {
+		ids = append(ids, id)
+	}
+	byBook, err := s.categoryRepo.GetByBookIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	byCategory := make(map[uuid.UUID]*models.CategoryStatDTO)
+	for bookID, categories := range byBook {
+		for _, c := range categories {
+			stat, ok := byCategory[c.ID]
+			if !ok {
+				stat = &models.CategoryStatDTO{CategoryID: c.ID, Name: c.Name}
+				byCategory[c.ID] = stat
+			}
+			stat.ReadTime += books[bookID].readTime
+			stat.Books++
+		}
+	}
+	favorites := make([]models.CategoryStatDTO, 0, len(byCategory))
+	for _, stat := range byCategory {
+		favorites = append(favorites, *stat)
+	}
+	sort.Slice(favorites, func(i, j int) bool {
+		if favorites[i].ReadTime != favorites[j].ReadTime {
+			return favorites[i].ReadTime > favorites[j].ReadTime
+		}
+		return favorites[i].Name < favorites[j].Name
+	})
+	if len(favorites) > statsFavoriteCategories {
+		favorites = favorites[:statsFavoriteCategories]
+	}
+	return favorites, nil
+}
+
+func round1(v float64) float64 {
+	return float64(int64(v*10+0.5)) / 10
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/oneErrortime/afst/internal/models"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestReadingStatsService(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Category{}, &models.Book{}, &models.BookAccess{}, &models.ReadingSession{}))
+	repos := gormrepo.NewExtendedRepository(db)
+	svc := NewReadingStatsService(repos, DefaultReadingStatsOptions())
+
+	// Не в полночь, чтобы сессии «сегодня» не попадали на вчера
+	now := time.Now().UTC()
+	if now.Hour() < 2 {
+		now = now.Add(2 * time.Hour)
+	}
+	svc.(*readingStatsService).now = func() time.Time { return now }
+
+	reader := &models.User{Email: "reader@example.com", Name: "Reader", Role: models.RoleReader, IsActive: true}
+	require.NoError(t, db.Create(reader).Error)
+	scifi := models.Category{Name: "Фантастика", Slug: "scifi"}
+	classics := models.Category{Name: "Классика", Slug: "classics"}
+	pages := 300
+	solaris := &models.Book{Title: "Солярис", Author: "Лем", PageCount: &pages, Categories: []models.Category{scifi}}
+	karenina := &models.Book{Title: "Анна Каренина", Author: "Толстой", Categories: []models.Category{classics}}
+	finished := &models.Book{Title: "Нос", Author: "Гоголь"}
+	for _, b := range []*models.Book{solaris, karenina, finished} {
+		require.NoError(t, db.Create(b).Error)
+	}
+
+	access := func(book *models.Book, page int, progress float32) *models.BookAccess {
+		a := &models.BookAccess{UserID: reader.ID, BookID: book.ID, Type: models.AccessTypeLoan, Status: models.AccessStatusActive,
+			StartDate: time.Now().Add(-30 * 24 * time.Hour), EndDate: time.Now().Add(24 * time.Hour), CurrentPage: page}
+		a.SetProgress(progress)
+		require.NoError(t, db.Create(a).Error)
+		return a
+	}
+	solarisAccess := access(solaris, 60, 20)
+	kareninaAccess := access(karenina, 0, 50)
+	finishedAccess := access(finished, 0, 100)
+
+	session := func(a *models.BookAccess, daysAgo, seconds, from, to int) {
+		start := now.AddDate(0, 0, -daysAgo).Add(-time.Hour)
+		end := start.Add(time.Duration(seconds) * time.Second)
+		require.NoError(t, db.Create(&models.ReadingSession{UserID: reader.ID, BookID: a.BookID, AccessID: a.ID,
+			StartedAt: start, EndedAt: &end, Duration: seconds, StartPage: from, EndPage: to}).Error)
+	}
+	session(solarisAccess, 0, 1800, 30, 60)
+	session(solarisAccess, 1, 1800, 0, 30)
+	session(kareninaAccess, 3, 3600, 0, 0)
+
+	stats, err := svc.GetUserStats(reader.ID, time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7200), stats.TotalReadTime)
+	assert.Equal(t, 3, stats.TotalSessions)
+	assert.Equal(t, 60, stats.PagesRead)
+	assert.Equal(t, 60.0, stats.PagesPerHour, "sessions without page changes do not dilute the speed")
+	assert.Equal(t, 1, stats.BooksFinished)
+	assert.Equal(t, 2, stats.BooksInProgress)
+	assert.Equal(t, 2, stats.CurrentStreak)
+	assert.Equal(t, 2, stats.LongestStreak)
+	assert.Equal(t, int64(7200/30), stats.AvgDailyReadTime)
+
+	require.Len(t, stats.Daily, 30)
+	assert.Equal(t, now.Format("2006-01-02"), stats.Daily[29].Start)
+	assert.Equal(t, int64(1800), stats.Daily[29].ReadTime)
+	assert.Equal(t, 30, stats.Daily[29].Pages)
+	require.Len(t, stats.Weekly, 12)
+	assert.Equal(t, time.Monday, mustDate(t, stats.Weekly[11].Start).Weekday())
+
+	require.Len(t, stats.FavoriteCategories, 2)
+	assert.Equal(t, "Классика", stats.FavoriteCategories[0].Name, "equal time is ordered by name")
+	assert.Equal(t, int64(3600), stats.FavoriteCategories[1].ReadTime)
+
+	estimates := map[string]models.BookEstimateDTO{}
+	for _, b := range stats.Books {
+		estimates[b.Title] = b
+	}
+	require.NotNil(t, estimates["Солярис"].RemainingTime)
+	assert.Equal(t, 240, *estimates["Солярис"].RemainingPages)
+	assert.Equal(t, int64(240*60), *estimates["Солярис"].RemainingTime, "240 pages at 60 pages per hour")
+	require.NotNil(t, estimates["Солярис"].EstimatedFinishAt)
+	assert.Equal(t, now.Add(60*24*time.Hour), *estimates["Солярис"].EstimatedFinishAt, "4 hours at 4 minutes a day")
+	require.NotNil(t, estimates["Анна Каренина"].RemainingTime)
+	assert.Equal(t, int64(3600), *estimates["Анна Каренина"].RemainingTime, "half read in an hour")
+
+	cached, err := svc.GetUserStats(reader.ID, time.UTC)
+	require.NoError(t, err)
+	assert.Same(t, stats, cached)
+
+	solarisAccess.SetProgress(100)
+	require.NoError(t, repos.BookAccess.Update(solarisAccess))
+	fresh, err := svc.GetUserStats(reader.ID, time.UTC)
+	require.NoError(t, err)
+	assert.NotSame(t, stats, fresh, "a changed access invalidates the cache")
+	assert.Equal(t, 2, fresh.BooksFinished)
+
+	// Прогресс сброшен при повторной выдаче, но книга уже была дочитана
+	finishedAccess.ReadProgress = 0
+	require.NoError(t, repos.BookAccess.Update(finishedAccess))
+	reset, err := svc.GetUserStats(reader.ID, time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, 2, reset.BooksFinished, "books finished counts FinishedAt, like reading goals")
+
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	local, err := svc.GetUserStats(reader.ID, moscow)
+	require.NoError(t, err)
+	assert.Equal(t, "Europe/Moscow", local.Timezone)
+}
+
+func mustDate(t *testing.T, s string) time.Time {
+	d, err := time.Parse("2006-01-02", s)
+	require.NoError(t, err)
+	return d
+}
@@ -7,15 +7,25 @@ import (
 )
 
 type reviewService struct {
-	repo repository.ReviewRepository
+	repo         repository.ReviewRepository
+	activityRepo repository.ActivityRepository
 }
 
-func NewReviewService(repo repository.ReviewRepository) ReviewService {
-	return &reviewService{repo: repo}
+func NewReviewService(repo repository.ReviewRepository, activityRepo repository.ActivityRepository) ReviewService {
+	return &reviewService{repo: repo, activityRepo: activityRepo}
 }
 
 func (s *reviewService) CreateReview(review *models.Review) error {
-	return s.repo.Create(review)
+	if err := s.repo.Create(review); err != nil {
+		return err
+	}
+	recordActivity(s.activityRepo, &models.Activity{
+		UserID:    review.UserID,
+		Type:      models.ActivityReviewWritten,
+		SubjectID: review.ID,
+		BookID:    &review.BookID,
+	})
+	return nil
 }
 
 func (s *reviewService) GetReviewsByBookID(bookID uuid.UUID) ([]models.Review, error) {
@@ -31,5 +41,13 @@ func (s *reviewService) UpdateReview(review *models.Review) error {
 }
 
 func (s *reviewService) DeleteReview(id uuid.UUID) error {
-	return s.repo.Delete(id)
+	review, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Delete(id); err != nil {
+		return err
+	}
+	forgetActivity(s.activityRepo, review.UserID, models.ActivityReviewWritten, id)
+	return nil
 }
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+	"github.com/oneErrortime/afst/internal/scheduler"
+	"gorm.io/gorm"
+)
+
+var ErrScheduledTaskNotFound = errors.New("scheduled task not found")
+
+// SchedulerService — просмотр периодических задач, их истории и ручной запуск.
+type SchedulerService interface {
+	List() ([]models.ScheduledTaskDTO, error)
+	Get(name string) (*models.ScheduledTaskDTO, error)
+	Runs(name string, limit int) ([]models.ScheduledTaskRun, error)
+	// RunNow запрашивает внеплановый запуск; выполнит его первый освободившийся инстанс.
+	RunNow(name string) error
+}
+
+type schedulerService struct {
+	repo  repository.SchedulerRepository
+	sched *scheduler.Scheduler
+}
+
+// NewSchedulerService — sched может быть nil, тогда доступен только просмотр
+func NewSchedulerService(repo repository.SchedulerRepository, sched *scheduler.Scheduler) SchedulerService {
+	return &schedulerService{repo: repo, sched: sched}
+}
+
+func (s *schedulerService) List() ([]models.ScheduledTaskDTO, error) {
+	tasks, err := s.repo.List()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]models.ScheduledTaskDTO, 0, len(tasks))
+	for _, t := range tasks {
+		result = append(result, s.toDTO(t))
+	}
+	return result, nil
+}
+
+func (s *schedulerService) Get(name string) (*models.ScheduledTaskDTO, error) {
+	task, err := s.repo.Get(name)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrScheduledTaskNotFound
+		}
+		return nil, err
+	}
+	dto := s.toDTO(*task)
+	return &dto, nil
+}
+
+func (s *schedulerService) Runs(name string, limit int) ([]models.ScheduledTaskRun, error) {
+	if _, err := s.Get(name); err != nil {
+		return nil, err
+	}
+	return s.repo.ListRuns(name, limit)
+}
+
+func (s *schedulerService) RunNow(name string) error {
+	if s.sched == nil {
+		return ErrScheduledTaskNotFound
+	}
+	err := s.sched.RunNow(name)
+	if errors.Is(err, scheduler.ErrUnknownTask) || errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrScheduledTaskNotFound
+	}
+	return err
+}
+
+func (s *schedulerService) toDTO(task models.ScheduledTask) models.ScheduledTaskDTO {
+	dto := models.ScheduledTaskDTO{ScheduledTask: task}
+	if s.sched != nil {
+		dto.Registered = s.sched.Registered(task.Name)
+		dto.Running = s.sched.Running(task.Name)
+	}
+	return dto
+}
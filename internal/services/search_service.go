@@ -0,0 +1,117 @@
+package services
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+)
+
+var ErrSearchQueryEmpty = errors.New("search query has no words")
+
+const (
+	// searchMaxTerms — лишние слова запроса отбрасываются
+	searchMaxTerms = 8
+	// searchPrefixMinRunes — слова от этой длины ищутся по префиксу,
+	// чтобы «войн» находило «войны» и «войной»
+	searchPrefixMinRunes = 3
+)
+
+// SearchService — поиск по тексту книг. Проверка доступа к книге — на вызывающей стороне.
+type SearchService interface {
+	SearchBook(bookID uuid.UUID, query string, limit, offset int) (*models.BookSearchResultDTO, error)
+	// SearchLibrary ищет по всем проиндексированным книгам (для библиотекарей).
+	SearchLibrary(query string, limit int) ([]models.LibrarySearchHitDTO, error)
+}
+
+type searchService struct {
+	repo repository.BookTextRepository
+}
+
+func NewSearchService(repo repository.BookTextRepository) SearchService {
+	return &searchService{repo: repo}
+}
+
+func (s *searchService) SearchBook(bookID uuid.UUID, query string, limit, offset int) (*models.BookSearchResultDTO, error) {
+	match, err := buildFTSQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, err := s.repo.GetIndexes(bookID)
+	if err != nil {
+		return nil, err
+	}
+	result := &models.BookSearchResultDTO{
+		Query:   query,
+		Indexed: len(indexes) > 0,
+		Hits:    []models.BookSearchHitDTO{},
+	}
+	if !result.Indexed {
+		return result, nil
+	}
+
+	hits, total, err := s.repo.Search(bookID, match, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	for i := range hits {
+		hits[i].Snippet = highlightSnippet(hits[i].Snippet)
+	}
+	result.Hits, result.Total = hits, total
+	return result, nil
+}
+
+// snippetMarks превращает границы совпадений в <mark> после экранирования:
+// текст книги попадает в ответ только как текст, а не как HTML
+var snippetMarks = strings.NewReplacer(
+	repository.SnippetMatchStart, "<mark>",
+	repository.SnippetMatchEnd, "</mark>",
+)
+
+func highlightSnippet(snippet string) string {
+	return snippetMarks.Replace(html.EscapeString(snippet))
+}
+
+func (s *searchService) SearchLibrary(query string, limit int) ([]models.LibrarySearchHitDTO, error) {
+	match, err := buildFTSQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.SearchLibrary(match, limit)
+}
+
+// buildFTSQuery превращает пользовательский ввод в выражение FTS5.
+// Операторы FTS5 пользователю недоступны: каждое слово берётся в кавычки,
+// слова объединяются через AND. Запрос в кавычках ищется как фраза.
+func buildFTSQuery(query string) (string, error) {
+	query = strings.TrimSpace(query)
+	phrase := len(query) > 1 && strings.HasPrefix(query, `"`) && strings.HasSuffix(query, `"`)
+
+	terms := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(terms) == 0 {
+		return "", ErrSearchQueryEmpty
+	}
+	if len(terms) > searchMaxTerms {
+		terms = terms[:searchMaxTerms]
+	}
+
+	if phrase {
+		return `"` + strings.Join(terms, " ") + `"`, nil
+	}
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = `"` + t + `"`
+		if utf8.RuneCountInString(t) >= searchPrefixMinRunes {
+			parts[i] += "*"
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestBuildFTSQuery(t *testing.T) {
+	cases := map[string]string{
+		"война":                   `"война"*`,
+		"  Война и мир ":          `"Война"* "и" "мир"*`,
+		`"война и мир"`:           `"война и мир"`,
+		`OR NEAR(a b) "x`:         `"OR" "NEAR"* "a" "b" "x"`,
+		"пьер-безухов, 1812":      `"пьер"* "безухов"* "1812"*`,
+		"a b c d e f g h i j k l": `"a" "b" "c" "d" "e" "f" "g" "h"`,
+	}
+	for in, want := range cases {
+		got, err := buildFTSQuery(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{"", "   ", `"*" - ()`} {
+		_, err := buildFTSQuery(in)
+		assert.ErrorIs(t, err, ErrSearchQueryEmpty, in)
+	}
+}
+
+func TestSearchService(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Book{}, &models.BookTextIndex{}))
+	require.NoError(t, repository.MigrateFullText(db))
+	repos := gormrepo.NewExtendedRepository(db)
+	svc := NewSearchService(repos.BookText)
+
+	war := &models.Book{Title: "Война и мир", Author: "Толстой"}
+	anna := &models.Book{Title: "Анна Каренина", Author: "Толстой"}
+	unindexed := &models.Book{Title: "Черновик", Author: "Аноним"}
+	for _, b := range []*models.Book{war, anna, unindexed} {
+		require.NoError(t, db.Create(b).Error)
+	}
+
+	page := func(n int) *int { return &n }
+	warFile := uuid.New()
+	require.NoError(t, repos.BookText.ReplaceFile(
+		&models.BookTextIndex{FileID: warFile, BookID: war.ID, Format: models.FileTypePDF, ChunkCount: 4, IndexedAt: time.Now()},
+		[]models.BookTextChunk{
+			{Ordinal: 1, Page: page(1), Text: "Eh bien, mon prince. Gênes et Lucques ne sont plus que des apanages"},
+			{Ordinal: 2, Page: page(2), Text: "Князь Андрей смотрел на небо над Аустерлицем"},
+			{Ordinal: 3, Page: page(3), Text: "Пьер думал о войне и о Наташе"},
+			{Ordinal: 4, Page: page(4), Text: "Наташа танцевала"},
+		},
+	))
+	annaFile := uuid.New()
+	require.NoError(t, repos.BookText.ReplaceFile(
+		&models.BookTextIndex{FileID: annaFile, BookID: anna.ID, Format: models.FileTypeEPUB, ChunkCount: 1, IndexedAt: time.Now()},
+		[]models.BookTextChunk{
+			{Ordinal: 1, Href: "OEBPS/ch1.xhtml", CFI: "epubcfi(/6/2!)", Text: "Все счастливые семьи похожи; Наташа здесь ни при чём"},
+		},
+	))
+
+	res, err := svc.SearchBook(war.ID, "наташ", 10, 0)
+	require.NoError(t, err)
+	assert.True(t, res.Indexed)
+	assert.EqualValues(t, 2, res.Total)
+	require.Len(t, res.Hits, 2)
+	assert.Equal(t, warFile, res.Hits[0].FileID)
+	assert.Equal(t, models.FileTypePDF, res.Hits[0].Format)
+	assert.Contains(t, res.Hits[0].Snippet, "<mark>Наташ")
+	assert.Greater(t, res.Hits[0].Score, 0.0)
+
+	res, err = svc.SearchBook(war.ID, "gênes lucques", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, res.Hits, 1)
+	assert.Equal(t, 1, *res.Hits[0].Page)
+
+	res, err = svc.SearchBook(anna.ID, "семьи", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, res.Hits, 1)
+	assert.Equal(t, "epubcfi(/6/2!)", res.Hits[0].CFI)
+	assert.Equal(t, "OEBPS/ch1.xhtml", res.Hits[0].Href)
+
+	// Разметка из текста книги приходит экранированной, размечены только совпадения
+	markupFile := uuid.New()
+	require.NoError(t, repos.BookText.ReplaceFile(
+		&models.BookTextIndex{FileID: markupFile, BookID: anna.ID, Format: models.FileTypeEPUB, ChunkCount: 1, IndexedAt: time.Now()},
+		[]models.BookTextChunk{{Ordinal: 2, Href: "OEBPS/ch2.xhtml", Text: `Левин писал <img src=x onerror="alert(1)"> и думал`}},
+	))
+	res, err = svc.SearchBook(anna.ID, "левин", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, res.Hits, 1)
+	assert.Contains(t, res.Hits[0].Snippet, "<mark>Левин</mark>")
+	assert.Contains(t, res.Hits[0].Snippet, "&lt;img src=x onerror=&#34;alert(1)&#34;&gt;")
+	assert.NotContains(t, res.Hits[0].Snippet, "<img")
+	require.NoError(t, repos.BookText.DeleteFile(markupFile))
+
+	res, err = svc.SearchBook(unindexed.ID, "наташа", 10, 0)
+	require.NoError(t, err)
+	assert.False(t, res.Indexed)
+	assert.Empty(t, res.Hits)
+
+	// Anna: 1 of 1 chunks matches, War and Peace: 2 of 4
+	hits, err := svc.SearchLibrary("наташ", 10)
+	require.NoError(t, err)
+	require.Len(t, hits, 2)
+	assert.Equal(t, anna.ID, hits[0].BookID)
+	assert.InDelta(t, 1.0, hits[0].Density, 1e-9)
+	assert.Equal(t, war.ID, hits[1].BookID)
+	assert.Equal(t, 2, hits[1].Hits)
+	assert.InDelta(t, 0.5, hits[1].Density, 1e-9)
+
+	// Re-indexing replaces the file's chunks, deleting drops them
+	require.NoError(t, repos.BookText.ReplaceFile(
+		&models.BookTextIndex{FileID: warFile, BookID: war.ID, Format: models.FileTypePDF, ChunkCount: 1, IndexedAt: time.Now()},
+		[]models.BookTextChunk{{Ordinal: 1, Page: page(1), Text: "Новая редакция"}},
+	))
+	res, err = svc.SearchBook(war.ID, "наташ", 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, res.Hits)
+
+	require.NoError(t, repos.BookText.DeleteFile(annaFile))
+	hits, err = svc.SearchLibrary("наташ", 10)
+	require.NoError(t, err)
+	assert.Empty(t, hits)
+
+	_, err = svc.SearchBook(war.ID, "!!!", 10, 0)
+	assert.ErrorIs(t, err, ErrSearchQueryEmpty)
+}
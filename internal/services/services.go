@@ -1,9 +1,15 @@
 package services
 
 import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
 	"github.com/oneErrortime/afst/internal/auth"
 	"github.com/oneErrortime/afst/internal/events"
 	"github.com/oneErrortime/afst/internal/repository"
+	"github.com/oneErrortime/afst/internal/scheduler"
 	"github.com/oneErrortime/afst/internal/storage"
 	"github.com/oneErrortime/afst/internal/worker"
 )
@@ -19,6 +25,23 @@ func NewServices(repos *repository.Repository, jwtService *auth.JWTService) *Ser
 }
 
 func NewExtendedServices(repos *repository.ExtendedRepository, jwtService *auth.JWTService, fileStorage storage.FileStorage) *Services {
+	featureFlags := NewFeatureFlagService(repos.FeatureFlag)
+	bookFiles := NewBookFileService(repos.BookFile, repos.Book, repos.BookText, fileStorage, DefaultFileCheckOptions())
+	uploads := UploadOptions{
+		Partials: storage.NewPartialStore(filepath.Join(os.TempDir(), "afst-uploads")),
+		Limits:   DefaultUploadLimits(),
+	}
+	// Случайный ключ: выданные ссылки действуют до перезапуска
+	downloads := DownloadOptions{
+		Signer: auth.NewLinkSigner(uuid.NewString(), 10*time.Minute),
+		Limits: DefaultDownloadLimits(),
+	}
+	bookAccess := NewBookAccessService(repos.BookAccess, repos.Book, repos.User, repos.Subscription, repos.UserGroup)
+	watermark := NewWatermarkService(repos, fileStorage, DefaultWatermarkOptions())
+	positions := NewReadingPositionService(repos, nil)
+	offline := OfflineOptions{Signer: auth.NewLicenceSigner(uuid.NewString())}
+	download := NewDownloadService(repos, bookAccess, downloads)
+
 	return &Services{
 		Auth:           NewAuthService(repos.User, repos.UserGroup, jwtService),
 		Book:           NewBookService(repos.Book),
@@ -27,46 +50,102 @@ func NewExtendedServices(repos *repository.ExtendedRepository, jwtService *auth.
 		UserGroup:      NewUserGroupService(repos.UserGroup, repos.User),
 		Category:       NewCategoryService(repos.Category),
 		Subscription:   NewSubscriptionService(repos.Subscription, repos.User),
-		BookAccess:     NewBookAccessService(repos.BookAccess, repos.Book, repos.User, repos.Subscription, repos.UserGroup),
-		BookFile:       NewBookFileService(repos.BookFile, repos.Book, fileStorage),
-		ReadingSession: NewReadingSessionService(repos.ReadingSession, repos.BookAccess),
-		FeatureFlag:    NewFeatureFlagService(repos.FeatureFlag),
+		BookAccess:     bookAccess,
+		BookFile:       bookFiles,
+		Download:       download,
+		Watermark:      watermark,
+		Upload:         NewUploadService(repos.Upload, repos.Book, bookFiles, uploads),
+		Blob:           NewBlobService(repos.Blob, fileStorage),
+		ReadingSession: NewReadingSessionService(repos.ReadingSession, repos.BookAccess, DefaultReadingSessionOptions()),
+		FeatureFlag:    featureFlags,
 		Social:         NewSocialService(repos.Social),
-		Collection:     NewCollectionService(repos.Collection),
-		Review:         NewReviewService(repos.Review),
+		Collection:     NewCollectionService(repos.Collection, repos.Activity),
+		Review:         NewReviewService(repos.Review, repos.Activity),
 		Bookmark:       NewBookmarkService(repos.Bookmark),
 		APIKey:         NewAPIKeyService(repos.APIKey),
+		Webhook:        NewWebhookService(repos.Webhook, repos.User, featureFlags, nil),
+		Job:            NewJobService(repos.Job),
+		Scheduler:      NewSchedulerService(repos.Scheduler, nil),
+		Cover:          NewCoverService(repos.BookCover, repos.Book, fileStorage, nil),
+		Search:         NewSearchService(repos.BookText),
+		Position:       positions,
+		Annotation:     NewAnnotationService(repos.Annotation, repos.Social),
+		Stats:          NewReadingStatsService(repos, DefaultReadingStatsOptions()),
+		Goal:           NewGoalService(repos, nil),
+		Offline:        NewOfflineService(repos, fileStorage, download, watermark, positions, offline),
+		Activity:       NewActivityService(repos.Activity),
 	}
 }
 
-// NewExtendedServicesWithWorkers wires in the worker pool and event bus
-// for async file processing and real-time event streaming.
-func NewExtendedServicesWithWorkers(
-	repos *repository.ExtendedRepository,
-	jwtService *auth.JWTService,
-	fileStorage storage.FileStorage,
-	bus *events.Bus,
-	pool *worker.Pool,
-) *Services {
-	processor := worker.NewFileProcessor(pool, repos.BookFile, bus)
+// Deps are the dependencies of NewExtendedServicesWithWorkers, assembled in cmd/server.
+type Deps struct {
+	Repos   *repository.ExtendedRepository
+	JWT     *auth.JWTService
+	Storage storage.FileStorage
+	Bus     *events.Bus
+
+	// FileQueue processes uploaded book files
+	FileQueue *worker.Queue
+	// WebhookQueue delivers webhooks and schedules their retries
+	WebhookQueue *worker.Queue
+	CoverPool    *worker.Pool
+	Scheduler    *scheduler.Scheduler
+
+	Uploads    UploadOptions
+	Downloads  DownloadOptions
+	Watermarks WatermarkOptions
+	Checks     FileCheckOptions
+	Stats      ReadingStatsOptions
+	Sessions   ReadingSessionOptions
+	Offline    OfflineOptions
+}
+
+// NewExtendedServicesWithWorkers wires in the persistent job queues, worker pools,
+// scheduler and event bus for async file processing and real-time event streaming.
+func NewExtendedServicesWithWorkers(d Deps) *Services {
+	covers := NewCoverService(d.Repos.BookCover, d.Repos.Book, d.Storage, d.CoverPool)
+	processor := worker.NewFileProcessor(d.FileQueue, d.Repos.BookFile, d.Repos.Book, d.Repos.BookText, d.Storage, d.Bus)
+	processor.SetCoverSink(covers)
+	processor.SetOutbox(d.Repos)
+	featureFlags := NewFeatureFlagService(d.Repos.FeatureFlag)
+	bookFiles := NewBookFileServiceWithWorker(d.Repos, d.Storage, processor, d.Bus, d.Checks)
+	bookAccess := NewBookAccessServiceWithOutbox(d.Repos)
+	watermark := NewWatermarkService(d.Repos, d.Storage, d.Watermarks)
+	positions := NewReadingPositionService(d.Repos, d.Bus)
+	download := NewDownloadService(d.Repos, bookAccess, d.Downloads)
 
 	return &Services{
-		Auth:           NewAuthService(repos.User, repos.UserGroup, jwtService),
-		Book:           NewBookService(repos.Book),
-		Reader:         NewReaderService(repos.Reader),
-		Borrow:         NewBorrowServiceWithTransaction(repos),
-		UserGroup:      NewUserGroupService(repos.UserGroup, repos.User),
-		Category:       NewCategoryService(repos.Category),
-		Subscription:   NewSubscriptionService(repos.Subscription, repos.User),
-		BookAccess:     NewBookAccessService(repos.BookAccess, repos.Book, repos.User, repos.Subscription, repos.UserGroup),
-		BookFile:       NewBookFileServiceWithWorker(repos.BookFile, repos.Book, fileStorage, processor, bus),
-		ReadingSession: NewReadingSessionService(repos.ReadingSession, repos.BookAccess),
-		FeatureFlag:    NewFeatureFlagService(repos.FeatureFlag),
-		Social:         NewSocialService(repos.Social),
-		Collection:     NewCollectionService(repos.Collection),
-		Review:         NewReviewService(repos.Review),
-		Bookmark:       NewBookmarkService(repos.Bookmark),
-		APIKey:         NewAPIKeyService(repos.APIKey),
+		Auth:           NewAuthService(d.Repos.User, d.Repos.UserGroup, d.JWT),
+		Book:           NewBookService(d.Repos.Book),
+		Reader:         NewReaderService(d.Repos.Reader),
+		Borrow:         NewBorrowServiceWithTransaction(d.Repos),
+		UserGroup:      NewUserGroupService(d.Repos.UserGroup, d.Repos.User),
+		Category:       NewCategoryService(d.Repos.Category),
+		Subscription:   NewSubscriptionServiceWithOutbox(d.Repos),
+		BookAccess:     bookAccess,
+		BookFile:       bookFiles,
+		Download:       download,
+		Watermark:      watermark,
+		Upload:         NewUploadService(d.Repos.Upload, d.Repos.Book, bookFiles, d.Uploads),
+		Blob:           NewBlobService(d.Repos.Blob, d.Storage),
+		ReadingSession: NewReadingSessionService(d.Repos.ReadingSession, d.Repos.BookAccess, d.Sessions),
+		FeatureFlag:    featureFlags,
+		Social:         NewSocialService(d.Repos.Social),
+		Collection:     NewCollectionService(d.Repos.Collection, d.Repos.Activity),
+		Review:         NewReviewService(d.Repos.Review, d.Repos.Activity),
+		Bookmark:       NewBookmarkService(d.Repos.Bookmark),
+		APIKey:         NewAPIKeyService(d.Repos.APIKey),
+		Webhook:        NewWebhookService(d.Repos.Webhook, d.Repos.User, featureFlags, d.WebhookQueue),
+		Job:            NewJobService(d.Repos.Job, d.FileQueue, d.WebhookQueue),
+		Scheduler:      NewSchedulerService(d.Repos.Scheduler, d.Scheduler),
+		Cover:          covers,
+		Search:         NewSearchService(d.Repos.BookText),
+		Position:       positions,
+		Annotation:     NewAnnotationService(d.Repos.Annotation, d.Repos.Social),
+		Stats:          NewReadingStatsService(d.Repos, d.Stats),
+		Goal:           NewGoalService(d.Repos, d.Bus),
+		Offline:        NewOfflineService(d.Repos, d.Storage, download, watermark, positions, d.Offline),
+		Activity:       NewActivityService(d.Repos.Activity),
 	}
 }
 
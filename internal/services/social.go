@@ -9,8 +9,8 @@ import (
 type SocialRepository interface {
 	Follow(userID, targetUserID uuid.UUID) error
 	Unfollow(userID, targetUserID uuid.UUID) error
-	GetFollowers(targetUserID uuid.UUID) ([]models.User, error)
-	GetFollowing(userID uuid.UUID) ([]models.User, error)
+	GetFollowers(targetUserID uuid.UUID, limit, offset int) ([]models.User, int64, error)
+	GetFollowing(userID uuid.UUID, limit, offset int) ([]models.User, int64, error)
 	IsFollowing(userID, targetUserID uuid.UUID) (bool, error)
 	GetUserPublicProfile(userID uuid.UUID) (*models.UserPublicProfileDTO, error)
 }
@@ -35,3 +35,28 @@ func (s *socialService) UnfollowUser(userID, targetUserID uuid.UUID) error {
 func (s *socialService) GetUserProfile(userID uuid.UUID) (*models.UserPublicProfileDTO, error) {
 	return s.repo.GetUserPublicProfile(userID)
 }
+
+func (s *socialService) GetFollowers(userID uuid.UUID, limit, offset int) ([]models.UserSummaryDTO, int64, error) {
+	users, total, err := s.repo.GetFollowers(userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return userSummaries(users), total, nil
+}
+
+func (s *socialService) GetFollowing(userID uuid.UUID, limit, offset int) ([]models.UserSummaryDTO, int64, error) {
+	users, total, err := s.repo.GetFollowing(userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return userSummaries(users), total, nil
+}
+
+// userSummaries оставляет только публичные поля: списки подписчиков видны всем
+func userSummaries(users []models.User) []models.UserSummaryDTO {
+	result := make([]models.UserSummaryDTO, len(users))
+	for i := range users {
+		result[i] = models.NewUserSummaryDTO(&users[i])
+	}
+	return result
+}
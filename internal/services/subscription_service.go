@@ -5,13 +5,16 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/events"
 	"github.com/oneErrortime/afst/internal/models"
 	"github.com/oneErrortime/afst/internal/repository"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
 )
 
 type subscriptionService struct {
 	subscriptionRepo repository.SubscriptionRepository
 	userRepo         repository.UserRepository
+	extendedRepo     *repository.ExtendedRepository
 }
 
 func NewSubscriptionService(subscriptionRepo repository.SubscriptionRepository, userRepo repository.UserRepository) SubscriptionService {
@@ -21,6 +24,16 @@ func NewSubscriptionService(subscriptionRepo repository.SubscriptionRepository,
 	}
 }
 
+// NewSubscriptionServiceWithOutbox записывает события подписки в outbox
+// в одной транзакции с изменением подписки.
+func NewSubscriptionServiceWithOutbox(extendedRepo *repository.ExtendedRepository) SubscriptionService {
+	return &subscriptionService{
+		subscriptionRepo: extendedRepo.Subscription,
+		userRepo:         extendedRepo.User,
+		extendedRepo:     extendedRepo,
+	}
+}
+
 func (s *subscriptionService) Create(userID uuid.UUID, plan models.SubscriptionPlan) (*models.Subscription, error) {
 	_, err := s.userRepo.GetByID(userID)
 	if err != nil {
@@ -51,10 +64,12 @@ func (s *subscriptionService) Create(userID uuid.UUID, plan models.SubscriptionP
 		Currency:        "RUB",
 	}
 
-	if err := s.subscriptionRepo.Create(subscription); err != nil {
+	err = s.persist(events.EventSubscriptionNew, subscription, func(repo repository.SubscriptionRepository) error {
+		return repo.Create(subscription)
+	})
+	if err != nil {
 		return nil, err
 	}
-
 	return subscription, nil
 }
 
@@ -98,6 +113,33 @@ func (s *subscriptionService) Renew(id uuid.UUID) error {
 	return s.subscriptionRepo.Update(subscription)
 }
 
+// expireBatch — сколько подписок обрабатываем за один проход
+const expireBatch = 100
+
+func (s *subscriptionService) ExpireOverdue() (int, error) {
+	expired := 0
+	for {
+		batch, err := s.subscriptionRepo.GetExpiredActive(time.Now(), expireBatch)
+		if err != nil {
+			return expired, err
+		}
+		if len(batch) == 0 {
+			return expired, nil
+		}
+		for i := range batch {
+			sub := &batch[i]
+			sub.Status = models.SubStatusExpired
+			err := s.persist(events.EventSubscriptionExpired, sub, func(repo repository.SubscriptionRepository) error {
+				return repo.Update(sub)
+			})
+			if err != nil {
+				return expired, err
+			}
+			expired++
+		}
+	}
+}
+
 func (s *subscriptionService) GetPlans() []models.SubscriptionPlanConfig {
 	return models.SubscriptionPlans
 }
@@ -110,3 +152,26 @@ func (s *subscriptionService) getPlanConfig(plan models.SubscriptionPlan) *model
 	}
 	return nil
 }
+
+// persist выполняет write и, если подключён outbox, в той же транзакции записывает событие.
+func (s *subscriptionService) persist(eventType events.EventType, sub *models.Subscription, write func(repository.SubscriptionRepository) error) error {
+	if s.extendedRepo == nil || s.extendedRepo.Outbox == nil {
+		return write(s.subscriptionRepo)
+	}
+	return gormrepo.WithTransaction(s.extendedRepo, func(txRepo *repository.ExtendedRepository) error {
+		if err := write(txRepo.Subscription); err != nil {
+			return err
+		}
+		return txRepo.Outbox.Enqueue(events.Event{
+			Type:   eventType,
+			UserID: sub.UserID.String(),
+			Payload: events.SubscriptionPayload{
+				SubscriptionID: sub.ID.String(),
+				UserID:         sub.UserID.String(),
+				Plan:           string(sub.Plan),
+				Status:         string(sub.Status),
+				EndDate:        sub.EndDate.Format(time.RFC3339),
+			},
+		})
+	})
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/oneErrortime/afst/internal/events"
+	"github.com/oneErrortime/afst/internal/models"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestSubscriptionService_ExpireOverdue(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Subscription{}, &models.OutboxEvent{}))
+	repos := gormrepo.NewExtendedRepository(db)
+	svc := NewSubscriptionServiceWithOutbox(repos)
+
+	user := &models.User{Email: "sub@example.com", Name: "Sub", Role: models.RoleReader, IsActive: true}
+	require.NoError(t, db.Create(user).Error)
+
+	past := time.Now().Add(-time.Hour)
+	overdue := &models.Subscription{UserID: user.ID, Plan: models.PlanBasic, Status: models.SubStatusActive, StartDate: past.AddDate(0, -1, 0), EndDate: past}
+	renewing := &models.Subscription{UserID: user.ID, Plan: models.PlanBasic, Status: models.SubStatusActive, StartDate: past.AddDate(0, -1, 0), EndDate: past, AutoRenew: true}
+	current := &models.Subscription{UserID: user.ID, Plan: models.PlanBasic, Status: models.SubStatusActive, StartDate: past, EndDate: time.Now().AddDate(0, 1, 0)}
+	for _, s := range []*models.Subscription{overdue, renewing, current} {
+		require.NoError(t, db.Create(s).Error)
+	}
+
+	n, err := svc.ExpireOverdue()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	got, err := repos.Subscription.GetByID(overdue.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.SubStatusExpired, got.Status)
+
+	got, err = repos.Subscription.GetByID(renewing.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.SubStatusActive, got.Status, "auto-renewing subscriptions are left to billing")
+
+	pending, err := repos.Outbox.GetPending(10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, string(events.EventSubscriptionExpired), pending[0].Type)
+	var payload events.SubscriptionPayload
+	require.NoError(t, json.Unmarshal([]byte(pending[0].Payload), &payload))
+	assert.Equal(t, overdue.ID.String(), payload.SubscriptionID)
+	assert.Equal(t, string(models.SubStatusExpired), payload.Status)
+
+	n, err = svc.ExpireOverdue()
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+	"github.com/oneErrortime/afst/internal/storage"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadBookNotFound   = errors.New("book not found")
+	ErrUploadForbidden      = errors.New("role may not upload files")
+	ErrUploadTooLarge       = errors.New("upload exceeds the size limit")
+	ErrUploadBadFileType    = errors.New("unsupported file type")
+	ErrUploadOffsetMismatch = errors.New("upload offset mismatch")
+	ErrUploadLocked         = errors.New("upload is being written by another request")
+	ErrUploadNotActive      = errors.New("upload is already finished")
+	ErrUploadExpired        = errors.New("upload expired")
+	ErrUploadFinalize       = errors.New("upload could not be saved as a book file")
+)
+
+// uploadCleanupBatch — сколько брошенных загрузок удаляется за один проход
+const uploadCleanupBatch = 100
+
+// UploadLimits — ограничения возобновляемых загрузок
+type UploadLimits struct {
+	// MaxSize — предельный размер файла по роли; роли без записи загружать не могут
+	MaxSize map[models.UserRole]int64
+	// Expiry — срок жизни незавершённой загрузки с последнего фрагмента
+	Expiry time.Duration
+}
+
+// DefaultUploadLimits — 1 ГБ для библиотекаря, 4 ГБ для администратора, сутки на докачку
+func DefaultUploadLimits() UploadLimits {
+	return UploadLimits{
+		MaxSize: map[models.UserRole]int64{
+			models.RoleLibrarian: 1 << 30,
+			models.RoleAdmin:     4 << 30,
+		},
+		Expiry: 24 * time.Hour,
+	}
+}
+
+// UploadOptions — временное хранилище и ограничения возобновляемых загрузок
+type UploadOptions struct {
+	Partials *storage.PartialStore
+	Limits   UploadLimits
+}
+
+// CreateUploadRequest — параметры новой загрузки из заголовков tus
+type CreateUploadRequest struct {
+	BookID   uuid.UUID
+	FileName string
+	Size     int64
+	// Metadata — исходный заголовок Upload-Metadata, отдаётся обратно в HEAD
+	Metadata string
+}
+
+// UploadService — возобновляемые загрузки файлов книг (протокол tus).
+// Фрагменты копятся во временном хранилище; последний фрагмент передаёт файл
+// в BookFileService.Upload, то есть в общую проверку дубликатов и обработку.
+type UploadService interface {
+	// MaxSize — предельный размер загрузки для роли, 0 — загрузка запрещена
+	MaxSize(role models.UserRole) int64
+	Create(userID uuid.UUID, role models.UserRole, req CreateUploadRequest) (*models.Upload, error)
+	// Get возвращает загрузку только её автору
+	Get(userID, id uuid.UUID) (*models.Upload, error)
+	// Append дописывает фрагмент с позиции offset. length — объявленный размер
+	// фрагмента или -1. Последний фрагмент создаёт BookFile.
+	Append(userID, id uuid.UUID, offset, length int64, r io.Reader) (*models.Upload, error)
+	Terminate(userID, id uuid.UUID) error
+	// CleanupExpired удаляет брошенные загрузки вместе с временными файлами
+	CleanupExpired() (int, error)
+}
+
+type uploadService struct {
+	repo      repository.UploadRepository
+	bookRepo  repository.BookRepository
+	bookFiles BookFileService
+	partials  *storage.PartialStore
+	limits    UploadLimits
+	// locks не дают двум запросам писать одну загрузку; между экземплярами
+	// сервера порядок охраняет условное обновление смещения в БД
+	locks sync.Map
+}
+
+func NewUploadService(
+	repo repository.UploadRepository,
+	bookRepo repository.BookRepository,
+	bookFiles BookFileService,
+	opts UploadOptions,
+) UploadService {
+	return &uploadService{
+		repo:      repo,
+		bookRepo:  bookRepo,
+		bookFiles: bookFiles,
+		partials:  opts.Partials,
+		limits:    opts.Limits,
+	}
+}
+
+func (s *uploadService) MaxSize(role models.UserRole) int64 {
+	return s.limits.MaxSize[role]
+}
+
+func (s *uploadService) Create(userID uuid.UUID, role models.UserRole, req CreateUploadRequest) (*models.Upload, error) {
+	limit := s.MaxSize(role)
+	if limit <= 0 {
+		return nil, ErrUploadForbidden
+	}
+	if req.Size > limit {
+		return nil, ErrUploadTooLarge
+	}
+	if !storage.IsAllowedFileName(req.FileName) {
+		return nil, ErrUploadBadFileType
+	}
+	if _, err := s.bookRepo.GetByID(req.BookID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUploadBookNotFound
+		}
+		return nil, err
+	}
+
+	upload := &models.Upload{
+		UserID:    userID,
+		BookID:    req.BookID,
+		FileName:  req.FileName,
+		Size:      req.Size,
+		Metadata:  req.Metadata,
+		Status:    models.UploadStatusActive,
+		ExpiresAt: time.Now().Add(s.limits.Expiry),
+	}
+	if err := s.repo.Create(upload); err != nil {
+		return nil, err
+	}
+	if err := s.partials.Create(upload.ID.String()); err != nil {
+		_ = s.repo.Delete(upload.ID)
+		return nil, err
+	}
+	return upload, nil
+}
+
+func (s *uploadService) Get(userID, id uuid.UUID) (*models.Upload, error) {
+	upload, err := s.repo.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	if upload.UserID != userID {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+func (s *uploadService) Append(userID, id uuid.UUID, offset, length int64, r io.Reader) (*models.Upload, error) {
+	if _, err := s.Get(userID, id); err != nil {
+		return nil, err
+	}
+
+	lock := s.lock(id)
+	if !lock.TryLock() {
+		return nil, ErrUploadLocked
+	}
+	defer lock.Unlock()
+
+	// Перечитываем под блокировкой: смещение мог сдвинуть предыдущий запрос
+	upload, err := s.Get(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Status != models.UploadStatusActive {
+		return nil, ErrUploadNotActive
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, ErrUploadExpired
+	}
+	if offset != upload.Offset {
+		return nil, ErrUploadOffsetMismatch
+	}
+	remaining := upload.Size - upload.Offset
+	if length > remaining {
+		return nil, ErrUploadTooLarge
+	}
+
+	n, writeErr := s.partials.Append(id.String(), offset, r, remaining)
+	if n > 0 {
+		expiresAt := time.Now().Add(s.limits.Expiry)
+		ok, err := s.repo.AdvanceOffset(id, offset, offset+n, expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrUploadOffsetMismatch
+		}
+		upload.Offset, upload.ExpiresAt = offset+n, expiresAt
+	}
+	// Оборванное соединение: сохранённые байты учтены, клиент продолжит с них
+	if writeErr != nil {
+		return upload, writeErr
+	}
+
+	if upload.Offset == upload.Size {
+		if err := s.finalize(upload); err != nil {
+			return upload, err
+		}
+	}
+	return upload, nil
+}
+
+// finalize передаёт собранный файл в обычную загрузку файла книги
+func (s *uploadService) finalize(upload *models.Upload) error {
+	id := upload.ID.String()
+	defer func() {
+		if err := s.partials.Remove(id); err != nil {
+			log.Printf("[upload] failed to remove partial file of %s: %v", id, err)
+		}
+		s.locks.Delete(upload.ID)
+	}()
+
+	f, err := s.partials.Open(id)
+	if err != nil {
+		return s.fail(upload, err)
+	}
+	bookFile, err := s.bookFiles.Upload(upload.BookID, f, &multipart.FileHeader{
+		Filename: upload.FileName,
+		Size:     upload.Size,
+	})
+	_ = f.Close()
+	if err != nil {
+		return s.fail(upload, err)
+	}
+
+	upload.Status = models.UploadStatusCompleted
+	upload.BookFileID = &bookFile.ID
+	return s.repo.Update(upload)
+}
+
+func (s *uploadService) fail(upload *models.Upload, cause error) error {
+	msg := cause.Error()
+	upload.Status = models.UploadStatusFailed
+	upload.Error = &msg
+	if err := s.repo.Update(upload); err != nil {
+		log.Printf("[upload] failed to mark %s as failed: %v", upload.ID, err)
+	}
+	return fmt.Errorf("%w: %w", ErrUploadFinalize, cause)
+}
+
+func (s *uploadService) Terminate(userID, id uuid.UUID) error {
+	if _, err := s.Get(userID, id); err != nil {
+		return err
+	}
+	lock := s.lock(id)
+	if !lock.TryLock() {
+		return ErrUploadLocked
+	}
+	defer lock.Unlock()
+	defer s.locks.Delete(id)
+
+	if err := s.partials.Remove(id.String()); err != nil {
+		return err
+	}
+	return s.repo.Delete(id)
+}
+
+func (s *uploadService) CleanupExpired() (int, error) {
+	uploads, err := s.repo.ListExpired(time.Now(), uploadCleanupBatch)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, u := range uploads {
+		lock := s.lock(u.ID)
+		if !lock.TryLock() {
+			continue // чанк пишется прямо сейчас
+		}
+		if err := s.partials.Remove(u.ID.String()); err != nil {
+			log.Printf("[upload] failed to remove partial file of %s: %v", u.ID, err)
+		} else if err := s.repo.Delete(u.ID); err != nil {
+			log.Printf("[upload] failed to delete expired upload %s: %v", u.ID, err)
+		} else {
+			removed++
+		}
+		lock.Unlock()
+		s.locks.Delete(u.ID)
+	}
+	return removed, nil
+}
+
+func (s *uploadService) lock(id uuid.UUID) *sync.Mutex {
+	m, _ := s.locks.LoadOrStore(id, &sync.Mutex{})
+	return m.(*sync.Mutex)
+}
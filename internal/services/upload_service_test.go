@@ -0,0 +1,147 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/oneErrortime/afst/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// brokenReader отдаёт данные и обрывается, как потерянное соединение
+type brokenReader struct{ data []byte }
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestUploadService(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Book{}, &models.BookFile{}, &models.Blob{}, &models.Upload{}))
+	repos := gormrepo.NewExtendedRepository(db)
+
+	partialDir := t.TempDir()
+	bookFiles := NewBookFileService(repos.BookFile, repos.Book, repos.BookText, storage.NewLocalStorage(t.TempDir(), ""), DefaultFileCheckOptions())
+	svc := NewUploadService(repos.Upload, repos.Book, bookFiles, UploadOptions{
+		Partials: storage.NewPartialStore(partialDir),
+		Limits: UploadLimits{
+			MaxSize: map[models.UserRole]int64{models.RoleLibrarian: 1024},
+			Expiry:  time.Hour,
+		},
+	})
+
+	book := &models.Book{Title: "Мёртвые души", Author: "Гоголь"}
+	require.NoError(t, db.Create(book).Error)
+	userID, otherID := uuid.New(), uuid.New()
+	content := append(append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte("x"), 300)...), testPDFTrailer...)
+	size := int64(len(content))
+
+	create := func(role models.UserRole, name string, size int64) (*models.Upload, error) {
+		return svc.Create(userID, role, CreateUploadRequest{BookID: book.ID, FileName: name, Size: size})
+	}
+
+	t.Run("limits and validation", func(t *testing.T) {
+		_, err := create(models.RoleReader, "book.pdf", 10)
+		assert.ErrorIs(t, err, ErrUploadForbidden)
+		_, err = create(models.RoleLibrarian, "book.pdf", 2048)
+		assert.ErrorIs(t, err, ErrUploadTooLarge)
+		_, err = create(models.RoleLibrarian, "book.exe", 10)
+		assert.ErrorIs(t, err, ErrUploadBadFileType)
+		_, err = svc.Create(userID, models.RoleLibrarian, CreateUploadRequest{BookID: uuid.New(), FileName: "book.pdf", Size: 10})
+		assert.ErrorIs(t, err, ErrUploadBookNotFound)
+	})
+
+	t.Run("chunks resume after a dropped connection and finalize into a book file", func(t *testing.T) {
+		upload, err := create(models.RoleLibrarian, "book.pdf", size)
+		require.NoError(t, err)
+
+		_, err = svc.Get(otherID, upload.ID)
+		assert.ErrorIs(t, err, ErrUploadNotFound, "uploads are visible to their owner only")
+
+		got, err := svc.Append(userID, upload.ID, 0, -1, &brokenReader{data: content[:100]})
+		require.Error(t, err)
+		assert.Equal(t, int64(100), got.Offset, "bytes received before the drop are kept")
+
+		_, err = svc.Append(userID, upload.ID, 50, -1, bytes.NewReader(content[50:]))
+		assert.ErrorIs(t, err, ErrUploadOffsetMismatch)
+
+		got, err = svc.Append(userID, upload.ID, 100, 100, bytes.NewReader(content[100:200]))
+		require.NoError(t, err)
+		assert.Equal(t, int64(200), got.Offset)
+		assert.Equal(t, models.UploadStatusActive, got.Status)
+
+		_, err = svc.Append(userID, upload.ID, 200, size, bytes.NewReader(content[200:]))
+		assert.ErrorIs(t, err, ErrUploadTooLarge, "a chunk may not run past Upload-Length")
+
+		got, err = svc.Append(userID, upload.ID, 200, -1, bytes.NewReader(content[200:]))
+		require.NoError(t, err)
+		assert.Equal(t, size, got.Offset)
+		assert.Equal(t, models.UploadStatusCompleted, got.Status)
+		require.NotNil(t, got.BookFileID)
+
+		file, err := bookFiles.GetByID(*got.BookFileID)
+		require.NoError(t, err)
+		assert.Equal(t, book.ID, file.BookID)
+		assert.Equal(t, size, file.FileSize)
+		assert.NoFileExists(t, filepath.Join(partialDir, upload.ID.String()+".part"))
+
+		_, err = svc.Append(userID, upload.ID, size, 0, bytes.NewReader(nil))
+		assert.ErrorIs(t, err, ErrUploadNotActive)
+
+		// Тот же файл повторно отклоняется проверкой дубликатов
+		dup, err := create(models.RoleLibrarian, "copy.pdf", size)
+		require.NoError(t, err)
+		_, err = svc.Append(userID, dup.ID, 0, size, bytes.NewReader(content))
+		assert.ErrorIs(t, err, ErrUploadFinalize)
+		failed, err := svc.Get(userID, dup.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.UploadStatusFailed, failed.Status)
+		assert.NotNil(t, failed.Error)
+	})
+
+	t.Run("terminate and expiry cleanup remove partial files", func(t *testing.T) {
+		upload, err := create(models.RoleLibrarian, "book.epub", 100)
+		require.NoError(t, err)
+		require.NoError(t, svc.Terminate(userID, upload.ID))
+		_, err = svc.Get(userID, upload.ID)
+		assert.ErrorIs(t, err, ErrUploadNotFound)
+		assert.NoFileExists(t, filepath.Join(partialDir, upload.ID.String()+".part"))
+
+		abandoned, err := create(models.RoleLibrarian, "book.epub", 100)
+		require.NoError(t, err)
+		_, err = svc.Append(userID, abandoned.ID, 0, 10, bytes.NewReader(make([]byte, 10)))
+		require.NoError(t, err)
+		require.NoError(t, db.Model(&models.Upload{}).Where("id = ?", abandoned.ID).
+			Update("expires_at", time.Now().Add(-time.Minute)).Error)
+
+		_, err = svc.Append(userID, abandoned.ID, 10, 10, bytes.NewReader(make([]byte, 10)))
+		assert.ErrorIs(t, err, ErrUploadExpired)
+
+		n, err := svc.CleanupExpired()
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		_, err = svc.Get(userID, abandoned.ID)
+		assert.ErrorIs(t, err, ErrUploadNotFound)
+		_, statErr := os.Stat(filepath.Join(partialDir, abandoned.ID.String()+".part"))
+		assert.True(t, os.IsNotExist(statErr))
+	})
+}
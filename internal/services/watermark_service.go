@@ -0,0 +1,236 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+	"github.com/oneErrortime/afst/internal/storage"
+	"github.com/oneErrortime/afst/internal/worker"
+)
+
+const (
+	// watermarkCacheDir — каталог кэша помеченных копий в хранилище
+	watermarkCacheDir = "watermarks"
+	// watermarkCleanupBatch — сколько просроченных копий удаляется за один запрос
+	watermarkCleanupBatch = 200
+)
+
+var (
+	ErrWatermarkUnsupported = errors.New("watermarking is not supported for this file")
+	ErrWatermarkNotFound    = errors.New("no watermark found in the file")
+)
+
+// WatermarkOptions — кэш помеченных копий
+type WatermarkOptions struct {
+	// CacheTTL — сколько копия хранится для повторных скачиваний того же
+	// пользователя; 0 — копия создаётся на каждое скачивание и не сохраняется
+	CacheTTL time.Duration
+	// TempDir — каталог для некэшируемых копий; пусто — os.TempDir()
+	TempDir string
+}
+
+// DefaultWatermarkOptions — копии кэшируются на сутки
+func DefaultWatermarkOptions() WatermarkOptions {
+	return WatermarkOptions{CacheTTL: 24 * time.Hour}
+}
+
+// WatermarkedCopy — помеченная копия, готовая к отдаче: либо Path в
+// хранилище (кэш, можно отдать прямой ссылкой), либо временный файл Temp,
+// который удаляется при Close.
+type WatermarkedCopy struct {
+	Mark *models.FileWatermark
+	Path string
+	Temp storage.Object
+}
+
+// WatermarkTrace — результат опознания утёкшего файла
+type WatermarkTrace struct {
+	Mark worker.Watermark
+	// Record — запись о выдаче; nil, если в базе её нет (знак подделан или база другая)
+	Record *models.FileWatermark
+	// User — получатель копии; nil, если аккаунт удалён
+	User *models.User
+	// EmailMatches — хеш в файле совпадает с текущим e-mail пользователя
+	EmailMatches bool
+}
+
+// WatermarkService помечает скачиваемые PDF и EPUB данными получателя
+// (социальный DRM): видимой строкой или страницей и метаданными с ID
+// пользователя, хешем e-mail и временем выдачи. Каждая выданная копия
+// записывается, чтобы по утёкшему файлу можно было найти аккаунт.
+type WatermarkService interface {
+	// Prepare возвращает копию файла для пользователя, из кэша или новую.
+	// ErrWatermarkUnsupported — формат не помечается (MOBI, зашифрованный PDF).
+	Prepare(file *models.BookFile, user *models.User) (*WatermarkedCopy, error)
+	// Trace читает водяной знак из файла и находит выдачу и пользователя
+	Trace(src io.ReaderAt, size int64) (*WatermarkTrace, error)
+	// CleanupCache удаляет из хранилища копии с истёкшим сроком кэша
+	CleanupCache() (int, error)
+}
+
+type watermarkService struct {
+	repo        repository.WatermarkRepository
+	userRepo    repository.UserRepository
+	fileStorage storage.FileStorage
+	opts        WatermarkOptions
+}
+
+func NewWatermarkService(repos *repository.ExtendedRepository, fileStorage storage.FileStorage, opts WatermarkOptions) WatermarkService {
+	return &watermarkService{
+		repo:        repos.Watermark,
+		userRepo:    repos.User,
+		fileStorage: fileStorage,
+		opts:        opts,
+	}
+}
+
+func (s *watermarkService) Prepare(file *models.BookFile, user *models.User) (*WatermarkedCopy, error) {
+	if file.FileType != models.FileTypePDF && file.FileType != models.FileTypeEPUB {
+		return nil, ErrWatermarkUnsupported
+	}
+	now := time.Now()
+	if s.opts.CacheTTL > 0 {
+		if mark, err := s.repo.FindCached(user.ID, file.ID, now); err == nil && s.fileStorage.Exists(*mark.CachePath) {
+			return &WatermarkedCopy{Mark: mark, Path: *mark.CachePath}, nil
+		}
+	}
+
+	src, err := s.fileStorage.Get(file.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = src.Close() }()
+	info, err := src.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	mark := &models.FileWatermark{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		FileID:    file.ID,
+		EmailHash: worker.EmailHash(user.Email),
+		CreatedAt: now,
+	}
+	tmp, err := os.CreateTemp(s.opts.TempDir, "afst-watermark-*")
+	if err != nil {
+		return nil, err
+	}
+	discard := func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}
+
+	err = worker.WatermarkFile(string(file.FileType), src, info.Size(), worker.Watermark{
+		ID:        mark.ID,
+		UserID:    mark.UserID,
+		EmailHash: mark.EmailHash,
+		IssuedAt:  now.UTC().Truncate(time.Second),
+	}, tmp)
+	if err != nil {
+		discard()
+		if errors.Is(err, worker.ErrWatermarkUnsupported) {
+			return nil, ErrWatermarkUnsupported
+		}
+		return nil, fmt.Errorf("watermark %s: %w", file.ID, err)
+	}
+	// Запись сохраняется до отдачи: копия без записи не опознаётся
+	if err := s.repo.Create(mark); err != nil {
+		discard()
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		discard()
+		return nil, err
+	}
+
+	if s.opts.CacheTTL > 0 {
+		cachePath := path.Join(watermarkCacheDir, file.ID.String(), mark.ID.String())
+		_, err := s.fileStorage.Put(cachePath, tmp)
+		if err == nil {
+			err = s.repo.SetCache(mark.ID, cachePath, now.Add(s.opts.CacheTTL))
+		}
+		if err == nil {
+			discard()
+			mark.CachePath = &cachePath
+			return &WatermarkedCopy{Mark: mark, Path: cachePath}, nil
+		}
+		// Без кэша копию всё равно можно отдать из временного файла
+		log.Printf("[watermark] failed to cache %s: %v", cachePath, err)
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			discard()
+			return nil, err
+		}
+	}
+	return &WatermarkedCopy{Mark: mark, Temp: tempObject{tmp}}, nil
+}
+
+func (s *watermarkService) Trace(src io.ReaderAt, size int64) (*WatermarkTrace, error) {
+	mark, err := worker.ReadWatermark(src, size)
+	if errors.Is(err, worker.ErrWatermarkNotFound) {
+		return nil, ErrWatermarkNotFound
+	}
+	if errors.Is(err, worker.ErrWatermarkUnsupported) {
+		return nil, ErrWatermarkUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	trace := &WatermarkTrace{Mark: *mark}
+	if record, err := s.repo.GetByID(mark.ID); err == nil {
+		trace.Record = record
+	}
+	if user, err := s.userRepo.GetByID(mark.UserID); err == nil {
+		trace.User = user
+		trace.EmailMatches = worker.EmailHash(user.Email) == mark.EmailHash
+	}
+	return trace, nil
+}
+
+func (s *watermarkService) CleanupCache() (int, error) {
+	removed := 0
+	for {
+		marks, err := s.repo.ListExpiredCache(time.Now(), watermarkCleanupBatch)
+		if err != nil {
+			return removed, err
+		}
+		progress := false
+		for _, m := range marks {
+			if err := s.fileStorage.Delete(*m.CachePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("[watermark] failed to delete %s: %v", *m.CachePath, err)
+				continue
+			}
+			if err := s.repo.ClearCache(m.ID); err != nil {
+				return removed, err
+			}
+			removed++
+			progress = true
+		}
+		if len(marks) < watermarkCleanupBatch || !progress {
+			return removed, nil
+		}
+	}
+}
+
+// tempObject — временный файл, удаляемый при закрытии
+type tempObject struct {
+	*os.File
+}
+
+func (o tempObject) Close() error {
+	err := o.File.Close()
+	if rmErr := os.Remove(o.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/oneErrortime/afst/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func testEPUB(t *testing.T) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	require.NoError(t, err)
+	_, _ = w.Write([]byte("application/epub+zip"))
+	for name, body := range map[string]string{
+		"META-INF/container.xml": `<container><rootfiles><rootfile full-path="content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`,
+		"content.opf": `<package xmlns="http://www.idpf.org/2007/opf" version="3.0"><metadata/>` +
+			`<manifest><item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/></manifest>` +
+			`<spine><itemref idref="ch1"/></spine></package>`,
+		"ch1.xhtml": "<html/>",
+	} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, _ = w.Write([]byte(body))
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestWatermarkService_PrepareCacheAndTrace(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.FileWatermark{}))
+	repos := gormrepo.NewExtendedRepository(db)
+	fileStorage := storage.NewMemoryStorage()
+	svc := NewWatermarkService(repos, fileStorage, WatermarkOptions{CacheTTL: time.Hour, TempDir: t.TempDir()})
+
+	user := &models.User{Email: "Reader@Example.com", Name: "Reader", Role: models.RoleReader, IsActive: true}
+	require.NoError(t, db.Create(user).Error)
+	_, err = fileStorage.Put("blobs/ep/epub", bytes.NewReader(testEPUB(t)))
+	require.NoError(t, err)
+	file := &models.BookFile{ID: uuid.New(), FilePath: "blobs/ep/epub", FileType: models.FileTypeEPUB}
+
+	first, err := svc.Prepare(file, user)
+	require.NoError(t, err)
+	require.NotEmpty(t, first.Path, "the copy is cached in storage")
+	second, err := svc.Prepare(file, user)
+	require.NoError(t, err)
+	assert.Equal(t, first.Mark.ID, second.Mark.ID, "repeat downloads reuse the cached copy")
+
+	obj, err := fileStorage.Get(first.Path)
+	require.NoError(t, err)
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	trace, err := svc.Trace(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	require.NotNil(t, trace.Record)
+	require.NotNil(t, trace.User)
+	assert.Equal(t, user.ID, trace.User.ID)
+	assert.True(t, trace.EmailMatches)
+
+	_, err = svc.Trace(bytes.NewReader(testEPUB(t)), int64(len(testEPUB(t))))
+	assert.ErrorIs(t, err, ErrWatermarkNotFound)
+
+	_, err = svc.Prepare(&models.BookFile{FilePath: "blobs/ep/epub", FileType: models.FileTypeMOBI}, user)
+	assert.ErrorIs(t, err, ErrWatermarkUnsupported)
+
+	// Истёкший кэш удаляется из хранилища, запись о выдаче остаётся
+	require.NoError(t, db.Model(&models.FileWatermark{}).Where("id = ?", first.Mark.ID).
+		Update("cache_expires_at", time.Now().Add(-time.Minute)).Error)
+	n, err := svc.CleanupCache()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.False(t, fileStorage.Exists(first.Path))
+	_, err = repos.Watermark.GetByID(first.Mark.ID)
+	assert.NoError(t, err)
+
+	// Без кэша копия отдаётся из временного файла
+	uncached := NewWatermarkService(repos, fileStorage, WatermarkOptions{TempDir: t.TempDir()})
+	copied, err := uncached.Prepare(file, user)
+	require.NoError(t, err)
+	require.NotNil(t, copied.Temp)
+	assert.NotEqual(t, first.Mark.ID, copied.Mark.ID)
+	require.NoError(t, copied.Temp.Close())
+}
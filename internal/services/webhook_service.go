@@ -0,0 +1,649 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/events"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+	"github.com/oneErrortime/afst/internal/worker"
+)
+
+const (
+	// Заголовки исходящей доставки
+	WebhookHeaderEvent     = "X-AFST-Event"
+	WebhookHeaderDelivery  = "X-AFST-Delivery"
+	WebhookHeaderTimestamp = "X-AFST-Timestamp"
+	WebhookHeaderSignature = "X-AFST-Signature"
+
+	// webhookDisableAfter — после стольких проваленных доставок подряд вебхук отключается
+	webhookDisableAfter = 10
+	// webhookMaxResponseBody — сколько байт ответа получателя сохраняем в журнале
+	webhookMaxResponseBody = 2048
+	webhookTimeout         = 10 * time.Second
+
+	// JobKindWebhookDelivery — задача очереди: одна попытка доставки
+	JobKindWebhookDelivery = "webhook.deliver"
+)
+
+var (
+	ErrWebhookNotFound   = errors.New("webhook not found")
+	ErrWebhookForbidden  = errors.New("access denied")
+	ErrWebhookBadEvent   = errors.New("unknown event type")
+	ErrWebhookBadURL     = errors.New("webhook url must be http or https")
+	ErrWebhookPrivateURL = errors.New("webhook url must not point to a loopback, private or link-local address")
+	errWebhookInactive   = errors.New("webhook is disabled")
+)
+
+// WebhookOwner — от чьего имени выполняется операция.
+// APIKeyID задан, если запрос пришёл через внешнее API: тогда видны только вебхуки этого ключа.
+type WebhookOwner struct {
+	UserID   uuid.UUID
+	APIKeyID *uuid.UUID
+}
+
+// WebhookService — управление вебхуками и доставка событий шины внешним получателям.
+type WebhookService interface {
+	Create(owner WebhookOwner, dto *models.CreateWebhookDTO) (*models.WebhookSecretDTO, error)
+	List(owner WebhookOwner) ([]models.Webhook, error)
+	Get(owner WebhookOwner, id uuid.UUID) (*models.Webhook, error)
+	Update(owner WebhookOwner, id uuid.UUID, dto *models.UpdateWebhookDTO) (*models.Webhook, error)
+	Delete(owner WebhookOwner, id uuid.UUID) error
+	RotateSecret(owner WebhookOwner, id uuid.UUID) (*models.WebhookSecretDTO, error)
+
+	ListDeliveries(owner WebhookOwner, id uuid.UUID, limit int) ([]models.WebhookDelivery, error)
+	Redeliver(owner WebhookOwner, id, deliveryID uuid.UUID) (*models.WebhookDelivery, error)
+
+	// Dispatch создаёт доставки события для всех подходящих вебхуков.
+	Dispatch(event events.Event)
+	// Start ставит в очередь доставки, оставшиеся незавершёнными после
+	// рестарта, подписывается на все события шины и вызывает Dispatch до отмены ctx.
+	Start(ctx context.Context, bus *events.Bus)
+}
+
+type webhookService struct {
+	repo     repository.WebhookRepository
+	userRepo repository.UserRepository
+	flags    FeatureFlagService
+	queue    *worker.Queue
+	client   *http.Client
+	// dedup отбрасывает повторы событий, которые outbox-релей доставил дважды
+	dedup *events.Deduplicator
+	// lookup разрешает имя хоста при проверке URL
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+	// allowPrivate снимает запрет на адреса внутренней сети — только для тестов
+	allowPrivate bool
+}
+
+// webhookDeliveryPayload — задача очереди ссылается на доставку, а сама
+// доставка и её журнал хранятся в webhook_deliveries
+type webhookDeliveryPayload struct {
+	DeliveryID uuid.UUID `json:"delivery_id"`
+}
+
+// NewWebhookService создаёт сервис вебхуков и регистрирует обработчик доставок
+// в queue. Повторы планирует очередь (run_at задачи), поэтому они переживают
+// рестарт и не занимают воркер на время ожидания. Если queue == nil,
+// доставка выполняется одной попыткой в отдельной горутине.
+func NewWebhookService(
+	repo repository.WebhookRepository,
+	userRepo repository.UserRepository,
+	flags FeatureFlagService,
+	queue *worker.Queue,
+) WebhookService {
+	s := &webhookService{
+		repo:     repo,
+		userRepo: userRepo,
+		flags:    flags,
+		queue:    queue,
+		dedup:    events.NewDeduplicator(4096),
+		lookup:   net.DefaultResolver.LookupIPAddr,
+	}
+	// Адрес проверяется и при подключении: DNS-запись могла смениться
+	// после регистрации, а получатель — ответить редиректом во внутреннюю сеть
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return s.checkIP(net.ParseIP(host))
+		},
+	}
+	s.client = &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: webhookTimeout},
+	}
+	if queue != nil {
+		queue.Register(JobKindWebhookDelivery, worker.JobHandler{
+			Execute: s.execute,
+			OnDone:  s.done,
+			Backoff: webhookBackoff,
+		})
+	}
+	return s
+}
+
+// SignWebhookPayload вычисляет подпись доставки: HMAC-SHA256(secret, "<timestamp>.<body>").
+// Получатель должен пересчитать её и сравнить с заголовком X-AFST-Signature.
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff — экспоненциальная задержка: 2s, 4s, 8s, ... но не больше 5 минут.
+func webhookBackoff(attempt int) time.Duration {
+	d := 2 * time.Second << uint(attempt-1)
+	if d > 5*time.Minute || d <= 0 {
+		d = 5 * time.Minute
+	}
+	return d
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// validateURL проверяет схему и то, что хост не ведёт во внутреннюю сеть
+// (защита от SSRF): все адреса имени должны быть публичными
+func (s *webhookService) validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrWebhookBadURL
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		return s.checkIP(ip)
+	}
+	if !s.allowPrivate && (host == "localhost" || strings.HasSuffix(host, ".localhost")) {
+		return ErrWebhookPrivateURL
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	addrs, err := s.lookup(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("%w: cannot resolve %s", ErrWebhookBadURL, host)
+	}
+	for _, addr := range addrs {
+		if err := s.checkIP(addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkIP отклоняет loopback, частные, link-local и неуказанные адреса
+func (s *webhookService) checkIP(ip net.IP) error {
+	if ip == nil {
+		return ErrWebhookBadURL
+	}
+	if s.allowPrivate {
+		return nil
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return ErrWebhookPrivateURL
+	}
+	return nil
+}
+
+func validateWebhookEvents(list []string) error {
+	for _, e := range list {
+		if e != models.WebhookEventWildcard && !events.IsKnownEventType(e) {
+			return fmt.Errorf("%w: %s", ErrWebhookBadEvent, e)
+		}
+	}
+	return nil
+}
+
+func (s *webhookService) Create(owner WebhookOwner, dto *models.CreateWebhookDTO) (*models.WebhookSecretDTO, error) {
+	if err := s.validateURL(dto.URL); err != nil {
+		return nil, err
+	}
+	if err := validateWebhookEvents(dto.Events); err != nil {
+		return nil, err
+	}
+	if dto.AllUsers {
+		if err := s.checkAllUsers(owner); err != nil {
+			return nil, err
+		}
+	}
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	hook := &models.Webhook{
+		UserID:      owner.UserID,
+		APIKeyID:    owner.APIKeyID,
+		URL:         dto.URL,
+		Events:      dto.Events,
+		Description: dto.Description,
+		AllUsers:    dto.AllUsers,
+		Secret:      secret,
+		IsActive:    true,
+	}
+	if err := s.repo.Create(hook); err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	return &models.WebhookSecretDTO{Webhook: *hook, Secret: secret}, nil
+}
+
+// checkAllUsers — события всех пользователей может получать только администратор
+func (s *webhookService) checkAllUsers(owner WebhookOwner) error {
+	user, err := s.userRepo.GetByID(owner.UserID)
+	if err != nil || !user.IsAdmin() {
+		return ErrWebhookForbidden
+	}
+	return nil
+}
+
+func (s *webhookService) List(owner WebhookOwner) ([]models.Webhook, error) {
+	if owner.APIKeyID != nil {
+		return s.repo.GetByAPIKeyID(*owner.APIKeyID)
+	}
+	return s.repo.GetByUserID(owner.UserID)
+}
+
+func (s *webhookService) Get(owner WebhookOwner, id uuid.UUID) (*models.Webhook, error) {
+	hook, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, ErrWebhookNotFound
+	}
+	if hook.UserID != owner.UserID {
+		return nil, ErrWebhookForbidden
+	}
+	if owner.APIKeyID != nil && (hook.APIKeyID == nil || *hook.APIKeyID != *owner.APIKeyID) {
+		return nil, ErrWebhookForbidden
+	}
+	return hook, nil
+}
+
+func (s *webhookService) Update(owner WebhookOwner, id uuid.UUID, dto *models.UpdateWebhookDTO) (*models.Webhook, error) {
+	hook, err := s.Get(owner, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if dto.URL != nil {
+		if err := s.validateURL(*dto.URL); err != nil {
+			return nil, err
+		}
+		hook.URL = *dto.URL
+	}
+	if dto.Events != nil {
+		if err := validateWebhookEvents(dto.Events); err != nil {
+			return nil, err
+		}
+		hook.Events = dto.Events
+	}
+	if dto.Description != nil {
+		hook.Description = *dto.Description
+	}
+	if dto.AllUsers != nil {
+		if *dto.AllUsers {
+			if err := s.checkAllUsers(owner); err != nil {
+				return nil, err
+			}
+		}
+		hook.AllUsers = *dto.AllUsers
+	}
+	if dto.IsActive != nil {
+		hook.IsActive = *dto.IsActive
+		if hook.IsActive {
+			// Ручное включение — начинаем отсчёт ошибок заново
+			hook.ConsecutiveFailures = 0
+			hook.DisabledAt = nil
+		}
+	}
+
+	if err := s.repo.Update(hook); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+func (s *webhookService) Delete(owner WebhookOwner, id uuid.UUID) error {
+	if _, err := s.Get(owner, id); err != nil {
+		return err
+	}
+	return s.repo.Delete(id)
+}
+
+func (s *webhookService) RotateSecret(owner WebhookOwner, id uuid.UUID) (*models.WebhookSecretDTO, error) {
+	hook, err := s.Get(owner, id)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	hook.Secret = secret
+	if err := s.repo.Update(hook); err != nil {
+		return nil, err
+	}
+	return &models.WebhookSecretDTO{Webhook: *hook, Secret: secret}, nil
+}
+
+func (s *webhookService) ListDeliveries(owner WebhookOwner, id uuid.UUID, limit int) ([]models.WebhookDelivery, error) {
+	if _, err := s.Get(owner, id); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	return s.repo.GetDeliveries(id, limit)
+}
+
+func (s *webhookService) Redeliver(owner WebhookOwner, id, deliveryID uuid.UUID) (*models.WebhookDelivery, error) {
+	if _, err := s.Get(owner, id); err != nil {
+		return nil, err
+	}
+
+	original, err := s.repo.GetDeliveryByID(deliveryID)
+	if err != nil || original.WebhookID != id {
+		return nil, ErrWebhookNotFound
+	}
+
+	// Тот же event_id: получатель может дедуплицировать повтор
+	delivery := &models.WebhookDelivery{
+		WebhookID:    id,
+		EventID:      original.EventID,
+		EventType:    original.EventType,
+		Payload:      original.Payload,
+		Status:       models.DeliveryStatusPending,
+		RedeliveryOf: &original.ID,
+	}
+	if err := s.repo.CreateDelivery(delivery); err != nil {
+		return nil, err
+	}
+
+	s.enqueue(delivery)
+	return delivery, nil
+}
+
+// webhookEnvelope — тело POST-запроса, которое получает подписчик
+type webhookEnvelope struct {
+	ID        uuid.UUID   `json:"id"`
+	Type      string      `json:"type"`
+	UserID    string      `json:"user_id,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+	Payload   interface{} `json:"payload"`
+}
+
+func (s *webhookService) Dispatch(event events.Event) {
+	if s.flags != nil && !s.flags.IsActive("enable_webhooks") {
+		return
+	}
+	// Событие с другого инстанса доставит инстанс-источник
+	if event.Remote || s.dedup.Seen(event.ID) {
+		return
+	}
+
+	hooks, err := s.repo.GetActive()
+	if err != nil {
+		log.Printf("[webhooks] failed to load webhooks: %v", err)
+		return
+	}
+
+	// ID из шины стабилен между повторными публикациями — получатель может по нему убирать дубли
+	eventID, err := uuid.Parse(event.ID)
+	if err != nil {
+		eventID = uuid.New()
+	}
+	body, err := json.Marshal(webhookEnvelope{
+		ID:        eventID,
+		Type:      string(event.Type),
+		UserID:    event.UserID,
+		Timestamp: event.Timestamp,
+		Payload:   event.Payload,
+	})
+	if err != nil {
+		log.Printf("[webhooks] failed to encode event %s: %v", event.Type, err)
+		return
+	}
+
+	admins := make(map[uuid.UUID]bool)
+	for i := range hooks {
+		hook := &hooks[i]
+		if !hook.Matches(string(event.Type)) || !s.canSee(hook, event, admins) {
+			continue
+		}
+
+		delivery := &models.WebhookDelivery{
+			WebhookID: hook.ID,
+			EventID:   eventID,
+			EventType: string(event.Type),
+			Payload:   string(body),
+			Status:    models.DeliveryStatusPending,
+		}
+		if err := s.repo.CreateDelivery(delivery); err != nil {
+			log.Printf("[webhooks] failed to record delivery for %s: %v", hook.ID, err)
+			continue
+		}
+		s.enqueue(delivery)
+	}
+}
+
+// canSee — события конкретного пользователя уходят только его вебхукам,
+// а также вебхукам с AllUsers, пока их владелец остаётся администратором.
+// Глобальные события видят все.
+func (s *webhookService) canSee(hook *models.Webhook, event events.Event, admins map[uuid.UUID]bool) bool {
+	if event.UserID == "" || event.UserID == hook.UserID.String() {
+		return true
+	}
+	if !hook.AllUsers {
+		return false
+	}
+	allowed, ok := admins[hook.UserID]
+	if !ok {
+		user, err := s.userRepo.GetByID(hook.UserID)
+		allowed = err == nil && user.IsAdmin()
+		admins[hook.UserID] = allowed
+	}
+	return allowed
+}
+
+func (s *webhookService) Start(ctx context.Context, bus *events.Bus) {
+	s.resume()
+	sub := bus.SubscribeDurable(ctx, events.AllEventTypes...)
+	go func() {
+		for event := range sub {
+			s.Dispatch(event)
+		}
+	}()
+}
+
+// enqueue ставит доставку в очередь. ID задачи совпадает с ID доставки,
+// поэтому повторная постановка (resume) не создаёт второй задачи.
+func (s *webhookService) enqueue(delivery *models.WebhookDelivery) {
+	if s.queue == nil {
+		go func() {
+			s.finish(delivery, s.attempt(context.Background(), delivery))
+		}()
+		return
+	}
+	if _, err := s.queue.EnqueueOnce(delivery.ID, JobKindWebhookDelivery, webhookDeliveryPayload{DeliveryID: delivery.ID}); err != nil {
+		// Доставка останется pending и будет поставлена в очередь при следующем старте
+		log.Printf("[webhooks] failed to queue delivery %s: %v", delivery.ID, err)
+	}
+}
+
+// resume ставит в очередь доставки, для которых нет задачи: например, созданные
+// перед падением процесса до постановки в очередь
+func (s *webhookService) resume() {
+	if s.queue == nil {
+		return
+	}
+	pending, err := s.repo.GetPendingDeliveries()
+	if err != nil {
+		log.Printf("[webhooks] failed to load pending deliveries: %v", err)
+		return
+	}
+	requeued := 0
+	for i := range pending {
+		created, err := s.queue.EnqueueOnce(pending[i].ID, JobKindWebhookDelivery, webhookDeliveryPayload{DeliveryID: pending[i].ID})
+		if err != nil {
+			log.Printf("[webhooks] failed to queue delivery %s: %v", pending[i].ID, err)
+			continue
+		}
+		if created {
+			requeued++
+		}
+	}
+	if requeued > 0 {
+		log.Printf("[webhooks] re-queued %d pending deliveries", requeued)
+	}
+}
+
+// delivery читает доставку, на которую ссылается задача очереди
+func (s *webhookService) delivery(job *models.Job) (*models.WebhookDelivery, error) {
+	var payload webhookDeliveryPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return nil, fmt.Errorf("invalid webhook job payload: %w", err)
+	}
+	return s.repo.GetDeliveryByID(payload.DeliveryID)
+}
+
+// execute — одна попытка доставки в воркере очереди
+func (s *webhookService) execute(ctx context.Context, job *models.Job) error {
+	delivery, err := s.delivery(job)
+	if err != nil {
+		return worker.Permanent(err)
+	}
+	if delivery.Status != models.DeliveryStatusPending {
+		// Итог уже записан: задачу повторили после того, как доставка завершилась
+		return nil
+	}
+	return s.attempt(ctx, delivery)
+}
+
+// done вызывается очередью после успеха или последней неудачной попытки
+func (s *webhookService) done(job *models.Job, err error) {
+	delivery, derr := s.delivery(job)
+	if derr != nil {
+		log.Printf("[webhooks] job %s: %v", job.ID, derr)
+		return
+	}
+	if delivery.Status != models.DeliveryStatusPending {
+		return
+	}
+	s.finish(delivery, err)
+}
+
+// attempt выполняет одну HTTP-попытку и записывает её результат в журнал
+func (s *webhookService) attempt(ctx context.Context, delivery *models.WebhookDelivery) error {
+	// Перечитываем вебхук: URL и секрет могли смениться, пока доставка ждала в очереди
+	hook, err := s.repo.GetByID(delivery.WebhookID)
+	if err != nil {
+		return worker.Permanent(ErrWebhookNotFound)
+	}
+	if !hook.IsActive {
+		return worker.Permanent(errWebhookInactive)
+	}
+
+	body := []byte(delivery.Payload)
+	ts := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return worker.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "AFST-Webhooks/1.0")
+	req.Header.Set(WebhookHeaderEvent, delivery.EventType)
+	req.Header.Set(WebhookHeaderDelivery, delivery.ID.String())
+	req.Header.Set(WebhookHeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(WebhookHeaderSignature, SignWebhookPayload(hook.Secret, ts, body))
+
+	started := time.Now()
+	resp, err := s.client.Do(req)
+	delivery.Attempts++
+	delivery.DurationMs = time.Since(started).Milliseconds()
+
+	if err != nil {
+		msg := err.Error()
+		delivery.Error = &msg
+		delivery.ResponseStatus = nil
+		delivery.ResponseBody = nil
+	} else {
+		defer func() { _ = resp.Body.Close() }()
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, webhookMaxResponseBody))
+		status := resp.StatusCode
+		respBody := string(snippet)
+		delivery.ResponseStatus = &status
+		delivery.ResponseBody = &respBody
+		delivery.Error = nil
+		if status < 200 || status >= 300 {
+			err = fmt.Errorf("receiver responded with status %d", status)
+			msg := err.Error()
+			delivery.Error = &msg
+		}
+	}
+
+	if updErr := s.repo.UpdateDelivery(delivery); updErr != nil {
+		log.Printf("[webhooks] failed to update delivery %s: %v", delivery.ID, updErr)
+	}
+	return err
+}
+
+// finish фиксирует итог доставки после всех повторов
+func (s *webhookService) finish(delivery *models.WebhookDelivery, err error) {
+	now := time.Now()
+	delivery.DeliveredAt = &now
+	if err == nil {
+		delivery.Status = models.DeliveryStatusSucceeded
+	} else {
+		delivery.Status = models.DeliveryStatusFailed
+		msg := err.Error()
+		delivery.Error = &msg
+	}
+	if updErr := s.repo.UpdateDelivery(delivery); updErr != nil {
+		log.Printf("[webhooks] failed to finalize delivery %s: %v", delivery.ID, updErr)
+	}
+
+	switch {
+	case err == nil:
+		_ = s.repo.RecordSuccess(delivery.WebhookID)
+	case errors.Is(err, errWebhookInactive), errors.Is(err, ErrWebhookNotFound):
+		// не считаем ошибкой получателя
+	default:
+		disabled, recErr := s.repo.RecordFailure(delivery.WebhookID, webhookDisableAfter)
+		if recErr != nil {
+			log.Printf("[webhooks] failed to record failure for %s: %v", delivery.WebhookID, recErr)
+		}
+		if disabled {
+			log.Printf("[webhooks] webhook %s disabled after %d consecutive failures", delivery.WebhookID, webhookDisableAfter)
+		}
+	}
+}
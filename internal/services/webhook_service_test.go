@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/events"
+	"github.com/oneErrortime/afst/internal/models"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/oneErrortime/afst/internal/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newWebhookTestService(t *testing.T) (WebhookService, *models.User, *gormdb.DB) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Webhook{}, &models.WebhookDelivery{}))
+
+	user := &models.User{Email: "hook@example.com", Name: "Hook", Role: models.RoleReader, IsActive: true}
+	require.NoError(t, db.Create(user).Error)
+
+	svc := NewWebhookService(gormrepo.NewWebhookRepository(db), gormrepo.NewUserRepository(db), nil, nil).(*webhookService)
+	// Получатели в тестах — httptest на 127.0.0.1, а DNS в песочнице может не работать
+	svc.allowPrivate = true
+	svc.lookup = func(context.Context, string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("203.0.113.7")}}, nil
+	}
+	return svc, user, db
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	sig := SignWebhookPayload("whsec_test", 1700000000, []byte(`{"a":1}`))
+	assert.Equal(t, sig, SignWebhookPayload("whsec_test", 1700000000, []byte(`{"a":1}`)))
+	assert.NotEqual(t, sig, SignWebhookPayload("whsec_test", 1700000001, []byte(`{"a":1}`)))
+	assert.NotEqual(t, sig, SignWebhookPayload("whsec_other", 1700000000, []byte(`{"a":1}`)))
+	assert.Len(t, sig, len("sha256=")+64)
+}
+
+func TestWebhookService_CreateValidates(t *testing.T) {
+	svc, user, _ := newWebhookTestService(t)
+	owner := WebhookOwner{UserID: user.ID}
+
+	_, err := svc.Create(owner, &models.CreateWebhookDTO{URL: "https://example.com/hook", Events: []string{"book.nope"}})
+	assert.ErrorIs(t, err, ErrWebhookBadEvent)
+
+	_, err = svc.Create(owner, &models.CreateWebhookDTO{URL: "ftp://example.com/hook", Events: []string{"*"}})
+	assert.ErrorIs(t, err, ErrWebhookBadURL)
+
+	created, err := svc.Create(owner, &models.CreateWebhookDTO{URL: "https://example.com/hook", Events: []string{"*"}})
+	require.NoError(t, err)
+	assert.Contains(t, created.Secret, "whsec_")
+}
+
+func TestWebhookService_RejectsPrivateTargets(t *testing.T) {
+	svc, user, db := newWebhookTestService(t)
+	guarded := svc.(*webhookService)
+	guarded.allowPrivate = false
+	guarded.lookup = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		if host == "internal.example.com" {
+			return []net.IPAddr{{IP: net.ParseIP("203.0.113.7")}, {IP: net.ParseIP("10.1.2.3")}}, nil
+		}
+		return []net.IPAddr{{IP: net.ParseIP("203.0.113.7")}}, nil
+	}
+	owner := WebhookOwner{UserID: user.ID}
+
+	for _, target := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://[::1]/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://0.0.0.0/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://localhost:3000/hook",
+		"https://internal.example.com/hook",
+	} {
+		_, err := svc.Create(owner, &models.CreateWebhookDTO{URL: target, Events: []string{"*"}})
+		assert.ErrorIs(t, err, ErrWebhookPrivateURL, target)
+	}
+
+	created, err := svc.Create(owner, &models.CreateWebhookDTO{URL: "https://hooks.example.com/in", Events: []string{"*"}})
+	require.NoError(t, err)
+	private := "http://10.0.0.5/hook"
+	_, err = svc.Update(owner, created.ID, &models.UpdateWebhookDTO{URL: &private})
+	assert.ErrorIs(t, err, ErrWebhookPrivateURL)
+
+	// Адрес сменился после регистрации — доставку останавливает проверка при подключении
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a private receiver must not be reached")
+	}))
+	defer srv.Close()
+	require.NoError(t, db.Model(&models.Webhook{}).Where("id = ?", created.ID).Update("url", srv.URL).Error)
+	svc.Dispatch(events.Event{Type: events.EventAccessGranted, UserID: user.ID.String(), Timestamp: time.Now()})
+	require.Eventually(t, func() bool {
+		var d models.WebhookDelivery
+		if err := db.First(&d, "webhook_id = ?", created.ID).Error; err != nil {
+			return false
+		}
+		return d.Status == models.DeliveryStatusFailed && d.Error != nil &&
+			strings.Contains(*d.Error, ErrWebhookPrivateURL.Error())
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestWebhookService_DispatchSignsDelivery(t *testing.T) {
+	svc, user, db := newWebhookTestService(t)
+
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan received, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- received{header: r.Header.Clone(), body: body}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	hook, err := svc.Create(WebhookOwner{UserID: user.ID}, &models.CreateWebhookDTO{
+		URL:    srv.URL,
+		Events: []string{string(events.EventAccessGranted)},
+	})
+	require.NoError(t, err)
+
+	// Не подписан — доставки нет
+	svc.Dispatch(events.Event{Type: events.EventBookDeleted, Timestamp: time.Now()})
+	svc.Dispatch(events.Event{Type: events.EventAccessGranted, UserID: user.ID.String(), Timestamp: time.Now()})
+
+	var r received
+	select {
+	case r = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery was not sent")
+	}
+
+	assert.Equal(t, string(events.EventAccessGranted), r.header.Get(WebhookHeaderEvent))
+	ts, err := strconv.ParseInt(r.header.Get(WebhookHeaderTimestamp), 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, SignWebhookPayload(hook.Secret, ts, r.body), r.header.Get(WebhookHeaderSignature))
+
+	require.Eventually(t, func() bool {
+		var d models.WebhookDelivery
+		if err := db.First(&d, "webhook_id = ?", hook.ID).Error; err != nil {
+			return false
+		}
+		return d.Status == models.DeliveryStatusSucceeded
+	}, 5*time.Second, 20*time.Millisecond)
+
+	var count int64
+	db.Model(&models.WebhookDelivery{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestWebhookService_DispatchHidesForeignEvents(t *testing.T) {
+	svc, user, db := newWebhookTestService(t)
+	_, err := svc.Create(WebhookOwner{UserID: user.ID}, &models.CreateWebhookDTO{
+		URL:    "http://127.0.0.1:1/hook",
+		Events: []string{"*"},
+	})
+	require.NoError(t, err)
+
+	other := &models.User{Email: "other@example.com", Name: "Other", Role: models.RoleReader, IsActive: true}
+	require.NoError(t, db.Create(other).Error)
+
+	svc.Dispatch(events.Event{Type: events.EventAccessGranted, UserID: other.ID.String(), Timestamp: time.Now()})
+
+	var count int64
+	db.Model(&models.WebhookDelivery{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestWebhookService_RetriesThroughQueue(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.Job{}))
+	user := &models.User{Email: "hook@example.com", Name: "Hook", Role: models.RoleReader, IsActive: true}
+	require.NoError(t, db.Create(user).Error)
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	repo := gormrepo.NewWebhookRepository(db)
+	hook := &models.Webhook{UserID: user.ID, URL: srv.URL, Secret: "whsec_test", Events: []string{"*"}, IsActive: true}
+	require.NoError(t, repo.Create(hook))
+	// Доставка, оставшаяся pending после рестарта: задачи для неё нет
+	stale := &models.WebhookDelivery{WebhookID: hook.ID, EventID: uuid.New(), EventType: string(events.EventAccessGranted),
+		Payload: "{}", Status: models.DeliveryStatusPending}
+	require.NoError(t, repo.CreateDelivery(stale))
+
+	queue := worker.NewQueue("webhooks", gormrepo.NewJobRepository(db), worker.QueueConfig{
+		MaxAttempts: 3, PollInterval: 20 * time.Millisecond,
+	})
+	svc := NewWebhookService(repo, gormrepo.NewUserRepository(db), nil, queue).(*webhookService)
+	svc.allowPrivate = true
+	queue.Start()
+	defer queue.Shutdown(time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc.Start(ctx, events.NewBus(16))
+
+	require.Eventually(t, func() bool {
+		d, err := repo.GetDeliveryByID(stale.ID)
+		return err == nil && d.Status == models.DeliveryStatusSucceeded
+	}, 10*time.Second, 20*time.Millisecond)
+	d, err := repo.GetDeliveryByID(stale.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, d.Attempts, "the 502 was retried from the queue")
+
+	var job models.Job
+	require.NoError(t, db.First(&job, "id = ?", stale.ID).Error)
+	assert.Equal(t, models.JobStatusSucceeded, job.Status)
+
+	// Повторный старт не ставит завершённую доставку в очередь второй раз
+	svc.resume()
+	var jobs int64
+	db.Model(&models.Job{}).Count(&jobs)
+	assert.Equal(t, int64(1), jobs)
+}
+
+func TestWebhookService_AllUsersScope(t *testing.T) {
+	svc, user, db := newWebhookTestService(t)
+	librarian := &models.User{Email: "lib@example.com", Name: "Lib", Role: models.RoleLibrarian, IsActive: true}
+	admin := &models.User{Email: "admin@example.com", Name: "Admin", Role: models.RoleAdmin, IsActive: true}
+	require.NoError(t, db.Create(librarian).Error)
+	require.NoError(t, db.Create(admin).Error)
+
+	dto := func(allUsers bool) *models.CreateWebhookDTO {
+		return &models.CreateWebhookDTO{URL: "http://127.0.0.1:1/hook", Events: []string{"*"}, AllUsers: allUsers}
+	}
+	_, err := svc.Create(WebhookOwner{UserID: librarian.ID}, dto(true))
+	assert.ErrorIs(t, err, ErrWebhookForbidden, "only admins may subscribe to everyone's events")
+	libHook, err := svc.Create(WebhookOwner{UserID: librarian.ID}, dto(false))
+	require.NoError(t, err)
+	allUsers := true
+	_, err = svc.Update(WebhookOwner{UserID: librarian.ID}, libHook.ID, &models.UpdateWebhookDTO{AllUsers: &allUsers})
+	assert.ErrorIs(t, err, ErrWebhookForbidden)
+	adminOwn, err := svc.Create(WebhookOwner{UserID: admin.ID}, dto(false))
+	require.NoError(t, err)
+	adminAll, err := svc.Create(WebhookOwner{UserID: admin.ID}, dto(true))
+	require.NoError(t, err)
+
+	svc.Dispatch(events.Event{ID: uuid.NewString(), Type: events.EventAccessGranted, UserID: user.ID.String(), Timestamp: time.Now()})
+
+	var hookIDs []uuid.UUID
+	require.NoError(t, db.Model(&models.WebhookDelivery{}).Pluck("webhook_id", &hookIDs).Error)
+	assert.Equal(t, []uuid.UUID{adminAll.ID}, hookIDs, "librarian %s and admin %s hooks get only their own events", libHook.ID, adminOwn.ID)
+}
@@ -0,0 +1,35 @@
+package storage
+
+import "fmt"
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// Config selects and configures the file storage backend
+type Config struct {
+	// Backend is BackendLocal (default) or BackendS3
+	Backend   string
+	LocalPath string
+	BaseURL   string
+	S3        S3Config
+}
+
+// New opens the backend named in cfg.Backend
+func New(cfg Config) (FileStorage, error) {
+	return Open(cfg.Backend, cfg)
+}
+
+// Open opens backend with the settings in cfg, regardless of cfg.Backend;
+// migrate-storage uses it to open the source and the target side by side
+func Open(backend string, cfg Config) (FileStorage, error) {
+	switch backend {
+	case "", BackendLocal:
+		return NewLocalStorage(cfg.LocalPath, cfg.BaseURL), nil
+	case BackendS3:
+		return NewS3Storage(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
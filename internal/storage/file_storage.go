@@ -3,24 +3,77 @@ package storage
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
-
-	"github.com/google/uuid"
 )
 
+// FileStorage keeps book files and covers under slash-separated paths
+// relative to the storage root. Files are streamed in and out, so a backend
+// does not have to be a local disk.
 type FileStorage interface {
-	Upload(file multipart.File, header *multipart.FileHeader) (*UploadResult, error)
-	Get(filePath string) (*os.File, error)
+	// Upload streams a book file into storage under BlobPath of its SHA-256.
+	// Content that is already stored ends up at the same path, so identical
+	// files are kept once. Content whose leading bytes are not those of the
+	// format named by the extension is rejected with ErrContentMismatch.
+	Upload(r io.Reader, originalName string) (*UploadResult, error)
+	// Put writes r to filePath (relative to the storage root), replacing any existing file
+	Put(filePath string, r io.Reader) (int64, error)
+	Get(filePath string) (Object, error)
 	Delete(filePath string) error
 	Exists(filePath string) bool
 	GetURL(filePath string) string
 }
 
+// Object is a stored file opened for reading. Stat reports the size and
+// modification time, enough for http.ServeContent and zip.NewReader.
+// *os.File satisfies it.
+type Object interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+	Stat() (fs.FileInfo, error)
+}
+
+// Walker lists every stored file; used to copy files between backends
+type Walker interface {
+	Walk(fn func(filePath string) error) error
+}
+
+// PresignOptions override response headers of a presigned download
+type PresignOptions struct {
+	ContentType        string
+	ContentDisposition string
+}
+
+// Presigner is implemented by backends that can hand out time-limited
+// direct download URLs. ErrPresignDisabled means the caller should stream
+// the file itself.
+type Presigner interface {
+	PresignGet(filePath string, opts PresignOptions) (string, error)
+}
+
+var ErrPresignDisabled = errors.New("presigned URLs are disabled")
+
+// BlobDir is the top-level directory of content-addressed book files
+const BlobDir = "blobs"
+
+// BlobPath is where the book file with the given hex SHA-256 is stored.
+// The path carries no extension: one blob may back files uploaded under
+// different names.
+func BlobPath(hash string) string {
+	return path.Join(BlobDir, hash[:2], hash)
+}
+
+// IsBlobPath reports whether filePath lies in the content-addressed area
+func IsBlobPath(filePath string) bool {
+	return strings.HasPrefix(filePath, BlobDir+"/")
+}
+
 type UploadResult struct {
 	FileName     string
 	OriginalName string
@@ -30,6 +83,14 @@ type UploadResult struct {
 	Hash         string
 }
 
+// allowedExtensions are the book formats accepted for upload
+var allowedExtensions = map[string]bool{".pdf": true, ".epub": true, ".mobi": true}
+
+// IsAllowedFileName reports whether name has an accepted book extension
+func IsAllowedFileName(name string) bool {
+	return allowedExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
 type LocalStorage struct {
 	BasePath string
 	BaseURL  string
@@ -45,68 +106,105 @@ func NewLocalStorage(basePath, baseURL string) *LocalStorage {
 	}
 }
 
-func (s *LocalStorage) Upload(file multipart.File, header *multipart.FileHeader) (*UploadResult, error) {
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	allowedExts := map[string]bool{".pdf": true, ".epub": true, ".mobi": true}
-	if !allowedExts[ext] {
+func (s *LocalStorage) Upload(r io.Reader, originalName string) (*UploadResult, error) {
+	ext := strings.ToLower(filepath.Ext(originalName))
+	if !allowedExtensions[ext] {
 		return nil, fmt.Errorf("unsupported file type: %s", ext)
 	}
-
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return nil, fmt.Errorf("failed to calculate hash: %w", err)
-	}
-	hashStr := hex.EncodeToString(hash.Sum(nil))
-
-	if _, err := file.Seek(0, 0); err != nil {
-		return nil, fmt.Errorf("failed to seek file: %w", err)
-	}
-
-	fileName := fmt.Sprintf("%s_%s%s", uuid.New().String(), hashStr[:8], ext)
-	subDir := hashStr[:2]
-	dirPath := filepath.Join(s.BasePath, subDir)
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create directory: %w", err)
+	r, err := sniffUpload(r, ext)
+	if err != nil {
+		return nil, err
 	}
 
-	filePath := filepath.Join(subDir, fileName)
-	fullPath := filepath.Join(s.BasePath, filePath)
-
-	dst, err := os.Create(fullPath)
+	// The path depends on the hash, so the file is written to a temp name
+	// first and moved into place once the whole stream has been read.
+	// Renaming over an existing blob keeps one copy and refreshes its
+	// modification time, which holds off the orphan sweep.
+	tmp, err := os.CreateTemp(s.BasePath, ".upload-*")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create file: %w", err)
 	}
-	defer func() { _ = dst.Close() }()
-
-	size, err := io.Copy(dst, file)
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		_ = os.Remove(fullPath)
+		_ = os.Remove(tmp.Name())
 		return nil, fmt.Errorf("failed to save file: %w", err)
 	}
+	hashStr := hex.EncodeToString(hash.Sum(nil))
 
-	mimeType := "application/octet-stream"
-	switch ext {
-	case ".pdf":
-		mimeType = "application/pdf"
-	case ".epub":
-		mimeType = "application/epub+zip"
-	case ".mobi":
-		mimeType = "application/x-mobipocket-ebook"
+	filePath := BlobPath(hashStr)
+	fullPath := s.LocalPath(filePath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		_ = os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), fullPath); err != nil {
+		_ = os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to save file: %w", err)
 	}
 
 	return &UploadResult{
-		FileName:     fileName,
-		OriginalName: header.Filename,
+		FileName:     hashStr + ext,
+		OriginalName: originalName,
 		FilePath:     filePath,
 		FileSize:     size,
-		MimeType:     mimeType,
+		MimeType:     mimeTypeFor(ext),
 		Hash:         hashStr,
 	}, nil
 }
 
-func (s *LocalStorage) Get(filePath string) (*os.File, error) {
-	fullPath := filepath.Join(s.BasePath, filePath)
-	return os.Open(fullPath)
+// mimeTypeFor maps an accepted book extension to its MIME type
+func mimeTypeFor(ext string) string {
+	switch ext {
+	case ".pdf":
+		return "application/pdf"
+	case ".epub":
+		return "application/epub+zip"
+	case ".mobi":
+		return "application/x-mobipocket-ebook"
+	}
+	return "application/octet-stream"
+}
+
+func (s *LocalStorage) Put(filePath string, r io.Reader) (int64, error) {
+	fullPath := filepath.Join(s.BasePath, filepath.Clean("/"+filePath))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// Write to a temp file and rename so readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), ".put-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
+	size, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fullPath)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return 0, fmt.Errorf("failed to save file: %w", err)
+	}
+	return size, nil
+}
+
+func (s *LocalStorage) Get(filePath string) (Object, error) {
+	f, err := os.Open(s.LocalPath(filePath))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// LocalPath is the file on disk behind filePath
+func (s *LocalStorage) LocalPath(filePath string) string {
+	return filepath.Join(s.BasePath, filepath.Clean("/"+filePath))
 }
 
 func (s *LocalStorage) Delete(filePath string) error {
@@ -123,3 +221,27 @@ func (s *LocalStorage) Exists(filePath string) bool {
 func (s *LocalStorage) GetURL(filePath string) string {
 	return fmt.Sprintf("%s/files/%s", s.BaseURL, filePath)
 }
+
+// Walk calls fn for every stored file. Dot-files and dot-directories hold
+// temp and partial uploads and are skipped.
+func (s *LocalStorage) Walk(fn func(filePath string) error) error {
+	return filepath.WalkDir(s.BasePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != s.BasePath {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.BasePath, path)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel))
+	})
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// LocalFile makes filePath available as a file on local disk for code that
+// needs a real path, such as the format parsers. Disk-backed storage returns
+// the stored file itself; other backends download it into a temp file that
+// release removes. release is never nil.
+func LocalFile(s FileStorage, filePath string) (localPath string, release func(), err error) {
+	noop := func() {}
+	if local, ok := s.(interface{ LocalPath(string) string }); ok {
+		localPath = local.LocalPath(filePath)
+		if _, err := os.Stat(localPath); err != nil {
+			return "", noop, err
+		}
+		return localPath, noop, nil
+	}
+
+	obj, err := s.Get(filePath)
+	if err != nil {
+		return "", noop, err
+	}
+	defer func() { _ = obj.Close() }()
+
+	// Keep the extension: some parsers look at it
+	tmp, err := os.CreateTemp("", "afst-*"+filepath.Ext(filePath))
+	if err != nil {
+		return "", noop, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	_, err = io.Copy(tmp, obj)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", noop, fmt.Errorf("failed to download %s: %w", filePath, err)
+	}
+	return tmp.Name(), func() { _ = os.Remove(tmp.Name()) }, nil
+}
@@ -2,51 +2,93 @@ package storage
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
-	"mime/multipart"
-	"os"
+	"io/fs"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
-
-	"github.com/google/uuid"
+	"time"
 )
 
 // MemoryStorage implements FileStorage in-memory for testing
 type MemoryStorage struct {
 	mu    sync.RWMutex
-	files map[string][]byte
+	files map[string]memoryFile
+	now   func() time.Time
+}
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
 }
 
 func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
-		files: make(map[string][]byte),
+		files: make(map[string]memoryFile),
+		now:   time.Now,
 	}
 }
 
-func (s *MemoryStorage) Upload(file multipart.File, header *multipart.FileHeader) (*UploadResult, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	data, err := io.ReadAll(file)
+func (s *MemoryStorage) Upload(r io.Reader, originalName string) (*UploadResult, error) {
+	ext := strings.ToLower(filepath.Ext(originalName))
+	if !allowedExtensions[ext] {
+		return nil, fmt.Errorf("unsupported file type: %s", ext)
+	}
+	data, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
+	if !sniffMatches(data[:min(len(data), sniffSize)], ext) {
+		return nil, fmt.Errorf("%w: %s", ErrContentMismatch, ext)
+	}
 
-	id := uuid.New().String()
-	ext := filepath.Ext(header.Filename)
-	fileName := fmt.Sprintf("%s%s", id, ext)
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	filePath := BlobPath(hash)
 
-	s.files[fileName] = data
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[filePath] = memoryFile{data: data, modTime: s.now()}
 
 	return &UploadResult{
-		FileName: fileName,
-		FilePath: fileName,
+		FileName:     hash + ext,
+		OriginalName: originalName,
+		FilePath:     filePath,
+		FileSize:     int64(len(data)),
+		MimeType:     mimeTypeFor(ext),
+		Hash:         hash,
 	}, nil
 }
 
-func (s *MemoryStorage) Get(fileName string) (*os.File, error) {
-	return nil, fmt.Errorf("not implemented")
+func (s *MemoryStorage) Put(filePath string, r io.Reader) (int64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[filePath] = memoryFile{data: data, modTime: s.now()}
+	return int64(len(data)), nil
+}
+
+func (s *MemoryStorage) Get(fileName string) (Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	f, ok := s.files[fileName]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: fileName, Err: fs.ErrNotExist}
+	}
+	return &memoryObject{
+		Reader: bytes.NewReader(f.data),
+		info:   objectInfo{name: path.Base(fileName), size: int64(len(f.data)), modTime: f.modTime},
+	}, nil
 }
 
 func (s *MemoryStorage) GetURL(filePath string) string {
@@ -66,20 +108,61 @@ func (s *MemoryStorage) Delete(fileName string) error {
 	defer s.mu.Unlock()
 
 	if _, ok := s.files[fileName]; !ok {
-		return fmt.Errorf("file not found: %s", fileName)
+		return &fs.PathError{Op: "remove", Path: fileName, Err: fs.ErrNotExist}
 	}
 
 	delete(s.files, fileName)
 	return nil
 }
 
+// Walk calls fn for every stored file
+func (s *MemoryStorage) Walk(fn func(filePath string) error) error {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		if err := fn(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *MemoryStorage) GetReader(fileName string) (io.ReadCloser, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	data, ok := s.files[fileName]
+	f, ok := s.files[fileName]
 	if !ok {
 		return nil, fmt.Errorf("file not found: %s", fileName)
 	}
-	return io.NopCloser(bytes.NewReader(data)), nil
+	return io.NopCloser(bytes.NewReader(f.data)), nil
 }
+
+type memoryObject struct {
+	*bytes.Reader
+	info objectInfo
+}
+
+func (o *memoryObject) Close() error { return nil }
+
+func (o *memoryObject) Stat() (fs.FileInfo, error) { return o.info, nil }
+
+// objectInfo describes a stored file that is not on local disk
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i objectInfo) Name() string       { return i.name }
+func (i objectInfo) Size() int64        { return i.size }
+func (i objectInfo) Mode() fs.FileMode  { return 0444 }
+func (i objectInfo) ModTime() time.Time { return i.modTime }
+func (i objectInfo) IsDir() bool        { return false }
+func (i objectInfo) Sys() any           { return nil }
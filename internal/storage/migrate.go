@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrHashMismatch means a file's content differs from what it should be
+var ErrHashMismatch = errors.New("hash mismatch")
+
+// MigrateOptions tunes Migrate
+type MigrateOptions struct {
+	// Expected maps storage paths to known SHA-256 hashes (BookFile.Hash);
+	// the source and the copy are checked against them as well
+	Expected map[string]string
+	// DryRun only reads the source and checks it against Expected
+	DryRun bool
+	// Progress, if set, is called after each file with its outcome
+	Progress func(filePath string, err error)
+}
+
+// MigrateReport summarises a Migrate run
+type MigrateReport struct {
+	Copied  int
+	Skipped int
+	Bytes   int64
+	Failed  map[string]error
+}
+
+// Migrate copies every file of src to dst under the same path and verifies
+// each copy by re-reading it from dst. Files already in dst with the right
+// content are skipped, so an interrupted run can simply be repeated.
+func Migrate(src, dst FileStorage, opts MigrateOptions) (*MigrateReport, error) {
+	walker, ok := src.(Walker)
+	if !ok {
+		return nil, errors.New("source storage cannot list its files")
+	}
+
+	report := &MigrateReport{Failed: make(map[string]error)}
+	err := walker.Walk(func(filePath string) error {
+		skipped, n, err := migrateFile(src, dst, filePath, opts)
+		switch {
+		case err != nil:
+			report.Failed[filePath] = err
+		case skipped:
+			report.Skipped++
+		default:
+			report.Copied++
+			report.Bytes += n
+		}
+		if opts.Progress != nil {
+			opts.Progress(filePath, err)
+		}
+		return nil
+	})
+	return report, err
+}
+
+func migrateFile(src, dst FileStorage, filePath string, opts MigrateOptions) (skipped bool, n int64, err error) {
+	want := opts.Expected[filePath]
+
+	if opts.DryRun {
+		got, err := hashStored(src, filePath)
+		if err != nil {
+			return false, 0, err
+		}
+		if want != "" && got != want {
+			return false, 0, fmt.Errorf("%w: source has %s, database %s", ErrHashMismatch, got, want)
+		}
+		return true, 0, nil
+	}
+
+	if dst.Exists(filePath) {
+		expected := want
+		got, err := hashStored(dst, filePath)
+		if err == nil && expected == "" {
+			expected, err = hashStored(src, filePath)
+		}
+		if err == nil && got == expected {
+			return true, 0, nil
+		}
+	}
+
+	obj, err := src.Get(filePath)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to open source: %w", err)
+	}
+	hash := sha256.New()
+	n, err = dst.Put(filePath, io.TeeReader(obj, hash))
+	_ = obj.Close()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to write target: %w", err)
+	}
+	srcHash := hex.EncodeToString(hash.Sum(nil))
+	if want != "" && srcHash != want {
+		return false, n, fmt.Errorf("%w: source has %s, database %s", ErrHashMismatch, srcHash, want)
+	}
+
+	dstHash, err := hashStored(dst, filePath)
+	if err != nil {
+		return false, n, fmt.Errorf("failed to verify target: %w", err)
+	}
+	if dstHash != srcHash {
+		return false, n, fmt.Errorf("%w: copied %s, target has %s", ErrHashMismatch, srcHash, dstHash)
+	}
+	return false, n, nil
+}
+
+// hashStored returns the hex SHA-256 of a stored file
+func hashStored(s FileStorage, filePath string) (string, error) {
+	obj, err := s.Get(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = obj.Close() }()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, obj); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// PartialStore keeps unfinished resumable uploads on local disk, whatever
+// the final storage is. Chunks are appended in order, so a partial file is
+// always a prefix of the upload.
+type PartialStore struct {
+	dir string
+}
+
+// NewPartialStore creates the store; dir is created on the first upload
+func NewPartialStore(dir string) *PartialStore {
+	return &PartialStore{dir: dir}
+}
+
+func (s *PartialStore) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+".part")
+}
+
+// Create starts an empty partial file
+func (s *PartialStore) Create(id string) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	f, err := os.OpenFile(s.path(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create partial upload: %w", err)
+	}
+	return f.Close()
+}
+
+// Append writes at most limit bytes from r at offset and returns how many
+// were written, also when r fails midway: a client that lost its connection
+// resumes from what was stored. Bytes past offset, left by a write whose
+// offset was never recorded, are discarded first.
+func (s *PartialStore) Append(id string, offset int64, r io.Reader, limit int64) (int64, error) {
+	f, err := os.OpenFile(s.path(id), os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := f.Truncate(offset); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, limit))
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+	return n, err
+}
+
+// Open opens a partial file for reading
+func (s *PartialStore) Open(id string) (*os.File, error) {
+	return os.Open(s.path(id))
+}
+
+// Remove deletes a partial file; a missing file is not an error
+func (s *PartialStore) Remove(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
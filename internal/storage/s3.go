@@ -0,0 +1,817 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// s3MinPartSize is the smallest part S3 accepts, except for the last one
+	s3MinPartSize     = 5 << 20
+	s3DefaultPartSize = 16 << 20
+	s3MaxParts        = 10000
+	// s3MaxCopySize is the largest object a single CopyObject can write
+	s3MaxCopySize = 5 << 30
+	// s3StagingDir holds uploads until their hash, and so their key, is known
+	s3StagingDir = ".staging"
+	// s3ReadAhead is how much ReadAt fetches at once; zip readers issue many
+	// small adjacent reads that would otherwise each be a request
+	s3ReadAhead = 256 << 10
+
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3TimeFormat      = "20060102T150405Z"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3EmptyHash       = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Config configures an S3-compatible backend (AWS S3, MinIO and the like)
+type S3Config struct {
+	// Endpoint is scheme://host[:port] of the API, e.g. https://s3.eu-central-1.amazonaws.com
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket in the path (MinIO) instead of the host name
+	PathStyle bool
+	// PartSize is the multipart chunk size; files up to one part use a plain PUT
+	PartSize int64
+	// PresignExpiry enables presigned download URLs valid this long
+	PresignExpiry time.Duration
+}
+
+// S3Storage keeps files in an S3 bucket. Requests are signed with AWS
+// Signature Version 4; large files go up as multipart uploads, reads are
+// ranged GETs, so nothing is buffered beyond one part.
+type S3Storage struct {
+	cfg         S3Config
+	endpoint    *url.URL
+	client      *http.Client
+	now         func() time.Time
+	maxCopySize int64
+}
+
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("S3 bucket is not set")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.PartSize == 0 {
+		cfg.PartSize = s3DefaultPartSize
+	}
+	if cfg.PartSize < s3MinPartSize {
+		return nil, fmt.Errorf("S3 part size must be at least %d bytes", s3MinPartSize)
+	}
+	return &S3Storage{
+		cfg:         cfg,
+		endpoint:    endpoint,
+		client:      &http.Client{},
+		now:         time.Now,
+		maxCopySize: s3MaxCopySize,
+	}, nil
+}
+
+// S3Error is an error response of the S3 API
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// Unwrap lets errors.Is(err, fs.ErrNotExist) detect missing objects
+func (e *S3Error) Unwrap() error {
+	if e.StatusCode == http.StatusNotFound || e.Code == "NoSuchKey" {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+func (s *S3Storage) Upload(r io.Reader, originalName string) (*UploadResult, error) {
+	ext := strings.ToLower(path.Ext(originalName))
+	if !allowedExtensions[ext] {
+		return nil, fmt.Errorf("unsupported file type: %s", ext)
+	}
+	r, err := sniffUpload(r, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	// The key depends on the hash, which is only known after the last part:
+	// the file goes to a staging key and is then copied into place on the
+	// server. Copying over an existing blob keeps one copy and refreshes its
+	// Last-Modified, which holds off the orphan sweep.
+	staging := path.Join(s3StagingDir, uuid.New().String())
+	hash := sha256.New()
+	size, err := s.Put(staging, io.TeeReader(r, hash))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = s.Delete(staging) }()
+
+	hashStr := hex.EncodeToString(hash.Sum(nil))
+	filePath := BlobPath(hashStr)
+	if err := s.copyObject(staging, filePath, size); err != nil {
+		return nil, err
+	}
+
+	return &UploadResult{
+		FileName:     hashStr + ext,
+		OriginalName: originalName,
+		FilePath:     filePath,
+		FileSize:     size,
+		MimeType:     mimeTypeFor(ext),
+		Hash:         hashStr,
+	}, nil
+}
+
+// copyObject copies src to dst inside the bucket without downloading it.
+// Objects above the CopyObject limit are copied part by part.
+func (s *S3Storage) copyObject(src, dst string, size int64) (err error) {
+	source := "/" + s.cfg.Bucket + "/" + s3Escape(s3Key(src), false)
+	key := s3Key(dst)
+
+	if size <= s.maxCopySize {
+		req, err := s.newRequest(http.MethodPut, key, nil, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Amz-Copy-Source", source)
+		resp, err := s.do(req)
+		if err != nil {
+			return err
+		}
+		return s3ResultError(resp)
+	}
+
+	uploadID, err := s.createMultipart(key)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			s.abortMultipart(key, uploadID)
+		}
+	}()
+
+	partSize := max(s.cfg.PartSize, (size+s3MaxParts-1)/s3MaxParts)
+	var parts []s3CompletedPart
+	for from, number := int64(0), 1; from < size; from, number = from+partSize, number+1 {
+		to := min(from+partSize, size) - 1
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		req, err := s.newRequest(http.MethodPut, key, query, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Amz-Copy-Source", source)
+		req.Header.Set("X-Amz-Copy-Source-Range", fmt.Sprintf("bytes=%d-%d", from, to))
+		resp, err := s.do(req)
+		if err != nil {
+			return err
+		}
+		var result struct {
+			ETag string `xml:"ETag"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if err != nil || result.ETag == "" {
+			return fmt.Errorf("s3: invalid copy part %d response", number)
+		}
+		parts = append(parts, s3CompletedPart{PartNumber: number, ETag: result.ETag})
+	}
+	return s.completeMultipart(key, uploadID, parts)
+}
+
+// Put uploads r in one request if it fits in a part, as a multipart upload otherwise
+func (s *S3Storage) Put(filePath string, r io.Reader) (int64, error) {
+	key := s3Key(filePath)
+	buf := make([]byte, s.cfg.PartSize)
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return int64(n), s.putObject(key, buf[:n])
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read upload: %w", err)
+	}
+	return s.multipartUpload(key, buf, r)
+}
+
+func (s *S3Storage) putObject(key string, data []byte) error {
+	req, err := s.newRequest(http.MethodPut, key, nil, data)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentTypeFor(key))
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// multipartUpload sends first and the rest of r in parts of PartSize,
+// aborting the upload on any failure so no orphaned parts are billed
+func (s *S3Storage) multipartUpload(key string, first []byte, r io.Reader) (total int64, err error) {
+	uploadID, err := s.createMultipart(key)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			s.abortMultipart(key, uploadID)
+		}
+	}()
+
+	var parts []s3CompletedPart
+	part := first
+	for number := 1; ; number++ {
+		if number > s3MaxParts {
+			return 0, fmt.Errorf("file needs more than %d parts of %d bytes", s3MaxParts, s.cfg.PartSize)
+		}
+		etag, err := s.uploadPart(key, uploadID, number, part)
+		if err != nil {
+			return 0, err
+		}
+		parts = append(parts, s3CompletedPart{PartNumber: number, ETag: etag})
+		total += int64(len(part))
+
+		// part aliases the buffer, which is free again once the part is sent
+		n, err := io.ReadFull(r, first)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, fmt.Errorf("failed to read upload: %w", err)
+		}
+		part = first[:n]
+	}
+
+	if err := s.completeMultipart(key, uploadID, parts); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+func (s *S3Storage) createMultipart(key string) (string, error) {
+	req, err := s.newRequest(http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentTypeFor(key))
+	resp, err := s.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("s3: invalid multipart upload response: %w", err)
+	}
+	if result.UploadID == "" {
+		return "", errors.New("s3: multipart upload without id")
+	}
+	return result.UploadID, nil
+}
+
+func (s *S3Storage) uploadPart(key, uploadID string, number int, data []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	req, err := s.newRequest(http.MethodPut, key, query, data)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return "", err
+	}
+	_ = resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("s3: part %d uploaded without ETag", number)
+	}
+	return etag, nil
+}
+
+func (s *S3Storage) completeMultipart(key, uploadID string, parts []s3CompletedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	req, err := s.newRequest(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	return s3ResultError(resp)
+}
+
+// s3ResultError reads the body of a completion or copy response, which can
+// report a failure after the 200 status line has been sent
+func s3ResultError(resp *http.Response) error {
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		s3err := &S3Error{StatusCode: resp.StatusCode}
+		_ = xml.Unmarshal(data, s3err)
+		return s3err
+	}
+	return nil
+}
+
+func (s *S3Storage) abortMultipart(key, uploadID string) {
+	req, err := s.newRequest(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil)
+	if err != nil {
+		return
+	}
+	if resp, err := s.do(req); err == nil {
+		_ = resp.Body.Close()
+	}
+}
+
+func (s *S3Storage) Get(filePath string) (Object, error) {
+	key := s3Key(filePath)
+	info, err := s.head(key)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Object{s: s, key: key, info: info}, nil
+}
+
+func (s *S3Storage) head(key string) (objectInfo, error) {
+	req, err := s.newRequest(http.MethodHead, key, nil, nil)
+	if err != nil {
+		return objectInfo{}, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return objectInfo{}, &fs.PathError{Op: "open", Path: key, Err: err}
+	}
+	_ = resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return objectInfo{name: path.Base(key), size: resp.ContentLength, modTime: modTime}, nil
+}
+
+// getRange fetches bytes from..to of key, both inclusive
+func (s *S3Storage) getRange(key string, from, to int64) (io.ReadCloser, error) {
+	req, err := s.newRequest(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, to))
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) Delete(filePath string) error {
+	req, err := s.newRequest(http.MethodDelete, s3Key(filePath), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *S3Storage) Exists(filePath string) bool {
+	_, err := s.head(s3Key(filePath))
+	return err == nil
+}
+
+func (s *S3Storage) GetURL(filePath string) string {
+	return s.objectURL(s3Key(filePath), nil).String()
+}
+
+// PresignGet returns a GET URL that is valid for PresignExpiry without other credentials
+func (s *S3Storage) PresignGet(filePath string, opts PresignOptions) (string, error) {
+	if s.cfg.PresignExpiry <= 0 {
+		return "", ErrPresignDisabled
+	}
+	query := url.Values{}
+	if opts.ContentType != "" {
+		query.Set("response-content-type", opts.ContentType)
+	}
+	if opts.ContentDisposition != "" {
+		query.Set("response-content-disposition", opts.ContentDisposition)
+	}
+	return s.presign(http.MethodGet, s3Key(filePath), query, s.cfg.PresignExpiry, s.now()), nil
+}
+
+func (s *S3Storage) presign(method, key string, query url.Values, expires time.Duration, t time.Time) string {
+	t = t.UTC()
+	scope := s.scope(t)
+	query.Set("X-Amz-Algorithm", s3Algorithm)
+	query.Set("X-Amz-Credential", s.cfg.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", t.Format(s3TimeFormat))
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	u := s.objectURL(key, query)
+	canonical := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		s3UnsignedPayload,
+	}, "\n")
+	u.RawQuery += "&X-Amz-Signature=" + s.signature(t, canonical)
+	return u.String()
+}
+
+// Walk lists the bucket with ListObjectsV2. Keys under dot-directories
+// hold staged uploads and are skipped, as on local disk.
+func (s *S3Storage) Walk(fn func(filePath string) error) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := s.newRequest(http.MethodGet, "", query, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := s.do(req)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		_ = resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("s3: invalid list response: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			if strings.HasSuffix(obj.Key, "/") || strings.HasPrefix(obj.Key, ".") || strings.Contains(obj.Key, "/.") {
+				continue
+			}
+			if err := fn(obj.Key); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// s3Key turns a storage path into an object key without a leading slash
+func s3Key(filePath string) string {
+	return strings.TrimPrefix(path.Clean("/"+filePath), "/")
+}
+
+func contentTypeFor(key string) string {
+	ext := strings.ToLower(path.Ext(key))
+	if allowedExtensions[ext] {
+		return mimeTypeFor(ext)
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// objectURL addresses key in the bucket. Path and query are encoded the way
+// Signature V4 expects, so the URL sent is exactly the one signed.
+func (s *S3Storage) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	if s.cfg.PathStyle {
+		u.Path = "/" + s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = s3CanonicalQuery(query)
+	return &u
+}
+
+func (s *S3Storage) newRequest(method, key string, query url.Values, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	payloadHash := s3EmptyHash
+	if body != nil {
+		reader = bytes.NewReader(body)
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	req, err := http.NewRequestWithContext(context.Background(), method, s.objectURL(key, query).String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	return req, nil
+}
+
+// do signs and sends req; error responses come back as *S3Error
+func (s *S3Storage) do(req *http.Request) (*http.Response, error) {
+	s.sign(req)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer func() { _ = resp.Body.Close() }()
+	s3err := &S3Error{StatusCode: resp.StatusCode}
+	if data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10)); err == nil {
+		_ = xml.Unmarshal(data, s3err)
+	}
+	return nil, s3err
+}
+
+// sign adds a Signature V4 Authorization header covering host, Range,
+// Content-Type and the x-amz-* headers. The payload hash is taken from
+// X-Amz-Content-Sha256, set by newRequest.
+func (s *S3Storage) sign(req *http.Request) {
+	t := s.now().UTC()
+	req.Header.Set("X-Amz-Date", t.Format(s3TimeFormat))
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+
+	signedHeaders, canonicalHeaders := s3CanonicalHeaders(req)
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.cfg.AccessKey, s.scope(t), signedHeaders, s.signature(t, canonical)))
+}
+
+func (s *S3Storage) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+func (s *S3Storage) signature(t time.Time, canonicalRequest string) string {
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		t.Format(s3TimeFormat),
+		s.scope(t),
+		hex.EncodeToString(sum[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3CanonicalHeaders returns the signed header list and the canonical
+// header block, which ends with a newline
+func s3CanonicalHeaders(req *http.Request) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	values := map[string]string{"host": host}
+	for name, vals := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" || name == "content-type" || name == "content-md5" {
+			for i, v := range vals {
+				vals[i] = strings.Join(strings.Fields(v), " ")
+			}
+			values[name] = strings.Join(vals, ",")
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + values[name] + "\n")
+	}
+	return strings.Join(names, ";"), b.String()
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(query))
+	for key, vals := range query {
+		for _, v := range vals {
+			pairs = append(pairs, s3Escape(key, true)+"="+s3Escape(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape percent-encodes everything but unreserved characters, as
+// Signature V4 requires; slashes are kept in paths
+func s3Escape(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+// s3Object reads an object with ranged GETs. Sequential reads stream one
+// open response; ReadAt goes through a read-ahead window.
+type s3Object struct {
+	s    *S3Storage
+	key  string
+	info objectInfo
+
+	off  int64
+	body io.ReadCloser
+
+	mu     sync.Mutex
+	win    []byte
+	winOff int64
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.off >= o.info.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		body, err := o.s.getRange(o.key, o.off, o.info.size-1)
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+	}
+	n, err := o.body.Read(p)
+	o.off += int64(n)
+	if errors.Is(err, io.EOF) {
+		_ = o.body.Close()
+		o.body = nil
+		if o.off < o.info.size {
+			return n, io.ErrUnexpectedEOF
+		}
+		if n > 0 {
+			err = nil
+		}
+	}
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = o.off + offset
+	case io.SeekEnd:
+		abs = o.info.size + offset
+	default:
+		return 0, errors.New("s3: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+	if abs != o.off && o.body != nil {
+		_ = o.body.Close()
+		o.body = nil
+	}
+	o.off = abs
+	return abs, nil
+}
+
+func (o *s3Object) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("s3: negative offset")
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= o.info.size {
+			return n, io.EOF
+		}
+		if pos < o.winOff || pos >= o.winOff+int64(len(o.win)) {
+			if err := o.fill(pos, len(p)-n); err != nil {
+				return n, err
+			}
+		}
+		n += copy(p[n:], o.win[pos-o.winOff:])
+	}
+	return n, nil
+}
+
+func (o *s3Object) fill(pos int64, want int) error {
+	end := pos + int64(max(want, s3ReadAhead))
+	if end > o.info.size {
+		end = o.info.size
+	}
+	body, err := o.s.getRange(o.key, pos, end-1)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = body.Close() }()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	o.win, o.winOff = data, pos
+	return nil
+}
+
+func (o *s3Object) Stat() (fs.FileInfo, error) {
+	return o.info, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body != nil {
+		err := o.body.Close()
+		o.body = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrContentMismatch means the leading bytes are not those of the format
+	// the file name claims, e.g. an executable renamed to .pdf
+	ErrContentMismatch = errors.New("file content does not match its extension")
+	// ErrMalformedBook means the file has the right signature but not the
+	// structure of its format
+	ErrMalformedBook = errors.New("malformed book file")
+	// ErrArchiveLimits means an EPUB would unpack past ValidationLimits
+	ErrArchiveLimits = errors.New("archive exceeds unpacking limits")
+)
+
+const (
+	// sniffSize is how many leading bytes are checked; PDF allows junk
+	// before %PDF- within the first kilobyte
+	sniffSize = 1024
+	// epubMimetype is the required content of the EPUB mimetype entry
+	epubMimetype = "application/epub+zip"
+	// ratioFloor is the unpacked size below which the compression ratio is
+	// not checked: small text files legitimately compress very well
+	ratioFloor = 1 << 20
+)
+
+// ValidationLimits bound what an EPUB may unpack to. Entry sizes come from
+// the central directory; archive/zip refuses to read past them, so a lying
+// header cannot get more out of an entry than was checked.
+type ValidationLimits struct {
+	MaxEntries  int
+	MaxUnpacked int64
+	// MaxRatio is the largest unpacked/packed ratio of one entry
+	MaxRatio int64
+}
+
+// DefaultValidationLimits allow 10000 entries, 2 GiB unpacked and 100:1 per entry
+func DefaultValidationLimits() ValidationLimits {
+	return ValidationLimits{MaxEntries: 10000, MaxUnpacked: 2 << 30, MaxRatio: 100}
+}
+
+// sniffMatches reports whether head starts like a file with extension ext
+func sniffMatches(head []byte, ext string) bool {
+	switch ext {
+	case ".pdf":
+		return bytes.Contains(head[:min(len(head), sniffSize)], []byte("%PDF-"))
+	case ".epub":
+		return bytes.HasPrefix(head, []byte("PK\x03\x04"))
+	case ".mobi":
+		return len(head) >= 68 && (string(head[60:68]) == "BOOKMOBI" || string(head[60:68]) == "TEXtREAd")
+	}
+	return false
+}
+
+// sniffUpload checks the leading bytes of an upload stream against ext and
+// returns a reader that still yields the whole stream
+func sniffUpload(r io.Reader, ext string) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, sniffSize)
+	head, err := br.Peek(sniffSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !sniffMatches(head, ext) {
+		return nil, fmt.Errorf("%w: %s", ErrContentMismatch, ext)
+	}
+	return br, nil
+}
+
+// ValidateBook checks that the content of a book file matches the
+// extension of name and has the basic structure of the format: the PDF
+// trailer, the EPUB mimetype entry and container, the MOBI record table.
+func ValidateBook(r io.ReaderAt, size int64, name string, limits ValidationLimits) error {
+	ext := strings.ToLower(filepath.Ext(name))
+	if !allowedExtensions[ext] {
+		return fmt.Errorf("unsupported file type: %s", ext)
+	}
+	head := make([]byte, min(size, sniffSize))
+	if _, err := r.ReadAt(head, 0); err != nil && err != io.EOF {
+		return err
+	}
+	if !sniffMatches(head, ext) {
+		return fmt.Errorf("%w: %s", ErrContentMismatch, ext)
+	}
+
+	switch ext {
+	case ".pdf":
+		return validatePDF(r, size)
+	case ".epub":
+		return validateEPUB(r, size, limits)
+	default:
+		return validateMOBI(r, size, head)
+	}
+}
+
+// validatePDF looks for startxref and %%EOF at the end of the file
+func validatePDF(r io.ReaderAt, size int64) error {
+	tail := make([]byte, min(size, sniffSize))
+	if _, err := r.ReadAt(tail, size-int64(len(tail))); err != nil && err != io.EOF {
+		return err
+	}
+	if !bytes.Contains(tail, []byte("startxref")) || !bytes.Contains(tail, []byte("%%EOF")) {
+		return fmt.Errorf("%w: pdf has no trailer", ErrMalformedBook)
+	}
+	return nil
+}
+
+func validateEPUB(r io.ReaderAt, size int64, limits ValidationLimits) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedBook, err)
+	}
+	if limits.MaxEntries > 0 && len(zr.File) > limits.MaxEntries {
+		return fmt.Errorf("%w: %d entries", ErrArchiveLimits, len(zr.File))
+	}
+
+	var unpacked uint64
+	var mimetype *zip.File
+	hasContainer := false
+	for _, f := range zr.File {
+		if path.IsAbs(f.Name) || strings.Contains(f.Name, "\\") || containsDotDot(f.Name) {
+			return fmt.Errorf("%w: unsafe entry name %q", ErrMalformedBook, f.Name)
+		}
+		unpacked += f.UncompressedSize64
+		if limits.MaxUnpacked > 0 && unpacked > uint64(limits.MaxUnpacked) {
+			return fmt.Errorf("%w: more than %d bytes unpacked", ErrArchiveLimits, limits.MaxUnpacked)
+		}
+		if limits.MaxRatio > 0 && f.UncompressedSize64 > ratioFloor &&
+			f.UncompressedSize64 > f.CompressedSize64*uint64(limits.MaxRatio) {
+			return fmt.Errorf("%w: %s compressed more than %d:1", ErrArchiveLimits, f.Name, limits.MaxRatio)
+		}
+		switch f.Name {
+		case "mimetype":
+			mimetype = f
+		case "META-INF/container.xml":
+			hasContainer = true
+		}
+	}
+
+	if mimetype == nil {
+		return fmt.Errorf("%w: zip has no epub mimetype entry", ErrContentMismatch)
+	}
+	rc, err := mimetype.Open()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedBook, err)
+	}
+	content, err := io.ReadAll(io.LimitReader(rc, 64))
+	_ = rc.Close()
+	if err != nil || strings.TrimSpace(string(content)) != epubMimetype {
+		return fmt.Errorf("%w: mimetype is not %s", ErrContentMismatch, epubMimetype)
+	}
+	if !hasContainer {
+		return fmt.Errorf("%w: epub has no META-INF/container.xml", ErrMalformedBook)
+	}
+	return nil
+}
+
+// validateMOBI checks the PalmDB record table: at least one record, all
+// offsets inside the file and in order
+func validateMOBI(r io.ReaderAt, size int64, head []byte) error {
+	if len(head) < 78 {
+		return fmt.Errorf("%w: mobi header is truncated", ErrMalformedBook)
+	}
+	records := int64(binary.BigEndian.Uint16(head[76:78]))
+	if records == 0 || 78+records*8 > size {
+		return fmt.Errorf("%w: bad mobi record table", ErrMalformedBook)
+	}
+	table := make([]byte, records*8)
+	if _, err := r.ReadAt(table, 78); err != nil && err != io.EOF {
+		return err
+	}
+	prev := int64(0)
+	for i := int64(0); i < records; i++ {
+		off := int64(binary.BigEndian.Uint32(table[i*8:]))
+		if off < prev || off >= size {
+			return fmt.Errorf("%w: mobi record %d is out of range", ErrMalformedBook, i)
+		}
+		prev = off
+	}
+	return nil
+}
+
+func containsDotDot(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const validPDF = "%PDF-1.4\n1 0 obj<<>>endobj\nstartxref\n9\n%%EOF\n"
+
+func buildEPUB(t *testing.T, entries map[string]string, mimetype bool) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if mimetype {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+		require.NoError(t, err)
+		_, _ = w.Write([]byte(epubMimetype))
+	}
+	for name, body := range entries {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, _ = w.Write([]byte(body))
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func buildMOBI(offsets ...uint32) []byte {
+	data := make([]byte, 78+len(offsets)*8+64)
+	copy(data[60:], "BOOKMOBI")
+	binary.BigEndian.PutUint16(data[76:], uint16(len(offsets)))
+	for i, off := range offsets {
+		binary.BigEndian.PutUint32(data[78+i*8:], off)
+	}
+	return data
+}
+
+func TestLocalUploadRejectsRenamedFiles(t *testing.T) {
+	s := NewLocalStorage(t.TempDir(), "")
+	_, err := s.Upload(strings.NewReader("MZ\x90\x00 not a book"), "setup.pdf")
+	assert.ErrorIs(t, err, ErrContentMismatch)
+	_, err = s.Upload(strings.NewReader(validPDF), "book.epub")
+	assert.ErrorIs(t, err, ErrContentMismatch)
+
+	entries, err := os.ReadDir(s.BasePath)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "rejected uploads leave nothing behind")
+
+	// Junk before %PDF- within the first kilobyte is allowed by the PDF spec
+	_, err = s.Upload(strings.NewReader(strings.Repeat(" ", 100)+validPDF), "book.pdf")
+	assert.NoError(t, err)
+}
+
+func TestValidateBook(t *testing.T) {
+	limits := DefaultValidationLimits()
+	container := map[string]string{"META-INF/container.xml": "<container/>"}
+	bomb := map[string]string{
+		"META-INF/container.xml": "<container/>",
+		"text.xhtml":             strings.Repeat("a", 4<<20),
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		data    []byte
+		wantErr error
+	}{
+		{"pdf", "a.pdf", []byte(validPDF), nil},
+		{"pdf without trailer", "a.pdf", []byte("%PDF-1.4\n1 0 obj<<>>endobj\n"), ErrMalformedBook},
+		{"exe named pdf", "a.pdf", []byte("MZ\x90\x00"), ErrContentMismatch},
+		{"epub", "a.epub", buildEPUB(t, container, true), nil},
+		{"plain zip named epub", "a.epub", buildEPUB(t, container, false), ErrContentMismatch},
+		{"epub without container", "a.epub", buildEPUB(t, map[string]string{"a.xhtml": "x"}, true), ErrMalformedBook},
+		{"epub with path traversal", "a.epub", buildEPUB(t, map[string]string{"../../etc/passwd": "x"}, true), ErrMalformedBook},
+		{"zip bomb", "a.epub", buildEPUB(t, bomb, true), ErrArchiveLimits},
+		{"truncated zip", "a.epub", buildEPUB(t, container, true)[:60], ErrMalformedBook},
+		{"mobi", "a.mobi", buildMOBI(78+8, 100), nil},
+		{"mobi records past the end", "a.mobi", buildMOBI(86, 1<<20), ErrMalformedBook},
+		{"mobi without records", "a.mobi", buildMOBI(), ErrMalformedBook},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateBook(bytes.NewReader(tt.data), int64(len(tt.data)), tt.file, limits)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("entry and size limits", func(t *testing.T) {
+		data := buildEPUB(t, container, true)
+		err := ValidateBook(bytes.NewReader(data), int64(len(data)), "a.epub", ValidationLimits{MaxEntries: 1})
+		assert.ErrorIs(t, err, ErrArchiveLimits)
+		err = ValidateBook(bytes.NewReader(data), int64(len(data)), "a.epub", ValidationLimits{MaxUnpacked: 10})
+		assert.ErrorIs(t, err, ErrArchiveLimits)
+	})
+}
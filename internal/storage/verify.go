@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+)
+
+// VerifyReport summarises a Verify run
+type VerifyReport struct {
+	Checked int
+	// Failed maps paths to fs.ErrNotExist, ErrHashMismatch or a read error
+	Failed map[string]error
+}
+
+// Verify re-reads every file in expected (storage path to hex SHA-256) and
+// reports files that are missing or whose content no longer matches.
+// Progress, if set, is called after each file with its outcome.
+func Verify(s FileStorage, expected map[string]string, progress func(filePath string, err error)) *VerifyReport {
+	paths := make([]string, 0, len(expected))
+	for p := range expected {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	report := &VerifyReport{Failed: make(map[string]error)}
+	for _, filePath := range paths {
+		got, err := hashStored(s, filePath)
+		if err == nil && got != expected[filePath] {
+			err = fmt.Errorf("%w: stored %s, expected %s", ErrHashMismatch, got, expected[filePath])
+		}
+		report.Checked++
+		if err != nil {
+			report.Failed[filePath] = err
+		}
+		if progress != nil {
+			progress(filePath, err)
+		}
+	}
+	return report
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalUploadIsContentAddressed(t *testing.T) {
+	s := NewLocalStorage(t.TempDir(), "")
+	first, err := s.Upload(strings.NewReader("%PDF-1.4 same"), "a.pdf")
+	require.NoError(t, err)
+	second, err := s.Upload(strings.NewReader("%PDF-1.4 same"), "b.PDF")
+	require.NoError(t, err)
+
+	assert.Equal(t, BlobPath(first.Hash), first.FilePath)
+	assert.Equal(t, first.FilePath, second.FilePath)
+	assert.Equal(t, first.Hash+".pdf", second.FileName)
+	assert.Equal(t, "b.PDF", second.OriginalName)
+
+	var files []string
+	require.NoError(t, s.Walk(func(p string) error {
+		files = append(files, p)
+		return nil
+	}))
+	assert.Equal(t, []string{first.FilePath}, files, "identical content is stored once, temp files are gone")
+}
+
+func TestVerify(t *testing.T) {
+	s := NewLocalStorage(t.TempDir(), "")
+	good, err := s.Upload(strings.NewReader("%PDF-1.4 good"), "good.pdf")
+	require.NoError(t, err)
+	bad, err := s.Upload(strings.NewReader("%PDF-1.4 bad"), "bad.pdf")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(s.LocalPath(bad.FilePath), []byte("%PDF-1.4 b4d"), 0644))
+
+	missing := BlobPath(strings.Repeat("0", 64))
+	var seen []string
+	report := Verify(s, map[string]string{
+		good.FilePath: good.Hash,
+		bad.FilePath:  bad.Hash,
+		missing:       good.Hash,
+	}, func(filePath string, err error) { seen = append(seen, filePath) })
+
+	assert.Equal(t, 3, report.Checked)
+	assert.Len(t, seen, 3)
+	assert.NotContains(t, report.Failed, good.FilePath)
+	assert.ErrorIs(t, report.Failed[bad.FilePath], ErrHashMismatch)
+	assert.ErrorIs(t, report.Failed[missing], fs.ErrNotExist)
+}
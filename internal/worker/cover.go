@@ -0,0 +1,277 @@
+package worker
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"sort"
+
+	// Register decoders for image.Decode
+	_ "image/png"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// MaxCoverBytes bounds both uploaded and extracted cover images
+	MaxCoverBytes = 10 << 20
+	// maxCoverPixels guards against decompression bombs: a tiny PNG can
+	// declare a huge canvas
+	maxCoverPixels   = 40_000_000
+	thumbnailQuality = 85
+)
+
+// CoverSize is a generated thumbnail: Width in pixels, height keeps the aspect ratio
+type CoverSize struct {
+	Name  string
+	Width int
+}
+
+// CoverSizes are the thumbnails generated for every cover
+var CoverSizes = []CoverSize{
+	{Name: "small", Width: 160},
+	{Name: "medium", Width: 320},
+	{Name: "large", Width: 640},
+}
+
+// ErrUnsupportedImage is returned for data that is not a JPEG, PNG or WebP image
+var ErrUnsupportedImage = errors.New("unsupported image format")
+
+// CoverInfo describes a validated cover image
+type CoverInfo struct {
+	Format      string
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// InspectCover checks that data is a JPEG, PNG or WebP of sane dimensions
+// without decoding the pixels
+func InspectCover(data []byte) (*CoverInfo, error) {
+	if len(data) > MaxCoverBytes {
+		return nil, fmt.Errorf("cover is larger than %d bytes", MaxCoverBytes)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	info := &CoverInfo{Format: format, Width: cfg.Width, Height: cfg.Height}
+	switch format {
+	case "jpeg":
+		info.ContentType = "image/jpeg"
+	case "png":
+		info.ContentType = "image/png"
+	case "webp":
+		info.ContentType = "image/webp"
+	default:
+		return nil, ErrUnsupportedImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxCoverPixels {
+		return nil, fmt.Errorf("cover dimensions %dx%d are out of range", cfg.Width, cfg.Height)
+	}
+	return info, nil
+}
+
+// GenerateThumbnails decodes data once and returns a JPEG per CoverSizes
+// entry, keyed by size name. Images are never upscaled.
+func GenerateThumbnails(data []byte) (map[string][]byte, error) {
+	if _, err := InspectCover(data); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode cover: %w", err)
+	}
+
+	out := make(map[string][]byte, len(CoverSizes))
+	for _, size := range CoverSizes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, resizeToWidth(src, size.Width), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+			return nil, fmt.Errorf("encode %s thumbnail: %w", size.Name, err)
+		}
+		out[size.Name] = buf.Bytes()
+	}
+	return out, nil
+}
+
+func resizeToWidth(src image.Image, width int) image.Image {
+	b := src.Bounds()
+	if b.Dx() <= width {
+		width = b.Dx()
+	}
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	// Draw onto white first: JPEG has no alpha, transparent PNG areas would turn black
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
+
+// extractEPUBCover reads the manifest cover image found by parseEPUB
+func extractEPUBCover(filePath, href string) ([]byte, error) {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = zr.Close() }()
+
+	for _, f := range zr.File {
+		if f.Name != href {
+			continue
+		}
+		if f.UncompressedSize64 > MaxCoverBytes {
+			return nil, fmt.Errorf("cover %s is too large", href)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = rc.Close() }()
+		return io.ReadAll(io.LimitReader(rc, MaxCoverBytes+1))
+	}
+	return nil, fmt.Errorf("cover %s not found in archive", href)
+}
+
+// extractPDFCover returns the largest JPEG image drawn on the first page.
+// The PDF library cannot decode DCT streams, so the image dictionaries only
+// tell us the stream lengths and the bytes are located with a raw scan for
+// JPEG streams of that length. Raster images in other encodings are skipped.
+func extractPDFCover(filePath string) (data []byte, err error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			data, err = nil, fmt.Errorf("malformed pdf: %v", r)
+		}
+	}()
+
+	r, err := pdf.NewReader(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if r.Trailer().Key("Encrypt").Kind() != pdf.Null {
+		return nil, errors.New("encrypted pdf")
+	}
+	if r.NumPage() == 0 {
+		return nil, errors.New("pdf has no pages")
+	}
+
+	type candidate struct {
+		area   int64
+		length int64
+	}
+	var candidates []candidate
+	xobjects := r.Page(1).Resources().Key("XObject")
+	for _, name := range xobjects.Keys() {
+		x := xobjects.Key(name)
+		if x.Key("Subtype").Name() != "Image" || !isDCTFilter(x.Key("Filter")) {
+			continue
+		}
+		length := x.Key("Length").Int64()
+		if length <= 0 || length > MaxCoverBytes {
+			continue
+		}
+		candidates = append(candidates, candidate{
+			area:   x.Key("Width").Int64() * x.Key("Height").Int64(),
+			length: length,
+		})
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("no jpeg image on the first page")
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].area > candidates[j].area })
+
+	for _, c := range candidates {
+		if data, ok := findJPEGStream(f, info.Size(), c.length); ok {
+			return data, nil
+		}
+	}
+	return nil, errors.New("jpeg stream not found")
+}
+
+func isDCTFilter(filter pdf.Value) bool {
+	switch filter.Kind() {
+	case pdf.Name:
+		return filter.Name() == "DCTDecode"
+	case pdf.Array:
+		return filter.Len() == 1 && filter.Index(0).Name() == "DCTDecode"
+	}
+	return false
+}
+
+// findJPEGStream scans the file for a stream that starts with a JPEG SOI
+// marker and whose declared length ends at an EOI marker
+func findJPEGStream(f io.ReaderAt, size, length int64) ([]byte, bool) {
+	const chunk = 1 << 20
+	keyword := []byte("stream")
+	buf := make([]byte, chunk+16)
+	for off := int64(0); off < size; off += chunk {
+		n, _ := f.ReadAt(buf, off)
+		if n == 0 {
+			break
+		}
+		window := buf[:n]
+		for i := 0; ; {
+			j := bytes.Index(window[i:], keyword)
+			if j < 0 || i+j >= chunk {
+				break
+			}
+			start := off + int64(i+j+len(keyword))
+			if data, ok := readJPEGAt(f, start, length); ok {
+				return data, true
+			}
+			i += j + len(keyword)
+		}
+	}
+	return nil, false
+}
+
+func readJPEGAt(f io.ReaderAt, start, length int64) ([]byte, bool) {
+	head := make([]byte, 5)
+	if _, err := f.ReadAt(head, start); err != nil {
+		return nil, false
+	}
+	// The stream keyword is followed by CRLF or LF
+	skip := 0
+	switch {
+	case head[0] == '\r' && head[1] == '\n':
+		skip = 2
+	case head[0] == '\n':
+		skip = 1
+	default:
+		return nil, false
+	}
+	if length < 4 || head[skip] != 0xFF || head[skip+1] != 0xD8 || head[skip+2] != 0xFF {
+		return nil, false
+	}
+
+	data := make([]byte, length)
+	if _, err := f.ReadAt(data, start+int64(skip)); err != nil {
+		return nil, false
+	}
+	// Some writers pad the stream, so look for EOI near the end
+	tail := data[len(data)-min(len(data), 8):]
+	if !bytes.Contains(tail, []byte{0xFF, 0xD9}) {
+		return nil, false
+	}
+	return data, true
+}
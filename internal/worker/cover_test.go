@@ -0,0 +1,132 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testImage(w, h int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func encodeTestJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, testImage(w, h), nil))
+	return buf.Bytes()
+}
+
+func TestInspectCover(t *testing.T) {
+	info, err := InspectCover(encodeTestJPEG(t, 40, 60))
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", info.ContentType)
+	assert.Equal(t, 40, info.Width)
+	assert.Equal(t, 60, info.Height)
+
+	var pngBuf bytes.Buffer
+	require.NoError(t, png.Encode(&pngBuf, testImage(10, 10)))
+	info, err = InspectCover(pngBuf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", info.ContentType)
+
+	var gifBuf bytes.Buffer
+	require.NoError(t, gif.Encode(&gifBuf, testImage(10, 10), nil))
+	_, err = InspectCover(gifBuf.Bytes())
+	assert.ErrorIs(t, err, ErrUnsupportedImage, "gif decoder is not registered for covers")
+
+	_, err = InspectCover([]byte("<svg/>"))
+	assert.ErrorIs(t, err, ErrUnsupportedImage)
+
+	// A PNG header declaring a huge canvas is rejected before decoding
+	var bomb bytes.Buffer
+	require.NoError(t, png.Encode(&bomb, image.NewGray(image.Rect(0, 0, 10000, 5000))))
+	_, err = InspectCover(bomb.Bytes())
+	assert.Error(t, err)
+}
+
+func TestGenerateThumbnails(t *testing.T) {
+	thumbs, err := GenerateThumbnails(encodeTestJPEG(t, 400, 600))
+	require.NoError(t, err)
+	require.Len(t, thumbs, len(CoverSizes))
+
+	want := map[string][2]int{
+		"small":  {160, 240},
+		"medium": {320, 480},
+		"large":  {400, 600}, // never upscaled
+	}
+	for name, dims := range want {
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(thumbs[name]))
+		require.NoError(t, err, name)
+		assert.Equal(t, "jpeg", format)
+		assert.Equal(t, dims[0], cfg.Width, name)
+		assert.Equal(t, dims[1], cfg.Height, name)
+	}
+}
+
+func TestExtractEPUBCover(t *testing.T) {
+	cover := encodeTestJPEG(t, 20, 30)
+	p := writeTestEPUB(t, map[string]string{
+		"OEBPS/images/cover.jpg": string(cover),
+	})
+
+	data, err := extractEPUBCover(p, "OEBPS/images/cover.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, cover, data)
+
+	_, err = extractEPUBCover(p, "OEBPS/missing.jpg")
+	assert.Error(t, err)
+}
+
+func TestExtractPDFCover(t *testing.T) {
+	big := encodeTestJPEG(t, 60, 90)
+	small := encodeTestJPEG(t, 10, 10)
+
+	for _, compressed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compressed=%v", compressed), func(t *testing.T) {
+			objs := map[int]pdfObject{
+				1: {body: "<< /Type /Catalog /Pages 2 0 R >>"},
+				2: {body: "<< /Type /Pages /Kids [3 0 R] /Count 1 /Resources << /XObject << /Logo 4 0 R /Cover 5 0 R >> >> >>"},
+				3: {body: "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 600 900] /Contents 6 0 R >>"},
+				4: {
+					body:   fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width 10 /Height 10 /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>", len(small)),
+					stream: string(small),
+				},
+				5: {
+					body:   fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width 60 /Height 90 /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter [/DCTDecode] /Length %d >>", len(big)),
+					stream: string(big),
+				},
+				6: {body: "<< /Length 32 >>", stream: "q 600 0 0 900 0 0 cm /Cover Do Q"},
+			}
+			path := writeTestPDF(t, buildTestPDF(objs, "/Root 1 0 R", compressed))
+
+			data, err := extractPDFCover(path)
+			require.NoError(t, err)
+			assert.Equal(t, big, data, "the largest image wins")
+		})
+	}
+
+	t.Run("no images", func(t *testing.T) {
+		objs := map[int]pdfObject{
+			1: {body: "<< /Type /Catalog /Pages 2 0 R >>"},
+			2: {body: "<< /Type /Pages /Kids [3 0 R] /Count 1 >>"},
+			3: {body: "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 600 900] >>"},
+		}
+		_, err := extractPDFCover(writeTestPDF(t, buildTestPDF(objs, "/Root 1 0 R", false)))
+		assert.Error(t, err)
+	})
+}
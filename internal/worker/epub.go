@@ -0,0 +1,433 @@
+package worker
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	epubContainerPath = "META-INF/container.xml"
+	// epubMaxXMLSize bounds how much of a single OPF/NCX/nav document is read
+	epubMaxXMLSize   = 8 << 20
+	epubNamespaceOPS = "http://www.idpf.org/2007/ops"
+)
+
+// EPUBMetadata is what the processor extracts from an EPUB and stores in
+// BookFile.Metadata as JSON. All hrefs are full paths inside the archive, so
+// the reader can fetch them from /files/:id/epub/<href>.
+type EPUBMetadata struct {
+	Format      string          `json:"format"`
+	Version     string          `json:"version,omitempty"`
+	Title       string          `json:"title,omitempty"`
+	Author      string          `json:"author,omitempty"`
+	Language    string          `json:"language,omitempty"`
+	Publisher   string          `json:"publisher,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Identifier  string          `json:"identifier,omitempty"`
+	PackagePath string          `json:"package_path"`
+	Cover       string          `json:"cover,omitempty"`
+	Spine       []EPUBSpineItem `json:"spine"`
+	TOC         []EPUBTOCItem   `json:"toc,omitempty"`
+	Resources   []EPUBResource  `json:"resources"`
+}
+
+// EPUBSpineItem is one document in reading order
+type EPUBSpineItem struct {
+	ID        string `json:"id"`
+	Href      string `json:"href"`
+	MediaType string `json:"media_type"`
+	Linear    bool   `json:"linear"`
+}
+
+// EPUBTOCItem is a table-of-contents entry; Href may carry a #fragment
+type EPUBTOCItem struct {
+	Title    string        `json:"title"`
+	Href     string        `json:"href,omitempty"`
+	Children []EPUBTOCItem `json:"children,omitempty"`
+}
+
+// EPUBResource is a manifest item
+type EPUBResource struct {
+	ID         string `json:"id"`
+	Href       string `json:"href"`
+	MediaType  string `json:"media_type"`
+	Properties string `json:"properties,omitempty"`
+}
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type opfPackage struct {
+	Version  string `xml:"version,attr"`
+	Metadata struct {
+		Titles       []string `xml:"title"`
+		Creators     []string `xml:"creator"`
+		Languages    []string `xml:"language"`
+		Publishers   []string `xml:"publisher"`
+		Descriptions []string `xml:"description"`
+		Identifiers  []string `xml:"identifier"`
+		Meta         []struct {
+			Name    string `xml:"name,attr"`
+			Content string `xml:"content,attr"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Spine struct {
+		TOC   string `xml:"toc,attr"`
+		Items []struct {
+			IDRef  string `xml:"idref,attr"`
+			Linear string `xml:"linear,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+type ncxNavPoint struct {
+	Label   string        `xml:"navLabel>text"`
+	Content ncxContent    `xml:"content"`
+	Points  []ncxNavPoint `xml:"navPoint"`
+}
+
+type ncxContent struct {
+	Src string `xml:"src,attr"`
+}
+
+type navList struct {
+	Items []navListItem `xml:"li"`
+}
+
+type navListItem struct {
+	Link struct {
+		Href  string `xml:"href,attr"`
+		Inner string `xml:",innerxml"`
+	} `xml:"a"`
+	Span struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"span"`
+	List *navList `xml:"ol"`
+}
+
+// parseEPUB reads container.xml, the package document and the EPUB 3 nav
+// document (or the EPUB 2 NCX when there is no nav)
+func parseEPUB(filePath string) (*EPUBMetadata, error) {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = zr.Close() }()
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	opfPath, err := epubPackagePath(files)
+	if err != nil {
+		return nil, err
+	}
+
+	var opf opfPackage
+	if err := decodeEPUBXML(files, opfPath, &opf); err != nil {
+		return nil, err
+	}
+
+	meta := &EPUBMetadata{
+		Format:      "epub",
+		Version:     opf.Version,
+		Title:       firstNonEmpty(opf.Metadata.Titles),
+		Author:      strings.Join(nonEmpty(opf.Metadata.Creators), ", "),
+		Language:    firstNonEmpty(opf.Metadata.Languages),
+		Publisher:   firstNonEmpty(opf.Metadata.Publishers),
+		Description: firstNonEmpty(opf.Metadata.Descriptions),
+		Identifier:  firstNonEmpty(opf.Metadata.Identifiers),
+		PackagePath: opfPath,
+		Spine:       []EPUBSpineItem{},
+		Resources:   []EPUBResource{},
+	}
+
+	base := path.Dir(opfPath)
+	byID := make(map[string]EPUBResource, len(opf.Manifest))
+	var navHref, coverID string
+	for _, m := range opf.Metadata.Meta {
+		if m.Name == "cover" {
+			coverID = m.Content
+		}
+	}
+	for _, item := range opf.Manifest {
+		res := EPUBResource{
+			ID:         item.ID,
+			Href:       resolveEPUBHref(base, item.Href),
+			MediaType:  item.MediaType,
+			Properties: item.Properties,
+		}
+		meta.Resources = append(meta.Resources, res)
+		byID[item.ID] = res
+
+		props := strings.Fields(item.Properties)
+		if containsString(props, "nav") {
+			navHref = res.Href
+		}
+		if containsString(props, "cover-image") || (meta.Cover == "" && item.ID == coverID) {
+			meta.Cover = res.Href
+		}
+	}
+
+	for _, ref := range opf.Spine.Items {
+		res, ok := byID[ref.IDRef]
+		if !ok {
+			continue
+		}
+		meta.Spine = append(meta.Spine, EPUBSpineItem{
+			ID:        res.ID,
+			Href:      res.Href,
+			MediaType: res.MediaType,
+			Linear:    ref.Linear != "no",
+		})
+	}
+
+	// A broken TOC should not hide the rest of the metadata
+	if navHref != "" {
+		meta.TOC, _ = parseEPUBNav(files, navHref)
+	}
+	if len(meta.TOC) == 0 {
+		if ncx, ok := byID[opf.Spine.TOC]; ok {
+			meta.TOC, _ = parseEPUBNCX(files, ncx.Href)
+		}
+	}
+
+	return meta, nil
+}
+
+// epubPackagePath returns the package document named in container.xml
+func epubPackagePath(files map[string]*zip.File) (string, error) {
+	var container epubContainer
+	if err := decodeEPUBXML(files, epubContainerPath, &container); err != nil {
+		return "", err
+	}
+	for _, rf := range container.Rootfiles {
+		if rf.MediaType == "" || rf.MediaType == "application/oebps-package+xml" {
+			return rf.FullPath, nil
+		}
+	}
+	return "", errors.New("epub: no package document in container.xml")
+}
+
+func parseEPUBNav(files map[string]*zip.File, navPath string) ([]EPUBTOCItem, error) {
+	rc, err := openEPUBEntry(files, navPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rc.Close() }()
+
+	dec := newEPUBDecoder(rc)
+	var fallback []EPUBTOCItem
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return fallback, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "nav" {
+			continue
+		}
+
+		var nav struct {
+			List navList `xml:"ol"`
+		}
+		if err := dec.DecodeElement(&nav, &start); err != nil {
+			return nil, err
+		}
+		items := convertNavList(path.Dir(navPath), &nav.List)
+
+		if navType(start) == "toc" {
+			return items, nil
+		}
+		if fallback == nil {
+			fallback = items
+		}
+	}
+}
+
+func navType(start xml.StartElement) string {
+	for _, a := range start.Attr {
+		if a.Name.Local == "type" && (a.Name.Space == epubNamespaceOPS || a.Name.Space == "epub") {
+			for _, t := range strings.Fields(a.Value) {
+				if t == "toc" {
+					return t
+				}
+			}
+		}
+	}
+	return ""
+}
+
+var xmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+func convertNavList(base string, list *navList) []EPUBTOCItem {
+	if list == nil {
+		return nil
+	}
+	items := make([]EPUBTOCItem, 0, len(list.Items))
+	for _, li := range list.Items {
+		title := li.Link.Inner
+		if title == "" {
+			title = li.Span.Inner
+		}
+		item := EPUBTOCItem{
+			Title:    collapseSpace(xmlUnescape(xmlTagPattern.ReplaceAllString(title, ""))),
+			Children: convertNavList(base, li.List),
+		}
+		if li.Link.Href != "" {
+			item.Href = resolveEPUBHref(base, li.Link.Href)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func parseEPUBNCX(files map[string]*zip.File, ncxPath string) ([]EPUBTOCItem, error) {
+	var ncx struct {
+		Points []ncxNavPoint `xml:"navMap>navPoint"`
+	}
+	if err := decodeEPUBXML(files, ncxPath, &ncx); err != nil {
+		return nil, err
+	}
+	return convertNCX(path.Dir(ncxPath), ncx.Points), nil
+}
+
+func convertNCX(base string, points []ncxNavPoint) []EPUBTOCItem {
+	items := make([]EPUBTOCItem, 0, len(points))
+	for _, p := range points {
+		items = append(items, EPUBTOCItem{
+			Title:    collapseSpace(p.Label),
+			Href:     resolveEPUBHref(base, p.Content.Src),
+			Children: convertNCX(base, p.Points),
+		})
+	}
+	return items
+}
+
+// resolveEPUBHref turns an href relative to base into a full archive path,
+// keeping the #fragment
+func resolveEPUBHref(base, href string) string {
+	if href == "" {
+		return ""
+	}
+	fragment := ""
+	if i := strings.IndexByte(href, '#'); i >= 0 {
+		href, fragment = href[:i], href[i:]
+	}
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	if href == "" {
+		return fragment
+	}
+	return strings.TrimPrefix(path.Join(base, href), "/") + fragment
+}
+
+func openEPUBEntry(files map[string]*zip.File, name string) (io.ReadCloser, error) {
+	f, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("epub: %s not found", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(rc, epubMaxXMLSize), rc}, nil
+}
+
+func decodeEPUBXML(files map[string]*zip.File, name string, v interface{}) error {
+	rc, err := openEPUBEntry(files, name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+	dec := newEPUBDecoder(rc)
+	// Package documents are XML: with HTML auto-closing, the EPUB 3
+	// <meta property="...">value</meta> would end before its value
+	dec.AutoClose = nil
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("epub: %s: %w", name, err)
+	}
+	return nil
+}
+
+// newEPUBDecoder tolerates the HTML entities and sloppy markup common in
+// real-world EPUBs
+func newEPUBDecoder(r io.Reader) *xml.Decoder {
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+	return dec
+}
+
+func xmlUnescape(s string) string {
+	var out strings.Builder
+	dec := newEPUBDecoder(strings.NewReader("<x>" + s + "</x>"))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		if cd, ok := tok.(xml.CharData); ok {
+			out.Write(cd)
+		}
+	}
+	return out.String()
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func firstNonEmpty(values []string) string {
+	for _, v := range values {
+		if v = collapseSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func nonEmpty(values []string) []string {
+	var out []string
+	for _, v := range values {
+		if v = collapseSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package worker
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testContainerXML = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+// writeTestEPUB writes files into a zip with mimetype stored first, as the spec requires
+func writeTestEPUB(t *testing.T, files map[string]string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "book.epub")
+	f, err := os.Create(p)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	zw := zip.NewWriter(f)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	require.NoError(t, err)
+	_, _ = w.Write([]byte("application/epub+zip"))
+	for name, body := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return p
+}
+
+func TestParseEPUB3Nav(t *testing.T) {
+	p := writeTestEPUB(t, map[string]string{
+		"META-INF/container.xml": testContainerXML,
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Мастер и Маргарита</dc:title>
+    <dc:creator>Михаил Булгаков</dc:creator>
+    <dc:language>ru</dc:language>
+    <dc:identifier>urn:isbn:9785170000000</dc:identifier>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="cover" href="images/cover.jpg" media-type="image/jpeg" properties="cover-image"/>
+    <item id="ch1" href="text/ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch2" href="text/ch2.xhtml" media-type="application/xhtml+xml"/>
+    <item id="notes" href="text/notes.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="ch1"/>
+    <itemref idref="ch2"/>
+    <itemref idref="notes" linear="no"/>
+  </spine>
+</package>`,
+		"OEBPS/nav.xhtml": `<?xml version="1.0" encoding="utf-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<body>
+  <nav epub:type="landmarks"><ol><li><a href="text/ch1.xhtml">Начало</a></li></ol></nav>
+  <nav epub:type="toc">
+    <ol>
+      <li><a href="text/ch1.xhtml">Часть <em>первая</em></a>
+        <ol><li><a href="text/ch1.xhtml#s2">Глава&nbsp;2</a></li></ol>
+      </li>
+      <li><span>Часть вторая</span>
+        <ol><li><a href="text/ch2.xhtml">Глава 19</a></li></ol>
+      </li>
+    </ol>
+  </nav>
+</body>
+</html>`,
+		"OEBPS/text/ch1.xhtml": "<html/>",
+		"OEBPS/text/ch2.xhtml": "<html/>",
+	})
+
+	meta, err := parseEPUB(p)
+	require.NoError(t, err)
+
+	assert.Equal(t, "3.0", meta.Version)
+	assert.Equal(t, "Мастер и Маргарита", meta.Title)
+	assert.Equal(t, "Михаил Булгаков", meta.Author)
+	assert.Equal(t, "ru", meta.Language)
+	assert.Equal(t, "OEBPS/content.opf", meta.PackagePath)
+	assert.Equal(t, "OEBPS/images/cover.jpg", meta.Cover)
+	assert.Len(t, meta.Resources, 5)
+
+	require.Len(t, meta.Spine, 3)
+	assert.Equal(t, "OEBPS/text/ch1.xhtml", meta.Spine[0].Href)
+	assert.True(t, meta.Spine[1].Linear)
+	assert.False(t, meta.Spine[2].Linear)
+
+	require.Len(t, meta.TOC, 2)
+	assert.Equal(t, "Часть первая", meta.TOC[0].Title)
+	assert.Equal(t, "OEBPS/text/ch1.xhtml", meta.TOC[0].Href)
+	require.Len(t, meta.TOC[0].Children, 1)
+	assert.Equal(t, "OEBPS/text/ch1.xhtml#s2", meta.TOC[0].Children[0].Href)
+	assert.Equal(t, "Часть вторая", meta.TOC[1].Title)
+	assert.Empty(t, meta.TOC[1].Href)
+	require.Len(t, meta.TOC[1].Children, 1)
+	assert.Equal(t, "OEBPS/text/ch2.xhtml", meta.TOC[1].Children[0].Href)
+
+	fields := meta.bookFields()
+	assert.Equal(t, "Мастер и Маргарита", fields.Title)
+	assert.Equal(t, "Михаил Булгаков", fields.Author)
+}
+
+func TestParseEPUB2NCXFallback(t *testing.T) {
+	p := writeTestEPUB(t, map[string]string{
+		"META-INF/container.xml": testContainerXML,
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:title>Old Book</dc:title>
+    <meta name="cover" content="cover-img"/>
+  </metadata>
+  <manifest>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="cover-img" href="cover.png" media-type="image/png"/>
+    <item id="c1" href="chapter%201.html" media-type="application/xhtml+xml"/>
+    <item id="c2" href="../shared/c2.html" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine toc="ncx">
+    <itemref idref="c1"/>
+    <itemref idref="missing"/>
+    <itemref idref="c2"/>
+  </spine>
+</package>`,
+		"OEBPS/toc.ncx": `<?xml version="1.0"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <navMap>
+    <navPoint id="p1" playOrder="1">
+      <navLabel><text>Chapter One</text></navLabel>
+      <content src="chapter%201.html"/>
+      <navPoint id="p1a" playOrder="2">
+        <navLabel><text>Section</text></navLabel>
+        <content src="chapter%201.html#sec"/>
+      </navPoint>
+    </navPoint>
+    <navPoint id="p2" playOrder="3">
+      <navLabel><text>Chapter Two</text></navLabel>
+      <content src="../shared/c2.html"/>
+    </navPoint>
+  </navMap>
+</ncx>`,
+	})
+
+	meta, err := parseEPUB(p)
+	require.NoError(t, err)
+
+	assert.Equal(t, "2.0", meta.Version)
+	assert.Equal(t, "OEBPS/cover.png", meta.Cover)
+
+	require.Len(t, meta.Spine, 2, "unknown idrefs are skipped")
+	assert.Equal(t, "OEBPS/chapter 1.html", meta.Spine[0].Href)
+	assert.Equal(t, "shared/c2.html", meta.Spine[1].Href)
+
+	require.Len(t, meta.TOC, 2)
+	assert.Equal(t, "Chapter One", meta.TOC[0].Title)
+	require.Len(t, meta.TOC[0].Children, 1)
+	assert.Equal(t, "OEBPS/chapter 1.html#sec", meta.TOC[0].Children[0].Href)
+	assert.Equal(t, "shared/c2.html", meta.TOC[1].Href)
+}
+
+func TestParseEPUBErrors(t *testing.T) {
+	_, err := parseEPUB(writeTestEPUB(t, map[string]string{}))
+	assert.Error(t, err, "missing container.xml")
+
+	_, err = parseEPUB(writeTestEPUB(t, map[string]string{
+		"META-INF/container.xml": testContainerXML,
+	}))
+	assert.Error(t, err, "missing package document")
+
+	p := filepath.Join(t.TempDir(), "broken.epub")
+	require.NoError(t, os.WriteFile(p, []byte("not a zip"), 0o600))
+	_, err = parseEPUB(p)
+	assert.Error(t, err)
+}
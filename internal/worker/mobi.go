@@ -0,0 +1,525 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+const (
+	// maxMOBIBytes bounds the file read into memory for parsing and conversion
+	maxMOBIBytes = 256 << 20
+	// maxMOBITextBytes bounds the uncompressed text; record 0 declaring more
+	// is rejected
+	maxMOBITextBytes = 64 << 20
+	// mobiTextRecordSize is the most uncompressed text one record holds
+	mobiTextRecordSize = 4096
+	// palmDOCMaxExpansion is the worst PalmDOC ratio: two bytes of back
+	// reference produce ten
+	palmDOCMaxExpansion = 5
+	// mobiBytesPerPage estimates pages from the uncompressed text length,
+	// which includes markup
+	mobiBytesPerPage = 2048
+
+	mobiCompressionNone     = 1
+	mobiCompressionPalmDOC  = 2
+	mobiCompressionHuffCDIC = 17480
+
+	mobiEncodingCP1252 = 1252
+	mobiEncodingUTF8   = 65001
+)
+
+// EXTH record types used by the parser
+const (
+	exthAuthor      = 100
+	exthPublisher   = 101
+	exthDescription = 103
+	exthISBN        = 104
+	exthSubject     = 105
+	exthPublished   = 106
+	exthASIN        = 113
+	exthKF8Boundary = 121
+	exthCoverOffset = 201
+	exthTitle       = 503
+	exthLanguage    = 524
+)
+
+var (
+	// ErrNotMOBI is returned for files without a MOBI header
+	ErrNotMOBI = errors.New("not a mobi file")
+	// ErrMOBIEncrypted is returned for DRM-protected books, which are never decrypted
+	ErrMOBIEncrypted = errors.New("mobi file is drm protected")
+	// ErrMOBICompression is returned for HUFF/CDIC and unknown text compression
+	ErrMOBICompression = errors.New("unsupported mobi compression")
+)
+
+// MOBIMetadata is stored in BookFile.Metadata for MOBI and AZW3 files
+type MOBIMetadata struct {
+	Format      string   `json:"format"`
+	Title       string   `json:"title,omitempty"`
+	Author      string   `json:"author,omitempty"`
+	Publisher   string   `json:"publisher,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Language    string   `json:"language,omitempty"`
+	ISBN        string   `json:"isbn,omitempty"`
+	ASIN        string   `json:"asin,omitempty"`
+	Subjects    []string `json:"subjects,omitempty"`
+	PublishedAt string   `json:"published_at,omitempty"`
+	Version     int      `json:"mobi_version"`
+	// KF8 is set for AZW3 files and MOBI/KF8 combination files
+	KF8         bool   `json:"kf8"`
+	Compression string `json:"compression"`
+	Encrypted   bool   `json:"encrypted"`
+	TextLength  int    `json:"text_length"`
+	// EstimatedPages is derived from TextLength: MOBI has no fixed pages
+	EstimatedPages int `json:"estimated_pages,omitempty"`
+}
+
+// mobiBook is a parsed Palm database with a MOBI header in record 0
+type mobiBook struct {
+	data    []byte
+	offsets []int
+	header  *mobiHeader
+}
+
+// mobiHeader holds the PalmDOC and MOBI header fields of record 0
+type mobiHeader struct {
+	compression int
+	textLength  int
+	textRecords int
+	encryption  int
+	encoding    int
+	version     int
+	fullName    []byte
+	firstImage  int
+	fdst        int
+	extraFlags  uint16
+	exth        map[uint32][][]byte
+}
+
+// mobiImage is an image record, numbered from 1 like recindex attributes
+type mobiImage struct {
+	index     int
+	data      []byte
+	ext       string
+	mediaType string
+}
+
+func openMOBI(filePath string) (*mobiBook, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxMOBIBytes {
+		return nil, fmt.Errorf("mobi file is larger than %d bytes", maxMOBIBytes)
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return parseMOBIData(data)
+}
+
+// parseMOBIData reads the PDB record table and the record 0 headers
+func parseMOBIData(data []byte) (*mobiBook, error) {
+	if len(data) < 78 || string(data[60:68]) != "BOOKMOBI" {
+		return nil, ErrNotMOBI
+	}
+	n := int(binary.BigEndian.Uint16(data[76:78]))
+	if n == 0 || len(data) < 78+n*8 {
+		return nil, fmt.Errorf("%w: truncated record table", ErrNotMOBI)
+	}
+
+	offsets := make([]int, n)
+	for i := range offsets {
+		off := int(binary.BigEndian.Uint32(data[78+i*8:]))
+		if off > len(data) || (i > 0 && off < offsets[i-1]) {
+			return nil, fmt.Errorf("%w: bad offset of record %d", ErrNotMOBI, i)
+		}
+		offsets[i] = off
+	}
+
+	b := &mobiBook{data: data, offsets: offsets}
+	header, err := parseMOBIHeader(b.record(0))
+	if err != nil {
+		return nil, err
+	}
+	b.header = header
+	return b, nil
+}
+
+func (b *mobiBook) record(i int) []byte {
+	if i < 0 || i >= len(b.offsets) {
+		return nil
+	}
+	end := len(b.data)
+	if i+1 < len(b.offsets) {
+		end = b.offsets[i+1]
+	}
+	return b.data[b.offsets[i]:end]
+}
+
+func parseMOBIHeader(rec []byte) (*mobiHeader, error) {
+	if len(rec) < 40 || string(rec[16:20]) != "MOBI" {
+		return nil, ErrNotMOBI
+	}
+	be := binary.BigEndian
+	h := &mobiHeader{
+		compression: int(be.Uint16(rec[0:])),
+		textLength:  int(be.Uint32(rec[4:])),
+		textRecords: int(be.Uint16(rec[8:])),
+		encryption:  int(be.Uint16(rec[12:])),
+		firstImage:  -1,
+		fdst:        -1,
+		exth:        map[uint32][][]byte{},
+	}
+	if h.textLength > maxMOBITextBytes {
+		return nil, fmt.Errorf("%w: text length %d exceeds %d bytes", ErrNotMOBI, h.textLength, maxMOBITextBytes)
+	}
+
+	// Offsets are from the start of record 0; the MOBI header starts at 16
+	end := min(16+int(be.Uint32(rec[20:])), len(rec))
+	field := func(off int) (uint32, bool) {
+		if off+4 > end {
+			return 0, false
+		}
+		return be.Uint32(rec[off:]), true
+	}
+
+	if v, ok := field(28); ok {
+		h.encoding = int(v)
+	}
+	if v, ok := field(36); ok {
+		h.version = int(v)
+	}
+	if off, ok := field(84); ok {
+		if n, ok := field(88); ok && int(off)+int(n) <= len(rec) {
+			h.fullName = rec[off : off+n]
+		}
+	}
+	if v, ok := field(108); ok && v != 0xFFFFFFFF {
+		h.firstImage = int(v)
+	}
+	if v, ok := field(0xC0); ok && h.version >= 8 && v != 0xFFFFFFFF {
+		h.fdst = int(v)
+	}
+	if end >= 0xF4 {
+		h.extraFlags = be.Uint16(rec[0xF2:])
+	}
+	if flags, ok := field(128); ok && flags&0x40 != 0 {
+		h.exth = parseEXTH(rec[end:])
+	}
+	return h, nil
+}
+
+// parseEXTH reads the extended header that follows the MOBI header.
+// Truncated records end the scan instead of failing the file.
+func parseEXTH(data []byte) map[uint32][][]byte {
+	exth := map[uint32][][]byte{}
+	if len(data) < 12 || string(data[:4]) != "EXTH" {
+		return exth
+	}
+	count := int(binary.BigEndian.Uint32(data[8:]))
+	pos := 12
+	for i := 0; i < count && pos+8 <= len(data); i++ {
+		typ := binary.BigEndian.Uint32(data[pos:])
+		size := int(binary.BigEndian.Uint32(data[pos+4:]))
+		if size < 8 || pos+size > len(data) {
+			break
+		}
+		exth[typ] = append(exth[typ], data[pos+8:pos+size])
+		pos += size
+	}
+	return exth
+}
+
+// decode converts header or text bytes to UTF-8
+func (h *mobiHeader) decode(data []byte) string {
+	if h.encoding == mobiEncodingCP1252 {
+		if out, err := charmap.Windows1252.NewDecoder().Bytes(data); err == nil {
+			return string(out)
+		}
+	}
+	return strings.ToValidUTF8(string(data), "")
+}
+
+func (h *mobiHeader) exthStrings(typ uint32) []string {
+	var out []string
+	for _, v := range h.exth[typ] {
+		if s := strings.TrimSpace(h.decode(v)); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (h *mobiHeader) exthString(typ uint32) string {
+	return firstNonEmpty(h.exthStrings(typ))
+}
+
+func (h *mobiHeader) exthUint32(typ uint32) (int, bool) {
+	values := h.exth[typ]
+	if len(values) == 0 || len(values[0]) < 4 {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint32(values[0])), true
+}
+
+func (h *mobiHeader) compressionName() string {
+	switch h.compression {
+	case mobiCompressionNone:
+		return "none"
+	case mobiCompressionPalmDOC:
+		return "palmdoc"
+	case mobiCompressionHuffCDIC:
+		return "huffcdic"
+	}
+	return "unknown-" + strconv.Itoa(h.compression)
+}
+
+// isKF8 reports whether record 0 describes KF8 text (AZW3). Combination
+// files start with a MOBI 6 header and only point at the KF8 part.
+func (h *mobiHeader) isKF8() bool {
+	return h.version >= 8
+}
+
+func (b *mobiBook) metadata() *MOBIMetadata {
+	h := b.header
+	_, combined := h.exthUint32(exthKF8Boundary)
+	m := &MOBIMetadata{
+		Format:      "mobi",
+		Title:       h.exthString(exthTitle),
+		Author:      strings.Join(h.exthStrings(exthAuthor), ", "),
+		Publisher:   h.exthString(exthPublisher),
+		Description: h.exthString(exthDescription),
+		Language:    h.exthString(exthLanguage),
+		ISBN:        h.exthString(exthISBN),
+		ASIN:        h.exthString(exthASIN),
+		Subjects:    h.exthStrings(exthSubject),
+		PublishedAt: h.exthString(exthPublished),
+		Version:     h.version,
+		KF8:         h.isKF8() || combined,
+		Compression: h.compressionName(),
+		Encrypted:   h.encryption != 0,
+		TextLength:  h.textLength,
+	}
+	if m.Title == "" {
+		m.Title = strings.TrimSpace(h.decode(h.fullName))
+	}
+	if h.textLength > 0 {
+		m.EstimatedPages = (h.textLength + mobiBytesPerPage - 1) / mobiBytesPerPage
+	}
+	return m
+}
+
+// bookFields leaves PageCount empty: the estimate is not a real page count
+func (m *MOBIMetadata) bookFields() *bookFields {
+	f := &bookFields{
+		Title:       m.Title,
+		Author:      m.Author,
+		Description: m.Description,
+		Language:    m.Language,
+		Publisher:   m.Publisher,
+	}
+	if len(m.PublishedAt) >= 4 {
+		if year, err := strconv.Atoi(m.PublishedAt[:4]); err == nil && year > 0 {
+			f.Year = year
+		}
+	}
+	return f
+}
+
+// convertible reports why the text cannot be extracted, if it cannot
+func (b *mobiBook) convertible() error {
+	h := b.header
+	if h.encryption != 0 {
+		return ErrMOBIEncrypted
+	}
+	if h.compression != mobiCompressionNone && h.compression != mobiCompressionPalmDOC {
+		return fmt.Errorf("%w: %s", ErrMOBICompression, h.compressionName())
+	}
+	return nil
+}
+
+// rawText decompresses the text records. The result is still in the book's
+// encoding, since filepos links are byte offsets into it. progress, if set,
+// is called after every record.
+func (b *mobiBook) rawText(progress func(done, total int)) ([]byte, error) {
+	if err := b.convertible(); err != nil {
+		return nil, err
+	}
+	h := b.header
+	// The header is untrusted: size the buffer by what the records can hold
+	out := make([]byte, 0, min(h.textLength, h.textRecords*mobiTextRecordSize, len(b.data)*palmDOCMaxExpansion))
+	for i := 1; i <= h.textRecords; i++ {
+		rec := b.record(i)
+		if rec == nil {
+			return nil, fmt.Errorf("text record %d is missing", i)
+		}
+		rec = rec[:len(rec)-trailingEntriesSize(rec, h.extraFlags)]
+
+		var err error
+		if h.compression == mobiCompressionPalmDOC {
+			out, err = palmDOCDecompress(out, rec)
+			if err != nil {
+				return nil, fmt.Errorf("text record %d: %w", i, err)
+			}
+		} else {
+			out = append(out, rec...)
+		}
+		if len(out) > maxMOBITextBytes {
+			return nil, fmt.Errorf("text exceeds %d bytes", maxMOBITextBytes)
+		}
+		if progress != nil {
+			progress(i, h.textRecords)
+		}
+	}
+	if h.textLength > 0 && len(out) > h.textLength {
+		out = out[:h.textLength]
+	}
+
+	// KF8 stores CSS and SVG flows after the text; FDST marks where the text ends
+	if h.isKF8() {
+		if fdst := b.record(h.fdst); len(fdst) >= 20 && string(fdst[:4]) == "FDST" {
+			if end := int(binary.BigEndian.Uint32(fdst[16:])); end > 0 && end < len(out) {
+				out = out[:end]
+			}
+		}
+	}
+	return out, nil
+}
+
+// trailingEntriesSize returns the size of the extra data appended to a text
+// record. Bits 1-15 of flags announce entries that end with a backward
+// varint size; bit 0 announces multibyte character overlap, stored last.
+func trailingEntriesSize(rec []byte, flags uint16) int {
+	size := 0
+	for rest := flags >> 1; rest != 0; rest >>= 1 {
+		if rest&1 != 0 {
+			size += backwardVarint(rec[:len(rec)-size])
+		}
+		if size >= len(rec) {
+			return len(rec)
+		}
+	}
+	if flags&1 != 0 && size < len(rec) {
+		size += int(rec[len(rec)-size-1]&3) + 1
+	}
+	return min(size, len(rec))
+}
+
+// backwardVarint decodes the entry size stored at the end of data: seven
+// bits per byte, most significant first, the first byte has the high bit set
+func backwardVarint(data []byte) int {
+	value, shift := 0, 0
+	for i := len(data) - 1; i >= 0 && shift < 28; i-- {
+		b := data[i]
+		value |= int(b&0x7F) << shift
+		shift += 7
+		if b&0x80 != 0 {
+			break
+		}
+	}
+	return value
+}
+
+// palmDOCDecompress appends the decompressed record to dst. Back
+// references never reach into earlier records.
+func palmDOCDecompress(dst, src []byte) ([]byte, error) {
+	start := len(dst)
+	for i := 0; i < len(src); {
+		c := src[i]
+		i++
+		switch {
+		case c >= 0x01 && c <= 0x08:
+			n := int(c)
+			if i+n > len(src) {
+				return nil, errors.New("palmdoc literal runs past the record")
+			}
+			dst = append(dst, src[i:i+n]...)
+			i += n
+		case c < 0x80:
+			dst = append(dst, c)
+		case c >= 0xC0:
+			dst = append(dst, ' ', c^0x80)
+		default:
+			if i >= len(src) {
+				return nil, errors.New("palmdoc back reference runs past the record")
+			}
+			v := (int(c)<<8 | int(src[i])) & 0x3FFF
+			i++
+			dist, length := v>>3, v&7+3
+			if dist == 0 || dist > len(dst)-start {
+				return nil, fmt.Errorf("palmdoc back reference %d out of range", dist)
+			}
+			// Byte by byte: the copy may overlap the bytes it produces
+			from := len(dst) - dist
+			for j := 0; j < length; j++ {
+				dst = append(dst, dst[from+j])
+			}
+		}
+	}
+	return dst, nil
+}
+
+// images returns the image records that follow firstImage, keyed by
+// their 1-based index. Fonts, RESC and other resources are skipped.
+func (b *mobiBook) images() map[int]mobiImage {
+	images := map[int]mobiImage{}
+	if b.header.firstImage <= 0 {
+		return images
+	}
+	for i := b.header.firstImage; i < len(b.offsets); i++ {
+		rec := b.record(i)
+		if bytes.HasPrefix(rec, []byte("BOUNDARY")) {
+			break // the KF8 part of a combination file has its own images
+		}
+		ext, mediaType := imageType(rec)
+		if ext == "" {
+			continue
+		}
+		index := i - b.header.firstImage + 1
+		images[index] = mobiImage{index: index, data: rec, ext: ext, mediaType: mediaType}
+	}
+	return images
+}
+
+// coverIndex returns the image index of the EXTH cover, or 0
+func (b *mobiBook) coverIndex() int {
+	if off, ok := b.header.exthUint32(exthCoverOffset); ok && off != 0xFFFFFFFF {
+		return off + 1
+	}
+	return 0
+}
+
+// cover returns the cover image bytes
+func (b *mobiBook) cover() ([]byte, error) {
+	index := b.coverIndex()
+	if index == 0 {
+		return nil, errors.New("mobi file has no cover record")
+	}
+	img, ok := b.images()[index]
+	if !ok {
+		return nil, fmt.Errorf("cover record %d is not an image", index)
+	}
+	return img.data, nil
+}
+
+// imageType recognises the formats EPUB readers display
+func imageType(data []byte) (ext, mediaType string) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return ".jpg", "image/jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return ".png", "image/png"
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return ".gif", "image/gif"
+	}
+	return "", ""
+}
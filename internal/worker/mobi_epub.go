@@ -0,0 +1,697 @@
+package worker
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// mobiTOCLabelRunes caps TOC labels taken from chapter text
+const mobiTOCLabelRunes = 80
+
+var (
+	fileposRe   = regexp.MustCompile(`(?i)filepos\s*=\s*["']?0*([0-9]+)`)
+	pagebreakRe = regexp.MustCompile(`(?i)<mbp:pagebreak[^>]*>`)
+	kf8DocRe    = regexp.MustCompile(`(?i)<html[\s>]`)
+	xmlNameRe   = regexp.MustCompile(`^[a-zA-Z_][-a-zA-Z0-9_.]*$`)
+)
+
+// droppedElements are removed from converted chapters with their content:
+// MOBI guides, scripts and head-only elements the HTML parser moved into body
+var droppedElements = map[string]bool{
+	"guide": true, "script": true, "style": true, "link": true, "meta": true, "title": true,
+	"noscript": true, "iframe": true, "object": true, "embed": true,
+}
+
+// droppedAttributes are MOBI layout hints with no XHTML meaning
+var droppedAttributes = map[string]bool{
+	"filepos": true, "recindex": true, "hirecindex": true, "lowrecindex": true, "aid": true,
+}
+
+// MOBIConversion describes an EPUB produced by convertMOBIToEPUB
+type MOBIConversion struct {
+	Chapters int
+	Images   int
+	// TextChars counts the characters of chapter text, markup excluded
+	TextChars int
+}
+
+// mobiChapter is a converted spine document
+type mobiChapter struct {
+	href  string
+	title string
+	body  *html.Node
+	chars int
+}
+
+// mobiLink is an <a filepos> whose target chapter is known only after
+// every chapter is parsed
+type mobiLink struct {
+	node *html.Node
+	pos  int
+}
+
+// convertMOBIToEPUB writes an EPUB 3 with the book's text, images, cover
+// and a table of contents built from chapter headings. MOBI 6 text is split
+// at page breaks and filepos links become anchors. For AZW3 each skeleton
+// becomes a chapter with its fragments appended in stored order, which keeps
+// the reading order but loses KF8 styles. progress receives 0..100.
+func convertMOBIToEPUB(b *mobiBook, identifier string, w io.Writer, progress func(percent int)) (*MOBIConversion, error) {
+	report := func(percent int) {
+		if progress != nil {
+			progress(percent)
+		}
+	}
+
+	raw, err := b.rawText(func(done, total int) {
+		report(done * 60 / total)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var pieces [][]byte
+	if b.header.isKF8() {
+		pieces = splitAt(raw, kf8DocRe, true)
+	} else {
+		pieces = splitAt(insertFileposAnchors(raw), pagebreakRe, false)
+	}
+
+	images := b.images()
+	usedImages := map[int]bool{}
+	anchors := map[string]int{}
+	var links []mobiLink
+	var chapters []*mobiChapter
+	var pending []*html.Node
+	var pendingIDs []string
+
+	for _, piece := range pieces {
+		doc, err := html.Parse(strings.NewReader(b.header.decode(piece)))
+		if err != nil {
+			continue
+		}
+		body := findElement(doc, "body")
+		if body == nil {
+			continue
+		}
+		c := &mobiCleaner{images: images, used: usedImages}
+		c.clean(body)
+
+		// Pieces without text hold only anchors and page furniture: keep
+		// them with the next chapter so links into them still resolve
+		if !hasContent(body) {
+			pending = append(pending, detachChildren(body)...)
+			pendingIDs = append(pendingIDs, c.ids...)
+			links = append(links, c.links...)
+			continue
+		}
+		prependChildren(body, pending)
+		c.ids = append(pendingIDs, c.ids...)
+		pending, pendingIDs = nil, nil
+
+		ch := &mobiChapter{
+			href: fmt.Sprintf("chapter-%04d.xhtml", len(chapters)+1),
+			body: body,
+		}
+		ch.title, ch.chars = chapterTitle(body), len([]rune(nodeText(body)))
+		for _, id := range c.ids {
+			anchors[id] = len(chapters)
+		}
+		links = append(links, c.links...)
+		chapters = append(chapters, ch)
+	}
+	if len(chapters) == 0 {
+		return nil, errors.New("mobi text has no readable content")
+	}
+	if len(pending) > 0 {
+		last := chapters[len(chapters)-1].body
+		for _, n := range pending {
+			last.AppendChild(n)
+		}
+		for _, id := range pendingIDs {
+			anchors[id] = len(chapters) - 1
+		}
+	}
+	report(75)
+
+	for _, l := range links {
+		id := fmt.Sprintf("filepos%d", l.pos)
+		if i, ok := anchors[id]; ok {
+			setAttr(l.node, "href", chapters[i].href+"#"+id)
+		} else {
+			removeAttr(l.node, "href")
+		}
+	}
+
+	meta := b.metadata()
+	pkg := &epubPackage{
+		Identifier:  identifier,
+		Title:       meta.Title,
+		Author:      meta.Author,
+		Publisher:   meta.Publisher,
+		Description: meta.Description,
+		Language:    meta.Language,
+		Modified:    time.Now().UTC(),
+	}
+	if meta.ISBN != "" {
+		pkg.Identifier = "urn:isbn:" + meta.ISBN
+	}
+	if pkg.Title == "" {
+		pkg.Title = "Untitled"
+	}
+	if pkg.Language == "" {
+		pkg.Language = "und"
+	}
+
+	cover := b.coverIndex()
+	indexes := make([]int, 0, len(images))
+	for index := range images {
+		if usedImages[index] || index == cover {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		img := images[index]
+		pkg.Images = append(pkg.Images, epubImage{
+			ID:        fmt.Sprintf("image-%05d", index),
+			Href:      mobiImageHref(img),
+			MediaType: img.mediaType,
+			Data:      img.data,
+			Cover:     index == cover,
+		})
+	}
+
+	stats := &MOBIConversion{Chapters: len(chapters), Images: len(pkg.Images)}
+	for i, ch := range chapters {
+		var buf bytes.Buffer
+		for n := ch.body.FirstChild; n != nil; n = n.NextSibling {
+			if err := html.Render(&buf, n); err != nil {
+				return nil, fmt.Errorf("render chapter %d: %w", i+1, err)
+			}
+		}
+		title := ch.title
+		if title == "" {
+			title = strconv.Itoa(i + 1)
+		}
+		pkg.Chapters = append(pkg.Chapters, epubChapter{Href: ch.href, Title: title, Body: buf.Bytes()})
+		stats.TextChars += ch.chars
+	}
+	report(90)
+
+	if err := pkg.write(w); err != nil {
+		return nil, err
+	}
+	report(100)
+	return stats, nil
+}
+
+// insertFileposAnchors puts <a id="fileposN"> at every offset a filepos
+// link points to. Offsets inside a tag move to the tag start, offsets at a
+// page break move past it so the anchor opens the next chapter.
+func insertFileposAnchors(raw []byte) []byte {
+	targets := map[int]bool{}
+	for _, m := range fileposRe.FindAllSubmatch(raw, -1) {
+		if pos, err := strconv.Atoi(string(m[1])); err == nil && pos < len(raw) {
+			targets[pos] = true
+		}
+	}
+	if len(targets) == 0 {
+		return raw
+	}
+	positions := make([]int, 0, len(targets))
+	for pos := range targets {
+		positions = append(positions, pos)
+	}
+	sort.Ints(positions)
+
+	var out bytes.Buffer
+	out.Grow(len(raw) + len(positions)*24)
+	last := 0
+	for _, pos := range positions {
+		at := pos
+		if lt := bytes.LastIndexByte(raw[:pos], '<'); lt >= 0 && lt > bytes.LastIndexByte(raw[:pos], '>') {
+			at = lt
+		}
+		if loc := pagebreakRe.FindIndex(raw[at:min(at+64, len(raw))]); loc != nil && loc[0] == 0 {
+			at += loc[1]
+		}
+		if at < last {
+			at = last
+		}
+		out.Write(raw[last:at])
+		fmt.Fprintf(&out, `<a id="filepos%d"></a>`, pos)
+		last = at
+	}
+	out.Write(raw[last:])
+	return out.Bytes()
+}
+
+// splitAt cuts data at every match of re. The match starts the next piece
+// when keepMatch is set and is dropped otherwise.
+func splitAt(data []byte, re *regexp.Regexp, keepMatch bool) [][]byte {
+	var pieces [][]byte
+	last := 0
+	for _, loc := range re.FindAllIndex(data, -1) {
+		if loc[0] > last {
+			pieces = append(pieces, data[last:loc[0]])
+		}
+		last = loc[1]
+		if keepMatch {
+			last = loc[0]
+		}
+	}
+	if last < len(data) {
+		pieces = append(pieces, data[last:])
+	}
+	return pieces
+}
+
+// mobiCleaner turns parsed MOBI markup into XHTML-safe nodes
+type mobiCleaner struct {
+	images map[int]mobiImage
+	used   map[int]bool
+	ids    []string
+	links  []mobiLink
+}
+
+func (c *mobiCleaner) clean(parent *html.Node) {
+	for n := parent.FirstChild; n != nil; {
+		next := n.NextSibling
+		switch n.Type {
+		case html.CommentNode, html.DoctypeNode:
+			parent.RemoveChild(n)
+		case html.TextNode:
+			n.Data = xmlSafe(n.Data)
+		case html.ElementNode:
+			c.cleanElement(parent, n)
+		}
+		n = next
+	}
+}
+
+func (c *mobiCleaner) cleanElement(parent, n *html.Node) {
+	if droppedElements[n.Data] {
+		parent.RemoveChild(n)
+		return
+	}
+	c.clean(n)
+
+	// Namespaced MOBI tags (mbp:nu, mbp:section) and font only wrap content
+	if strings.Contains(n.Data, ":") || n.Data == "font" {
+		for _, child := range detachChildren(n) {
+			parent.InsertBefore(child, n)
+		}
+		parent.RemoveChild(n)
+		return
+	}
+	if n.Data == "center" {
+		n.Data = "div"
+		n.Attr = append(n.Attr, html.Attribute{Key: "style", Val: "text-align: center"})
+	}
+
+	filepos, recindex, src := "", "", ""
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		switch {
+		case a.Key == "filepos":
+			filepos = a.Val
+		case a.Key == "recindex":
+			recindex = a.Val
+		case a.Key == "src":
+			src = a.Val
+		}
+		if a.Namespace != "" || droppedAttributes[a.Key] || !xmlNameRe.MatchString(a.Key) {
+			continue
+		}
+		// Paragraph width/height are MOBI indent hints, not sizes
+		if n.Data != "img" && (a.Key == "width" || a.Key == "height") {
+			continue
+		}
+		a.Val = xmlSafe(a.Val)
+		attrs = append(attrs, a)
+		if a.Key == "id" {
+			c.ids = append(c.ids, a.Val)
+		}
+	}
+	n.Attr = attrs
+
+	switch n.Data {
+	case "a":
+		if filepos != "" {
+			pos, _ := strconv.Atoi(filepos)
+			c.links = append(c.links, mobiLink{node: n, pos: pos})
+		} else if href := getAttr(n, "href"); strings.HasPrefix(href, "kindle:") {
+			removeAttr(n, "href") // KF8 positions need the FRAG index
+		}
+	case "img":
+		index := 0
+		if recindex != "" {
+			index, _ = strconv.Atoi(recindex)
+		} else if strings.HasPrefix(src, "kindle:embed:") {
+			index = kindleEmbedIndex(src)
+		}
+		img, ok := c.images[index]
+		if !ok {
+			if recindex != "" || strings.HasPrefix(src, "kindle:") {
+				parent.RemoveChild(n)
+			}
+			return
+		}
+		c.used[index] = true
+		setAttr(n, "src", mobiImageHref(img))
+		if getAttr(n, "alt") == "" {
+			setAttr(n, "alt", "")
+		}
+	}
+}
+
+// kindleEmbedIndex decodes kindle:embed:XXXX, a base-32 image number
+func kindleEmbedIndex(src string) int {
+	ref := strings.TrimPrefix(src, "kindle:embed:")
+	if i := strings.IndexByte(ref, '?'); i >= 0 {
+		ref = ref[:i]
+	}
+	index, err := strconv.ParseInt(ref, 32, 32)
+	if err != nil {
+		return 0
+	}
+	return int(index)
+}
+
+func mobiImageHref(img mobiImage) string {
+	return fmt.Sprintf("images/image-%05d%s", img.index, img.ext)
+}
+
+// hasContent reports whether n holds visible text or an image
+func hasContent(n *html.Node) bool {
+	if n.Type == html.TextNode && strings.TrimSpace(n.Data) != "" {
+		return true
+	}
+	if n.Type == html.ElementNode && n.Data == "img" {
+		return true
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if hasContent(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// chapterTitle uses the first heading, or else the first line of text
+func chapterTitle(body *html.Node) string {
+	for _, tag := range []string{"h1", "h2", "h3"} {
+		if h := findElement(body, tag); h != nil {
+			if title := truncateRunes(collapseSpace(nodeText(h)), mobiTOCLabelRunes); title != "" {
+				return title
+			}
+		}
+	}
+	for _, line := range strings.Split(nodeText(body), "\n") {
+		if line = collapseSpace(line); line != "" {
+			return truncateRunes(line, mobiTOCLabelRunes)
+		}
+	}
+	return ""
+}
+
+func nodeText(n *html.Node) string {
+	var out strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			out.WriteString(n.Data)
+		case n.Type == html.ElementNode && blockElements[n.Data]:
+			out.WriteByte('\n')
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return out.String()
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+func findElement(n *html.Node, tag string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == tag {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func detachChildren(n *html.Node) []*html.Node {
+	var children []*html.Node
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		n.RemoveChild(c)
+		children = append(children, c)
+		c = next
+	}
+	return children
+}
+
+func prependChildren(n *html.Node, children []*html.Node) {
+	first := n.FirstChild
+	for _, c := range children {
+		if first == nil {
+			n.AppendChild(c)
+		} else {
+			n.InsertBefore(c, first)
+		}
+	}
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func removeAttr(n *html.Node, key string) {
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		if a.Key != key {
+			attrs = append(attrs, a)
+		}
+	}
+	n.Attr = attrs
+}
+
+// xmlSafe drops characters XML 1.0 does not allow
+func xmlSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || (r >= 0x20 && r <= 0xD7FF) ||
+			(r >= 0xE000 && r <= 0xFFFD) || r >= 0x10000 {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// epubPackage is an EPUB 3 written by convertMOBIToEPUB
+type epubPackage struct {
+	Identifier  string
+	Title       string
+	Author      string
+	Publisher   string
+	Description string
+	Language    string
+	Modified    time.Time
+	Chapters    []epubChapter
+	Images      []epubImage
+}
+
+type epubChapter struct {
+	Href  string
+	Title string
+	// Body is rendered XHTML placed inside <body>
+	Body []byte
+}
+
+type epubImage struct {
+	ID        string
+	Href      string
+	MediaType string
+	Data      []byte
+	Cover     bool
+}
+
+const epubContainerXML = `<?xml version="1.0" encoding="utf-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+func (p *epubPackage) write(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	// mimetype must come first and stay uncompressed
+	mt, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mt, "application/epub+zip"); err != nil {
+		return err
+	}
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"META-INF/container.xml", []byte(epubContainerXML)},
+		{"OEBPS/content.opf", p.opf()},
+		{"OEBPS/nav.xhtml", p.nav()},
+		{"OEBPS/toc.ncx", p.ncx()},
+	}
+	for _, ch := range p.Chapters {
+		files = append(files, struct {
+			name string
+			data []byte
+		}{"OEBPS/" + ch.Href, p.xhtml(ch.Title, ch.Body)})
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	// Images are already compressed
+	for _, img := range p.Images {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: "OEBPS/" + img.Href, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(img.Data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (p *epubPackage) opf() []byte {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">` + "\n")
+	b.WriteString(`  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">` + "\n")
+	fmt.Fprintf(&b, "    <dc:identifier id=\"book-id\">%s</dc:identifier>\n", xmlText(p.Identifier))
+	fmt.Fprintf(&b, "    <dc:title>%s</dc:title>\n", xmlText(p.Title))
+	fmt.Fprintf(&b, "    <dc:language>%s</dc:language>\n", xmlText(p.Language))
+	if p.Author != "" {
+		fmt.Fprintf(&b, "    <dc:creator>%s</dc:creator>\n", xmlText(p.Author))
+	}
+	if p.Publisher != "" {
+		fmt.Fprintf(&b, "    <dc:publisher>%s</dc:publisher>\n", xmlText(p.Publisher))
+	}
+	if p.Description != "" {
+		fmt.Fprintf(&b, "    <dc:description>%s</dc:description>\n", xmlText(p.Description))
+	}
+	fmt.Fprintf(&b, "    <meta property=\"dcterms:modified\">%s</meta>\n", p.Modified.Format("2006-01-02T15:04:05Z"))
+	for _, img := range p.Images {
+		if img.Cover {
+			// EPUB 2 readers look for the cover here
+			fmt.Fprintf(&b, "    <meta name=\"cover\" content=\"%s\"/>\n", img.ID)
+		}
+	}
+	b.WriteString("  </metadata>\n  <manifest>\n")
+	b.WriteString(`    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>` + "\n")
+	b.WriteString(`    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>` + "\n")
+	for i, ch := range p.Chapters {
+		fmt.Fprintf(&b, "    <item id=\"chapter-%04d\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n", i+1, ch.Href)
+	}
+	for _, img := range p.Images {
+		props := ""
+		if img.Cover {
+			props = ` properties="cover-image"`
+		}
+		fmt.Fprintf(&b, "    <item id=\"%s\" href=\"%s\" media-type=\"%s\"%s/>\n", img.ID, img.Href, img.MediaType, props)
+	}
+	b.WriteString("  </manifest>\n  <spine toc=\"ncx\">\n")
+	for i := range p.Chapters {
+		fmt.Fprintf(&b, "    <itemref idref=\"chapter-%04d\"/>\n", i+1)
+	}
+	b.WriteString("  </spine>\n</package>\n")
+	return b.Bytes()
+}
+
+func (p *epubPackage) nav() []byte {
+	var list bytes.Buffer
+	list.WriteString(`<nav epub:type="toc" id="toc"><ol>`)
+	for _, ch := range p.Chapters {
+		fmt.Fprintf(&list, "\n<li><a href=\"%s\">%s</a></li>", ch.Href, xmlText(ch.Title))
+	}
+	list.WriteString("\n</ol></nav>")
+	return p.xhtml(p.Title, list.Bytes())
+}
+
+func (p *epubPackage) ncx() []byte {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString(`<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">` + "\n")
+	fmt.Fprintf(&b, "  <head><meta name=\"dtb:uid\" content=\"%s\"/></head>\n", xmlText(p.Identifier))
+	fmt.Fprintf(&b, "  <docTitle><text>%s</text></docTitle>\n  <navMap>\n", xmlText(p.Title))
+	for i, ch := range p.Chapters {
+		fmt.Fprintf(&b, "    <navPoint id=\"np-%d\" playOrder=\"%d\"><navLabel><text>%s</text></navLabel><content src=\"%s\"/></navPoint>\n",
+			i+1, i+1, xmlText(ch.Title), ch.Href)
+	}
+	b.WriteString("  </navMap>\n</ncx>\n")
+	return b.Bytes()
+}
+
+func (p *epubPackage) xhtml(title string, body []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n<!DOCTYPE html>\n")
+	fmt.Fprintf(&b, "<html xmlns=\"http://www.w3.org/1999/xhtml\" xmlns:epub=\"http://www.idpf.org/2007/ops\" lang=\"%s\" xml:lang=\"%s\">\n",
+		xmlText(p.Language), xmlText(p.Language))
+	fmt.Fprintf(&b, "<head><meta charset=\"utf-8\"/><title>%s</title></head>\n<body>\n", xmlText(title))
+	b.Write(body)
+	b.WriteString("\n</body>\n</html>\n")
+	return b.Bytes()
+}
+
+func xmlText(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(xmlSafe(s)))
+	return b.String()
+}
@@ -0,0 +1,396 @@
+package worker
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oneErrortime/afst/internal/events"
+	"github.com/oneErrortime/afst/internal/models"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/oneErrortime/afst/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testMOBI struct {
+	text       string
+	records    int // text records the text is split into
+	encryption uint16
+	exth       map[uint32][]string
+	coverIndex int // EXTH 201 offset + 1, 0 for none
+	fullName   string
+}
+
+// testMOBIText has a contents page linking to the page break before the
+// second chapter, an inline image and a namespaced MOBI tag
+func testMOBIText() string {
+	const placeholder = "##########"
+	text := `<html><head><guide><reference type="toc" title="Contents" filepos=0000000000 /></guide></head><body>` +
+		`<p>Contents: <a filepos=` + placeholder + `>Chapter Two</a></p><mbp:pagebreak/>` +
+		`<h1>Chapter One</h1><p width="0">First text, café &amp; more.</p><p><img recindex="00001" /></p><mbp:pagebreak/>` +
+		`<h2>Chapter Two</h2><p>Second <mbp:nu>text</mbp:nu>.</p></body></html>`
+	// The offset is zero-padded to the placeholder width, so it stays valid
+	target := strings.LastIndex(text, "<mbp:pagebreak/>")
+	return strings.Replace(text, placeholder, fmt.Sprintf("%010d", target), 1)
+}
+
+// palmDOCLiterals encodes data with literal bytes only
+func palmDOCLiterals(data []byte) []byte {
+	var out []byte
+	for _, b := range data {
+		if b == 0 || (b >= 0x09 && b < 0x80) {
+			out = append(out, b)
+		} else {
+			out = append(out, 0x01, b)
+		}
+	}
+	return out
+}
+
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 12)), nil))
+	return buf.Bytes()
+}
+
+// buildTestMOBI assembles a MOBI 6 file with PalmDOC compression and
+// trailing entries (multibyte flag plus one sized entry) on every record
+func buildTestMOBI(t *testing.T, m testMOBI, img []byte) []byte {
+	t.Helper()
+	be := binary.BigEndian
+	text := []byte(m.text)
+	if m.records == 0 {
+		m.records = 1
+	}
+
+	var records [][]byte
+	step := (len(text) + m.records - 1) / m.records
+	for start := 0; start < len(text); start += step {
+		rec := palmDOCLiterals(text[start:min(start+step, len(text))])
+		rec = append(rec, 0x00)           // multibyte overlap: none
+		rec = append(rec, 'X', 'Y', 0x83) // sized entry of 3 bytes
+		records = append(records, rec)
+	}
+	firstImage := len(records) + 1
+	records = append(records, img, []byte("FLIS\x00\x00\x00\x08"))
+
+	var exth bytes.Buffer
+	count := 0
+	for typ, values := range m.exth {
+		for _, v := range values {
+			_ = binary.Write(&exth, be, typ)
+			_ = binary.Write(&exth, be, uint32(8+len(v)))
+			exth.WriteString(v)
+			count++
+		}
+	}
+	if m.coverIndex > 0 {
+		_ = binary.Write(&exth, be, uint32(exthCoverOffset))
+		_ = binary.Write(&exth, be, uint32(12))
+		_ = binary.Write(&exth, be, uint32(m.coverIndex-1))
+		count++
+	}
+
+	const headerLen = 0xE8
+	rec0 := make([]byte, 16+headerLen)
+	be.PutUint16(rec0[0:], mobiCompressionPalmDOC)
+	be.PutUint32(rec0[4:], uint32(len(text)))
+	be.PutUint16(rec0[8:], uint16(len(records)-2))
+	be.PutUint16(rec0[10:], 4096)
+	be.PutUint16(rec0[12:], m.encryption)
+	copy(rec0[16:], "MOBI")
+	be.PutUint32(rec0[20:], headerLen)
+	be.PutUint32(rec0[24:], 2)
+	be.PutUint32(rec0[28:], mobiEncodingUTF8)
+	be.PutUint32(rec0[36:], 6)
+	be.PutUint32(rec0[108:], uint32(firstImage))
+	be.PutUint32(rec0[128:], 0x40)
+	be.PutUint32(rec0[0xC0:], 0xFFFFFFFF)
+	be.PutUint16(rec0[0xF2:], 0x3)
+
+	rec0 = append(rec0, "EXTH"...)
+	rec0 = be.AppendUint32(rec0, uint32(12+exth.Len()))
+	rec0 = be.AppendUint32(rec0, uint32(count))
+	rec0 = append(rec0, exth.Bytes()...)
+	be.PutUint32(rec0[84:], uint32(len(rec0)))
+	be.PutUint32(rec0[88:], uint32(len(m.fullName)))
+	rec0 = append(rec0, m.fullName...)
+	rec0 = append(rec0, 0, 0, 0, 0)
+	records = append([][]byte{rec0}, records...)
+
+	header := make([]byte, 78)
+	copy(header, "test-book")
+	copy(header[60:], "BOOKMOBI")
+	be.PutUint16(header[76:], uint16(len(records)))
+	offset := 78 + len(records)*8 + 2
+	for _, rec := range records {
+		header = be.AppendUint32(header, uint32(offset))
+		header = be.AppendUint32(header, 0)
+		offset += len(rec)
+	}
+	header = append(header, 0, 0)
+	for _, rec := range records {
+		header = append(header, rec...)
+	}
+	return header
+}
+
+func defaultTestMOBI() testMOBI {
+	return testMOBI{
+		text:    testMOBIText(),
+		records: 3,
+		exth: map[uint32][]string{
+			exthTitle:       {"Тестовая книга"},
+			exthAuthor:      {"Анна Автор", "Борис Соавтор"},
+			exthPublisher:   {"Вестник"},
+			exthLanguage:    {"ru"},
+			exthPublished:   {"2019-05-01T00:00:00+00:00"},
+			exthISBN:        {"9780000000002"},
+			exthDescription: {"Описание"},
+		},
+		coverIndex: 1,
+		fullName:   "Full Name",
+	}
+}
+
+func TestPalmDOCDecompress(t *testing.T) {
+	// "abc", then copy 6 bytes from 3 back, then space + 'a', then a literal run
+	src := []byte{'a', 'b', 'c', 0x80, 0x1B, 0xE1, 0x02, 0xC3, 0xA9}
+	out, err := palmDOCDecompress([]byte("prev"), src)
+	require.NoError(t, err)
+	assert.Equal(t, "prevabcabcabc aé", string(out))
+
+	_, err = palmDOCDecompress(nil, []byte{'a', 0x80, 0x1B})
+	assert.Error(t, err, "back references cannot reach before the record")
+}
+
+func TestTrailingEntriesSize(t *testing.T) {
+	rec := []byte{'t', 'e', 'x', 't', 'm', 0x01, 'X', 'Y', 0x83}
+	assert.Equal(t, 5, trailingEntriesSize(rec, 0x3))
+	assert.Equal(t, 3, trailingEntriesSize(rec, 0x2))
+	assert.Equal(t, 0, trailingEntriesSize(rec, 0))
+}
+
+func TestParseMOBI_Metadata(t *testing.T) {
+	b, err := parseMOBIData(buildTestMOBI(t, defaultTestMOBI(), testJPEG(t)))
+	require.NoError(t, err)
+
+	meta := b.metadata()
+	assert.Equal(t, "mobi", meta.Format)
+	assert.Equal(t, "Тестовая книга", meta.Title)
+	assert.Equal(t, "Анна Автор, Борис Соавтор", meta.Author)
+	assert.Equal(t, "Вестник", meta.Publisher)
+	assert.Equal(t, "ru", meta.Language)
+	assert.Equal(t, "9780000000002", meta.ISBN)
+	assert.Equal(t, 6, meta.Version)
+	assert.False(t, meta.KF8)
+	assert.Equal(t, "palmdoc", meta.Compression)
+	assert.Positive(t, meta.EstimatedPages)
+	assert.Equal(t, 2019, meta.bookFields().Year)
+
+	cover, err := b.cover()
+	require.NoError(t, err)
+	assert.Equal(t, testJPEG(t), cover)
+
+	raw, err := b.rawText(nil)
+	require.NoError(t, err)
+	assert.Equal(t, testMOBIText(), string(raw), "text records are joined without trailing entries")
+}
+
+func TestParseMOBI_FallsBackToFullName(t *testing.T) {
+	m := defaultTestMOBI()
+	m.exth = nil
+	b, err := parseMOBIData(buildTestMOBI(t, m, testJPEG(t)))
+	require.NoError(t, err)
+	assert.Equal(t, "Full Name", b.metadata().Title)
+}
+
+func TestParseMOBI_Errors(t *testing.T) {
+	_, err := parseMOBIData([]byte("%PDF-1.7 not a mobi"))
+	assert.ErrorIs(t, err, ErrNotMOBI)
+
+	m := defaultTestMOBI()
+	m.encryption = 2
+	b, err := parseMOBIData(buildTestMOBI(t, m, testJPEG(t)))
+	require.NoError(t, err, "metadata of protected books is still readable")
+	assert.True(t, b.metadata().Encrypted)
+	_, err = b.rawText(nil)
+	assert.ErrorIs(t, err, ErrMOBIEncrypted)
+}
+
+func TestParseMOBI_ForgedTextLength(t *testing.T) {
+	forge := func(textLength uint32) []byte {
+		data := buildTestMOBI(t, defaultTestMOBI(), testJPEG(t))
+		rec0 := binary.BigEndian.Uint32(data[78:])
+		binary.BigEndian.PutUint32(data[rec0+4:], textLength)
+		return data
+	}
+
+	_, err := parseMOBIData(forge(0xFFFFFFFF))
+	assert.ErrorIs(t, err, ErrNotMOBI)
+
+	data := forge(maxMOBITextBytes)
+	b, err := parseMOBIData(data)
+	require.NoError(t, err)
+	text, err := b.rawText(nil)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, cap(text), len(data)*palmDOCMaxExpansion, "the buffer is not sized from the header")
+}
+
+// readZip returns the entries of an archive in stored order
+func readZip(t *testing.T, data []byte) ([]string, map[string]string) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	var names []string
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		body, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		_ = rc.Close()
+		names = append(names, f.Name)
+		files[f.Name] = string(body)
+	}
+	return names, files
+}
+
+func TestConvertMOBIToEPUB(t *testing.T) {
+	b, err := parseMOBIData(buildTestMOBI(t, defaultTestMOBI(), testJPEG(t)))
+	require.NoError(t, err)
+
+	var progress []int
+	var buf bytes.Buffer
+	stats, err := convertMOBIToEPUB(b, "urn:uuid:test", &buf, func(p int) { progress = append(progress, p) })
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Chapters)
+	assert.Equal(t, 1, stats.Images)
+	assert.Equal(t, 100, progress[len(progress)-1])
+
+	names, files := readZip(t, buf.Bytes())
+	assert.Equal(t, "mimetype", names[0])
+	for name, body := range files {
+		if strings.HasSuffix(name, ".xhtml") || strings.HasSuffix(name, ".opf") || strings.HasSuffix(name, ".ncx") {
+			dec := xml.NewDecoder(strings.NewReader(body))
+			for {
+				if _, err := dec.Token(); err != nil {
+					require.ErrorIs(t, err, io.EOF, "%s must be well-formed XML", name)
+					break
+				}
+			}
+		}
+	}
+
+	target := strings.LastIndex(testMOBIText(), "<mbp:pagebreak/>")
+	anchor := fmt.Sprintf("filepos%d", target)
+	assert.Contains(t, files["OEBPS/chapter-0001.xhtml"], `href="chapter-0003.xhtml#`+anchor+`"`)
+	assert.Contains(t, files["OEBPS/chapter-0003.xhtml"], `id="`+anchor+`"`, "anchors at a page break open the next chapter")
+	assert.Contains(t, files["OEBPS/chapter-0002.xhtml"], `<img src="images/image-00001.jpg" alt=""/>`)
+	assert.Contains(t, files["OEBPS/chapter-0002.xhtml"], "café &amp; more")
+	assert.NotContains(t, files["OEBPS/chapter-0002.xhtml"], "width")
+	assert.Contains(t, files["OEBPS/chapter-0003.xhtml"], "Second text.")
+	assert.NotContains(t, files["OEBPS/chapter-0001.xhtml"], "guide")
+	assert.Contains(t, files, "OEBPS/images/image-00001.jpg")
+
+	// The result goes through the regular EPUB pipeline
+	path := filepath.Join(t.TempDir(), "converted.epub")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+	meta, err := parseEPUB(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Тестовая книга", meta.Title)
+	assert.Equal(t, "ru", meta.Language)
+	assert.Equal(t, "OEBPS/images/image-00001.jpg", meta.Cover)
+	require.Len(t, meta.Spine, 3)
+	require.Len(t, meta.TOC, 3)
+	assert.Equal(t, "Chapter One", meta.TOC[1].Title)
+	assert.Equal(t, "Chapter Two", meta.TOC[2].Title)
+
+	chunks, err := extractEPUBText(path, meta)
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+	assert.Contains(t, chunks[1].Text, "First text, café & more.")
+}
+
+func TestFileProcessor_ConvertsMOBI(t *testing.T) {
+	db, repo := newQueueTestRepo(t)
+	require.NoError(t, db.AutoMigrate(&models.Book{}, &models.BookFile{}, &models.Blob{}))
+
+	store := storage.NewLocalStorage(t.TempDir(), "")
+	_, err := store.Put("ab/book.mobi", bytes.NewReader(buildTestMOBI(t, defaultTestMOBI(), testJPEG(t))))
+	require.NoError(t, err)
+
+	book := &models.Book{Author: "Known Author"}
+	require.NoError(t, db.Create(book).Error)
+	file := &models.BookFile{BookID: book.ID, FileName: "book.mobi", OriginalName: "Book.mobi", FilePath: "ab/book.mobi",
+		FileType: models.FileTypeMOBI, FileSize: 1, MimeType: "application/x-mobipocket-ebook", Hash: "h"}
+	require.NoError(t, db.Create(file).Error)
+
+	bus := events.NewBus(16)
+	sub := bus.Subscribe(t.Context(), events.EventBookProcessed)
+	q := NewQueue("files", repo, QueueConfig{})
+	p := NewFileProcessor(q, gormrepo.NewBookFileRepository(db), gormrepo.NewBookRepository(db), nil, store, bus)
+
+	// Storage-relative paths are resolved against the storage root
+	require.NoError(t, p.process(t.Context(), file.ID, file.FilePath, "mobi", book.ID))
+
+	var stored models.BookFile
+	require.NoError(t, db.First(&stored, "id = ?", file.ID).Error)
+	assert.True(t, stored.IsProcessed)
+	require.NotNil(t, stored.PageCount)
+	require.NotNil(t, stored.Metadata)
+	assert.Contains(t, *stored.Metadata, `"compression":"palmdoc"`)
+
+	var storedBook models.Book
+	require.NoError(t, db.First(&storedBook, "id = ?", book.ID).Error)
+	assert.Equal(t, "Тестовая книга", storedBook.Title)
+	assert.Equal(t, "Known Author", storedBook.Author)
+
+	var jobs []models.Job
+	require.NoError(t, db.Find(&jobs, "kind = ?", JobKindConvertMOBI).Error)
+	require.Len(t, jobs, 1)
+
+	// A retried conversion replaces the earlier EPUB
+	for i := 0; i < 2; i++ {
+		require.NoError(t, p.executeConvert(t.Context(), &jobs[0]))
+	}
+	p.convertDone(&jobs[0], nil)
+
+	var derived []models.BookFile
+	require.NoError(t, db.Find(&derived, "source_file_id = ?", file.ID).Error)
+	require.Len(t, derived, 1)
+	assert.Equal(t, models.FileTypeEPUB, derived[0].FileType)
+	assert.Equal(t, "Book.epub", derived[0].OriginalName)
+	assert.True(t, store.Exists(derived[0].FilePath))
+
+	require.NoError(t, db.Find(&jobs, "kind = ?", JobKindProcessBookFile).Error)
+	assert.Len(t, jobs, 2, "every conversion queues the EPUB for processing")
+
+	var stages []string
+	var last events.BookProcessedPayload
+	for len(sub) > 0 {
+		last = (<-sub).Payload.(events.BookProcessedPayload)
+		stages = append(stages, fmt.Sprintf("%s:%d", last.Stage, last.Progress))
+	}
+	assert.Contains(t, stages, "converting:50")
+	assert.Equal(t, events.StageConverted, last.Stage)
+	assert.True(t, last.Success)
+	assert.Equal(t, derived[0].ID.String(), last.DerivedFileID)
+}
+
+func TestKindleEmbedIndex(t *testing.T) {
+	assert.Equal(t, 1, kindleEmbedIndex("kindle:embed:0001?mime=image/jpeg"))
+	assert.Equal(t, 42, kindleEmbedIndex("kindle:embed:001A"))
+	assert.Equal(t, 0, kindleEmbedIndex("kindle:embed:zz"))
+}
@@ -0,0 +1,145 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/events"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+)
+
+const (
+	outboxBatchSize = 100
+	// outboxClaimLease bounds how long a crashed relay holds its batch
+	// before another instance may publish it
+	outboxClaimLease = 30 * time.Second
+	// outboxPublishTimeout bounds how long one event may wait for a durable
+	// subscriber or JetStream before the rest of the batch is left to a retry
+	outboxPublishTimeout = 10 * time.Second
+	// outboxRetention is how long published rows are kept for debugging
+	outboxRetention    = 7 * 24 * time.Hour
+	outboxCleanupEvery = time.Hour
+)
+
+// OutboxRelay drains the transactional outbox into the event bus (and,
+// through it, the NATS bridge). Each batch is claimed first, so several
+// instances never publish the same rows concurrently. Delivery is
+// at-least-once: rows are marked published only after PublishDurable
+// succeeds, and a row that failed, or was caught by a crash before
+// MarkPublished, is published again with the same event ID once the claim
+// expires.
+type OutboxRelay struct {
+	repo     repository.OutboxRepository
+	bus      *events.Bus
+	interval time.Duration
+	owner    string
+
+	lease          time.Duration
+	publishTimeout time.Duration
+}
+
+// NewOutboxRelay creates a relay that polls the outbox every interval
+func NewOutboxRelay(repo repository.OutboxRepository, bus *events.Bus, interval time.Duration) *OutboxRelay {
+	host, _ := os.Hostname()
+	return &OutboxRelay{
+		repo:     repo,
+		bus:      bus,
+		interval: interval,
+		owner:    fmt.Sprintf("%s/%s", host, uuid.NewString()),
+
+		lease:          outboxClaimLease,
+		publishTimeout: outboxPublishTimeout,
+	}
+}
+
+// Start runs the relay loop until ctx is cancelled
+func (r *OutboxRelay) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		lastCleanup := time.Now()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if _, err := r.Drain(); err != nil {
+				log.Printf("[outbox] relay error: %v", err)
+			}
+
+			if time.Since(lastCleanup) >= outboxCleanupEvery {
+				lastCleanup = time.Now()
+				if n, err := r.repo.DeletePublishedBefore(time.Now().Add(-outboxRetention)); err != nil {
+					log.Printf("[outbox] cleanup error: %v", err)
+				} else if n > 0 {
+					log.Printf("[outbox] removed %d published events", n)
+				}
+			}
+		}
+	}()
+}
+
+// Drain publishes all pending events not claimed by another relay and
+// returns how many were relayed
+func (r *OutboxRelay) Drain() (int, error) {
+	total := 0
+	for {
+		pending, err := r.repo.Claim(r.owner, outboxBatchSize, r.lease)
+		if err != nil {
+			return total, err
+		}
+		if len(pending) == 0 {
+			return total, nil
+		}
+
+		ids := make([]uuid.UUID, 0, len(pending))
+		var publishErr error
+		for _, rec := range pending {
+			if publishErr = r.publish(rec); publishErr != nil {
+				break
+			}
+			ids = append(ids, rec.ID)
+		}
+
+		// Rows after a failure stay claimed and are retried once the claim
+		// expires, so events keep their order
+		if len(ids) > 0 {
+			if err := r.repo.MarkPublished(ids); err != nil {
+				return total, err
+			}
+		}
+		total += len(ids)
+		if publishErr != nil {
+			return total, publishErr
+		}
+
+		if len(pending) < outboxBatchSize {
+			return total, nil
+		}
+	}
+}
+
+func (r *OutboxRelay) publish(rec models.OutboxEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.publishTimeout)
+	defer cancel()
+	err := r.bus.PublishDurable(ctx, events.Event{
+		ID:        rec.ID.String(),
+		Type:      events.EventType(rec.Type),
+		UserID:    rec.UserID,
+		Payload:   json.RawMessage(rec.Payload),
+		Timestamp: rec.CreatedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("publish outbox event %s: %w", rec.ID, err)
+	}
+	return nil
+}
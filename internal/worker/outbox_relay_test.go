@@ -0,0 +1,183 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/oneErrortime/afst/internal/events"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newOutboxTestRepo(t *testing.T) *repository.ExtendedRepository {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.OutboxEvent{}))
+	return gormrepo.NewExtendedRepository(db)
+}
+
+func TestOutboxRelay_OnlyCommittedEventsArePublished(t *testing.T) {
+	repos := newOutboxTestRepo(t)
+	bus := events.NewBus(16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub := bus.Subscribe(ctx, events.EventAccessGranted)
+
+	err := gormrepo.WithTransaction(repos, func(tx *repository.ExtendedRepository) error {
+		return tx.Outbox.Enqueue(events.Event{
+			Type:    events.EventAccessGranted,
+			UserID:  "u1",
+			Payload: events.AccessPayload{AccessID: "a1"},
+		})
+	})
+	require.NoError(t, err)
+
+	rollback := errors.New("rollback")
+	err = gormrepo.WithTransaction(repos, func(tx *repository.ExtendedRepository) error {
+		require.NoError(t, tx.Outbox.Enqueue(events.Event{Type: events.EventAccessGranted, Payload: events.AccessPayload{AccessID: "a2"}}))
+		return rollback
+	})
+	require.ErrorIs(t, err, rollback)
+
+	relay := NewOutboxRelay(repos.Outbox, bus, time.Second)
+	n, err := relay.Drain()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	event := <-sub
+	assert.NotEmpty(t, event.ID)
+	assert.Equal(t, "u1", event.UserID)
+	var payload events.AccessPayload
+	require.NoError(t, json.Unmarshal(event.Payload.(json.RawMessage), &payload))
+	assert.Equal(t, "a1", payload.AccessID)
+
+	n, err = relay.Drain()
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestOutboxRelay_RedeliveryKeepsEventID(t *testing.T) {
+	repos := newOutboxTestRepo(t)
+	bus := events.NewBus(16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub := bus.Subscribe(ctx, events.EventSubscriptionNew)
+
+	require.NoError(t, repos.Outbox.Enqueue(events.Event{Type: events.EventSubscriptionNew}))
+
+	// Simulate a relay that crashed between Publish and MarkPublished
+	pending, err := repos.Outbox.Claim("crashed", 10, time.Millisecond)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	bus.Publish(events.Event{ID: pending[0].ID.String(), Type: events.EventSubscriptionNew})
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = NewOutboxRelay(repos.Outbox, bus, time.Second).Drain()
+	require.NoError(t, err)
+
+	first, second := <-sub, <-sub
+	assert.Equal(t, first.ID, second.ID)
+
+	dedup := events.NewDeduplicator(8)
+	assert.False(t, dedup.Seen(first.ID))
+	assert.True(t, dedup.Seen(second.ID))
+}
+
+func TestOutboxRelay_SkipsEventsClaimedByAnotherInstance(t *testing.T) {
+	repos := newOutboxTestRepo(t)
+	bus := events.NewBus(16)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, repos.Outbox.Enqueue(events.Event{Type: events.EventSubscriptionNew}))
+	}
+
+	claimed, err := repos.Outbox.Claim("other", 2, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+
+	n, err := NewOutboxRelay(repos.Outbox, bus, time.Second).Drain()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "rows under another relay's claim are not published twice")
+
+	again, err := repos.Outbox.Claim("third", 10, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, again)
+}
+
+// stubBridge stands in for the NATS bridge; PublishSync fails while err is set
+type stubBridge struct {
+	err       error
+	published []string
+}
+
+func (b *stubBridge) Publish(subject string, data []byte) error { return nil }
+
+func (b *stubBridge) PublishSync(ctx context.Context, subject string, data []byte) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.published = append(b.published, subject)
+	return nil
+}
+
+func (b *stubBridge) Subscribe(subject string, handler func([]byte)) error { return nil }
+
+func (b *stubBridge) Close() {}
+
+func TestOutboxRelay_BridgeFailureLeavesRowsForRetry(t *testing.T) {
+	repos := newOutboxTestRepo(t)
+	bus := events.NewBus(16)
+	bridge := &stubBridge{err: errors.New("nats unavailable")}
+	bus.SetNATSBridge(bridge)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, repos.Outbox.Enqueue(events.Event{Type: events.EventSubscriptionNew}))
+	}
+
+	relay := NewOutboxRelay(repos.Outbox, bus, time.Second)
+	relay.lease = time.Millisecond
+	n, err := relay.Drain()
+	require.Error(t, err)
+	assert.Zero(t, n)
+
+	bridge.err = nil
+	time.Sleep(5 * time.Millisecond)
+	n, err = relay.Drain()
+	require.NoError(t, err)
+	assert.Equal(t, 2, n, "rows are published again once the claim expires")
+	assert.Len(t, bridge.published, 2)
+}
+
+func TestOutboxRelay_WaitsForDurableSubscriber(t *testing.T) {
+	repos := newOutboxTestRepo(t)
+	bus := events.NewBus(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub := bus.SubscribeDurable(ctx, events.EventAccessGranted)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, repos.Outbox.Enqueue(events.Event{Type: events.EventAccessGranted}))
+	}
+
+	relay := NewOutboxRelay(repos.Outbox, bus, time.Second)
+	relay.lease = time.Millisecond
+	relay.publishTimeout = 20 * time.Millisecond
+	n, err := relay.Drain()
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, n, "the event that did not fit is not marked published")
+
+	first := <-sub
+	time.Sleep(5 * time.Millisecond)
+	n, err = relay.Drain()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NotEqual(t, first.ID, (<-sub).ID)
+}
@@ -0,0 +1,431 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ledongthuc/pdf"
+)
+
+const (
+	// pdfMaxTreeDepth guards against cyclic page and outline trees
+	pdfMaxTreeDepth = 64
+	// pdfMaxOutlineItems bounds the stored table of contents
+	pdfMaxOutlineItems = 2000
+	// pdfMaxXMPSize bounds how much of the XMP packet is read
+	pdfMaxXMPSize = 1 << 20
+)
+
+// PDFMetadata is what the processor extracts from a PDF and stores in
+// BookFile.Metadata as JSON. Info dictionary values take precedence over XMP.
+type PDFMetadata struct {
+	Format     string           `json:"format"`
+	PageCount  int              `json:"page_count"`
+	Title      string           `json:"title,omitempty"`
+	Author     string           `json:"author,omitempty"`
+	Subject    string           `json:"subject,omitempty"`
+	Keywords   string           `json:"keywords,omitempty"`
+	Publisher  string           `json:"publisher,omitempty"`
+	Language   string           `json:"language,omitempty"`
+	Creator    string           `json:"creator,omitempty"`
+	Producer   string           `json:"producer,omitempty"`
+	CreatedAt  *time.Time       `json:"created_at,omitempty"`
+	ModifiedAt *time.Time       `json:"modified_at,omitempty"`
+	Outline    []PDFOutlineItem `json:"outline,omitempty"`
+	HasXMP     bool             `json:"has_xmp"`
+	Linearized bool             `json:"linearized"`
+	PDFVersion string           `json:"pdf_version,omitempty"`
+}
+
+// PDFOutlineItem is one table-of-contents entry; Page is 1-based, 0 if unknown
+type PDFOutlineItem struct {
+	Title    string           `json:"title"`
+	Page     int              `json:"page,omitempty"`
+	Children []PDFOutlineItem `json:"children,omitempty"`
+}
+
+// parsePDF reads the cross-reference data (tables or streams, including
+// objects packed in object streams), walks the page tree and extracts the
+// document metadata and outline.
+func parsePDF(filePath string) (meta *PDFMetadata, err error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// The parser panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			meta, err = nil, fmt.Errorf("malformed pdf: %v", r)
+		}
+	}()
+
+	r, err := pdf.NewReader(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	meta = &PDFMetadata{Format: "pdf"}
+	meta.PDFVersion, meta.Linearized = readPDFHeader(f)
+
+	root := r.Trailer().Key("Root")
+	if root.Kind() != pdf.Dict {
+		return nil, errors.New("pdf has no document catalog")
+	}
+
+	pages := newPDFPageIndex(root.Key("Pages"))
+	meta.PageCount = pages.count
+	if meta.PageCount == 0 {
+		// Fall back to the declared count for trees we could not walk
+		meta.PageCount = int(root.Key("Pages").Key("Count").Int64())
+	}
+
+	docInfo := r.Trailer().Key("Info")
+	meta.Title = pdfText(docInfo.Key("Title"))
+	meta.Author = pdfText(docInfo.Key("Author"))
+	meta.Subject = pdfText(docInfo.Key("Subject"))
+	meta.Keywords = pdfText(docInfo.Key("Keywords"))
+	meta.Creator = pdfText(docInfo.Key("Creator"))
+	meta.Producer = pdfText(docInfo.Key("Producer"))
+	meta.CreatedAt = parsePDFDate(docInfo.Key("CreationDate").Text())
+	meta.ModifiedAt = parsePDFDate(docInfo.Key("ModDate").Text())
+	meta.Language = pdfText(root.Key("Lang"))
+
+	if md := root.Key("Metadata"); md.Kind() == pdf.Stream {
+		if xmp, err := parseXMP(io.LimitReader(md.Reader(), pdfMaxXMPSize)); err == nil {
+			meta.HasXMP = true
+			meta.mergeXMP(xmp)
+		}
+	}
+
+	budget := pdfMaxOutlineItems
+	meta.Outline = buildPDFOutline(root, root.Key("Outlines").Key("First"), pages, 0, &budget)
+
+	return meta, nil
+}
+
+// pdfPageIndex maps page objects to page numbers. The library does not
+// expose object identities, so a page is keyed by its serialized dictionary,
+// which holds unique indirect references (Contents, Parent, Annots...).
+type pdfPageIndex struct {
+	count   int
+	numbers map[string]int
+}
+
+func newPDFPageIndex(pages pdf.Value) *pdfPageIndex {
+	idx := &pdfPageIndex{numbers: make(map[string]int)}
+	idx.walk(pages, 0)
+	return idx
+}
+
+func (idx *pdfPageIndex) walk(node pdf.Value, depth int) {
+	if depth > pdfMaxTreeDepth || node.Kind() != pdf.Dict {
+		return
+	}
+	kids := node.Key("Kids")
+	// A node without Kids is a leaf even if /Type is missing
+	if node.Key("Type").Name() == "Page" || kids.Kind() != pdf.Array {
+		idx.count++
+		key := node.String()
+		if _, dup := idx.numbers[key]; !dup {
+			idx.numbers[key] = idx.count
+		}
+		return
+	}
+	for i := 0; i < kids.Len(); i++ {
+		idx.walk(kids.Index(i), depth+1)
+	}
+}
+
+func (idx *pdfPageIndex) pageOf(page pdf.Value) int {
+	if page.Kind() == pdf.Integer {
+		// Some producers write 0-based page numbers instead of references
+		return int(page.Int64()) + 1
+	}
+	if page.Kind() != pdf.Dict {
+		return 0
+	}
+	return idx.numbers[page.String()]
+}
+
+func buildPDFOutline(root, item pdf.Value, pages *pdfPageIndex, depth int, budget *int) []PDFOutlineItem {
+	if depth > pdfMaxTreeDepth {
+		return nil
+	}
+	var items []PDFOutlineItem
+	for ; item.Kind() == pdf.Dict && *budget > 0; item = item.Key("Next") {
+		*budget--
+		entry := PDFOutlineItem{
+			Title: strings.TrimSpace(item.Key("Title").Text()),
+			Page:  pages.pageOf(resolvePDFDest(root, outlineDest(item))),
+		}
+		entry.Children = buildPDFOutline(root, item.Key("First"), pages, depth+1, budget)
+		items = append(items, entry)
+	}
+	return items
+}
+
+// outlineDest returns the destination of an outline item, either direct
+// (/Dest) or through a GoTo action (/A << /S /GoTo /D ... >>)
+func outlineDest(item pdf.Value) pdf.Value {
+	if dest := item.Key("Dest"); !dest.IsNull() {
+		return dest
+	}
+	if action := item.Key("A"); action.Key("S").Name() == "GoTo" {
+		return action.Key("D")
+	}
+	return pdf.Value{}
+}
+
+// resolvePDFDest turns a destination (explicit array or named) into its page object
+func resolvePDFDest(root, dest pdf.Value) pdf.Value {
+	for i := 0; i < 4; i++ {
+		switch dest.Kind() {
+		case pdf.Array:
+			if dest.Len() == 0 {
+				return pdf.Value{}
+			}
+			return dest.Index(0)
+		case pdf.Dict:
+			// Named destinations may map to << /D [...] >>
+			dest = dest.Key("D")
+		case pdf.String:
+			dest = lookupPDFNameTree(root.Key("Names").Key("Dests"), dest.RawString(), 0)
+		case pdf.Name:
+			dest = root.Key("Dests").Key(dest.Name())
+		default:
+			return pdf.Value{}
+		}
+	}
+	return pdf.Value{}
+}
+
+func lookupPDFNameTree(node pdf.Value, key string, depth int) pdf.Value {
+	if depth > pdfMaxTreeDepth || node.Kind() != pdf.Dict {
+		return pdf.Value{}
+	}
+	if names := node.Key("Names"); names.Kind() == pdf.Array {
+		for i := 0; i+1 < names.Len(); i += 2 {
+			if names.Index(i).RawString() == key {
+				return names.Index(i + 1)
+			}
+		}
+	}
+	kids := node.Key("Kids")
+	for i := 0; i < kids.Len(); i++ {
+		kid := kids.Index(i)
+		if limits := kid.Key("Limits"); limits.Len() == 2 {
+			if key < limits.Index(0).RawString() || key > limits.Index(1).RawString() {
+				continue
+			}
+		}
+		if v := lookupPDFNameTree(kid, key, depth+1); !v.IsNull() {
+			return v
+		}
+	}
+	return pdf.Value{}
+}
+
+func pdfText(v pdf.Value) string {
+	return strings.TrimSpace(strings.ReplaceAll(v.Text(), "\x00", ""))
+}
+
+// readPDFHeader returns the version from %PDF-x.y and whether the file
+// declares itself linearized in its first object
+func readPDFHeader(f io.ReaderAt) (version string, linearized bool) {
+	buf := make([]byte, 1024)
+	n, _ := f.ReadAt(buf, 0)
+	buf = buf[:n]
+	if i := bytes.Index(buf, []byte("%PDF-")); i >= 0 && i+8 <= len(buf) {
+		version = string(buf[i+5 : i+8])
+	}
+	return version, bytes.Contains(buf, []byte("/Linearized"))
+}
+
+// parsePDFDate parses D:YYYYMMDDHHmmSSOHH'mm' with any trailing part optional
+func parsePDFDate(s string) *time.Time {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "D:")
+	if len(s) < 4 {
+		return nil
+	}
+
+	num := func(from, to, def int) int {
+		if len(s) < to {
+			return def
+		}
+		v, err := strconv.Atoi(s[from:to])
+		if err != nil {
+			return def
+		}
+		return v
+	}
+	year := num(0, 4, 0)
+	if year == 0 {
+		return nil
+	}
+
+	loc := time.UTC
+	if len(s) > 14 {
+		switch tz := s[14:]; tz[0] {
+		case '+', '-':
+			parts := strings.Split(strings.Trim(tz[1:], "'"), "'")
+			h, _ := strconv.Atoi(parts[0])
+			m := 0
+			if len(parts) > 1 {
+				m, _ = strconv.Atoi(parts[1])
+			}
+			offset := h*3600 + m*60
+			if tz[0] == '-' {
+				offset = -offset
+			}
+			loc = time.FixedZone("", offset)
+		}
+	}
+
+	t := time.Date(year, time.Month(num(4, 6, 1)), num(6, 8, 1),
+		num(8, 10, 0), num(10, 12, 0), num(12, 14, 0), 0, loc).UTC()
+	return &t
+}
+
+// xmpMetadata holds the Dublin Core properties we use from an XMP packet
+type xmpMetadata struct {
+	Title       string
+	Creators    []string
+	Description string
+	Publisher   string
+	Language    string
+	Subject     []string
+	Producer    string
+	CreatorTool string
+}
+
+const (
+	xmpNamespaceDC  = "http://purl.org/dc/elements/1.1/"
+	xmpNamespacePDF = "http://ns.adobe.com/pdf/1.3/"
+	xmpNamespaceXMP = "http://ns.adobe.com/xap/1.0/"
+)
+
+// parseXMP collects dc:* values (from rdf:li items or plain text) and the
+// producer/creator tool, which may also appear as rdf:Description attributes
+func parseXMP(r io.Reader) (*xmpMetadata, error) {
+	dec := xml.NewDecoder(r)
+	x := &xmpMetadata{}
+	var field string
+	var text strings.Builder
+
+	add := func(field, value string) {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return
+		}
+		switch field {
+		case "title":
+			if x.Title == "" {
+				x.Title = value
+			}
+		case "creator":
+			x.Creators = append(x.Creators, value)
+		case "description":
+			if x.Description == "" {
+				x.Description = value
+			}
+		case "publisher":
+			if x.Publisher == "" {
+				x.Publisher = value
+			}
+		case "language":
+			if x.Language == "" {
+				x.Language = value
+			}
+		case "subject":
+			x.Subject = append(x.Subject, value)
+		case "Producer":
+			x.Producer = value
+		case "CreatorTool":
+			x.CreatorTool = value
+		}
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return x, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			for _, a := range t.Attr {
+				if a.Name.Space == xmpNamespacePDF || a.Name.Space == xmpNamespaceXMP {
+					add(a.Name.Local, a.Value)
+				}
+			}
+			switch {
+			case t.Name.Space == xmpNamespaceDC:
+				field = t.Name.Local
+				text.Reset()
+			case t.Name.Space == xmpNamespacePDF || t.Name.Space == xmpNamespaceXMP:
+				field = t.Name.Local
+				text.Reset()
+			case t.Name.Local == "li":
+				text.Reset()
+			}
+		case xml.CharData:
+			if field != "" {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			switch {
+			case t.Name.Local == "li" && field != "":
+				add(field, text.String())
+				text.Reset()
+			case field != "" && t.Name.Local == field:
+				add(field, text.String())
+				field = ""
+				text.Reset()
+			}
+		}
+	}
+}
+
+func (m *PDFMetadata) mergeXMP(x *xmpMetadata) {
+	if m.Title == "" {
+		m.Title = x.Title
+	}
+	if m.Author == "" && len(x.Creators) > 0 {
+		m.Author = strings.Join(x.Creators, ", ")
+	}
+	if m.Subject == "" {
+		m.Subject = x.Description
+	}
+	if m.Keywords == "" && len(x.Subject) > 0 {
+		m.Keywords = strings.Join(x.Subject, ", ")
+	}
+	if m.Publisher == "" {
+		m.Publisher = x.Publisher
+	}
+	if m.Language == "" {
+		m.Language = x.Language
+	}
+	if m.Producer == "" {
+		m.Producer = x.Producer
+	}
+	if m.Creator == "" {
+		m.Creator = x.CreatorTool
+	}
+}
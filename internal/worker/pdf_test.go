@@ -0,0 +1,304 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/glebarez/sqlite"
+	"github.com/oneErrortime/afst/internal/events"
+	"github.com/oneErrortime/afst/internal/models"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// pdfObject is an object of a generated test PDF; streams cannot live in object streams
+type pdfObject struct {
+	body   string
+	stream string
+}
+
+func utf16Hex(s string) string {
+	var buf bytes.Buffer
+	buf.WriteString("<FEFF")
+	for _, r := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&buf, "%04X", r)
+	}
+	buf.WriteString(">")
+	return buf.String()
+}
+
+// buildTestPDF writes objects numbered from 1. With compressed set, every
+// non-stream object is packed into an object stream and the cross-reference
+// data is written as an xref stream, as modern producers do.
+func buildTestPDF(objs map[int]pdfObject, trailer string, compressed bool) []byte {
+	var ids []int
+	for id := range objs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	size := ids[len(ids)-1] + 1
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	offsets := map[int]int{}
+	writeObj := func(id int, o pdfObject) {
+		offsets[id] = buf.Len()
+		if o.stream != "" || o.body == "" {
+			fmt.Fprintf(&buf, "%d 0 obj\n%s\nstream\n%s\nendstream\nendobj\n", id, o.body, o.stream)
+			return
+		}
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", id, o.body)
+	}
+
+	if !compressed {
+		for _, id := range ids {
+			writeObj(id, objs[id])
+		}
+		xref := buf.Len()
+		fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", size)
+		for id := 1; id < size; id++ {
+			fmt.Fprintf(&buf, "%010d 00000 n \n", offsets[id])
+		}
+		fmt.Fprintf(&buf, "trailer\n<< /Size %d %s >>\nstartxref\n%d\n%%%%EOF\n", size, trailer, xref)
+		return buf.Bytes()
+	}
+
+	objStmID, xrefID := size, size+1
+	var packed []int
+	var header, data bytes.Buffer
+	for _, id := range ids {
+		if objs[id].stream != "" {
+			writeObj(id, objs[id])
+			continue
+		}
+		fmt.Fprintf(&header, "%d %d ", id, data.Len())
+		data.WriteString(objs[id].body)
+		data.WriteString("\n")
+		packed = append(packed, id)
+	}
+	content := header.String() + data.String()
+	writeObj(objStmID, pdfObject{
+		body:   fmt.Sprintf("<< /Type /ObjStm /N %d /First %d /Length %d >>", len(packed), header.Len(), len(content)),
+		stream: content,
+	})
+
+	var rows bytes.Buffer
+	index := map[int]int{}
+	for i, id := range packed {
+		index[id] = i
+	}
+	for id := 0; id < xrefID+1; id++ {
+		switch {
+		case id == 0:
+			rows.Write([]byte{0, 0, 0, 0, 0, 0xff, 0xff})
+		case id == xrefID:
+			rows.Write([]byte{1, byte(buf.Len() >> 24), byte(buf.Len() >> 16), byte(buf.Len() >> 8), byte(buf.Len()), 0, 0})
+		default:
+			if i, ok := index[id]; ok {
+				rows.Write([]byte{2, byte(objStmID >> 24), byte(objStmID >> 16), byte(objStmID >> 8), byte(objStmID), byte(i >> 8), byte(i)})
+			} else {
+				off := offsets[id]
+				rows.Write([]byte{1, byte(off >> 24), byte(off >> 16), byte(off >> 8), byte(off), 0, 0})
+			}
+		}
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "%d 0 obj\n<< /Type /XRef /Size %d /W [1 4 2] /Length %d %s >>\nstream\n",
+		xrefID, xrefID+1, rows.Len(), trailer)
+	buf.Write(rows.Bytes())
+	fmt.Fprintf(&buf, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", xref)
+	return buf.Bytes()
+}
+
+const testXMP = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/"
+      xmlns:pdf="http://ns.adobe.com/pdf/1.3/" pdf:Producer="TestWriter 1.0">
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">XMP Title</rdf:li></rdf:Alt></dc:title>
+   <dc:creator><rdf:Seq><rdf:li>Lev Tolstoy</rdf:li><rdf:li>Editor</rdf:li></rdf:Seq></dc:creator>
+   <dc:description><rdf:Alt><rdf:li xml:lang="x-default">A novel</rdf:li></rdf:Alt></dc:description>
+   <dc:publisher><rdf:Bag><rdf:li>Russkiy Vestnik</rdf:li></rdf:Bag></dc:publisher>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func testPDFObjects(title string) map[int]pdfObject {
+	return map[int]pdfObject{
+		1:  {body: "<< /Type /Catalog /Pages 2 0 R /Outlines 8 0 R /Lang (ru) /Metadata 7 0 R /Names << /Dests 12 0 R >> >>"},
+		2:  {body: "<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 3 >>"},
+		3:  {body: "<< /Type /Pages /Parent 2 0 R /Kids [5 0 R 6 0 R] /Count 2 >>"},
+		4:  {body: "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 13 0 R >>"},
+		5:  {body: "<< /Type /Page /Parent 3 0 R /MediaBox [0 0 612 792] /Contents 14 0 R >>"},
+		6:  {body: "<< /Type /Page /Parent 3 0 R /MediaBox [0 0 612 792] /Contents 15 0 R >>"},
+		7:  {body: fmt.Sprintf("<< /Type /Metadata /Subtype /XML /Length %d >>", len(testXMP)), stream: testXMP},
+		8:  {body: "<< /Type /Outlines /First 9 0 R /Last 10 0 R /Count 3 >>"},
+		9:  {body: "<< /Title (Chapter 1) /Parent 8 0 R /Next 10 0 R /Dest [5 0 R /Fit] /First 11 0 R /Last 11 0 R /Count 1 >>"},
+		10: {body: "<< /Title (Chapter 2) /Parent 8 0 R /Prev 9 0 R /A << /S /GoTo /D (chap2) >> >>"},
+		11: {body: "<< /Title (Section 1.1) /Parent 9 0 R /Dest [6 0 R /XYZ 0 0 0] >>"},
+		12: {body: "<< /Names [(chap2) [4 0 R /Fit]] >>"},
+		13: {body: "<< /Length 8 >>", stream: "BT ET %3"},
+		14: {body: "<< /Length 8 >>", stream: "BT ET %1"},
+		15: {body: "<< /Length 8 >>", stream: "BT ET %2"},
+		16: {body: fmt.Sprintf("<< /Title %s /Author (Leo Tolstoy) /CreationDate (D:18690101120000+03'00') >>", utf16Hex(title))},
+	}
+}
+
+func writeTestPDF(t *testing.T, data []byte) string {
+	path := filepath.Join(t.TempDir(), "book.pdf")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func TestParsePDF(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compressed=%v", compressed), func(t *testing.T) {
+			path := writeTestPDF(t, buildTestPDF(testPDFObjects("Война и мир"), "/Root 1 0 R /Info 16 0 R", compressed))
+
+			meta, err := parsePDF(path)
+			require.NoError(t, err)
+
+			assert.Equal(t, 3, meta.PageCount)
+			assert.Equal(t, "1.7", meta.PDFVersion)
+			assert.Equal(t, "Война и мир", meta.Title, "Info wins over XMP")
+			assert.Equal(t, "Leo Tolstoy", meta.Author)
+			assert.Equal(t, "A novel", meta.Subject, "missing Info values come from XMP")
+			assert.Equal(t, "Russkiy Vestnik", meta.Publisher)
+			assert.Equal(t, "TestWriter 1.0", meta.Producer)
+			assert.Equal(t, "ru", meta.Language)
+			assert.True(t, meta.HasXMP)
+			require.NotNil(t, meta.CreatedAt)
+			assert.Equal(t, time.Date(1869, 1, 1, 9, 0, 0, 0, time.UTC), *meta.CreatedAt)
+
+			require.Len(t, meta.Outline, 2)
+			assert.Equal(t, PDFOutlineItem{
+				Title: "Chapter 1", Page: 1,
+				Children: []PDFOutlineItem{{Title: "Section 1.1", Page: 2}},
+			}, meta.Outline[0])
+			assert.Equal(t, PDFOutlineItem{Title: "Chapter 2", Page: 3}, meta.Outline[1], "named destination via GoTo")
+		})
+	}
+}
+
+func TestParsePDF_Malformed(t *testing.T) {
+	path := writeTestPDF(t, []byte("%PDF-1.4\n1 0 obj\n<< /Type /Page >>\nendobj\ntrailer\n<< /Root 9 0 R >>\n%%EOF"))
+	_, err := parsePDF(path)
+	assert.Error(t, err)
+
+	// The scanner fallback still finds the page
+	pages, err := countPDFPages(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, pages)
+}
+
+func TestParsePDFDate(t *testing.T) {
+	d := parsePDFDate("D:20210314150926-05'30'")
+	require.NotNil(t, d)
+	assert.Equal(t, time.Date(2021, 3, 14, 20, 39, 26, 0, time.UTC), *d)
+
+	d = parsePDFDate("D:2021")
+	require.NotNil(t, d)
+	assert.Equal(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), *d)
+
+	assert.Nil(t, parsePDFDate("yesterday"))
+}
+
+func TestFileProcessor_StoresPDFMetadataAndFillsBook(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Book{}, &models.BookFile{}, &models.Blob{}))
+
+	path := writeTestPDF(t, buildTestPDF(testPDFObjects(""), "/Root 1 0 R /Info 16 0 R", true))
+
+	book := &models.Book{Title: "", Author: "Known Author"}
+	require.NoError(t, db.Create(book).Error)
+	file := &models.BookFile{BookID: book.ID, FileName: "book.pdf", OriginalName: "book.pdf", FilePath: path,
+		FileType: models.FileTypePDF, FileSize: 1, MimeType: "application/pdf", Hash: "h"}
+	require.NoError(t, db.Create(file).Error)
+
+	p := &FileProcessor{
+		fileRepo: gormrepo.NewBookFileRepository(db),
+		bookRepo: gormrepo.NewBookRepository(db),
+	}
+	require.NoError(t, p.process(t.Context(), file.ID, path, "pdf", book.ID))
+
+	var storedFile models.BookFile
+	require.NoError(t, db.First(&storedFile, "id = ?", file.ID).Error)
+	assert.True(t, storedFile.IsProcessed)
+	require.NotNil(t, storedFile.PageCount)
+	assert.Equal(t, 3, *storedFile.PageCount)
+	require.NotNil(t, storedFile.Metadata)
+	var meta PDFMetadata
+	require.NoError(t, json.Unmarshal([]byte(*storedFile.Metadata), &meta))
+	assert.Equal(t, "pdf", meta.Format)
+	assert.Len(t, meta.Outline, 2)
+
+	var storedBook models.Book
+	require.NoError(t, db.First(&storedBook, "id = ?", book.ID).Error)
+	assert.Equal(t, "XMP Title", storedBook.Title, "empty title is filled")
+	assert.Equal(t, "Known Author", storedBook.Author, "existing values are kept")
+	require.NotNil(t, storedBook.Publisher)
+	assert.Equal(t, "Russkiy Vestnik", *storedBook.Publisher)
+	require.NotNil(t, storedBook.PageCount)
+	assert.Equal(t, 3, *storedBook.PageCount)
+}
+
+func TestFileProcessor_WritesProcessedEventToOutbox(t *testing.T) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Book{}, &models.BookFile{}, &models.Blob{}, &models.OutboxEvent{}))
+	repos := gormrepo.NewExtendedRepository(db)
+
+	path := writeTestPDF(t, buildTestPDF(testPDFObjects(""), "/Root 1 0 R /Info 16 0 R", true))
+	book := &models.Book{Title: "Book"}
+	require.NoError(t, db.Create(book).Error)
+	file := &models.BookFile{BookID: book.ID, FileName: "book.pdf", OriginalName: "book.pdf", FilePath: path,
+		FileType: models.FileTypePDF, FileSize: 1, MimeType: "application/pdf", Hash: "h"}
+	require.NoError(t, db.Create(file).Error)
+
+	bus := events.NewBus(16)
+	sub := bus.Subscribe(t.Context(), events.EventBookProcessed)
+	p := &FileProcessor{fileRepo: repos.BookFile, bookRepo: repos.Book, bus: bus}
+	p.SetOutbox(repos)
+	require.NoError(t, p.process(t.Context(), file.ID, path, "pdf", book.ID))
+
+	data, err := json.Marshal(processFilePayload{FileID: file.ID, FilePath: path, FileType: "pdf", BookID: book.ID})
+	require.NoError(t, err)
+	job := &models.Job{Payload: string(data)}
+	p.done(job, nil)
+
+	pending, err := repos.Outbox.GetPending(10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1, "the success event is written with the processed flag, not again on completion")
+	assert.Equal(t, string(events.EventBookProcessed), pending[0].Type)
+	var payload events.BookProcessedPayload
+	require.NoError(t, json.Unmarshal([]byte(pending[0].Payload), &payload))
+	assert.Equal(t, file.ID.String(), payload.FileID)
+	assert.Equal(t, events.StageProcessed, payload.Stage)
+	assert.True(t, payload.Success)
+
+	p.done(job, errors.New("parse failed"))
+	pending, err = repos.Outbox.GetPending(10)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	require.NoError(t, json.Unmarshal([]byte(pending[1].Payload), &payload))
+	assert.False(t, payload.Success)
+	assert.Equal(t, "parse failed", payload.Error)
+	assert.Empty(t, sub, "nothing bypasses the outbox")
+}
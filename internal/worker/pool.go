@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"log"
 	"sync"
 	"sync/atomic"
@@ -15,6 +16,21 @@ type Job struct {
 	OnDone  func(err error)
 }
 
+// permanentError marks a failure that retrying cannot fix
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that the pool or queue stops retrying the job immediately.
+// OnDone still receives the original error.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
 // Pool is a bounded worker pool with graceful shutdown support
 type Pool struct {
 	name       string
@@ -86,6 +102,12 @@ func (p *Pool) runWithRetry(job Job) {
 			break
 		}
 		log.Printf("[worker:%s] job %s attempt %d failed: %v", p.name, job.ID, attempt+1, err)
+
+		var perm permanentError
+		if errors.As(err, &perm) {
+			err = perm.err
+			break
+		}
 	}
 
 	if err != nil {
@@ -5,60 +5,209 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/oneErrortime/afst/internal/events"
+	"github.com/oneErrortime/afst/internal/models"
 	"github.com/oneErrortime/afst/internal/repository"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/oneErrortime/afst/internal/storage"
 )
 
-// FileProcessor dispatches file-processing jobs to the worker pool.
+const (
+	// JobKindProcessBookFile is the queue job kind for uploaded book files
+	JobKindProcessBookFile = "book_file.process"
+	// JobKindConvertMOBI converts a processed MOBI/AZW3 file to a derived EPUB
+	JobKindConvertMOBI = "book_file.convert_mobi"
+)
+
+// processFilePayload is the stored payload of a JobKindProcessBookFile job
+type processFilePayload struct {
+	FileID   uuid.UUID `json:"file_id"`
+	FilePath string    `json:"file_path"`
+	FileType string    `json:"file_type"`
+	BookID   uuid.UUID `json:"book_id"`
+}
+
+// convertPayload is the stored payload of a JobKindConvertMOBI job
+type convertPayload struct {
+	FileID uuid.UUID `json:"file_id"`
+	BookID uuid.UUID `json:"book_id"`
+}
+
+// FileProcessor dispatches file-processing jobs to the persistent job queue.
 // Currently handles:
-//   - PDF page count extraction (pure Go, no cgo)
-//   - EPUB chapter counting (via zip inspection)
+//   - PDF parsing (pure Go, no cgo): page tree, Info/XMP metadata, outline
+//   - EPUB parsing: package metadata, spine, nav/NCX table of contents
+//   - cover extraction: EPUB cover-image, largest JPEG on the first PDF page
+//   - full-text indexing: text per PDF page or EPUB spine document
+//   - MOBI/AZW3: PalmDOC/MOBI/EXTH headers, then conversion to a derived
+//     EPUB file that goes through the EPUB pipeline
 type FileProcessor struct {
-	pool     *Pool
+	queue    *Queue
 	fileRepo repository.BookFileRepository
+	bookRepo repository.BookRepository
+	textRepo repository.BookTextRepository
+	storage  storage.FileStorage
 	bus      *events.Bus
+	covers   CoverSink
+	repos    *repository.ExtendedRepository
 }
 
-// NewFileProcessor creates a processor backed by the given pool
-func NewFileProcessor(pool *Pool, fileRepo repository.BookFileRepository, bus *events.Bus) *FileProcessor {
-	return &FileProcessor{
-		pool:     pool,
+// CoverSink stores cover images found in processed files
+type CoverSink interface {
+	SetExtractedCover(bookID uuid.UUID, data []byte, source models.CoverSource) error
+}
+
+// NewFileProcessor creates a processor and registers its handlers on queue.
+// textRepo may be nil to skip full-text indexing. fileStorage resolves the
+// stored file paths and receives converted files.
+func NewFileProcessor(
+	queue *Queue,
+	fileRepo repository.BookFileRepository,
+	bookRepo repository.BookRepository,
+	textRepo repository.BookTextRepository,
+	fileStorage storage.FileStorage,
+	bus *events.Bus,
+) *FileProcessor {
+	p := &FileProcessor{
+		queue:    queue,
 		fileRepo: fileRepo,
+		bookRepo: bookRepo,
+		textRepo: textRepo,
+		storage:  fileStorage,
 		bus:      bus,
 	}
+	queue.Register(JobKindProcessBookFile, JobHandler{
+		Execute: p.execute,
+		OnDone:  p.done,
+	})
+	queue.Register(JobKindConvertMOBI, JobHandler{
+		Execute: p.executeConvert,
+		OnDone:  p.convertDone,
+	})
+	return p
 }
 
-// Enqueue schedules background processing for a newly-uploaded book file
-func (p *FileProcessor) Enqueue(fileID uuid.UUID, filePath, fileType string, bookID uuid.UUID) {
-	p.pool.SubmitNonBlocking(Job{
-		ID: "process:" + fileID.String(),
-		Execute: func(ctx context.Context) error {
-			return p.process(ctx, fileID, filePath, fileType, bookID)
-		},
-		OnDone: func(err error) {
-			payload := events.BookProcessedPayload{
-				BookID:  bookID.String(),
-				FileID:  fileID.String(),
-				Success: err == nil,
-			}
-			if err != nil {
-				payload.Error = err.Error()
-			}
-			p.bus.Publish(events.Event{
-				Type:    events.EventBookProcessed,
-				Payload: payload,
-			})
-		},
+// SetCoverSink enables cover extraction; must be called before the queue starts
+func (p *FileProcessor) SetCoverSink(sink CoverSink) {
+	p.covers = sink
+}
+
+// SetOutbox writes book.processed results to the transactional outbox
+// instead of publishing them on the bus; a successful run records its event
+// in the same transaction as the processed flag. Must be called before the
+// queue starts.
+func (p *FileProcessor) SetOutbox(repos *repository.ExtendedRepository) {
+	p.repos = repos
+}
+
+func (p *FileProcessor) hasOutbox() bool {
+	return p.repos != nil && p.repos.Outbox != nil
+}
+
+// Enqueue schedules background processing for a newly-uploaded book file.
+// The job is stored first, so it survives a restart before it runs.
+func (p *FileProcessor) Enqueue(fileID uuid.UUID, filePath, fileType string, bookID uuid.UUID) error {
+	_, err := p.queue.Enqueue(JobKindProcessBookFile, processFilePayload{
+		FileID:   fileID,
+		FilePath: filePath,
+		FileType: fileType,
+		BookID:   bookID,
+	})
+	return err
+}
+
+func (p *FileProcessor) execute(ctx context.Context, job *models.Job) error {
+	var payload processFilePayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return Permanent(fmt.Errorf("invalid payload: %w", err))
+	}
+	return p.process(ctx, payload.FileID, payload.FilePath, payload.FileType, payload.BookID)
+}
+
+func (p *FileProcessor) done(job *models.Job, err error) {
+	// A successful run already wrote its event along with the processed flag
+	if err == nil && p.hasOutbox() {
+		return
+	}
+
+	var payload processFilePayload
+	_ = json.Unmarshal([]byte(job.Payload), &payload)
+
+	processed := events.BookProcessedPayload{
+		BookID:  payload.BookID.String(),
+		FileID:  payload.FileID.String(),
+		Stage:   events.StageProcessed,
+		Success: err == nil,
+	}
+	if err != nil {
+		processed.Error = err.Error()
+	}
+	p.publish(processed)
+}
+
+// publish sends a processing event. Results go through the outbox when one
+// is wired; conversion progress is transient and stays on the bus.
+func (p *FileProcessor) publish(payload events.BookProcessedPayload) {
+	event := events.Event{
+		Type:    events.EventBookProcessed,
+		Payload: payload,
+	}
+	if payload.Stage != events.StageConverting && p.hasOutbox() {
+		if err := p.repos.Outbox.Enqueue(event); err != nil {
+			log.Printf("[processor] failed to record %s event for %s: %v", payload.Stage, payload.FileID, err)
+		}
+		return
+	}
+	if p.bus == nil {
+		return
+	}
+	p.bus.Publish(event)
+}
+
+// markProcessed stores the processed file. With an outbox the success event
+// is written in the same transaction, so a committed flag always has it.
+func (p *FileProcessor) markProcessed(file *models.BookFile) error {
+	if !p.hasOutbox() {
+		return p.fileRepo.Update(file)
+	}
+	return gormrepo.WithTransaction(p.repos, func(txRepo *repository.ExtendedRepository) error {
+		if err := txRepo.BookFile.Update(file); err != nil {
+			return err
+		}
+		return txRepo.Outbox.Enqueue(events.Event{
+			Type: events.EventBookProcessed,
+			Payload: events.BookProcessedPayload{
+				BookID:  file.BookID.String(),
+				FileID:  file.ID.String(),
+				Stage:   events.StageProcessed,
+				Success: true,
+			},
+		})
 	})
 }
 
+// localPath makes a stored file available on disk for the parsers; release
+// removes the temp copy of a remote backend
+func (p *FileProcessor) localPath(filePath string) (string, func(), error) {
+	if p.storage == nil {
+		return filePath, func() {}, nil
+	}
+	return storage.LocalFile(p.storage, filePath)
+}
+
 func (p *FileProcessor) process(ctx context.Context, fileID uuid.UUID, filePath, fileType string, bookID uuid.UUID) error {
 	select {
 	case <-ctx.Done():
@@ -66,16 +215,53 @@ func (p *FileProcessor) process(ctx context.Context, fileID uuid.UUID, filePath,
 	default:
 	}
 
+	storedPath := filePath
+	filePath, release, err := p.localPath(storedPath)
+	if err != nil {
+		return fmt.Errorf("file %s is not readable: %w", storedPath, err)
+	}
+	defer release()
+
 	var pageCount int
-	var err error
+	// metadata is stored as JSON in BookFile.Metadata
+	var metadata interface{}
+	var fields *bookFields
+	var epubMeta *EPUBMetadata
+	var mobi *mobiBook
 
 	switch fileType {
 	case "pdf":
-		pageCount, err = countPDFPages(filePath)
+		var meta *PDFMetadata
+		meta, err = parsePDF(filePath)
+		if err != nil {
+			log.Printf("[processor] pdf parser failed for %s, falling back to scan: %v", fileID, err)
+			pageCount, err = countPDFPages(filePath)
+			break
+		}
+		pageCount, metadata = meta.PageCount, meta
+		fields = meta.bookFields()
 	case "epub":
-		pageCount, err = countEPUBChapters(filePath)
+		var meta *EPUBMetadata
+		meta, err = parseEPUB(filePath)
+		if err != nil {
+			log.Printf("[processor] epub parser failed for %s, falling back to scan: %v", fileID, err)
+			pageCount, err = countEPUBChapters(filePath)
+			break
+		}
+		pageCount, metadata = len(meta.Spine), meta
+		fields = meta.bookFields()
+		epubMeta = meta
+	case "mobi":
+		mobi, err = openMOBI(filePath)
+		if err != nil {
+			log.Printf("[processor] mobi parser failed for %s: %v", fileID, err)
+			break
+		}
+		meta := mobi.metadata()
+		pageCount, metadata = meta.EstimatedPages, meta
+		fields = meta.bookFields()
 	default:
-		return nil // nothing to do for mobi etc.
+		return nil
 	}
 
 	if err != nil {
@@ -92,18 +278,332 @@ func (p *FileProcessor) process(ctx context.Context, fileID uuid.UUID, filePath,
 	if pageCount > 0 {
 		file.PageCount = &pageCount
 	}
+	if metadata != nil {
+		if data, err := json.Marshal(metadata); err == nil {
+			encoded := string(data)
+			file.Metadata = &encoded
+		}
+	}
 	file.IsProcessed = true
 
-	if err := p.fileRepo.Update(file); err != nil {
+	if err := p.markProcessed(file); err != nil {
 		return fmt.Errorf("failed to update file %s: %w", fileID, err)
 	}
 
+	if fields != nil {
+		p.fillBook(bookID, fields)
+	}
+	p.extractCover(bookID, fileType, filePath, epubMeta, mobi)
+	p.indexText(fileID, bookID, fileType, filePath, epubMeta)
+
 	log.Printf("[processor] processed %s (%s): %d pages", fileID, fileType, pageCount)
+
+	// The web reader only opens EPUB, so MOBI text is served through a
+	// converted copy; its text is indexed when that copy is processed
+	if mobi != nil {
+		if err := mobi.convertible(); err != nil {
+			log.Printf("[processor] %s will not be converted: %v", fileID, err)
+		} else if _, err := p.queue.Enqueue(JobKindConvertMOBI, convertPayload{FileID: fileID, BookID: bookID}); err != nil {
+			log.Printf("[processor] failed to queue conversion of %s: %v", fileID, err)
+		}
+	}
+	return nil
+}
+
+func (p *FileProcessor) executeConvert(ctx context.Context, job *models.Job) error {
+	var payload convertPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return Permanent(fmt.Errorf("invalid payload: %w", err))
+	}
+	return p.convertMOBI(ctx, payload.FileID, payload.BookID)
+}
+
+func (p *FileProcessor) convertDone(job *models.Job, err error) {
+	var payload convertPayload
+	_ = json.Unmarshal([]byte(job.Payload), &payload)
+
+	converted := events.BookProcessedPayload{
+		BookID:  payload.BookID.String(),
+		FileID:  payload.FileID.String(),
+		Stage:   events.StageConverted,
+		Success: err == nil,
+	}
+	if err != nil {
+		converted.Error = err.Error()
+	} else if derived, derr := p.fileRepo.GetDerived(payload.FileID); derr == nil && len(derived) > 0 {
+		converted.Progress = 100
+		converted.DerivedFileID = derived[len(derived)-1].ID.String()
+		if derived[len(derived)-1].PageCount != nil {
+			converted.PageCount = *derived[len(derived)-1].PageCount
+		}
+	}
+	p.publish(converted)
+}
+
+// convertMOBI writes the EPUB conversion of a MOBI file to storage as a new
+// BookFile linked to the source and queues it for EPUB processing. A
+// repeated conversion replaces the previous derived file.
+func (p *FileProcessor) convertMOBI(ctx context.Context, fileID, bookID uuid.UUID) error {
+	if p.storage == nil {
+		return Permanent(errors.New("conversion needs file storage"))
+	}
+	source, err := p.fileRepo.GetByID(fileID)
+	if err != nil {
+		return Permanent(fmt.Errorf("file %s not found: %w", fileID, err))
+	}
+	filePath, release, err := p.localPath(source.FilePath)
+	if err != nil {
+		return fmt.Errorf("file %s is not readable: %w", source.FilePath, err)
+	}
+	defer release()
+	book, err := openMOBI(filePath)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	// Progress goes out in quarter steps to keep the event stream quiet
+	reported := -1
+	progress := func(percent int) {
+		if step := percent / 25 * 25; step > reported {
+			reported = step
+			p.publish(events.BookProcessedPayload{
+				BookID:   bookID.String(),
+				FileID:   fileID.String(),
+				Stage:    events.StageConverting,
+				Progress: step,
+				Success:  true,
+			})
+		}
+	}
+
+	var buf bytes.Buffer
+	stats, err := convertMOBIToEPUB(book, "urn:uuid:"+fileID.String(), &buf, progress)
+	if err != nil {
+		// The same input always fails the same way
+		return Permanent(err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	hash := hex.EncodeToString(sum[:])
+	derived := &models.BookFile{
+		BookID:       bookID,
+		FileName:     hash + ".epub",
+		OriginalName: strings.TrimSuffix(source.OriginalName, filepath.Ext(source.OriginalName)) + ".epub",
+		FilePath:     storage.BlobPath(hash),
+		FileType:     models.FileTypeEPUB,
+		FileSize:     int64(buf.Len()),
+		MimeType:     "application/epub+zip",
+		Hash:         hash,
+		SourceFileID: &source.ID,
+	}
+	// The record references the blob before it is written, so garbage
+	// collection cannot remove it in between; a blob being collected right
+	// now makes this attempt fail and the job retry
+	if err := p.fileRepo.Create(derived); err != nil {
+		return err
+	}
+	if _, err := p.storage.Put(derived.FilePath, bytes.NewReader(buf.Bytes())); err != nil {
+		_ = p.fileRepo.Delete(derived.ID)
+		return err
+	}
+	p.removeDerived(source.ID, derived.ID)
+
+	if err := p.Enqueue(derived.ID, derived.FilePath, string(derived.FileType), bookID); err != nil {
+		log.Printf("[processor] failed to queue processing of converted %s: %v", derived.ID, err)
+	}
+	log.Printf("[processor] converted %s to epub %s: %d chapters, %d images",
+		fileID, derived.ID, stats.Chapters, stats.Images)
 	return nil
 }
 
-// countPDFPages counts pages using a pure-Go scan for "/Type /Page" objects.
-// This is ~10× faster than loading the full PDF and works on any valid PDF.
+// removeDerived drops earlier conversions of sourceID except keep. Their
+// blobs are left to garbage collection.
+func (p *FileProcessor) removeDerived(sourceID, keep uuid.UUID) {
+	files, err := p.fileRepo.GetDerived(sourceID)
+	if err != nil {
+		log.Printf("[processor] failed to list conversions of %s: %v", sourceID, err)
+		return
+	}
+	for _, f := range files {
+		if f.ID == keep {
+			continue
+		}
+		if p.textRepo != nil {
+			_ = p.textRepo.DeleteFile(f.ID)
+		}
+		if err := p.fileRepo.Delete(f.ID); err != nil {
+			log.Printf("[processor] failed to remove old conversion %s: %v", f.ID, err)
+		}
+	}
+}
+
+// bookFields are the values a parsed file can contribute to a Book
+type bookFields struct {
+	Title       string
+	Author      string
+	Description string
+	Language    string
+	Publisher   string
+	PageCount   int
+	Year        int
+}
+
+func (m *PDFMetadata) bookFields() *bookFields {
+	f := &bookFields{
+		Title:       m.Title,
+		Author:      m.Author,
+		Description: m.Subject,
+		Language:    m.Language,
+		Publisher:   m.Publisher,
+		PageCount:   m.PageCount,
+	}
+	if m.CreatedAt != nil {
+		f.Year = m.CreatedAt.Year()
+	}
+	return f
+}
+
+// bookFields leaves PageCount empty: spine documents are not pages
+func (m *EPUBMetadata) bookFields() *bookFields {
+	return &bookFields{
+		Title:       m.Title,
+		Author:      m.Author,
+		Description: m.Description,
+		Language:    m.Language,
+		Publisher:   m.Publisher,
+	}
+}
+
+// fillBook copies parsed metadata into empty book fields, like the EPUB path
+// does on upload. Failures are logged: metadata is not critical.
+func (p *FileProcessor) fillBook(bookID uuid.UUID, meta *bookFields) {
+	if p.bookRepo == nil {
+		return
+	}
+	book, err := p.bookRepo.GetByID(bookID)
+	if err != nil {
+		log.Printf("[processor] book %s not found for metadata: %v", bookID, err)
+		return
+	}
+
+	changed := false
+	setString := func(dst *string, v string) {
+		if *dst == "" && v != "" {
+			*dst, changed = v, true
+		}
+	}
+	setOptional := func(dst **string, v string) {
+		if (*dst == nil || **dst == "") && v != "" {
+			*dst, changed = &v, true
+		}
+	}
+
+	setString(&book.Title, meta.Title)
+	setString(&book.Author, meta.Author)
+	setOptional(&book.Description, meta.Description)
+	setOptional(&book.Language, meta.Language)
+	setOptional(&book.Publisher, meta.Publisher)
+	if book.PageCount == nil && meta.PageCount > 0 {
+		pages := meta.PageCount
+		book.PageCount, changed = &pages, true
+	}
+	if book.PublicationYear == nil && meta.Year > 0 {
+		year := meta.Year
+		book.PublicationYear, changed = &year, true
+	}
+
+	if !changed {
+		return
+	}
+	if err := p.bookRepo.Update(book); err != nil {
+		log.Printf("[processor] failed to update book %s from file metadata: %v", bookID, err)
+	}
+}
+
+// extractCover hands the embedded cover to the sink. Like metadata, covers
+// are best effort: failures are logged and never fail the job.
+func (p *FileProcessor) extractCover(bookID uuid.UUID, fileType, filePath string, epubMeta *EPUBMetadata, mobi *mobiBook) {
+	if p.covers == nil {
+		return
+	}
+
+	var data []byte
+	var source models.CoverSource
+	var err error
+	switch fileType {
+	case "epub":
+		if epubMeta == nil || epubMeta.Cover == "" {
+			return
+		}
+		data, err = extractEPUBCover(filePath, epubMeta.Cover)
+		source = models.CoverSourceEPUB
+	case "pdf":
+		data, err = extractPDFCover(filePath)
+		source = models.CoverSourcePDF
+	case "mobi":
+		if mobi == nil {
+			return
+		}
+		data, err = mobi.cover()
+		source = models.CoverSourceMOBI
+	default:
+		return
+	}
+	if err != nil {
+		log.Printf("[processor] no cover for book %s: %v", bookID, err)
+		return
+	}
+	if err := p.covers.SetExtractedCover(bookID, data, source); err != nil {
+		log.Printf("[processor] failed to store cover for book %s: %v", bookID, err)
+	}
+}
+
+// indexText replaces the file's entries in the full-text index. Search is
+// an extra, so failures are logged and never fail the job.
+func (p *FileProcessor) indexText(fileID, bookID uuid.UUID, fileType, filePath string, epubMeta *EPUBMetadata) {
+	if p.textRepo == nil {
+		return
+	}
+
+	var chunks []models.BookTextChunk
+	var err error
+	switch fileType {
+	case "pdf":
+		chunks, err = extractPDFText(filePath)
+	case "epub":
+		if epubMeta == nil {
+			return
+		}
+		chunks, err = extractEPUBText(filePath, epubMeta)
+	default:
+		return
+	}
+	if err != nil {
+		log.Printf("[processor] text extraction failed for %s: %v", fileID, err)
+		return
+	}
+
+	index := &models.BookTextIndex{
+		FileID:     fileID,
+		BookID:     bookID,
+		Format:     models.FileType(fileType),
+		ChunkCount: len(chunks),
+		IndexedAt:  time.Now(),
+	}
+	for _, c := range chunks {
+		index.CharCount += int64(len(c.Text))
+	}
+	if err := p.textRepo.ReplaceFile(index, chunks); err != nil {
+		log.Printf("[processor] failed to index text of %s: %v", fileID, err)
+	}
+}
+
+// countPDFPages is the fallback when the PDF cannot be parsed: it counts
+// pages with a raw scan for "/Type /Page" objects. It misses pages inside
+// compressed object streams.
 func countPDFPages(filePath string) (int, error) {
 	f, err := os.Open(filePath)
 	if err != nil {
@@ -159,7 +659,8 @@ func countPDFPages(filePath string) (int, error) {
 	return count, scanner.Err()
 }
 
-// countEPUBChapters counts spine items in the OPF — a reasonable proxy for "pages"
+// countEPUBChapters is the fallback when the EPUB cannot be parsed: it counts
+// spine items in the OPF — a reasonable proxy for "pages"
 func countEPUBChapters(filePath string) (int, error) {
 	r, err := zip.OpenReader(filePath)
 	if err != nil {
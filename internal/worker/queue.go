@@ -0,0 +1,353 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+)
+
+const (
+	defaultJobLease       = time.Minute
+	defaultJobPoll        = 2 * time.Second
+	defaultJobMaxAttempts = 5
+	jobBackoffBase        = 5 * time.Second
+	jobBackoffMax         = 10 * time.Minute
+)
+
+// JobHandler executes jobs of one kind.
+type JobHandler struct {
+	Execute func(ctx context.Context, job *models.Job) error
+	// OnDone is called once the job reaches a final state on this instance:
+	// succeeded (err == nil) or dead after its last attempt.
+	OnDone func(job *models.Job, err error)
+	// Backoff overrides the queue's delay before the retry that follows
+	// attempt (1-based).
+	Backoff func(attempt int) time.Duration
+}
+
+// QueueConfig tunes a Queue; zero values fall back to defaults.
+type QueueConfig struct {
+	Workers     int
+	MaxAttempts int
+	// Lease is how long a claimed job stays reserved without a heartbeat.
+	// Workers renew it every Lease/3, so only a crashed instance lets it expire.
+	Lease time.Duration
+	// PollInterval bounds how long idle workers wait before looking for jobs
+	// enqueued by other instances or whose retry time has come.
+	PollInterval time.Duration
+}
+
+// Queue is a persistent job queue backed by the jobs table. Unlike Pool it
+// survives restarts: jobs are stored before they run, claimed under a lease
+// and retried with exponential backoff until MaxAttempts, after which they
+// are dead-lettered for manual inspection.
+type Queue struct {
+	name     string
+	repo     repository.JobRepository
+	cfg      QueueConfig
+	owner    string
+	handlers map[string]JobHandler
+	wake     chan struct{}
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	processed atomic.Int64
+	failed    atomic.Int64
+}
+
+// NewQueue creates a queue; register handlers before calling Start
+func NewQueue(name string, repo repository.JobRepository, cfg QueueConfig) *Queue {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultJobMaxAttempts
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = defaultJobLease
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultJobPoll
+	}
+
+	host, _ := os.Hostname()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Queue{
+		name:     name,
+		repo:     repo,
+		cfg:      cfg,
+		owner:    fmt.Sprintf("%s/%s", host, uuid.NewString()),
+		handlers: make(map[string]JobHandler),
+		wake:     make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Name returns the queue name
+func (q *Queue) Name() string {
+	return q.name
+}
+
+// Register sets the handler for jobs of kind
+func (q *Queue) Register(kind string, h JobHandler) {
+	q.handlers[kind] = h
+}
+
+// Enqueue stores a job with a JSON-encoded payload and wakes an idle worker
+func (q *Queue) Enqueue(kind string, payload interface{}) (*models.Job, error) {
+	job, err := q.newJob(uuid.Nil, kind, payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.repo.Enqueue(job); err != nil {
+		return nil, err
+	}
+	q.notify()
+	return job, nil
+}
+
+// EnqueueOnce stores a job under a caller-chosen id unless a job with that
+// id already exists, so a producer can re-submit its work items (e.g. after
+// a restart) without running them twice. false means the job existed.
+func (q *Queue) EnqueueOnce(id uuid.UUID, kind string, payload interface{}) (bool, error) {
+	job, err := q.newJob(id, kind, payload)
+	if err != nil {
+		return false, err
+	}
+	created, err := q.repo.EnqueueOnce(job)
+	if err != nil {
+		return false, err
+	}
+	if created {
+		q.notify()
+	}
+	return created, nil
+}
+
+func (q *Queue) newJob(id uuid.UUID, kind string, payload interface{}) (*models.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s payload: %w", kind, err)
+	}
+	return &models.Job{
+		ID:          id,
+		Queue:       q.name,
+		Kind:        kind,
+		Payload:     string(data),
+		Status:      models.JobStatusPending,
+		MaxAttempts: q.cfg.MaxAttempts,
+		RunAt:       time.Now(),
+	}, nil
+}
+
+// notify wakes an idle worker
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start launches the workers and the lease reaper
+func (q *Queue) Start() {
+	for i := 0; i < q.cfg.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+
+	q.wg.Add(1)
+	go q.reap()
+
+	log.Printf("[queue:%s] started %d workers (lease=%v)", q.name, q.cfg.Workers, q.cfg.Lease)
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for {
+		if q.ctx.Err() != nil {
+			return
+		}
+
+		job, err := q.repo.Claim(q.name, q.owner, q.cfg.Lease)
+		if err != nil {
+			log.Printf("[queue:%s] claim error: %v", q.name, err)
+		}
+		if job != nil {
+			q.run(job)
+			continue
+		}
+
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-q.wake:
+		case <-time.After(q.cfg.PollInterval):
+		}
+	}
+}
+
+// reap dead-letters jobs whose worker died during their last attempt;
+// jobs with attempts left are simply re-claimed by Claim.
+func (q *Queue) reap() {
+	defer q.wg.Done()
+	ticker := time.NewTicker(q.cfg.Lease)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if n, err := q.repo.ReapExpired(q.name); err != nil {
+			log.Printf("[queue:%s] reap error: %v", q.name, err)
+		} else if n > 0 {
+			log.Printf("[queue:%s] dead-lettered %d jobs with expired leases", q.name, n)
+		}
+	}
+}
+
+func (q *Queue) run(job *models.Job) {
+	h, ok := q.handlers[job.Kind]
+	if !ok {
+		q.failed.Add(1)
+		log.Printf("[queue:%s] no handler for job %s (%s)", q.name, job.ID, job.Kind)
+		if _, err := q.repo.Fail(job.ID, q.owner, "no handler for kind "+job.Kind, nil); err != nil {
+			log.Printf("[queue:%s] job %s: %v", q.name, job.ID, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(q.ctx)
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		q.heartbeat(ctx, cancel, job)
+	}()
+
+	err := h.Execute(ctx, job)
+	cancel()
+	<-heartbeatDone
+
+	if err == nil {
+		q.processed.Add(1)
+		if ok, err := q.repo.Complete(job.ID, q.owner); err != nil {
+			log.Printf("[queue:%s] job %s: failed to mark succeeded: %v", q.name, job.ID, err)
+		} else if ok && h.OnDone != nil {
+			h.OnDone(job, nil)
+		}
+		return
+	}
+
+	q.failed.Add(1)
+
+	// Shutdown interrupted the job: hand it back right away instead of
+	// waiting for the lease to expire
+	if q.ctx.Err() != nil {
+		now := time.Now()
+		if _, ferr := q.repo.Fail(job.ID, q.owner, "interrupted by shutdown", &now); ferr != nil {
+			log.Printf("[queue:%s] job %s: %v", q.name, job.ID, ferr)
+		}
+		return
+	}
+
+	var perm permanentError
+	if errors.As(err, &perm) {
+		err = perm.err
+	}
+
+	var nextRunAt *time.Time
+	if perm.err == nil && !job.IsLastAttempt() {
+		backoff := jobBackoff
+		if h.Backoff != nil {
+			backoff = h.Backoff
+		}
+		next := time.Now().Add(backoff(job.Attempts))
+		nextRunAt = &next
+		log.Printf("[queue:%s] job %s attempt %d/%d failed, retry at %s: %v",
+			q.name, job.ID, job.Attempts, job.MaxAttempts, next.Format(time.RFC3339), err)
+	} else {
+		log.Printf("[queue:%s] job %s dead after %d attempts: %v", q.name, job.ID, job.Attempts, err)
+	}
+
+	ok, ferr := q.repo.Fail(job.ID, q.owner, err.Error(), nextRunAt)
+	if ferr != nil {
+		log.Printf("[queue:%s] job %s: failed to record failure: %v", q.name, job.ID, ferr)
+		return
+	}
+	if ok && nextRunAt == nil && h.OnDone != nil {
+		h.OnDone(job, err)
+	}
+}
+
+// heartbeat renews the lease until ctx is done. Losing the lease (the job
+// was cancelled or re-claimed elsewhere) cancels the running handler.
+func (q *Queue) heartbeat(ctx context.Context, cancel context.CancelFunc, job *models.Job) {
+	ticker := time.NewTicker(q.cfg.Lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ok, err := q.repo.ExtendLease(job.ID, q.owner, time.Now().Add(q.cfg.Lease))
+		if err != nil {
+			log.Printf("[queue:%s] job %s: lease renewal failed: %v", q.name, job.ID, err)
+			continue
+		}
+		if !ok {
+			log.Printf("[queue:%s] job %s: lease lost, stopping", q.name, job.ID)
+			cancel()
+			return
+		}
+	}
+}
+
+// Shutdown stops claiming new jobs and waits for running ones to return
+func (q *Queue) Shutdown(timeout time.Duration) {
+	log.Printf("[queue:%s] shutting down...", q.name)
+	q.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Printf("[queue:%s] clean shutdown (processed=%d, failed=%d)",
+			q.name, q.processed.Load(), q.failed.Load())
+	case <-time.After(timeout):
+		log.Printf("[queue:%s] shutdown timed out after %v", q.name, timeout)
+	}
+}
+
+// Stats returns counters of attempts handled by this instance
+func (q *Queue) Stats() (processed, failed int64) {
+	return q.processed.Load(), q.failed.Load()
+}
+
+// jobBackoff is the delay before the retry that follows attempt (1-based)
+func jobBackoff(attempt int) time.Duration {
+	d := jobBackoffBase
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= jobBackoffMax {
+			return jobBackoffMax
+		}
+	}
+	return d
+}
@@ -0,0 +1,227 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/oneErrortime/afst/internal/models"
+	"github.com/oneErrortime/afst/internal/repository"
+	gormrepo "github.com/oneErrortime/afst/internal/repository/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormdb "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newQueueTestRepo(t *testing.T) (*gormdb.DB, repository.JobRepository) {
+	db, err := gormdb.Open(sqlite.Open(":memory:"), &gormdb.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	// every connection to :memory: is a separate database
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	require.NoError(t, db.AutoMigrate(&models.Job{}))
+	return db, gormrepo.NewJobRepository(db)
+}
+
+func waitForStatus(t *testing.T, repo repository.JobRepository, job *models.Job, status models.JobStatus) *models.Job {
+	t.Helper()
+	var got *models.Job
+	require.Eventually(t, func() bool {
+		var err error
+		got, err = repo.GetByID(job.ID)
+		return err == nil && got.Status == status
+	}, 5*time.Second, 10*time.Millisecond)
+	return got
+}
+
+func TestQueue_RunsEnqueuedJob(t *testing.T) {
+	_, repo := newQueueTestRepo(t)
+	q := NewQueue("test", repo, QueueConfig{Workers: 2, PollInterval: 20 * time.Millisecond})
+
+	done := make(chan error, 1)
+	q.Register("echo", JobHandler{
+		Execute: func(ctx context.Context, job *models.Job) error { return nil },
+		OnDone:  func(job *models.Job, err error) { done <- err },
+	})
+	q.Start()
+	defer q.Shutdown(time.Second)
+
+	job, err := q.Enqueue("echo", map[string]string{"hello": "world"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"hello":"world"}`, job.Payload)
+
+	got := waitForStatus(t, repo, job, models.JobStatusSucceeded)
+	assert.Equal(t, 1, got.Attempts)
+	assert.Nil(t, got.LeaseOwner)
+	assert.NotNil(t, got.FinishedAt)
+	assert.NoError(t, <-done)
+
+	processed, failed := q.Stats()
+	assert.Equal(t, int64(1), processed)
+	assert.Equal(t, int64(0), failed)
+}
+
+func TestQueue_ExpiredLeaseIsReclaimed(t *testing.T) {
+	_, repo := newQueueTestRepo(t)
+	job := &models.Job{Queue: "test", Kind: "echo", Payload: "{}", MaxAttempts: 3}
+	require.NoError(t, repo.Enqueue(job))
+
+	// A worker of a crashed instance took the job and never came back
+	claimed, err := repo.Claim("test", "crashed", 10*time.Millisecond)
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+
+	again, err := repo.Claim("test", "other", time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, again, "job is leased")
+
+	time.Sleep(20 * time.Millisecond)
+
+	q := NewQueue("test", repo, QueueConfig{PollInterval: 20 * time.Millisecond})
+	q.Register("echo", JobHandler{Execute: func(ctx context.Context, job *models.Job) error { return nil }})
+	q.Start()
+	defer q.Shutdown(time.Second)
+
+	got := waitForStatus(t, repo, job, models.JobStatusSucceeded)
+	assert.Equal(t, 2, got.Attempts)
+
+	// The crashed worker can no longer report on the job
+	ok, err := repo.Complete(job.ID, "crashed")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestQueue_DeadLettersAfterMaxAttempts(t *testing.T) {
+	db, repo := newQueueTestRepo(t)
+	q := NewQueue("test", repo, QueueConfig{MaxAttempts: 2})
+
+	var final error
+	q.Register("broken", JobHandler{
+		Execute: func(ctx context.Context, job *models.Job) error { return errors.New("boom") },
+		OnDone:  func(job *models.Job, err error) { final = err },
+	})
+
+	job, err := q.Enqueue("broken", nil)
+	require.NoError(t, err)
+
+	claimed, err := repo.Claim("test", q.owner, time.Minute)
+	require.NoError(t, err)
+	q.run(claimed)
+
+	got, err := repo.GetByID(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobStatusFailed, got.Status)
+	assert.Equal(t, "boom", *got.LastError)
+	assert.True(t, got.RunAt.After(time.Now()), "retry is delayed by backoff")
+	assert.Nil(t, final, "OnDone waits for the final attempt")
+
+	// Skip the backoff
+	require.NoError(t, db.Model(&models.Job{}).Where("id = ?", job.ID).Update("run_at", time.Now()).Error)
+	claimed, err = repo.Claim("test", q.owner, time.Minute)
+	require.NoError(t, err)
+	q.run(claimed)
+
+	got, err = repo.GetByID(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobStatusDead, got.Status)
+	assert.Equal(t, 2, got.Attempts)
+	assert.EqualError(t, final, "boom")
+
+	claimed, err = repo.Claim("test", q.owner, time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, claimed, "dead jobs are not picked up")
+
+	require.NoError(t, repo.Retry(job.ID))
+	got, err = repo.GetByID(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobStatusPending, got.Status)
+	assert.Equal(t, 0, got.Attempts)
+}
+
+func TestQueue_PermanentErrorSkipsRetries(t *testing.T) {
+	_, repo := newQueueTestRepo(t)
+	q := NewQueue("test", repo, QueueConfig{MaxAttempts: 5})
+	q.Register("bad", JobHandler{
+		Execute: func(ctx context.Context, job *models.Job) error { return Permanent(errors.New("invalid")) },
+	})
+
+	job, err := q.Enqueue("bad", nil)
+	require.NoError(t, err)
+	claimed, err := repo.Claim("test", q.owner, time.Minute)
+	require.NoError(t, err)
+	q.run(claimed)
+
+	got, err := repo.GetByID(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobStatusDead, got.Status)
+	assert.Equal(t, "invalid", *got.LastError)
+}
+
+func TestJobRepository_ReapExpiredLastAttempt(t *testing.T) {
+	_, repo := newQueueTestRepo(t)
+	job := &models.Job{Queue: "test", Kind: "echo", Payload: "{}", MaxAttempts: 1}
+	require.NoError(t, repo.Enqueue(job))
+
+	_, err := repo.Claim("test", "crashed", time.Millisecond)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	claimed, err := repo.Claim("test", "other", time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, claimed, "no attempts left")
+
+	n, err := repo.ReapExpired("test")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	got, err := repo.GetByID(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobStatusDead, got.Status)
+}
+
+func TestQueue_CancelStopsRunningJob(t *testing.T) {
+	_, repo := newQueueTestRepo(t)
+	q := NewQueue("test", repo, QueueConfig{Lease: 30 * time.Millisecond, PollInterval: 10 * time.Millisecond})
+
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+	q.Register("slow", JobHandler{
+		Execute: func(ctx context.Context, job *models.Job) error {
+			close(started)
+			<-ctx.Done()
+			stopped <- ctx.Err()
+			return ctx.Err()
+		},
+	})
+	q.Start()
+	defer q.Shutdown(time.Second)
+
+	job, err := q.Enqueue("slow", nil)
+	require.NoError(t, err)
+	<-started
+
+	require.NoError(t, repo.Cancel(job.ID))
+	select {
+	case err := <-stopped:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler was not stopped after cancel")
+	}
+
+	got, err := repo.GetByID(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobStatusCancelled, got.Status)
+}
+
+func TestJobBackoff(t *testing.T) {
+	assert.Equal(t, 5*time.Second, jobBackoff(1))
+	assert.Equal(t, 10*time.Second, jobBackoff(2))
+	assert.Equal(t, 40*time.Second, jobBackoff(4))
+	assert.Equal(t, jobBackoffMax, jobBackoff(20))
+}
@@ -0,0 +1,180 @@
+package worker
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+	"github.com/oneErrortime/afst/internal/models"
+)
+
+// maxChunkChars caps the text indexed per page or spine document
+const maxChunkChars = 1 << 20
+
+// blockElements start a new line in extracted XHTML text, so words from
+// adjacent paragraphs and cells are not glued together
+var blockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "td": true, "th": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "pre": true, "section": true, "article": true, "aside": true,
+	"header": true, "footer": true, "figcaption": true, "dt": true, "dd": true, "hr": true,
+}
+
+// skippedElements hold no reader-visible text
+var skippedElements = map[string]bool{"head": true, "script": true, "style": true, "svg": true}
+
+// extractPDFText returns the plain text of every non-empty page. A page the
+// parser chokes on is skipped instead of failing the whole file.
+func extractPDFText(filePath string) (chunks []models.BookTextChunk, err error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			chunks, err = nil, fmt.Errorf("malformed pdf: %v", r)
+		}
+	}()
+
+	r, err := pdf.NewReader(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if r.Trailer().Key("Encrypt").Kind() != pdf.Null {
+		return nil, errors.New("encrypted pdf")
+	}
+
+	for i := 1; i <= r.NumPage(); i++ {
+		text := pdfPageText(r.Page(i))
+		if text == "" {
+			continue
+		}
+		page := i
+		chunks = append(chunks, models.BookTextChunk{Ordinal: i, Page: &page, Text: text})
+	}
+	return chunks, nil
+}
+
+func pdfPageText(page pdf.Page) (text string) {
+	defer func() {
+		if recover() != nil {
+			text = ""
+		}
+	}()
+	if page.V.IsNull() {
+		return ""
+	}
+	raw, err := page.GetPlainText(nil)
+	if err != nil {
+		return ""
+	}
+	return normalizeText(raw)
+}
+
+// extractEPUBText returns the text of every XHTML document in the spine.
+// Locations are spine-level EPUB CFIs: the spine is always the third child
+// of the package element (/6) and itemrefs use even steps.
+func extractEPUBText(filePath string, meta *EPUBMetadata) ([]models.BookTextChunk, error) {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = zr.Close() }()
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var chunks []models.BookTextChunk
+	for i, item := range meta.Spine {
+		if item.MediaType != "application/xhtml+xml" && item.MediaType != "text/html" {
+			continue
+		}
+		text, err := epubDocumentText(files, item.Href)
+		if err != nil || text == "" {
+			continue
+		}
+		chunks = append(chunks, models.BookTextChunk{
+			Ordinal: i + 1,
+			Href:    item.Href,
+			CFI:     fmt.Sprintf("epubcfi(/6/%d!)", (i+1)*2),
+			Text:    text,
+		})
+	}
+	return chunks, nil
+}
+
+func epubDocumentText(files map[string]*zip.File, name string) (string, error) {
+	rc, err := openEPUBEntry(files, name)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = rc.Close() }()
+
+	var out strings.Builder
+	dec := newEPUBDecoder(rc)
+	skip := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Keep what was read before the markup broke
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if skippedElements[name] {
+				skip++
+			} else if blockElements[name] {
+				out.WriteByte('\n')
+			}
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			if skippedElements[name] && skip > 0 {
+				skip--
+			} else if blockElements[name] {
+				out.WriteByte('\n')
+			}
+		case xml.CharData:
+			if skip == 0 {
+				out.Write(t)
+			}
+		}
+		if out.Len() > maxChunkChars {
+			break
+		}
+	}
+	return normalizeText(out.String()), nil
+}
+
+// normalizeText collapses runs of spaces inside lines and drops empty lines
+func normalizeText(s string) string {
+	lines := strings.Split(s, "\n")
+	out := lines[:0]
+	for _, line := range lines {
+		if line = collapseSpace(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	text := strings.Join(out, "\n")
+	if len(text) > maxChunkChars {
+		text = strings.ToValidUTF8(text[:maxChunkChars], "")
+	}
+	return text
+}
@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractEPUBText(t *testing.T) {
+	p := writeTestEPUB(t, map[string]string{
+		"OEBPS/ch1.xhtml": `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>Не индексируется</title><style>p { color: red }</style></head>
+<body>
+  <h1>Глава&nbsp;первая</h1>
+  <p>Все счастливые семьи<br/>похожи друг на друга.</p>
+  <script>var hidden = "секрет";</script>
+  <table><tr><td>ячейка</td><td>соседняя</td></tr></table>
+</body>
+</html>`,
+		"OEBPS/empty.xhtml": `<html><body><img src="x.png"/></body></html>`,
+		"OEBPS/ch2.xhtml":   `<html><body><p>Смешалось всё в доме Облонских.</p></body></html>`,
+	})
+	meta := &EPUBMetadata{Spine: []EPUBSpineItem{
+		{ID: "c1", Href: "OEBPS/ch1.xhtml", MediaType: "application/xhtml+xml"},
+		{ID: "img", Href: "OEBPS/empty.xhtml", MediaType: "application/xhtml+xml"},
+		{ID: "c2", Href: "OEBPS/ch2.xhtml", MediaType: "application/xhtml+xml"},
+		{ID: "missing", Href: "OEBPS/missing.xhtml", MediaType: "application/xhtml+xml"},
+	}}
+
+	chunks, err := extractEPUBText(p, meta)
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+
+	assert.Equal(t, "Глава первая\nВсе счастливые семьи\nпохожи друг на друга.\nячейка\nсоседняя", chunks[0].Text)
+	assert.Equal(t, "OEBPS/ch1.xhtml", chunks[0].Href)
+	assert.Equal(t, "epubcfi(/6/2!)", chunks[0].CFI)
+	assert.Nil(t, chunks[0].Page)
+
+	assert.Equal(t, 3, chunks[1].Ordinal)
+	assert.Equal(t, "epubcfi(/6/6!)", chunks[1].CFI)
+	assert.Equal(t, "Смешалось всё в доме Облонских.", chunks[1].Text)
+}
+
+func TestExtractPDFText(t *testing.T) {
+	content := func(s string) pdfObject {
+		stream := fmt.Sprintf("BT /F1 12 Tf 72 700 Td (%s) Tj ET", s)
+		return pdfObject{body: fmt.Sprintf("<< /Length %d >>", len(stream)), stream: stream}
+	}
+	objs := map[int]pdfObject{
+		1: {body: "<< /Type /Catalog /Pages 2 0 R >>"},
+		2: {body: "<< /Type /Pages /Kids [3 0 R 4 0 R 5 0 R] /Count 3 /Resources << /Font << /F1 6 0 R >> >> >>"},
+		3: {body: "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 600 800] /Contents 7 0 R >>"},
+		4: {body: "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 600 800] >>"},
+		5: {body: "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 600 800] /Contents 8 0 R >>"},
+		6: {body: "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>"},
+		7: content("Call me Ishmael"),
+		8: content("The whale"),
+	}
+	chunks, err := extractPDFText(writeTestPDF(t, buildTestPDF(objs, "/Root 1 0 R", false)))
+	require.NoError(t, err)
+	require.Len(t, chunks, 2, "pages without text are skipped")
+
+	require.NotNil(t, chunks[0].Page)
+	assert.Equal(t, 1, *chunks[0].Page)
+	assert.Equal(t, "Call me Ishmael", chunks[0].Text)
+	assert.Equal(t, 3, *chunks[1].Page)
+	assert.Equal(t, "The whale", chunks[1].Text)
+}
@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrWatermarkUnsupported — формат или файл нельзя пометить (MOBI, зашифрованный PDF)
+	ErrWatermarkUnsupported = errors.New("watermarking is not supported for this file")
+	// ErrWatermarkNotFound — в файле нет водяного знака
+	ErrWatermarkNotFound = errors.New("no watermark found")
+)
+
+// Watermark identifies the account a downloaded copy was made for. It is
+// written both visibly (PDF page footers, an EPUB colophon page) and as
+// metadata, so a leaked file can be traced with cmd/trace-watermark.
+type Watermark struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	EmailHash string    `json:"email_hash"`
+	IssuedAt  time.Time `json:"issued_at"`
+}
+
+// EmailHash is the hex SHA-256 of the normalized e-mail. The address itself
+// never goes into the file.
+func EmailHash(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:])
+}
+
+// FooterText is the visible line on every PDF page
+func (m Watermark) FooterText() string {
+	return fmt.Sprintf("Экземпляр пользователя %s · %s · %s",
+		m.UserID, shortHash(m.EmailHash), m.IssuedAt.UTC().Format("2006-01-02 15:04 UTC"))
+}
+
+// token is the machine-readable form kept in metadata and content streams
+func (m Watermark) token() string {
+	return fmt.Sprintf("afst-wm:v1;id=%s;user=%s;email=%s;at=%s",
+		m.ID, m.UserID, m.EmailHash, m.IssuedAt.UTC().Format(time.RFC3339))
+}
+
+var watermarkTokenRe = regexp.MustCompile(`afst-wm:v1;id=([0-9a-f-]{36});user=([0-9a-f-]{36});email=([0-9a-f]{64});at=([0-9TZ:+-]+)`)
+
+// findWatermarkToken returns the first watermark token in data
+func findWatermarkToken(data []byte) (*Watermark, bool) {
+	m := watermarkTokenRe.FindSubmatch(data)
+	if m == nil {
+		return nil, false
+	}
+	id, err1 := uuid.ParseBytes(m[1])
+	userID, err2 := uuid.ParseBytes(m[2])
+	at, err3 := time.Parse(time.RFC3339, string(m[4]))
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil, false
+	}
+	return &Watermark{ID: id, UserID: userID, EmailHash: string(m[3]), IssuedAt: at}, true
+}
+
+func shortHash(h string) string {
+	if len(h) > 16 {
+		return h[:16]
+	}
+	return h
+}
+
+// WatermarkFile writes a copy of the PDF or EPUB in src marked for one user.
+// fileType is models.FileType as a string.
+func WatermarkFile(fileType string, src io.ReaderAt, size int64, mark Watermark, w io.Writer) error {
+	switch fileType {
+	case "pdf":
+		return watermarkPDF(src, size, mark, w)
+	case "epub":
+		return watermarkEPUB(src, size, mark, w)
+	default:
+		return ErrWatermarkUnsupported
+	}
+}
+
+// ReadWatermark finds the watermark in a PDF or EPUB, recognized by its
+// leading bytes. Metadata is checked first; if it was stripped, the page
+// content (PDF) or documents (EPUB) are scanned for the embedded token.
+func ReadWatermark(src io.ReaderAt, size int64) (*Watermark, error) {
+	head := make([]byte, 5)
+	n, _ := src.ReadAt(head, 0)
+	switch {
+	case bytes.HasPrefix(head[:n], []byte("%PDF-")):
+		return readPDFWatermark(src, size)
+	case bytes.HasPrefix(head[:n], []byte("PK")):
+		return readEPUBWatermark(src, size)
+	default:
+		return nil, ErrWatermarkUnsupported
+	}
+}
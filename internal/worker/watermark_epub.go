@@ -0,0 +1,206 @@
+package worker
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// epubWatermarkID is the manifest id of the injected colophon page
+	epubWatermarkID   = "afst-watermark"
+	epubWatermarkFile = "afst-watermark.xhtml"
+	// epubWatermarkMeta is the OPF <meta name> carrying the token
+	epubWatermarkMeta = "afst:watermark"
+)
+
+// watermarkEPUB rewrites the archive with a colophon page appended to the
+// spine and the token in the package metadata. Other entries are copied
+// without recompression; mimetype stays first and stored, as OCF requires.
+func watermarkEPUB(src io.ReaderAt, size int64, mark Watermark, w io.Writer) error {
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrWatermarkUnsupported, err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	opfPath, err := epubPackagePath(files)
+	if err != nil {
+		return err
+	}
+	opf, err := readEPUBEntry(files, opfPath)
+	if err != nil {
+		return err
+	}
+	colophon := path.Join(path.Dir(opfPath), epubWatermarkFile)
+	if _, exists := files[colophon]; exists {
+		return fmt.Errorf("epub: %s already exists", colophon)
+	}
+	opf, err = injectOPFWatermark(opf, mark)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	mimetype, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mimetype, "application/epub+zip"); err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		switch f.Name {
+		case "mimetype":
+			continue
+		case opfPath:
+			hdr := f.FileHeader
+			hdr.Method = zip.Deflate
+			entry, err := zw.CreateHeader(&hdr)
+			if err != nil {
+				return err
+			}
+			if _, err := entry.Write(opf); err != nil {
+				return err
+			}
+		default:
+			if err := zw.Copy(f); err != nil {
+				return err
+			}
+		}
+	}
+	entry, err := zw.CreateHeader(&zip.FileHeader{Name: colophon, Method: zip.Deflate, Modified: mark.IssuedAt})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(entry, epubColophon(mark)); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// injectOPFWatermark adds the meta, the manifest item and the spine entry
+// with the same namespace prefix the package document uses
+func injectOPFWatermark(opf []byte, mark Watermark) ([]byte, error) {
+	var ok bool
+	if opf, ok = appendOPFChild(opf, "metadata", func(p string) string {
+		return fmt.Sprintf("<%smeta name=\"%s\" content=\"%s\"/>\n", p, epubWatermarkMeta, mark.token())
+	}); !ok {
+		return nil, fmt.Errorf("epub: package document has no metadata")
+	}
+	if opf, ok = appendOPFChild(opf, "manifest", func(p string) string {
+		return fmt.Sprintf("<%sitem id=\"%s\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n",
+			p, epubWatermarkID, epubWatermarkFile)
+	}); !ok {
+		return nil, fmt.Errorf("epub: package document has no manifest")
+	}
+	if opf, ok = appendOPFChild(opf, "spine", func(p string) string {
+		return fmt.Sprintf("<%sitemref idref=\"%s\"/>\n", p, epubWatermarkID)
+	}); !ok {
+		return nil, fmt.Errorf("epub: package document has no spine")
+	}
+	return opf, nil
+}
+
+// appendOPFChild inserts a child built for the element's namespace prefix
+// before its end tag; an empty <element/> is expanded first
+func appendOPFChild(doc []byte, element string, child func(prefix string) string) ([]byte, bool) {
+	endRe := regexp.MustCompile(`</([A-Za-z_][\w.-]*:)?` + element + `\s*>`)
+	emptyRe := regexp.MustCompile(`<([A-Za-z_][\w.-]*:)?` + element + `\b([^>]*?)/>`)
+
+	if loc := endRe.FindSubmatchIndex(doc); loc != nil {
+		prefix := ""
+		if loc[2] >= 0 {
+			prefix = string(doc[loc[2]:loc[3]])
+		}
+		out := make([]byte, 0, len(doc)+256)
+		out = append(out, doc[:loc[0]]...)
+		out = append(out, child(prefix)...)
+		return append(out, doc[loc[0]:]...), true
+	}
+	if loc := emptyRe.FindSubmatchIndex(doc); loc != nil {
+		prefix := ""
+		if loc[2] >= 0 {
+			prefix = string(doc[loc[2]:loc[3]])
+		}
+		out := make([]byte, 0, len(doc)+256)
+		out = append(out, doc[:loc[0]]...)
+		out = append(out, fmt.Sprintf("<%s%s%s>%s</%s%s>", prefix, element, doc[loc[4]:loc[5]], child(prefix), prefix, element)...)
+		return append(out, doc[loc[1]:]...), true
+	}
+	return doc, false
+}
+
+// epubColophon is plain XHTML 1.1 so that EPUB 2 readers accept it too
+func epubColophon(mark Watermark) string {
+	token := html.EscapeString(mark.token())
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="ru" lang="ru">
+<head>
+<title>Сведения об экземпляре</title>
+<meta name="` + epubWatermarkMeta + `" content="` + token + `"/>
+</head>
+<body>
+<h1>Сведения об экземпляре</h1>
+<p>Этот экземпляр выдан библиотекой конкретному читателю и предназначен только для личного использования. Передача файла третьим лицам запрещена.</p>
+<p>Пользователь: ` + mark.UserID.String() + `</p>
+<p>Хеш e-mail: ` + mark.EmailHash + `</p>
+<p>Выдан: ` + mark.IssuedAt.UTC().Format("2006-01-02 15:04 UTC") + `</p>
+<p style="font-size: 0.6em; color: #999;">` + token + `</p>
+</body>
+</html>
+`
+}
+
+// readEPUBWatermark checks the package metadata, then every XHTML document
+func readEPUBWatermark(src io.ReaderAt, size int64) (*Watermark, error) {
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	if opfPath, err := epubPackagePath(files); err == nil {
+		if opf, err := readEPUBEntry(files, opfPath); err == nil {
+			if m, ok := findWatermarkToken(opf); ok {
+				return m, nil
+			}
+		}
+	}
+	for _, f := range zr.File {
+		ext := strings.ToLower(path.Ext(f.Name))
+		if ext != ".xhtml" && ext != ".html" && ext != ".htm" {
+			continue
+		}
+		data, err := readEPUBEntry(files, f.Name)
+		if err != nil {
+			continue
+		}
+		if m, ok := findWatermarkToken(data); ok {
+			return m, nil
+		}
+	}
+	return nil, ErrWatermarkNotFound
+}
+
+func readEPUBEntry(files map[string]*zip.File, name string) ([]byte, error) {
+	rc, err := openEPUBEntry(files, name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rc.Close() }()
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(rc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
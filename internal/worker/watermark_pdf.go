@@ -0,0 +1,501 @@
+package worker
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// pdfFooterSize is the footer font size in points
+	pdfFooterSize = 7
+	// pdfFooterMargin is the distance of the footer from the page edges
+	pdfFooterMargin = 12
+	// pdfMaxContentSize bounds a decoded content stream that is rewritten
+	pdfMaxContentSize = 64 << 20
+	// pdfTailSize is how much of the file end is searched for startxref
+	pdfTailSize = 2048
+)
+
+var (
+	pdfRefRe       = regexp.MustCompile(`(\d+) (\d+) R`)
+	pdfRootRe      = regexp.MustCompile(`/Root (\d+) (\d+) R`)
+	pdfContentsRe  = regexp.MustCompile(`/Contents (\d+ \d+ R|\[[0-9 R]*\])`)
+	pdfStartXRefRe = regexp.MustCompile(`startxref\s+(\d+)`)
+)
+
+// pdfRef is an indirect object reference
+type pdfRef struct {
+	id, gen int
+}
+
+func (r pdfRef) String() string {
+	return fmt.Sprintf("%d %d R", r.id, r.gen)
+}
+
+// watermarkPDF appends an incremental update to the original file: page
+// content streams are rewritten with a footer drawn as vector outlines (no
+// font resources are touched, so any PDF keeps rendering), and a new Info
+// dictionary carries the watermark. The original bytes stay intact, so
+// signatures over the original revision remain verifiable.
+func watermarkPDF(src io.ReaderAt, size int64, mark Watermark, w io.Writer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed pdf: %v", r)
+		}
+	}()
+
+	r, err := pdf.NewReader(src, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrWatermarkUnsupported, err)
+	}
+	trailer := r.Trailer()
+	if trailer.Key("Encrypt").Kind() != pdf.Null {
+		return ErrWatermarkUnsupported
+	}
+	rootMatch := pdfRootRe.FindStringSubmatch(trailer.String())
+	if rootMatch == nil {
+		return fmt.Errorf("malformed pdf: no /Root")
+	}
+	nextID := int(trailer.Key("Size").Int64())
+	if nextID <= 0 {
+		return fmt.Errorf("malformed pdf: no /Size")
+	}
+
+	tail := make([]byte, pdfTailSize)
+	tailStart := size - pdfTailSize
+	if tailStart < 0 {
+		tailStart, tail = 0, tail[:size]
+	}
+	if _, err := src.ReadAt(tail, tailStart); err != nil && err != io.EOF {
+		return err
+	}
+	starts := pdfStartXRefRe.FindAllSubmatch(tail, -1)
+	if starts == nil {
+		return fmt.Errorf("malformed pdf: no startxref")
+	}
+	prev, _ := strconv.ParseInt(string(starts[len(starts)-1][1]), 10, 64)
+	head := make([]byte, 32)
+	n, _ := src.ReadAt(head, prev)
+	classic := bytes.HasPrefix(bytes.TrimLeft(head[:n], " \t\r\n"), []byte("xref"))
+
+	// Original revision first, then the update
+	cw := &countingWriter{w: w}
+	if _, err := io.Copy(cw, io.NewSectionReader(src, 0, size)); err != nil {
+		return err
+	}
+	if last := tail[len(tail)-1]; last != '\n' && last != '\r' {
+		if _, err := io.WriteString(cw, "\n"); err != nil {
+			return err
+		}
+	}
+
+	offsets := map[int]int64{}
+	gens := map[int]int{}
+	writeObject := func(ref pdfRef, dict string, stream []byte) error {
+		offsets[ref.id], gens[ref.id] = cw.n, ref.gen
+		if stream == nil {
+			_, err := fmt.Fprintf(cw, "%d %d obj\n%s\nendobj\n", ref.id, ref.gen, dict)
+			return err
+		}
+		if _, err := fmt.Fprintf(cw, "%d %d obj\n%s\nstream\n", ref.id, ref.gen, dict); err != nil {
+			return err
+		}
+		if _, err := cw.Write(stream); err != nil {
+			return err
+		}
+		_, err := io.WriteString(cw, "\nendstream\nendobj\n")
+		return err
+	}
+
+	footer := mark.FooterText()
+	token := mark.token()
+	rewritten := map[pdfRef]bool{}
+	var pages []pdfPage
+	collectPDFPages(trailer.Key("Root").Key("Pages"), pdfPage{}, 0, &pages)
+	for _, page := range pages {
+		streams, ok := page.contents(rewritten)
+		if !ok {
+			continue
+		}
+		for i, s := range streams {
+			var data bytes.Buffer
+			if i == 0 {
+				data.WriteString("q\n")
+			}
+			data.Write(s.data)
+			if i == len(streams)-1 {
+				data.WriteString("\nQ\n")
+				data.WriteString(pdfFooterOps(footer, token, page))
+			}
+			packed, err := flate(data.Bytes())
+			if err != nil {
+				return err
+			}
+			dict := fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>", len(packed))
+			if err := writeObject(s.ref, dict, packed); err != nil {
+				return err
+			}
+			rewritten[s.ref] = true
+		}
+	}
+
+	infoRef := pdfRef{id: nextID}
+	nextID++
+	if err := writeObject(infoRef, pdfInfoDict(trailer.Key("Info"), mark), nil); err != nil {
+		return err
+	}
+
+	entries := fmt.Sprintf("/Root %s %s R /Info %s /Prev %d", rootMatch[1], rootMatch[2], infoRef, prev)
+	if id := trailer.Key("ID"); id.Kind() == pdf.Array && id.Len() == 2 {
+		entries += fmt.Sprintf(" /ID [<%x> <%x>]", id.Index(0).RawString(), id.Index(1).RawString())
+	}
+
+	ids := make([]int, 0, len(offsets))
+	for id := range offsets {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	if classic {
+		xref := cw.n
+		var buf bytes.Buffer
+		buf.WriteString("xref\n")
+		for i := 0; i < len(ids); {
+			j := i
+			for j+1 < len(ids) && ids[j+1] == ids[j]+1 {
+				j++
+			}
+			fmt.Fprintf(&buf, "%d %d\n", ids[i], j-i+1)
+			for k := i; k <= j; k++ {
+				fmt.Fprintf(&buf, "%010d %05d n \n", offsets[ids[k]], gens[ids[k]])
+			}
+			i = j + 1
+		}
+		fmt.Fprintf(&buf, "trailer\n<< /Size %d %s >>\nstartxref\n%d\n%%%%EOF\n", nextID, entries, xref)
+		_, err := cw.Write(buf.Bytes())
+		return err
+	}
+
+	// A file with cross-reference streams gets one too: readers do not
+	// follow /Prev from a stream to a classic table
+	xrefRef := pdfRef{id: nextID}
+	nextID++
+	xref := cw.n
+	offsets[xrefRef.id] = xref
+	ids = append(ids, xrefRef.id)
+	var rows bytes.Buffer
+	var index strings.Builder
+	for _, id := range ids {
+		off, gen := offsets[id], gens[id]
+		rows.Write([]byte{1, byte(off >> 24), byte(off >> 16), byte(off >> 8), byte(off), byte(gen >> 8), byte(gen)})
+		fmt.Fprintf(&index, " %d 1", id)
+	}
+	dict := fmt.Sprintf("<< /Type /XRef /Size %d /W [1 4 2] /Index [%s] /Length %d %s >>",
+		nextID, strings.TrimSpace(index.String()), rows.Len(), entries)
+	if err := writeObject(xrefRef, dict, rows.Bytes()); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(cw, "startxref\n%d\n%%%%EOF\n", xref)
+	return err
+}
+
+// pdfPage is a leaf of the page tree with its inherited geometry
+type pdfPage struct {
+	value  pdf.Value
+	box    [4]float64
+	rotate int
+}
+
+type pdfContentStream struct {
+	ref  pdfRef
+	data []byte
+}
+
+func collectPDFPages(node pdf.Value, inherited pdfPage, depth int, pages *[]pdfPage) {
+	if depth > pdfMaxTreeDepth || node.Kind() != pdf.Dict {
+		return
+	}
+	page := inherited
+	for _, key := range []string{"MediaBox", "CropBox"} {
+		if b := node.Key(key); b.Kind() == pdf.Array && b.Len() == 4 {
+			for i := range page.box {
+				page.box[i] = b.Index(i).Float64()
+			}
+		}
+	}
+	if rot := node.Key("Rotate"); rot.Kind() == pdf.Integer {
+		page.rotate = ((int(rot.Int64()) % 360) + 360) % 360
+	}
+	if node.Key("Type").Name() != "Pages" {
+		page.value = node
+		*pages = append(*pages, page)
+		return
+	}
+	kids := node.Key("Kids")
+	for i := 0; i < kids.Len(); i++ {
+		collectPDFPages(kids.Index(i), page, depth+1, pages)
+	}
+}
+
+// contents decodes the page's content streams. Pages whose streams are
+// shared with an already marked page, or use filters the parser cannot
+// decode, are left as they are.
+func (p pdfPage) contents(done map[pdfRef]bool) (streams []pdfContentStream, ok bool) {
+	defer func() {
+		if recover() != nil {
+			streams, ok = nil, false
+		}
+	}()
+
+	m := pdfContentsRe.FindStringSubmatch(p.value.String())
+	if m == nil {
+		return nil, false
+	}
+	var refs []pdfRef
+	for _, r := range pdfRefRe.FindAllStringSubmatch(m[1], -1) {
+		id, _ := strconv.Atoi(r[1])
+		gen, _ := strconv.Atoi(r[2])
+		ref := pdfRef{id: id, gen: gen}
+		if done[ref] {
+			return nil, false
+		}
+		refs = append(refs, ref)
+	}
+
+	values := []pdf.Value{p.value.Key("Contents")}
+	if values[0].Kind() == pdf.Array {
+		values = values[:0]
+		for i := 0; i < p.value.Key("Contents").Len(); i++ {
+			values = append(values, p.value.Key("Contents").Index(i))
+		}
+	}
+	if len(values) != len(refs) || len(refs) == 0 {
+		return nil, false
+	}
+	for i, v := range values {
+		if v.Kind() != pdf.Stream {
+			return nil, false
+		}
+		rc := v.Reader()
+		data, err := io.ReadAll(io.LimitReader(rc, pdfMaxContentSize+1))
+		_ = rc.Close()
+		if err != nil || len(data) > pdfMaxContentSize {
+			return nil, false
+		}
+		streams = append(streams, pdfContentStream{ref: refs[i], data: data})
+	}
+	return streams, true
+}
+
+// pdfFooterOps draws text at the bottom of the visible page, scaled down
+// to fit its width. The token goes into a comment next to it, so the mark
+// survives even if the Info dictionary is stripped.
+func pdfFooterOps(text, token string, page pdfPage) string {
+	llx, lly, urx, ury := page.box[0], page.box[1], page.box[2], page.box[3]
+	if urx-llx <= 0 || ury-lly <= 0 {
+		llx, lly, urx, ury = 0, 0, 612, 792
+	}
+	width := urx - llx
+	var cm string
+	switch page.rotate {
+	case 90:
+		width = ury - lly
+		cm = fmt.Sprintf("0 1 -1 0 %s %s", pdfNum(urx), pdfNum(lly))
+	case 180:
+		cm = fmt.Sprintf("-1 0 0 -1 %s %s", pdfNum(urx), pdfNum(ury))
+	case 270:
+		width = ury - lly
+		cm = fmt.Sprintf("0 -1 1 0 %s %s", pdfNum(llx), pdfNum(ury))
+	default:
+		cm = fmt.Sprintf("1 0 0 1 %s %s", pdfNum(llx), pdfNum(lly))
+	}
+
+	path, advance := outlineText(text)
+	scale := 1.0
+	if limit := width - 2*pdfFooterMargin; advance > limit && limit > 0 {
+		scale = limit / advance
+	}
+	x := (width - advance*scale) / 2
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%%%s\nq\n%s cm\n%s 0 0 %s %s %d cm\n0.5 g\n%s\nQ\n",
+		token, cm, pdfNum(scale), pdfNum(scale), pdfNum(x), pdfFooterMargin, path)
+	return b.String()
+}
+
+var (
+	footerFontOnce sync.Once
+	footerFont     *sfnt.Font
+)
+
+// outlineText converts text set in Go Regular to PDF path operators in
+// points, with the baseline at y=0. It returns the path and its width.
+func outlineText(text string) (string, float64) {
+	footerFontOnce.Do(func() {
+		footerFont, _ = sfnt.Parse(goregular.TTF)
+	})
+	if footerFont == nil {
+		return "", 0
+	}
+	var buf sfnt.Buffer
+	ppem := fixed.I(pdfFooterSize)
+	const unit = 64.0
+
+	var b strings.Builder
+	var pen fixed.Int26_6
+	for _, r := range text {
+		idx, err := footerFont.GlyphIndex(&buf, r)
+		if err != nil || idx == 0 {
+			idx, _ = footerFont.GlyphIndex(&buf, '?')
+		}
+		segs, err := footerFont.LoadGlyph(&buf, idx, ppem, nil)
+		if err != nil {
+			continue
+		}
+		pt := func(p fixed.Point26_6) (float64, float64) {
+			return float64(p.X+pen) / unit, -float64(p.Y) / unit
+		}
+		var cx, cy float64
+		for i, s := range segs {
+			switch s.Op {
+			case sfnt.SegmentOpMoveTo:
+				if i > 0 {
+					b.WriteString("h\n")
+				}
+				cx, cy = pt(s.Args[0])
+				fmt.Fprintf(&b, "%s %s m\n", pdfNum(cx), pdfNum(cy))
+			case sfnt.SegmentOpLineTo:
+				cx, cy = pt(s.Args[0])
+				fmt.Fprintf(&b, "%s %s l\n", pdfNum(cx), pdfNum(cy))
+			case sfnt.SegmentOpQuadTo:
+				qx, qy := pt(s.Args[0])
+				x, y := pt(s.Args[1])
+				fmt.Fprintf(&b, "%s %s %s %s %s %s c\n",
+					pdfNum(cx+2*(qx-cx)/3), pdfNum(cy+2*(qy-cy)/3),
+					pdfNum(x+2*(qx-x)/3), pdfNum(y+2*(qy-y)/3), pdfNum(x), pdfNum(y))
+				cx, cy = x, y
+			case sfnt.SegmentOpCubeTo:
+				x1, y1 := pt(s.Args[0])
+				x2, y2 := pt(s.Args[1])
+				cx, cy = pt(s.Args[2])
+				fmt.Fprintf(&b, "%s %s %s %s %s %s c\n",
+					pdfNum(x1), pdfNum(y1), pdfNum(x2), pdfNum(y2), pdfNum(cx), pdfNum(cy))
+			}
+		}
+		if len(segs) > 0 {
+			b.WriteString("h\nf\n")
+		}
+		adv, err := footerFont.GlyphAdvance(&buf, idx, ppem, font.HintingNone)
+		if err == nil {
+			pen += adv
+		}
+	}
+	return b.String(), float64(pen) / unit
+}
+
+// pdfInfoDict keeps the string entries of the original Info dictionary
+// and adds the watermark
+func pdfInfoDict(info pdf.Value, mark Watermark) string {
+	var b strings.Builder
+	b.WriteString("<<")
+	if info.Kind() == pdf.Dict {
+		for _, key := range info.Keys() {
+			if strings.HasPrefix(key, "Afst") {
+				continue
+			}
+			if v := info.Key(key); v.Kind() == pdf.String {
+				fmt.Fprintf(&b, " /%s <%s>", key, hex.EncodeToString([]byte(v.RawString())))
+			}
+		}
+	}
+	for _, kv := range [][2]string{
+		{"AfstWatermark", mark.token()},
+		{"AfstUserID", mark.UserID.String()},
+		{"AfstEmailHash", mark.EmailHash},
+		{"AfstIssuedAt", pdfDate(mark)},
+	} {
+		fmt.Fprintf(&b, " /%s (%s)", kv[0], kv[1])
+	}
+	b.WriteString(" >>")
+	return b.String()
+}
+
+func pdfDate(mark Watermark) string {
+	return mark.IssuedAt.UTC().Format("D:20060102150405Z")
+}
+
+// readPDFWatermark looks in the Info dictionary, then in page contents
+func readPDFWatermark(src io.ReaderAt, size int64) (mark *Watermark, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			mark, err = nil, fmt.Errorf("malformed pdf: %v", r)
+		}
+	}()
+
+	r, err := pdf.NewReader(src, size)
+	if err != nil {
+		return nil, err
+	}
+	trailer := r.Trailer()
+	if m, ok := findWatermarkToken([]byte(trailer.Key("Info").Key("AfstWatermark").RawString())); ok {
+		return m, nil
+	}
+	var pages []pdfPage
+	collectPDFPages(trailer.Key("Root").Key("Pages"), pdfPage{}, 0, &pages)
+	for _, page := range pages {
+		streams, _ := page.contents(nil)
+		for _, s := range streams {
+			if m, ok := findWatermarkToken(s.data); ok {
+				return m, nil
+			}
+		}
+	}
+	return nil, ErrWatermarkNotFound
+}
+
+func flate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pdfNum formats a number without exponent, as PDF requires
+func pdfNum(f float64) string {
+	s := strconv.FormatFloat(f, 'f', 2, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" || s == "" {
+		return "0"
+	}
+	return s
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,173 @@
+package worker
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ledongthuc/pdf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testWatermark() Watermark {
+	return Watermark{
+		ID:        uuid.New(),
+		UserID:    uuid.New(),
+		EmailHash: EmailHash(" Reader@Example.com "),
+		IssuedAt:  time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC),
+	}
+}
+
+func TestWatermarkPDF(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compressed=%v", compressed), func(t *testing.T) {
+			objs := testPDFObjects("Война и мир")
+			objs[4] = pdfObject{body: "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Rotate 90 /Contents 13 0 R >>"}
+			original := buildTestPDF(objs, "/Root 1 0 R /Info 16 0 R", compressed)
+			mark := testWatermark()
+
+			var out bytes.Buffer
+			require.NoError(t, WatermarkFile("pdf", bytes.NewReader(original), int64(len(original)), mark, &out))
+			marked := out.Bytes()
+			assert.True(t, bytes.HasPrefix(marked, original), "the update is appended to the original revision")
+
+			meta, err := parsePDF(writeTestPDF(t, marked))
+			require.NoError(t, err)
+			assert.Equal(t, 3, meta.PageCount)
+			assert.Equal(t, "Война и мир", meta.Title, "original Info entries are kept")
+			assert.Len(t, meta.Outline, 2)
+
+			r, err := pdf.NewReader(bytes.NewReader(marked), int64(len(marked)))
+			require.NoError(t, err)
+			info := r.Trailer().Key("Info")
+			assert.Equal(t, mark.UserID.String(), info.Key("AfstUserID").RawString())
+			assert.Equal(t, mark.EmailHash, info.Key("AfstEmailHash").RawString())
+
+			page := r.Page(3)
+			rc := page.V.Key("Contents").Reader()
+			content, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			_ = rc.Close()
+			assert.True(t, strings.HasPrefix(string(content), "q\nBT ET %3\nQ\n"), "original content is isolated in q/Q")
+			assert.Contains(t, string(content), "%"+mark.token())
+			assert.Contains(t, string(content), "0 1 -1 0 612 0 cm", "footer follows the page rotation")
+			assert.Contains(t, string(content), "h\nf\n", "footer is drawn as filled outlines")
+
+			got, err := ReadWatermark(bytes.NewReader(marked), int64(len(marked)))
+			require.NoError(t, err)
+			assert.Equal(t, mark, *got)
+		})
+	}
+}
+
+func TestReadWatermark_PDFContentFallback(t *testing.T) {
+	objs := testPDFObjects("Title")
+	original := buildTestPDF(objs, "/Root 1 0 R", false)
+	mark := testWatermark()
+
+	var out bytes.Buffer
+	require.NoError(t, WatermarkFile("pdf", bytes.NewReader(original), int64(len(original)), mark, &out))
+
+	// Someone stripped the Info dictionary but kept the page content
+	r, err := pdf.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.NoError(t, err)
+	rc := r.Page(2).V.Key("Contents").Reader()
+	content, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	_ = rc.Close()
+	objs[15] = pdfObject{body: fmt.Sprintf("<< /Length %d >>", len(content)), stream: string(content)}
+	stripped := buildTestPDF(objs, "/Root 1 0 R", false)
+
+	got, err := ReadWatermark(bytes.NewReader(stripped), int64(len(stripped)))
+	require.NoError(t, err)
+	assert.Equal(t, mark.UserID, got.UserID)
+
+	_, err = ReadWatermark(bytes.NewReader(original), int64(len(original)))
+	assert.ErrorIs(t, err, ErrWatermarkNotFound)
+}
+
+func TestWatermarkPDF_Encrypted(t *testing.T) {
+	objs := testPDFObjects("Title")
+	objs[17] = pdfObject{body: "<< /Filter /Standard /V 1 /R 2 /O <00> /U <00> /P -4 >>"}
+	original := buildTestPDF(objs, "/Root 1 0 R /Encrypt 17 0 R /ID [<01> <01>]", false)
+	err := WatermarkFile("pdf", bytes.NewReader(original), int64(len(original)), testWatermark(), io.Discard)
+	assert.ErrorIs(t, err, ErrWatermarkUnsupported)
+}
+
+func TestWatermarkEPUB(t *testing.T) {
+	p := writeTestEPUB(t, map[string]string{
+		"META-INF/container.xml": testContainerXML,
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<opf:package xmlns:opf="http://www.idpf.org/2007/opf" version="2.0">
+  <opf:metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Мастер и Маргарита</dc:title>
+  </opf:metadata>
+  <opf:manifest>
+    <opf:item id="ch1" href="text/ch1.xhtml" media-type="application/xhtml+xml"/>
+  </opf:manifest>
+  <opf:spine>
+    <opf:itemref idref="ch1"/>
+  </opf:spine>
+</opf:package>`,
+		"OEBPS/text/ch1.xhtml": "<html/>",
+	})
+	original, err := os.ReadFile(p)
+	require.NoError(t, err)
+	mark := testWatermark()
+
+	var out bytes.Buffer
+	require.NoError(t, WatermarkFile("epub", bytes.NewReader(original), int64(len(original)), mark, &out))
+
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.NoError(t, err)
+	assert.Equal(t, "mimetype", zr.File[0].Name)
+	assert.Equal(t, zip.Store, zr.File[0].Method)
+
+	marked := filepath.Join(t.TempDir(), "marked.epub")
+	require.NoError(t, os.WriteFile(marked, out.Bytes(), 0o600))
+	meta, err := parseEPUB(marked)
+	require.NoError(t, err)
+	assert.Equal(t, "Мастер и Маргарита", meta.Title)
+	require.Len(t, meta.Spine, 2)
+	assert.Equal(t, "OEBPS/afst-watermark.xhtml", meta.Spine[1].Href)
+
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	colophon, err := readEPUBEntry(files, "OEBPS/afst-watermark.xhtml")
+	require.NoError(t, err)
+	assert.Contains(t, string(colophon), mark.UserID.String())
+	assert.Contains(t, string(colophon), mark.EmailHash)
+	opf, err := readEPUBEntry(files, "OEBPS/content.opf")
+	require.NoError(t, err)
+	assert.Contains(t, string(opf), `<opf:meta name="afst:watermark"`)
+
+	got, err := ReadWatermark(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.NoError(t, err)
+	assert.Equal(t, mark, *got)
+
+	_, err = ReadWatermark(bytes.NewReader(original), int64(len(original)))
+	assert.ErrorIs(t, err, ErrWatermarkNotFound)
+}
+
+func TestWatermarkFile_Unsupported(t *testing.T) {
+	err := WatermarkFile("mobi", bytes.NewReader(nil), 0, testWatermark(), io.Discard)
+	assert.ErrorIs(t, err, ErrWatermarkUnsupported)
+
+	_, err = ReadWatermark(bytes.NewReader([]byte("BOOKMOBI")), 8)
+	assert.ErrorIs(t, err, ErrWatermarkUnsupported)
+}
+
+func TestEmailHash_Normalizes(t *testing.T) {
+	assert.Equal(t, EmailHash("reader@example.com"), EmailHash(" Reader@Example.COM"))
+	assert.Len(t, EmailHash("reader@example.com"), 64)
+}
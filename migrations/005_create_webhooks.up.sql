@@ -0,0 +1,42 @@
+-- Исходящие вебхуки: подписки внешних систем на события библиотеки
+
+CREATE TABLE webhooks (
+    id                   TEXT PRIMARY KEY,
+    user_id              TEXT NOT NULL REFERENCES users(id),
+    api_key_id           TEXT REFERENCES api_keys(id),   -- NULL, если создан через /api/v1
+    url                  TEXT NOT NULL,
+    events               TEXT NOT NULL,                  -- JSON-массив типов событий, "*" — все
+    description          TEXT,
+    secret               TEXT NOT NULL,                  -- ключ HMAC-SHA256 ("whsec_...")
+    is_active            BOOLEAN NOT NULL DEFAULT true,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    disabled_at          TIMESTAMP WITH TIME ZONE,
+    last_delivery_at     TIMESTAMP WITH TIME ZONE,
+    created_at           TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at           TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_webhooks_user_id    ON webhooks(user_id);
+CREATE INDEX idx_webhooks_api_key_id ON webhooks(api_key_id);
+CREATE INDEX idx_webhooks_active     ON webhooks(is_active);
+
+-- Журнал доставок
+CREATE TABLE webhook_deliveries (
+    id              TEXT PRIMARY KEY,
+    webhook_id      TEXT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_id        TEXT NOT NULL,
+    event_type      TEXT NOT NULL,
+    payload         TEXT NOT NULL,
+    status          TEXT NOT NULL DEFAULT 'pending',   -- pending | succeeded | failed
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    response_status INTEGER,
+    response_body   TEXT,
+    error           TEXT,
+    duration_ms     BIGINT NOT NULL DEFAULT 0,
+    redelivery_of   TEXT,
+    created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    delivered_at    TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, created_at);
+CREATE INDEX idx_webhook_deliveries_event   ON webhook_deliveries(event_id);
@@ -0,0 +1,13 @@
+-- Транзакционный outbox: события шины пишутся в одной транзакции с изменением данных
+
+CREATE TABLE event_outbox (
+    id           TEXT PRIMARY KEY,              -- ID события, сохраняется при повторной публикации
+    type         TEXT NOT NULL,
+    user_id      TEXT,
+    payload      TEXT NOT NULL,                 -- JSON
+    created_at   TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    published_at TIMESTAMP WITH TIME ZONE       -- NULL — ещё не отправлено релеем
+);
+
+CREATE INDEX idx_event_outbox_pending   ON event_outbox(created_at) WHERE published_at IS NULL;
+CREATE INDEX idx_event_outbox_published ON event_outbox(published_at);
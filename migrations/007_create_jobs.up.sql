@@ -0,0 +1,22 @@
+-- Персистентная очередь фоновых задач с арендой (lease) и dead-letter
+
+CREATE TABLE jobs (
+    id           TEXT PRIMARY KEY,
+    queue        TEXT NOT NULL,
+    kind         TEXT NOT NULL,
+    payload      TEXT NOT NULL,                 -- JSON
+    status       TEXT NOT NULL DEFAULT 'pending', -- pending, running, succeeded, failed, dead, cancelled
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 5,
+    run_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(), -- не раньше этого времени (backoff)
+    lease_owner  TEXT,                          -- воркер, взявший задачу
+    lease_until  TIMESTAMP WITH TIME ZONE,      -- после истечения задачу подхватит другой воркер
+    last_error   TEXT,
+    started_at   TIMESTAMP WITH TIME ZONE,
+    finished_at  TIMESTAMP WITH TIME ZONE,
+    created_at   TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at   TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_jobs_claim       ON jobs(queue, status, run_at);
+CREATE INDEX idx_jobs_finished_at ON jobs(finished_at);
@@ -0,0 +1,34 @@
+-- Планировщик периодических задач: общее состояние для всех инстансов и история запусков
+
+CREATE TABLE scheduled_tasks (
+    name             TEXT PRIMARY KEY,
+    schedule         TEXT NOT NULL,                  -- cron-выражение или @daily / @every 1h
+    next_run_at      TIMESTAMP WITH TIME ZONE NOT NULL,
+    run_requested    BOOLEAN NOT NULL DEFAULT FALSE, -- ручной запуск из админки
+    lease_owner      TEXT,                           -- инстанс, выполняющий задачу
+    lease_until      TIMESTAMP WITH TIME ZONE,
+    last_run_at      TIMESTAMP WITH TIME ZONE,
+    last_status      TEXT,
+    last_error       TEXT,
+    last_duration_ms BIGINT NOT NULL DEFAULT 0,
+    run_count        BIGINT NOT NULL DEFAULT 0,
+    fail_count       BIGINT NOT NULL DEFAULT 0,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_scheduled_tasks_next_run_at ON scheduled_tasks(next_run_at);
+
+CREATE TABLE scheduled_task_runs (
+    id          TEXT PRIMARY KEY,
+    task_name   TEXT NOT NULL,
+    owner       TEXT NOT NULL,
+    manual      BOOLEAN NOT NULL DEFAULT FALSE,
+    status      TEXT NOT NULL,                       -- running, succeeded, failed
+    error       TEXT,
+    started_at  TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    duration_ms BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_scheduled_runs_task ON scheduled_task_runs(task_name, started_at);
@@ -0,0 +1,15 @@
+-- Обложки книг: оригинал и признак готовности миниатюр (small/medium/large)
+
+CREATE TABLE book_covers (
+    book_id          UUID PRIMARY KEY REFERENCES books(id) ON DELETE CASCADE,
+    source           TEXT NOT NULL,                 -- epub, pdf, upload
+    content_type     TEXT NOT NULL,
+    hash             TEXT NOT NULL,                 -- sha256 оригинала, из него строятся пути и ETag
+    width            INTEGER NOT NULL DEFAULT 0,
+    height           INTEGER NOT NULL DEFAULT 0,
+    file_size        BIGINT NOT NULL DEFAULT 0,
+    file_path        TEXT NOT NULL,
+    thumbnails_ready BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS book_text_fts;
+DROP TABLE IF EXISTS book_text_indexes;
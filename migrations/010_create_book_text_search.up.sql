@@ -0,0 +1,30 @@
+-- Полнотекстовый поиск по содержимому книг.
+-- В SQLite фрагменты лежат в виртуальной таблице FTS5 (repository.MigrateFullText),
+-- здесь — эквивалент для PostgreSQL на tsvector.
+
+CREATE TABLE book_text_indexes (
+    file_id     UUID PRIMARY KEY,
+    book_id     UUID NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    format      TEXT NOT NULL,                     -- pdf, epub
+    chunk_count INTEGER NOT NULL DEFAULT 0,        -- страниц PDF или документов spine EPUB с текстом
+    char_count  BIGINT NOT NULL DEFAULT 0,
+    indexed_at  TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_book_text_indexes_book_id ON book_text_indexes(book_id);
+
+CREATE TABLE book_text_fts (
+    id      BIGSERIAL PRIMARY KEY,
+    content TEXT NOT NULL,
+    book_id UUID NOT NULL,
+    file_id UUID NOT NULL REFERENCES book_text_indexes(file_id) ON DELETE CASCADE,
+    ordinal INTEGER NOT NULL,
+    page    INTEGER,                               -- номер страницы PDF
+    href    TEXT,                                  -- документ EPUB внутри архива
+    cfi     TEXT,                                  -- EPUB CFI документа в spine
+    tsv     tsvector GENERATED ALWAYS AS (to_tsvector('simple', content)) STORED
+);
+
+CREATE INDEX idx_book_text_fts_tsv ON book_text_fts USING GIN(tsv);
+CREATE INDEX idx_book_text_fts_book ON book_text_fts(book_id);
+CREATE INDEX idx_book_text_fts_file ON book_text_fts(file_id);
@@ -0,0 +1,22 @@
+-- Возобновляемые загрузки файлов книг (протокол tus).
+-- Принятые фрагменты лежат на диске (UPLOAD_PARTIAL_PATH), здесь — состояние загрузки.
+
+CREATE TABLE uploads (
+    id            UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id       UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    book_id       UUID NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    file_name     TEXT NOT NULL,
+    size          BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,               -- сколько байт уже принято
+    metadata      TEXT,                                    -- исходный заголовок Upload-Metadata
+    status        TEXT NOT NULL DEFAULT 'active',          -- active, completed, failed
+    book_file_id  UUID,                                    -- созданный файл книги
+    error         TEXT,
+    expires_at    TIMESTAMP WITH TIME ZONE NOT NULL,       -- продлевается каждым фрагментом
+    created_at    TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at    TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_uploads_user_id ON uploads(user_id);
+CREATE INDEX idx_uploads_status ON uploads(status);
+CREATE INDEX idx_uploads_expires_at ON uploads(expires_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_book_files_file_path;
+DROP TABLE IF EXISTS blobs;
@@ -0,0 +1,22 @@
+-- Файлы книг в хранилище адресуются SHA-256 содержимого (blobs/<xx>/<hash>)
+-- и разделяются между записями book_files. ref_count — число ссылающихся
+-- записей; -1 — файл удаляет сборщик мусора.
+
+CREATE TABLE blobs (
+    file_path  TEXT PRIMARY KEY,                          -- путь в хранилище
+    hash       TEXT NOT NULL,                             -- SHA-256 содержимого
+    size       BIGINT NOT NULL,
+    ref_count  INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()     -- последнее изменение ссылок
+);
+
+CREATE INDEX idx_blobs_hash ON blobs(hash);
+CREATE INDEX idx_blobs_ref_count ON blobs(ref_count);
+CREATE INDEX idx_book_files_file_path ON book_files(file_path);
+
+-- Файлы, загруженные до учёта ссылок
+INSERT INTO blobs (file_path, hash, size, ref_count)
+SELECT file_path, MIN(hash), MAX(file_size), COUNT(*)
+FROM book_files
+GROUP BY file_path;
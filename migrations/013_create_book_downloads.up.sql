@@ -0,0 +1,15 @@
+-- Учёт скачиваний файлов книг для квот тарифа и группы.
+-- Одна запись на подписанную ссылку: докачка по той же ссылке не учитывается повторно.
+
+CREATE TABLE book_downloads (
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    book_id    UUID NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    file_id    UUID NOT NULL,
+    link_id    UUID NOT NULL,                             -- идентификатор подписанной ссылки
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_book_downloads_link_id ON book_downloads(link_id);
+CREATE INDEX idx_book_downloads_user_created ON book_downloads(user_id, created_at);
+CREATE INDEX idx_book_downloads_book_id ON book_downloads(book_id);
@@ -0,0 +1,15 @@
+-- Помеченные копии файлов, выданные при скачивании (социальный DRM).
+-- Записи не удаляются вместе с пользователем: по ним опознаётся аккаунт утёкшего файла.
+
+CREATE TABLE file_watermarks (
+    id               UUID PRIMARY KEY DEFAULT gen_random_uuid(), -- идентификатор в водяном знаке
+    user_id          UUID NOT NULL,
+    file_id          UUID NOT NULL,
+    email_hash       TEXT NOT NULL,                              -- SHA-256 e-mail на момент выдачи
+    cache_path       TEXT,                                       -- копия в хранилище, NULL — не кэширована
+    cache_expires_at TIMESTAMP WITH TIME ZONE,
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_file_watermarks_user_file ON file_watermarks(user_id, file_id);
+CREATE INDEX idx_file_watermarks_cache_expires_at ON file_watermarks(cache_expires_at);
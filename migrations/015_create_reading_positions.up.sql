@@ -0,0 +1,21 @@
+-- Позиции чтения по устройствам пользователя для синхронизации между ними.
+-- На каждое устройство одна строка; актуальной считается позиция с самым поздним client_updated_at.
+
+CREATE TABLE reading_positions (
+    id                UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id           UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    book_id           UUID NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    device_id         TEXT NOT NULL,
+    device_name       TEXT,
+    locator_type      TEXT NOT NULL,                 -- page | cfi | percent
+    locator_file_id   UUID,                          -- файл, к страницам которого относится позиция
+    locator_page      INTEGER,
+    locator_cfi       TEXT,                          -- EPUB CFI
+    locator_percent   DOUBLE PRECISION,
+    progress          REAL DEFAULT 0,                -- прогресс в процентах, считается сервером
+    client_updated_at TIMESTAMP WITH TIME ZONE NOT NULL, -- время изменения на устройстве
+    created_at        TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at        TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_reading_positions_device ON reading_positions(user_id, book_id, device_id);
@@ -0,0 +1,26 @@
+-- Выделения и заметки пользователей в книгах.
+-- Место хранится так же, как у позиций чтения: текстовая форма location и разобранный локатор.
+
+CREATE TABLE annotations (
+    id               UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id          UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    book_id          UUID NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    type             TEXT NOT NULL DEFAULT 'highlight',  -- highlight | note
+    location         TEXT NOT NULL,
+    locator_type     TEXT,                               -- page | cfi | percent, NULL — произвольная строка
+    locator_file_id  UUID,
+    locator_page     INTEGER,
+    locator_cfi      TEXT,
+    locator_percent  DOUBLE PRECISION,
+    highlighted_text TEXT,
+    note             TEXT,
+    color            TEXT,
+    tags             TEXT,                               -- JSON-массив тегов
+    visibility       TEXT NOT NULL DEFAULT 'private',    -- private | followers | public
+    created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_annotations_user_id ON annotations(user_id);
+CREATE INDEX idx_annotations_book_id ON annotations(book_id);
+CREATE INDEX idx_annotations_visibility ON annotations(visibility);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS challenge_participants;
+DROP TABLE IF EXISTS reading_challenges;
+DROP TABLE IF EXISTS reading_goals;
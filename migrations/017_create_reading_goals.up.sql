@@ -0,0 +1,47 @@
+-- Личные цели чтения, челленджи клуба и их участники.
+-- reached_period и warned_period — начало периода (YYYY-MM-DD), за который уже
+-- отправлены события goal.reached и streak.at_risk.
+
+CREATE TABLE reading_goals (
+    id             UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id        UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    metric         TEXT NOT NULL,                  -- books | minutes | pages
+    period         TEXT NOT NULL,                  -- day | week | year
+    target         INTEGER NOT NULL,
+    timezone       TEXT NOT NULL DEFAULT 'UTC',
+    is_active      BOOLEAN DEFAULT TRUE,
+    reached_period TEXT,
+    warned_period  TEXT,
+    created_at     TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at     TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_reading_goals_user_id ON reading_goals(user_id);
+CREATE INDEX idx_reading_goals_is_active ON reading_goals(is_active);
+
+CREATE TABLE reading_challenges (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    title       TEXT NOT NULL,
+    description TEXT,
+    metric      TEXT NOT NULL,                     -- books | minutes | pages
+    target      INTEGER NOT NULL,
+    start_date  TIMESTAMP WITH TIME ZONE NOT NULL,
+    end_date    TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_by  UUID NOT NULL REFERENCES users(id),
+    created_at  TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at  TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_reading_challenges_start_date ON reading_challenges(start_date);
+CREATE INDEX idx_reading_challenges_end_date ON reading_challenges(end_date);
+
+CREATE TABLE challenge_participants (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    challenge_id UUID NOT NULL REFERENCES reading_challenges(id) ON DELETE CASCADE,
+    user_id      UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    completed_at TIMESTAMP WITH TIME ZONE,          -- когда участник впервые набрал цель
+    joined_at    TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_challenge_participant ON challenge_participants(challenge_id, user_id);
+CREATE INDEX idx_challenge_participants_user_id ON challenge_participants(user_id);
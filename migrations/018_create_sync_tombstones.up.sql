@@ -0,0 +1,13 @@
+-- Следы удалённых закладок и аннотаций для синхронизации мобильных приложений:
+-- устройство, синхронизирующееся после удаления, узнаёт о нём отсюда.
+-- Записи старше 90 дней удаляет задача sync.tombstones_cleanup.
+
+CREATE TABLE sync_tombstones (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id     UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    entity_type TEXT NOT NULL,                     -- bookmark | annotation
+    entity_id   UUID NOT NULL,
+    deleted_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_sync_tombstones_user_deleted ON sync_tombstones(user_id, deleted_at);
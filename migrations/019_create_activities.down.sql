@@ -0,0 +1,3 @@
+ALTER TABLE IF EXISTS collections DROP COLUMN IF EXISTS is_public;
+DROP TABLE IF EXISTS activity_preferences;
+DROP TABLE IF EXISTS activities;
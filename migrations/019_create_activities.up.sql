@@ -0,0 +1,30 @@
+-- Лента активности: дочитанные книги, рецензии, открытые коллекции и челленджи.
+-- Одно событие на субъект (выдачу, рецензию, коллекцию, челлендж). Лента
+-- подписок собирается при чтении; виды событий, скрытые автором в
+-- activity_preferences, подписчикам не показываются.
+
+CREATE TABLE activities (
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type       TEXT NOT NULL,                     -- book_finished | review_written | collection_published | challenge_joined
+    subject_id UUID NOT NULL,
+    book_id    UUID REFERENCES books(id) ON DELETE CASCADE,
+    title      TEXT,                              -- название коллекции или челленджа
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_activities_subject ON activities(user_id, type, subject_id);
+CREATE INDEX idx_activities_user_created ON activities(user_id, created_at);
+CREATE INDEX idx_activities_book_id ON activities(book_id);
+
+-- Строка есть только для настроенных видов; по умолчанию события видны
+CREATE TABLE activity_preferences (
+    user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type       TEXT NOT NULL,
+    shared     BOOLEAN NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, type)
+);
+
+-- collections создаётся AutoMigrate, поэтому таблицы может ещё не быть
+ALTER TABLE IF EXISTS collections ADD COLUMN IF NOT EXISTS is_public BOOLEAN NOT NULL DEFAULT FALSE;
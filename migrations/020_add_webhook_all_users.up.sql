@@ -0,0 +1,4 @@
+-- Область доставки вебхука: по умолчанию только события владельца.
+-- all_users = true (ставит только администратор) — события всех пользователей.
+
+ALTER TABLE webhooks ADD COLUMN all_users BOOLEAN NOT NULL DEFAULT false;
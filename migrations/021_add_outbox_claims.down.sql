@@ -0,0 +1,2 @@
+ALTER TABLE event_outbox DROP COLUMN IF EXISTS claimed_until;
+ALTER TABLE event_outbox DROP COLUMN IF EXISTS claimed_by;
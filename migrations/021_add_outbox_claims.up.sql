@@ -0,0 +1,5 @@
+-- Аренда строк outbox: релей сначала помечает пачку (claimed_by, claimed_until),
+-- затем публикует её, поэтому несколько инстансов не публикуют одно событие дважды
+
+ALTER TABLE event_outbox ADD COLUMN claimed_by TEXT;
+ALTER TABLE event_outbox ADD COLUMN claimed_until TIMESTAMP WITH TIME ZONE;
@@ -87,6 +87,7 @@ func (suite *APITestSuite) migrateSQLite(db *gormdb.DB) error {
 		&models.Category{},
 		&models.Book{},
 		&models.BookFile{},
+		&models.Blob{},
 		&models.Subscription{},
 		&models.BookAccess{},
 		&models.ReadingSession{},